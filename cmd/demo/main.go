@@ -0,0 +1,114 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// demo runs the example scenarios of package demo, each over a relay-server
+// and a tunnel started in process, and reports which succeed:
+//
+//	demo [flags] [scenario ...]
+//
+// Without arguments every scenario runs. See pkg/demo for their code.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flymesh/core/pkg/demo"
+	"github.com/flymesh/core/pkg/logging"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	file := ""
+	outDir := ""
+	timeout := 30 * time.Second
+	logLevel := "warn"
+	list := false
+	flag.StringVar(&file, "file", file, "file the send scenario transfers (default: 4 MiB of random data)")
+	flag.StringVar(&outDir, "out-dir", outDir, "directory the send scenario stores the file in (default: a temporary directory)")
+	flag.DurationVar(&timeout, "timeout", timeout, "how long every scenario may take")
+	flag.StringVar(&logLevel, "log-level", logLevel, "debug | info | warn | error")
+	flag.BoolVar(&list, "list", list, "list the scenarios and exit")
+	flag.Parse()
+
+	if err := logging.Setup(logLevel, "text", logging.Redaction{}); err != nil {
+		logging.Fatal("bad log level", "err", err)
+	}
+	if outDir == "" {
+		dir, err := os.MkdirTemp("", "flymesh-demo-")
+		if err != nil {
+			logging.Fatal("create temporary directory failed", "err", err)
+		}
+		defer os.RemoveAll(dir)
+		outDir = dir
+	}
+
+	scenarios := demo.Scenarios(file, outDir)
+	if list {
+		for _, sc := range scenarios {
+			fmt.Printf("%-8s %s\n", sc.Name, sc.Description)
+		}
+		return 0
+	}
+	for _, name := range flag.Args() {
+		if !slices.ContainsFunc(scenarios, func(sc demo.Scenario) bool { return sc.Name == name }) {
+			logging.Fatal("unknown scenario", "scenario", name)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tRESULT\tTIME")
+	failed := 0
+	for _, sc := range scenarios {
+		if flag.NArg() > 0 && !slices.Contains(flag.Args(), sc.Name) {
+			continue
+		}
+		started := time.Now()
+		err := runScenario(ctx, sc, timeout)
+		verdict := "ok"
+		if err != nil {
+			verdict = "FAIL: " + err.Error()
+			failed++
+		}
+		slog.Info("scenario done", "scenario", sc.Name, "err", err)
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", sc.Name, verdict, time.Since(started).Round(10*time.Millisecond))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	_ = tw.Flush()
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runScenario runs sc over a Dev of its own.
+func runScenario(ctx context.Context, sc demo.Scenario, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	d, err := demo.StartDev(ctx)
+	if err != nil {
+		return fmt.Errorf("start dev relay: %w", err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			slog.Warn("stop dev relay failed", "err", err)
+		}
+	}()
+	return sc.Run(ctx, d)
+}
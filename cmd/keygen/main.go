@@ -0,0 +1,190 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// keygen manages the private key files of relay-server and tunnel, raw libp2p,
+// PEM or encrypted with a passphrase, and prints the peer IDs they derive
+// without starting a node:
+//
+//	keygen generate [-type ed25519|secp256k1] [-format raw|pem|encrypted] [-force] FILE
+//	keygen inspect FILE
+//	keygen convert [-format raw|pem|encrypted] [-force] IN OUT
+//	keygen verify FILE
+//
+// Every command takes -passphrase-file, which defaults to the
+// FLYMESH_KEY_PASSPHRASE environment variable, to read and write encrypted
+// files.
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const usage = `usage:
+  keygen generate [-type ed25519|secp256k1] [-format raw|pem|encrypted] [-force] FILE
+  keygen inspect FILE
+  keygen convert [-format raw|pem|encrypted] [-force] IN OUT
+  keygen verify FILE
+
+Every command takes -passphrase-file FILE (default: $FLYMESH_KEY_PASSPHRASE)
+for encrypted key files.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "generate":
+		err = generate(args[1:])
+	case "inspect":
+		err = inspect(args[1:])
+	case "convert":
+		err = convert(args[1:])
+	case "verify":
+		err = verify(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "keygen:", err)
+		return 1
+	}
+	return 0
+}
+
+// parse parses the flags of command, with -passphrase-file, checks that n
+// arguments remain and returns them with the passphrase.
+func parse(fs *flag.FlagSet, args []string, n int) ([]string, []byte, error) {
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase of encrypted keys (default: $"+keyfile.PassphraseEnv+")")
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, nil, fmt.Errorf("%s takes %d file arguments, got %d", fs.Name(), n, fs.NArg())
+	}
+	passphrase, err := keyfile.ReadPassphrase(*passphraseFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs.Args(), passphrase, nil
+}
+
+// write writes priv to path in format.
+func write(path string, priv crypto.PrivKey, format string, passphrase []byte, overwrite bool) error {
+	data, err := keyfile.Marshal(priv, format, passphrase)
+	if err != nil {
+		return err
+	}
+	return keyfile.Write(path, data, overwrite)
+}
+
+func generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	typ := fs.String("type", "ed25519", "key type: ed25519 | secp256k1")
+	format := fs.String("format", keyfile.FormatRaw, "file format: raw (libp2p protobuf) | pem | encrypted")
+	force := fs.Bool("force", false, "overwrite an existing file")
+	files, passphrase, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	priv, err := keyfile.Generate(*typ)
+	if err != nil {
+		return err
+	}
+	if err := write(files[0], priv, *format, passphrase, *force); err != nil {
+		return err
+	}
+	return printKey(priv, *format)
+}
+
+func inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	files, passphrase, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	priv, format, err := keyfile.Load(files[0], passphrase)
+	if err != nil {
+		return err
+	}
+	return printKey(priv, format)
+}
+
+func convert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	format := fs.String("format", keyfile.FormatPEM, "format of the converted file: raw | pem | encrypted")
+	force := fs.Bool("force", false, "overwrite an existing output file")
+	files, passphrase, err := parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	priv, _, err := keyfile.Load(files[0], passphrase)
+	if err != nil {
+		return err
+	}
+	if err := write(files[1], priv, *format, passphrase, *force); err != nil {
+		return err
+	}
+	return printKey(priv, *format)
+}
+
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	files, passphrase, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	priv, format, err := keyfile.Load(files[0], passphrase)
+	if err != nil {
+		return err
+	}
+	if err := keyfile.Verify(priv); err != nil {
+		return err
+	}
+	if err := printKey(priv, format); err != nil {
+		return err
+	}
+	if st, err := os.Stat(files[0]); err == nil && st.Mode().Perm()&0o077 != 0 {
+		fmt.Printf("warning:    %s is accessible by other users (mode %s)\n", files[0], st.Mode().Perm())
+	}
+	fmt.Println("status:     ok")
+	return nil
+}
+
+// printKey prints the type, format, peer ID, in both forms, and public key
+// of priv.
+func printKey(priv crypto.PrivKey, format string) error {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pub, err := crypto.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("type:       %s\n", priv.Type())
+	fmt.Printf("format:     %s\n", format)
+	fmt.Printf("peer id:    %s\n", id)
+	// The CID form survives case folding, e.g. of %h in ssh_config.
+	fmt.Printf("peer cid:   %s\n", peer.ToCid(id))
+	fmt.Printf("public key: %s\n", base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// nat-matrix runs the relay-server and tunnel binaries across simulated NATs
+// (see package nattest) and reports which connection path every combination
+// of NAT types ends up with. It must run as root on Linux with iproute2 and
+// iptables installed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/nattest"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == nattest.RoleArg {
+		if err := nattest.RunRole(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	h := nattest.New()
+	filter := ""
+	logLevel := "info"
+	flag.StringVar(&h.RelayServerBin, "relay-server", h.RelayServerBin, "relay-server binary under test")
+	flag.StringVar(&h.TunnelBin, "tunnel", h.TunnelBin, "tunnel binary under test")
+	flag.StringVar(&h.WorkDir, "work-dir", h.WorkDir, "directory receiving keys and logs of every scenario")
+	flag.DurationVar(&h.HolePunchWait, "holepunch-wait", h.HolePunchWait, "how long a relayed connection may take to become direct")
+	flag.StringVar(&filter, "run", filter, "only run scenarios whose name matches this regexp")
+	flag.StringVar(&logLevel, "log-level", logLevel, "debug | info | warn | error")
+	flag.Parse()
+
+	if err := logging.Setup(logLevel, "text", logging.Redaction{}); err != nil {
+		logging.Fatal("bad log level", "err", err)
+	}
+	match, err := regexp.Compile(filter)
+	if err != nil {
+		logging.Fatal("bad --run", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tWANT\tGOT\tRESULT\tTIME")
+	failed := 0
+	for _, sc := range nattest.Matrix() {
+		if !match.MatchString(sc.Name) {
+			continue
+		}
+		res := h.Run(ctx, sc)
+		verdict := "ok"
+		switch {
+		case res.Err != nil:
+			verdict = "error: " + res.Err.Error()
+		case !res.OK():
+			verdict = "FAIL, logs in " + res.LogDir
+		}
+		if !res.OK() {
+			failed++
+		}
+		slog.Info("scenario done", "scenario", sc.Name, "want", sc.Want, "got", res.Got, "ok", res.OK(), "err", res.Err)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", sc.Name, sc.Want, res.Got, verdict, res.Duration.Round(100*time.Millisecond))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	_ = tw.Flush()
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/flymesh/core/pkg/config"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+)
+
+// drainReportInterval is how often a draining relay-server logs the
+// allocations left, and the drain command prints them with --wait.
+const drainReportInterval = 5 * time.Second
+
+// drainStatus is the answer of /drain.
+type drainStatus struct {
+	Draining    bool `json:"draining"`
+	Allocations int  `json:"allocations"`
+	Bridges     int  `json:"bridges"`
+}
+
+// drainHandler serves /drain of the admin server: a POST calls start, and
+// both POST and GET answer with the allocations left.
+func drainHandler(rm *relay_manager.RelayManager, start func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			start()
+		case http.MethodGet:
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		allocations, bridges := rm.Load()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(drainStatus{Draining: rm.Draining(), Allocations: allocations, Bridges: bridges})
+	})
+}
+
+// drain has rm drain, then calls stop once no allocation is left, to shut
+// the relay-server down. It logs the allocations left meanwhile.
+func drain(ctx context.Context, rm *relay_manager.RelayManager, stop func()) {
+	rm.Drain()
+	go func() {
+		for {
+			waitCtx, cancel := context.WithTimeout(ctx, drainReportInterval)
+			err := rm.WaitDrained(waitCtx)
+			cancel()
+			if err == nil {
+				slog.Info("drained")
+				stop()
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			allocations, bridges := rm.Load()
+			slog.Info("draining", "allocations", allocations, "bridges", bridges)
+		}
+	}()
+}
+
+// drainCommand runs "relay-server drain": it has the relay-server whose admin
+// server listens on --admin-listen drain, and prints the allocations left,
+// until none is with --wait. It returns the exit code.
+func drainCommand(args []string) int {
+	cfg, err := config.LoadFromArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "drain:", err)
+		return 1
+	}
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	fs.String("config", "", "config file of the relay-server, to read its admin listen address from")
+	admin := fs.String("admin-listen", cfg.Listen.Admin, "admin listen address of the relay-server")
+	wait := fs.Bool("wait", false, "print the allocations left until none is, and the relay-server stops")
+	_ = fs.Parse(args)
+	if *admin == "" {
+		fmt.Fprintln(os.Stderr, "drain: no admin listen address: pass --admin-listen or --config")
+		return 2
+	}
+
+	url := "http://" + *admin + "/drain"
+	client := &http.Client{Timeout: 10 * time.Second}
+	st, err := requestDrain(client, http.MethodPost, url)
+	for {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "drain:", err)
+			return 1
+		}
+		fmt.Printf("draining: %d allocations left, %d bridged\n", st.Allocations, st.Bridges)
+		if !*wait || st.Allocations == 0 {
+			return 0
+		}
+		time.Sleep(drainReportInterval)
+		st, err = requestDrain(client, http.MethodGet, url)
+		// The relay-server stops once drained, possibly between two polls.
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			fmt.Println("drained")
+			return 0
+		}
+	}
+}
+
+// requestDrain sends a request of method to the /drain url of a relay-server.
+func requestDrain(client *http.Client, method string, url string) (*drainStatus, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var st drainStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+)
+
+func TestDrainHandler(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantCode   int
+		wantStart  bool
+		wantStatus drainStatus
+	}{
+		{method: http.MethodGet, wantCode: http.StatusOK},
+		{method: http.MethodPost, wantCode: http.StatusOK, wantStart: true, wantStatus: drainStatus{Draining: true}},
+		{method: http.MethodDelete, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rm := relay_manager.New()
+			started := false
+			h := drainHandler(rm, func() {
+				started = true
+				rm.Drain()
+			})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/drain", nil))
+			if rec.Code != tt.wantCode || started != tt.wantStart {
+				t.Fatalf("%s /drain: code %d, started %v, want %d, %v", tt.method, rec.Code, started, tt.wantCode, tt.wantStart)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got drainStatus
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got != tt.wantStatus {
+				t.Fatalf("%s /drain = %+v, err = %v, want %+v", tt.method, got, err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -5,48 +5,526 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/flymesh/core/p2p"
+	"github.com/flymesh/core/pkg/admin"
+	"github.com/flymesh/core/pkg/config"
+	"github.com/flymesh/core/pkg/dialback"
+	"github.com/flymesh/core/pkg/history"
+	"github.com/flymesh/core/pkg/identity"
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/mesh"
+	"github.com/flymesh/core/pkg/metrics/prom"
+	"github.com/flymesh/core/pkg/obfs"
+	"github.com/flymesh/core/pkg/policy"
+	"github.com/flymesh/core/pkg/protocol"
+	"github.com/flymesh/core/pkg/proxyproto"
+	relay_dns "github.com/flymesh/core/pkg/relay-dns"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
 	"github.com/flymesh/core/pkg/relay-server"
-	"github.com/flymesh/core/pkg/util"
+	"github.com/flymesh/core/pkg/service"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/flymesh/core/pkg/tracing"
 	"github.com/libp2p/go-libp2p"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
-	privKeyFile := flag.String("private-key", "", "path to private key file")
-	listenPort := flag.Int("listen-port", 0, "listen port")
-	relayListen := flag.String("relay-server-listen", ":24002", "relay-server TCP listen address")
+	if len(os.Args) > 1 && os.Args[1] == "drain" {
+		os.Exit(drainCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		if err := service.Command("flymesh-relay-server", "flymesh relay-server", os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "service:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(service.Run("flymesh-relay-server", run))
+}
+
+// run runs the command until parent is done or it is interrupted, and returns
+// its exit code.
+func run(parent context.Context) int {
+	cfg, err := config.LoadFromArgs(os.Args[1:])
+	if err != nil {
+		return fatal("load config failed", "err", err)
+	}
+	reloader := config.NewReloader(os.Args[1:], cfg)
+
+	flag.String("config", "", "path to a YAML or TOML config file; flags override its values")
+	flag.StringVar(&cfg.Identity.PrivateKeyFile, "private-key", cfg.Identity.PrivateKeyFile, "private key file path, keyring:[SERVICE/]ACCOUNT in the OS keychain, or env:VAR | exec:COMMAND holding the key as PEM or base64")
+	flag.StringVar(&cfg.Identity.PassphraseFile, "key-passphrase-file", cfg.Identity.PassphraseFile, "file holding the passphrase encrypting the private key file, which is encrypted in place if plaintext (default: $FLYMESH_KEY_PASSPHRASE)")
+	flag.IntVar(&cfg.Listen.Port, "listen-port", cfg.Listen.Port, "listen port")
+	config.StringsVar(&cfg.Bootstrap, "bootstrap", "DHT bootstrap peer multiaddr (repeatable, default: built-in list)")
+	flag.BoolVar(&cfg.NoBootstrap, "no-bootstrap", cfg.NoBootstrap, "do not bootstrap the DHT, for isolated networks")
+	config.StringsVar(&cfg.P2P.ListenAddrs, "listen-addr", "libp2p listen multiaddr, overrides --listen-port (repeatable)")
+	flag.Func("transports", "comma separated libp2p transports to enable: tcp,quic,ws (default: all)", func(v string) error {
+		cfg.P2P.Transports = strings.Split(v, ",")
+		return nil
+	})
+	flag.BoolVar(&cfg.P2P.IPv6Only, "ipv6-only", cfg.P2P.IPv6Only, "disable IPv4 entirely: listen, dial and announce IPv6 addresses only")
+	flag.StringVar(&cfg.P2P.Reachability, "reachability", cfg.P2P.Reachability, "auto | public | private")
+	flag.BoolVar(&cfg.P2P.AutoRelay, "autorelay", cfg.P2P.AutoRelay, "reserve circuit relay slots when not publicly reachable")
+	config.StringsVar(&cfg.P2P.StaticRelays, "static-relay", "circuit relay multiaddr, /dnsaddr multiaddr or hostname with _dnsaddr TXT records, to use instead of DHT discovered relays (repeatable)")
+	flag.StringVar(&cfg.P2P.DHTMode, "dht-mode", cfg.P2P.DHTMode, "client | server | auto")
+	flag.StringVar(&cfg.P2P.DHTProtocolPrefix, "dht-protocol-prefix", cfg.P2P.DHTProtocolPrefix, "DHT protocol prefix replacing /ipfs, for a private DHT")
+	flag.IntVar(&cfg.P2P.ConnLowWater, "conn-low-water", cfg.P2P.ConnLowWater, "trim connections down to this many once over --conn-high-water (0 keeps the libp2p default)")
+	flag.IntVar(&cfg.P2P.ConnHighWater, "conn-high-water", cfg.P2P.ConnHighWater, "trim connections once there are more than this many (0 keeps the libp2p default)")
+	flag.DurationVar(&cfg.P2P.ConnGracePeriod, "conn-grace-period", cfg.P2P.ConnGracePeriod, "how long new connections are protected from trimming (default: 1m)")
+	flag.IntVar(&cfg.P2P.MaxStreams, "libp2p-max-streams", cfg.P2P.MaxStreams, "maximum open libp2p streams (0 scales with the host)")
+	flag.IntVar(&cfg.P2P.MaxStreamsPerPeer, "libp2p-max-streams-per-peer", cfg.P2P.MaxStreamsPerPeer, "maximum open libp2p streams of a single peer (0 scales with the host)")
+	flag.Int64Var(&cfg.P2P.MaxMemoryMB, "libp2p-max-memory", cfg.P2P.MaxMemoryMB, "megabytes libp2p connections and streams may reserve (0 scales with the host)")
+	flag.IntVar(&cfg.P2P.MaxFDs, "libp2p-max-fds", cfg.P2P.MaxFDs, "file descriptors libp2p connections may use (0 scales with the host)")
+	flag.StringVar(&cfg.Mesh.ID, "mesh-id", cfg.Mesh.ID, "announce presence to, and track, the nodes sharing this mesh ID (disabled if empty)")
+	flag.DurationVar(&cfg.Mesh.AnnounceInterval, "mesh-announce-interval", cfg.Mesh.AnnounceInterval, "time between two mesh presence announcements")
+	flag.StringVar(&cfg.Mesh.DNSListen, "mesh-dns-listen", cfg.Mesh.DNSListen, "answer DNS queries for --mesh-dns-name on this UDP and TCP address with the least loaded relay-server of the mesh (disabled if empty)")
+	flag.StringVar(&cfg.Mesh.DNSName, "mesh-dns-name", cfg.Mesh.DNSName, "hostname of the relay fleet, e.g. relay.example.com")
+	flag.DurationVar(&cfg.Mesh.DNSTTL, "mesh-dns-ttl", cfg.Mesh.DNSTTL, "TTL of the DNS answers")
+	flag.StringVar(&cfg.Listen.Relay, "relay-server-listen", cfg.Listen.Relay, "relay-server TCP listen address")
+	flag.StringVar(&cfg.Listen.RelayUnix, "relay-server-unix-socket", cfg.Listen.RelayUnix, "also accept relay-server connections on this unix socket path, e.g. from a co-located TLS gateway")
+	flag.StringVar(&cfg.Listen.RelayPublic, "relay-server-public-address", cfg.Listen.RelayPublic, "relay-server endpoint handed to peers, e.g. a load balancer address (default: --relay-server-listen)")
+	config.StringsVar(&cfg.Listen.RelayExtra, "relay-server-extra-listen", "also accept relay-server connections on this TCP listen address, e.g. 0.0.0.0:443 (repeatable)")
+	config.StringsVar(&cfg.Listen.RelayExtraPublic, "relay-server-extra-public-address", "endpoint handed to peers after --relay-server-public-address (repeatable, default: the --relay-server-extra-listen addresses, on the public host for unspecified IPs)")
+	flag.BoolVar(&cfg.Listen.ProxyProtocol, "relay-proxy-protocol", cfg.Listen.ProxyProtocol, "require a PROXY protocol v1/v2 header on relay-server TCP connections")
+	flag.Func("relay-trusted-proxies", "comma separated CIDRs allowed to send PROXY headers (default: any)", func(v string) error {
+		cfg.Listen.TrustedProxies = strings.Split(v, ",")
+		return nil
+	})
+	flag.BoolVar(&cfg.Listen.RelayBindSources, "relay-bind-sources", cfg.Listen.RelayBindSources, "accept relay-server connections only from the IP of the libp2p connection of their peer")
+	flag.BoolVar(&cfg.Listen.RelayReusePort, "relay-reuse-port", cfg.Listen.RelayReusePort, "listen with SO_REUSEPORT, so that a new relay-server takes the relay listeners over when this one drains (unix only)")
+	flag.BoolVar(&cfg.Listen.RelayPortMapping, "relay-port-mapping", cfg.Listen.RelayPortMapping, "map the relay-server TCP ports on the UPnP or NAT-PMP gateway and hand their external address to peers, for a relay-server behind a home NAT")
+	flag.StringVar(&cfg.Listen.RelayObfsSecret, "relay-obfs-secret", cfg.Listen.RelayObfsSecret, "also accept relay-server connections obfuscated with this shared secret")
+	flag.BoolVar(&cfg.Listen.RelayObfsRequire, "relay-obfs-require", cfg.Listen.RelayObfsRequire, "refuse plain relay-server connections, requires --relay-obfs-secret")
+	flag.StringVar(&cfg.Listen.RelayWS, "relay-ws-listen", cfg.Listen.RelayWS, "also accept relay-server connections over WebSocket on this HTTP(S) listen address, for peers behind HTTP-only egress")
+	flag.StringVar(&cfg.Listen.RelayWSPath, "relay-ws-path", cfg.Listen.RelayWSPath, "path upgraded to WebSocket (default: /flymesh-relay)")
+	flag.StringVar(&cfg.Listen.RelayWSTLSCert, "relay-ws-tls-cert", cfg.Listen.RelayWSTLSCert, "TLS certificate file, serves wss:// with --relay-ws-tls-key")
+	flag.StringVar(&cfg.Listen.RelayWSTLSKey, "relay-ws-tls-key", cfg.Listen.RelayWSTLSKey, "TLS private key file of --relay-ws-tls-cert")
+	flag.StringVar(&cfg.Listen.RelayWSPublic, "relay-ws-public-url", cfg.Listen.RelayWSPublic, "WebSocket URL handed to peers, e.g. behind a reverse proxy (default: built from --relay-ws-listen)")
+	flag.StringVar(&cfg.Listen.RelayTLSCert, "relay-tls-cert", cfg.Listen.RelayTLSCert, "TLS certificate file, requires TLS on --relay-server-listen with --relay-tls-key and serves wss:// on --relay-ws-listen")
+	flag.StringVar(&cfg.Listen.RelayTLSKey, "relay-tls-key", cfg.Listen.RelayTLSKey, "TLS private key file of --relay-tls-cert")
+	flag.StringVar(&cfg.Listen.RelayACMEHost, "relay-acme-host", cfg.Listen.RelayACMEHost, "obtain the relay TLS certificate for this hostname by ACME instead of --relay-tls-cert; a relay listener must be reachable on port 443 of it")
+	flag.StringVar(&cfg.Listen.RelayACMECache, "relay-acme-cache", cfg.Listen.RelayACMECache, "directory keeping the ACME account and certificates")
+	flag.StringVar(&cfg.Listen.RelayACMEEmail, "relay-acme-email", cfg.Listen.RelayACMEEmail, "contact address of the ACME account")
+	flag.StringVar(&cfg.Listen.RelayACMEDirectory, "relay-acme-directory", cfg.Listen.RelayACMEDirectory, "directory URL of the ACME CA (default: Let's Encrypt)")
+	flag.StringVar(&cfg.History.Dir, "history-dir", cfg.History.Dir, "keep the throughput and latency history of peers and relays in this directory, served on /history (disabled if empty)")
+	flag.DurationVar(&cfg.History.Interval, "history-interval", cfg.History.Interval, "resolution of the history")
+	flag.DurationVar(&cfg.History.Retention, "history-retention", cfg.History.Retention, "how far back the history goes")
+	flag.StringVar(&cfg.Listen.Admin, "admin-listen", cfg.Listen.Admin, "HTTP listen address for /healthz, /readyz, /status, /metrics and /drain (disabled if empty)")
+	flag.DurationVar(&cfg.Limits.StreamTTL, "stream-ttl", cfg.Limits.StreamTTL, "how long an allocation waits for both sides to connect")
+	flag.IntVar(&cfg.Limits.MaxAllocations, "max-allocations", cfg.Limits.MaxAllocations, "maximum concurrent allocations (0 means unlimited)")
+	flag.Int64Var(&cfg.Limits.Bandwidth, "bandwidth", cfg.Limits.Bandwidth, "bytes per second all bridges carry together, shared by the priority of their streams (0 means unlimited)")
+	flag.DurationVar(&cfg.Limits.DrainTimeout, "drain-timeout", cfg.Limits.DrainTimeout, "how long in-flight bridges may run after SIGINT/SIGTERM before they are closed")
+	flag.StringVar(&cfg.Limits.StateFile, "state-file", cfg.Limits.StateFile, "keep the allocations not yet bridged in this file across restarts (disabled if empty)")
+	flag.BoolVar(&cfg.DialBack.Enabled, "dial-back", cfg.DialBack.Enabled, "verify server peers with a signed dial-back challenge before creating allocations")
+	flag.DurationVar(&cfg.DialBack.CacheTTL, "dial-back-cache-ttl", cfg.DialBack.CacheTTL, "how long a verified peer is trusted without a new dial-back")
+	flag.StringVar(&cfg.Policy.CreateStream, "create-stream-policy", cfg.Policy.CreateStream, "Starlark expression a create-stream request must satisfy, over action, peer, client_peer, count, max_bytes, allocations, unix, hour and weekday")
+	flag.BoolVar(&cfg.Dev.Enabled, "dev", cfg.Dev.Enabled, "development mode: allow the --chaos-* fault injection options")
+	flag.Int64Var(&cfg.Dev.KillBridgeAfter, "chaos-kill-bridge-after", cfg.Dev.KillBridgeAfter, "dev: close every bridge after this many bytes (0 disables)")
+	flag.DurationVar(&cfg.Dev.AckDelay, "chaos-ack-delay", cfg.Dev.AckDelay, "dev: delay every successful HandshakeAck")
+	flag.IntVar(&cfg.Dev.DropHandshakeEvery, "chaos-drop-handshake-every", cfg.Dev.DropHandshakeEvery, "dev: drop every k-th relay handshake (0 disables)")
+	flag.StringVar(&cfg.Logging.Level, "log-level", cfg.Logging.Level, "log level: debug | info | warn | error")
+	flag.StringVar(&cfg.Logging.Format, "log-format", cfg.Logging.Format, "log output format: text | json | journal (default when stderr is the systemd journal)")
+	flag.StringVar(&cfg.Logging.Output, "output", cfg.Logging.Output, "stdout format of the host ID, addresses, relay endpoints and errors: text | json (lines)")
+	flag.StringVar(&cfg.Logging.AccessLog.Path, "access-log", cfg.Logging.AccessLog.Path, "path of the JSONL relay access log, one line per allocation when it ends (disabled if empty)")
+	flag.Int64Var(&cfg.Logging.AccessLog.MaxSizeMB, "access-log-max-size", cfg.Logging.AccessLog.MaxSizeMB, "rotate the access log after this many megabytes (0 disables rotation)")
+	flag.DurationVar(&cfg.Logging.AccessLog.MaxAge, "access-log-max-age", cfg.Logging.AccessLog.MaxAge, "remove rotated access logs older than this (0 keeps them)")
+	flag.IntVar(&cfg.Logging.AccessLog.MaxBackups, "access-log-max-backups", cfg.Logging.AccessLog.MaxBackups, "keep at most this many rotated access logs (0 keeps all)")
+	flag.BoolVar(&cfg.Logging.AccessLog.Compress, "access-log-compress", cfg.Logging.AccessLog.Compress, "gzip rotated access logs")
+	flag.StringVar(&cfg.Logging.Redact.PeerIDs, "log-redact-peers", cfg.Logging.Redact.PeerIDs, "peer IDs in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.Redact.IPs, "log-redact-ips", cfg.Logging.Redact.IPs, "IP addresses in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.Redact.Tokens, "log-redact-tokens", cfg.Logging.Redact.Tokens, "tokens in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.TraceFile, "trace-file", cfg.Logging.TraceFile, "write OpenTelemetry spans as JSON to this file (disabled if empty)")
 	flag.Parse()
 
-	if *privKeyFile == "" {
-		log.Fatal("missing --private-key")
+	if err := logging.Setup(cfg.Logging.Level, cfg.Logging.Format, cfg.Logging.Redact.Redaction()); err != nil {
+		return fatal("bad logging configuration", "err", err)
+	}
+	if err := logging.SetupEvents(cfg.Logging.Output, cfg.Logging.Redact.Redaction()); err != nil {
+		return fatal("bad --output", "err", err)
+	}
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Logging.TraceFile != "" {
+		shutdownTracing, err = tracing.SetupFile(cfg.Logging.TraceFile, "flymesh-relay-server")
+		if err != nil {
+			return fatal("trace setup failed", "err", err)
+		}
 	}
 
-	priv, err := util.LoadOrCreatePrivateKey(*privKeyFile)
+	sigCtx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Identity.PrivateKeyFile == "" {
+		return fatal("missing --private-key")
+	}
+
+	passphrase, err := keyfile.ReadPassphrase(cfg.Identity.PassphraseFile)
+	if err != nil {
+		return fatal("read key passphrase failed", "err", err)
+	}
+	priv, err := identity.Load(context.Background(), cfg.Identity.PrivateKeyFile, identity.Options{Passphrase: passphrase})
 	if err != nil {
-		log.Fatalf("load private key failed: %+v", err)
+		return fatal("load private key failed", "err", err)
 	}
 
+	promMetrics := prom.New()
 	node := &p2p.Node{
 		PrivKey:    priv,
-		ListenPort: *listenPort,
+		ListenPort: cfg.Listen.Port,
 		Libp2pOptions: []libp2p.Option{
 			libp2p.EnableRelayService(),
 		},
+		Metrics: promMetrics,
+	}
+	node.ListenAddrs = cfg.P2P.ListenAddrs
+	node.Transports, err = p2p.ParseTransports(cfg.P2P.Transports)
+	if err != nil {
+		return fatal("bad transports", "err", err)
+	}
+	node.IPv6Only = cfg.P2P.IPv6Only
+	node.ReachabilityMode, err = p2p.ParseReachabilityMode(cfg.P2P.Reachability)
+	if err != nil {
+		return fatal("bad reachability", "err", err)
+	}
+	node.DisableAutoRelay = !cfg.P2P.AutoRelay
+	resolveCtx, cancelResolve := context.WithTimeout(parent, 30*time.Second)
+	node.StaticRelays, err = p2p.ResolvePeerList(resolveCtx, cfg.P2P.StaticRelays)
+	cancelResolve()
+	if err != nil {
+		return fatal("bad static relays", "err", err)
+	}
+	node.DHTMode, err = p2p.ParseDHTMode(cfg.P2P.DHTMode)
+	if err != nil {
+		return fatal("bad dht mode", "err", err)
+	}
+	node.DHTProtocolPrefix = cfg.P2P.DHTProtocolPrefix
+	node.ConnLimits = p2p.ConnLimits{
+		LowWater:    cfg.P2P.ConnLowWater,
+		HighWater:   cfg.P2P.ConnHighWater,
+		GracePeriod: cfg.P2P.ConnGracePeriod,
+	}
+	node.ResourceLimits = p2p.ResourceLimits{
+		Streams:        cfg.P2P.MaxStreams,
+		StreamsPerPeer: cfg.P2P.MaxStreamsPerPeer,
+		Memory:         cfg.P2P.MaxMemoryMB << 20,
+		FDs:            cfg.P2P.MaxFDs,
+	}
+	node.NoBootstrap = cfg.NoBootstrap
+	node.BootstrapPeers, err = p2p.ParseBootstrapPeers(cfg.Bootstrap)
+	if err != nil {
+		return fatal("bad bootstrap peers", "err", err)
 	}
 	if err := node.Init(); err != nil {
-		log.Fatalf("node initialize failed: %+v", err)
+		return fatal("node initialize failed", "err", err)
 	}
 	ctx := context.Background()
 
-	log.Printf("Host ID: %s", node.Host.ID())
+	slog.Info("host started", logging.KeyPeer, node.Host.ID().String())
+	var listenAddrs []string
 	for _, a := range node.Host.Addrs() {
-		log.Printf("Listen on: %s/p2p/%s", a, node.Host.ID())
+		addr := fmt.Sprintf("%s/p2p/%s", a, node.Host.ID())
+		slog.Info("listening", "addr", addr)
+		listenAddrs = append(listenAddrs, addr)
+	}
+	logging.Event(logging.EventHost, "peer_id", node.Host.ID().String(), "addrs", listenAddrs)
+
+	rm := relay_manager.New()
+	rm.PublicAddress = cfg.Listen.Relay
+	if cfg.Listen.RelayPublic != "" {
+		rm.PublicAddress = cfg.Listen.RelayPublic
+	}
+	rm.ExtraListen = cfg.Listen.RelayExtra
+	rm.ExtraPublicAddresses = cfg.Listen.RelayExtraPublic
+	rm.StreamTTL = cfg.Limits.StreamTTL
+	rm.MaxAllocations = cfg.Limits.MaxAllocations
+	rm.Bandwidth = cfg.Limits.Bandwidth
+	rm.StateFile = cfg.Limits.StateFile
+	rm.ReusePort = cfg.Listen.RelayReusePort
+	rm.PortMapping = cfg.Listen.RelayPortMapping
+	rm.Metrics = promMetrics
+	chaos := &relay_manager.Chaos{
+		KillBridgeAfter:    cfg.Dev.KillBridgeAfter,
+		AckDelay:           cfg.Dev.AckDelay,
+		DropHandshakeEvery: cfg.Dev.DropHandshakeEvery,
+	}
+	if chaos.Enabled() {
+		if !cfg.Dev.Enabled {
+			return fatal("chaos options require --dev")
+		}
+		slog.Warn("development mode: injecting faults",
+			"kill_bridge_after", chaos.KillBridgeAfter,
+			"ack_delay", chaos.AckDelay,
+			"drop_handshake_every", chaos.DropHandshakeEvery)
+		rm.Chaos = chaos
+	}
+	rm.UnixSocket = cfg.Listen.RelayUnix
+	rm.IPv6Only = cfg.P2P.IPv6Only
+	rm.Redaction = cfg.Logging.Redact.Redaction()
+	if cfg.DialBack.Enabled {
+		verifier := dialback.New(node.Host)
+		verifier.CacheTTL = cfg.DialBack.CacheTTL
+		verifier.Hello = rm.Hello()
+		rm.PeerVerifier = verifier
+	}
+	if cfg.Policy.CreateStream != "" {
+		rm.Policy, err = policy.Compile(cfg.Policy.CreateStream, relay_server.PolicyAttrs...)
+		if err != nil {
+			return fatal("bad create-stream policy", "err", err)
+		}
+	}
+	rm.ProxyProtocol = cfg.Listen.ProxyProtocol
+	rm.BindObservedSources = cfg.Listen.RelayBindSources
+	rm.TrustedProxies, err = proxyproto.ParsePrefixes(cfg.Listen.TrustedProxies)
+	if err != nil {
+		return fatal("bad trusted proxies", "err", err)
+	}
+	if cfg.Listen.RelayObfsSecret != "" {
+		rm.Obfuscator, err = obfs.NewPadded(cfg.Listen.RelayObfsSecret)
+		if err != nil {
+			return fatal("bad relay obfuscation", "err", err)
+		}
+		rm.RequireObfuscation = cfg.Listen.RelayObfsRequire
+	} else if cfg.Listen.RelayObfsRequire {
+		return fatal("--relay-obfs-require requires --relay-obfs-secret")
+	}
+	switch {
+	case cfg.Listen.RelayACMEHost != "":
+		if cfg.Listen.RelayTLSCert != "" || cfg.Listen.RelayTLSKey != "" {
+			return fatal("--relay-acme-host and --relay-tls-cert are exclusive")
+		}
+		if cfg.Listen.RelayACMECache == "" {
+			return fatal("--relay-acme-host requires --relay-acme-cache")
+		}
+		acm := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Listen.RelayACMEHost),
+			Cache:      autocert.DirCache(cfg.Listen.RelayACMECache),
+			Email:      cfg.Listen.RelayACMEEmail,
+		}
+		if cfg.Listen.RelayACMEDirectory != "" {
+			acm.Client = &acme.Client{DirectoryURL: cfg.Listen.RelayACMEDirectory}
+		}
+		rm.TLSConfig = acm.TLSConfig()
+	case cfg.Listen.RelayTLSCert != "" || cfg.Listen.RelayTLSKey != "":
+		cert, err := tls.LoadX509KeyPair(cfg.Listen.RelayTLSCert, cfg.Listen.RelayTLSKey)
+		if err != nil {
+			return fatal("bad relay TLS certificate", "err", err)
+		}
+		rm.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if cfg.Listen.RelayWS != "" {
+		rm.WebSocket = &relay_manager.WebSocketOptions{
+			Listen:    cfg.Listen.RelayWS,
+			Path:      cfg.Listen.RelayWSPath,
+			TLSConfig: rm.TLSConfig,
+			PublicURL: cfg.Listen.RelayWSPublic,
+		}
+		if cfg.Listen.RelayWSTLSCert != "" || cfg.Listen.RelayWSTLSKey != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.Listen.RelayWSTLSCert, cfg.Listen.RelayWSTLSKey)
+			if err != nil {
+				return fatal("bad relay WebSocket certificate", "err", err)
+			}
+			rm.WebSocket.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+	}
+	var accessLogFile *logging.RotatingFile
+	if cfg.Logging.AccessLog.Path != "" {
+		accessLogFile = &logging.RotatingFile{
+			Path:       cfg.Logging.AccessLog.Path,
+			MaxSize:    cfg.Logging.AccessLog.MaxSizeMB * 1024 * 1024,
+			MaxAge:     cfg.Logging.AccessLog.MaxAge,
+			MaxBackups: cfg.Logging.AccessLog.MaxBackups,
+			Compress:   cfg.Logging.AccessLog.Compress,
+		}
+		rm.AccessLog = accessLogFile
+	}
+	var hist *history.Store
+	if cfg.History.Dir != "" {
+		hist, err = history.Open(cfg.History.Dir, cfg.History.Interval, cfg.History.Retention)
+		if err != nil {
+			return fatal("open history failed", "err", err)
+		}
+		hist.MaxSeries = cfg.History.MaxSeries
+		hist.Start()
+	}
+	rm.History = hist
+	relay_server.Run(ctx, node, rm, cfg.Listen.Relay)
+	logging.Event(logging.EventRelay, "listen", rm.Addr().String(), "endpoints", rm.Endpoints())
+
+	var presence *mesh.Presence
+	if cfg.Mesh.ID != "" {
+		presence = mesh.New(node.Host, cfg.Mesh.ID)
+		presence.Role = "relay-server"
+		presence.Services = nil
+		presence.Discovery = node.Discovery()
+		presence.Interval = cfg.Mesh.AnnounceInterval
+		presence.MemberTTL = 4 * cfg.Mesh.AnnounceInterval
+		presence.Load = func() *mesh.Load {
+			allocations, bridges := rm.Load()
+			return &mesh.Load{
+				RelayEndpoint:  rm.PublicAddress,
+				Allocations:    allocations,
+				Bridges:        bridges,
+				MaxAllocations: rm.Settings().MaxAllocations,
+			}
+		}
+		if err := presence.Start(sigCtx); err != nil {
+			return fatal("join mesh failed", "err", err)
+		}
 	}
 
-	relay_server.Run(ctx, node, *relayListen, *relayListen)
+	var responder *relay_dns.Responder
+	if cfg.Mesh.DNSListen != "" {
+		if presence == nil {
+			return fatal("--mesh-dns-listen requires --mesh-id")
+		}
+		responder = &relay_dns.Responder{
+			Name:     cfg.Mesh.DNSName,
+			Presence: presence,
+			TTL:      cfg.Mesh.DNSTTL,
+			IPv6Only: cfg.P2P.IPv6Only,
+		}
+		if err := responder.Start(cfg.Mesh.DNSListen); err != nil {
+			return fatal("start relay DNS failed", "err", err)
+		}
+	}
 
-	select {}
+	adminServer := admin.New()
+	if cfg.Listen.Admin != "" {
+		adminServer.AddLivenessCheck("host", node.CheckHost)
+		adminServer.AddReadinessCheck("relay-listener", rm.CheckListener)
+		adminServer.AddReadinessCheck("drain", rm.CheckDraining)
+		adminServer.AddReadinessCheck("dht", node.CheckDHT)
+		sources := []status.Source{node, rm, status.SourceFunc(func(s *status.Status) {
+			s.Versions.Protocols = []string{protocol.ProtoRelayCreate, protocol.ProtoInfo}
+			s.Versions.ProtocolVersion = relay_protocol.ProtocolVersion
+		})}
+		if presence != nil {
+			sources = append(sources, presence)
+		}
+		adminServer.Handle("/status", status.Handler("relay-server", sources...))
+		adminServer.Handle("/metrics", promMetrics.Handler())
+		if hist != nil {
+			adminServer.Handle("/history", hist.Handler())
+		}
+		var drainOnce sync.Once
+		adminServer.Handle("/drain", drainHandler(rm, func() {
+			drainOnce.Do(func() { drain(sigCtx, rm, stop) })
+		}))
+		if err := adminServer.Start(cfg.Listen.Admin); err != nil {
+			return fatal("admin server start failed", "err", err)
+		}
+	}
+
+	var onReload func()
+	if config.PathFromArgs(os.Args[1:]) != "" {
+		onReload = func() { cfg = reload(reloader, cfg, rm) }
+	}
+	service.WaitReload(sigCtx, onReload, node.CheckHost, rm.CheckListener)
+	stop()
+	slog.Info("shutting down", "drain_timeout", cfg.Limits.DrainTimeout)
+	service.Stopping()
+
+	code := 0
+	node.Host.RemoveStreamHandler(protocol.ProtoRelayCreate)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Limits.DrainTimeout)
+	defer cancel()
+	if err := rm.Shutdown(drainCtx); err != nil {
+		slog.Warn("drain timeout exceeded, closed remaining bridges", "err", err)
+		code = 1
+	}
+	adminServer.Stop()
+	if err := hist.Close(); err != nil {
+		slog.Warn("write history failed", "err", err)
+	}
+	if responder != nil {
+		responder.Stop()
+	}
+	if presence != nil {
+		presence.Stop()
+	}
+	if err := node.Close(); err != nil {
+		slog.Warn("close node failed", "err", err)
+	}
+	if accessLogFile != nil {
+		_ = accessLogFile.Close()
+	}
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("flush traces failed", "err", err)
+	}
+	slog.Info("stopped")
+	return code
+}
+
+// reloadable lists the keys of the config file a relay-server applies on
+// SIGHUP, to the allocations and bridges that follow.
+var reloadable = []string{
+	"limits.stream_ttl",
+	"limits.max_allocations",
+	"limits.drain_timeout",
+	"policy.create_stream",
+	"logging.level",
+}
+
+// fatal logs msg and args as an error and returns the exit code of a failed
+// run. run returns rather than exits so that the Windows service manager sees
+// the service stop with that code.
+func fatal(msg string, args ...any) int {
+	slog.Error(msg, args...)
+	return 1
+}
+
+// reload reads the config file again and applies the reloadable keys that
+// changed to rm. It returns the running config, cfg if the file is invalid.
+func reload(reloader *config.Reloader, cfg *config.Config, rm *relay_manager.RelayManager) *config.Config {
+	res, err := reloader.Reload(cfg)
+	if err != nil {
+		slog.Error("reload config failed", "err", err)
+		return cfg
+	}
+	next := res.Config
+	if _, err := logging.ParseLevel(next.Logging.Level); err != nil {
+		slog.Error("reload config failed", "err", err)
+		return cfg
+	}
+	settings := rm.Settings()
+	settings.StreamTTL = next.Limits.StreamTTL
+	settings.MaxAllocations = next.Limits.MaxAllocations
+	if next.Policy.CreateStream != cfg.Policy.CreateStream {
+		settings.Policy = nil
+		if next.Policy.CreateStream != "" {
+			settings.Policy, err = policy.Compile(next.Policy.CreateStream, relay_server.PolicyAttrs...)
+			if err != nil {
+				slog.Error("reload config failed: bad create-stream policy", "err", err)
+				return cfg
+			}
+		}
+	}
+	rm.Reconfigure(settings)
+	_ = logging.SetLevel(next.Logging.Level)
+	reloader.Accept(res)
+
+	for _, key := range res.Overridden {
+		slog.Warn("config change ignored, the command line sets it", "key", key)
+	}
+	if restart := res.Restart(reloadable...); len(restart) > 0 {
+		slog.Warn("config changes take effect after a restart", "keys", restart)
+	}
+	slog.Info("config reloaded", "changed", res.Changed)
+	return next
 }
@@ -0,0 +1,51 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCopy(t *testing.T) {
+	const id = "12D3KooWLRPJAA5o6zfDNcEnJUWHA8FYhG9GLExsqDhVdxPA5ZUE"
+	dir := t.TempDir()
+	tests := []struct {
+		name       string
+		src, dst   string
+		want       copyJob
+		wantRemote string
+		bad        bool
+	}{
+		{name: "put", src: "a.bin", dst: id + ":backup/a.bin", want: copyJob{Put: true, Local: "a.bin", Path: "backup/a.bin"}, wantRemote: id},
+		{name: "put into dir", src: "x/a.bin", dst: id + ":backup/", want: copyJob{Put: true, Local: "x/a.bin", Path: "backup/a.bin"}, wantRemote: id},
+		{name: "put to root", src: "a.bin", dst: id + ":", want: copyJob{Put: true, Local: "a.bin", Path: "a.bin"}, wantRemote: id},
+		{name: "get", src: id + ":backup/a.bin", dst: "b.bin", want: copyJob{Local: "b.bin", Path: "backup/a.bin"}, wantRemote: id},
+		{name: "get into dir", src: id + ":backup/a.bin", dst: dir, want: copyJob{Local: filepath.Join(dir, "a.bin"), Path: "backup/a.bin"}, wantRemote: id},
+		{
+			name: "multiaddr", src: "a.bin", dst: "/ip6/::1/tcp/4001/p2p/" + id + ":a.bin",
+			want: copyJob{Put: true, Local: "a.bin", Path: "a.bin"}, wantRemote: "/ip6/::1/tcp/4001/p2p/" + id,
+		},
+		{name: "hostname", src: "peers.example.com:a.bin", dst: "a.bin", want: copyJob{Local: "a.bin", Path: "a.bin"}, wantRemote: "peers.example.com"},
+		{name: "both local", src: "a.bin", dst: "./b:c", bad: true},
+		{name: "drive letter", src: "a.bin", dst: `C:\b.bin`, bad: true},
+		{name: "multiaddr without peer", src: "a.bin", dst: "/ip4/127.0.0.1/tcp/1:a.bin", bad: true},
+		{name: "both remote", src: id + ":a.bin", dst: id + ":b.bin", bad: true},
+		{name: "get dir", src: id + ":backup/", dst: "b.bin", bad: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, remote, err := parseCopy(tt.src, tt.dst)
+			if tt.bad {
+				if err == nil {
+					t.Fatalf("parseCopy() = %+v, %q, want an error", got, remote)
+				}
+				return
+			}
+			if err != nil || got != tt.want || remote != tt.wantRemote {
+				t.Fatalf("parseCopy() = %+v, %q, %v, want %+v, %q", got, remote, err, tt.want, tt.wantRemote)
+			}
+		})
+	}
+}
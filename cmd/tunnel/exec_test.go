@@ -0,0 +1,70 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/remoteexec"
+	relay_client "github.com/flymesh/core/relay-client"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestAuthorizerExec(t *testing.T) {
+	admin, other := peer.ID("admin"), peer.ID("other")
+	peers := func(ids ...peer.ID) map[peer.ID]struct{} {
+		m := make(map[peer.ID]struct{}, len(ids))
+		for _, id := range ids {
+			m[id] = struct{}{}
+		}
+		return m
+	}
+	tests := []struct {
+		name       string
+		allowPeers map[peer.ID]struct{}
+		execPeers  map[peer.ID]struct{}
+		peer       peer.ID
+		alpn       string
+		wantErr    bool
+	}{
+		{name: "stream from any peer", peer: other},
+		{name: "exec disabled", peer: admin, alpn: remoteexec.ALPN, wantErr: true},
+		{name: "exec peer", execPeers: peers(admin), peer: admin, alpn: remoteexec.ALPN},
+		{name: "exec from other peer", execPeers: peers(admin), peer: other, alpn: remoteexec.ALPN, wantErr: true},
+		{name: "allowed peer not exec peer", allowPeers: peers(other), execPeers: peers(admin), peer: other, alpn: remoteexec.ALPN, wantErr: true},
+		{name: "exec peer not allowed peer", allowPeers: peers(other), execPeers: peers(admin), peer: admin, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer(tt.allowPeers, tt.execPeers, nil)(tt.peer, &controlpb.StartRelayStreamRequest{Alpn: tt.alpn})
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, relay_client.ErrUnauthorized)) {
+				t.Fatalf("authorize() err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		req      *controlpb.ExecRequest
+		wantErr  bool
+	}{
+		{name: "any command", req: &controlpb.ExecRequest{Argv: []string{"sh"}, Env: []string{"PATH=/tmp"}, Dir: "/tmp"}},
+		{name: "listed", commands: []string{"uptime"}, req: &controlpb.ExecRequest{Argv: []string{"uptime", "-p"}}},
+		{name: "not listed", commands: []string{"uptime"}, req: &controlpb.ExecRequest{Argv: []string{"sh"}}, wantErr: true},
+		{name: "listed with env", commands: []string{"uptime"}, req: &controlpb.ExecRequest{Argv: []string{"uptime"}, Env: []string{"LD_PRELOAD=/tmp/evil.so"}}, wantErr: true},
+		{name: "listed with dir", commands: []string{"uptime"}, req: &controlpb.ExecRequest{Argv: []string{"uptime"}, Dir: "/tmp"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := allowCommand(tt.commands, tt.req); (err != nil) != tt.wantErr {
+				t.Fatalf("allowCommand() err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		bad  bool
+	}{
+		{in: "0", want: 0},
+		{in: "1500", want: 1500},
+		{in: "1K", want: 1 << 10},
+		{in: "512M", want: 512 << 20},
+		{in: "512MiB", want: 512 << 20},
+		{in: "512Mi", want: 512 << 20},
+		{in: "2G", want: 2 << 30},
+		{in: "3T", want: 3 << 40},
+		{in: "100B", want: 100},
+		{in: "8388607T", want: 8388607 << 40},
+		{in: "8388608T", bad: true},
+		{in: "9223372036854775807", want: math.MaxInt64},
+		{in: "9223372036854775808", bad: true},
+		{in: "", bad: true},
+		{in: "M", bad: true},
+		{in: "-1", bad: true},
+		{in: "-1K", bad: true},
+		{in: "1.5G", bad: true},
+		{in: "1P", bad: true},
+		{in: "1KM", bad: true},
+		{in: "K1", bad: true},
+		{in: " 1K", bad: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseGrant(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := id.String()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		expires  time.Time
+		service  string
+		maxBytes int64
+		bad      bool
+	}{
+		{spec: p + ",duration=1h", expires: now.Add(time.Hour)},
+		{spec: p + ",duration=90m,service=ssh", expires: now.Add(90 * time.Minute), service: "ssh"},
+		{spec: p + ",service=web,max-bytes=1G,duration=24h", expires: now.Add(24 * time.Hour), service: "web", maxBytes: 1 << 30},
+		{spec: p + ",duration=1h,duration=2h", expires: now.Add(2 * time.Hour)},
+		{spec: p, bad: true},
+		{spec: p + ",service=ssh", bad: true},
+		{spec: p + ",duration=0s", bad: true},
+		{spec: p + ",duration=-1h", bad: true},
+		{spec: p + ",duration=soon", bad: true},
+		{spec: p + ",duration=1h,max-bytes=lots", bad: true},
+		{spec: p + ",duration=1h,color=blue", bad: true},
+		{spec: p + ",duration=1h,", bad: true},
+		{spec: "not-a-peer,duration=1h", bad: true},
+		{spec: "", bad: true},
+	}
+	for _, tt := range tests {
+		g, err := parseGrant(tt.spec, now)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("parseGrant(%q) err = nil", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGrant(%q) err = %v", tt.spec, err)
+			continue
+		}
+		if g.Peer != id || !g.Expires.Equal(tt.expires) || g.Service != tt.service || g.MaxBytes != tt.maxBytes {
+			t.Errorf("parseGrant(%q) = %+v", tt.spec, g)
+		}
+	}
+}
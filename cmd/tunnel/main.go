@@ -5,167 +5,1886 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/flymesh/core/p2p"
+	"github.com/flymesh/core/pkg/admin"
+	"github.com/flymesh/core/pkg/config"
+	"github.com/flymesh/core/pkg/control"
+	"github.com/flymesh/core/pkg/forward"
+	"github.com/flymesh/core/pkg/history"
+	"github.com/flymesh/core/pkg/identity"
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/mesh"
+	"github.com/flymesh/core/pkg/metrics/prom"
+	"github.com/flymesh/core/pkg/obfs"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/policy"
+	"github.com/flymesh/core/pkg/protocol"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/remoteexec"
+	"github.com/flymesh/core/pkg/service"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/flymesh/core/pkg/tracing"
+	"github.com/flymesh/core/pkg/transfer"
 	"github.com/flymesh/core/pkg/util"
+	"github.com/flymesh/core/pkg/wg"
 	relay_client "github.com/flymesh/core/relay-client"
 
+	"github.com/libp2p/go-libp2p/core/network"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
+	"golang.zx2c4.com/wireguard/tun"
 )
 
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
-	mode := flag.String("mode", "", "server | client")
-	privKeyFile := flag.String("private-key", "", "path to private key file")
-	listenPort := flag.Int("listen-port", 0, "listen port")
-	remoteAddr := flag.String("remote", "", "remote peer multiaddr (client mode)")
-	duration := flag.Int("duration", 10, "throughput test duration in seconds")
-	sendMode := flag.Bool("send", true, "client mode: send or receive on relay-server TCP")
+	if len(os.Args) > 1 && os.Args[1] == "service" {
+		if err := service.Command("flymesh-tunnel", "flymesh tunnel", os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "service:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	os.Exit(service.Run("flymesh-tunnel", run))
+}
+
+// run runs the command until parent is done or it is interrupted, and returns
+// its exit code.
+func run(parent context.Context) int {
+	cfg, err := config.LoadFromArgs(os.Args[1:])
+	if err != nil {
+		return fatal("load config failed", "err", err)
+	}
+	reloader := config.NewReloader(os.Args[1:], cfg)
+	var relay config.Relay
+	if len(cfg.Relays) > 0 {
+		relay = cfg.Relays[0]
+	}
+
+	var forwardSpecs, udpForwardSpecs, udpTargetSpecs stringList
+	flag.String("config", "", "path to a YAML or TOML config file; flags override its values")
+	flag.StringVar(&cfg.Tunnel.Mode, "mode", cfg.Tunnel.Mode, "server | client")
+	flag.StringVar(&cfg.Tunnel.Profile, "profile", cfg.Tunnel.Profile, "node profile: default | mobile")
+	flag.StringVar(&cfg.Identity.PrivateKeyFile, "private-key", cfg.Identity.PrivateKeyFile, "private key file path, keyring:[SERVICE/]ACCOUNT in the OS keychain, or env:VAR | exec:COMMAND holding the key as PEM or base64")
+	flag.StringVar(&cfg.Identity.PassphraseFile, "key-passphrase-file", cfg.Identity.PassphraseFile, "file holding the passphrase encrypting the private key file, which is encrypted in place if plaintext (default: $FLYMESH_KEY_PASSPHRASE)")
+	flag.IntVar(&cfg.Listen.Port, "listen-port", cfg.Listen.Port, "listen port")
+	config.StringsVar(&cfg.Bootstrap, "bootstrap", "DHT bootstrap peer multiaddr (repeatable, default: built-in list)")
+	flag.BoolVar(&cfg.NoBootstrap, "no-bootstrap", cfg.NoBootstrap, "do not bootstrap the DHT, for isolated networks")
+	config.StringsVar(&cfg.P2P.ListenAddrs, "listen-addr", "libp2p listen multiaddr, overrides --listen-port (repeatable)")
+	flag.Func("transports", "comma separated libp2p transports to enable: tcp,quic,ws (default: all)", func(v string) error {
+		cfg.P2P.Transports = strings.Split(v, ",")
+		return nil
+	})
+	flag.BoolVar(&cfg.P2P.IPv6Only, "ipv6-only", cfg.P2P.IPv6Only, "disable IPv4 entirely: listen, dial and announce IPv6 addresses only")
+	flag.StringVar(&cfg.P2P.Reachability, "reachability", cfg.P2P.Reachability, "auto | public | private")
+	flag.BoolVar(&cfg.P2P.AutoRelay, "autorelay", cfg.P2P.AutoRelay, "reserve circuit relay slots when not publicly reachable")
+	config.StringsVar(&cfg.P2P.StaticRelays, "static-relay", "circuit relay multiaddr, /dnsaddr multiaddr or hostname with _dnsaddr TXT records, to use instead of DHT discovered relays (repeatable)")
+	flag.StringVar(&cfg.P2P.DHTMode, "dht-mode", cfg.P2P.DHTMode, "client | server | auto")
+	flag.StringVar(&cfg.P2P.DHTProtocolPrefix, "dht-protocol-prefix", cfg.P2P.DHTProtocolPrefix, "DHT protocol prefix replacing /ipfs, for a private DHT")
+	flag.IntVar(&cfg.P2P.ConnLowWater, "conn-low-water", cfg.P2P.ConnLowWater, "trim connections down to this many once over --conn-high-water (0 keeps the libp2p default)")
+	flag.IntVar(&cfg.P2P.ConnHighWater, "conn-high-water", cfg.P2P.ConnHighWater, "trim connections once there are more than this many (0 keeps the libp2p default)")
+	flag.DurationVar(&cfg.P2P.ConnGracePeriod, "conn-grace-period", cfg.P2P.ConnGracePeriod, "how long new connections are protected from trimming (default: 1m)")
+	flag.IntVar(&cfg.P2P.MaxStreams, "libp2p-max-streams", cfg.P2P.MaxStreams, "maximum open libp2p streams (0 scales with the host)")
+	flag.IntVar(&cfg.P2P.MaxStreamsPerPeer, "libp2p-max-streams-per-peer", cfg.P2P.MaxStreamsPerPeer, "maximum open libp2p streams of a single peer (0 scales with the host)")
+	flag.Int64Var(&cfg.P2P.MaxMemoryMB, "libp2p-max-memory", cfg.P2P.MaxMemoryMB, "megabytes libp2p connections and streams may reserve (0 scales with the host)")
+	flag.IntVar(&cfg.P2P.MaxFDs, "libp2p-max-fds", cfg.P2P.MaxFDs, "file descriptors libp2p connections may use (0 scales with the host)")
+	flag.StringVar(&cfg.Mesh.ID, "mesh-id", cfg.Mesh.ID, "announce presence to, and track, the nodes sharing this mesh ID (disabled if empty)")
+	flag.DurationVar(&cfg.Mesh.AnnounceInterval, "mesh-announce-interval", cfg.Mesh.AnnounceInterval, "time between two mesh presence announcements")
+	flag.StringVar(&cfg.Tunnel.Remote, "remote", cfg.Tunnel.Remote, "remote peer multiaddr, /dnsaddr multiaddr or hostname with _dnsaddr TXT records, re-resolved every --resolve-interval, or peer ID found through the DHT (client mode)")
+	stdio := flag.String("stdio", "", "bridge stdin and stdout to a stream to this peer ID or multiaddr, like ssh -W, e.g. ProxyCommand tunnel --stdio %n (implies --mode client)")
+	flag.IntVar(&cfg.Tunnel.ConnectAttempts, "connect-attempts", cfg.Tunnel.ConnectAttempts, "client mode: attempts to connect to the remote peer (0 retries until --connect-deadline)")
+	flag.DurationVar(&cfg.Tunnel.ConnectBackoff, "connect-backoff", cfg.Tunnel.ConnectBackoff, "client mode: pause after the first failed connect attempt, doubled after each further one")
+	flag.DurationVar(&cfg.Tunnel.ConnectMaxBackoff, "connect-max-backoff", cfg.Tunnel.ConnectMaxBackoff, "client mode: longest pause between two connect attempts")
+	flag.Float64Var(&cfg.Tunnel.ConnectJitter, "connect-jitter", cfg.Tunnel.ConnectJitter, "client mode: randomize each pause between connect attempts by up to this fraction of it, 0 to 1")
+	flag.DurationVar(&cfg.Tunnel.ConnectDeadline, "connect-deadline", cfg.Tunnel.ConnectDeadline, "client mode: give up connecting to the remote peer after this long (disabled if 0)")
+	flag.DurationVar(&cfg.Tunnel.ResolveInterval, "resolve-interval", cfg.Tunnel.ResolveInterval, "how often a --remote or --relay-server-addr naming peers through DNS is resolved again")
+	flag.StringVar(&cfg.Tunnel.Service, "service", cfg.Tunnel.Service, "client mode: connect to a peer advertising this service name instead of --remote")
+	config.StringsVar(&cfg.Tunnel.Advertise, "advertise", "server mode: advertise this service name, e.g. office-nas/ssh, in the DHT (repeatable)")
+	config.StringsVar(&cfg.Tunnel.AllowPeers, "allow-peer", "server mode: only accept streams from this peer ID (repeatable, default: any peer)")
+	flag.StringVar(&cfg.Policy.StartRelay, "stream-policy", cfg.Policy.StartRelay, "server mode: Starlark expression a stream request must satisfy, over peer, service, address, alpn, guest, sessions, unix, hour and weekday")
+	config.StringsVar(&cfg.Tunnel.Grants, "grant", "server mode: give a peer guest access for a time and volume, as PEER,duration=D[,service=NAME][,max-bytes=SIZE] (repeatable)")
+	flag.IntVar(&cfg.Limits.MaxSessionsPerClient, "max-sessions-per-client", cfg.Limits.MaxSessionsPerClient, "server mode: maximum concurrent streams of one client (0 means unlimited)")
+	flag.StringVar(&cfg.Tunnel.DialStrategy, "dial-strategy", cfg.Tunnel.DialStrategy, "client mode: relay | race (race a direct stream against the relay path) | bond (stripe streams over both paths, experimental) | notify (have the relay-server notify a server registered with --relay-notify)")
+	flag.DurationVar(&cfg.Tunnel.DirectHeadStart, "direct-head-start", cfg.Tunnel.DirectHeadStart, "client mode: how long --dial-strategy=race tries the direct path alone before starting the relay path")
+	config.StringsVar(&cfg.Tunnel.Compression, "compression", "compress relayed streams with zstd or snappy if the peer agrees; a client offers them in the order given (repeatable)")
+	flag.StringVar(&cfg.Tunnel.Priority, "priority", cfg.Tunnel.Priority, "client mode: class of traffic relay-servers schedule the streams as, interactive | bulk, unless a forward sets its own")
+	config.StringsVar(&cfg.Tunnel.RelayMACs, "relay-mac", "MAC algorithm, hmac-sha256 or blake3, relay-servers may authenticate their frames with, most preferred first (repeatable)")
+	flag.StringVar(&cfg.Tunnel.RelayObfsSecret, "relay-obfs-secret", cfg.Tunnel.RelayObfsSecret, "obfuscate the connections to relay-servers with this shared secret, which they must know too")
+	flag.StringVar(&cfg.Tunnel.RelayTLSCA, "relay-tls-ca", cfg.Tunnel.RelayTLSCA, "PEM file of the CA certificates trusted for TLS relay endpoints (default: system roots)")
+	flag.BoolVar(&cfg.Tunnel.RequireSessionBinding, "require-session-binding", cfg.Tunnel.RequireSessionBinding, "refuse relayed streams whose Noise handshake is not bound to the relay allocation")
+	flag.BoolVar(&cfg.Tunnel.RelayTransport, "relay-transport", cfg.Tunnel.RelayTransport, "carry libp2p connections over the relay: accept them as a server, upgrade limited connections to servers through it")
+	flag.BoolVar(&cfg.Tunnel.RelayNotify, "relay-notify", cfg.Tunnel.RelayNotify, "server mode: register with the relay-server for the stream requests clients send through it with --dial-strategy=notify")
+	flag.IntVar(&cfg.Tunnel.RelayRetries, "relay-retries", cfg.Tunnel.RelayRetries, "client mode: how many times a failed relay dial is retried with a new allocation")
+	flag.IntVar(&cfg.Tunnel.Duration, "duration", cfg.Tunnel.Duration, "throughput test duration in seconds")
+	flag.BoolVar(&cfg.Tunnel.Send, "send", cfg.Tunnel.Send, "client mode: send or receive on relay-server TCP")
+	flag.BoolVar(&cfg.Tunnel.Reverse, "reverse", cfg.Tunnel.Reverse, "client mode: have the server send in the throughput test, like --send=false")
+	flag.BoolVar(&cfg.Tunnel.Bidir, "bidir", cfg.Tunnel.Bidir, "client mode: send and receive at once in the throughput test")
+	flag.BoolFunc("json", "shorthand for --output json", func(string) error {
+		cfg.Logging.Output = logging.OutputJSON
+		return nil
+	})
+	flag.IntVar(&cfg.Tunnel.Parallel, "parallel", cfg.Tunnel.Parallel, "client mode: number of streams the throughput test runs at once")
+	flag.StringVar(&cfg.Tunnel.Test, "test", cfg.Tunnel.Test, "client mode: throughput | latency (round trip times and jitter of echoed frames)")
+	printStatus := flag.Bool("status", false, "print the NAT type and reachability status after probing for --status-wait, then exit")
+	statusWait := flag.Duration("status-wait", 30*time.Second, "how long --status lets AutoNAT and the relays settle")
+	flag.DurationVar(&cfg.Tunnel.LinkInterval, "link-monitor-interval", cfg.Tunnel.LinkInterval, "client mode: ping the remote peer and its relays at this interval, for RTT and loss in /status and /metrics (disabled if 0)")
+	flag.DurationVar(&cfg.Tunnel.LinkMaxRTT, "link-max-rtt", cfg.Tunnel.LinkMaxRTT, "client mode: a monitored link with a higher average RTT is degraded (disabled if 0)")
+	flag.Float64Var(&cfg.Tunnel.LinkMaxLoss, "link-max-loss", cfg.Tunnel.LinkMaxLoss, "client mode: a monitored link losing a larger fraction of pings, 0 to 1, is degraded (disabled if 0)")
+	flag.BoolVar(&cfg.Tunnel.LinkReevaluate, "link-reevaluate", cfg.Tunnel.LinkReevaluate, "client mode: look for a direct path to the remote peer when a monitored link is degraded")
+	flag.DurationVar(&cfg.Tunnel.StatusInterval, "status-interval", cfg.Tunnel.StatusInterval, "log the NAT type and reachability status at this interval (disabled if 0)")
+	flag.DurationVar(&cfg.Limits.DrainTimeout, "drain-timeout", cfg.Limits.DrainTimeout, "how long forwarded connections may run after SIGINT/SIGTERM before they are closed")
 	// relay-server config
-	relayPeer := flag.String("relay-server-peer", "", "relay-server peer ID (server mode)")
-	relayAddr := flag.String("relay-server-addr", "", "relay-server peer multiaddr (server mode, optional)")
+	flag.StringVar(&relay.Peer, "relay-server-peer", relay.Peer, "relay-server peer ID (server mode, client mode with --dial-strategy=notify)")
+	flag.StringVar(&relay.Addr, "relay-server-addr", relay.Addr, "relay-server peer multiaddr, /dnsaddr multiaddr or hostname with _dnsaddr TXT records (server mode, optional)")
+	flag.StringVar(&cfg.History.Dir, "history-dir", cfg.History.Dir, "keep the throughput and latency history of peers and relays in this directory, served on /history (disabled if empty)")
+	flag.DurationVar(&cfg.History.Interval, "history-interval", cfg.History.Interval, "resolution of the history")
+	flag.DurationVar(&cfg.History.Retention, "history-retention", cfg.History.Retention, "how far back the history goes")
+	flag.StringVar(&cfg.Listen.Admin, "admin-listen", cfg.Listen.Admin, "HTTP listen address for /healthz, /status and /metrics (disabled if empty)")
+	flag.StringVar(&cfg.Listen.Control, "control-socket", cfg.Listen.Control, "serve the control API of tunnelctl on this Unix socket; a client then keeps running to take forwards at runtime (disabled if empty)")
+	flag.Var(&forwardSpecs, "forward", "client mode: forward [name=]localAddr to the remote peer (repeatable)")
+	forwardTarget := flag.String("forward-target", "", "server mode: carry incoming streams to [name=]host:port instead of running the throughput test")
+	flag.Var(&udpForwardSpecs, "forward-udp", "client mode: forward UDP datagrams received on [name=][bind:]port, loopback by default, to host:port through the remote peer (repeatable)")
+	flag.Var(&udpForwardSpecs, "U", "shorthand for --forward-udp")
+	flag.StringVar(&cfg.Tunnel.FileRoot, "file-root", cfg.Tunnel.FileRoot, "server mode: serve the file transfers of tunnel cp within this directory (disabled if empty)")
+	flag.BoolVar(&cfg.Tunnel.FileReadOnly, "file-read-only", cfg.Tunnel.FileReadOnly, "server mode: only let clients fetch files from --file-root")
+	config.StringsVar(&cfg.Tunnel.ExecPeers, "exec-allow-peer", "server mode: run the commands of tunnel exec from this peer ID, as the user of the tunnel (repeatable, disabled if none)")
+	config.StringsVar(&cfg.Tunnel.ExecCommands, "exec-allow-command", "server mode: run only this program for tunnel exec, as named on its command line, without environment or working directory overrides (repeatable, any command if none)")
+	flag.Var(&udpTargetSpecs, "forward-udp-target", "server mode: carry the UDP flows clients ask for to [name=]host:port (repeatable)")
+	flag.StringVar(&cfg.WireGuard.Interface, "wireguard-interface", cfg.WireGuard.Interface, "run a WireGuard device on this new TUN interface, its peers reached over streams: the clients of a server, the --remote of a client (disabled if empty)")
+	flag.IntVar(&cfg.WireGuard.MTU, "wireguard-mtu", cfg.WireGuard.MTU, "MTU of the WireGuard interface")
+	flag.StringVar(&cfg.WireGuard.PrivateKeyFile, "wireguard-private-key", cfg.WireGuard.PrivateKeyFile, "WireGuard private key file written by wg genkey (default: a new key on every start)")
+	config.StringsVar(&cfg.WireGuard.Routes, "wireguard-route", "prefix routed to this node over WireGuard, announced to its peers (repeatable)")
+	config.StringsVar(&cfg.WireGuard.AllowedIPs, "wireguard-allowed-ip", "prefix the WireGuard peers may announce as routed to them (repeatable)")
+	flag.StringVar(&cfg.Logging.Level, "log-level", cfg.Logging.Level, "log level: debug | info | warn | error")
+	flag.StringVar(&cfg.Logging.Format, "log-format", cfg.Logging.Format, "log output format: text | json | journal (default when stderr is the systemd journal)")
+	flag.StringVar(&cfg.Logging.Output, "output", cfg.Logging.Output, "stdout format of the host ID, addresses, relay endpoints, test results and errors: text | json (lines)")
+	flag.StringVar(&cfg.Logging.Redact.PeerIDs, "log-redact-peers", cfg.Logging.Redact.PeerIDs, "peer IDs in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.Redact.IPs, "log-redact-ips", cfg.Logging.Redact.IPs, "IP addresses in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.Redact.Tokens, "log-redact-tokens", cfg.Logging.Redact.Tokens, "tokens in logs: full | truncated | hashed")
+	flag.StringVar(&cfg.Logging.TraceFile, "trace-file", cfg.Logging.TraceFile, "write OpenTelemetry spans as JSON to this file (disabled if empty)")
 	flag.Parse()
+	// The relay flags override the first relay of the config file.
+	if len(cfg.Relays) > 0 {
+		cfg.Relays[0] = relay
+	} else if relay != (config.Relay{}) {
+		cfg.Relays = []config.Relay{relay}
+	}
+
+	if *stdio != "" {
+		if cfg.Tunnel.Mode != "" && cfg.Tunnel.Mode != "client" {
+			return fatal("--stdio requires client mode", "mode", cfg.Tunnel.Mode)
+		}
+		if len(forwardSpecs) > 0 || len(udpForwardSpecs) > 0 {
+			return fatal("--stdio and --forward are exclusive")
+		}
+		if cfg.Listen.Control != "" {
+			return fatal("--stdio and --control-socket are exclusive")
+		}
+		if cfg.WireGuard.Interface != "" {
+			return fatal("--stdio and --wireguard-interface are exclusive")
+		}
+		if flag.NArg() > 0 {
+			return fatal("--stdio takes no command", "args", flag.Args())
+		}
+		if strings.EqualFold(cfg.Logging.Output, logging.OutputJSON) {
+			return fatal("--stdio carries the stream on stdout, it cannot print --output json")
+		}
+		cfg.Tunnel.Mode = "client"
+		cfg.Tunnel.Remote = *stdio
+		// Keep the terminal of the ssh session quiet unless asked otherwise.
+		if !flagSet("log-level") && cfg.Logging.Level == config.Default().Logging.Level {
+			cfg.Logging.Level = "warn"
+		}
+	}
 
-	if *mode == "" {
-		log.Fatal("missing --mode")
+	// tunnel [flags] cp SRC DST copies a file from or to the peer named in
+	// one of SRC and DST. tunnel [flags] exec REMOTE COMMAND [ARG...] runs
+	// COMMAND on REMOTE.
+	var (
+		cp       *copyJob
+		execArgv []string
+	)
+	if flag.NArg() > 0 {
+		switch {
+		case flag.Arg(0) == "cp" && flag.NArg() == 3:
+			job, remote, err := parseCopy(flag.Arg(1), flag.Arg(2))
+			if err != nil {
+				return fatal("bad cp arguments", "err", err)
+			}
+			cfg.Tunnel.Remote = remote
+			cp = &job
+		case flag.Arg(0) == "exec" && flag.NArg() >= 3:
+			cfg.Tunnel.Remote = flag.Arg(1)
+			execArgv = flag.Args()[2:]
+		default:
+			return fatal("bad arguments, want tunnel [flags] cp SRC DST or tunnel [flags] exec REMOTE COMMAND [ARG...]", "args", flag.Args())
+		}
+		if cfg.Tunnel.Mode != "" && cfg.Tunnel.Mode != "client" {
+			return fatal(flag.Arg(0)+" requires client mode", "mode", cfg.Tunnel.Mode)
+		}
+		cfg.Tunnel.Mode = "client"
+		// Keep the progress line, or the output of the command, clear of
+		// logs unless asked otherwise.
+		if !flagSet("log-level") && cfg.Logging.Level == config.Default().Logging.Level {
+			cfg.Logging.Level = "warn"
+		}
+	}
+
+	if err := logging.Setup(cfg.Logging.Level, cfg.Logging.Format, cfg.Logging.Redact.Redaction()); err != nil {
+		return fatal("bad logging configuration", "err", err)
 	}
-	if *privKeyFile == "" {
-		log.Fatal("missing --private-key")
+	if err := logging.SetupEvents(cfg.Logging.Output, cfg.Logging.Redact.Redaction()); err != nil {
+		return fatal("bad --output", "err", err)
+	}
+	jsonOutput := strings.EqualFold(cfg.Logging.Output, logging.OutputJSON)
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Logging.TraceFile != "" {
+		shutdownTracing, err = tracing.SetupFile(cfg.Logging.TraceFile, "flymesh-tunnel")
+		if err != nil {
+			return fatal("trace setup failed", "err", err)
+		}
+	}
+
+	if err := relay_client.ParseCompression(cfg.Tunnel.Compression); err != nil {
+		return fatal("bad --compression", "err", err)
+	}
+	priority, err := relay_client.ParsePriority(cfg.Tunnel.Priority)
+	if err != nil {
+		return fatal("bad --priority", "err", err)
+	}
+	relayMACs, err := relay_protocol.ParseMACs(cfg.Tunnel.RelayMACs)
+	if err != nil {
+		return fatal("bad --relay-mac", "err", err)
+	}
+	var obfuscator obfs.Obfuscator
+	if cfg.Tunnel.RelayObfsSecret != "" {
+		obfuscator, err = obfs.NewPadded(cfg.Tunnel.RelayObfsSecret)
+		if err != nil {
+			return fatal("bad relay obfuscation", "err", err)
+		}
+	}
+	var relayTLS *tls.Config
+	if cfg.Tunnel.RelayTLSCA != "" {
+		pem, err := os.ReadFile(cfg.Tunnel.RelayTLSCA)
+		if err != nil {
+			return fatal("read relay TLS CA failed", "err", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fatal("no certificate in relay TLS CA", "file", cfg.Tunnel.RelayTLSCA)
+		}
+		relayTLS = &tls.Config{RootCAs: roots}
+	}
+
+	var hist *history.Store
+	if cfg.History.Dir != "" {
+		hist, err = history.Open(cfg.History.Dir, cfg.History.Interval, cfg.History.Retention)
+		if err != nil {
+			return fatal("open history failed", "err", err)
+		}
+		hist.MaxSeries = cfg.History.MaxSeries
+		hist.Start()
+	}
+
+	// Forward flags replace the forwards of the same kind from the config file.
+	if len(forwardSpecs) > 0 {
+		cfg.Forwards = slices.DeleteFunc(cfg.Forwards, func(f config.Forward) bool { return f.Listen != "" && !isUDP(f) })
+		for _, spec := range forwardSpecs {
+			name, addr := forward.ParseSpec(spec)
+			cfg.Forwards = append(cfg.Forwards, config.Forward{Name: name, Listen: addr})
+		}
+	}
+	if len(udpForwardSpecs) > 0 {
+		cfg.Forwards = slices.DeleteFunc(cfg.Forwards, func(f config.Forward) bool { return f.Listen != "" && isUDP(f) })
+		for _, spec := range udpForwardSpecs {
+			name, listen, target, err := forward.ParseUDPSpec(spec)
+			if err != nil {
+				return fatal("bad --forward-udp", "err", err)
+			}
+			cfg.Forwards = append(cfg.Forwards, config.Forward{Name: name, Listen: listen, Target: target, Network: forward.NetworkUDP})
+		}
+	}
+	if *forwardTarget != "" {
+		cfg.Forwards = slices.DeleteFunc(cfg.Forwards, func(f config.Forward) bool { return f.Listen == "" && !isUDP(f) })
+		name, addr := forward.ParseSpec(*forwardTarget)
+		cfg.Forwards = append(cfg.Forwards, config.Forward{Name: name, Target: addr})
+	}
+	if len(udpTargetSpecs) > 0 {
+		cfg.Forwards = slices.DeleteFunc(cfg.Forwards, func(f config.Forward) bool { return f.Listen == "" && isUDP(f) })
+		for _, spec := range udpTargetSpecs {
+			name, addr := forward.ParseSpec(spec)
+			cfg.Forwards = append(cfg.Forwards, config.Forward{Name: name, Target: addr, Network: forward.NetworkUDP})
+		}
+	}
+
+	if cfg.Tunnel.Mode == "" && !*printStatus {
+		return fatal("missing --mode")
+	}
+	if cfg.Identity.PrivateKeyFile == "" {
+		return fatal("missing --private-key")
 	}
 
 	// Load private key
-	priv, err := util.LoadOrCreatePrivateKey(*privKeyFile)
+	passphrase, err := keyfile.ReadPassphrase(cfg.Identity.PassphraseFile)
 	if err != nil {
-		log.Fatalf("load private key failed: %+v", err)
+		return fatal("read key passphrase failed", "err", err)
+	}
+	priv, err := identity.Load(context.Background(), cfg.Identity.PrivateKeyFile, identity.Options{Passphrase: passphrase})
+	if err != nil {
+		return fatal("load private key failed", "err", err)
+	}
+
+	profile, err := p2p.ProfileByName(cfg.Tunnel.Profile)
+	if err != nil {
+		return fatal("bad profile", "err", err)
 	}
 
 	// Build libp2p node
+	promMetrics := prom.New()
 	node := &p2p.Node{
 		PrivKey:    priv,
-		ListenPort: *listenPort,
+		ListenPort: cfg.Listen.Port,
+		Profile:    profile,
+		Metrics:    promMetrics,
+	}
+	node.ListenAddrs = cfg.P2P.ListenAddrs
+	node.Transports, err = p2p.ParseTransports(cfg.P2P.Transports)
+	if err != nil {
+		return fatal("bad transports", "err", err)
+	}
+	node.IPv6Only = cfg.P2P.IPv6Only
+	node.ReachabilityMode, err = p2p.ParseReachabilityMode(cfg.P2P.Reachability)
+	if err != nil {
+		return fatal("bad reachability", "err", err)
+	}
+	node.DisableAutoRelay = !cfg.P2P.AutoRelay
+	resolveCtx, cancelResolve := context.WithTimeout(parent, 30*time.Second)
+	node.StaticRelays, err = p2p.ResolvePeerList(resolveCtx, cfg.P2P.StaticRelays)
+	cancelResolve()
+	if err != nil {
+		return fatal("bad static relays", "err", err)
+	}
+	node.DHTMode, err = p2p.ParseDHTMode(cfg.P2P.DHTMode)
+	if err != nil {
+		return fatal("bad dht mode", "err", err)
+	}
+	node.DHTProtocolPrefix = cfg.P2P.DHTProtocolPrefix
+	node.ConnLimits = p2p.ConnLimits{
+		LowWater:    cfg.P2P.ConnLowWater,
+		HighWater:   cfg.P2P.ConnHighWater,
+		GracePeriod: cfg.P2P.ConnGracePeriod,
+	}
+	node.ResourceLimits = p2p.ResourceLimits{
+		Streams:        cfg.P2P.MaxStreams,
+		StreamsPerPeer: cfg.P2P.MaxStreamsPerPeer,
+		Memory:         cfg.P2P.MaxMemoryMB << 20,
+		FDs:            cfg.P2P.MaxFDs,
+	}
+	node.NoBootstrap = cfg.NoBootstrap
+	node.BootstrapPeers, err = p2p.ParseBootstrapPeers(cfg.Bootstrap)
+	if err != nil {
+		return fatal("bad bootstrap peers", "err", err)
 	}
 	if err := node.Init(); err != nil {
-		log.Fatalf("node initialize failed: %+v", err)
+		return fatal("node initialize failed", "err", err)
 	}
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Printf("Host ID: %s", node.Host.ID())
+	slog.Info("host started", logging.KeyPeer, node.Host.ID().String())
+	var listenAddrs []string
 	for _, a := range node.Host.Addrs() {
-		log.Printf("Listen on: %s/p2p/%s", a, node.Host.ID())
+		addr := fmt.Sprintf("%s/p2p/%s", a, node.Host.ID())
+		slog.Info("listening", "addr", addr)
+		listenAddrs = append(listenAddrs, addr)
+	}
+	logging.Event(logging.EventHost, "peer_id", node.Host.ID().String(), "addrs", listenAddrs)
+
+	if *printStatus {
+		select {
+		case <-time.After(*statusWait):
+		case <-ctx.Done():
+		}
+		if jsonOutput {
+			logging.Event(logging.EventResult, append([]any{"test", "status"}, natStatusAttrs(node.NATStatus())...)...)
+		} else {
+			printNATStatus(node.NATStatus())
+		}
+		if err := node.Close(); err != nil {
+			slog.Warn("close node failed", "err", err)
+		}
+		return 0
+	}
+	if cfg.Tunnel.StatusInterval > 0 {
+		go logNATStatus(ctx, node, cfg.Tunnel.StatusInterval)
+	}
+
+	if err := checkForwards(cfg.Forwards); err != nil {
+		return fatal("bad forward", "err", err)
+	}
+	forwards := &forward.Table{Metrics: promMetrics}
+	for _, fc := range cfg.Forwards {
+		f, err := configForward(fc, cfg.Tunnel.Remote)
+		if err != nil {
+			return fatal("bad forward", "err", err)
+		}
+		if err := forwards.Add(f); err != nil {
+			return fatal("bad forward", "err", err)
+		}
+	}
+	streams := &relay_client.StreamSet{}
+
+	var wgDev *wg.Device
+	if cfg.WireGuard.Interface != "" {
+		if wgDev, err = newWireGuard(cfg.WireGuard); err != nil {
+			return fatal("start WireGuard failed", "err", err)
+		}
+		slog.Info("wireguard device up", "interface", cfg.WireGuard.Interface, "public_key", wgDev.PublicKey().String())
+	}
+
+	var presence *mesh.Presence
+	if cfg.Mesh.ID != "" {
+		presence = mesh.New(node.Host, cfg.Mesh.ID)
+		presence.Role = "tunnel"
+		presence.Services = cfg.Tunnel.Advertise
+		presence.Discovery = node.Discovery()
+		presence.Interval = cfg.Mesh.AnnounceInterval
+		presence.MemberTTL = 4 * cfg.Mesh.AnnounceInterval
+		if err := presence.Start(ctx); err != nil {
+			return fatal("join mesh failed", "err", err)
+		}
+	}
+
+	var (
+		serverRole *relay_client.ServerRole
+		grants     *relay_client.Grants
+	)
+	if cfg.Tunnel.Mode == "server" {
+		allowPeers, err := parseAllowPeers(cfg.Tunnel.AllowPeers)
+		if err != nil {
+			return fatal("bad --allow-peer", "err", err)
+		}
+		if len(cfg.Tunnel.Grants) > 0 {
+			grants = &relay_client.Grants{}
+			for _, spec := range cfg.Tunnel.Grants {
+				g, err := parseGrant(spec, time.Now())
+				if err != nil {
+					return fatal("bad --grant", "grant", spec, "err", err)
+				}
+				grants.Add(g)
+				slog.Info("guest grant issued", "peer", g.Peer.String(), "service", g.Service, "expires", g.Expires, "max_bytes", g.MaxBytes)
+			}
+		}
+		var files *transfer.Server
+		if cfg.Tunnel.FileRoot != "" {
+			files = &transfer.Server{Root: cfg.Tunnel.FileRoot, ReadOnly: cfg.Tunnel.FileReadOnly}
+		}
+		execPeers, err := parseAllowPeers(cfg.Tunnel.ExecPeers)
+		if err != nil {
+			return fatal("bad --exec-allow-peer", "err", err)
+		}
+		serverRole = newServerRole(ctx, node, forwards, allowPeers, execPeers, cfg.Tunnel.ExecCommands, grants, streams, wgDev, files)
+		serverRole.MaxSessionsPerClient = cfg.Limits.MaxSessionsPerClient
+		serverRole.RequireSessionBinding = cfg.Tunnel.RequireSessionBinding
+		serverRole.Compression = cfg.Tunnel.Compression
+		serverRole.Obfuscator = obfuscator
+		serverRole.RelayTLSConfig = relayTLS
+		serverRole.RelayMACs = relayMACs
+		serverRole.IPv6Only = cfg.P2P.IPv6Only
+		serverRole.Metrics = promMetrics
+		if cfg.Policy.StartRelay != "" {
+			serverRole.Policy, err = policy.Compile(cfg.Policy.StartRelay, relay_client.PolicyAttrs...)
+			if err != nil {
+				return fatal("bad stream policy", "err", err)
+			}
+		}
+		if hist != nil {
+			handle := serverRole.Handler
+			serverRole.Handler = func(streamInfo *relay_client.StreamInfo, conn net.Conn) {
+				trackConn(hist, conn.(*relay_client.Conn), 0)
+				handle(streamInfo, conn)
+			}
+		}
+	}
+
+	if cfg.Tunnel.RelayTransport {
+		dialer := &relay_client.ClientRole{
+			Obfuscator:     obfuscator,
+			RelayTLSConfig: relayTLS,
+			RelayMACs:      relayMACs,
+			IPv6Only:       cfg.P2P.IPv6Only,
+		}
+		if _, err := relay_client.AddTransport(node.Host, dialer, serverRole); err != nil {
+			return fatal("register relay transport failed", "err", err)
+		}
 	}
 
-	switch *mode {
+	var monitor *p2p.LinkMonitor
+	if cfg.Tunnel.Mode == "client" && cfg.Tunnel.LinkInterval > 0 {
+		monitor = &p2p.LinkMonitor{
+			Node:       node,
+			Interval:   cfg.Tunnel.LinkInterval,
+			MaxRTT:     cfg.Tunnel.LinkMaxRTT,
+			MaxLoss:    cfg.Tunnel.LinkMaxLoss,
+			Reevaluate: cfg.Tunnel.LinkReevaluate,
+		}
+		go monitor.Run(ctx)
+	}
+
+	sources := []status.Source{node, forwards, streams, status.SourceFunc(func(s *status.Status) {
+		s.Versions.Protocols = []string{protocol.ProtoServerStartRelay, protocol.ProtoServerDirect, protocol.ProtoServerNotify, protocol.ProtoDialBack, protocol.ProtoInfo}
+		s.Versions.ProtocolVersion = relay_protocol.ProtocolVersion
+	})}
+	if presence != nil {
+		sources = append(sources, presence)
+	}
+	if monitor != nil {
+		sources = append(sources, monitor)
+	}
+	if serverRole != nil {
+		sources = append(sources, serverRole)
+	}
+	adminServer := admin.New()
+	if cfg.Listen.Admin != "" {
+		adminServer.AddLivenessCheck("host", node.CheckHost)
+		adminServer.Handle("/status", status.Handler("tunnel", sources...))
+		adminServer.Handle("/metrics", promMetrics.Handler())
+		if hist != nil {
+			adminServer.Handle("/history", hist.Handler())
+		}
+		if err := adminServer.Start(cfg.Listen.Admin); err != nil {
+			return fatal("admin server start failed", "err", err)
+		}
+	}
+	controlServer := &control.Server{Component: "tunnel", Sources: sources}
+	if cfg.Listen.Control != "" {
+		if cfg.Tunnel.Mode == "client" {
+			controlServer.Forwards = controlForwards{table: forwards, remote: cfg.Tunnel.Remote}
+		}
+		if err := controlServer.Start(cfg.Listen.Control); err != nil {
+			return fatal("control server start failed", "err", err)
+		}
+	}
+
+	remote := cfg.Tunnel.Remote
+	var reload func()
+	if config.PathFromArgs(os.Args[1:]) != "" {
+		reload = func() {
+			cfg = reloadConfig(ctx, reloader, cfg, node, forwards, remote, serverRole, grants)
+		}
+	}
+	code := 0
+	switch cfg.Tunnel.Mode {
 	case "server":
-		if *relayPeer == "" && *relayAddr == "" {
-			log.Fatal("server mode requires --relay-server-peer=<peerID> or --relay-server-addr=<multiaddr>")
+		if relay.Peer == "" && relay.Addr == "" {
+			return fatal("server mode requires --relay-server-peer=<peerID> or --relay-server-addr=<multiaddr>")
+		}
+		if err := runServerMode(ctx, node, serverRole, relay.Peer, relay.Addr, cfg.Tunnel.ResolveInterval, cfg.Tunnel.RelayNotify); err != nil {
+			return fatal("connect to relay-server failed", "err", err)
+		}
+		for _, name := range cfg.Tunnel.Advertise {
+			if err := node.AdvertiseService(name); err != nil {
+				return fatal("advertise service failed", "err", err)
+			}
 		}
-		runServerMode(ctx, node, *relayPeer, *relayAddr, *duration)
+		service.WaitReload(ctx, reload, node.CheckHost)
 	case "client":
-		if *remoteAddr == "" {
-			log.Fatal("client mode requires --remote=<multiaddr>")
+		if cfg.Tunnel.Remote == "" && cfg.Tunnel.Service == "" {
+			return fatal("client mode requires --remote=<multiaddr> or --service=<name>")
+		}
+		if cfg.Tunnel.Parallel < 1 {
+			return fatal("bad --parallel: must be at least 1", "parallel", cfg.Tunnel.Parallel)
+		}
+		if cfg.Tunnel.Test != "throughput" && cfg.Tunnel.Test != "latency" {
+			return fatal("bad --test: want throughput or latency", "test", cfg.Tunnel.Test)
+		}
+		if cfg.Tunnel.Bidir && cfg.Tunnel.Reverse {
+			return fatal("--bidir and --reverse are exclusive")
+		}
+		test := clientTest{
+			Name:      cfg.Tunnel.Test,
+			Duration:  cfg.Tunnel.Duration,
+			Direction: util.DirectionSend,
+			Parallel:  cfg.Tunnel.Parallel,
+			JSON:      jsonOutput,
+		}
+		switch {
+		case cfg.Tunnel.Bidir:
+			test.Direction = util.DirectionBidir
+		case cfg.Tunnel.Reverse || !cfg.Tunnel.Send:
+			test.Direction = util.DirectionReceive
+		}
+		strategy, err := relay_client.ParseDialStrategy(cfg.Tunnel.DialStrategy)
+		if err != nil {
+			return fatal("bad dial strategy", "err", err)
+		}
+		var notifyRelay peer.ID
+		if strategy == relay_client.DialNotify {
+			if relay.Peer == "" && relay.Addr == "" {
+				return fatal("--dial-strategy=notify requires --relay-server-peer=<peerID> or --relay-server-addr=<multiaddr>")
+			}
+			if notifyRelay, err = connectRelay(ctx, node, relay.Peer, relay.Addr); err != nil {
+				return fatal("connect to relay-server failed", "err", err)
+			}
+		}
+		clientRole := &relay_client.ClientRole{
+			PrivKey:               node.PrivKey,
+			Strategy:              strategy,
+			DirectHeadStart:       cfg.Tunnel.DirectHeadStart,
+			RelayRetries:          cfg.Tunnel.RelayRetries,
+			RequireSessionBinding: cfg.Tunnel.RequireSessionBinding,
+			Compression:           cfg.Tunnel.Compression,
+			Obfuscator:            obfuscator,
+			RelayTLSConfig:        relayTLS,
+			RelayMACs:             relayMACs,
+			IPv6Only:              cfg.P2P.IPv6Only,
+			NotifyRelay:           notifyRelay,
+			Priority:              priority,
+			Metrics:               promMetrics,
+		}
+		retry := p2p.RetryPolicy{
+			MaxAttempts:    cfg.Tunnel.ConnectAttempts,
+			InitialBackoff: cfg.Tunnel.ConnectBackoff,
+			MaxBackoff:     cfg.Tunnel.ConnectMaxBackoff,
+			Jitter:         cfg.Tunnel.ConnectJitter,
+			Deadline:       cfg.Tunnel.ConnectDeadline,
+		}
+		if err := retry.Validate(); err != nil {
+			return fatal("bad connect retry policy", "err", err)
+		}
+		daemon := cfg.Listen.Control != ""
+		if wgDev != nil && (daemon || len(forwards.Listening()) > 0) {
+			return fatal("--wireguard-interface and --forward or --control-socket are exclusive in client mode")
+		}
+		if (cp != nil || execArgv != nil) && (daemon || len(forwards.Listening()) > 0 || wgDev != nil) {
+			return fatal(flag.Arg(0) + " and --forward, --control-socket or --wireguard-interface are exclusive")
+		}
+		var exit exitCode
+		if err := runClientMode(ctx, node, clientRole, clientOptions{
+			Remote:          cfg.Tunnel.Remote,
+			Service:         cfg.Tunnel.Service,
+			ResolveInterval: cfg.Tunnel.ResolveInterval,
+			Retry:           retry,
+			Forwards:        forwards,
+			Daemon:          daemon,
+			WireGuard:       wgDev,
+			Copy:            cp,
+			Exec:            execArgv,
+			Stdio:           *stdio != "",
+			Test:            test,
+			Streams:         streams,
+			History:         hist,
+			Monitor:         monitor,
+		}); errors.As(err, &exit) {
+			code = int(exit)
+		} else if err != nil {
+			slog.Error("client failed", "err", err)
+			code = 1
+		} else if daemon || len(forwards.Listening()) > 0 {
+			service.WaitReload(ctx, reload, node.CheckHost)
 		}
-		runClientMode(ctx, node, *remoteAddr, *duration, *sendMode)
 	default:
-		log.Fatalf("unknown --mode: %s", *mode)
+		return fatal("unknown --mode", "mode", cfg.Tunnel.Mode)
 	}
-	select {}
+	stop()
+	slog.Info("shutting down", "drain_timeout", cfg.Limits.DrainTimeout)
+	service.Stopping()
+	controlServer.Stop()
+
+	node.Host.RemoveStreamHandler(protocol.ProtoServerStartRelay)
+	node.Host.RemoveStreamHandler(protocol.ProtoServerDirect)
+	node.Host.RemoveStreamHandler(protocol.ProtoInfo)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Limits.DrainTimeout)
+	defer cancel()
+	if err := forwards.Shutdown(drainCtx); err != nil {
+		slog.Warn("drain timeout exceeded, closed remaining connections", "err", err)
+		code = 1
+	}
+	if serverRole != nil {
+		if err := serverRole.Shutdown(drainCtx); err != nil {
+			slog.Warn("drain timeout exceeded, closed remaining sessions", "err", err)
+			code = 1
+		}
+	}
+	if wgDev != nil {
+		wgDev.Close()
+	}
+	adminServer.Stop()
+	if err := hist.Close(); err != nil {
+		slog.Warn("write history failed", "err", err)
+	}
+	if presence != nil {
+		presence.Stop()
+	}
+	if err := node.Close(); err != nil {
+		slog.Warn("close node failed", "err", err)
+	}
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("flush traces failed", "err", err)
+	}
+	slog.Info("stopped")
+	return code
 }
 
-// --------------- server mode -----------------
+// printNATStatus writes s to stdout.
+func printNATStatus(s p2p.NATStatus) {
+	addrs := func(l []ma.Multiaddr) string {
+		if len(l) == 0 {
+			return "-"
+		}
+		var out []string
+		for _, a := range l {
+			out = append(out, a.String())
+		}
+		return strings.Join(out, ", ")
+	}
+	relays := "-"
+	if len(s.Relays) > 0 {
+		var out []string
+		for _, id := range s.Relays {
+			out = append(out, id.String())
+		}
+		relays = strings.Join(out, ", ")
+	}
+	fmt.Printf("reachability:       %s\n", s.Reachability)
+	fmt.Printf("reachable addrs:    %s\n", addrs(s.ReachableAddrs))
+	fmt.Printf("unreachable addrs:  %s\n", addrs(s.UnreachableAddrs))
+	fmt.Printf("unconfirmed addrs:  %s\n", addrs(s.UnknownAddrs))
+	fmt.Printf("observed addrs:     %s\n", addrs(s.ObservedAddrs))
+	fmt.Printf("relay reservations: %s\n", relays)
+	hp := s.HolePunch
+	switch {
+	case hp.Attempts == 0:
+		fmt.Printf("hole punching:      not attempted\n")
+	case hp.LastError != "":
+		fmt.Printf("hole punching:      %d of %d succeeded, last failed %s ago: %s\n", hp.Successes, hp.Attempts, time.Since(hp.LastAttempt).Round(time.Second), hp.LastError)
+	default:
+		fmt.Printf("hole punching:      %d of %d succeeded, last succeeded %s ago\n", hp.Successes, hp.Attempts, time.Since(hp.LastSuccess).Round(time.Second))
+	}
+}
 
-func runServerMode(ctx context.Context, node *p2p.Node, relayPeerID string, relayMaddr string, duration int) {
-	var (
-		rpid peer.ID
-		err  error
-	)
+// logNATStatus logs the NAT status of node every interval until ctx is done.
+func logNATStatus(ctx context.Context, node *p2p.Node, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		slog.Info("nat status", natStatusAttrs(node.NATStatus())...)
+	}
+}
 
-	if relayMaddr != "" {
-		maddr, err := ma.NewMultiaddr(relayMaddr)
+// natStatusAttrs returns s as slog attributes.
+func natStatusAttrs(s p2p.NATStatus) []any {
+	return []any{
+		"reachability", s.Reachability.String(),
+		"reachable_addrs", s.ReachableAddrs,
+		"unreachable_addrs", s.UnreachableAddrs,
+		"observed_addrs", s.ObservedAddrs,
+		"relays", s.Relays,
+		"hole_punch_attempts", s.HolePunch.Attempts,
+		"hole_punch_successes", s.HolePunch.Successes,
+		"hole_punch_recent", s.HolePunch.SucceededRecently(),
+		"hole_punch_last_error", s.HolePunch.LastError,
+	}
+}
+
+// --------------- config reload -----------------
+
+// reloadable lists the keys of the config file a tunnel applies on SIGHUP.
+var reloadable = []string{
+	"forwards",
+	"relays",
+	"tunnel.allow_peers",
+	"tunnel.exec_peers",
+	"policy.start_relay",
+	"limits.max_sessions_per_client",
+	"limits.drain_timeout",
+	"logging.level",
+}
+
+// fatal logs msg and args as an error and returns the exit code of a failed
+// run. run returns rather than exits so that the Windows service manager sees
+// the service stop with that code.
+func fatal(msg string, args ...any) int {
+	slog.Error(msg, args...)
+	return 1
+}
+
+// reloadConfig reads the config file again and applies the reloadable keys that
+// changed: the forwards, with remote the peer of client forwards, and the
+// relay-server, peers, policy and limits of serverRole, if not nil. Streams
+// already open keep running. It returns the running config, cfg if the file is
+// invalid.
+func reloadConfig(ctx context.Context, reloader *config.Reloader, cfg *config.Config, node *p2p.Node, forwards *forward.Table, remote string, serverRole *relay_client.ServerRole, grants *relay_client.Grants) *config.Config {
+	fail := func(err error) *config.Config {
+		slog.Error("reload config failed", "err", err)
+		return cfg
+	}
+	res, err := reloader.Reload(cfg)
+	if err != nil {
+		return fail(err)
+	}
+	next := res.Config
+	if _, err := logging.ParseLevel(next.Logging.Level); err != nil {
+		return fail(err)
+	}
+	if err := checkForwards(next.Forwards); err != nil {
+		return fail(err)
+	}
+	for _, fc := range next.Forwards {
+		if _, err := configForward(fc, remote); err != nil {
+			return fail(err)
+		}
+	}
+	var settings relay_client.ServerSettings
+	if serverRole != nil {
+		settings = serverRole.Settings()
+		allowPeers, err := parseAllowPeers(next.Tunnel.AllowPeers)
 		if err != nil {
-			log.Fatalf("bad --relay-server-addr: %v", err)
+			return fail(fmt.Errorf("bad allow_peers: %w", err))
 		}
-		info, err := peer.AddrInfoFromP2pAddr(maddr)
+		execPeers, err := parseAllowPeers(next.Tunnel.ExecPeers)
 		if err != nil {
-			log.Fatalf("bad --relay-server-addr: %v", err)
+			return fail(fmt.Errorf("bad exec_peers: %w", err))
 		}
-		rpid = info.ID
-		connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-		if err := node.Host.Connect(connectCtx, *info); err != nil {
-			log.Fatalf("connect to relay-server failed: %v", err)
+		settings.Authorize = authorizer(allowPeers, execPeers, grants)
+		settings.MaxSessionsPerClient = next.Limits.MaxSessionsPerClient
+		if next.Policy.StartRelay != cfg.Policy.StartRelay {
+			settings.Policy = nil
+			if next.Policy.StartRelay != "" {
+				settings.Policy, err = policy.Compile(next.Policy.StartRelay, relay_client.PolicyAttrs...)
+				if err != nil {
+					return fail(fmt.Errorf("bad stream policy: %w", err))
+				}
+			}
+		}
+		if !slices.Equal(next.Relays, cfg.Relays) {
+			if len(next.Relays) == 0 {
+				return fail(errors.New("server mode requires a relay-server"))
+			}
+			settings.RelayPeerId, err = connectRelay(ctx, node, next.Relays[0].Peer, next.Relays[0].Addr)
+			if err != nil {
+				return fail(fmt.Errorf("connect to relay-server: %w", err))
+			}
+		}
+	}
+
+	if serverRole != nil {
+		serverRole.Reconfigure(settings)
+	}
+	reloadForwards(forwards, cfg.Forwards, next.Forwards, remote, next.Limits.DrainTimeout)
+	_ = logging.SetLevel(next.Logging.Level)
+	reloader.Accept(res)
+
+	for _, key := range res.Overridden {
+		slog.Warn("config change ignored, the command line sets it", "key", key)
+	}
+	if restart := res.Restart(reloadable...); len(restart) > 0 {
+		slog.Warn("config changes take effect after a restart", "keys", restart)
+	}
+	slog.Info("config reloaded", "changed", res.Changed)
+	return next
+}
+
+// reloadForwards replaces the forwards of the config file old with those of
+// next. The ones next drops or changes stop accepting at once and have drain
+// to finish their connections; the forwards added through the control socket
+// are left alone.
+func reloadForwards(forwards *forward.Table, old []config.Forward, next []config.Forward, remote string, drain time.Duration) {
+	for _, fc := range old {
+		if slices.Contains(next, fc) {
+			continue
+		}
+		f, err := forwards.Detach(forwardName(fc))
+		if err != nil {
+			// Removed through the control socket.
+			continue
+		}
+		slog.Info("forward removed", logging.KeyForward, f.Name)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), drain)
+			defer cancel()
+			if err := f.Shutdown(ctx); err != nil {
+				slog.Warn("drain timeout exceeded, closed remaining connections", logging.KeyForward, f.Name, "err", err)
+			}
+		}()
+	}
+	for _, fc := range next {
+		if slices.Contains(old, fc) {
+			continue
+		}
+		f, err := configForward(fc, remote)
+		if err == nil {
+			err = forwards.Add(f)
 		}
-	} else {
-		rpid, err = peer.Decode(relayPeerID)
 		if err != nil {
-			log.Fatalf("bad --relay-server-peer: %v", err)
+			slog.Error("add forward failed", logging.KeyForward, forwardName(fc), "err", err)
+			continue
 		}
-		// Attempt to connect using routed host (DHT) if possible
-		connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-		_ = node.Host.Connect(connectCtx, peer.AddrInfo{ID: rpid})
+		slog.Info("forward added", logging.KeyForward, f.Name)
+	}
+}
+
+// --------------- server mode -----------------
+
+func runServerMode(ctx context.Context, node *p2p.Node, serverRole *relay_client.ServerRole, relayPeerID string, relayMaddr string, resolveInterval time.Duration, notify bool) error {
+	rpid, err := connectRelay(ctx, node, relayPeerID, relayMaddr)
+	if err != nil {
+		return err
+	}
+	if p2p.IsDNSName(relayMaddr) {
+		node.KeepResolved(relayMaddr, nil, resolveInterval, nil)
+	}
+	serverRole.RelayPeerId = rpid
+	serverRole.RegisterProtocol(node.Host)
+	if notify {
+		go serveNotify(ctx, node, serverRole)
 	}
 
+	slog.Info("server ready, waiting for clients")
+	logging.Event(logging.EventReady, "relay_peer", rpid.String())
+	return nil
+}
+
+// notifyRetryInterval is the pause before registering again with the
+// relay-server after the registration of serveNotify failed.
+const notifyRetryInterval = 10 * time.Second
+
+// serveNotify keeps serverRole registered with its current relay-server for
+// the stream requests of clients until ctx ends.
+func serveNotify(ctx context.Context, node *p2p.Node, serverRole *relay_client.ServerRole) {
+	for {
+		relayPeer := serverRole.Settings().RelayPeerId
+		err := serverRole.ServeNotify(ctx, node.Host, relayPeer)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("relay-server notifications failed", logging.KeyPeer, relayPeer.String(), "err", err, "retry_in", notifyRetryInterval)
+		select {
+		case <-time.After(notifyRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connectRelay connects node to the relay-server at the multiaddr relayMaddr,
+// or else to the peer relayPeerID found through the DHT, and returns its peer
+// ID.
+func connectRelay(ctx context.Context, node *p2p.Node, relayPeerID string, relayMaddr string) (peer.ID, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if relayMaddr != "" {
+		infos, err := p2p.ResolvePeers(connectCtx, relayMaddr)
+		if err != nil {
+			return "", fmt.Errorf("bad relay-server addr: %w", err)
+		}
+		if len(infos) != 1 {
+			return "", fmt.Errorf("bad relay-server addr: %s names %d peers, want one", relayMaddr, len(infos))
+		}
+		info := infos[0]
+		if err := node.Host.Connect(connectCtx, info); err != nil {
+			return "", err
+		}
+		return info.ID, nil
+	}
+	rpid, err := peer.Decode(relayPeerID)
+	if err != nil {
+		return "", fmt.Errorf("bad relay-server peer: %w", err)
+	}
+	// Attempt to connect using routed host (DHT) if possible
+	_ = node.Host.Connect(connectCtx, peer.AddrInfo{ID: rpid})
+	return rpid, nil
+}
+
+// trackConn records the activity of conn in hist, for its remote peer and its
+// relay-server. latency is the time conn took to open, 0 if unknown.
+func trackConn(hist *history.Store, conn *relay_client.Conn, latency time.Duration) {
+	meta := conn.Meta()
+	series := []history.Series{{Kind: history.KindPeer, Key: meta.RemotePeer.String()}}
+	if meta.RelayEndpoint != "" {
+		series = append(series, history.Series{Kind: history.KindRelay, Key: meta.RelayEndpoint})
+	}
+	if latency > 0 {
+		for _, s := range series {
+			hist.Add(s, time.Now(), history.Sample{Latency: latency})
+		}
+	}
+	untrack := hist.Track(series, func() (uint64, uint64) {
+		stats := conn.Stats()
+		return stats.BytesRead, stats.BytesWritten
+	})
+	conn.OnClose(func(relay_client.ConnStats) {
+		untrack()
+	})
+}
+
+// newServerRole returns the server role carrying streams to the forward target
+// of forwards, or running the throughput test without one, and UDP flows to its
+// UDP targets, for the peers in allowPeers and those holding one of grants.
+// Without either, any peer is accepted. The streams it serves are kept in
+// streams. The streams carrying WireGuard are served by wgDev and the file
+// transfers by files, each if not nil. The commands of the peers in execPeers
+// are run, any command unless execCommands lists the programs allowed, until
+// ctx is done.
+func newServerRole(ctx context.Context, node *p2p.Node, forwards *forward.Table, allowPeers map[peer.ID]struct{}, execPeers map[peer.ID]struct{}, execCommands []string, grants *relay_client.Grants, streams *relay_client.StreamSet, wgDev *wg.Device, files *transfer.Server) *relay_client.ServerRole {
+	execs := &remoteexec.Server{}
 	serverRole := &relay_client.ServerRole{
-		PrivKey:     node.PrivKey,
-		RelayPeerId: rpid,
+		PrivKey: node.PrivKey,
 		Handler: func(streamInfo *relay_client.StreamInfo, conn net.Conn) {
+			if c, ok := conn.(*relay_client.Conn); ok {
+				streams.Add(c)
+				streamEvent(c.Meta())
+			}
+			switch streamInfo.Destination.ALPN {
+			case wg.ALPN:
+				if wgDev == nil {
+					_ = conn.Close()
+					return
+				}
+				if err := wgDev.Serve(context.Background(), conn); err != nil {
+					slog.Warn("wireguard stream failed", logging.KeyPeer, streamInfo.RemotePeerID.String(), "err", err)
+				}
+				return
+			case transfer.ALPN:
+				if files == nil {
+					_ = conn.Close()
+					return
+				}
+				if err := files.Serve(conn); err != nil {
+					slog.Warn("file transfer failed", logging.KeyPeer, streamInfo.RemotePeerID.String(), "err", err)
+				}
+				return
+			case remoteexec.ALPN:
+				if err := execs.Serve(ctx, conn, streamInfo.RemotePeerID); err != nil {
+					slog.Warn("command failed", logging.KeyPeer, streamInfo.RemotePeerID.String(), "err", err)
+				}
+				return
+			}
+			target, udpTargets := serverTargets(forwards.Forwards())
+			if streamInfo.Destination.ALPN == forward.UDPALPN {
+				t := udpTarget(udpTargets, streamInfo.Destination)
+				if t == nil {
+					_ = conn.Close()
+					return
+				}
+				// A socket per flow: the kernel maps its replies back.
+				local, err := net.Dial("udp", t.Target)
+				if err != nil {
+					t.Fail(err)
+					_ = conn.Close()
+					return
+				}
+				t.BridgePackets(local, conn)
+				return
+			}
+			if target != nil {
+				local, err := net.Dial("tcp", target.Target)
+				if err != nil {
+					target.Fail(err)
+					_ = conn.Close()
+					return
+				}
+				target.Bridge(local, conn)
+				return
+			}
 			defer conn.Close()
-			util.ReceiveAndMeasureTCP(conn, 10)
+			switch streamInfo.Destination.ALPN {
+			case util.LatencyALPN:
+				util.EchoLatency(conn)
+			case util.ThroughputALPN:
+				result, err := util.ServeThroughput(conn)
+				if err != nil {
+					slog.Warn("throughput test failed", "err", err)
+					return
+				}
+				slog.Info("throughput test done", "direction", result.Direction,
+					"sent_bytes", result.SentBytes, "received_bytes", result.ReceivedBytes, "seconds", result.Seconds)
+				logging.Event(logging.EventResult, "test", "throughput", "remote_peer", streamInfo.RemotePeerID.String(), "result", result)
+			default:
+				// Clients predating ThroughputALPN only send.
+				util.ReceiveAndMeasureTCP(conn, 10)
+			}
+		},
+		CheckDestination: func(dst relay_client.Destination) error {
+			switch {
+			case dst.ALPN == wg.ALPN && wgDev == nil, dst.ALPN == transfer.ALPN && files == nil:
+				return fmt.Errorf("%w: %s", relay_client.ErrUnknownTarget, dst)
+			case dst.ALPN == wg.ALPN, dst.ALPN == transfer.ALPN, dst.ALPN == remoteexec.ALPN:
+				return nil
+			}
+			target, udpTargets := serverTargets(forwards.Forwards())
+			return checkTarget(target, udpTargets, dst)
 		},
+		Authorize: authorizer(allowPeers, execPeers, grants),
+		Grants:    grants,
+	}
+	// Guest grants do not cover commands: consult Authorize again, as
+	// reconfigured, for the streams a grant admitted.
+	execs.Allow = func(p peer.ID, req *controlpb.ExecRequest) error {
+		if err := serverRole.Settings().Authorize(p, &controlpb.StartRelayStreamRequest{Alpn: remoteexec.ALPN}); err != nil {
+			return err
+		}
+		return allowCommand(execCommands, req)
 	}
+	return serverRole
+}
 
-	serverRole.RegisterProtocol(node.Host)
+// allowCommand checks req against the programs of execCommands, allowing any
+// command if empty. A listed program may not run with other environment
+// variables or in another working directory, which would change what it does.
+func allowCommand(execCommands []string, req *controlpb.ExecRequest) error {
+	if len(execCommands) == 0 {
+		return nil
+	}
+	if !slices.Contains(execCommands, req.GetArgv()[0]) {
+		return fmt.Errorf("program %s not allowed", req.GetArgv()[0])
+	}
+	if len(req.GetEnv()) > 0 || req.GetDir() != "" {
+		return errors.New("environment and working directory overrides not allowed")
+	}
+	return nil
+}
 
-	log.Printf("[server] ready. Waiting for clients...")
+// parseAllowPeers parses the peer IDs a server accepts streams from.
+func parseAllowPeers(list []string) (map[peer.ID]struct{}, error) {
+	allowPeers := make(map[peer.ID]struct{}, len(list))
+	for _, v := range list {
+		id, err := peer.Decode(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad peer %q: %w", v, err)
+		}
+		allowPeers[id] = struct{}{}
+	}
+	return allowPeers, nil
 }
 
-func runClientMode(ctx context.Context, node *p2p.Node, remote string, duration int, send bool) {
-	clientRole := &relay_client.ClientRole{
-		PrivKey: node.PrivKey,
+// authorizer returns the Authorize of a server accepting the peers in
+// allowPeers, any peer if empty unless grants is set, and the commands of the
+// peers in execPeers only.
+func authorizer(allowPeers map[peer.ID]struct{}, execPeers map[peer.ID]struct{}, grants *relay_client.Grants) func(peer.ID, *controlpb.StartRelayStreamRequest) error {
+	return func(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest) error {
+		if req.GetAlpn() == remoteexec.ALPN {
+			if _, ok := execPeers[clientPeer]; !ok {
+				return fmt.Errorf("%w: peer %s not allowed to run commands", relay_client.ErrUnauthorized, clientPeer)
+			}
+			return nil
+		}
+		if len(allowPeers) == 0 && grants == nil {
+			return nil
+		}
+		if _, ok := allowPeers[clientPeer]; !ok {
+			return fmt.Errorf("%w: peer %s not allowed", relay_client.ErrUnauthorized, clientPeer)
+		}
+		return nil
 	}
+}
+
+// streamEvent reports a stream opened to or by the remote peer of meta.
+func streamEvent(meta relay_client.ConnMeta) {
+	logging.Event(logging.EventStream, "remote_peer", meta.RemotePeer.String(), "path", meta.Path,
+		"relay_endpoint", meta.RelayEndpoint, "stream_id", meta.StreamID, "destination", meta.Destination.String())
+}
 
-	// Parse remote addr
-	maddr, err := ma.NewMultiaddr(remote)
+// parseGrant parses a guest grant spec, PEER,duration=D[,service=NAME]
+// [,max-bytes=SIZE], issued at now. SIZE is a byte count with an optional K, M,
+// G or T binary suffix.
+func parseGrant(spec string, now time.Time) (relay_client.Grant, error) {
+	var g relay_client.Grant
+	fields := strings.Split(spec, ",")
+	id, err := peer.Decode(fields[0])
 	if err != nil {
-		log.Fatalf("bad --remote: %v", err)
+		return g, fmt.Errorf("bad peer ID: %w", err)
 	}
-	info, err := peer.AddrInfoFromP2pAddr(maddr)
-	if err != nil {
-		log.Fatalf("bad --remote: %v", err)
+	g.Peer = id
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "duration":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return g, fmt.Errorf("bad duration %q", value)
+			}
+			g.Expires = now.Add(d)
+		case "service":
+			g.Service = value
+		case "max-bytes":
+			if g.MaxBytes, err = parseSize(value); err != nil {
+				return g, err
+			}
+		default:
+			return g, fmt.Errorf("unknown grant field %q", key)
+		}
+	}
+	if g.Expires.IsZero() {
+		return g, errors.New("missing duration")
 	}
+	return g, nil
+}
 
-	// Connect
-	connectCtx, cancel := context.WithTimeout(ctx, time.Minute)
-	defer cancel()
+// parseSize parses a byte count with an optional K, M, G or T binary suffix,
+// e.g. 512M.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	shift := 0
+	if i := strings.IndexAny(num, "KMGT"); i >= 0 && i == len(num)-1 {
+		shift = 10 * (1 + strings.IndexByte("KMGT", num[i]))
+		num = num[:i]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n << shift, nil
+}
 
-	var success bool
-	for i := 0; i < 5; i++ {
-		if err := node.Host.Connect(connectCtx, *info); err == nil {
-			log.Printf("[client] connected to %s", info.ID)
-			success = true
-			break
-		} else {
-			log.Printf("connect failed: %v", err)
+// checkTarget accepts the destinations target serves: the default one, any
+// service if target is unnamed, and its own service name and address. Without a
+// target only the default throughput test is served. UDP flows are accepted for
+// udpTargets only.
+func checkTarget(target *forward.Forward, udpTargets forward.Set, dst relay_client.Destination) error {
+	if dst.ALPN == forward.UDPALPN {
+		if udpTarget(udpTargets, dst) != nil {
+			return nil
 		}
-		time.Sleep(time.Second * 3)
+		return fmt.Errorf("%w: %s", relay_client.ErrUnknownTarget, dst)
 	}
-	if !success {
-		return
+	if dst.Service == "" && dst.Address == "" {
+		return nil
 	}
+	if target != nil && serves(target, dst) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", relay_client.ErrUnknownTarget, dst)
+}
+
+// serverTargets returns the forward target, the first forward neither listening
+// nor UDP, and the UDP targets among forwards.
+func serverTargets(forwards forward.Set) (target *forward.Forward, udpTargets forward.Set) {
+	for _, f := range forwards {
+		switch {
+		case f.ListenAddress != "":
+		case f.Network == forward.NetworkUDP:
+			udpTargets = append(udpTargets, f)
+		case target == nil:
+			target = f
+		}
+	}
+	return target, udpTargets
+}
+
+// udpTarget returns the first of targets serving dst, or nil.
+func udpTarget(targets forward.Set, dst relay_client.Destination) *forward.Forward {
+	for _, t := range targets {
+		if serves(t, dst) {
+			return t
+		}
+	}
+	return nil
+}
 
-	conn, err := clientRole.OpenStream(ctx, node.Host, info.ID)
+// serves reports whether target serves the service and address of dst, any
+// service if target is unnamed.
+func serves(target *forward.Forward, dst relay_client.Destination) bool {
+	return (dst.Service == "" || target.Service == "" || dst.Service == target.Service) &&
+		(dst.Address == "" || dst.Address == target.Target)
+}
+
+// forwardNetwork checks the network of a forward, tcp by default.
+func forwardNetwork(network string) (string, error) {
+	switch network = strings.ToLower(network); network {
+	case "":
+		return forward.NetworkTCP, nil
+	case forward.NetworkTCP, forward.NetworkUDP:
+		return network, nil
+	default:
+		return "", fmt.Errorf("bad network %q: want tcp or udp", network)
+	}
+}
+
+// checkForwards checks the forwards of a config file: a server has a single
+// forward target.
+func checkForwards(fcs []config.Forward) error {
+	targets := 0
+	for _, fc := range fcs {
+		if fc.Listen == "" && !isUDP(fc) {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return errors.New("server mode supports a single forward target")
+	}
+	return nil
+}
+
+// forwardName returns the name of the forward fc: its own, or else its address.
+func forwardName(fc config.Forward) string {
+	switch {
+	case fc.Name != "":
+		return fc.Name
+	case fc.Listen != "":
+		return fc.Listen
+	default:
+		return fc.Target
+	}
+}
+
+// configForward returns the forward fc of the config file: a client forward to
+// remote if it listens, see localForward, or else a server target.
+func configForward(fc config.Forward, remote string) (*forward.Forward, error) {
+	if fc.Listen != "" {
+		return localForward(fc, remote)
+	}
+	network, err := forwardNetwork(fc.Network)
 	if err != nil {
-		log.Fatalf("open stream failed: %+v", err)
+		return nil, fmt.Errorf("forward %s: %w", fc.Name, err)
 	}
+	return &forward.Forward{
+		Name:    forwardName(fc),
+		Network: network,
+		Target:  fc.Target,
+		Service: fc.Name,
+	}, nil
+}
+
+// localForward returns the client forward fc, listening on fc.Listen and
+// carrying its connections to remote, or its UDP flows to fc.Target.
+func localForward(fc config.Forward, remote string) (*forward.Forward, error) {
+	if fc.Listen == "" {
+		return nil, fmt.Errorf("forward %s: missing listen address", fc.Name)
+	}
+	network, err := forwardNetwork(fc.Network)
+	if err != nil {
+		return nil, fmt.Errorf("forward %s: %w", fc.Name, err)
+	}
+	name := forwardName(fc)
+	if _, err := relay_client.ParsePriority(fc.Priority); err != nil {
+		return nil, fmt.Errorf("forward %s: %w", name, err)
+	}
+	f := &forward.Forward{
+		Name:          name,
+		ListenAddress: fc.Listen,
+		Network:       network,
+		Target:        remote,
+		Service:       fc.Name,
+		Priority:      fc.Priority,
+	}
+	if network == forward.NetworkUDP {
+		if fc.Target == "" {
+			return nil, fmt.Errorf("UDP forward %s requires a target host:port", name)
+		}
+		f.Target = fc.Target
+	}
+	return f, nil
+}
+
+// controlForwards adds and removes the forwards of a client at runtime.
+type controlForwards struct {
+	table  *forward.Table
+	remote string
+}
+
+func (c controlForwards) AddForward(spec control.ForwardSpec) (status.ForwardInfo, error) {
+	f, err := localForward(config.Forward{Name: spec.Name, Listen: spec.Listen, Target: spec.Target, Network: spec.Network}, c.remote)
+	if err != nil {
+		return status.ForwardInfo{}, err
+	}
+	if err := c.table.Add(f); err != nil {
+		return status.ForwardInfo{}, err
+	}
+	return f.StatusInfo(), nil
+}
+
+func (c controlForwards) RemoveForward(ctx context.Context, name string) error {
+	return c.table.Remove(ctx, name)
+}
+
+// isUDP reports whether f forwards UDP.
+func isUDP(f config.Forward) bool {
+	return strings.EqualFold(f.Network, forward.NetworkUDP)
+}
+
+// latencyInterval is the pace of the frames of the latency test.
+const latencyInterval = 100 * time.Millisecond
+
+// clientTest is the test a client runs without forwards.
+type clientTest struct {
+	// Name is throughput or latency.
+	Name     string
+	Duration int
+	// Direction and Parallel shape the throughput test.
+	Direction util.Direction
+	Parallel  int
+	// JSON leaves the result to the EventResult event instead of printing it
+	// as text.
+	JSON bool
+}
+
+// clientOptions are what runClientMode connects to and runs there.
+type clientOptions struct {
+	// Remote is the peer to connect to, a multiaddr, a peer ID or a DNS name
+	// resolved every ResolveInterval. Empty means a provider of Service.
+	Remote          string
+	Service         string
+	ResolveInterval time.Duration
+	Retry           p2p.RetryPolicy
+	// Forwards are run if any listens, or if Daemon, also those added later.
+	Forwards *forward.Table
+	Daemon   bool
+	// Otherwise the first set of WireGuard, Copy, Exec and Stdio runs, or
+	// else Test.
+	WireGuard *wg.Device
+	Copy      *copyJob
+	Exec      []string
+	Stdio     bool
+	Test      clientTest
+	// Streams keeps the streams opened, and History, if set, records them.
+	Streams *relay_client.StreamSet
+	History *history.Store
+	// Monitor, if set, watches the links to the peer connected to.
+	Monitor *p2p.LinkMonitor
+}
+
+// runClientMode connects to the peer of opts and runs what opts asks for there:
+// the forwards, the WireGuard device, the file transfer or the command until
+// they end, stdio bridged to a stream until either side closes, or the
+// throughput or latency test to completion. Cancelling ctx aborts a running
+// test.
+func runClientMode(ctx context.Context, node *p2p.Node, clientRole *relay_client.ClientRole, opts clientOptions) error {
+	// Parse remote addr, or resolve the service name to its providers
+	var (
+		candidates []peer.AddrInfo
+		target     = opts.Service
+	)
+	if opts.Remote != "" && p2p.IsDNSName(opts.Remote) {
+		// A /dnsaddr or hostname, naming the peers in DNS TXT records that
+		// are followed as they change.
+		resolveCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		var err error
+		candidates, err = p2p.ResolvePeers(resolveCtx, opts.Remote)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("bad --remote: %w", err)
+		}
+		node.KeepResolved(opts.Remote, candidates, opts.ResolveInterval, nil)
+		slog.Info("remote resolved", "remote", opts.Remote, "peers", len(candidates))
+		target = opts.Remote
+	} else if opts.Remote != "" && !strings.HasPrefix(opts.Remote, "/") {
+		// A bare peer ID, its addresses found through the DHT on connect.
+		id, err := peer.Decode(opts.Remote)
+		if err != nil {
+			if strings.ToLower(opts.Remote) == opts.Remote {
+				// ssh lowercases %h, base58 peer IDs do not survive it.
+				return fmt.Errorf("bad --remote: lowercased peer ID? pass ssh %%n rather than %%h, or the base32 CID of the peer ID: %w", err)
+			}
+			return fmt.Errorf("bad --remote: %w", err)
+		}
+		candidates = []peer.AddrInfo{{ID: id}}
+		target = id.String()
+	} else if opts.Remote != "" {
+		maddr, err := ma.NewMultiaddr(opts.Remote)
+		if err != nil {
+			return fmt.Errorf("bad --remote: %w", err)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return fmt.Errorf("bad --remote: %w", err)
+		}
+		candidates = []peer.AddrInfo{*info}
+		target = info.ID.String()
+	} else {
+		resolveCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		var err error
+		candidates, err = node.FindService(resolveCtx, opts.Service)
+		cancel()
+		if err != nil {
+			return err
+		}
+		slog.Info("service resolved", "service", opts.Service, "providers", len(candidates))
+	}
+
+	// Log every connection to the server, so that relayed connections upgraded
+	// by hole punching show up.
+	node.Host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			for _, cand := range candidates {
+				if c.RemotePeer() == cand.ID {
+					slog.Info("connection opened", logging.KeyPeer, cand.ID.String(), "path", p2p.ConnPath(c), "addr", c.RemoteMultiaddr().String())
+				}
+			}
+		},
+	})
+
+	// Connect to the first candidate that answers
+	opts.Retry.OnAttempt = func(a p2p.RetryAttempt) {
+		args := []any{"target", target, "attempt", a.Attempt, "ok", a.Err == nil, "elapsed", a.Elapsed}
+		if a.Err != nil {
+			args = append(args, "err", a.Err.Error(), "backoff", a.Backoff)
+			slog.Warn("connect attempt failed", args...)
+		}
+		logging.Event(logging.EventConnect, args...)
+	}
+	var info peer.AddrInfo
+	if clientRole.Strategy == relay_client.DialNotify {
+		// The relay-server reaches the server for us.
+		if len(candidates) != 1 {
+			return fmt.Errorf("--dial-strategy=notify needs a --remote naming one peer, %s names %d", target, len(candidates))
+		}
+		info = candidates[0]
+	} else {
+		var err error
+		if info, err = node.ConnectAny(ctx, candidates, opts.Retry); err != nil {
+			return fmt.Errorf("connect to %s: %w", target, err)
+		}
+		slog.Info("connected", logging.KeyPeer, info.ID.String())
+	}
+	if opts.Monitor != nil {
+		opts.Monitor.Watch(info.ID)
+	}
+
+	openStream := func(ctx context.Context, dst relay_client.Destination) (*relay_client.Conn, error) {
+		started := time.Now()
+		conn, err := clientRole.OpenStream(ctx, node.Host, info.ID, dst)
+		if err != nil {
+			return nil, err
+		}
+		if opts.History != nil {
+			trackConn(opts.History, conn, time.Since(started))
+		}
+		opts.Streams.Add(conn)
+		streamEvent(conn.Meta())
+		return conn, nil
+	}
+
+	if opts.Daemon || len(opts.Forwards.Listening()) > 0 {
+		return opts.Forwards.Run(func(f *forward.Forward) error {
+			dst := relay_client.Destination{Service: f.Service}
+			// localForward checked the priority.
+			dst.Priority, _ = relay_client.ParsePriority(f.Priority)
+			if f.Network == forward.NetworkUDP {
+				dst.Address = f.Target
+				dst.ALPN = forward.UDPALPN
+			}
+			f.Dial = func(ctx context.Context) (net.Conn, error) {
+				conn, err := openStream(ctx, dst)
+				if err != nil {
+					return nil, err
+				}
+				return conn, nil
+			}
+			return f.Start(ctx)
+		})
+	}
+
+	if opts.WireGuard != nil {
+		serveWireGuard(ctx, opts.WireGuard, openStream)
+		return nil
+	}
+
+	if opts.Copy != nil {
+		conn, err := openStream(ctx, relay_client.Destination{ALPN: transfer.ALPN})
+		if err != nil {
+			return fmt.Errorf("open stream: %w", err)
+		}
+		defer conn.Close()
+		stopCopy := context.AfterFunc(ctx, func() {
+			_ = conn.Close()
+		})
+		defer stopCopy()
+		return runCopy(conn, *opts.Copy, opts.Test.JSON)
+	}
+
+	if opts.Exec != nil {
+		conn, err := openStream(ctx, relay_client.Destination{ALPN: remoteexec.ALPN})
+		if err != nil {
+			return fmt.Errorf("open stream: %w", err)
+		}
+		defer conn.Close()
+		stopExec := context.AfterFunc(ctx, func() {
+			_ = conn.Close()
+		})
+		defer stopExec()
+		code, err := remoteexec.Run(conn, &remoteexec.Command{Argv: opts.Exec, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr})
+		if err != nil {
+			return fmt.Errorf("exec: %w", err)
+		}
+		if code != 0 {
+			return exitCode(code)
+		}
+		return nil
+	}
+
+	if opts.Stdio {
+		conn, err := openStream(ctx, relay_client.Destination{})
+		if err != nil {
+			return fmt.Errorf("open stream: %w", err)
+		}
+		bridgeStdio(ctx, conn)
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Test.JSON {
+		out = io.Discard
+	}
+	if opts.Test.Name == "latency" {
+		conn, err := openStream(ctx, relay_client.Destination{ALPN: util.LatencyALPN})
+		if err != nil {
+			return fmt.Errorf("open stream: %w", err)
+		}
+		defer conn.Close()
+		stopTest := context.AfterFunc(ctx, func() {
+			_ = conn.Close()
+		})
+		defer stopTest()
+		stats := util.MeasureLatency(conn, opts.Test.Duration, latencyInterval, out)
+		logging.Event(logging.EventResult, "test", opts.Test.Name, "result", stats)
+		return nil
+	}
+
+	conns := make([]net.Conn, 0, opts.Test.Parallel)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	for range opts.Test.Parallel {
+		conn, err := openStream(ctx, relay_client.Destination{ALPN: util.ThroughputALPN})
+		if err != nil {
+			return fmt.Errorf("open stream: %w", err)
+		}
+		conns = append(conns, conn)
+		if err := util.RequestThroughput(conn, opts.Test.Direction, opts.Test.Duration); err != nil {
+			return fmt.Errorf("request throughput test: %w", err)
+		}
+	}
+	stopTest := context.AfterFunc(ctx, func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	defer stopTest()
 
 	// Throughput test over bridged TCP
-	if send {
-		util.SendAndMeasureTCP(conn, duration)
+	result := util.ParallelTCP(conns, opts.Test.Duration, opts.Test.Direction, out)
+	logging.Event(logging.EventResult, "test", opts.Test.Name, "result", result)
+	return nil
+}
+
+// copyJob is the file transfer of tunnel cp: Local is put to Path on the
+// remote peer, or fetched from it if not Put.
+type copyJob struct {
+	Put   bool
+	Local string
+	Path  string
+}
+
+// parseCopy parses the SRC and DST arguments of tunnel cp, one a local path
+// and the other REMOTE:PATH, and returns the job and REMOTE. As with cp, a
+// destination ending with a slash, or a local directory, gets the name of
+// the source.
+func parseCopy(src, dst string) (copyJob, string, error) {
+	srcRemote, srcPath, srcOK := splitRemote(src)
+	dstRemote, dstPath, dstOK := splitRemote(dst)
+	switch {
+	case srcOK && dstOK:
+		return copyJob{}, "", errors.New("both SRC and DST are remote")
+	case dstOK:
+		if dstPath == "" || strings.HasSuffix(dstPath, "/") {
+			dstPath += filepath.Base(src)
+		}
+		return copyJob{Put: true, Local: src, Path: dstPath}, dstRemote, nil
+	case srcOK:
+		if srcPath == "" || strings.HasSuffix(srcPath, "/") {
+			return copyJob{}, "", fmt.Errorf("%s names no file", src)
+		}
+		if fi, err := os.Stat(dst); (err == nil && fi.IsDir()) || strings.HasSuffix(dst, string(filepath.Separator)) {
+			dst = filepath.Join(dst, path.Base(srcPath))
+		}
+		return copyJob{Local: dst, Path: srcPath}, srcRemote, nil
+	default:
+		return copyJob{}, "", errors.New("neither SRC nor DST is REMOTE:PATH")
+	}
+}
+
+// splitRemote splits REMOTE:PATH, REMOTE being a peer ID, a hostname or a
+// multiaddr ending with /p2p/ID. ok is false for a local path: one without a
+// colon, with a slash before it as scp has it, or starting with a Windows
+// drive letter.
+func splitRemote(arg string) (remote string, p string, ok bool) {
+	start := 0
+	if strings.HasPrefix(arg, "/") {
+		start = strings.LastIndex(arg, "/p2p/")
+		if start < 0 {
+			return "", "", false
+		}
+	}
+	i := strings.Index(arg[start:], ":")
+	if i < 0 || start+i < 2 || (start == 0 && strings.ContainsAny(arg[:i], `/\`)) {
+		return "", "", false
+	}
+	return arg[:start+i], arg[start+i+1:], true
+}
+
+// exitCode is the non-zero exit code of a command run by tunnel exec, which
+// the tunnel exits with.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("command exited with code %d", int(c))
+}
+
+// progressInterval is the pace of the progress line of tunnel cp.
+const progressInterval = 500 * time.Millisecond
+
+// runCopy runs job over conn, showing its progress on stderr and printing its
+// result, or leaving it to the EventResult event with jsonOutput.
+func runCopy(conn net.Conn, job copyJob, jsonOutput bool) error {
+	var progress transfer.Progress
+	if !jsonOutput {
+		var last time.Time
+		progress = func(done, size int64) {
+			if done < size && time.Since(last) < progressInterval {
+				return
+			}
+			last = time.Now()
+			fmt.Fprintf(os.Stderr, "\r%8.2f / %.2f MB (%3.0f%%)", float64(done)/1e6, float64(size)/1e6, 100*float64(done)/float64(max(size, 1)))
+			if done == size {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+	op := "get"
+	var (
+		result transfer.Result
+		err    error
+	)
+	if job.Put {
+		op = "put"
+		result, err = transfer.Put(conn, job.Local, job.Path, progress)
 	} else {
-		util.ReceiveAndMeasureTCP(conn, duration)
+		result, err = transfer.Get(conn, job.Path, job.Local, progress)
+	}
+	if err != nil {
+		return fmt.Errorf("cp: %w", err)
+	}
+	if !jsonOutput {
+		fmt.Printf("✅ Copied %d bytes in %.1fs (%.2f MB/s), %d resumed\n", result.Size, result.Seconds, result.MBps, result.Resumed)
+	}
+	logging.Event(logging.EventResult, "test", "cp", "op", op, "result", result)
+	return nil
+}
+
+// wireguardRetryInterval is the pause before serveWireGuard opens a new stream
+// after the last one failed.
+const wireguardRetryInterval = 5 * time.Second
+
+// serveWireGuard carries the packets of dev over a stream opened with
+// openStream, opening a new one whenever the last one ends, until ctx ends.
+func serveWireGuard(ctx context.Context, dev *wg.Device, openStream func(context.Context, relay_client.Destination) (*relay_client.Conn, error)) {
+	for {
+		conn, err := openStream(ctx, relay_client.Destination{ALPN: wg.ALPN})
+		if err == nil {
+			err = dev.Serve(ctx, conn)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("wireguard stream ended", "err", err, "retry_in", wireguardRetryInterval)
+		select {
+		case <-time.After(wireguardRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newWireGuard creates the TUN interface of c and brings up a WireGuard
+// device over it.
+func newWireGuard(c config.WireGuard) (*wg.Device, error) {
+	cfg := wg.Config{Logger: slog.Default()}
+	var err error
+	if cfg.Routes, err = wg.ParsePrefixes(c.Routes); err != nil {
+		return nil, fmt.Errorf("bad --wireguard-route: %w", err)
+	}
+	if cfg.AllowedIPs, err = wg.ParsePrefixes(c.AllowedIPs); err != nil {
+		return nil, fmt.Errorf("bad --wireguard-allowed-ip: %w", err)
+	}
+	if c.PrivateKeyFile != "" {
+		if cfg.PrivateKey, err = wg.LoadKey(c.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("load WireGuard private key: %w", err)
+		}
+	}
+	tunDev, err := tun.CreateTUN(c.Interface, c.MTU)
+	if err != nil {
+		return nil, fmt.Errorf("create TUN interface %s: %w", c.Interface, err)
+	}
+	return wg.New(tunDev, cfg)
+}
+
+// bridgeStdio copies stdin to conn and conn to stdout until conn ends or ctx
+// is done, then closes conn. The end of stdin only stops the copy to conn:
+// the stream cannot be half-closed, and the remote end still answers. ssh
+// kills its ProxyCommand when done.
+func bridgeStdio(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		_, _ = io.Copy(conn, os.Stdin)
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(os.Stdout, conn)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
 	}
 }
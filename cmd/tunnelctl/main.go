@@ -0,0 +1,188 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// tunnelctl talks to a tunnel started with --control-socket: it adds and
+// removes forwards without restarting the tunnel, and lists its forwards and
+// open streams.
+//
+//	tunnelctl [-socket PATH] [-json] status
+//	tunnelctl [-socket PATH] [-json] forwards
+//	tunnelctl [-socket PATH] [-json] streams
+//	tunnelctl [-socket PATH] [-json] add [name=]localAddr
+//	tunnelctl [-socket PATH] [-json] add -U [name=][bind:]port:host:port
+//	tunnelctl [-socket PATH] remove NAME
+//
+// -socket defaults to the FLYMESH_CONTROL_SOCKET environment variable.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flymesh/core/pkg/control"
+	"github.com/flymesh/core/pkg/forward"
+	"github.com/flymesh/core/pkg/status"
+)
+
+const usage = `usage: tunnelctl [-socket PATH] [-json] COMMAND
+
+commands:
+  status                                   print the status of the tunnel
+  forwards                                 list the forwards
+  streams                                  list the open streams
+  add [name=]localAddr                     forward TCP connections to the remote peer
+  add -U [name=][bind:]port:host:port      forward UDP datagrams to host:port
+  remove NAME                              remove a forward, closing its connections
+
+-socket defaults to $FLYMESH_CONTROL_SOCKET.
+`
+
+// timeout bounds a command, which may wait for the connections of a removed
+// forward to drain.
+const timeout = 30 * time.Second
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("tunnelctl", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	socket := fs.String("socket", os.Getenv(control.SocketEnv), "control socket of the tunnel")
+	jsonOut := fs.Bool("json", false, "print JSON")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *socket == "" {
+		fmt.Fprintf(os.Stderr, "tunnelctl: no control socket: pass -socket or set %s\n", control.SocketEnv)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := control.NewClient(*socket)
+
+	var (
+		out any
+		err error
+	)
+	args = fs.Args()
+	switch args[0] {
+	case "status":
+		out, err = c.Status(ctx)
+	case "forwards":
+		out, err = c.Forwards(ctx)
+	case "streams":
+		out, err = c.Streams(ctx)
+	case "add":
+		var spec control.ForwardSpec
+		if spec, err = parseAdd(args[1:]); err == nil {
+			out, err = c.AddForward(ctx, spec)
+		}
+	case "remove":
+		if len(args) != 2 {
+			err = errors.New("remove takes a forward name")
+			break
+		}
+		err = c.RemoveForward(ctx, args[1])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tunnelctl:", err)
+		return 1
+	}
+	if out == nil {
+		return 0
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+		return 0
+	}
+	printText(out)
+	return 0
+}
+
+// parseAdd parses the arguments of add.
+func parseAdd(args []string) (control.ForwardSpec, error) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	udp := fs.Bool("U", false, "forward UDP: [name=][bind:]port:host:port")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return control.ForwardSpec{}, errors.New("add takes one forward spec")
+	}
+	if *udp {
+		name, listen, target, err := forward.ParseUDPSpec(fs.Arg(0))
+		if err != nil {
+			return control.ForwardSpec{}, err
+		}
+		return control.ForwardSpec{Name: name, Listen: listen, Target: target, Network: forward.NetworkUDP}, nil
+	}
+	name, listen := forward.ParseSpec(fs.Arg(0))
+	return control.ForwardSpec{Name: name, Listen: listen}, nil
+}
+
+// printText prints out as text.
+func printText(out any) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	switch v := out.(type) {
+	case *status.Status:
+		if v.Node != nil {
+			fmt.Fprintf(w, "peer id:\t%s\n", v.Node.PeerID)
+			fmt.Fprintf(w, "reachability:\t%s\n", v.Node.Reachability)
+			fmt.Fprintf(w, "connected peers:\t%d\n", v.Node.ConnectedPeers)
+		}
+		fmt.Fprintf(w, "forwards:\t%d\n", len(v.Forwards))
+		fmt.Fprintf(w, "streams:\t%d\n", len(v.Streams))
+		fmt.Fprintf(w, "sessions:\t%d\n", len(v.Sessions))
+		for _, l := range v.Links {
+			state := "ok"
+			if l.Degraded {
+				state = "degraded"
+			}
+			fmt.Fprintf(w, "%s link %s:\t%.1f ms avg, %.0f%% loss, %s\n", l.Kind, l.PeerID, l.AvgRTTMs, l.Loss*100, state)
+		}
+	case []status.ForwardInfo:
+		fmt.Fprintln(w, "NAME\tNETWORK\tLISTEN\tTARGET\tACTIVE\tCONNECTIONS\tTX\tRX\tERRORS")
+		for _, f := range v {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n", f.Name, network(f.Network), dash(f.Listen), dash(f.Target),
+				f.ActiveConnections, f.Connections, f.BytesTx, f.BytesRx, f.Errors)
+		}
+	case status.ForwardInfo:
+		fmt.Fprintf(w, "added %s: %s %s -> %s\n", v.Name, network(v.Network), v.Listen, v.Target)
+	case []status.StreamInfo:
+		fmt.Fprintln(w, "ID\tPEER\tPATH\tDESTINATION\tAGE\tREAD\tWRITTEN")
+		for _, s := range v {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\n", s.StreamID, s.RemotePeerID, s.Path, s.Destination,
+				time.Since(s.OpenedAt).Truncate(time.Second), s.BytesRead, s.BytesWritten)
+		}
+	}
+}
+
+func network(n string) string {
+	if n == "" {
+		return forward.NetworkTCP
+	}
+	return n
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
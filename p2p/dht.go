@@ -0,0 +1,56 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"fmt"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// DHTMode selects whether the node answers DHT queries from other peers.
+type DHTMode string
+
+const (
+	// DHTModeClient only queries the DHT. This is the default.
+	DHTModeClient DHTMode = "client"
+	// DHTModeServer always serves DHT queries, so the node becomes part of the
+	// routing network other nodes bootstrap from.
+	DHTModeServer DHTMode = "server"
+	// DHTModeAuto serves DHT queries while the node is publicly reachable.
+	DHTModeAuto DHTMode = "auto"
+)
+
+// ParseDHTMode parses client, server or auto.
+func ParseDHTMode(s string) (DHTMode, error) {
+	switch m := DHTMode(s); m {
+	case DHTModeClient, DHTModeServer, DHTModeAuto:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown dht mode %q (want client, server or auto)", s)
+	}
+}
+
+// dhtOptions returns the kad-dht options for the DHT settings of n.
+func (n *Node) dhtOptions() []dht.Option {
+	mode := dht.ModeClient
+	switch n.DHTMode {
+	case DHTModeServer:
+		mode = dht.ModeServer
+	case DHTModeAuto:
+		mode = dht.ModeAutoServer
+	}
+	opts := []dht.Option{
+		dht.Mode(mode),
+		dht.BootstrapPeers(n.BootstrapPeers...),
+	}
+	if n.DHTProtocolPrefix != "" {
+		opts = append(opts, dht.ProtocolPrefix(protocol.ID(n.DHTProtocolPrefix)))
+	}
+	if n.DHTDatastore != nil {
+		opts = append(opts, dht.Datastore(n.DHTDatastore))
+	}
+	return append(opts, n.Profile.dhtOptions()...)
+}
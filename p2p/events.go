@@ -0,0 +1,181 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerEvents are callbacks for the libp2p events of a Node. nil callbacks are
+// not subscribed. Callbacks run one at a time on a goroutine of the
+// subscription, so a slow callback delays the later events of its subscription
+// only.
+type PeerEvents struct {
+	// Connectedness reports a peer getting connected or disconnected.
+	Connectedness func(p peer.ID, c network.Connectedness)
+	// Reachability reports a change of the reachability found by AutoNAT.
+	Reachability func(r network.Reachability)
+	// Relays reports the circuit relays the node holds a reservation with,
+	// whenever that set changes. An empty list means no reservation is left.
+	Relays func(relays []peer.ID)
+	// Identified reports the end of the identify exchange with a peer: err is
+	// nil once its protocols and addresses are in the peerstore.
+	Identified func(p peer.ID, err error)
+}
+
+// types returns the event types of the non-nil callbacks.
+func (e PeerEvents) types() []any {
+	var types []any
+	if e.Connectedness != nil {
+		types = append(types, new(event.EvtPeerConnectednessChanged))
+	}
+	if e.Reachability != nil {
+		types = append(types, new(event.EvtLocalReachabilityChanged))
+	}
+	if e.Relays != nil {
+		types = append(types, new(event.EvtAutoRelayAddrsUpdated))
+	}
+	if e.Identified != nil {
+		types = append(types, new(event.EvtPeerIdentificationCompleted), new(event.EvtPeerIdentificationFailed))
+	}
+	return types
+}
+
+// dispatch calls the callback of evt.
+func (e PeerEvents) dispatch(evt any) {
+	switch evt := evt.(type) {
+	case event.EvtPeerConnectednessChanged:
+		e.Connectedness(evt.Peer, evt.Connectedness)
+	case event.EvtLocalReachabilityChanged:
+		e.Reachability(evt.Reachability)
+	case event.EvtAutoRelayAddrsUpdated:
+		relays := []peer.ID{}
+		for _, a := range evt.RelayAddrs {
+			if id, ok := circuitRelayPeer(a); ok && !containsPeer(relays, id) {
+				relays = append(relays, id)
+			}
+		}
+		e.Relays(relays)
+	case event.EvtPeerIdentificationCompleted:
+		e.Identified(evt.Peer, nil)
+	case event.EvtPeerIdentificationFailed:
+		err := evt.Reason
+		if err == nil {
+			err = errors.New("identify failed")
+		}
+		e.Identified(evt.Peer, err)
+	}
+}
+
+func containsPeer(ids []peer.ID, id peer.ID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe calls the callbacks of e for the events of the node until
+// unsubscribe is called or the node is closed. It must be called after Init.
+// Stateful events, such as the current reachability and relay set, are
+// delivered right away if already known.
+func (n *Node) Subscribe(e PeerEvents) (unsubscribe func(), err error) {
+	if n.Host == nil {
+		return nil, errors.New("host not initialized")
+	}
+	types := e.types()
+	if len(types) == 0 {
+		return func() {}, nil
+	}
+	sub, err := n.Host.EventBus().Subscribe(types)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(n.ctx)
+	n.goroutine(func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				e.dispatch(evt)
+			}
+		}
+	})
+	return cancel, nil
+}
+
+// PeerEventsChan carries the events of PeerEvents on channels, for select
+// loops. Sends block while the channel is full, which holds back the
+// other events of the subscription.
+type PeerEventsChan struct {
+	Connectedness chan ConnectednessEvent
+	Reachability  chan network.Reachability
+	Relays        chan []peer.ID
+	Identified    chan IdentifiedEvent
+}
+
+// ConnectednessEvent is a peer getting connected or disconnected.
+type ConnectednessEvent struct {
+	Peer          peer.ID
+	Connectedness network.Connectedness
+}
+
+// IdentifiedEvent is the end of the identify exchange with a peer.
+type IdentifiedEvent struct {
+	Peer peer.ID
+	Err  error
+}
+
+// SubscribeChan is Subscribe delivering events on the non-nil channels of c.
+// Pending sends are abandoned when unsubscribe is called or the node is closed.
+func (n *Node) SubscribeChan(c PeerEventsChan) (unsubscribe func(), err error) {
+	if n.Host == nil {
+		return nil, errors.New("host not initialized")
+	}
+	ctx, cancel := context.WithCancel(n.ctx)
+	var e PeerEvents
+	if c.Connectedness != nil {
+		e.Connectedness = func(p peer.ID, v network.Connectedness) {
+			send(ctx, c.Connectedness, ConnectednessEvent{Peer: p, Connectedness: v})
+		}
+	}
+	if c.Reachability != nil {
+		e.Reachability = func(r network.Reachability) { send(ctx, c.Reachability, r) }
+	}
+	if c.Relays != nil {
+		e.Relays = func(relays []peer.ID) { send(ctx, c.Relays, relays) }
+	}
+	if c.Identified != nil {
+		e.Identified = func(p peer.ID, err error) {
+			send(ctx, c.Identified, IdentifiedEvent{Peer: p, Err: err})
+		}
+	}
+	stop, err := n.Subscribe(e)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return func() {
+		cancel()
+		stop()
+	}, nil
+}
+
+func send[T any](ctx context.Context, c chan<- T, v T) {
+	select {
+	case c <- v:
+	case <-ctx.Done():
+	}
+}
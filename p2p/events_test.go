@@ -0,0 +1,160 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	testRelay = "12D3KooWLRPJAA5o6zfDNcEnJUWHA8FYhG9GLExsqDhVdxPA5ZUE"
+	testPeer  = "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"
+)
+
+// newEventNode returns a Node with a libp2p host that does not listen, and an
+// emitter for events of type evt on its bus.
+func newEventNode(t *testing.T, evt any) (*Node, event.Emitter) {
+	t.Helper()
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &Node{Host: h}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		n.cancel()
+		n.wg.Wait()
+		h.Close()
+	})
+	em, err := h.EventBus().Emitter(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { em.Close() })
+	return n, em
+}
+
+func TestPeerEventsDispatch(t *testing.T) {
+	relay := mustDecodePeer(t, testRelay)
+	p := mustDecodePeer(t, testPeer)
+	circuit := ma.StringCast("/ip4/192.0.2.1/tcp/4001/p2p/" + testRelay + "/p2p-circuit")
+	circuitQUIC := ma.StringCast("/ip4/192.0.2.1/udp/4001/quic-v1/p2p/" + testRelay + "/p2p-circuit")
+	failure := errors.New("stream reset")
+
+	var got []any
+	e := PeerEvents{
+		Connectedness: func(p peer.ID, c network.Connectedness) { got = append(got, ConnectednessEvent{p, c}) },
+		Reachability:  func(r network.Reachability) { got = append(got, r) },
+		Relays:        func(relays []peer.ID) { got = append(got, relays) },
+		Identified:    func(p peer.ID, err error) { got = append(got, IdentifiedEvent{p, err}) },
+	}
+	tests := []struct {
+		name string
+		evt  any
+		want any
+	}{
+		{
+			name: "connected",
+			evt:  event.EvtPeerConnectednessChanged{Peer: p, Connectedness: network.Connected},
+			want: ConnectednessEvent{p, network.Connected},
+		},
+		{
+			name: "reachability",
+			evt:  event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPublic},
+			want: network.ReachabilityPublic,
+		},
+		{
+			name: "relay addresses of one relay",
+			evt:  event.EvtAutoRelayAddrsUpdated{RelayAddrs: []ma.Multiaddr{circuit, circuitQUIC}},
+			want: []peer.ID{relay},
+		},
+		{
+			name: "no relay address left",
+			evt:  event.EvtAutoRelayAddrsUpdated{},
+			want: []peer.ID{},
+		},
+		{
+			name: "identified",
+			evt:  event.EvtPeerIdentificationCompleted{Peer: p},
+			want: IdentifiedEvent{p, nil},
+		},
+		{
+			name: "identify failed",
+			evt:  event.EvtPeerIdentificationFailed{Peer: p, Reason: failure},
+			want: IdentifiedEvent{p, failure},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			e.dispatch(tt.evt)
+			if len(got) != 1 || !reflect.DeepEqual(got[0], tt.want) {
+				t.Fatalf("dispatch() called back with %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerEventsTypes(t *testing.T) {
+	if types := (PeerEvents{}).types(); len(types) != 0 {
+		t.Fatalf("types() of no callback = %v", types)
+	}
+	e := PeerEvents{Identified: func(peer.ID, error) {}}
+	if types := e.types(); len(types) != 2 {
+		t.Fatalf("types() of Identified = %d types, want completed and failed", len(types))
+	}
+}
+
+func TestSubscribeChan(t *testing.T) {
+	n, em := newEventNode(t, new(event.EvtPeerConnectednessChanged))
+	p := mustDecodePeer(t, testPeer)
+	c := make(chan ConnectednessEvent)
+	unsubscribe, err := n.SubscribeChan(PeerEventsChan{Connectedness: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := em.Emit(event.EvtPeerConnectednessChanged{Peer: p, Connectedness: network.Connected}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case evt := <-c:
+		if evt.Peer != p || evt.Connectedness != network.Connected {
+			t.Fatalf("event = %+v", evt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	unsubscribe()
+	// A send blocked on the unread channel must not keep the node from closing.
+	em.Emit(event.EvtPeerConnectednessChanged{Peer: p, Connectedness: network.NotConnected})
+}
+
+func TestSubscribeBeforeInit(t *testing.T) {
+	if _, err := new(Node).Subscribe(PeerEvents{Reachability: func(network.Reachability) {}}); err == nil {
+		t.Fatal("Subscribe() before Init succeeded")
+	}
+	if _, err := new(Node).SubscribeChan(PeerEventsChan{}); err == nil {
+		t.Fatal("SubscribeChan() before Init succeeded")
+	}
+}
+
+func mustDecodePeer(t *testing.T, s string) peer.ID {
+	t.Helper()
+	id, err := peer.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
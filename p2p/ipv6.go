@@ -0,0 +1,60 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// isIPv4 reports whether a is reached over IPv4, circuit addresses through an
+// IPv4 relay included.
+func isIPv4(a ma.Multiaddr) bool {
+	return len(a) > 0 && a[0].Protocol().Code == ma.P_IP4
+}
+
+// withoutIPv4 returns addrs without their IPv4 addresses.
+func withoutIPv4(addrs []ma.Multiaddr) []ma.Multiaddr {
+	out := addrs[:0:0]
+	for _, a := range addrs {
+		if !isIPv4(a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// ipv6OnlyGater refuses every IPv4 connection of an IPv6-only node.
+type ipv6OnlyGater struct{}
+
+var _ connmgr.ConnectionGater = ipv6OnlyGater{}
+
+func (ipv6OnlyGater) InterceptPeerDial(peer.ID) bool { return true }
+
+func (ipv6OnlyGater) InterceptAddrDial(_ peer.ID, a ma.Multiaddr) bool { return !isIPv4(a) }
+
+func (ipv6OnlyGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return !isIPv4(addrs.RemoteMultiaddr())
+}
+
+func (ipv6OnlyGater) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (ipv6OnlyGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// ipv6OnlyOptions returns the libp2p options keeping a node off IPv4: it
+// neither dials, accepts nor announces IPv4 addresses.
+func ipv6OnlyOptions() []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.ConnectionGater(ipv6OnlyGater{}),
+		libp2p.AddrsFactory(withoutIPv4),
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// Defaults of the libp2p connection manager.
+const (
+	DefaultConnLowWater  = 160
+	DefaultConnHighWater = 192
+)
+
+// ConnLimits configures the libp2p connection manager, which trims connections
+// down to LowWater once there are more than HighWater. 0 fields use
+// DefaultConnLowWater and DefaultConnHighWater.
+type ConnLimits struct {
+	LowWater  int
+	HighWater int
+	// GracePeriod protects new connections from trimming. 0 uses one minute.
+	GracePeriod time.Duration
+}
+
+func (l ConnLimits) isZero() bool {
+	return l == ConnLimits{}
+}
+
+func (l ConnLimits) option() (libp2p.Option, error) {
+	if l.LowWater < 0 || l.HighWater < 0 || l.GracePeriod < 0 {
+		return nil, fmt.Errorf("negative connection limits")
+	}
+	if l.LowWater == 0 {
+		l.LowWater = DefaultConnLowWater
+	}
+	if l.HighWater == 0 {
+		l.HighWater = DefaultConnHighWater
+	}
+	if l.HighWater < l.LowWater {
+		return nil, fmt.Errorf("connection high water %d is below low water %d", l.HighWater, l.LowWater)
+	}
+	var opts []connmgr.Option
+	if l.GracePeriod > 0 {
+		opts = append(opts, connmgr.WithGracePeriod(l.GracePeriod))
+	}
+	mgr, err := connmgr.NewConnManager(l.LowWater, l.HighWater, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.ConnectionManager(mgr), nil
+}
+
+// ResourceLimits caps what the libp2p resource manager lets the node and each
+// peer reserve. Every 0 field keeps the default, which libp2p scales with the
+// memory and file descriptors of the host.
+type ResourceLimits struct {
+	// Streams caps the open streams of the node.
+	Streams int
+	// StreamsPerPeer caps the open streams of a single peer, so that one peer
+	// cannot take the whole Streams budget.
+	StreamsPerPeer int
+	// Memory caps the bytes reserved by all connections and streams.
+	Memory int64
+	// FDs caps the file descriptors used by connections.
+	FDs int
+}
+
+func (l ResourceLimits) isZero() bool {
+	return l == ResourceLimits{}
+}
+
+// limits returns the default limits with the non-zero fields of l applied.
+func (l ResourceLimits) limits() (rcmgr.ConcreteLimitConfig, error) {
+	if l.Streams < 0 || l.StreamsPerPeer < 0 || l.Memory < 0 || l.FDs < 0 {
+		return rcmgr.ConcreteLimitConfig{}, fmt.Errorf("negative resource limits")
+	}
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	partial := rcmgr.PartialLimitConfig{
+		System: rcmgr.ResourceLimits{
+			Streams: rcmgr.LimitVal(l.Streams),
+			Memory:  rcmgr.LimitVal64(l.Memory),
+			FD:      rcmgr.LimitVal(l.FDs),
+		},
+		PeerDefault: rcmgr.ResourceLimits{
+			Streams: rcmgr.LimitVal(l.StreamsPerPeer),
+		},
+	}
+	return partial.Build(scaling.AutoScale()), nil
+}
+
+func (l ResourceLimits) option() (libp2p.Option, error) {
+	limits, err := l.limits()
+	if err != nil {
+		return nil, err
+	}
+	mgr, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits))
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.ResourceManager(mgr), nil
+}
+
+// limitOptions returns the connection and resource manager options of the
+// non-zero limits of n.
+func (n *Node) limitOptions() ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+	if !n.ConnLimits.isZero() {
+		opt, err := n.ConnLimits.option()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if !n.ResourceLimits.isZero() {
+		opt, err := n.ResourceLimits.option()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnLimitsOption(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ConnLimits
+		ok     bool
+	}{
+		{name: "both water marks", limits: ConnLimits{LowWater: 400, HighWater: 500}, ok: true},
+		{name: "low water only", limits: ConnLimits{LowWater: 100}, ok: true},
+		{name: "high water only", limits: ConnLimits{HighWater: 1000}, ok: true},
+		{name: "grace period only", limits: ConnLimits{GracePeriod: time.Second}, ok: true},
+		{name: "equal water marks", limits: ConnLimits{LowWater: 10, HighWater: 10}, ok: true},
+		{name: "low water over default high water", limits: ConnLimits{LowWater: 500}},
+		{name: "high water below low water", limits: ConnLimits{LowWater: 20, HighWater: 10}},
+		{name: "negative", limits: ConnLimits{LowWater: -1, HighWater: 10}},
+		{name: "negative grace period", limits: ConnLimits{GracePeriod: -time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.limits.option()
+			if (err == nil) != tt.ok {
+				t.Fatalf("option() err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	defaults, err := ResourceLimits{}.limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ResourceLimits{Streams: 123, StreamsPerPeer: 7, Memory: 64 << 20, FDs: 99}
+	got, err := l.limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := got.ToPartialLimitConfig()
+	if v := int(partial.System.Streams); v != l.Streams {
+		t.Errorf("system streams = %d, want %d", v, l.Streams)
+	}
+	if v := int64(partial.System.Memory); v != l.Memory {
+		t.Errorf("system memory = %d, want %d", v, l.Memory)
+	}
+	if v := int(partial.System.FD); v != l.FDs {
+		t.Errorf("system FDs = %d, want %d", v, l.FDs)
+	}
+	if v := int(partial.PeerDefault.Streams); v != l.StreamsPerPeer {
+		t.Errorf("peer streams = %d, want %d", v, l.StreamsPerPeer)
+	}
+	if got, want := partial.System.Conns, defaults.ToPartialLimitConfig().System.Conns; got != want {
+		t.Errorf("system conns = %d, want the default %d", got, want)
+	}
+
+	if _, err := (ResourceLimits{Streams: -1}).limits(); err == nil {
+		t.Error("limits() accepted negative streams")
+	}
+}
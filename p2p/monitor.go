@@ -0,0 +1,353 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Kinds of monitored links.
+const (
+	// LinkPeer is a link to a watched peer.
+	LinkPeer = "peer"
+	// LinkRelay is a link to a circuit relay carrying a connection to a
+	// watched peer.
+	LinkRelay = "relay"
+)
+
+// Defaults of LinkMonitor.
+const (
+	DefaultLinkInterval = 10 * time.Second
+	DefaultLinkWindow   = 30
+)
+
+// minLinkSamples is how many pings a link needs before it can be degraded, so
+// that a single lost ping of a new link does not count as 100% loss.
+const minLinkSamples = 3
+
+// LinkMonitor pings the watched peers, and the circuit relays their connections
+// go through, with the PingService of a Node. It keeps the round-trip time and
+// loss of each link over a sliding window of pings, exports them as metrics and
+// status, and reports links crossing the MaxRTT or MaxLoss thresholds.
+type LinkMonitor struct {
+	Node *Node
+	// Interval is the time between two pings of a link. Defaults to
+	// DefaultLinkInterval.
+	Interval time.Duration
+	// Window is the number of pings the statistics are computed over. Defaults
+	// to DefaultLinkWindow.
+	Window int
+	// MaxRTT degrades a link whose average round-trip time is above it. 0
+	// disables.
+	MaxRTT time.Duration
+	// MaxLoss degrades a link losing a larger fraction of pings, 0 to 1. 0
+	// disables.
+	MaxLoss float64
+	// Reevaluate calls Node.ReevaluatePath for every watched peer when a link
+	// gets degraded, so that a relayed peer is tried over a direct path.
+	Reevaluate bool
+	// OnDegraded, if set, is called when a link gets degraded, once until it
+	// recovers. It runs on the monitor goroutine, delaying the next round.
+	OnDegraded func(LinkStats)
+
+	mu      sync.Mutex
+	watched map[peer.ID]struct{}
+	links   map[linkKey]*link
+}
+
+type linkKey struct {
+	peer peer.ID
+	kind string
+}
+
+type link struct {
+	// samples is a ring of the last pings; a zero RTT is a lost ping.
+	samples  []time.Duration
+	next     int
+	count    int
+	last     time.Duration
+	degraded bool
+}
+
+// LinkStats are the statistics of one link.
+type LinkStats struct {
+	Peer peer.ID
+	Kind string
+	// RTT is the round-trip time of the last successful ping.
+	RTT time.Duration
+	// AvgRTT is the average round-trip time of the successful pings of the
+	// window.
+	AvgRTT time.Duration
+	// Loss is the fraction of lost pings of the window.
+	Loss float64
+	// Samples is the number of pings in the window.
+	Samples  int
+	Degraded bool
+}
+
+func (m *LinkMonitor) interval() time.Duration {
+	if m.Interval <= 0 {
+		return DefaultLinkInterval
+	}
+	return m.Interval
+}
+
+func (m *LinkMonitor) window() int {
+	if m.Window <= 0 {
+		return DefaultLinkWindow
+	}
+	return m.Window
+}
+
+// Watch starts monitoring the link to p and to the relays of its connections.
+func (m *LinkMonitor) Watch(p peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.watched == nil {
+		m.watched = make(map[peer.ID]struct{})
+	}
+	m.watched[p] = struct{}{}
+}
+
+// Unwatch stops monitoring the link to p.
+func (m *LinkMonitor) Unwatch(p peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.watched, p)
+}
+
+// Run pings the links every Interval until ctx is done.
+func (m *LinkMonitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval())
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.round(ctx)
+		}
+	}
+}
+
+// Watched returns the watched peers.
+func (m *LinkMonitor) Watched() []peer.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers := make([]peer.ID, 0, len(m.watched))
+	for p := range m.watched {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// targets returns the links to ping: the watched peers and the circuit relays
+// of their connections.
+func (m *LinkMonitor) targets() []linkKey {
+	peers := m.Watched()
+	var keys []linkKey
+	relays := make(map[peer.ID]bool)
+	for _, p := range peers {
+		keys = append(keys, linkKey{p, LinkPeer})
+		for _, c := range m.Node.Host.Network().ConnsToPeer(p) {
+			if id, ok := circuitRelayPeer(c.RemoteMultiaddr()); ok && !relays[id] {
+				relays[id] = true
+				keys = append(keys, linkKey{id, LinkRelay})
+			}
+		}
+	}
+	return keys
+}
+
+// round pings every link once and updates the statistics.
+func (m *LinkMonitor) round(ctx context.Context) {
+	keys := m.targets()
+	rtts := make([]time.Duration, len(keys))
+	pctx, cancel := context.WithTimeout(ctx, m.interval()/2)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, k := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtts[i] = m.ping(pctx, k.peer)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	degraded := m.record(keys, rtts)
+	for _, st := range degraded {
+		if m.OnDegraded != nil {
+			m.OnDegraded(st)
+		}
+	}
+	if m.Reevaluate && len(degraded) > 0 {
+		m.reevaluate(ctx)
+	}
+}
+
+// record adds the ping round-trip times rtts of the links keys, 0 for a lost
+// ping, and returns the links that got degraded. Links missing from keys are
+// forgotten.
+func (m *LinkMonitor) record(keys []linkKey, rtts []time.Duration) []LinkStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var degraded []LinkStats
+	if m.links == nil {
+		m.links = make(map[linkKey]*link)
+	}
+	seen := make(map[linkKey]bool, len(keys))
+	for i, k := range keys {
+		seen[k] = true
+		l := m.links[k]
+		if l == nil {
+			l = &link{samples: make([]time.Duration, m.window())}
+			m.links[k] = l
+		}
+		l.add(rtts[i])
+		st := l.stats(k)
+		bad := st.Samples >= minLinkSamples &&
+			((m.MaxRTT > 0 && st.AvgRTT > m.MaxRTT) || (m.MaxLoss > 0 && st.Loss > m.MaxLoss))
+		switch {
+		case bad && !l.degraded:
+			l.degraded = true
+			st.Degraded = true
+			degraded = append(degraded, st)
+			m.Node.metrics().Counter(metrics.LinkDegradations, k.kind).Add(1)
+			m.Node.logger().Warn("link degraded", logging.KeyPeer, k.peer, "kind", k.kind, "avg_rtt", st.AvgRTT, "loss", st.Loss)
+		case !bad && l.degraded:
+			l.degraded = false
+			m.Node.logger().Info("link recovered", logging.KeyPeer, k.peer, "kind", k.kind, "avg_rtt", st.AvgRTT, "loss", st.Loss)
+		}
+		m.Node.metrics().Gauge(metrics.LinkRTT, k.peer.String(), k.kind).Set(st.AvgRTT.Seconds())
+		m.Node.metrics().Gauge(metrics.LinkLoss, k.peer.String(), k.kind).Set(st.Loss)
+	}
+	for k := range m.links {
+		if !seen[k] {
+			delete(m.links, k)
+			metrics.Delete(m.Node.metrics(), metrics.LinkRTT, k.peer.String(), k.kind)
+			metrics.Delete(m.Node.metrics(), metrics.LinkLoss, k.peer.String(), k.kind)
+		}
+	}
+	return degraded
+}
+
+// reevaluate looks for better paths to the watched peers, within an interval.
+func (m *LinkMonitor) reevaluate(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.interval())
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, p := range m.Watched() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Node.ReevaluatePath(ctx, p); err != nil {
+				m.Node.logger().Info("no better path", logging.KeyPeer, p, "err", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ping returns the round-trip time of one ping to p, or 0 if it was lost.
+func (m *LinkMonitor) ping(ctx context.Context, p peer.ID) time.Duration {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	res, ok := <-m.Node.PingService.Ping(ctx, p)
+	if !ok || res.Error != nil {
+		return 0
+	}
+	return max(res.RTT, time.Nanosecond)
+}
+
+func (l *link) add(rtt time.Duration) {
+	l.samples[l.next] = rtt
+	l.next = (l.next + 1) % len(l.samples)
+	l.count = min(l.count+1, len(l.samples))
+	if rtt > 0 {
+		l.last = rtt
+	}
+}
+
+func (l *link) stats(k linkKey) LinkStats {
+	st := LinkStats{Peer: k.peer, Kind: k.kind, RTT: l.last, Samples: l.count, Degraded: l.degraded}
+	var (
+		sum  time.Duration
+		lost int
+	)
+	for _, rtt := range l.samples[:l.count] {
+		if rtt == 0 {
+			lost++
+		}
+		sum += rtt
+	}
+	if l.count > lost {
+		st.AvgRTT = sum / time.Duration(l.count-lost)
+	}
+	if l.count > 0 {
+		st.Loss = float64(lost) / float64(l.count)
+	}
+	return st
+}
+
+// Stats returns the statistics of the monitored links, peers first.
+func (m *LinkMonitor) Stats() []LinkStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]LinkStats, 0, len(m.links))
+	for k, l := range m.links {
+		stats = append(stats, l.stats(k))
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind == LinkPeer
+		}
+		return stats[i].Peer < stats[j].Peer
+	})
+	return stats
+}
+
+// FillStatus implements status.Source.
+func (m *LinkMonitor) FillStatus(s *status.Status) {
+	for _, st := range m.Stats() {
+		s.Links = append(s.Links, status.LinkInfo{
+			PeerID:   st.Peer.String(),
+			Kind:     st.Kind,
+			RTTMs:    float64(st.RTT) / float64(time.Millisecond),
+			AvgRTTMs: float64(st.AvgRTT) / float64(time.Millisecond),
+			Loss:     st.Loss,
+			Samples:  st.Samples,
+			Degraded: st.Degraded,
+		})
+	}
+}
+
+// ReevaluatePath looks for a better path to p than its current connections.
+// When they are all relayed, it dials p directly, which libp2p does by hole
+// punching if p is behind a NAT, so that new streams take the direct path.
+func (n *Node) ReevaluatePath(ctx context.Context, p peer.ID) error {
+	for _, c := range n.Host.Network().ConnsToPeer(p) {
+		if ConnPath(c) == "direct" {
+			return nil
+		}
+	}
+	ctx = network.WithForceDirectDial(ctx, "link degraded")
+	if err := n.Host.Connect(ctx, peer.AddrInfo{ID: p}); err != nil {
+		return err
+	}
+	n.logger().Info("direct path found", logging.KeyPeer, p)
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		window int
+		rtts   []time.Duration
+		avg    time.Duration
+		last   time.Duration
+		loss   float64
+		count  int
+	}{
+		{name: "no ping", window: 4},
+		{name: "all answered", window: 4, rtts: []time.Duration{10 * ms, 20 * ms, 30 * ms}, avg: 20 * ms, last: 30 * ms, count: 3},
+		{name: "half lost", window: 4, rtts: []time.Duration{10 * ms, 0, 30 * ms, 0}, avg: 20 * ms, last: 30 * ms, loss: 0.5, count: 4},
+		{name: "all lost", window: 4, rtts: []time.Duration{0, 0}, loss: 1, count: 2},
+		{name: "window slides", window: 2, rtts: []time.Duration{0, 0, 40 * ms, 60 * ms}, avg: 50 * ms, last: 60 * ms, count: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &link{samples: make([]time.Duration, tt.window)}
+			for _, rtt := range tt.rtts {
+				l.add(rtt)
+			}
+			st := l.stats(linkKey{kind: LinkPeer})
+			if st.AvgRTT != tt.avg || st.RTT != tt.last || st.Loss != tt.loss || st.Samples != tt.count {
+				t.Fatalf("stats = %+v, want avg %v, last %v, loss %v, samples %d", st, tt.avg, tt.last, tt.loss, tt.count)
+			}
+		})
+	}
+}
+
+func TestLinkMonitorDegrades(t *testing.T) {
+	n := &Node{}
+	p := mustDecodePeer(t, testPeer)
+	relay := mustDecodePeer(t, testRelay)
+	m := &LinkMonitor{Node: n, Window: 4, MaxRTT: 200 * time.Millisecond, MaxLoss: 0.4}
+	keys := []linkKey{{p, LinkPeer}, {relay, LinkRelay}}
+
+	rounds := []struct {
+		peer, relay time.Duration
+		degraded    []linkKey
+	}{
+		{peer: 10 * time.Millisecond, relay: 0},
+		{peer: 10 * time.Millisecond, relay: 0},
+		// The third sample makes the relay loss count.
+		{peer: 10 * time.Millisecond, relay: 0, degraded: []linkKey{{relay, LinkRelay}}},
+		// Reported once until it recovers.
+		{peer: 500 * time.Millisecond, relay: 0},
+		{peer: 500 * time.Millisecond, relay: 0, degraded: []linkKey{{p, LinkPeer}}},
+	}
+	for i, r := range rounds {
+		got := m.record(keys, []time.Duration{r.peer, r.relay})
+		if len(got) != len(r.degraded) {
+			t.Fatalf("round %d: degraded %+v, want %v", i, got, r.degraded)
+		}
+		for j, st := range got {
+			if (linkKey{st.Peer, st.Kind}) != r.degraded[j] || !st.Degraded {
+				t.Fatalf("round %d: degraded %+v, want %v", i, st, r.degraded[j])
+			}
+		}
+	}
+	if stats := m.Stats(); len(stats) != 2 || stats[0].Kind != LinkPeer || !stats[0].Degraded || !stats[1].Degraded {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+
+	// A relay no longer carrying a connection is forgotten.
+	m.record(keys[:1], []time.Duration{10 * time.Millisecond})
+	if stats := m.Stats(); len(stats) != 1 || stats[0].Peer != p {
+		t.Fatalf("Stats() after the relay is gone = %+v", stats)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// RecentHolePunch is how long a successful hole punch counts as recent.
+const RecentHolePunch = 10 * time.Minute
+
+// NATStatus explains how the node can be reached, and so why connections to it
+// end up direct or relayed.
+type NATStatus struct {
+	// Reachability is the overall verdict, forced by ReachabilityMode or
+	// reported by AutoNAT.
+	Reachability network.Reachability
+	// ReachableAddrs, UnreachableAddrs and UnknownAddrs are the public addresses
+	// of the node as confirmed by AutoNAT v2.
+	ReachableAddrs   []ma.Multiaddr
+	UnreachableAddrs []ma.Multiaddr
+	UnknownAddrs     []ma.Multiaddr
+	// ObservedAddrs are the addresses peers reported seeing the node connect from.
+	ObservedAddrs []ma.Multiaddr
+	// Relays are the circuit relays holding a reservation for the node.
+	Relays    []peer.ID
+	HolePunch HolePunchStatus
+}
+
+// HolePunchStatus summarizes the hole punches attempted by the node.
+type HolePunchStatus struct {
+	Attempts  uint64
+	Successes uint64
+	// LastAttempt, LastPeer and LastError describe the latest finished hole
+	// punch. LastError is empty if it succeeded.
+	LastAttempt time.Time
+	LastPeer    peer.ID
+	LastError   string
+	// LastSuccess is when a hole punch last succeeded.
+	LastSuccess time.Time
+}
+
+// SucceededRecently reports whether a hole punch succeeded within RecentHolePunch.
+func (h HolePunchStatus) SucceededRecently() bool {
+	return !h.LastSuccess.IsZero() && time.Since(h.LastSuccess) < RecentHolePunch
+}
+
+// holePunchTracker records hole punch outcomes. It is the holepunch tracer of
+// the node.
+type holePunchTracker struct {
+	metrics metrics.Metrics
+
+	mu     sync.Mutex
+	status HolePunchStatus
+}
+
+func (t *holePunchTracker) Trace(evt *holepunch.Event) {
+	end, ok := evt.Evt.(*holepunch.EndHolePunchEvt)
+	if !ok {
+		return
+	}
+	result := "failure"
+	if end.Success {
+		result = "success"
+	}
+	metrics.Or(t.metrics).Counter(metrics.HolePunches, result).Add(1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Unix(0, evt.Timestamp)
+	t.status.Attempts++
+	t.status.LastAttempt = now
+	t.status.LastPeer = evt.Remote
+	t.status.LastError = end.Error
+	if end.Success {
+		t.status.Successes++
+		t.status.LastSuccess = now
+	}
+}
+
+func (t *holePunchTracker) get() HolePunchStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// NATStatus returns the current NAT status of the node.
+func (n *Node) NATStatus() NATStatus {
+	s := NATStatus{
+		Reachability: n.Reachability(),
+		HolePunch:    n.holePunch.get(),
+	}
+	if n.Host == nil {
+		return s
+	}
+	if h, ok := n.basicHost.(interface {
+		ConfirmedAddrs() ([]ma.Multiaddr, []ma.Multiaddr, []ma.Multiaddr)
+	}); ok {
+		s.ReachableAddrs, s.UnreachableAddrs, s.UnknownAddrs = h.ConfirmedAddrs()
+	}
+	if h, ok := n.basicHost.(interface{ IDService() identify.IDService }); ok {
+		s.ObservedAddrs = h.IDService().OwnObservedAddrs()
+	}
+	for _, a := range n.Host.Addrs() {
+		if relayID, ok := circuitRelayPeer(a); ok {
+			s.Relays = append(s.Relays, relayID)
+		}
+	}
+	return s
+}
+
+// natInfo converts s for the status document.
+func (s NATStatus) natInfo() *status.NATInfo {
+	info := &status.NATInfo{
+		ReachableAddrs:   addrStrings(s.ReachableAddrs),
+		UnreachableAddrs: addrStrings(s.UnreachableAddrs),
+		UnknownAddrs:     addrStrings(s.UnknownAddrs),
+		ObservedAddrs:    addrStrings(s.ObservedAddrs),
+		HolePunch: status.HolePunchInfo{
+			Attempts:          s.HolePunch.Attempts,
+			Successes:         s.HolePunch.Successes,
+			LastError:         s.HolePunch.LastError,
+			SucceededRecently: s.HolePunch.SucceededRecently(),
+		},
+	}
+	for _, id := range s.Relays {
+		info.Relays = append(info.Relays, id.String())
+	}
+	if !s.HolePunch.LastAttempt.IsZero() {
+		t := s.HolePunch.LastAttempt.UTC()
+		info.HolePunch.LastAttempt = &t
+	}
+	if !s.HolePunch.LastSuccess.IsZero() {
+		t := s.HolePunch.LastSuccess.UTC()
+		info.HolePunch.LastSuccess = &t
+	}
+	return info
+}
+
+func addrStrings(addrs []ma.Multiaddr) []string {
+	var out []string
+	for _, a := range addrs {
+		out = append(out, a.String())
+	}
+	return out
+}
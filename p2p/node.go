@@ -6,14 +6,24 @@ package p2p
 import (
 	"context"
 	crand "crypto/rand"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/ipfs/go-datastore"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/backoff"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
@@ -23,24 +33,103 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// ReachabilityMode selects how a Node decides whether it is publicly reachable.
+type ReachabilityMode string
+
+const (
+	// ReachabilityAuto lets AutoNAT probe the node's addresses.
+	ReachabilityAuto ReachabilityMode = "auto"
+	// ReachabilityPublic announces direct addresses and never relays.
+	ReachabilityPublic ReachabilityMode = "public"
+	// ReachabilityPrivate always reserves relay slots.
+	ReachabilityPrivate ReachabilityMode = "private"
+)
+
+// ParseReachabilityMode parses auto, public or private.
+func ParseReachabilityMode(s string) (ReachabilityMode, error) {
+	switch m := ReachabilityMode(s); m {
+	case ReachabilityAuto, ReachabilityPublic, ReachabilityPrivate:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown reachability %q (want auto, public or private)", s)
+	}
+}
+
 type Node struct {
-	Context        context.Context
-	PrivKey        crypto.PrivKey
-	Host           host.Host
+	Context context.Context
+	PrivKey crypto.PrivKey
+	Host    host.Host
+	// BootstrapPeers seed the DHT. If empty, DefaultBootstrapPeers are used
+	// unless NoBootstrap is set.
 	BootstrapPeers []peer.AddrInfo
-	DHT            *dht.IpfsDHT
-	PingService    *ping.PingService
+	// NoBootstrap runs the DHT without any bootstrap peer, for isolated networks.
+	NoBootstrap bool
+	DHT         *dht.IpfsDHT
+	PingService *ping.PingService
+
+	// DHTMode selects whether the node serves the DHT. Empty means DHTModeClient.
+	DHTMode DHTMode
+	// DHTProtocolPrefix replaces the "/ipfs" DHT protocol prefix, which keeps
+	// the routing table apart from the public IPFS network. Nodes of a private DHT
+	// need BootstrapPeers speaking the same prefix.
+	DHTProtocolPrefix string
+	// DHTDatastore stores DHT records. nil keeps them in memory.
+	DHTDatastore datastore.Batching
 
 	// ListenPort controls libp2p listen port for both TCP and QUIC (UDP).
 	// If 0, libp2p.DefaultListenAddrs are used.
 	ListenPort int
+	// ListenAddrs are explicit listen multiaddrs. They take precedence over ListenPort.
+	ListenAddrs []string
+	// Transports restricts the enabled transports. Empty enables the libp2p defaults.
+	Transports []Transport
+	// IPv6Only keeps the node off IPv4: it listens on IPv6 only, and neither
+	// dials, accepts nor announces IPv4 addresses.
+	IPv6Only bool
+
+	// Profile tunes background network activity. nil means DefaultProfile().
+	Profile *Profile
+
+	// ReachabilityMode overrides AutoNAT. Empty means ReachabilityPrivate.
+	ReachabilityMode ReachabilityMode
+	// DisableAutoRelay turns off circuit relay reservations.
+	DisableAutoRelay bool
+	// StaticRelays replaces the DHT-fed AutoRelay peer source with a fixed list.
+	StaticRelays []peer.AddrInfo
+
+	// ConnLimits tunes when the node trims connections. The zero value keeps the
+	// libp2p defaults.
+	ConnLimits ConnLimits
+	// ResourceLimits caps the streams, memory and file descriptors libp2p may
+	// use. The zero value keeps the libp2p defaults.
+	ResourceLimits ResourceLimits
 
 	UseCustomRelayConfig bool
 	Libp2pOptions        []libp2p.Option
 
-	ctx      context.Context
-	cancel   context.CancelFunc
-	peerChan chan peer.AddrInfo
+	Logger *slog.Logger
+	// Metrics receives the metrics of the node and of its LinkMonitor. If
+	// nil, they are dropped.
+	Metrics metrics.Metrics
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	peerChan     chan peer.AddrInfo
+	basicHost    host.Host
+	holePunch    holePunchTracker
+	reachability atomic.Int32
+	lastActive   atomic.Int64
+	wg           sync.WaitGroup
+	closeOnce    sync.Once
+	closeErr     error
+}
+
+func (n *Node) logger() *slog.Logger {
+	return logging.Component(n.Logger, "p2p")
+}
+
+func (n *Node) metrics() metrics.Metrics {
+	return metrics.Or(n.Metrics)
 }
 
 func (n *Node) Init() error {
@@ -50,6 +139,10 @@ func (n *Node) Init() error {
 		n.Context = context.Background()
 	}
 	n.ctx, n.cancel = context.WithCancel(n.Context)
+	if n.Profile == nil {
+		n.Profile = DefaultProfile()
+	}
+	n.Touch()
 
 	if n.PrivKey == nil {
 		n.PrivKey, _, err = crypto.GenerateEd25519Key(crand.Reader)
@@ -58,23 +151,35 @@ func (n *Node) Init() error {
 		}
 	}
 
+	n.holePunch.metrics = n.metrics()
 	opts := []libp2p.Option{
 		libp2p.Identity(n.PrivKey),
 		libp2p.UserAgent("p2ptest"),
-		libp2p.DefaultTransports,
 		libp2p.DefaultSecurity,
-		libp2p.DefaultMuxers,
 		libp2p.NATPortMap(),
 		//libp2p.EnableRelayService(relay.WithLimit(nil), relay.WithACL(acl)),
 		//libp2p.EnableNATService(),
 		libp2p.EnableAutoNATv2(),
 		libp2p.EnableHolePunching(
-			holepunch.WithTracer(&simpleTracer{}),
+			holepunch.WithTracer(&n.holePunch),
 		),
-		libp2p.ForceReachabilityPrivate(),
 		//libp2p.WithDialTimeout(time.Second*10),
 	}
-	if !n.UseCustomRelayConfig {
+	switch n.ReachabilityMode {
+	case "", ReachabilityPrivate:
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	case ReachabilityPublic:
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	case ReachabilityAuto:
+	default:
+		return fmt.Errorf("unknown reachability %q", n.ReachabilityMode)
+	}
+	if !n.UseCustomRelayConfig && !n.DisableAutoRelay && len(n.StaticRelays) > 0 {
+		opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(n.StaticRelays,
+			autorelay.WithNumRelays(min(2, len(n.StaticRelays))),
+			autorelay.WithBootDelay(5*time.Second),
+		))
+	} else if !n.UseCustomRelayConfig && !n.DisableAutoRelay {
 		peerChan := make(chan peer.AddrInfo)
 		n.peerChan = peerChan
 		opts = append(opts, libp2p.EnableAutoRelayWithPeerSource(
@@ -104,17 +209,21 @@ func (n *Node) Init() error {
 			autorelay.WithBootDelay(5*time.Second),
 		))
 	}
+	opts = append(opts, n.transportOptions()...)
+	if n.IPv6Only {
+		opts = append(opts, ipv6OnlyOptions()...)
+	}
+	opts = append(opts, n.Profile.libp2pOptions()...)
+	limitOpts, err := n.limitOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, limitOpts...)
 	opts = append(opts, n.Libp2pOptions...)
 	opts = append(opts, libp2p.FallbackDefaults)
 
-	// Listen addrs: if ListenPort specified, use the same port for TCP and QUIC (UDP)
-	if n.ListenPort > 0 {
-		addrs := []string{
-			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", n.ListenPort),
-			fmt.Sprintf("/ip6/::/tcp/%d", n.ListenPort),
-			fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", n.ListenPort),
-			fmt.Sprintf("/ip6/::/udp/%d/quic-v1", n.ListenPort),
-		}
+	// Listen addrs: explicit ListenAddrs, or ListenPort for every enabled transport
+	if addrs := n.listenAddrs(); addrs != nil {
 		opts = append(opts, libp2p.ListenAddrStrings(addrs...))
 	} else {
 		opts = append(opts, libp2p.DefaultListenAddrs)
@@ -125,34 +234,178 @@ func (n *Node) Init() error {
 		return err
 	}
 
+	if len(n.BootstrapPeers) == 0 && !n.NoBootstrap {
+		n.BootstrapPeers, err = DefaultBootstrapPeers()
+		if err != nil {
+			return err
+		}
+	}
+
 	if n.DHT == nil {
-		ddht, err := dht.New(
-			n.ctx,
-			basicHost,
-			dht.Mode(dht.ModeClient),
-			dht.BootstrapPeers(n.BootstrapPeers...),
-		)
+		ddht, err := dht.New(n.ctx, basicHost, n.dhtOptions()...)
 		if err != nil {
 			return err
 		}
 		n.DHT = ddht
 	}
 
+	n.basicHost = basicHost
 	n.Host = routedhost.Wrap(basicHost, n.DHT)
 
 	n.PingService = ping.NewPingService(n.Host)
 
-	if !n.UseCustomRelayConfig {
+	sub, err := n.Host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return err
+	}
+	n.goroutine(func() { n.trackReachability(sub) })
+
+	if n.Profile.DHTRefreshInterval > 0 {
+		n.goroutine(func() { n.refreshDHT(n.Profile.DHTRefreshInterval) })
+	}
+	if n.Profile.KeepAliveInterval > 0 {
+		n.goroutine(func() { n.keepAlive(n.Profile.KeepAliveInterval) })
+	}
+
+	if n.peerChan != nil {
 		// Continuously feed peers into the AutoRelay service
-		go n.autoRelayFeeder(n.peerChan)
+		n.goroutine(func() { n.autoRelayFeeder(n.peerChan) })
+	}
+
+	return nil
+}
+
+// Close stops the background goroutines of the node, then closes the DHT and the
+// host. It is safe to call more than once; later calls return the first result.
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		if n.cancel != nil {
+			n.cancel()
+		}
+		n.wg.Wait()
+		var errs []error
+		if n.DHT != nil {
+			if err := n.DHT.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close dht: %w", err))
+			}
+		}
+		if n.Host != nil {
+			if err := n.Host.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close host: %w", err))
+			}
+		}
+		if n.peerChan != nil {
+			close(n.peerChan)
+		}
+		n.closeErr = errors.Join(errs...)
+	})
+	return n.closeErr
+}
+
+// goroutine runs f in a goroutine that Close waits for.
+func (n *Node) goroutine(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+// CheckHost reports an error unless the libp2p host is up and listening.
+func (n *Node) CheckHost(ctx context.Context) error {
+	if n.Host == nil {
+		return errors.New("host not initialized")
 	}
+	if len(n.Host.Network().ListenAddresses()) == 0 {
+		return errors.New("host has no listen addresses")
+	}
+	return nil
+}
 
+// CheckDHT reports an error unless the DHT routing table has at least one peer.
+func (n *Node) CheckDHT(ctx context.Context) error {
+	if n.DHT == nil {
+		return errors.New("dht not initialized")
+	}
+	if n.DHT.RoutingTable().Size() == 0 {
+		return errors.New("dht not bootstrapped")
+	}
 	return nil
 }
 
+// Reachability returns the last reachability reported by AutoNAT.
+func (n *Node) Reachability() network.Reachability {
+	return network.Reachability(n.reachability.Load())
+}
+
+func (n *Node) trackReachability(sub event.Subscription) {
+	defer sub.Close()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			r := evt.(event.EvtLocalReachabilityChanged).Reachability
+			if network.Reachability(n.reachability.Swap(int32(r))) != r {
+				n.logger().Info("reachability changed", "reachability", r.String())
+			}
+		}
+	}
+}
+
+// FillStatus implements status.Source.
+func (n *Node) FillStatus(s *status.Status) {
+	if n.Host == nil {
+		return
+	}
+	info := &status.NodeInfo{
+		PeerID:         n.Host.ID().String(),
+		Reachability:   n.Reachability().String(),
+		ConnectedPeers: len(n.Host.Network().Peers()),
+		NAT:            n.NATStatus().natInfo(),
+	}
+	for _, a := range n.Host.Addrs() {
+		info.Addresses = append(info.Addresses, a.String())
+		if relayID, ok := circuitRelayPeer(a); ok {
+			s.Relays = append(s.Relays, status.RelayInfo{
+				PeerID:    relayID.String(),
+				Endpoints: []string{a.String()},
+				Kind:      "circuit-v2",
+			})
+		}
+	}
+	s.Node = info
+}
+
+// circuitRelayPeer returns the relay peer of a /p2p/<relay>/p2p-circuit address.
+func circuitRelayPeer(a ma.Multiaddr) (peer.ID, bool) {
+	var relayID peer.ID
+	for _, c := range a {
+		switch c.Protocol().Code {
+		case ma.P_P2P:
+			id, err := peer.IDFromBytes(c.RawValue())
+			if err == nil {
+				relayID = id
+			}
+		case ma.P_CIRCUIT:
+			return relayID, relayID != ""
+		}
+	}
+	return "", false
+}
+
 func (n *Node) autoRelayFeeder(peerChan chan peer.AddrInfo) {
-	delay := backoff.NewExponentialDecorrelatedJitter(time.Second, time.Second*60, 5.0, rand.NewSource(time.Now().UnixMilli()))()
+	delay := backoff.NewExponentialDecorrelatedJitter(time.Second, n.Profile.relayFeedMaxDelay(), 5.0, rand.NewSource(time.Now().UnixMilli()))()
 	for {
+		if n.Idle() {
+			if !n.sleep(delay.Delay()) {
+				return
+			}
+			continue
+		}
 		for _, p := range n.Host.Network().Peers() {
 			pi := n.Host.Network().Peerstore().PeerInfo(p)
 			relayCount := 0
@@ -165,16 +418,39 @@ func (n *Node) autoRelayFeeder(peerChan chan peer.AddrInfo) {
 				}
 			}
 			if relayCount < 2 {
-				peerChan <- pi
+				select {
+				case peerChan <- pi:
+				case <-n.ctx.Done():
+					return
+				}
 			}
 		}
-		time.Sleep(delay.Delay())
+		if !n.sleep(delay.Delay()) {
+			return
+		}
 	}
 }
 
-type simpleTracer struct {
+// sleep waits for d and reports false if the node was closed meanwhile.
+func (n *Node) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
 }
 
-func (s *simpleTracer) Trace(evt *holepunch.Event) {
-	//log.Printf("HOLEPUNCH EVENT: %+v\n", evt)
+// ConnPath describes how c reaches its peer: "relayed" through a circuit relay,
+// or "direct" (including connections upgraded by hole punching).
+func ConnPath(c network.Conn) string {
+	if c.Stat().Limited {
+		return "relayed"
+	}
+	if _, ok := circuitRelayPeer(c.RemoteMultiaddr()); ok {
+		return "relayed"
+	}
+	return "direct"
 }
@@ -0,0 +1,223 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Profile is a set of options that trade background network activity for
+// responsiveness. A nil Node.Profile behaves like DefaultProfile.
+type Profile struct {
+	// Name identifies the profile in logs and status output.
+	Name string
+
+	// DHTRefreshInterval replaces kad-dht's own routing table refresh schedule
+	// with a node driven one. 0 keeps the kad-dht defaults.
+	DHTRefreshInterval time.Duration
+
+	// KeepAliveInterval disables the per-connection yamux keepalive timers and
+	// instead pings every connected peer at once on a single shared timer, so the
+	// radio wakes up once per interval rather than once per connection.
+	// 0 keeps the yamux defaults.
+	KeepAliveInterval time.Duration
+
+	// PreferQUIC listens on QUIC only (unless ListenAddrs is set or QUIC is not
+	// an enabled transport) and delays TCP dials by TCPDialDelay.
+	// QUIC connections survive NAT rebinding and address changes of the client,
+	// which happen often when a phone moves between Wi-Fi and cellular.
+	PreferQUIC   bool
+	TCPDialDelay time.Duration
+
+	// IdleAfter pauses background discovery (AutoRelay candidate feeding and DHT
+	// refreshes) once the node has seen no activity for this long. Activity is
+	// an open /flymesh/ stream or a call to Node.Touch. 0 never pauses.
+	IdleAfter time.Duration
+
+	// RelayFeedMaxDelay caps the backoff between AutoRelay candidate rounds.
+	// 0 uses 60 seconds.
+	RelayFeedMaxDelay time.Duration
+}
+
+// DefaultProfile returns the profile used by servers and desktops.
+func DefaultProfile() *Profile {
+	return &Profile{
+		Name: "default",
+	}
+}
+
+// MobileProfile returns a battery-aware profile for Android and iOS.
+func MobileProfile() *Profile {
+	return &Profile{
+		Name:               "mobile",
+		DHTRefreshInterval: 30 * time.Minute,
+		KeepAliveInterval:  2 * time.Minute,
+		PreferQUIC:         true,
+		TCPDialDelay:       2 * time.Second,
+		IdleAfter:          5 * time.Minute,
+		RelayFeedMaxDelay:  10 * time.Minute,
+	}
+}
+
+// ProfileByName returns the named built-in profile.
+func ProfileByName(name string) (*Profile, error) {
+	switch name {
+	case "", "default":
+		return DefaultProfile(), nil
+	case "mobile":
+		return MobileProfile(), nil
+	default:
+		return nil, fmt.Errorf("unknown profile %q (want default or mobile)", name)
+	}
+}
+
+func (p *Profile) relayFeedMaxDelay() time.Duration {
+	if p.RelayFeedMaxDelay > 0 {
+		return p.RelayFeedMaxDelay
+	}
+	return 60 * time.Second
+}
+
+// libp2pOptions returns the host options implementing the profile.
+func (p *Profile) libp2pOptions() []libp2p.Option {
+	var opts []libp2p.Option
+	if p.KeepAliveInterval > 0 {
+		cfg := *yamux.DefaultTransport.Config()
+		cfg.EnableKeepAlive = false
+		opts = append(opts, libp2p.Muxer(yamux.ID, (*yamux.Transport)(&cfg)))
+	} else {
+		opts = append(opts, libp2p.DefaultMuxers)
+	}
+	if p.PreferQUIC {
+		opts = append(opts, libp2p.SwarmOpts(swarm.WithDialRanker(quicFirstDialRanker(p.TCPDialDelay))))
+	}
+	return opts
+}
+
+// dhtOptions returns the DHT options implementing the profile.
+func (p *Profile) dhtOptions() []dht.Option {
+	if p.DHTRefreshInterval <= 0 {
+		return nil
+	}
+	return []dht.Option{
+		dht.RoutingTableRefreshPeriod(p.DHTRefreshInterval),
+		dht.DisableAutoRefresh(),
+	}
+}
+
+// quicFirstDialRanker wraps swarm.DefaultDialRanker and pushes every non-QUIC
+// dial back by tcpDelay.
+func quicFirstDialRanker(tcpDelay time.Duration) network.DialRanker {
+	return func(addrs []ma.Multiaddr) []network.AddrDelay {
+		ranked := swarm.DefaultDialRanker(addrs)
+		for i, ad := range ranked {
+			if _, err := ad.Addr.ValueForProtocol(ma.P_QUIC_V1); err != nil {
+				ranked[i].Delay += tcpDelay
+			}
+		}
+		return ranked
+	}
+}
+
+// Touch records application activity, postponing the idle state of the profile.
+func (n *Node) Touch() {
+	n.lastActive.Store(time.Now().UnixNano())
+}
+
+// Idle reports whether background discovery is currently paused by the profile.
+func (n *Node) Idle() bool {
+	if n.Profile == nil || n.Profile.IdleAfter <= 0 || n.Host == nil {
+		return false
+	}
+	for _, c := range n.Host.Network().Conns() {
+		for _, s := range c.GetStreams() {
+			if strings.HasPrefix(string(s.Protocol()), "/flymesh/") {
+				n.Touch()
+				return false
+			}
+		}
+	}
+	return time.Since(time.Unix(0, n.lastActive.Load())) > n.Profile.IdleAfter
+}
+
+// refreshDHT refreshes the routing table on the profile's schedule, skipping
+// rounds while the node is idle.
+func (n *Node) refreshDHT(interval time.Duration) {
+	if !n.waitRefresh() {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+			if n.Idle() {
+				n.logger().Debug("dht refresh skipped while idle")
+				continue
+			}
+			if !n.waitRefresh() {
+				return
+			}
+		}
+	}
+}
+
+// waitRefresh runs one routing table refresh and reports false if the node was
+// closed before it finished.
+func (n *Node) waitRefresh() bool {
+	select {
+	case <-n.DHT.RefreshRoutingTable():
+		return true
+	case <-n.ctx.Done():
+		return false
+	}
+}
+
+// keepAlive pings every connected peer in one batch per interval.
+func (n *Node) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-t.C:
+			n.pingAll(interval / 2)
+		}
+	}
+}
+
+func (n *Node) pingAll(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(n.ctx, timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, p := range n.Host.Network().Peers() {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			pctx, pcancel := context.WithCancel(ctx)
+			defer pcancel()
+			res, ok := <-n.PingService.Ping(pctx, p)
+			if ok && res.Error != nil {
+				n.logger().Debug("keepalive ping failed", logging.KeyPeer, p, "err", res.Error)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
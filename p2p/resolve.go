@@ -0,0 +1,193 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// DefaultResolveInterval is how often KeepResolved resolves names again when
+// its interval is 0.
+const DefaultResolveInterval = 5 * time.Minute
+
+// maxDNSAddrDepth bounds the /dnsaddr records pointing at other /dnsaddr names.
+const maxDNSAddrDepth = 4
+
+// dnsResolver resolves /dnsaddr components. Tests replace it.
+var dnsResolver = madns.DefaultResolver
+
+// IsDNSName reports whether s names peers through DNS records that may change:
+// a multiaddr with a /dnsaddr component, or a hostname such as
+// relay.example.com, which stands for /dnsaddr/relay.example.com.
+func IsDNSName(s string) bool {
+	if strings.HasPrefix(s, "/") {
+		maddr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return false
+		}
+		_, err = maddr.ValueForProtocol(ma.P_DNSADDR)
+		return err == nil
+	}
+	return isHostname(s)
+}
+
+// isHostname reports whether s is a dotted hostname. Peer IDs have no dots.
+func isHostname(s string) bool {
+	if !strings.Contains(s, ".") || strings.HasPrefix(s, ".") || strings.Contains(s, "..") {
+		return false
+	}
+	for _, c := range strings.TrimSuffix(s, ".") {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ResolvePeers returns the peers named by s: a multiaddr ending in /p2p/ID,
+// a /dnsaddr multiaddr, or a hostname looked up as /dnsaddr/HOST in the TXT
+// records of _dnsaddr.HOST. /dnsaddr components are resolved, recursively;
+// /dns, /dns4 and /dns6 components are left for libp2p to resolve on dial.
+func ResolvePeers(ctx context.Context, s string) ([]peer.AddrInfo, error) {
+	if !strings.HasPrefix(s, "/") {
+		if !isHostname(s) {
+			return nil, fmt.Errorf("%q is neither a multiaddr nor a hostname", s)
+		}
+		s = "/dnsaddr/" + strings.TrimSuffix(s, ".")
+	}
+	maddr, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := resolveDNSAddr(ctx, maddr, 0)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", s, err)
+	}
+	var p2pAddrs []ma.Multiaddr
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(ma.P_P2P); err == nil {
+			p2pAddrs = append(p2pAddrs, a)
+		}
+	}
+	if len(p2pAddrs) == 0 {
+		return nil, fmt.Errorf("resolve %s: no /p2p address", s)
+	}
+	return peer.AddrInfosFromP2pAddrs(p2pAddrs...)
+}
+
+func resolveDNSAddr(ctx context.Context, maddr ma.Multiaddr, depth int) ([]ma.Multiaddr, error) {
+	if _, err := maddr.ValueForProtocol(ma.P_DNSADDR); err != nil {
+		return []ma.Multiaddr{maddr}, nil
+	}
+	if depth == maxDNSAddrDepth {
+		return nil, errors.New("too many nested /dnsaddr records")
+	}
+	resolved, err := dnsResolver.Resolve(ctx, maddr)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []ma.Multiaddr
+	for _, a := range resolved {
+		more, err := resolveDNSAddr(ctx, a, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, more...)
+	}
+	return addrs, nil
+}
+
+// ResolvePeerList is ResolvePeers for each entry of list, merging the
+// addresses of the same peer.
+func ResolvePeerList(ctx context.Context, list []string) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	for _, s := range list {
+		resolved, err := ResolvePeers(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range resolved {
+			i := slices.IndexFunc(infos, func(v peer.AddrInfo) bool { return v.ID == info.ID })
+			if i < 0 {
+				infos = append(infos, info)
+				continue
+			}
+			for _, a := range info.Addrs {
+				if !slices.ContainsFunc(infos[i].Addrs, a.Equal) {
+					infos[i].Addrs = append(infos[i].Addrs, a)
+				}
+			}
+		}
+	}
+	return infos, nil
+}
+
+// KeepResolved resolves s again with ResolvePeers every interval until the
+// node is closed, and adds the addresses found to the peerstore, where they
+// expire after two intervals unless found again. Connections to the peers of s
+// thus follow the DNS records. known are the peers s resolved to last, if any;
+// onChange, if set, is called with the peers whenever they differ from the last
+// ones.
+func (n *Node) KeepResolved(s string, known []peer.AddrInfo, interval time.Duration, onChange func([]peer.AddrInfo)) {
+	if interval <= 0 {
+		interval = DefaultResolveInterval
+	}
+	n.goroutine(func() {
+		last := known
+		for n.sleep(interval) {
+			ctx, cancel := context.WithTimeout(n.ctx, 30*time.Second)
+			infos, err := ResolvePeers(ctx, s)
+			cancel()
+			if err != nil {
+				if n.ctx.Err() == nil {
+					n.logger().Warn("resolve failed", "name", s, "err", err)
+				}
+				continue
+			}
+			for _, info := range infos {
+				n.Host.Peerstore().AddAddrs(info.ID, info.Addrs, 2*interval)
+			}
+			if samePeers(last, infos) {
+				continue
+			}
+			if last != nil {
+				n.logger().Info("resolved addresses changed", "name", s, "peers", len(infos))
+			}
+			last = infos
+			if onChange != nil {
+				onChange(infos)
+			}
+		}
+	})
+}
+
+// samePeers reports whether a and b hold the same peers with the same
+// addresses, in any order.
+func samePeers(a, b []peer.AddrInfo) bool {
+	key := func(infos []peer.AddrInfo) []string {
+		var keys []string
+		for _, info := range infos {
+			for _, addr := range info.Addrs {
+				keys = append(keys, info.ID.String()+addr.String())
+			}
+			if len(info.Addrs) == 0 {
+				keys = append(keys, info.ID.String())
+			}
+		}
+		slices.Sort(keys)
+		return keys
+	}
+	return slices.Equal(key(a), key(b))
+}
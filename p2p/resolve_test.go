@@ -0,0 +1,124 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+func TestIsDNSName(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"relay.example.com", true},
+		{"relay.example.com.", true},
+		{"/dnsaddr/relay.example.com", true},
+		{"/dnsaddr/relay.example.com/p2p/" + testRelay, true},
+		{"/dns4/relay.example.com/tcp/4001/p2p/" + testRelay, false},
+		{"/ip4/192.0.2.1/tcp/4001/p2p/" + testRelay, false},
+		{testRelay, false},
+		{"localhost", false},
+		{".example.com", false},
+		{"relay..example.com", false},
+		{"relay example.com", false},
+		{"/not-a-protocol/x", false},
+	}
+	for _, tt := range tests {
+		if got := IsDNSName(tt.s); got != tt.want {
+			t.Errorf("IsDNSName(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestResolvePeers(t *testing.T) {
+	relay := "/ip4/192.0.2.1/tcp/4001/p2p/" + testRelay
+	relayQUIC := "/ip4/192.0.2.1/udp/4001/quic-v1/p2p/" + testRelay
+	server := "/dns4/server.example.com/tcp/4001/p2p/" + testPeer
+	prev := dnsResolver
+	r, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		TXT: map[string][]string{
+			"_dnsaddr.relay.example.com":  {"dnsaddr=" + relay, "dnsaddr=" + relayQUIC, "v=spf1 -all"},
+			"_dnsaddr.mesh.example.com":   {"dnsaddr=/dnsaddr/relay.example.com", "dnsaddr=" + server},
+			"_dnsaddr.loop.example.com":   {"dnsaddr=/dnsaddr/loop.example.com"},
+			"_dnsaddr.nopeer.example.com": {"dnsaddr=/ip4/192.0.2.3/tcp/4001"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsResolver = r
+	t.Cleanup(func() { dnsResolver = prev })
+
+	tests := []struct {
+		name  string
+		s     string
+		peers map[string]int
+		fail  bool
+	}{
+		{name: "p2p multiaddr", s: relay, peers: map[string]int{testRelay: 1}},
+		{name: "dns4 left for dial", s: server, peers: map[string]int{testPeer: 1}},
+		{name: "hostname", s: "relay.example.com", peers: map[string]int{testRelay: 2}},
+		{name: "dnsaddr", s: "/dnsaddr/relay.example.com", peers: map[string]int{testRelay: 2}},
+		{name: "dnsaddr filtered by peer", s: "/dnsaddr/mesh.example.com/p2p/" + testPeer, peers: map[string]int{testPeer: 1}},
+		{name: "nested dnsaddr", s: "mesh.example.com", peers: map[string]int{testRelay: 2, testPeer: 1}},
+		{name: "nesting loop", s: "loop.example.com", fail: true},
+		{name: "no peer ID", s: "nopeer.example.com", fail: true},
+		{name: "no record", s: "missing.example.com", fail: true},
+		{name: "not a name", s: "relay", fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := ResolvePeers(context.Background(), tt.s)
+			if tt.fail {
+				if err == nil {
+					t.Fatalf("ResolvePeers() = %v, want an error", infos)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolvePeers() err = %v", err)
+			}
+			got := make(map[string]int)
+			for _, info := range infos {
+				got[info.ID.String()] = len(info.Addrs)
+			}
+			if len(got) != len(tt.peers) {
+				t.Fatalf("ResolvePeers() = %v, want %v", got, tt.peers)
+			}
+			for id, n := range tt.peers {
+				if got[id] != n {
+					t.Fatalf("ResolvePeers() = %v, want %v", got, tt.peers)
+				}
+			}
+		})
+	}
+
+	infos, err := ResolvePeerList(context.Background(), []string{relay, "relay.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || len(infos[0].Addrs) != 2 {
+		t.Fatalf("ResolvePeerList() = %v, want the two addresses of one relay", infos)
+	}
+}
+
+func TestSamePeers(t *testing.T) {
+	a, err := ResolvePeerList(context.Background(), []string{"/ip4/192.0.2.1/tcp/4001/p2p/" + testRelay, "/ip4/192.0.2.2/tcp/4001/p2p/" + testRelay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := []peer.AddrInfo{{ID: a[0].ID, Addrs: []ma.Multiaddr{a[0].Addrs[1], a[0].Addrs[0]}}}
+	if !samePeers(a, b) {
+		t.Error("samePeers() = false for reordered addresses")
+	}
+	if samePeers(a, []peer.AddrInfo{{ID: a[0].ID, Addrs: a[0].Addrs[:1]}}) {
+		t.Error("samePeers() = true with an address gone")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrNoCandidates is returned by ConnectAny without any peer to connect to.
+var ErrNoCandidates = errors.New("no peer to connect to")
+
+// RetryPolicy paces the attempts of an operation retried until it succeeds:
+// exponential backoff with jitter, bounded by a number of attempts and an
+// overall deadline.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts. 0 retries until the deadline or the
+	// context ends.
+	MaxAttempts int
+	// InitialBackoff is the pause after the first failed attempt. It is
+	// multiplied by Multiplier after each further one, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Jitter shortens or lengthens each pause by a random fraction of it up to
+	// Jitter, 0 to 1, so that clients restarted together do not retry in
+	// lockstep.
+	Jitter float64
+	// Deadline bounds the time of all attempts and pauses. 0 means none.
+	Deadline time.Duration
+	// OnAttempt, if set, is called after every attempt.
+	OnAttempt func(RetryAttempt)
+}
+
+// RetryAttempt is the outcome of one attempt of RetryPolicy.Do.
+type RetryAttempt struct {
+	// Attempt counts from 1.
+	Attempt int
+	// Err is nil if the attempt succeeded.
+	Err error
+	// Elapsed is the time since the first attempt started.
+	Elapsed time.Duration
+	// Backoff is the pause before the next attempt, 0 if there is none.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy returns 5 attempts paced by a backoff from 1s up to 30s,
+// with 20% jitter and no deadline.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Validate reports a policy that cannot be applied.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("negative max attempts %d", p.MaxAttempts)
+	case p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.Deadline < 0:
+		return errors.New("negative backoff or deadline")
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return fmt.Errorf("backoff multiplier %g is below 1", p.Multiplier)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter %g is not between 0 and 1", p.Jitter)
+	}
+	return nil
+}
+
+// backoff returns the pause after the failed attempt, counted from 1, with
+// jitter drawn from rnd, a float in [0, 1).
+func (p RetryPolicy) backoff(attempt int, rnd float64) time.Duration {
+	mult := p.Multiplier
+	if mult == 0 {
+		mult = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= mult
+		if p.MaxBackoff > 0 && d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	d += d * p.Jitter * (2*rnd - 1)
+	return time.Duration(d)
+}
+
+// Do calls f until it succeeds, the attempts are exhausted, the deadline passes
+// or ctx ends, pausing between attempts. f gets a context bounded by the
+// deadline and the attempt number, counted from 1. The error of a failure
+// wraps that of the last attempt.
+func (p RetryPolicy) Do(ctx context.Context, f func(ctx context.Context, attempt int) error) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	started := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := f(ctx, attempt)
+		res := RetryAttempt{Attempt: attempt, Err: err, Elapsed: time.Since(started)}
+		last := err == nil || (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) || ctx.Err() != nil
+		if !last {
+			res.Backoff = p.backoff(attempt, rand.Float64())
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(res.Backoff).After(deadline) {
+				res.Backoff = 0
+				last = true
+			}
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(res)
+		}
+		switch {
+		case err == nil:
+			return nil
+		case last && ctx.Err() != nil:
+			return fmt.Errorf("gave up after %d attempts in %s: %w (last error: %w)", attempt, res.Elapsed.Round(time.Millisecond), ctx.Err(), err)
+		case last && p.MaxAttempts > 0 && attempt >= p.MaxAttempts:
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		case last:
+			return fmt.Errorf("gave up after %d attempts, the deadline is too close for another: %w", attempt, err)
+		}
+		t := time.NewTimer(res.Backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("gave up after %d attempts: %w (last error: %w)", attempt, ctx.Err(), err)
+		}
+	}
+}
+
+// ConnectAny connects to the first of candidates that answers, trying them all
+// in order on every attempt of policy, and returns it.
+func (n *Node) ConnectAny(ctx context.Context, candidates []peer.AddrInfo, policy RetryPolicy) (peer.AddrInfo, error) {
+	if len(candidates) == 0 {
+		return peer.AddrInfo{}, ErrNoCandidates
+	}
+	var connected peer.AddrInfo
+	err := policy.Do(ctx, func(ctx context.Context, attempt int) error {
+		var errs []error
+		for _, cand := range candidates {
+			if err := n.Host.Connect(ctx, cand); err != nil {
+				n.logger().Warn("connect failed", logging.KeyPeer, cand.ID.String(), "attempt", attempt, "err", err)
+				errs = append(errs, fmt.Errorf("%s: %w", cand.ID, err))
+				continue
+			}
+			connected = cand
+			return nil
+		}
+		return errors.Join(errs...)
+	})
+	return connected, err
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Jitter: 0.5}
+	tests := []struct {
+		attempt int
+		rnd     float64
+		want    time.Duration
+	}{
+		{attempt: 1, rnd: 0.5, want: 100 * time.Millisecond},
+		{attempt: 2, rnd: 0.5, want: 200 * time.Millisecond},
+		{attempt: 4, rnd: 0.5, want: 800 * time.Millisecond},
+		{attempt: 5, rnd: 0.5, want: time.Second},
+		{attempt: 1000, rnd: 0.5, want: time.Second},
+		{attempt: 1, rnd: 0, want: 50 * time.Millisecond},
+		{attempt: 5, rnd: 0.999999, want: 1499999500 * time.Nanosecond},
+	}
+	for _, tt := range tests {
+		got := p.backoff(tt.attempt, tt.rnd)
+		if d := got - tt.want; d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("backoff(%d, %g) = %v, want %v", tt.attempt, tt.rnd, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	bad := []RetryPolicy{
+		{MaxAttempts: -1},
+		{InitialBackoff: -time.Second},
+		{Deadline: -time.Second},
+		{Multiplier: 0.5},
+		{Jitter: 1.5},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil", p)
+		}
+	}
+	if err := DefaultRetryPolicy().Validate(); err != nil {
+		t.Errorf("Validate(DefaultRetryPolicy()) = %v", err)
+	}
+}
+
+func TestRetryDo(t *testing.T) {
+	failure := errors.New("refused")
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		succeed  int
+		attempts int
+		err      error
+	}{
+		{name: "first attempt", policy: RetryPolicy{MaxAttempts: 3}, succeed: 1, attempts: 1},
+		{name: "third attempt", policy: RetryPolicy{MaxAttempts: 3}, succeed: 3, attempts: 3},
+		{name: "attempts exhausted", policy: RetryPolicy{MaxAttempts: 3}, attempts: 3, err: failure},
+		{name: "unbounded until success", policy: RetryPolicy{}, succeed: 20, attempts: 20},
+		{
+			name:     "deadline",
+			policy:   RetryPolicy{InitialBackoff: 10 * time.Millisecond, Multiplier: 10, Deadline: time.Second},
+			attempts: 3,
+			err:      failure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []RetryAttempt
+			tt.policy.OnAttempt = func(a RetryAttempt) { events = append(events, a) }
+			calls := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context, attempt int) error {
+				calls++
+				if attempt != calls {
+					t.Fatalf("attempt %d on call %d", attempt, calls)
+				}
+				if attempt == tt.succeed {
+					return nil
+				}
+				return failure
+			})
+			if tt.err == nil && err != nil {
+				t.Fatalf("Do() err = %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("Do() err = %v, want %v", err, tt.err)
+			}
+			if calls != tt.attempts || len(events) != tt.attempts {
+				t.Fatalf("%d calls and %d events, want %d", calls, len(events), tt.attempts)
+			}
+			last := events[len(events)-1]
+			if last.Backoff != 0 || (last.Err == nil) != (tt.err == nil) {
+				t.Fatalf("last event = %+v", last)
+			}
+			for _, e := range events[:len(events)-1] {
+				if e.Err == nil {
+					t.Fatalf("event %+v of a failed attempt has no error", e)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryDoCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := RetryPolicy{InitialBackoff: time.Hour}
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Do(ctx, func(context.Context, int) error { return errors.New("refused") })
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Do() err = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do() still sleeping after cancel")
+	}
+}
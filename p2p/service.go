@@ -0,0 +1,126 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+var ErrServiceNotFound = errors.New("service not found")
+
+// maxServiceNameLength bounds service names such as "office-nas/ssh".
+const maxServiceNameLength = 128
+
+// findServiceLimit caps the providers collected by FindService.
+const findServiceLimit = 16
+
+// advertiseRetry is the wait before retrying a failed advertisement.
+const advertiseRetry = 30 * time.Second
+
+// ValidateServiceName checks that name is non-empty, printable and free of
+// whitespace.
+func ValidateServiceName(name string) error {
+	if name == "" || len(name) > maxServiceNameLength {
+		return fmt.Errorf("bad service name %q: must be 1 to %d bytes", name, maxServiceNameLength)
+	}
+	if strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+		return fmt.Errorf("bad service name %q: must not contain whitespace or control characters", name)
+	}
+	return nil
+}
+
+// Discovery returns a libp2p discovery backed by the node's DHT.
+func (n *Node) Discovery() discovery.Discovery {
+	return drouting.NewRoutingDiscovery(n.DHT)
+}
+
+// serviceNamespace is the DHT provider namespace of a service name.
+func serviceNamespace(name string) string {
+	return "flymesh/service/" + name
+}
+
+// AdvertiseService publishes the node as a provider of name in the DHT and
+// keeps re-publishing it until the node is closed.
+func (n *Node) AdvertiseService(name string) error {
+	if err := ValidateServiceName(name); err != nil {
+		return err
+	}
+	n.logger().Info("advertising service", "service", name)
+	n.goroutine(func() { n.advertise(name) })
+	return nil
+}
+
+func (n *Node) advertise(name string) {
+	rd := n.Discovery()
+	for {
+		if err := n.waitRoutingTable(n.ctx); err != nil {
+			return
+		}
+		ttl, err := rd.Advertise(n.ctx, serviceNamespace(name))
+		if err != nil {
+			if n.ctx.Err() != nil {
+				return
+			}
+			n.logger().Warn("advertise service failed", "service", name, "err", err)
+			ttl = advertiseRetry
+		} else {
+			n.logger().Debug("service advertised", "service", name, "ttl", ttl)
+			ttl = 7 * ttl / 8
+		}
+		if !n.sleep(ttl) {
+			return
+		}
+	}
+}
+
+// waitRoutingTable waits until the DHT routing table has at least one peer,
+// since providing or finding records fails on an empty one.
+func (n *Node) waitRoutingTable(ctx context.Context) error {
+	for n.DHT.RoutingTable().Size() == 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// FindService looks up the peers advertising name, excluding the node itself.
+// It returns ErrServiceNotFound if there are none.
+func (n *Node) FindService(ctx context.Context, name string) ([]peer.AddrInfo, error) {
+	if err := ValidateServiceName(name); err != nil {
+		return nil, err
+	}
+	n.Touch()
+	if err := n.waitRoutingTable(ctx); err != nil {
+		return nil, fmt.Errorf("find service %s: empty DHT routing table: %w", name, err)
+	}
+	found, err := dutil.FindPeers(ctx, n.Discovery(), serviceNamespace(name), discovery.Limit(findServiceLimit))
+	if err != nil {
+		return nil, fmt.Errorf("find service %s: %w", name, err)
+	}
+	var out []peer.AddrInfo
+	for _, info := range found {
+		if info.ID != n.Host.ID() {
+			out = append(out, info)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	n.logger().Debug("service resolved", "service", name, logging.KeyPeer, out[0].ID.String(), "providers", len(out))
+	return out, nil
+}
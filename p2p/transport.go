@@ -0,0 +1,109 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package p2p
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+)
+
+// Transport names a libp2p transport that can be enabled on a Node.
+type Transport string
+
+const (
+	TransportTCP  Transport = "tcp"
+	TransportQUIC Transport = "quic"
+	TransportWS   Transport = "ws"
+)
+
+// ParseTransports parses transport names such as "tcp", "quic" and "ws".
+// Each element may itself be a comma separated list.
+func ParseTransports(names []string) ([]Transport, error) {
+	var out []Transport
+	for _, name := range names {
+		for _, t := range strings.Split(name, ",") {
+			t = strings.TrimSpace(t)
+			if t == "" {
+				continue
+			}
+			switch tr := Transport(t); tr {
+			case TransportTCP, TransportQUIC, TransportWS:
+				if !slices.Contains(out, tr) {
+					out = append(out, tr)
+				}
+			default:
+				return nil, fmt.Errorf("unknown transport %q (want tcp, quic or ws)", t)
+			}
+		}
+	}
+	return out, nil
+}
+
+// transportOptions returns the libp2p options enabling n.Transports, or the
+// libp2p default transports if none are selected.
+func (n *Node) transportOptions() []libp2p.Option {
+	if len(n.Transports) == 0 {
+		return []libp2p.Option{libp2p.DefaultTransports}
+	}
+	var opts []libp2p.Option
+	for _, t := range n.Transports {
+		switch t {
+		case TransportTCP:
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+		case TransportQUIC:
+			opts = append(opts, libp2p.Transport(quic.NewTransport))
+		case TransportWS:
+			opts = append(opts, libp2p.Transport(ws.New))
+		}
+	}
+	return opts
+}
+
+// listenAddrs returns the addresses the node listens on, or nil for the libp2p
+// defaults. ListenAddrs wins; otherwise one address per enabled transport and IP
+// stack, only IPv6 if IPv6Only, is derived from ListenPort. A websocket listener
+// is only derived when TCP is disabled, since both would need the same port.
+func (n *Node) listenAddrs() []string {
+	if len(n.ListenAddrs) > 0 {
+		return n.ListenAddrs
+	}
+	if n.ListenPort == 0 && len(n.Transports) == 0 && !n.Profile.PreferQUIC && !n.IPv6Only {
+		return nil
+	}
+
+	transports := n.Transports
+	if len(transports) == 0 {
+		transports = []Transport{TransportTCP, TransportQUIC}
+	}
+	if n.Profile.PreferQUIC && slices.Contains(transports, TransportQUIC) {
+		transports = []Transport{TransportQUIC}
+	}
+
+	var addrs []string
+	stacks := []string{"/ip4/0.0.0.0", "/ip6/::"}
+	if n.IPv6Only {
+		stacks = stacks[1:]
+	}
+	for _, ip := range stacks {
+		for _, t := range transports {
+			switch t {
+			case TransportTCP:
+				addrs = append(addrs, fmt.Sprintf("%s/tcp/%d", ip, n.ListenPort))
+			case TransportQUIC:
+				addrs = append(addrs, fmt.Sprintf("%s/udp/%d/quic-v1", ip, n.ListenPort))
+			case TransportWS:
+				if !slices.Contains(transports, TransportTCP) {
+					addrs = append(addrs, fmt.Sprintf("%s/tcp/%d/ws", ip, n.ListenPort))
+				}
+			}
+		}
+	}
+	return addrs
+}
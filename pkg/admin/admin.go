@@ -0,0 +1,152 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+)
+
+// Check reports the health of one subsystem. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Server is a small HTTP server exposing /healthz (liveness) and /readyz (readiness).
+type Server struct {
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	mu    sync.Mutex
+	live  []namedCheck
+	ready []namedCheck
+	mux   *http.ServeMux
+	srv   *http.Server
+	wg    sync.WaitGroup
+}
+
+func New() *Server {
+	s := &Server{
+		mux: http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, r, s.checks(false))
+	})
+	s.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, r, s.checks(true))
+	})
+	return s
+}
+
+func (s *Server) logger() *slog.Logger {
+	return logging.Component(s.Logger, "admin")
+}
+
+// AddLivenessCheck registers a check consulted by /healthz.
+// Liveness checks are also part of readiness.
+func (s *Server) AddLivenessCheck(name string, c Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live = append(s.live, namedCheck{name: name, check: c})
+}
+
+// AddReadinessCheck registers a check consulted by /readyz only.
+func (s *Server) AddReadinessCheck(name string, c Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = append(s.ready, namedCheck{name: name, check: c})
+}
+
+// Handle registers an additional handler on the admin mux.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start begins serving on listenAddress.
+func (s *Server) Start(listenAddress string) error {
+	if s.srv != nil {
+		return errors.New("already started")
+	}
+	ln, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return err
+	}
+	s.srv = &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.logger().Info("listening", "addr", ln.Addr().String())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger().Error("serve error", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down.
+func (s *Server) Stop() {
+	if s.srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+	s.wg.Wait()
+}
+
+func (s *Server) checks(readiness bool) []namedCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := append([]namedCheck(nil), s.live...)
+	if readiness {
+		out = append(out, s.ready...)
+	}
+	return out
+}
+
+type checkResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (s *Server) serveChecks(w http.ResponseWriter, r *http.Request, checks []namedCheck) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	resp := checkResponse{
+		Status: "ok",
+		Checks: make(map[string]string, len(checks)),
+	}
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			resp.Status = "fail"
+			resp.Checks[c.name] = err.Error()
+		} else {
+			resp.Checks[c.name] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(&resp)
+}
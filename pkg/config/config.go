@@ -0,0 +1,577 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/flymesh/core/pkg/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file shared by cmd/tunnel and cmd/relay-server.
+// Each command only reads the sections relevant to it. Command line flags
+// override values loaded from the file.
+type Config struct {
+	Identity Identity `yaml:"identity" toml:"identity"`
+	Listen   Listen   `yaml:"listen" toml:"listen"`
+	// Bootstrap lists DHT bootstrap multiaddrs. Empty uses the built-in defaults.
+	Bootstrap []string `yaml:"bootstrap" toml:"bootstrap"`
+	// NoBootstrap disables bootstrapping entirely, for isolated networks.
+	NoBootstrap bool      `yaml:"no_bootstrap" toml:"no_bootstrap"`
+	P2P         P2P       `yaml:"p2p" toml:"p2p"`
+	Relays      []Relay   `yaml:"relays" toml:"relays"`
+	Forwards    []Forward `yaml:"forwards" toml:"forwards"`
+	Limits      Limits    `yaml:"limits" toml:"limits"`
+	DialBack    DialBack  `yaml:"dial_back" toml:"dial_back"`
+	Policy      Policy    `yaml:"policy" toml:"policy"`
+	Dev         Dev       `yaml:"dev" toml:"dev"`
+	Mesh        Mesh      `yaml:"mesh" toml:"mesh"`
+	History     History   `yaml:"history" toml:"history"`
+	Logging     Logging   `yaml:"logging" toml:"logging"`
+	Tunnel      Tunnel    `yaml:"tunnel" toml:"tunnel"`
+	WireGuard   WireGuard `yaml:"wireguard" toml:"wireguard"`
+}
+
+type Identity struct {
+	// PrivateKeyFile is the path of the key file, created if missing, or a
+	// reference to another identity provider, keyring:[SERVICE/]ACCOUNT,
+	// env:VAR or exec:COMMAND.
+	PrivateKeyFile string `yaml:"private_key_file" toml:"private_key_file"`
+	// PassphraseFile holds the passphrase encrypting PrivateKeyFile. Empty
+	// falls back to the FLYMESH_KEY_PASSPHRASE environment variable, then to
+	// a plaintext key.
+	PassphraseFile string `yaml:"passphrase_file" toml:"passphrase_file"`
+}
+
+type Listen struct {
+	// Port is the libp2p listen port for TCP and QUIC.
+	Port int `yaml:"port" toml:"port"`
+	// Relay is the relay-server TCP data listen address.
+	Relay string `yaml:"relay" toml:"relay"`
+	// RelayUnix additionally accepts relay connections on this unix socket path.
+	RelayUnix string `yaml:"relay_unix" toml:"relay_unix"`
+	// RelayPublic is the relay endpoint handed to peers, e.g. the load balancer
+	// address. Empty means Relay.
+	RelayPublic string `yaml:"relay_public" toml:"relay_public"`
+	// RelayExtra lists further relay-server TCP listen addresses, e.g.
+	// "0.0.0.0:443", for peers whose egress allows few ports.
+	RelayExtra []string `yaml:"relay_extra" toml:"relay_extra"`
+	// RelayExtraPublic lists the endpoints handed to peers after RelayPublic.
+	// Empty means the RelayExtra addresses, on the host of RelayPublic for
+	// those of an unspecified IP.
+	RelayExtraPublic []string `yaml:"relay_extra_public" toml:"relay_extra_public"`
+	// ProxyProtocol requires a PROXY protocol v1/v2 header on relay connections.
+	ProxyProtocol bool `yaml:"proxy_protocol" toml:"proxy_protocol"`
+	// TrustedProxies lists the CIDRs allowed to send PROXY headers. Empty trusts all.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// RelayBindSources accepts a relay connection only from an IP the libp2p
+	// connection of its peer to the relay-server comes from.
+	RelayBindSources bool `yaml:"relay_bind_sources" toml:"relay_bind_sources"`
+	// RelayReusePort sets SO_REUSEPORT on the relay-server listeners, so that
+	// the next relay-server of an upgrade listens on them while this one
+	// drains.
+	RelayReusePort bool `yaml:"relay_reuse_port" toml:"relay_reuse_port"`
+	// RelayPortMapping maps the relay-server TCP ports on the UPnP or
+	// NAT-PMP gateway of the network and hands their external address to
+	// peers, for a relay-server hosted behind a consumer NAT.
+	RelayPortMapping bool `yaml:"relay_port_mapping" toml:"relay_port_mapping"`
+	// Admin is the HTTP admin listen address.
+	Admin string `yaml:"admin" toml:"admin"`
+	// Control is the Unix socket of the control API of a tunnel, which keeps
+	// the tunnel running to take forwards at runtime.
+	Control string `yaml:"control" toml:"control"`
+	// RelayObfsSecret, if set, also accepts relay connections obfuscated with
+	// this shared secret.
+	RelayObfsSecret string `yaml:"relay_obfs_secret" toml:"relay_obfs_secret"`
+	// RelayObfsRequire refuses plain relay connections when RelayObfsSecret is
+	// set.
+	RelayObfsRequire bool `yaml:"relay_obfs_require" toml:"relay_obfs_require"`
+	// RelayWS, if set, also accepts relay connections over WebSocket on this
+	// HTTP(S) listen address.
+	RelayWS string `yaml:"relay_ws" toml:"relay_ws"`
+	// RelayWSPath is the path upgraded to WebSocket. Empty means
+	// /flymesh-relay.
+	RelayWSPath string `yaml:"relay_ws_path" toml:"relay_ws_path"`
+	// RelayWSTLSCert and RelayWSTLSKey, if set, serve wss:// instead of ws://.
+	RelayWSTLSCert string `yaml:"relay_ws_tls_cert" toml:"relay_ws_tls_cert"`
+	RelayWSTLSKey  string `yaml:"relay_ws_tls_key" toml:"relay_ws_tls_key"`
+	// RelayWSPublic is the WebSocket URL handed to peers. Empty means a URL
+	// built from RelayWS.
+	RelayWSPublic string `yaml:"relay_ws_public" toml:"relay_ws_public"`
+	// RelayTLSCert and RelayTLSKey, if set, require TLS on the relay TCP
+	// listener, and serve wss:// on RelayWS unless RelayWSTLSCert is set.
+	RelayTLSCert string `yaml:"relay_tls_cert" toml:"relay_tls_cert"`
+	RelayTLSKey  string `yaml:"relay_tls_key" toml:"relay_tls_key"`
+	// RelayACMEHost, if set, obtains the relay TLS certificate for this
+	// hostname from an ACME CA instead of RelayTLSCert, validated with the
+	// tls-alpn-01 challenge on port 443.
+	RelayACMEHost string `yaml:"relay_acme_host" toml:"relay_acme_host"`
+	// RelayACMECache is the directory ACME accounts and certificates are
+	// kept in.
+	RelayACMECache string `yaml:"relay_acme_cache" toml:"relay_acme_cache"`
+	// RelayACMEEmail is the contact address of the ACME account. Optional.
+	RelayACMEEmail string `yaml:"relay_acme_email" toml:"relay_acme_email"`
+	// RelayACMEDirectory is the directory URL of the ACME CA. Empty means
+	// Let's Encrypt.
+	RelayACMEDirectory string `yaml:"relay_acme_directory" toml:"relay_acme_directory"`
+}
+
+// P2P configures the libp2p node.
+type P2P struct {
+	// ListenAddrs are explicit listen multiaddrs, overriding Listen.Port.
+	ListenAddrs []string `yaml:"listen_addrs" toml:"listen_addrs"`
+	// Transports restricts the enabled transports: tcp, quic, ws. Empty enables all.
+	Transports []string `yaml:"transports" toml:"transports"`
+	// IPv6Only disables IPv4 entirely: libp2p, relay listeners and relay dials
+	// use IPv6 only.
+	IPv6Only bool `yaml:"ipv6_only" toml:"ipv6_only"`
+	// Reachability is auto, public or private.
+	Reachability string `yaml:"reachability" toml:"reachability"`
+	// AutoRelay enables circuit relay reservations.
+	AutoRelay bool `yaml:"auto_relay" toml:"auto_relay"`
+	// StaticRelays are circuit relay multiaddrs used instead of DHT discovered ones.
+	StaticRelays []string `yaml:"static_relays" toml:"static_relays"`
+	// DHTMode is client, server or auto.
+	DHTMode string `yaml:"dht_mode" toml:"dht_mode"`
+	// DHTProtocolPrefix replaces "/ipfs" to run a private DHT.
+	DHTProtocolPrefix string `yaml:"dht_protocol_prefix" toml:"dht_protocol_prefix"`
+	// ConnLowWater and ConnHighWater bound the connection count: above high
+	// water, connections are trimmed down to low water. 0 keeps the libp2p
+	// defaults.
+	ConnLowWater  int `yaml:"conn_low_water" toml:"conn_low_water"`
+	ConnHighWater int `yaml:"conn_high_water" toml:"conn_high_water"`
+	// ConnGracePeriod protects new connections from trimming.
+	ConnGracePeriod time.Duration `yaml:"conn_grace_period" toml:"conn_grace_period"`
+	// MaxStreams, MaxStreamsPerPeer, MaxMemoryMB and MaxFDs cap the libp2p
+	// resource manager. 0 keeps the defaults scaled to the host.
+	MaxStreams        int   `yaml:"max_streams" toml:"max_streams"`
+	MaxStreamsPerPeer int   `yaml:"max_streams_per_peer" toml:"max_streams_per_peer"`
+	MaxMemoryMB       int64 `yaml:"max_memory_mb" toml:"max_memory_mb"`
+	MaxFDs            int   `yaml:"max_fds" toml:"max_fds"`
+}
+
+// Relay is a flymesh relay-server used by the tunnel in server mode.
+// Either Peer (resolved via the DHT) or Addr (a full multiaddr) is required.
+type Relay struct {
+	Peer string `yaml:"peer" toml:"peer"`
+	Addr string `yaml:"addr" toml:"addr"`
+}
+
+type Forward struct {
+	// Name labels the forward. It is also the service a client forward requests
+	// from the server peer, and the service a target serves.
+	Name string `yaml:"name" toml:"name"`
+	// Listen is the local address accepting connections (client mode).
+	Listen string `yaml:"listen" toml:"listen"`
+	// Target is the address incoming streams are carried to (server mode). A
+	// udp client forward asks the server peer for this address, which it must
+	// serve as a udp target.
+	Target string `yaml:"target" toml:"target"`
+	// Network is tcp, the default, or udp: datagrams carried over a stream per
+	// local source address.
+	Network string `yaml:"network" toml:"network"`
+	// Priority is the class of traffic, interactive or bulk, relay-servers
+	// schedule the streams of a client forward as. Empty means
+	// Tunnel.Priority.
+	Priority string `yaml:"priority" toml:"priority"`
+}
+
+type Limits struct {
+	// StreamTTL is how long a relay allocation waits for both sides to connect.
+	StreamTTL time.Duration `yaml:"stream_ttl" toml:"stream_ttl"`
+	// MaxAllocations caps concurrent relay allocations. 0 means unlimited.
+	MaxAllocations int `yaml:"max_allocations" toml:"max_allocations"`
+	// Bandwidth caps the bytes per second all relay bridges carry together,
+	// shared by the priority of their streams. 0 means unlimited.
+	Bandwidth int64 `yaml:"bandwidth" toml:"bandwidth"`
+	// MaxSessionsPerClient caps the concurrent sessions a tunnel server serves
+	// one client. 0 means unlimited.
+	MaxSessionsPerClient int `yaml:"max_sessions_per_client" toml:"max_sessions_per_client"`
+	// DrainTimeout is how long in-flight bridges may run after SIGINT/SIGTERM
+	// before they are closed.
+	DrainTimeout time.Duration `yaml:"drain_timeout" toml:"drain_timeout"`
+	// StateFile keeps the relay allocations not yet bridged across restarts.
+	// Empty drops them on restart.
+	StateFile string `yaml:"state_file" toml:"state_file"`
+}
+
+// DialBack configures relay-server verification of server peers.
+type DialBack struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// CacheTTL is how long a verified peer is trusted without a new dial-back.
+	CacheTTL time.Duration `yaml:"cache_ttl" toml:"cache_ttl"`
+}
+
+// Policy holds admission policies, Starlark expressions evaluated over the
+// attributes of a request, see package policy. Empty admits every request.
+type Policy struct {
+	// CreateStream is evaluated by relay-servers over create-stream requests,
+	// see relay_server.PolicyAttrs.
+	CreateStream string `yaml:"create_stream" toml:"create_stream"`
+	// StartRelay is evaluated by tunnel servers over stream requests, see
+	// relay_client.PolicyAttrs.
+	StartRelay string `yaml:"start_relay" toml:"start_relay"`
+}
+
+// Mesh configures presence announcements to other nodes sharing a mesh ID.
+type Mesh struct {
+	// ID selects the mesh. Empty disables announcements.
+	ID string `yaml:"id" toml:"id"`
+	// AnnounceInterval is the time between two presence announcements.
+	AnnounceInterval time.Duration `yaml:"announce_interval" toml:"announce_interval"`
+	// DNSListen, if set, answers DNS queries for DNSName on this UDP and TCP
+	// address with the least loaded relay-server of the mesh.
+	DNSListen string `yaml:"dns_listen" toml:"dns_listen"`
+	// DNSName is the hostname of the relay fleet answered on DNSListen.
+	DNSName string `yaml:"dns_name" toml:"dns_name"`
+	// DNSTTL is the TTL of the DNS answers.
+	DNSTTL time.Duration `yaml:"dns_ttl" toml:"dns_ttl"`
+}
+
+// History configures the throughput and latency history kept on disk per
+// peer and relay.
+type History struct {
+	// Dir holds the history files. Empty disables the history.
+	Dir string `yaml:"dir" toml:"dir"`
+	// Interval is the resolution of the history.
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+	// Retention is how far back the history goes.
+	Retention time.Duration `yaml:"retention" toml:"retention"`
+	// MaxSeries caps the peers and relays with a history.
+	MaxSeries int `yaml:"max_series" toml:"max_series"`
+}
+
+// Dev configures development features of the relay-server. Never enable them
+// on a production relay.
+type Dev struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// KillBridgeAfter closes a bridge after this many bytes. 0 disables.
+	KillBridgeAfter int64 `yaml:"kill_bridge_after" toml:"kill_bridge_after"`
+	// AckDelay delays every successful HandshakeAck.
+	AckDelay time.Duration `yaml:"ack_delay" toml:"ack_delay"`
+	// DropHandshakeEvery drops every k-th relay handshake. 0 disables.
+	DropHandshakeEvery int `yaml:"drop_handshake_every" toml:"drop_handshake_every"`
+}
+
+type Logging struct {
+	Level string `yaml:"level" toml:"level"`
+	// Format is text, json, or journal for the systemd journal, which text
+	// switches to when stderr is the journal.
+	Format string `yaml:"format" toml:"format"`
+	// Output is how a command reports its host ID, addresses, relay
+	// endpoints, test results and errors on stdout: text, or json lines for
+	// scripts.
+	Output    string    `yaml:"output" toml:"output"`
+	TraceFile string    `yaml:"trace_file" toml:"trace_file"`
+	AccessLog AccessLog `yaml:"access_log" toml:"access_log"`
+	Redact    Redact    `yaml:"redact" toml:"redact"`
+}
+
+// Redact selects how personal data and secrets appear in logs and the access
+// log: full, truncated or hashed.
+type Redact struct {
+	PeerIDs string `yaml:"peer_ids" toml:"peer_ids"`
+	IPs     string `yaml:"ips" toml:"ips"`
+	Tokens  string `yaml:"tokens" toml:"tokens"`
+	// HashSalt is mixed into hashed values. Set it to a deployment secret.
+	HashSalt string `yaml:"hash_salt" toml:"hash_salt"`
+}
+
+// Redaction converts r to the logging package representation.
+func (r Redact) Redaction() logging.Redaction {
+	return logging.Redaction{
+		PeerIDs:  logging.RedactMode(r.PeerIDs),
+		IPs:      logging.RedactMode(r.IPs),
+		Tokens:   logging.RedactMode(r.Tokens),
+		HashSalt: r.HashSalt,
+	}
+}
+
+type AccessLog struct {
+	Path string `yaml:"path" toml:"path"`
+	// MaxSizeMB rotates the log after this many megabytes. 0 disables rotation.
+	MaxSizeMB  int64         `yaml:"max_size_mb" toml:"max_size_mb"`
+	MaxAge     time.Duration `yaml:"max_age" toml:"max_age"`
+	MaxBackups int           `yaml:"max_backups" toml:"max_backups"`
+	Compress   bool          `yaml:"compress" toml:"compress"`
+}
+
+// Tunnel holds cmd/tunnel specific settings.
+type Tunnel struct {
+	Mode   string `yaml:"mode" toml:"mode"`
+	Remote string `yaml:"remote" toml:"remote"`
+	// ConnectAttempts bounds the attempts of a client to connect to the remote
+	// peer. 0 retries until ConnectDeadline.
+	ConnectAttempts int `yaml:"connect_attempts" toml:"connect_attempts"`
+	// ConnectBackoff is the pause after the first failed attempt, doubled
+	// after each further one up to ConnectMaxBackoff, and randomized by up to
+	// the fraction ConnectJitter of it.
+	ConnectBackoff    time.Duration `yaml:"connect_backoff" toml:"connect_backoff"`
+	ConnectMaxBackoff time.Duration `yaml:"connect_max_backoff" toml:"connect_max_backoff"`
+	ConnectJitter     float64       `yaml:"connect_jitter" toml:"connect_jitter"`
+	// ConnectDeadline bounds the time of all attempts. 0 means none.
+	ConnectDeadline time.Duration `yaml:"connect_deadline" toml:"connect_deadline"`
+	// ResolveInterval is how often a Remote or relay address naming peers
+	// through DNS, a /dnsaddr multiaddr or a hostname, is resolved again.
+	ResolveInterval time.Duration `yaml:"resolve_interval" toml:"resolve_interval"`
+	// Service is a service name resolved through the DHT instead of Remote.
+	Service string `yaml:"service" toml:"service"`
+	// Advertise lists the service names a server advertises in the DHT.
+	Advertise []string `yaml:"advertise" toml:"advertise"`
+	Duration  int      `yaml:"duration" toml:"duration"`
+	Send      bool     `yaml:"send" toml:"send"`
+	// Reverse has the server send in the throughput test, as Send false does.
+	Reverse bool `yaml:"reverse" toml:"reverse"`
+	// Bidir has the throughput test send both ways at once.
+	Bidir bool `yaml:"bidir" toml:"bidir"`
+	// Parallel is how many streams the throughput test runs at once.
+	Parallel int `yaml:"parallel" toml:"parallel"`
+	// Test is the test a client runs without forwards: throughput or
+	// latency.
+	Test string `yaml:"test" toml:"test"`
+	// Profile selects the p2p node profile: default or mobile.
+	Profile string `yaml:"profile" toml:"profile"`
+	// DialStrategy is relay, race to also try a direct stream, bond to
+	// stripe streams over both (experimental), or notify to have the
+	// relay-server notify a server registered with RelayNotify.
+	DialStrategy string `yaml:"dial_strategy" toml:"dial_strategy"`
+	// DirectHeadStart is how long the race lets the direct attempt run alone.
+	DirectHeadStart time.Duration `yaml:"direct_head_start" toml:"direct_head_start"`
+	// RelayRetries is how many times a failed relay dial is retried.
+	RelayRetries int `yaml:"relay_retries" toml:"relay_retries"`
+	// LinkInterval pings the remote peer and the relays of its connections at
+	// this interval to measure their round-trip time and loss. 0 disables.
+	LinkInterval time.Duration `yaml:"link_interval" toml:"link_interval"`
+	// LinkMaxRTT and LinkMaxLoss degrade a link whose average round-trip time
+	// or fraction of lost pings is above them. 0 disables each.
+	LinkMaxRTT  time.Duration `yaml:"link_max_rtt" toml:"link_max_rtt"`
+	LinkMaxLoss float64       `yaml:"link_max_loss" toml:"link_max_loss"`
+	// LinkReevaluate looks for a direct path to the remote peer when a link
+	// gets degraded.
+	LinkReevaluate bool `yaml:"link_reevaluate" toml:"link_reevaluate"`
+	// StatusInterval logs the NAT and reachability status at this interval. 0
+	// disables.
+	StatusInterval time.Duration `yaml:"status_interval" toml:"status_interval"`
+	// AllowPeers lists the peer IDs a server accepts streams from. Empty
+	// accepts any peer.
+	AllowPeers []string `yaml:"allow_peers" toml:"allow_peers"`
+	// Grants lists the guest grants a server issues at startup, each as
+	// "PEER,duration=D[,service=NAME][,max-bytes=SIZE]".
+	Grants []string `yaml:"grants" toml:"grants"`
+	// RequireSessionBinding refuses relayed streams whose Noise handshake is
+	// not bound to the relay allocation, i.e. with peers predating it.
+	RequireSessionBinding bool `yaml:"require_session_binding" toml:"require_session_binding"`
+	// RelayTransport registers the relay as a libp2p transport: a server
+	// accepts libp2p connections through its relay-server, and limited
+	// connections to such servers are upgraded through it.
+	RelayTransport bool `yaml:"relay_transport" toml:"relay_transport"`
+	// RelayNotify registers a server with its relay-server for the stream
+	// requests clients send through it, so that they need no libp2p
+	// connection to the server.
+	RelayNotify bool `yaml:"relay_notify" toml:"relay_notify"`
+	// Compression lists the algorithms, zstd or snappy, relayed streams may be
+	// compressed with: offered most preferred first by a client, accepted by a
+	// server. Empty disables compression.
+	Compression []string `yaml:"compression" toml:"compression"`
+	// Priority is the class of traffic, interactive or bulk, relay-servers
+	// schedule the streams of a client as. Empty asks for none.
+	Priority string `yaml:"priority" toml:"priority"`
+	// RelayMACs lists the MAC algorithms, hmac-sha256 or blake3, relay-servers
+	// may authenticate their relay frames with, most preferred first. Empty
+	// announces both, hmac-sha256 first.
+	RelayMACs []string `yaml:"relay_macs" toml:"relay_macs"`
+	// RelayObfsSecret, if set, obfuscates the connections to relay-servers with
+	// this shared secret. The relay-servers must be configured with it.
+	RelayObfsSecret string `yaml:"relay_obfs_secret" toml:"relay_obfs_secret"`
+	// RelayTLSCA, if set, is a PEM file of the CA certificates trusted for the
+	// tls:// and wss:// endpoints of relay-servers instead of the system roots.
+	RelayTLSCA string `yaml:"relay_tls_ca" toml:"relay_tls_ca"`
+	// FileRoot is the directory a server serves the file transfers of
+	// clients, tunnel cp, within. Empty refuses them.
+	FileRoot string `yaml:"file_root" toml:"file_root"`
+	// FileReadOnly refuses the files clients send to FileRoot.
+	FileReadOnly bool `yaml:"file_read_only" toml:"file_read_only"`
+	// ExecPeers lists the peers a server runs the commands of, tunnel exec,
+	// as its own user. Empty refuses them.
+	ExecPeers []string `yaml:"exec_peers" toml:"exec_peers"`
+	// ExecCommands, if set, lists the programs, as named on the command line
+	// of tunnel exec, the peers of ExecPeers may run, without setting their
+	// environment or working directory. Empty allows any command.
+	ExecCommands []string `yaml:"exec_commands" toml:"exec_commands"`
+}
+
+// WireGuard configures the WireGuard mode of cmd/tunnel: a userspace WireGuard
+// device whose peers, the clients of a server or the server of a client, are
+// reached over streams.
+type WireGuard struct {
+	// Interface is the name of the TUN interface to create. Empty disables the
+	// mode. The interface is addressed and routed to with the system tools.
+	Interface string `yaml:"interface" toml:"interface"`
+	// MTU is the MTU of the interface.
+	MTU int `yaml:"mtu" toml:"mtu"`
+	// PrivateKeyFile holds the key of the device, as wg genkey writes it.
+	// Empty generates a key on every start.
+	PrivateKeyFile string `yaml:"private_key_file" toml:"private_key_file"`
+	// Routes are the prefixes routed to this node, announced to its peers.
+	Routes []string `yaml:"routes" toml:"routes"`
+	// AllowedIPs bound the routes the peers may announce.
+	AllowedIPs []string `yaml:"allowed_ips" toml:"allowed_ips"`
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *Config {
+	return &Config{
+		Listen: Listen{
+			Relay:          ":24002",
+			RelayACMECache: "acme",
+		},
+		DialBack: DialBack{
+			CacheTTL: 10 * time.Minute,
+		},
+		P2P: P2P{
+			Reachability: "private",
+			DHTMode:      "client",
+			AutoRelay:    true,
+		},
+		Limits: Limits{
+			StreamTTL:    time.Minute,
+			DrainTimeout: 30 * time.Second,
+		},
+		Logging: Logging{
+			Level:  "info",
+			Format: "text",
+			Output: "text",
+			Redact: Redact{
+				PeerIDs: "full",
+				IPs:     "full",
+				Tokens:  "full",
+			},
+			AccessLog: AccessLog{
+				MaxSizeMB: 100,
+				MaxAge:    7 * 24 * time.Hour,
+			},
+		},
+		Mesh: Mesh{
+			AnnounceInterval: 30 * time.Second,
+			DNSTTL:           5 * time.Second,
+		},
+		History: History{
+			Interval:  5 * time.Minute,
+			Retention: 7 * 24 * time.Hour,
+			MaxSeries: 1024,
+		},
+		Tunnel: Tunnel{
+			DialStrategy:      "relay",
+			DirectHeadStart:   250 * time.Millisecond,
+			RelayRetries:      1,
+			ResolveInterval:   5 * time.Minute,
+			ConnectAttempts:   5,
+			ConnectBackoff:    time.Second,
+			ConnectMaxBackoff: 30 * time.Second,
+			ConnectJitter:     0.2,
+			Duration:          10,
+			Send:              true,
+			Parallel:          1,
+			Test:              "throughput",
+		},
+		WireGuard: WireGuard{
+			MTU: 1420,
+		},
+	}
+}
+
+// Load reads a YAML (.yaml/.yml) or TOML (.toml) file on top of cfg.
+func Load(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parse %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unsupported config file type %q (want .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	return nil
+}
+
+// PathFromArgs finds the value of --config in args before flags are parsed, so
+// that file values can serve as flag defaults.
+func PathFromArgs(args []string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		name := strings.TrimLeft(a, "-")
+		if name == a {
+			continue
+		}
+		if v, ok := strings.CutPrefix(name, "config="); ok {
+			return v
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// LoadFromArgs returns Default() overlaid with the file named by --config in args, if any.
+func LoadFromArgs(args []string) (*Config, error) {
+	cfg := Default()
+	if path := PathFromArgs(args); path != "" {
+		if err := Load(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// StringsVar defines a repeatable flag backed by the config list dst. The first
+// use on the command line replaces the values loaded from the config file.
+func StringsVar(dst *[]string, name string, usage string) {
+	flag.Var(&stringsFlag{dst: dst}, name, usage)
+}
+
+type stringsFlag struct {
+	dst *[]string
+	set bool
+}
+
+func (f *stringsFlag) String() string {
+	if f.dst == nil {
+		return ""
+	}
+	return strings.Join(*f.dst, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	if !f.set {
+		*f.dst = nil
+		f.set = true
+	}
+	*f.dst = append(*f.dst, v)
+	return nil
+}
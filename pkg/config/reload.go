@@ -0,0 +1,153 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Clone returns a deep copy of c.
+func (c *Config) Clone() *Config {
+	out := new(Config)
+	cloneValue(reflect.ValueOf(out).Elem(), reflect.ValueOf(c).Elem())
+	return out
+}
+
+func cloneValue(dst reflect.Value, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			cloneValue(dst.Field(i), src.Field(i))
+		}
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.SetZero()
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			cloneValue(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	default:
+		dst.Set(src)
+	}
+}
+
+// Changes returns the keys whose value differs between a and b, as dotted
+// paths of the file keys, e.g. limits.stream_ttl. Lists compare as a whole:
+// a changed forward reports forwards.
+func Changes(a *Config, b *Config) []string {
+	var keys []string
+	walk(reflect.ValueOf(a).Elem(), "", func(key string, v reflect.Value) {
+		if !reflect.DeepEqual(v.Interface(), lookup(b, key).Interface()) {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// walk calls fn for every key of the struct v below prefix that is not a
+// section itself.
+func walk(v reflect.Value, prefix string, fn func(key string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := prefix + fieldKey(t.Field(i))
+		if v.Field(i).Kind() == reflect.Struct {
+			walk(v.Field(i), key+".", fn)
+			continue
+		}
+		fn(key, v.Field(i))
+	}
+}
+
+func fieldKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// lookup returns the field of c at key, a path returned by Changes.
+func lookup(c *Config, key string) reflect.Value {
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if fieldKey(t.Field(i)) == name {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+	return v
+}
+
+// Reloader reloads the config file of a running command. Values set on the
+// command line keep overriding the file: a key is only reloaded while the
+// running config holds the value the file gave it.
+type Reloader struct {
+	args []string
+	file *Config
+}
+
+// NewReloader returns a reloader of the config file named by --config in args.
+// file is the config LoadFromArgs returned, before flags were applied to it.
+func NewReloader(args []string, file *Config) *Reloader {
+	return &Reloader{args: args, file: file.Clone()}
+}
+
+// Reload is a config file read again.
+type Reload struct {
+	// Config is the running config with the changes of the file.
+	Config *Config
+	// Changed lists the keys the file changed, see Changes.
+	Changed []string
+	// Overridden lists the keys the file changed that a flag sets, which keep
+	// their value.
+	Overridden []string
+
+	file *Config
+}
+
+// Reload reads the config file again and applies its changes to a copy of cur,
+// the running config.
+func (r *Reloader) Reload(cur *Config) (*Reload, error) {
+	file, err := LoadFromArgs(r.args)
+	if err != nil {
+		return nil, err
+	}
+	res := &Reload{Config: cur.Clone(), file: file}
+	for _, key := range Changes(r.file, file) {
+		running := lookup(res.Config, key)
+		if !reflect.DeepEqual(running.Interface(), lookup(r.file, key).Interface()) {
+			res.Overridden = append(res.Overridden, key)
+			continue
+		}
+		cloneValue(running, lookup(file, key))
+		res.Changed = append(res.Changed, key)
+	}
+	return res, nil
+}
+
+// Restart returns the changed keys not in reloadable, which a running command
+// only applies when restarted.
+func (r *Reload) Restart(reloadable ...string) []string {
+	var keys []string
+	for _, key := range r.Changed {
+		if !slices.Contains(reloadable, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Accept makes the file of res the one the next Reload compares to, once the
+// changes of res are applied.
+func (r *Reloader) Accept(res *Reload) {
+	r.file = res.file
+}
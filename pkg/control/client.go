@@ -0,0 +1,104 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/flymesh/core/pkg/status"
+)
+
+// Client calls the control API of a tunnel.
+type Client struct {
+	hc *http.Client
+}
+
+// NewClient returns a client of the control socket at path.
+func NewClient(path string) *Client {
+	return &Client{hc: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// Status returns the status document of the tunnel.
+func (c *Client) Status(ctx context.Context) (*status.Status, error) {
+	var st status.Status
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// Streams returns the open streams of the tunnel.
+func (c *Client) Streams(ctx context.Context) ([]status.StreamInfo, error) {
+	var streams []status.StreamInfo
+	err := c.do(ctx, http.MethodGet, "/streams", nil, &streams)
+	return streams, err
+}
+
+// Forwards returns the forwards of the tunnel.
+func (c *Client) Forwards(ctx context.Context) ([]status.ForwardInfo, error) {
+	var forwards []status.ForwardInfo
+	err := c.do(ctx, http.MethodGet, "/forwards", nil, &forwards)
+	return forwards, err
+}
+
+// AddForward adds and starts the forward spec.
+func (c *Client) AddForward(ctx context.Context, spec ForwardSpec) (status.ForwardInfo, error) {
+	var info status.ForwardInfo
+	err := c.do(ctx, http.MethodPost, "/forwards", spec, &info)
+	return info, err
+}
+
+// RemoveForward removes the forward name.
+func (c *Client) RemoveForward(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/forwards/"+url.PathEscape(name), nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://tunnel"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e errorBody
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
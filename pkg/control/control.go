@@ -0,0 +1,211 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package control is the control API of a running tunnel: JSON over HTTP on a
+// Unix socket, which Windows 10 and later have too. It serves the status
+// document, the open streams and the forwards, and adds and removes forwards
+// without touching the connections of the others.
+//
+//	GET    /status
+//	GET    /streams
+//	GET    /forwards
+//	POST   /forwards         ForwardSpec
+//	DELETE /forwards/{name}
+//
+// Errors are {"error": "..."} with a 4xx or 5xx status.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/forward"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/status"
+)
+
+// SocketEnv is the environment variable naming the socket of the control
+// client by default.
+const SocketEnv = "FLYMESH_CONTROL_SOCKET"
+
+// removeTimeout bounds how long the connections of a removed forward drain.
+const removeTimeout = 10 * time.Second
+
+// ErrFixedForwards is returned by a server without Forwards.
+var ErrFixedForwards = errors.New("forwards cannot change in this mode")
+
+// ForwardSpec describes a forward to add, as a forward of the config file.
+type ForwardSpec struct {
+	Name    string `json:"name,omitempty"`
+	Listen  string `json:"listen"`
+	Target  string `json:"target,omitempty"`
+	Network string `json:"network,omitempty"`
+}
+
+// Forwards changes the forwards of a running process.
+type Forwards interface {
+	AddForward(spec ForwardSpec) (status.ForwardInfo, error)
+	// RemoveForward removes the forward name, closing its connections still
+	// open when ctx is done.
+	RemoveForward(ctx context.Context, name string) error
+}
+
+// Server serves the control API.
+type Server struct {
+	// Component and Sources build the status document.
+	Component string
+	Sources   []status.Source
+	// Forwards, if set, adds and removes forwards. Otherwise changing them
+	// fails with ErrFixedForwards.
+	Forwards Forwards
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	srv *http.Server
+	wg  sync.WaitGroup
+}
+
+func (s *Server) logger() *slog.Logger {
+	return logging.Component(s.Logger, "control")
+}
+
+// Start serves on a Unix socket at path, readable by its owner only. A socket
+// left at path by a process that is gone is replaced.
+func (s *Server) Start(path string) error {
+	if s.srv != nil {
+		return errors.New("already started")
+	}
+	if _, err := os.Stat(path); err == nil {
+		if c, err := net.Dial("unix", path); err == nil {
+			_ = c.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /status", status.Handler(s.Component, s.Sources...))
+	mux.HandleFunc("GET /streams", func(w http.ResponseWriter, r *http.Request) {
+		st := status.Build(s.Component, s.Sources...)
+		writeJSON(w, http.StatusOK, nonNil(st.Streams))
+	})
+	mux.HandleFunc("GET /forwards", func(w http.ResponseWriter, r *http.Request) {
+		st := status.Build(s.Component, s.Sources...)
+		writeJSON(w, http.StatusOK, st.Forwards)
+	})
+	mux.HandleFunc("POST /forwards", s.addForward)
+	mux.HandleFunc("DELETE /forwards/{name}", s.removeForward)
+
+	s.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.logger().Info("listening", "socket", path)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger().Error("serve error", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down and removes its socket.
+func (s *Server) Stop() {
+	if s.srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = s.srv.Shutdown(ctx)
+	s.wg.Wait()
+}
+
+func (s *Server) addForward(w http.ResponseWriter, r *http.Request) {
+	if s.Forwards == nil {
+		writeError(w, ErrFixedForwards)
+		return
+	}
+	var spec ForwardSpec
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&spec); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "bad forward: " + err.Error()})
+		return
+	}
+	info, err := s.Forwards.AddForward(spec)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.logger().Info("forward added", logging.KeyForward, info.Name, "listen", info.Listen, "network", info.Network)
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (s *Server) removeForward(w http.ResponseWriter, r *http.Request) {
+	if s.Forwards == nil {
+		writeError(w, ErrFixedForwards)
+		return
+	}
+	name := r.PathValue("name")
+	ctx, cancel := context.WithTimeout(r.Context(), removeTimeout)
+	defer cancel()
+	if err := s.Forwards.RemoveForward(ctx, name); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.logger().Info("forward removed", logging.KeyForward, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// writeError writes err with the status matching it.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusBadRequest
+	switch {
+	case errors.Is(err, forward.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, forward.ErrExists):
+		code = http.StatusConflict
+	case errors.Is(err, ErrFixedForwards):
+		code = http.StatusNotImplemented
+	}
+	writeJSON(w, code, errorBody{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// nonNil makes an empty list encode as [] rather than null.
+func nonNil[T any](v []T) []T {
+	if v == nil {
+		return []T{}
+	}
+	return v
+}
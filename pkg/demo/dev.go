@@ -0,0 +1,134 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package demo wires a relay-server and both roles of a tunnel together in
+// one process, on the loopback interface, and runs example scenarios over
+// them. The scenarios double as integration tests of the whole stack and as
+// starting points for programs embedding flymesh.
+package demo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/flymesh/core/p2p"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_server "github.com/flymesh/core/pkg/relay-server"
+	relay_client "github.com/flymesh/core/relay-client"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// connectTimeout bounds the libp2p connections between the nodes of a Dev.
+const connectTimeout = 10 * time.Second
+
+// Dev is a development deployment: a relay-server, and the server and the
+// client of a tunnel through it, each a libp2p node of its own listening on
+// the loopback interface only.
+type Dev struct {
+	RelayNode  *p2p.Node
+	ServerNode *p2p.Node
+	ClientNode *p2p.Node
+	Relay      *relay_manager.RelayManager
+	// ServerRole accepts the streams of the client. Set its Handler, or call
+	// its Listen, before opening streams.
+	ServerRole *relay_client.ServerRole
+	ClientRole *relay_client.ClientRole
+
+	cancel context.CancelFunc
+}
+
+// StartDev starts a Dev. Close stops it.
+func StartDev(ctx context.Context) (_ *Dev, err error) {
+	d := &Dev{}
+	ctx, d.cancel = context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			_ = d.Close()
+		}
+	}()
+	for _, n := range []**p2p.Node{&d.RelayNode, &d.ServerNode, &d.ClientNode} {
+		if *n, err = newNode(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	relayAddress, err := freeAddress()
+	if err != nil {
+		return nil, err
+	}
+	d.Relay = relay_manager.New()
+	d.Relay.PublicAddress = relayAddress
+	relay_server.Run(ctx, d.RelayNode, d.Relay, relayAddress)
+
+	d.ServerRole = &relay_client.ServerRole{
+		PrivKey:     d.ServerNode.PrivKey,
+		RelayPeerId: d.RelayNode.Host.ID(),
+	}
+	if err := connect(ctx, d.ServerNode, d.RelayNode); err != nil {
+		return nil, fmt.Errorf("connect server to relay: %w", err)
+	}
+	d.ServerRole.RegisterProtocol(d.ServerNode.Host)
+
+	d.ClientRole = &relay_client.ClientRole{
+		PrivKey:  d.ClientNode.PrivKey,
+		Strategy: relay_client.DialRelay,
+	}
+	if err := connect(ctx, d.ClientNode, d.ServerNode); err != nil {
+		return nil, fmt.Errorf("connect client to server: %w", err)
+	}
+	return d, nil
+}
+
+// OpenStream opens a stream from the client to the server, through the relay.
+func (d *Dev) OpenStream(ctx context.Context, dst relay_client.Destination) (*relay_client.Conn, error) {
+	return d.ClientRole.OpenStream(ctx, d.ClientNode.Host, d.ServerNode.Host.ID(), dst)
+}
+
+// Close stops the relay-server and the nodes.
+func (d *Dev) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var errs []error
+	if d.ServerRole != nil {
+		errs = append(errs, d.ServerRole.Shutdown(ctx))
+	}
+	if d.Relay != nil {
+		errs = append(errs, d.Relay.Shutdown(ctx))
+	}
+	for _, n := range []*p2p.Node{d.ClientNode, d.ServerNode, d.RelayNode} {
+		if n != nil {
+			errs = append(errs, n.Close())
+		}
+	}
+	d.cancel()
+	return errors.Join(errs...)
+}
+
+func newNode(ctx context.Context) (*p2p.Node, error) {
+	n := &p2p.Node{
+		Context:          ctx,
+		ListenAddrs:      []string{"/ip4/127.0.0.1/tcp/0"},
+		NoBootstrap:      true,
+		DisableAutoRelay: true,
+	}
+	return n, n.Init()
+}
+
+func connect(ctx context.Context, from *p2p.Node, to *p2p.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return from.Host.Connect(ctx, peer.AddrInfo{ID: to.Host.ID(), Addrs: to.Host.Addrs()})
+}
+
+// freeAddress returns a loopback TCP address nothing listens on.
+func freeAddress() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,175 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package demo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/flymesh/core/pkg/transfer"
+	relay_client "github.com/flymesh/core/relay-client"
+)
+
+// Scenario is an example run over a Dev.
+type Scenario struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context, d *Dev) error
+}
+
+// Scenarios returns the example scenarios. The send scenario transfers the
+// file at path, or a generated one if path is empty, into dir.
+func Scenarios(path string, dir string) []Scenario {
+	return []Scenario{
+		{Name: "echo", Description: "the server echoes what the client writes", Run: Echo},
+		{Name: "http", Description: "the server exposes an HTTP server the client queries", Run: HTTP},
+		{Name: "send", Description: "the client sends a file the server stores", Run: func(ctx context.Context, d *Dev) error {
+			return SendFile(ctx, d, path, dir)
+		}},
+	}
+}
+
+// Echo has the server echo a message the client writes.
+func Echo(ctx context.Context, d *Dev) error {
+	d.ServerRole.Handler = func(_ *relay_client.StreamInfo, conn net.Conn) {
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}
+
+	conn, err := d.OpenStream(ctx, relay_client.Destination{})
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	msg := []byte("hello flymesh")
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, msg) {
+		return fmt.Errorf("echoed %q, want %q", got, msg)
+	}
+	return nil
+}
+
+// HTTP has the server serve HTTP on its streams, through Listen, and the
+// client fetch a page over them.
+func HTTP(ctx context.Context, d *Dev) error {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello from %s\n", r.URL.Path)
+	})}
+	ln := d.ServerRole.Listen(d.ServerNode.Host.ID(), 16)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.OpenStream(ctx, relay_client.Destination{Service: "http"})
+		},
+	}}
+	defer client.CloseIdleConnections()
+	// The host is not resolved: every connection is a stream to the server.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://demo/index.html", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if want := "hello from /index.html\n"; resp.StatusCode != http.StatusOK || string(body) != want {
+		return fmt.Errorf("got %s %q, want 200 %q", resp.Status, body, want)
+	}
+	return nil
+}
+
+// SendFile has the client send the file at path, or a generated one if path
+// is empty, to the server, which stores it in dir, with package transfer as
+// tunnel cp does.
+func SendFile(ctx context.Context, d *Dev, path string, dir string) error {
+	if path == "" {
+		var err error
+		if path, err = generateFile(dir); err != nil {
+			return err
+		}
+	}
+	same, err := sameDir(filepath.Dir(path), dir)
+	if err != nil {
+		return err
+	}
+	if same {
+		return fmt.Errorf("%s would be received onto itself in %s", path, dir)
+	}
+	files := &transfer.Server{Root: dir}
+	d.ServerRole.Handler = func(_ *relay_client.StreamInfo, conn net.Conn) {
+		if err := files.Serve(conn); err != nil {
+			slog.Warn("receive file failed", "err", err)
+		}
+	}
+
+	conn, err := d.OpenStream(ctx, relay_client.Destination{ALPN: transfer.ALPN})
+	if err != nil {
+		return fmt.Errorf("open stream: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	name := filepath.Base(path)
+	result, err := transfer.Put(conn, path, name, nil)
+	if err != nil {
+		return err
+	}
+	slog.Info("file sent", "file", name, "size", result.Size, "dir", dir)
+	return nil
+}
+
+func sameDir(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(ai, bi), nil
+}
+
+// generateFile writes a file of random content in a new directory under dir.
+func generateFile(dir string) (string, error) {
+	src, err := os.MkdirTemp(dir, "send-")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(src, "random.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.CopyN(f, rand.Reader, 4<<20); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
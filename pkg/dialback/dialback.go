@@ -0,0 +1,199 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package dialback lets a relay-server verify that a peer requesting resources
+// controls its identity: the relay opens a stream back to the peer and the peer
+// signs a fresh challenge with its identity key.
+package dialback
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/protocol"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var ErrVerificationFailed = errors.New("dial-back verification failed")
+
+const signaturePrefix = "flymesh-dialback/1"
+
+// payload is the message signed by the verified peer.
+func payload(relayID peer.ID, nonce []byte) []byte {
+	b := []byte(signaturePrefix)
+	b = append(b, []byte(relayID)...)
+	return append(b, nonce...)
+}
+
+// Verifier dials back to peers and checks their signed challenge. Successful
+// verifications are cached for CacheTTL.
+type Verifier struct {
+	Host host.Host
+	// Timeout bounds one dial-back round trip.
+	Timeout time.Duration
+	// CacheTTL is how long a verified peer is trusted without a new dial-back.
+	CacheTTL time.Duration
+	// Hello is announced to peers that support it. If nil, the Hello of this
+	// build without limits is.
+	Hello *controlpb.Hello
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	verified map[peer.ID]time.Time
+}
+
+func New(h host.Host) *Verifier {
+	return &Verifier{
+		Host:     h,
+		Timeout:  10 * time.Second,
+		CacheTTL: 10 * time.Minute,
+		verified: make(map[peer.ID]time.Time),
+	}
+}
+
+func (v *Verifier) logger() *slog.Logger {
+	return logging.Component(v.Logger, "dialback")
+}
+
+// VerifyPeer returns nil if p answered a dial-back challenge within CacheTTL, or
+// does so now. Failures wrap ErrVerificationFailed.
+func (v *Verifier) VerifyPeer(ctx context.Context, p peer.ID) error {
+	v.mu.Lock()
+	until, ok := v.verified[p]
+	v.mu.Unlock()
+	if ok && time.Now().Before(until) {
+		return nil
+	}
+
+	if err := v.challenge(ctx, p); err != nil {
+		v.logger().Warn("peer failed dial-back", logging.KeyPeer, p.String(), "err", err)
+		return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+	v.logger().Debug("peer verified", logging.KeyPeer, p.String())
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for id, exp := range v.verified {
+		if now.After(exp) {
+			delete(v.verified, id)
+		}
+	}
+	v.verified[p] = now.Add(v.CacheTTL)
+	return nil
+}
+
+func (v *Verifier) challenge(ctx context.Context, p peer.ID) error {
+	pub, err := p.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extract public key: %w", err)
+	}
+	nonce := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+	s, err := v.Host.NewStream(network.WithAllowLimitedConn(ctx, "dial-back"), p, protocol.ProtoDialBack)
+	if err != nil {
+		return fmt.Errorf("open dial-back stream: %w", err)
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+
+	req := controlpb.DialBackChallenge{
+		Nonce:       nonce,
+		RelayPeerId: []byte(v.Host.ID()),
+	}
+	data, err := req.MarshalVT()
+	if err != nil {
+		return err
+	}
+	hello := v.Hello
+	if hello == nil {
+		hello = relay_protocol.NewHello(nil)
+	}
+	if err := relay_protocol.WriteControlRequest(v.Host, s, hello, relay_protocol.ControlTypeDialBackChallenge, data); err != nil {
+		return fmt.Errorf("write DialBackChallenge: %w", err)
+	}
+
+	typ, data, err := relay_protocol.ReadControlResponse(v.Host, s, v.Timeout)
+	if err != nil {
+		return fmt.Errorf("read DialBackResponse: %w", err)
+	}
+	if typ != relay_protocol.ControlTypeDialBackResponse {
+		return fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var resp controlpb.DialBackResponse
+	if err := resp.UnmarshalVT(data); err != nil {
+		return fmt.Errorf("decode DialBackResponse: %w", err)
+	}
+	ok, err := pub.Verify(payload(v.Host.ID(), nonce), resp.GetSignature())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+// RegisterResponder answers dial-back challenges on h by signing them with priv,
+// which must be the identity key of h. Challenges are only answered for the relay
+// that sent them. hello is announced to relays that support it.
+func RegisterResponder(h host.Host, priv crypto.PrivKey, hello *controlpb.Hello, logger *slog.Logger) {
+	logger = logging.Component(logger, "dialback")
+	h.SetStreamHandler(protocol.ProtoDialBack, func(s network.Stream) {
+		defer s.Close()
+		relayID := s.Conn().RemotePeer()
+
+		typ, data, err := relay_protocol.ReadControlRequest(h, s, hello, time.Second*10)
+		if err != nil {
+			logger.Warn("read DialBackChallenge failed", logging.KeyPeer, relayID.String(), "err", err)
+			return
+		}
+		if typ != relay_protocol.ControlTypeDialBackChallenge {
+			logger.Warn("unexpected control frame type", logging.KeyPeer, relayID.String(), "type", typ)
+			return
+		}
+		var req controlpb.DialBackChallenge
+		if err := req.UnmarshalVT(data); err != nil {
+			logger.Warn("bad DialBackChallenge", logging.KeyPeer, relayID.String(), "err", err)
+			return
+		}
+		if peer.ID(req.GetRelayPeerId()) != relayID {
+			logger.Warn("dial-back challenge for another relay", logging.KeyPeer, relayID.String())
+			return
+		}
+
+		sig, err := priv.Sign(payload(relayID, req.GetNonce()))
+		if err != nil {
+			logger.Error("sign dial-back challenge failed", "err", err)
+			return
+		}
+		resp := controlpb.DialBackResponse{Signature: sig}
+		data, err = resp.MarshalVT()
+		if err != nil {
+			return
+		}
+		if err := relay_protocol.WriteControlResponse(h, s, relay_protocol.ControlTypeDialBackResponse, data); err != nil {
+			logger.Warn("write DialBackResponse failed", logging.KeyPeer, relayID.String(), "err", err)
+		}
+	})
+}
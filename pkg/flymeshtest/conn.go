@@ -0,0 +1,47 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package flymeshtest
+
+import (
+	"net"
+	"testing"
+)
+
+// TCPPair returns the two ends of a loopback TCP connection, which buffers
+// what both ends write at once, unlike net.Pipe. Both ends are closed when
+// the test ends.
+func TCPPair(t testing.TB) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+	b := <-accepted
+	if b == nil {
+		t.Fatal("accept failed")
+	}
+	t.Cleanup(func() { _ = b.Close() })
+	return a, b
+}
+
+// ServeConn runs serve on one end of a TCPPair in the background, and returns
+// the other end and the channel receiving what serve returns.
+func ServeConn(t testing.TB, serve func(conn net.Conn) error) (net.Conn, <-chan error) {
+	t.Helper()
+	client, server := TCPPair(t)
+	done := make(chan error, 1)
+	go func() { done <- serve(server) }()
+	return client, done
+}
@@ -0,0 +1,228 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package flymeshtest runs a flymesh network in process for tests: a
+// relay-server, and the server and the client of a tunnel through it, so that
+// programs embedding flymesh can be tested end to end without real networks.
+//
+// The libp2p nodes talk over the loopback interface, or over a libp2p mocknet
+// with Options.Mocknet. The relay-server accepts the relayed connections on a
+// random loopback TCP port either way.
+package flymeshtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flymesh/core/p2p"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_server "github.com/flymesh/core/pkg/relay-server"
+	relay_client "github.com/flymesh/core/relay-client"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// connectTimeout bounds the libp2p connections between the nodes of a Net.
+const connectTimeout = 10 * time.Second
+
+// backlog is how many streams Pipe leaves waiting to be accepted.
+const backlog = 16
+
+// Options configure New.
+type Options struct {
+	// Mocknet connects the nodes over a libp2p mocknet instead of the
+	// loopback interface.
+	Mocknet bool
+	// Relay, if set, is called with the RelayManager before it starts, to set
+	// its policies or limits.
+	Relay func(rm *relay_manager.RelayManager)
+	// Server and Client, if set, are called with the roles before the nodes
+	// connect, to set their options.
+	Server func(r *relay_client.ServerRole)
+	Client func(r *relay_client.ClientRole)
+}
+
+// Net is a flymesh network: a relay-server, and the server and the client of
+// a tunnel through it, each a libp2p node of its own.
+type Net struct {
+	RelayNode  *p2p.Node
+	ServerNode *p2p.Node
+	ClientNode *p2p.Node
+	Relay      *relay_manager.RelayManager
+	// ServerRole accepts the streams of the client. Set its Handler, or use
+	// Pipe, before opening streams.
+	ServerRole *relay_client.ServerRole
+	ClientRole *relay_client.ClientRole
+
+	listenOnce sync.Once
+	listener   *relay_client.Listener
+}
+
+// New starts a Net, which is stopped when the test ends. It fails the test
+// if the Net does not start.
+func New(t testing.TB, opts Options) *Net {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Net{}
+	t.Cleanup(func() {
+		if err := n.close(); err != nil {
+			t.Logf("flymeshtest: close: %v", err)
+		}
+		cancel()
+	})
+	if err := n.start(ctx, opts); err != nil {
+		t.Fatalf("flymeshtest: %v", err)
+	}
+	return n
+}
+
+func (n *Net) start(ctx context.Context, opts Options) error {
+	nodes := []**p2p.Node{&n.RelayNode, &n.ServerNode, &n.ClientNode}
+	var mn mocknet.Mocknet
+	if opts.Mocknet {
+		mn = mocknet.New()
+	}
+	for _, node := range nodes {
+		var err error
+		if mn != nil {
+			*node, err = newMockNode(ctx, mn)
+		} else {
+			*node, err = newNode(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if mn != nil {
+		if err := mn.LinkAll(); err != nil {
+			return err
+		}
+	}
+
+	relayAddress, err := freeAddress()
+	if err != nil {
+		return err
+	}
+	n.Relay = relay_manager.New()
+	n.Relay.PublicAddress = relayAddress
+	if opts.Relay != nil {
+		opts.Relay(n.Relay)
+	}
+	relay_server.Run(ctx, n.RelayNode, n.Relay, relayAddress)
+
+	n.ServerRole = &relay_client.ServerRole{
+		PrivKey:     n.ServerNode.PrivKey,
+		RelayPeerId: n.RelayNode.Host.ID(),
+	}
+	if opts.Server != nil {
+		opts.Server(n.ServerRole)
+	}
+	if err := connect(ctx, n.ServerNode, n.RelayNode); err != nil {
+		return fmt.Errorf("connect server to relay: %w", err)
+	}
+	n.ServerRole.RegisterProtocol(n.ServerNode.Host)
+
+	n.ClientRole = &relay_client.ClientRole{
+		PrivKey:  n.ClientNode.PrivKey,
+		Strategy: relay_client.DialRelay,
+	}
+	if opts.Client != nil {
+		opts.Client(n.ClientRole)
+	}
+	if err := connect(ctx, n.ClientNode, n.ServerNode); err != nil {
+		return fmt.Errorf("connect client to server: %w", err)
+	}
+	return nil
+}
+
+// OpenStream opens a stream from the client to the server, through the relay.
+func (n *Net) OpenStream(ctx context.Context, dst relay_client.Destination) (*relay_client.Conn, error) {
+	return n.ClientRole.OpenStream(ctx, n.ClientNode.Host, n.ServerNode.Host.ID(), dst)
+}
+
+// Pipe opens a stream to dst and returns both of its ends, which are closed
+// when the test ends. It fails the test if the stream is not set up.
+//
+// The first call replaces the Handler of ServerRole, see
+// relay_client.ServerRole.Listen.
+func (n *Net) Pipe(t testing.TB, dst relay_client.Destination) (client, server *relay_client.Conn) {
+	t.Helper()
+	n.listenOnce.Do(func() {
+		n.listener = n.ServerRole.Listen(n.ServerNode.Host.ID(), backlog)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	client, err := n.OpenStream(ctx, dst)
+	if err != nil {
+		t.Fatalf("flymeshtest: open stream: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if server, err = n.listener.AcceptContext(ctx); err != nil {
+		t.Fatalf("flymeshtest: accept stream: %v", err)
+	}
+	t.Cleanup(func() { _ = server.Close() })
+	return client, server
+}
+
+// close stops the relay-server and the nodes.
+func (n *Net) close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var errs []error
+	if n.listener != nil {
+		errs = append(errs, n.listener.Close())
+	}
+	if n.ServerRole != nil {
+		errs = append(errs, n.ServerRole.Shutdown(ctx))
+	}
+	if n.Relay != nil {
+		errs = append(errs, n.Relay.Shutdown(ctx))
+	}
+	for _, node := range []*p2p.Node{n.ClientNode, n.ServerNode, n.RelayNode} {
+		if node != nil {
+			errs = append(errs, node.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newNode(ctx context.Context) (*p2p.Node, error) {
+	n := &p2p.Node{
+		Context:          ctx,
+		ListenAddrs:      []string{"/ip4/127.0.0.1/tcp/0"},
+		NoBootstrap:      true,
+		DisableAutoRelay: true,
+	}
+	return n, n.Init()
+}
+
+// newMockNode returns a node on mn. Its host is all it has: no DHT, no ping
+// service.
+func newMockNode(ctx context.Context, mn mocknet.Mocknet) (*p2p.Node, error) {
+	h, err := mn.GenPeer()
+	if err != nil {
+		return nil, err
+	}
+	return &p2p.Node{Context: ctx, Host: h, PrivKey: h.Peerstore().PrivKey(h.ID())}, nil
+}
+
+func connect(ctx context.Context, from *p2p.Node, to *p2p.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return from.Host.Connect(ctx, peer.AddrInfo{ID: to.Host.ID(), Addrs: to.Host.Addrs()})
+}
+
+// freeAddress returns a loopback TCP address nothing listens on.
+func freeAddress() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package flymeshtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	relay_client "github.com/flymesh/core/relay-client"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPipe(t *testing.T) {
+	blake3 := []relay_protocol.MAC{relay_protocol.MACBLAKE3}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "loopback"},
+		{name: "mocknet", opts: Options{Mocknet: true}},
+		{name: "blake3 relay frames", opts: Options{
+			Mocknet: true,
+			Server:  func(r *relay_client.ServerRole) { r.RelayMACs = blake3 },
+			Client:  func(r *relay_client.ClientRole) { r.RelayMACs = blake3 },
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := New(t, tt.opts)
+			client, server := n.Pipe(t, relay_client.Destination{Service: "echo"})
+			if got := server.Info().Destination.Service; got != "echo" {
+				t.Fatalf("server got destination %q, want echo", got)
+			}
+			go func() { _, _ = io.Copy(server, server) }()
+			want := []byte("hello through the relay")
+			if _, err := client.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(want))
+			if _, err := io.ReadFull(client, got); err != nil || string(got) != string(want) {
+				t.Fatalf("echoed %q, err = %v, want %q", got, err, want)
+			}
+		})
+	}
+}
+
+type refuseAll struct{}
+
+func (refuseAll) VerifyPeer(context.Context, peer.ID) error { return errors.New("not verified") }
+
+func TestOpenStreamRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  func(r *relay_client.ServerRole)
+		relay   func(rm *relay_manager.RelayManager)
+		wantErr error
+	}{
+		{
+			name: "unknown target",
+			server: func(r *relay_client.ServerRole) {
+				r.CheckDestination = func(relay_client.Destination) error { return relay_client.ErrUnknownTarget }
+			},
+			wantErr: relay_client.ErrUnknownTarget,
+		},
+		{
+			name: "unauthorized",
+			server: func(r *relay_client.ServerRole) {
+				r.Authorize = func(peer.ID, *controlpb.StartRelayStreamRequest) error { return errors.New("not you") }
+			},
+			wantErr: relay_client.ErrUnauthorized,
+		},
+		{
+			name:    "server not verified by the relay",
+			relay:   func(rm *relay_manager.RelayManager) { rm.PeerVerifier = refuseAll{} },
+			wantErr: relay_client.ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := New(t, Options{Mocknet: true, Relay: tt.relay, Server: tt.server})
+			n.ServerRole.Handler = func(*relay_client.StreamInfo, net.Conn) {}
+			_, err := n.OpenStream(context.Background(), relay_client.Destination{Service: "echo"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("OpenStream() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
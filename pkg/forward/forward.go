@@ -0,0 +1,332 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package forward
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/status"
+)
+
+// Stats is a snapshot of the accounting of one forward.
+type Stats struct {
+	Connections       uint64
+	ActiveConnections int64
+	BytesTx           uint64 // local -> remote peer
+	BytesRx           uint64 // remote peer -> local
+	Errors            uint64
+}
+
+// Forward carries TCP connections, or UDP flows, between a local address and a
+// remote flymesh peer. Every metric, log line and counter of a forward is labelled
+// with its Name; a UDP flow counts as a connection.
+//
+// When ListenAddress is set, Start accepts local connections and carries each one
+// over a new connection obtained from Dial. Bridge and BridgePackets can be used
+// directly to account an already established pair (e.g. on the server peer side).
+type Forward struct {
+	Name          string
+	ListenAddress string
+	// Network is tcp, the default, or udp.
+	Network string
+	// Target describes the remote end, for logs and status only.
+	Target string
+	// Service is the service name requested from the remote peer, or served by
+	// Target on the server peer side. Empty means the default target.
+	Service string
+	// Priority is the class of traffic relay-servers schedule the streams of
+	// a client forward as, e.g. "bulk". Empty means that of the client.
+	Priority string
+	Dial     func(ctx context.Context) (net.Conn, error)
+	// IdleTimeout ends a UDP flow that carried no datagram for that long. 0
+	// means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Metrics receives the metrics of the forward. If nil, they are dropped.
+	Metrics metrics.Metrics
+
+	lis     net.Listener
+	pc      net.PacketConn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	bridges sync.WaitGroup
+	mu      sync.Mutex
+	open    map[net.Conn]struct{}
+	closed  bool
+
+	connections atomic.Uint64
+	active      atomic.Int64
+	bytesTx     atomic.Uint64
+	bytesRx     atomic.Uint64
+	errors      atomic.Uint64
+}
+
+// ParseSpec parses "[name=]address". name is empty if omitted.
+func ParseSpec(spec string) (name string, address string) {
+	if i := strings.Index(spec, "="); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return "", spec
+}
+
+func (f *Forward) logger() *slog.Logger {
+	return logging.Component(f.Logger, "forward").With(logging.KeyForward, f.Name)
+}
+
+func (f *Forward) metrics() metrics.Metrics {
+	return metrics.Or(f.Metrics)
+}
+
+// Start begins accepting local connections.
+func (f *Forward) Start(ctx context.Context) error {
+	if f.cancel != nil {
+		return errors.New("already started")
+	}
+	if f.Dial == nil {
+		return errors.New("forward has no Dial function")
+	}
+	f.ctx, f.cancel = context.WithCancel(ctx)
+	if f.Network == NetworkUDP {
+		return f.startPackets()
+	}
+	ln, err := net.Listen("tcp", f.ListenAddress)
+	if err != nil {
+		return err
+	}
+	f.lis = ln
+	f.logger().Info("listening", "addr", ln.Addr().String(), "target", f.Target)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.acceptLoop()
+	}()
+	return nil
+}
+
+// Stop closes the listener and waits for all carried connections to finish.
+func (f *Forward) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.closeListener()
+	f.wg.Wait()
+}
+
+func (f *Forward) closeListener() {
+	if f.lis != nil {
+		_ = f.lis.Close()
+	}
+	if f.pc != nil {
+		_ = f.pc.Close()
+	}
+}
+
+// Shutdown closes the listener and waits for carried connections, including ones
+// passed to Bridge directly, to finish until ctx is done. Connections still open at
+// that point are closed and ctx.Err() is returned.
+func (f *Forward) Shutdown(ctx context.Context) error {
+	f.closeListener()
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		f.bridges.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		f.mu.Lock()
+		for c := range f.open {
+			_ = c.Close()
+		}
+		f.mu.Unlock()
+		<-done
+	}
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.wg.Wait()
+	return err
+}
+
+// Stats returns a snapshot of the forward's counters.
+func (f *Forward) Stats() Stats {
+	return Stats{
+		Connections:       f.connections.Load(),
+		ActiveConnections: f.active.Load(),
+		BytesTx:           f.bytesTx.Load(),
+		BytesRx:           f.bytesRx.Load(),
+		Errors:            f.errors.Load(),
+	}
+}
+
+func (f *Forward) acceptLoop() {
+	for {
+		conn, err := f.lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || f.ctx.Err() != nil {
+				return
+			}
+			f.logger().Warn("accept error", "err", err)
+			continue
+		}
+		f.wg.Add(1)
+		go func(c net.Conn) {
+			defer f.wg.Done()
+			remote, err := f.Dial(f.ctx)
+			if err != nil {
+				f.Fail(err)
+				_ = c.Close()
+				return
+			}
+			f.Bridge(c, remote)
+		}(conn)
+	}
+}
+
+// Fail accounts a connection that could not be established.
+func (f *Forward) Fail(err error) {
+	f.errors.Add(1)
+	f.metrics().Counter(metrics.ForwardErrors, f.Name).Add(1)
+	f.logger().Warn("connection failed", "err", err)
+}
+
+// Bridge copies data between local and remote until either side closes, then closes both.
+func (f *Forward) Bridge(local net.Conn, remote net.Conn) {
+	f.bridge(local, remote, func() int64 {
+		n, _ := io.Copy(remote, local)
+		return n
+	}, func() int64 {
+		n, _ := io.Copy(local, remote)
+		return n
+	})
+}
+
+// bridge accounts the pair local and remote while tx copies local to remote and
+// rx remote to local, each returning the bytes it copied. When either returns,
+// the side it writes to is closed.
+func (f *Forward) bridge(local net.Conn, remote net.Conn, tx func() int64, rx func() int64) {
+	if !f.track(local, remote) {
+		_ = local.Close()
+		_ = remote.Close()
+		return
+	}
+	defer f.untrack(local, remote)
+
+	f.connections.Add(1)
+	f.active.Add(1)
+	f.metrics().Counter(metrics.ForwardConnections, f.Name).Add(1)
+	activeGauge := f.metrics().Gauge(metrics.ForwardActiveConnections, f.Name)
+	activeGauge.Add(1)
+	defer func() {
+		f.active.Add(-1)
+		activeGauge.Add(-1)
+	}()
+
+	logger := f.logger().With(logging.KeyRemoteAddr, local.RemoteAddr().String())
+	logger.Info("connection opened")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer remote.Close()
+		n := tx()
+		f.bytesTx.Add(uint64(n))
+		f.metrics().Counter(metrics.ForwardBytes, f.Name, "tx").Add(float64(n))
+	}()
+	go func() {
+		defer wg.Done()
+		defer local.Close()
+		n := rx()
+		f.bytesRx.Add(uint64(n))
+		f.metrics().Counter(metrics.ForwardBytes, f.Name, "rx").Add(float64(n))
+	}()
+	wg.Wait()
+
+	logger.Info("connection closed")
+}
+
+// track adds a bridged pair to the set closed by Shutdown. It returns false once
+// Shutdown has been called.
+func (f *Forward) track(local net.Conn, remote net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	if f.open == nil {
+		f.open = make(map[net.Conn]struct{})
+	}
+	f.open[local] = struct{}{}
+	f.open[remote] = struct{}{}
+	f.bridges.Add(1)
+	return true
+}
+
+func (f *Forward) untrack(local net.Conn, remote net.Conn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.open, local)
+	delete(f.open, remote)
+	f.bridges.Done()
+}
+
+// StatusInfo returns the forward as it appears in the status document.
+func (f *Forward) StatusInfo() status.ForwardInfo {
+	st := f.Stats()
+	return status.ForwardInfo{
+		Name:              f.Name,
+		Listen:            f.ListenAddress,
+		Network:           f.Network,
+		Target:            f.Target,
+		Connections:       st.Connections,
+		ActiveConnections: st.ActiveConnections,
+		BytesTx:           st.BytesTx,
+		BytesRx:           st.BytesRx,
+		Errors:            st.Errors,
+	}
+}
+
+// Set is a group of forwards run by one process.
+type Set []*Forward
+
+// Shutdown shuts every forward down concurrently and joins their errors.
+func (fs Set) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(fs))
+	var wg sync.WaitGroup
+	for i, f := range fs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// FillStatus implements status.Source.
+func (fs Set) FillStatus(s *status.Status) {
+	for _, f := range fs {
+		s.Forwards = append(s.Forwards, f.StatusInfo())
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/status"
+)
+
+var (
+	// ErrExists is returned when adding a forward whose name is taken.
+	ErrExists = errors.New("forward exists")
+	// ErrNotFound is returned for a forward name not in a Table.
+	ErrNotFound = errors.New("no such forward")
+)
+
+// Table is the set of forwards of a process, which forwards may join and leave
+// while it runs. Its zero value is empty and not running.
+type Table struct {
+	// Metrics is set on the forwards added without their own.
+	Metrics metrics.Metrics
+
+	mu       sync.Mutex
+	forwards Set
+	start    func(*Forward) error
+}
+
+// Add adds f under its name, and starts it if f listens and the table runs.
+func (t *Table) Add(f *Forward) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, other := range t.forwards {
+		if other.Name == f.Name {
+			return fmt.Errorf("%w: %s", ErrExists, f.Name)
+		}
+	}
+	if f.Metrics == nil {
+		f.Metrics = t.Metrics
+	}
+	if t.start != nil && f.ListenAddress != "" {
+		if err := t.start(f); err != nil {
+			return fmt.Errorf("start forward %s: %w", f.Name, err)
+		}
+	}
+	t.forwards = append(t.forwards, f)
+	return nil
+}
+
+// Run starts the listening forwards of the table with start, which sets their
+// Dial function and calls Start, and makes Add start the ones added later.
+func (t *Table) Run(start func(*Forward) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.start != nil {
+		return errors.New("already running")
+	}
+	for _, f := range t.forwards {
+		if f.ListenAddress == "" {
+			continue
+		}
+		if err := start(f); err != nil {
+			return fmt.Errorf("start forward %s: %w", f.Name, err)
+		}
+	}
+	t.start = start
+	return nil
+}
+
+// Remove removes the forward named name and shuts it down, closing the
+// connections still open when ctx is done.
+func (t *Table) Remove(ctx context.Context, name string) error {
+	f, err := t.Detach(name)
+	if err != nil {
+		return err
+	}
+	if err := f.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
+
+// Detach removes the forward named name and closes its listener, so that
+// another forward may take its address, and returns it. Its connections keep
+// running until the caller shuts it down.
+func (t *Table) Detach(name string) (*Forward, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, f := range t.forwards {
+		if f.Name == name {
+			t.forwards = append(t.forwards[:i:i], t.forwards[i+1:]...)
+			f.closeListener()
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Forwards returns the forwards of the table.
+func (t *Table) Forwards() Set {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(Set(nil), t.forwards...)
+}
+
+// Listening returns the forwards of the table accepting local connections.
+func (t *Table) Listening() Set {
+	var out Set
+	for _, f := range t.Forwards() {
+		if f.ListenAddress != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Shutdown shuts every forward down, see Set.Shutdown.
+func (t *Table) Shutdown(ctx context.Context) error {
+	return t.Forwards().Shutdown(ctx)
+}
+
+// FillStatus implements status.Source.
+func (t *Table) FillStatus(s *status.Status) {
+	t.Forwards().FillStatus(s)
+}
@@ -0,0 +1,325 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package forward
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+)
+
+// Networks of a Forward.
+const (
+	NetworkTCP = "tcp"
+	NetworkUDP = "udp"
+)
+
+// UDPALPN marks the streams carrying a UDP flow, one per local source address,
+// each datagram prefixed with its length as a big endian uint16.
+const UDPALPN = "flymesh-udp"
+
+// DefaultIdleTimeout ends UDP flows, which have no end of their own.
+const DefaultIdleTimeout = 2 * time.Minute
+
+// MaxDatagram is the largest datagram a stream carries.
+const MaxDatagram = 0xffff
+
+// flowQueue is how many datagrams of a flow wait while its stream opens or
+// falls behind. More are dropped, as on a congested path.
+const flowQueue = 64
+
+// ParseUDPSpec parses "[name=][bindAddress:]port:host:hostport", as ssh -L
+// does. The listen address defaults to the loopback interface.
+func ParseUDPSpec(spec string) (name string, listen string, target string, err error) {
+	name, rest := ParseSpec(spec)
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("bad UDP forward %q: want [bind:]port:host:port", spec)
+	}
+	head, port := rest[:i], rest[i+1:]
+	var host string
+	if strings.HasSuffix(head, "]") {
+		j := strings.LastIndex(head, "[")
+		if j < 0 {
+			return "", "", "", fmt.Errorf("bad UDP forward %q: unbalanced brackets", spec)
+		}
+		head, host = head[:j], strings.Trim(head[j:], "[]")
+	} else {
+		j := strings.LastIndex(head, ":")
+		head, host = head[:j+1], head[j+1:]
+	}
+	local, ok := strings.CutSuffix(head, ":")
+	if !ok || local == "" || host == "" {
+		return "", "", "", fmt.Errorf("bad UDP forward %q: want [bind:]port:host:port", spec)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", "", fmt.Errorf("bad UDP forward %q: bad port %q", spec, port)
+	}
+	target = net.JoinHostPort(host, port)
+	listen = local
+	if _, _, err := net.SplitHostPort(local); err != nil {
+		listen = net.JoinHostPort("127.0.0.1", local)
+	}
+	if _, p, _ := net.SplitHostPort(listen); p == "" {
+		return "", "", "", fmt.Errorf("bad UDP forward %q: missing local port", spec)
+	} else if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+		return "", "", "", fmt.Errorf("bad UDP forward %q: bad local port %q", spec, p)
+	}
+	return name, listen, target, nil
+}
+
+// WriteDatagram writes p to w, prefixed with its length.
+func WriteDatagram(w io.Writer, p []byte) error {
+	if len(p) > MaxDatagram {
+		return fmt.Errorf("datagram of %d bytes exceeds %d", len(p), MaxDatagram)
+	}
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadDatagram reads a datagram written by WriteDatagram into buf, which must
+// hold MaxDatagram bytes, and returns its length.
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n > len(buf) {
+		return 0, fmt.Errorf("datagram of %d bytes exceeds the buffer", n)
+	}
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return n, nil
+}
+
+// BridgePackets carries datagrams between local, every Read and Write of which
+// is one datagram as on a connected UDP socket, and remote, a stream framing
+// them with WriteDatagram. It closes both when either side closes or when no
+// datagram passed for IdleTimeout.
+func (f *Forward) BridgePackets(local net.Conn, remote net.Conn) {
+	idle := f.IdleTimeout
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	var last atomic.Int64
+	touch := func() {
+		last.Store(time.Now().UnixNano())
+	}
+	touch()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(idle / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, last.Load())) >= idle {
+					f.logger().Debug("UDP flow idle", logging.KeyRemoteAddr, local.RemoteAddr().String())
+					_ = local.Close()
+					_ = remote.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	f.bridge(local, remote, func() int64 {
+		var total int64
+		buf := make([]byte, MaxDatagram)
+		for {
+			n, err := local.Read(buf)
+			if err != nil {
+				if refused(err) {
+					continue
+				}
+				return total
+			}
+			touch()
+			if err := WriteDatagram(remote, buf[:n]); err != nil {
+				return total
+			}
+			total += int64(n)
+		}
+	}, func() int64 {
+		var total int64
+		buf := make([]byte, MaxDatagram)
+		for {
+			n, err := ReadDatagram(remote, buf)
+			if err != nil {
+				return total
+			}
+			touch()
+			if _, err := local.Write(buf[:n]); err != nil && !refused(err) {
+				return total
+			}
+			total += int64(n)
+		}
+	})
+}
+
+// refused reports the ICMP port unreachable a connected UDP socket returns
+// after a datagram found no listener. The flow goes on: the target may start.
+func refused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func (f *Forward) startPackets() error {
+	pc, err := net.ListenPacket("udp", f.ListenAddress)
+	if err != nil {
+		return err
+	}
+	f.pc = pc
+	f.logger().Info("listening", "addr", pc.LocalAddr().String(), "network", NetworkUDP, "target", f.Target)
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.packetLoop()
+	}()
+	return nil
+}
+
+// packetLoop reads the datagrams of the local listener and queues each one on
+// the flow of its source address, opening the flow on its first datagram.
+func (f *Forward) packetLoop() {
+	var (
+		mu    sync.Mutex
+		flows = make(map[string]*flow)
+	)
+	defer func() {
+		mu.Lock()
+		open := make([]*flow, 0, len(flows))
+		for _, fl := range flows {
+			open = append(open, fl)
+		}
+		mu.Unlock()
+		for _, fl := range open {
+			_ = fl.Close()
+		}
+	}()
+	buf := make([]byte, MaxDatagram)
+	for {
+		n, addr, err := f.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || f.ctx.Err() != nil {
+				return
+			}
+			f.logger().Warn("read error", "err", err)
+			continue
+		}
+		key := addr.String()
+		mu.Lock()
+		fl := flows[key]
+		if fl == nil {
+			fl = newFlow(f.pc, addr)
+			fl.onClose = func() {
+				mu.Lock()
+				if flows[key] == fl {
+					delete(flows, key)
+				}
+				mu.Unlock()
+			}
+			flows[key] = fl
+			f.wg.Add(1)
+			go func() {
+				defer f.wg.Done()
+				remote, err := f.Dial(f.ctx)
+				if err != nil {
+					f.Fail(err)
+					_ = fl.Close()
+					return
+				}
+				f.BridgePackets(fl, remote)
+			}()
+		}
+		mu.Unlock()
+		fl.push(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// flow is the net.Conn of one source address of a UDP listener: Read returns
+// the datagrams it sent, Write sends it one.
+type flow struct {
+	pc      net.PacketConn
+	addr    net.Addr
+	in      chan []byte
+	done    chan struct{}
+	once    sync.Once
+	onClose func()
+}
+
+func newFlow(pc net.PacketConn, addr net.Addr) *flow {
+	return &flow{
+		pc:   pc,
+		addr: addr,
+		in:   make(chan []byte, flowQueue),
+		done: make(chan struct{}),
+	}
+}
+
+// push queues p, or drops it if the queue is full.
+func (c *flow) push(p []byte) {
+	select {
+	case c.in <- p:
+	case <-c.done:
+	default:
+	}
+}
+
+func (c *flow) Read(b []byte) (int, error) {
+	select {
+	case p := <-c.in:
+		return copy(b, p), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *flow) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	return c.pc.WriteTo(b, c.addr)
+}
+
+func (c *flow) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *flow) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *flow) RemoteAddr() net.Addr { return c.addr }
+
+// Flows end on Close or when idle, never on a deadline.
+func (c *flow) SetDeadline(time.Time) error      { return nil }
+func (c *flow) SetReadDeadline(time.Time) error  { return nil }
+func (c *flow) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,112 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package forward
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestParseUDPSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		name   string
+		listen string
+		target string
+		bad    bool
+	}{
+		{spec: "5353:10.0.0.1:53", listen: "127.0.0.1:5353", target: "10.0.0.1:53"},
+		{spec: "dns=5353:resolver.lan:53", name: "dns", listen: "127.0.0.1:5353", target: "resolver.lan:53"},
+		{spec: "0.0.0.0:5353:10.0.0.1:53", listen: "0.0.0.0:5353", target: "10.0.0.1:53"},
+		{spec: ":5353:10.0.0.1:53", listen: ":5353", target: "10.0.0.1:53"},
+		{spec: "[::1]:5353:10.0.0.1:53", listen: "[::1]:5353", target: "10.0.0.1:53"},
+		{spec: "5353:[2001:db8::53]:53", listen: "127.0.0.1:5353", target: "[2001:db8::53]:53"},
+		{spec: "[::]:5353:[2001:db8::53]:53", listen: "[::]:5353", target: "[2001:db8::53]:53"},
+		{spec: "10.0.0.1:53", bad: true},
+		{spec: "53", bad: true},
+		{spec: "", bad: true},
+		{spec: "5353:10.0.0.1:", bad: true},
+		{spec: "5353:10.0.0.1:65536", bad: true},
+		{spec: "5353::53", bad: true},
+		{spec: "70000:10.0.0.1:53", bad: true},
+		{spec: "0.0.0.0::10.0.0.1:53", bad: true},
+		{spec: "5353:2001:db8::53]:53", bad: true},
+		{spec: "x=10.0.0.1:53", bad: true},
+	}
+	for _, tt := range tests {
+		name, listen, target, err := ParseUDPSpec(tt.spec)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("ParseUDPSpec(%q) = %q, %q, %q, want an error", tt.spec, name, listen, target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseUDPSpec(%q) err = %v", tt.spec, err)
+			continue
+		}
+		if name != tt.name || listen != tt.listen || target != tt.target {
+			t.Errorf("ParseUDPSpec(%q) = %q, %q, %q, want %q, %q, %q", tt.spec, name, listen, target, tt.name, tt.listen, tt.target)
+		}
+	}
+}
+
+func TestDatagramFraming(t *testing.T) {
+	datagrams := [][]byte{
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte{0xab}, 1400),
+		bytes.Repeat([]byte{0xcd}, MaxDatagram),
+		[]byte("last"),
+	}
+	var stream bytes.Buffer
+	for _, d := range datagrams {
+		if err := WriteDatagram(&stream, d); err != nil {
+			t.Fatalf("WriteDatagram(%d bytes) err = %v", len(d), err)
+		}
+	}
+	buf := make([]byte, MaxDatagram)
+	for i, d := range datagrams {
+		n, err := ReadDatagram(&stream, buf)
+		if err != nil {
+			t.Fatalf("ReadDatagram() %d err = %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], d) {
+			t.Fatalf("datagram %d of %d bytes, want %d", i, n, len(d))
+		}
+	}
+	if _, err := ReadDatagram(&stream, buf); err != io.EOF {
+		t.Fatalf("ReadDatagram() at the end err = %v, want io.EOF", err)
+	}
+}
+
+func TestDatagramFramingRejects(t *testing.T) {
+	if err := WriteDatagram(io.Discard, make([]byte, MaxDatagram+1)); err == nil {
+		t.Error("WriteDatagram() of an oversized datagram err = nil")
+	}
+	tests := []struct {
+		name string
+		wire []byte
+		buf  int
+		err  error
+	}{
+		{name: "truncated length", wire: []byte{0x00}, buf: MaxDatagram, err: io.ErrUnexpectedEOF},
+		{name: "truncated datagram", wire: []byte{0x00, 0x04, 'a', 'b'}, buf: MaxDatagram, err: io.ErrUnexpectedEOF},
+		{name: "empty datagram missing", wire: []byte{0x00, 0x01}, buf: MaxDatagram, err: io.ErrUnexpectedEOF},
+		{name: "larger than the buffer", wire: []byte{0x00, 0x05, 'a', 'b', 'c', 'd', 'e'}, buf: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadDatagram(bytes.NewReader(tt.wire), make([]byte, tt.buf))
+			if err == nil {
+				t.Fatal("ReadDatagram() err = nil")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ReadDatagram() err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
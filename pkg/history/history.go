@@ -0,0 +1,537 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package history keeps the throughput and latency history of peers and relays
+// beyond the lifetime of the process. Each series is a ring file of fixed size
+// holding one slot per interval of the retention period, so that the store
+// never grows past MaxSeries files and old slots are overwritten in place.
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+)
+
+// Kinds of series.
+const (
+	// KindPeer series are keyed by the peer ID of the remote peer.
+	KindPeer = "peer"
+	// KindRelay series are keyed by the relay-server endpoint.
+	KindRelay = "relay"
+)
+
+// DefaultMaxSeries is the MaxSeries of Open.
+const DefaultMaxSeries = 1024
+
+var ErrBadFile = errors.New("bad history file")
+
+// Series identifies a history.
+type Series struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+}
+
+// Sample is activity added to a series.
+type Sample struct {
+	BytesIn  uint64
+	BytesOut uint64
+	// Streams counts streams opened.
+	Streams uint64
+	// Latency is the setup time of a stream, if non-zero.
+	Latency time.Duration
+}
+
+// Point is the activity of a series during one interval.
+type Point struct {
+	Time           time.Time `json:"time"`
+	BytesIn        uint64    `json:"bytes_in"`
+	BytesOut       uint64    `json:"bytes_out"`
+	Streams        uint64    `json:"streams"`
+	LatencySamples uint64    `json:"latency_samples,omitempty"`
+	LatencyAvgMs   float64   `json:"latency_avg_ms,omitempty"`
+	LatencyMaxMs   float64   `json:"latency_max_ms,omitempty"`
+}
+
+// Ring file layout, all integers LE:
+//
+//	header (headerSize bytes): "FLYH", version (2B), slot count (4B),
+//	interval in seconds (8B), kind length (2B), kind, key length (2B), key
+//	slots (slotSize bytes each): start in unix seconds, bytes in, bytes out,
+//	streams, latency samples, latency sum and latency max in microseconds
+//
+// A slot whose start is not that of the interval it is read for is empty.
+const (
+	fileMagic   = "FLYH"
+	fileVersion = 1
+	headerSize  = 512
+	slotSize    = 7 * 8
+	maxKeyLen   = headerSize - 24
+)
+
+type slot struct {
+	start      int64
+	bytesIn    uint64
+	bytesOut   uint64
+	streams    uint64
+	latencyN   uint64
+	latencySum uint64
+	latencyMax uint64
+}
+
+func (sl *slot) add(sample Sample) {
+	sl.bytesIn += sample.BytesIn
+	sl.bytesOut += sample.BytesOut
+	sl.streams += sample.Streams
+	if sample.Latency > 0 {
+		us := uint64(sample.Latency.Microseconds())
+		sl.latencyN++
+		sl.latencySum += us
+		sl.latencyMax = max(sl.latencyMax, us)
+	}
+}
+
+func (sl *slot) marshal() []byte {
+	b := make([]byte, 0, slotSize)
+	for _, v := range []uint64{uint64(sl.start), sl.bytesIn, sl.bytesOut, sl.streams, sl.latencyN, sl.latencySum, sl.latencyMax} {
+		b = binary.LittleEndian.AppendUint64(b, v)
+	}
+	return b
+}
+
+func unmarshalSlot(b []byte) slot {
+	u := func(i int) uint64 { return binary.LittleEndian.Uint64(b[i*8:]) }
+	return slot{
+		start:      int64(u(0)),
+		bytesIn:    u(1),
+		bytesOut:   u(2),
+		streams:    u(3),
+		latencyN:   u(4),
+		latencySum: u(5),
+		latencyMax: u(6),
+	}
+}
+
+func (sl *slot) point() Point {
+	p := Point{
+		Time:           time.Unix(sl.start, 0).UTC(),
+		BytesIn:        sl.bytesIn,
+		BytesOut:       sl.bytesOut,
+		Streams:        sl.streams,
+		LatencySamples: sl.latencyN,
+	}
+	if sl.latencyN > 0 {
+		p.LatencyAvgMs = float64(sl.latencySum) / float64(sl.latencyN) / 1000
+		p.LatencyMaxMs = float64(sl.latencyMax) / 1000
+	}
+	return p
+}
+
+// ring is the file of a series and its slots not written yet.
+type ring struct {
+	path    string
+	exists  bool
+	last    int64 // start of the latest slot with activity
+	pending map[int64]*slot
+}
+
+// Store records series in ring files under a directory.
+type Store struct {
+	// MaxSeries caps the series kept. Activity of further series is dropped.
+	MaxSeries int
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	dir      string
+	interval int64 // seconds
+	slots    int
+
+	mu      sync.Mutex
+	rings   map[Series]*ring
+	tracked map[*tracker]struct{}
+	full    bool // MaxSeries was reached, and reported
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the store in dir, keeping retention of history at a resolution of
+// interval. Series recorded with another interval or retention are ignored,
+// and overwritten should they be recorded again; those without activity for
+// longer than retention are deleted.
+func Open(dir string, interval, retention time.Duration) (*Store, error) {
+	if interval < time.Second || retention < interval {
+		return nil, fmt.Errorf("history interval %s and retention %s: want 1s <= interval <= retention", interval, retention)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		MaxSeries: DefaultMaxSeries,
+		dir:       dir,
+		interval:  int64(interval / time.Second),
+		slots:     int(retention / interval),
+		rings:     make(map[Series]*ring),
+		tracked:   make(map[*tracker]struct{}),
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.ring"))
+	if err != nil {
+		return nil, err
+	}
+	horizon := time.Now().Unix() - int64(s.slots)*s.interval
+	for _, path := range paths {
+		series, last, err := s.load(path)
+		if err != nil {
+			s.logger().Warn("history file ignored", "path", path, "err", err)
+			continue
+		}
+		if last < horizon {
+			_ = os.Remove(path)
+			continue
+		}
+		s.rings[series] = &ring{path: path, exists: true, last: last, pending: make(map[int64]*slot)}
+	}
+	return s, nil
+}
+
+func (s *Store) logger() *slog.Logger {
+	return logging.Component(s.Logger, "history")
+}
+
+// load reads the header of the ring file at path and returns its series and
+// the start of its latest slot.
+func (s *Store) load(path string) (Series, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Series{}, 0, err
+	}
+	if len(data) < headerSize || string(data[:4]) != fileMagic || binary.LittleEndian.Uint16(data[4:]) != fileVersion {
+		return Series{}, 0, ErrBadFile
+	}
+	slots := int(binary.LittleEndian.Uint32(data[6:]))
+	interval := int64(binary.LittleEndian.Uint64(data[10:]))
+	if slots != s.slots || interval != s.interval {
+		return Series{}, 0, fmt.Errorf("%w: %d slots of %ds, want %d of %ds", ErrBadFile, slots, interval, s.slots, s.interval)
+	}
+	if len(data) != headerSize+slots*slotSize {
+		return Series{}, 0, fmt.Errorf("%w: truncated", ErrBadFile)
+	}
+	var series Series
+	off := 18
+	for _, dst := range []*string{&series.Kind, &series.Key} {
+		n := int(binary.LittleEndian.Uint16(data[off:]))
+		off += 2
+		if off+n > headerSize {
+			return Series{}, 0, ErrBadFile
+		}
+		*dst = string(data[off : off+n])
+		off += n
+	}
+	var last int64
+	for i := range slots {
+		sl := unmarshalSlot(data[headerSize+i*slotSize:])
+		last = max(last, sl.start)
+	}
+	return series, last, nil
+}
+
+// Start samples the tracked streams and writes the history to disk in the
+// background, until Close.
+func (s *Store) Start() {
+	s.stop = make(chan struct{})
+	period := min(time.Duration(s.interval)*time.Second, 10*time.Second)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+				s.flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close samples the tracked streams a last time and writes the history to
+// disk.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	if s.stop != nil {
+		close(s.stop)
+		s.wg.Wait()
+	}
+	s.sample()
+	return s.flush()
+}
+
+// Add adds sample to series, in the interval of t. A nil Store drops it.
+func (s *Store) Add(series Series, t time.Time, sample Sample) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addLocked(series, t, sample)
+}
+
+func (s *Store) addLocked(series Series, t time.Time, sample Sample) {
+	r := s.ringLocked(series)
+	if r == nil {
+		return
+	}
+	start := t.Unix() / s.interval * s.interval
+	sl, ok := r.pending[start]
+	if !ok {
+		sl = &slot{start: start}
+		if stored, err := s.readSlot(r, start); err == nil && stored.start == start {
+			sl = &stored
+		}
+		r.pending[start] = sl
+	}
+	sl.add(sample)
+	r.last = max(r.last, start)
+}
+
+// ringLocked returns the ring of series, or nil if there is no room for it.
+func (s *Store) ringLocked(series Series) *ring {
+	if r, ok := s.rings[series]; ok {
+		return r
+	}
+	if len(series.Kind)+len(series.Key) > maxKeyLen {
+		return nil
+	}
+	if s.MaxSeries > 0 && len(s.rings) >= s.MaxSeries {
+		if !s.full {
+			s.full = true
+			s.logger().Warn("history series limit reached, dropping new series", "max_series", s.MaxSeries)
+		}
+		return nil
+	}
+	sum := sha256.Sum256([]byte(series.Key))
+	r := &ring{
+		path:    filepath.Join(s.dir, series.Kind+"-"+hex.EncodeToString(sum[:12])+".ring"),
+		pending: make(map[int64]*slot),
+	}
+	s.rings[series] = r
+	return r
+}
+
+func (s *Store) offset(start int64) int64 {
+	return headerSize + (start/s.interval)%int64(s.slots)*slotSize
+}
+
+func (s *Store) readSlot(r *ring, start int64) (slot, error) {
+	if !r.exists {
+		return slot{}, os.ErrNotExist
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		return slot{}, err
+	}
+	defer f.Close()
+	b := make([]byte, slotSize)
+	if _, err := f.ReadAt(b, s.offset(start)); err != nil {
+		return slot{}, err
+	}
+	return unmarshalSlot(b), nil
+}
+
+// flush writes the pending slots of every series.
+func (s *Store) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for series, r := range s.rings {
+		if err := s.flushLocked(series, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		s.logger().Warn("write history failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) flushLocked(series Series, r *ring) error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	flags := os.O_WRONLY
+	if !r.exists {
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(r.path, flags, 0o600)
+	if err != nil {
+		return err
+	}
+	if !r.exists {
+		if err := s.writeHeader(f, series); err != nil {
+			_ = f.Close()
+			return err
+		}
+		r.exists = true
+	}
+	for start, sl := range r.pending {
+		if _, err := f.WriteAt(sl.marshal(), s.offset(start)); err != nil {
+			_ = f.Close()
+			return err
+		}
+		delete(r.pending, start)
+	}
+	return f.Close()
+}
+
+func (s *Store) writeHeader(f *os.File, series Series) error {
+	hdr := make([]byte, 0, headerSize)
+	hdr = append(hdr, fileMagic...)
+	hdr = binary.LittleEndian.AppendUint16(hdr, fileVersion)
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(s.slots))
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(s.interval))
+	for _, v := range []string{series.Kind, series.Key} {
+		hdr = binary.LittleEndian.AppendUint16(hdr, uint16(len(v)))
+		hdr = append(hdr, v...)
+	}
+	hdr = hdr[:headerSize]
+	if _, err := f.WriteAt(hdr, 0); err != nil {
+		return err
+	}
+	return f.Truncate(headerSize + int64(s.slots)*slotSize)
+}
+
+// Query returns the points of series with activity between from and to,
+// oldest first.
+func (s *Store) Query(series Series, from, to time.Time) ([]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rings[series]
+	if !ok {
+		return nil, nil
+	}
+	if err := s.flushLocked(series, r); err != nil {
+		return nil, err
+	}
+	if !r.exists {
+		return nil, nil
+	}
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, s.slots*slotSize)
+	if _, err := f.ReadAt(data, headerSize); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var points []Point
+	for i := range s.slots {
+		sl := unmarshalSlot(data[i*slotSize:])
+		if sl.start == 0 || sl.start < from.Unix()/s.interval*s.interval || sl.start >= to.Unix() {
+			continue
+		}
+		points = append(points, sl.point())
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+// SeriesInfo is a series with the time of its latest activity.
+type SeriesInfo struct {
+	Series
+	Last time.Time `json:"last"`
+}
+
+// List returns the series of the store, most recently active first.
+func (s *Store) List() []SeriesInfo {
+	s.mu.Lock()
+	out := make([]SeriesInfo, 0, len(s.rings))
+	for series, r := range s.rings {
+		out = append(out, SeriesInfo{Series: series, Last: time.Unix(r.last, 0).UTC()})
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Last.Equal(out[j].Last) {
+			return out[i].Last.After(out[j].Last)
+		}
+		return out[i].Kind+out[i].Key < out[j].Kind+out[j].Key
+	})
+	return out
+}
+
+// Interval returns the resolution of the store.
+func (s *Store) Interval() time.Duration {
+	return time.Duration(s.interval) * time.Second
+}
+
+type tracker struct {
+	series  []Series
+	counter func() (in, out uint64)
+	in, out uint64
+}
+
+// Track counts a stream opened on every series, then samples the byte
+// counters of the stream into them until the returned func is called. A nil
+// Store tracks nothing.
+func (s *Store) Track(series []Series, counter func() (in, out uint64)) (untrack func()) {
+	if s == nil {
+		return func() {}
+	}
+	t := &tracker{series: series, counter: counter}
+	now := time.Now()
+	s.mu.Lock()
+	for _, ser := range series {
+		s.addLocked(ser, now, Sample{Streams: 1})
+	}
+	s.tracked[t] = struct{}{}
+	s.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.sampleLocked(t, time.Now())
+			delete(s.tracked, t)
+		})
+	}
+}
+
+func (s *Store) sample() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.tracked {
+		s.sampleLocked(t, now)
+	}
+}
+
+func (s *Store) sampleLocked(t *tracker, now time.Time) {
+	in, out := t.counter()
+	sample := Sample{BytesIn: in - t.in, BytesOut: out - t.out}
+	t.in, t.out = in, out
+	if sample.BytesIn == 0 && sample.BytesOut == 0 {
+		return
+	}
+	for _, ser := range t.series {
+		s.addLocked(ser, now, sample)
+	}
+}
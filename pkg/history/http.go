@@ -0,0 +1,98 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultQueryRange is the range a query covers when it gives no from.
+const defaultQueryRange = 24 * time.Hour
+
+type listResponse struct {
+	IntervalSeconds int64        `json:"interval_seconds"`
+	Series          []SeriesInfo `json:"series"`
+}
+
+type queryResponse struct {
+	IntervalSeconds int64     `json:"interval_seconds"`
+	Series          Series    `json:"series"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Points          []Point   `json:"points"`
+}
+
+// Handler serves the store as JSON. Without parameters it lists the series.
+// With kind and key it returns the points of that series between from and to,
+// RFC 3339 times defaulting to the last 24 hours; since, a duration such as
+// 6h, may replace from.
+func (s *Store) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("kind") == "" && q.Get("key") == "" {
+			writeJSON(w, http.StatusOK, &listResponse{
+				IntervalSeconds: s.interval,
+				Series:          s.List(),
+			})
+			return
+		}
+		from, to, err := queryRange(q.Get("from"), q.Get("to"), q.Get("since"), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series := Series{Kind: q.Get("kind"), Key: q.Get("key")}
+		points, err := s.Query(series, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if points == nil {
+			points = []Point{}
+		}
+		writeJSON(w, http.StatusOK, &queryResponse{
+			IntervalSeconds: s.interval,
+			Series:          series,
+			From:            from.UTC(),
+			To:              to.UTC(),
+			Points:          points,
+		})
+	})
+}
+
+func queryRange(fromArg, toArg, sinceArg string, now time.Time) (from, to time.Time, err error) {
+	to = now
+	if toArg != "" {
+		if to, err = time.Parse(time.RFC3339, toArg); err != nil {
+			return from, to, fmt.Errorf("bad to: %w", err)
+		}
+	}
+	from = to.Add(-defaultQueryRange)
+	switch {
+	case fromArg != "" && sinceArg != "":
+		return from, to, fmt.Errorf("from and since are exclusive")
+	case fromArg != "":
+		if from, err = time.Parse(time.RFC3339, fromArg); err != nil {
+			return from, to, fmt.Errorf("bad from: %w", err)
+		}
+	case sinceArg != "":
+		since, err := time.ParseDuration(sinceArg)
+		if err != nil || since <= 0 {
+			return from, to, fmt.Errorf("bad since %q", sinceArg)
+		}
+		from = to.Add(-since)
+	}
+	return from, to, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,245 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package identity loads the private key of a node from a Provider, chosen by
+// the scheme of a reference such as env:FLYMESH_KEY, keyring:node or
+// exec:secret-tool lookup flymesh node. A reference without a registered
+// scheme is a key file path.
+//
+// Backends holding keys outside the process, such as a PKCS #11 token, plug in
+// with Register. Their PrivKey only needs to sign: libp2p never asks for the
+// raw key.
+package identity
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/flymesh/core/pkg/util"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// Provider holds the identity key of a node.
+type Provider interface {
+	PrivateKey(ctx context.Context) (crypto.PrivKey, error)
+}
+
+// Options configure a Provider.
+type Options struct {
+	// Passphrase opens encrypted keys, and encrypts the key files of the file
+	// provider.
+	Passphrase []byte
+}
+
+// Factory returns the Provider for ref, the reference without its scheme.
+type Factory func(ref string, opts Options) (Provider, error)
+
+// ExecTimeout bounds the command of the exec provider.
+const ExecTimeout = 30 * time.Second
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		"file":    newFile,
+		"env":     newEnv,
+		"exec":    newExec,
+		"keyring": newKeyring,
+	}
+)
+
+// Register makes the provider built by f available under scheme, replacing
+// any other.
+func Register(scheme string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[scheme] = f
+}
+
+// Schemes returns the registered schemes, sorted.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return slices.Sorted(maps.Keys(factories))
+}
+
+// Open returns the Provider for spec, scheme:ref or a file path.
+func Open(spec string, opts Options) (Provider, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("empty identity reference")
+	}
+	scheme, ref, ok := strings.Cut(spec, ":")
+	mu.RLock()
+	f, registered := factories[scheme]
+	mu.RUnlock()
+	if !ok || !registered {
+		return newFile(spec, opts)
+	}
+	return f(ref, opts)
+}
+
+// Load returns the private key spec refers to.
+func Load(ctx context.Context, spec string, opts Options) (crypto.PrivKey, error) {
+	p, err := Open(spec, opts)
+	if err != nil {
+		return nil, err
+	}
+	return p.PrivateKey(ctx)
+}
+
+// file is a key file, created on first use.
+type file struct {
+	path       string
+	passphrase []byte
+}
+
+func newFile(ref string, opts Options) (Provider, error) {
+	return &file{path: ref, passphrase: opts.Passphrase}, nil
+}
+
+func (f *file) PrivateKey(context.Context) (crypto.PrivKey, error) {
+	return util.LoadOrCreatePrivateKey(f.path, f.passphrase)
+}
+
+// env is a key in an environment variable, as set by secrets managers.
+type env struct {
+	name       string
+	passphrase []byte
+}
+
+func newEnv(ref string, opts Options) (Provider, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("env identity: missing variable name")
+	}
+	return &env{name: ref, passphrase: opts.Passphrase}, nil
+}
+
+func (e *env) PrivateKey(context.Context) (crypto.PrivKey, error) {
+	v, ok := os.LookupEnv(e.name)
+	if !ok || v == "" {
+		return nil, fmt.Errorf("env identity: %s is not set", e.name)
+	}
+	priv, err := decode([]byte(v), e.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("env identity %s: %w", e.name, err)
+	}
+	return priv, nil
+}
+
+// command prints a key, e.g. a keychain or secrets manager client. It runs
+// without a shell, its words split and quoted as a shell would, see splitWords.
+type command struct {
+	args       []string
+	passphrase []byte
+}
+
+func newExec(ref string, opts Options) (Provider, error) {
+	args, err := splitWords(ref)
+	if err != nil {
+		return nil, fmt.Errorf("exec identity: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("exec identity: missing command")
+	}
+	return &command{args: args, passphrase: opts.Passphrase}, nil
+}
+
+func (c *command) PrivateKey(ctx context.Context) (crypto.PrivKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, ExecTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("exec identity %s: %w", c.args[0], err)
+	}
+	priv, err := decode(out, c.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("exec identity %s: %w", c.args[0], err)
+	}
+	return priv, nil
+}
+
+// decode decodes a key printed as text: PEM, or the raw key in base64.
+func decode(data []byte, passphrase []byte) (crypto.PrivKey, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		raw, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("neither PEM nor base64: %w", err)
+		}
+		data = raw
+	}
+	priv, _, err := keyfile.Unmarshal(data, passphrase)
+	return priv, err
+}
+
+// splitWords splits s into words at blanks, as a POSIX shell does without
+// expansions: single quotes keep their content as is, double quotes keep it
+// but for backslash escapes of ", \, $ and `, and a backslash outside quotes
+// escapes the next character.
+func splitWords(s string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		// inWord is set once word started, even empty, as in "".
+		inWord bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += 1 + end
+			inWord = true
+		case '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+			inWord = true
+		case '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package identity
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/zalando/go-keyring"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		bad  bool
+	}{
+		{in: "", want: nil},
+		{in: "  \t ", want: nil},
+		{in: "secret-tool lookup flymesh node", want: []string{"secret-tool", "lookup", "flymesh", "node"}},
+		{in: "  a   b  ", want: []string{"a", "b"}},
+		{in: `"/opt/my tools/get key" --name node`, want: []string{"/opt/my tools/get key", "--name", "node"}},
+		{in: `get 'it''s' "a \"b\" \\ \$ \x"`, want: []string{"get", "its", `a "b" \ $ \x`}},
+		{in: `a\ b c\'d`, want: []string{"a b", "c'd"}},
+		{in: `x "" ''`, want: []string{"x", "", ""}},
+		{in: `pre"mid"'post'`, want: []string{"premidpost"}},
+		{in: `'no end`, bad: true},
+		{in: `"no end`, bad: true},
+		{in: `"escaped end\"`, bad: true},
+		{in: `trailing\`, bad: true},
+	}
+	for _, tt := range tests {
+		got, err := splitWords(tt.in)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("splitWords(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitWords(%q) err = %v", tt.in, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	priv, err := keyfile.Generate("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := keyfile.Marshal(priv, keyfile.FormatRaw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem, err := keyfile.Marshal(priv, keyfile.FormatPEM, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]string{
+		"base64": base64.StdEncoding.EncodeToString(raw),
+		"pem":    "\n" + string(pem) + "\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("FLYMESH_TEST_KEY", v)
+			got, err := Load(context.Background(), "env:FLYMESH_TEST_KEY", Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equals(priv) {
+				t.Fatal("loaded another key")
+			}
+		})
+	}
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("FLYMESH_TEST_KEY", "")
+		if _, err := Load(context.Background(), "env:FLYMESH_TEST_KEY", Options{}); err == nil {
+			t.Fatal("Load() of an unset variable err = nil")
+		}
+	})
+}
+
+func TestKeyringProvider(t *testing.T) {
+	keyring.MockInit()
+	passphrase := []byte("correct horse")
+
+	first, err := Load(context.Background(), "keyring:node", Options{Passphrase: passphrase})
+	if err != nil {
+		t.Fatalf("Load() creating the key err = %v", err)
+	}
+	stored, err := keyring.Get(KeyringService, "node")
+	if err != nil {
+		t.Fatalf("key not stored: %v", err)
+	}
+	if _, format, err := keyfile.Unmarshal([]byte(stored), passphrase); err != nil || format != keyfile.FormatEncrypted {
+		t.Fatalf("stored key format %q, err = %v, want encrypted", format, err)
+	}
+	again, err := Load(context.Background(), "keyring:node", Options{Passphrase: passphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equals(first) {
+		t.Fatal("second Load() returned another key")
+	}
+	if _, err := Load(context.Background(), "keyring:node", Options{Passphrase: []byte("wrong")}); err == nil {
+		t.Fatal("Load() with a wrong passphrase err = nil")
+	}
+
+	other, err := Load(context.Background(), "keyring:vault/node", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Equals(first) {
+		t.Fatal("keyring:vault/node returned the key of keyring:node")
+	}
+
+	for _, ref := range []string{"keyring:", "keyring:/node", "keyring:vault/"} {
+		if _, err := Open(ref, Options{}); err == nil {
+			t.Errorf("Open(%q) err = nil", ref)
+		}
+	}
+}
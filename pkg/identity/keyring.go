@@ -0,0 +1,78 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringService is the service of the keyring entries a keyring reference
+// names by account alone.
+const KeyringService = "flymesh"
+
+// keyringKey is a key in the secure store of the OS: the macOS keychain, the
+// Secret Service of a Linux desktop session, such as GNOME Keyring or KWallet,
+// or the Windows Credential Manager. It is created on first use, in PEM,
+// encrypted if a passphrase is given.
+//
+// The reference is ACCOUNT, or SERVICE/ACCOUNT for an entry outside
+// KeyringService.
+type keyringKey struct {
+	service    string
+	account    string
+	passphrase []byte
+}
+
+func newKeyring(ref string, opts Options) (Provider, error) {
+	service, account, ok := strings.Cut(ref, "/")
+	if !ok {
+		service, account = KeyringService, ref
+	}
+	if service == "" || account == "" {
+		return nil, fmt.Errorf("keyring identity: want ACCOUNT or SERVICE/ACCOUNT, got %q", ref)
+	}
+	return &keyringKey{service: service, account: account, passphrase: opts.Passphrase}, nil
+}
+
+func (k *keyringKey) PrivateKey(context.Context) (crypto.PrivKey, error) {
+	secret, err := keyring.Get(k.service, k.account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return k.create()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("keyring identity %s/%s: %w", k.service, k.account, err)
+	}
+	priv, err := decode([]byte(secret), k.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("keyring identity %s/%s: %w", k.service, k.account, err)
+	}
+	return priv, nil
+}
+
+// create stores a new key under the entry of k.
+func (k *keyringKey) create() (crypto.PrivKey, error) {
+	priv, err := keyfile.Generate("ed25519")
+	if err != nil {
+		return nil, err
+	}
+	format := keyfile.FormatPEM
+	if len(k.passphrase) > 0 {
+		format = keyfile.FormatEncrypted
+	}
+	data, err := keyfile.Marshal(priv, format, k.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.Set(k.service, k.account, string(data)); err != nil {
+		return nil, fmt.Errorf("keyring identity %s/%s: store new key: %w", k.service, k.account, err)
+	}
+	return priv, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package keyfile
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// FormatEncrypted is a PEM block holding the raw key sealed with
+// ChaCha20-Poly1305 under a key derived from a passphrase by scrypt.
+const FormatEncrypted = "encrypted"
+
+// PassphraseEnv is the environment variable ReadPassphrase falls back to.
+const PassphraseEnv = "FLYMESH_KEY_PASSPHRASE"
+
+const (
+	pemEncrypted = "FLYMESH ENCRYPTED PRIVATE KEY"
+	kdfScrypt    = "scrypt"
+	saltLen      = 16
+
+	// scrypt cost of new files, about 100ms and 32MiB.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// scryptMaxMem and scryptMaxP bound the cost a file may ask for when it
+	// is read.
+	scryptMaxMem = 1 << 30
+	scryptMaxP   = 16
+)
+
+var (
+	// ErrPassphraseRequired is returned for an encrypted key without a
+	// passphrase.
+	ErrPassphraseRequired = errors.New("key file is encrypted: passphrase required")
+	// ErrBadPassphrase is returned when the passphrase does not open the key.
+	ErrBadPassphrase = errors.New("wrong passphrase or corrupted key file")
+)
+
+// ReadPassphrase returns the passphrase in file, without its trailing line
+// break, or else the value of PassphraseEnv. It returns nil if neither is
+// set.
+func ReadPassphrase(file string) ([]byte, error) {
+	if file == "" {
+		if v, ok := os.LookupEnv(PassphraseEnv); ok && v != "" {
+			return []byte(v), nil
+		}
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s: empty passphrase", file)
+	}
+	return data, nil
+}
+
+func marshalEncrypted(priv crypto.PrivKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	plain, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: pemEncrypted,
+		Headers: map[string]string{
+			"KDF":        kdfScrypt,
+			"KDF-Params": fmt.Sprintf("N=%d,r=%d,p=%d", scryptN, scryptR, scryptP),
+			"Salt":       base64.StdEncoding.EncodeToString(salt),
+			"Nonce":      base64.StdEncoding.EncodeToString(nonce),
+		},
+		Bytes: aead.Seal(nil, nonce, plain, nil),
+	}), nil
+}
+
+func unmarshalEncrypted(block *pem.Block, passphrase []byte) (crypto.PrivKey, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrPassphraseRequired
+	}
+	if kdf := block.Headers["KDF"]; kdf != kdfScrypt {
+		return nil, fmt.Errorf("unsupported key derivation %q", kdf)
+	}
+	var n, r, p int
+	if _, err := fmt.Sscanf(block.Headers["KDF-Params"], "N=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+		return nil, fmt.Errorf("bad KDF-Params: %w", err)
+	}
+	if n <= 1 || n&(n-1) != 0 || r < 1 || p < 1 || p > scryptMaxP || 128*r > scryptMaxMem/n {
+		return nil, fmt.Errorf("scrypt parameters out of range: N=%d,r=%d,p=%d", n, r, p)
+	}
+	salt, err := base64.StdEncoding.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("bad salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("bad nonce: %w", err)
+	}
+	aead, err := newAEAD(passphrase, salt, n, r, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("bad nonce length %d", len(nonce))
+	}
+	plain, err := aead.Open(nil, nonce, block.Bytes, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return crypto.UnmarshalPrivateKey(plain)
+}
+
+func newAEAD(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
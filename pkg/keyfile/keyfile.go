@@ -0,0 +1,236 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package keyfile reads and writes the private key files of flymesh nodes,
+// either raw, the protobuf encoding of libp2p, or PEM: PKCS #8 for Ed25519,
+// RSA and ECDSA keys, SEC 1 for Secp256k1 keys, and the raw key encrypted
+// with a passphrase.
+package keyfile
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// Key file formats.
+const (
+	FormatRaw = "raw"
+	FormatPEM = "pem"
+)
+
+// PEM block types.
+const (
+	pemPKCS8 = "PRIVATE KEY"
+	pemSEC1  = "EC PRIVATE KEY"
+)
+
+// oidSecp256k1 is the named curve of SEC 2, which crypto/x509 does not know.
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+// ecPrivateKey is the SEC 1 ECPrivateKey structure.
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// Generate returns a new key of type typ: ed25519 or secp256k1.
+func Generate(typ string) (crypto.PrivKey, error) {
+	var kt int
+	switch strings.ToLower(typ) {
+	case "", "ed25519":
+		kt = crypto.Ed25519
+	case "secp256k1":
+		kt = crypto.Secp256k1
+	default:
+		return nil, fmt.Errorf("bad key type %q", typ)
+	}
+	priv, _, err := crypto.GenerateKeyPairWithReader(kt, -1, rand.Reader)
+	return priv, err
+}
+
+// Marshal encodes priv in format: raw, pem, or encrypted with passphrase.
+func Marshal(priv crypto.PrivKey, format string, passphrase []byte) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "", FormatRaw:
+		return crypto.MarshalPrivateKey(priv)
+	case FormatPEM:
+		return marshalPEM(priv)
+	case FormatEncrypted:
+		return marshalEncrypted(priv, passphrase)
+	default:
+		return nil, fmt.Errorf("bad key format %q", format)
+	}
+}
+
+func marshalPEM(priv crypto.PrivKey) ([]byte, error) {
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, err
+	}
+	var block pem.Block
+	switch priv.Type() {
+	case crypto.Ed25519:
+		// Raw is the seed and the public key, as in ed25519.PrivateKey.
+		der, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(raw))
+		if err != nil {
+			return nil, err
+		}
+		block = pem.Block{Type: pemPKCS8, Bytes: der}
+	case crypto.Secp256k1:
+		der, err := asn1.Marshal(ecPrivateKey{Version: 1, PrivateKey: raw, NamedCurveOID: oidSecp256k1})
+		if err != nil {
+			return nil, err
+		}
+		block = pem.Block{Type: pemSEC1, Bytes: der}
+	default:
+		std, err := crypto.PrivKeyToStdKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(std)
+		if err != nil {
+			return nil, err
+		}
+		block = pem.Block{Type: pemPKCS8, Bytes: der}
+	}
+	return pem.EncodeToMemory(&block), nil
+}
+
+// Unmarshal decodes a key in any format, opening an encrypted one with
+// passphrase, and returns it with the format it was in.
+func Unmarshal(data []byte, passphrase []byte) (crypto.PrivKey, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		priv, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("neither PEM nor a libp2p private key: %w", err)
+		}
+		return priv, FormatRaw, nil
+	}
+	var (
+		priv crypto.PrivKey
+		err  error
+	)
+	switch block.Type {
+	case pemEncrypted:
+		priv, err = unmarshalEncrypted(block, passphrase)
+		if err != nil {
+			return nil, "", err
+		}
+		return priv, FormatEncrypted, nil
+	case pemPKCS8:
+		var std any
+		if std, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			break
+		}
+		// libp2p takes Ed25519 keys by pointer only.
+		if k, ok := std.(ed25519.PrivateKey); ok {
+			std = &k
+		}
+		priv, _, err = crypto.KeyPairFromStdKey(std)
+	case pemSEC1:
+		var k ecPrivateKey
+		if _, err = asn1.Unmarshal(block.Bytes, &k); err != nil {
+			break
+		}
+		if k.NamedCurveOID.Equal(oidSecp256k1) {
+			priv, err = crypto.UnmarshalSecp256k1PrivateKey(k.PrivateKey)
+			break
+		}
+		std, perr := x509.ParseECPrivateKey(block.Bytes)
+		if perr != nil {
+			err = perr
+			break
+		}
+		priv, _, err = crypto.KeyPairFromStdKey(std)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return priv, FormatPEM, nil
+}
+
+// Load reads the key file at path, in any format.
+func Load(path string, passphrase []byte) (crypto.PrivKey, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	priv, format, err := Unmarshal(data, passphrase)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return priv, format, nil
+}
+
+// Write writes data, as returned by Marshal, to a new file at path readable
+// by its owner only. It fails if the file exists, unless overwrite.
+func Write(path string, data []byte, overwrite bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Replace atomically replaces the file at path with data, readable by its
+// owner only.
+func Replace(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Verify checks that priv signs what its public key verifies.
+func Verify(priv crypto.PrivKey) error {
+	msg := make([]byte, 32)
+	_, _ = rand.Read(msg)
+	sig, err := priv.Sign(msg)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	ok, err := priv.GetPublic().Verify(msg, sig)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if !ok {
+		return errors.New("signature does not verify against the public key")
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package keyfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	passphrase := []byte("correct horse battery staple")
+	for _, typ := range []string{"ed25519", "secp256k1"} {
+		priv, err := Generate(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range []string{FormatRaw, FormatPEM, FormatEncrypted} {
+			t.Run(typ+"/"+format, func(t *testing.T) {
+				data, err := Marshal(priv, format, passphrase)
+				if err != nil {
+					t.Fatalf("Marshal() err = %v", err)
+				}
+				got, gotFormat, err := Unmarshal(data, passphrase)
+				if err != nil {
+					t.Fatalf("Unmarshal() err = %v", err)
+				}
+				if gotFormat != format {
+					t.Errorf("Unmarshal() format = %q, want %q", gotFormat, format)
+				}
+				if !got.Equals(priv) {
+					t.Error("Unmarshal() returned another key")
+				}
+				if err := Verify(got); err != nil {
+					t.Errorf("Verify() err = %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestEncryptedRejects(t *testing.T) {
+	priv, err := Generate("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte("correct horse battery staple")
+	data, err := Marshal(priv, FormatEncrypted, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemEncrypted {
+		t.Fatalf("Marshal() did not write a %q PEM block", pemEncrypted)
+	}
+	if bytes.Contains(block.Bytes, mustRaw(t, priv)) {
+		t.Fatal("the encrypted block holds the raw key")
+	}
+	tampered := func(f func(b *pem.Block)) []byte {
+		b := &pem.Block{Type: block.Type, Headers: map[string]string{}, Bytes: bytes.Clone(block.Bytes)}
+		for k, v := range block.Headers {
+			b.Headers[k] = v
+		}
+		f(b)
+		return pem.EncodeToMemory(b)
+	}
+	otherSalt := base64.StdEncoding.EncodeToString(make([]byte, saltLen))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase []byte
+		err        error
+	}{
+		{name: "no passphrase", data: data, err: ErrPassphraseRequired},
+		{name: "wrong passphrase", data: data, passphrase: []byte("wrong"), err: ErrBadPassphrase},
+		{name: "flipped ciphertext bit", data: tampered(func(b *pem.Block) { b.Bytes[0] ^= 1 }), passphrase: passphrase, err: ErrBadPassphrase},
+		{name: "flipped tag bit", data: tampered(func(b *pem.Block) { b.Bytes[len(b.Bytes)-1] ^= 1 }), passphrase: passphrase, err: ErrBadPassphrase},
+		{name: "truncated ciphertext", data: tampered(func(b *pem.Block) { b.Bytes = b.Bytes[:len(b.Bytes)-1] }), passphrase: passphrase, err: ErrBadPassphrase},
+		{name: "other salt", data: tampered(func(b *pem.Block) { b.Headers["Salt"] = otherSalt }), passphrase: passphrase, err: ErrBadPassphrase},
+		{name: "other nonce", data: tampered(func(b *pem.Block) {
+			b.Headers["Nonce"] = base64.StdEncoding.EncodeToString(make([]byte, 12))
+		}), passphrase: passphrase, err: ErrBadPassphrase},
+		{name: "short nonce", data: tampered(func(b *pem.Block) { b.Headers["Nonce"] = otherSalt[:8] }), passphrase: passphrase},
+		{name: "bad salt", data: tampered(func(b *pem.Block) { b.Headers["Salt"] = "!" }), passphrase: passphrase},
+		{name: "unknown KDF", data: tampered(func(b *pem.Block) { b.Headers["KDF"] = "argon2id" }), passphrase: passphrase},
+		{name: "bad KDF-Params", data: tampered(func(b *pem.Block) { b.Headers["KDF-Params"] = "N=x" }), passphrase: passphrase},
+		{name: "N not a power of 2", data: tampered(func(b *pem.Block) { b.Headers["KDF-Params"] = "N=1000,r=8,p=1" }), passphrase: passphrase},
+		{name: "memory over the bound", data: tampered(func(b *pem.Block) { b.Headers["KDF-Params"] = "N=1048576,r=16,p=1" }), passphrase: passphrase},
+		{name: "p over the bound", data: tampered(func(b *pem.Block) { b.Headers["KDF-Params"] = "N=16384,r=8,p=17" }), passphrase: passphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Unmarshal(tt.data, tt.passphrase)
+			if err == nil {
+				t.Fatal("Unmarshal() err = nil")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("Unmarshal() err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMarshalEncryptedRequiresPassphrase(t *testing.T) {
+	priv, err := Generate("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Marshal(priv, FormatEncrypted, nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("Marshal() err = %v, want ErrPassphraseRequired", err)
+	}
+}
+
+func TestUnmarshalRejectsGarbage(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":         nil,
+		"garbage":       []byte("not a key"),
+		"unknown PEM":   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}}),
+		"bad PKCS8":     pem.EncodeToMemory(&pem.Block{Type: pemPKCS8, Bytes: []byte{1, 2, 3}}),
+		"bad SEC1":      pem.EncodeToMemory(&pem.Block{Type: pemSEC1, Bytes: []byte{1, 2, 3}}),
+		"truncated raw": mustRaw(t, mustGenerate(t))[:10],
+	} {
+		if _, _, err := Unmarshal(data, nil); err == nil {
+			t.Errorf("Unmarshal(%s) err = nil", name)
+		}
+	}
+}
+
+func TestWriteAndReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	if err := Write(path, []byte("one"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, []byte("two"), false); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Write() over a file err = %v, want os.ErrExist", err)
+	}
+	if err := Replace(path, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "three" {
+		t.Fatalf("file holds %q after Replace", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm()&0o077 != 0 {
+		t.Fatalf("file mode %v, err = %v, want owner only", fi.Mode(), err)
+	}
+}
+
+func TestReadPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pass")
+	if err := os.WriteFile(file, []byte("secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PassphraseEnv, "from env")
+	if got, err := ReadPassphrase(file); err != nil || string(got) != "secret" {
+		t.Errorf("ReadPassphrase(file) = %q, %v", got, err)
+	}
+	if got, err := ReadPassphrase(""); err != nil || string(got) != "from env" {
+		t.Errorf("ReadPassphrase(\"\") = %q, %v", got, err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPassphrase(empty); err == nil {
+		t.Error("ReadPassphrase() of an empty file err = nil")
+	}
+}
+
+func mustGenerate(t *testing.T) crypto.PrivKey {
+	t.Helper()
+	priv, err := Generate("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func mustRaw(t *testing.T, priv crypto.PrivKey) []byte {
+	t.Helper()
+	raw, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Output formats of the events of a command.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Event names shared by the commands.
+const (
+	EventHost    = "host"
+	EventRelay   = "relay"
+	EventReady   = "ready"
+	EventStream  = "stream"
+	EventResult  = "result"
+	EventError   = "error"
+	EventConnect = "connect"
+)
+
+// KeyEvent is the key of the event name in a JSON event line.
+const KeyEvent = "event"
+
+var events atomic.Pointer[slog.Logger]
+
+func init() {
+	events.Store(slog.New(slog.DiscardHandler))
+}
+
+// NewEvents builds the events logger writing to w: with output json one JSON
+// line per event, its name under KeyEvent, and with text nothing at all, the
+// commands logging and printing the same for humans.
+func NewEvents(w io.Writer, output string, redact Redaction) (*slog.Logger, error) {
+	if err := redact.Validate(); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(output) {
+	case "", OutputText:
+		return slog.New(slog.DiscardHandler), nil
+	case OutputJSON:
+		return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 {
+					switch a.Key {
+					case slog.LevelKey:
+						return slog.Attr{}
+					case slog.MessageKey:
+						a.Key = KeyEvent
+						return a
+					}
+				}
+				return redact.replaceAttr(groups, a)
+			},
+		})), nil
+	default:
+		return nil, fmt.Errorf("bad output %q", output)
+	}
+}
+
+// SetupEvents installs the events logger on stdout for output. With json the
+// error records of the default logger are also written as EventError, so that
+// a script sees why the command failed without parsing its logs; call it after
+// Setup.
+func SetupEvents(output string, redact Redaction) error {
+	logger, err := NewEvents(os.Stdout, output, redact)
+	if err != nil {
+		return err
+	}
+	events.Store(logger)
+	if strings.ToLower(output) == OutputJSON {
+		slog.SetDefault(slog.New(errorTee{slog.Default().Handler(), logger.Handler()}))
+	}
+	return nil
+}
+
+// Event writes the event name with the attributes args, as slog.Info does, to
+// the events logger installed by SetupEvents.
+func Event(name string, args ...any) {
+	events.Load().Info(name, args...)
+}
+
+// errorTee passes records to Handler and also writes the error ones to events
+// as EventError, the message under "message".
+type errorTee struct {
+	slog.Handler
+	events slog.Handler
+}
+
+func (h errorTee) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.Handler.Enabled(ctx, level)
+}
+
+func (h errorTee) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		e := slog.NewRecord(r.Time, slog.LevelInfo, EventError, r.PC)
+		e.AddAttrs(slog.String("message", r.Message))
+		r.Attrs(func(a slog.Attr) bool {
+			e.AddAttrs(a)
+			return true
+		})
+		_ = h.events.Handle(ctx, e)
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h errorTee) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return errorTee{h.Handler.WithAttrs(attrs), h.events.WithAttrs(attrs)}
+}
+
+func (h errorTee) WithGroup(name string) slog.Handler {
+	return errorTee{h.Handler.WithGroup(name), h.events.WithGroup(name)}
+}
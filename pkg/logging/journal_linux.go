@@ -0,0 +1,26 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build linux
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// stderrIsJournal reports whether systemd connected stderr to the journal:
+// JOURNAL_STREAM then names its device and inode.
+func stderrIsJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &st); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
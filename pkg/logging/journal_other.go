@@ -0,0 +1,12 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build !linux
+
+package logging
+
+// stderrIsJournal reports whether stderr is the systemd journal, which is
+// Linux-only.
+func stderrIsJournal() bool {
+	return false
+}
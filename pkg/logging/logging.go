@@ -0,0 +1,114 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Attribute keys shared by all flymesh components.
+const (
+	KeyComponent  = "component"
+	KeyStreamID   = "stream_id"
+	KeyPeer       = "peer"
+	KeyServerPeer = "server_peer"
+	KeyClientPeer = "client_peer"
+	KeyRemoteAddr = "remote_addr"
+	KeyServerAddr = "server_addr"
+	KeyClientAddr = "client_addr"
+	KeyToken      = "token"
+	KeyForward    = "forward"
+)
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("bad log level %q: %w", s, err)
+	}
+	return level, nil
+}
+
+// level is the level of the logger installed by Setup, which SetLevel changes.
+var level slog.LevelVar
+
+// New builds a logger writing to w. format is "text", "json" or "journal". Peer IDs,
+// addresses and tokens logged under the shared keys are redacted according to redact.
+func New(w io.Writer, level string, format string, redact Redaction) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return newLogger(w, lvl, format, redact)
+}
+
+func newLogger(w io.Writer, lvl slog.Leveler, format string, redact Redaction) (*slog.Logger, error) {
+	if err := redact.Validate(); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact.replaceAttr}
+	switch strings.ToLower(format) {
+	case FormatJournal:
+		return newJournalLogger(w, lvl, redact)
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("bad log format %q", format)
+	}
+}
+
+// Setup installs a logger on stderr, or the log set with UseSystemLog, as the
+// slog default. The text format is FormatJournal when stderr is the journal.
+func Setup(lvl string, format string, redact Redaction) error {
+	var (
+		logger *slog.Logger
+		err    error
+	)
+	switch {
+	case systemLog != nil:
+		logger, err = newSystemLogger(systemLog, &level, format, redact, nil)
+	case (format == "" || strings.EqualFold(format, "text")) && stderrIsJournal():
+		logger, err = newJournalLogger(os.Stderr, &level, redact)
+	default:
+		logger, err = newLogger(os.Stderr, &level, format, redact)
+	}
+	if err != nil {
+		return err
+	}
+	if err := SetLevel(lvl); err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// SetLevel changes the level of the logger installed by Setup.
+func SetLevel(lvl string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
+
+// Component returns logger (or the default logger if nil) tagged with a component name.
+func Component(logger *slog.Logger, name string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With(KeyComponent, name)
+}
+
+// Fatal logs msg at error level and exits the process.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
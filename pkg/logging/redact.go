@@ -0,0 +1,130 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/netip"
+)
+
+// RedactMode controls how a class of personal or secret values appears in logs.
+type RedactMode string
+
+const (
+	// RedactFull logs values unchanged. It is also the meaning of the zero value.
+	RedactFull RedactMode = "full"
+	// RedactTruncated keeps a short suffix of peer IDs and tokens, and the /24
+	// (IPv4) or /48 (IPv6) network of addresses.
+	RedactTruncated RedactMode = "truncated"
+	// RedactHashed replaces values with a salted hash, so they still correlate
+	// across log lines but cannot be read back.
+	RedactHashed RedactMode = "hashed"
+)
+
+// Redaction selects a RedactMode per class of value.
+type Redaction struct {
+	PeerIDs RedactMode
+	IPs     RedactMode
+	Tokens  RedactMode
+	// HashSalt is mixed into hashed values so that they cannot be matched against
+	// a list of known peer IDs or addresses without it.
+	HashSalt string
+}
+
+// Validate reports an error for unknown modes.
+func (r Redaction) Validate() error {
+	for name, m := range map[string]RedactMode{"peer ids": r.PeerIDs, "ips": r.IPs, "tokens": r.Tokens} {
+		switch m {
+		case "", RedactFull, RedactTruncated, RedactHashed:
+		default:
+			return fmt.Errorf("bad redaction mode %q for %s (want full, truncated or hashed)", m, name)
+		}
+	}
+	return nil
+}
+
+// Peer redacts a peer ID.
+func (r Redaction) Peer(s string) string {
+	switch r.PeerIDs {
+	case RedactTruncated:
+		return truncate(s, 6)
+	case RedactHashed:
+		return r.hash(s)
+	default:
+		return s
+	}
+}
+
+// Addr redacts an IP address, with or without port. Values that are not IP
+// addresses (e.g. unix socket paths) are kept as is when truncating.
+func (r Redaction) Addr(s string) string {
+	switch r.IPs {
+	case RedactTruncated:
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			ap, err := netip.ParseAddrPort(s)
+			if err != nil {
+				return s
+			}
+			ip = ap.Addr()
+		}
+		ip = ip.Unmap()
+		bits := 24
+		if ip.Is6() {
+			bits = 48
+		}
+		p, _ := ip.Prefix(bits)
+		return p.String()
+	case RedactHashed:
+		return r.hash(s)
+	default:
+		return s
+	}
+}
+
+// Token redacts a secret such as a relay stream token.
+func (r Redaction) Token(s string) string {
+	switch r.Tokens {
+	case RedactTruncated:
+		return truncate(s, 4)
+	case RedactHashed:
+		return r.hash(s)
+	default:
+		return s
+	}
+}
+
+func (r Redaction) hash(s string) string {
+	sum := sha256.Sum256([]byte(r.HashSalt + s))
+	return "h:" + hex.EncodeToString(sum[:8])
+}
+
+func truncate(s string, keep int) string {
+	if len(s) <= keep {
+		return s
+	}
+	return "…" + s[len(s)-keep:]
+}
+
+// replaceAttr is a slog.HandlerOptions.ReplaceAttr applying r to the shared keys.
+func (r Redaction) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Key {
+	case KeyPeer, KeyServerPeer, KeyClientPeer:
+		if r.PeerIDs != "" && r.PeerIDs != RedactFull {
+			return slog.String(a.Key, r.Peer(a.Value.String()))
+		}
+	case KeyRemoteAddr, KeyServerAddr, KeyClientAddr:
+		if r.IPs != "" && r.IPs != RedactFull {
+			return slog.String(a.Key, r.Addr(a.Value.String()))
+		}
+	case KeyToken:
+		if r.Tokens != "" && r.Tokens != RedactFull {
+			return slog.String(a.Key, r.Token(a.Value.String()))
+		}
+	}
+	return a
+}
@@ -0,0 +1,166 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotateTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an append-only file that is rotated when it grows beyond MaxSize.
+// Rotated files are named <Path>.<timestamp>[.gz] and pruned by MaxAge and MaxBackups.
+type RotatingFile struct {
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated. 0 disables rotation.
+	MaxSize int64
+	// MaxAge removes rotated files older than this. 0 keeps them regardless of age.
+	MaxAge time.Duration
+	// MaxBackups keeps at most this many rotated files. 0 keeps all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	wg   sync.WaitGroup
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file and waits for pending compressions.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.f != nil {
+		err = r.f.Close()
+		r.f = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = st.Size()
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+
+	rotated := r.Path + "." + time.Now().UTC().Format(rotateTimeFormat)
+	if err := os.Rename(r.Path, rotated); err != nil {
+		return fmt.Errorf("rotate %s: %w", r.Path, err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if r.Compress {
+			if err := compressFile(rotated); err != nil {
+				Component(nil, "logging").Warn("compress rotated log failed", "path", rotated, "err", err)
+			}
+		}
+		r.prune()
+	}()
+	return nil
+}
+
+// prune removes rotated files beyond MaxAge and MaxBackups.
+func (r *RotatingFile) prune() {
+	if r.MaxAge <= 0 && r.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.Path + ".*")
+	if err != nil {
+		return
+	}
+	type backup struct {
+		path string
+		at   time.Time
+	}
+	var backups []backup
+	prefix := filepath.Base(r.Path) + "."
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix), ".gz")
+		at, err := time.Parse(rotateTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: m, at: at})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.After(backups[j].at) })
+
+	cutoff := time.Now().Add(-r.MaxAge)
+	for i, b := range backups {
+		if (r.MaxBackups > 0 && i >= r.MaxBackups) || (r.MaxAge > 0 && b.at.Before(cutoff)) {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	err = errors.Join(err, zw.Close(), dst.Close())
+	if err != nil {
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// FormatJournal writes the records to stderr for the systemd journal: each
+// line prefixed with its syslog priority, as sd-daemon(3) describes, and
+// without a time, which the journal adds. The text format switches to it when
+// stderr is the journal.
+const FormatJournal = "journal"
+
+// SystemLog receives every record of the logger installed by Setup, formatted
+// as one line, with its level.
+type SystemLog func(level slog.Level, line []byte) error
+
+var systemLog SystemLog
+
+// UseSystemLog makes Setup send the records to log instead of stderr, e.g. the
+// event log of a Windows service, which has no stderr. Call it before Setup.
+func UseSystemLog(log SystemLog) {
+	systemLog = log
+}
+
+// newSystemLogger returns a logger formatting records as format does and
+// handing them to log.
+func newSystemLogger(log SystemLog, lvl slog.Leveler, format string, redact Redaction, replace func([]string, slog.Attr) slog.Attr) (*slog.Logger, error) {
+	if err := redact.Validate(); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if replace != nil {
+			if a = replace(groups, a); a.Key == "" {
+				return a
+			}
+		}
+		return redact.replaceAttr(groups, a)
+	}}
+	h := &recordHandler{buf: &bytes.Buffer{}, mu: &sync.Mutex{}, log: log}
+	switch strings.ToLower(format) {
+	case "", "text", FormatJournal:
+		h.inner = slog.NewTextHandler(h.buf, opts)
+	case "json":
+		h.inner = slog.NewJSONHandler(h.buf, opts)
+	default:
+		return nil, fmt.Errorf("bad log format %q", format)
+	}
+	return slog.New(h), nil
+}
+
+// newJournalLogger returns a logger writing to w, the journal, in FormatJournal.
+func newJournalLogger(w io.Writer, lvl slog.Leveler, redact Redaction) (*slog.Logger, error) {
+	log := func(level slog.Level, line []byte) error {
+		_, err := fmt.Fprintf(w, "<%d>%s", journalPriority(level), line)
+		return err
+	}
+	return newSystemLogger(log, lvl, FormatJournal, redact, func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	})
+}
+
+// journalPriority returns the syslog priority of level.
+func journalPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// recordHandler formats each record with inner into buf and hands it to log.
+// The handlers derived from it share buf under mu.
+type recordHandler struct {
+	inner slog.Handler
+	buf   *bytes.Buffer
+	mu    *sync.Mutex
+	log   SystemLog
+}
+
+func (h *recordHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *recordHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	return h.log(r.Level, h.buf.Bytes())
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &recordHandler{inner: h.inner.WithAttrs(attrs), buf: h.buf, mu: h.mu, log: h.log}
+}
+
+func (h *recordHandler) WithGroup(name string) slog.Handler {
+	return &recordHandler{inner: h.inner.WithGroup(name), buf: h.buf, mu: h.mu, log: h.log}
+}
@@ -0,0 +1,353 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package mesh is the coordination layer of flymesh nodes sharing a mesh ID.
+// Every member periodically announces its peer ID, addresses, role and offered
+// services on a GossipSub topic derived from the mesh ID, and keeps a table of
+// the members it heard from recently.
+package mesh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	meshpb "github.com/flymesh/core/pkg/pb/mesh"
+	"github.com/flymesh/core/pkg/status"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var ErrBadAnnouncement = errors.New("bad mesh announcement")
+
+// maxClockSkew bounds how far an announcement timestamp may be in the future.
+const maxClockSkew = time.Minute
+
+// Topic returns the GossipSub topic of a mesh. The mesh ID is hashed so that
+// topics have a fixed length whatever the ID.
+func Topic(meshID string) string {
+	sum := sha256.Sum256([]byte(meshID))
+	return "/flymesh/mesh/1.0/" + hex.EncodeToString(sum[:16])
+}
+
+// Member is a node of the mesh as last announced.
+type Member struct {
+	PeerID   peer.ID
+	Addrs    []ma.Multiaddr
+	Role     string
+	Services []string
+	LastSeen time.Time
+	// Load is the announced load of a relay-server, nil for other roles.
+	Load *Load
+}
+
+// Load is how busy a relay-server is.
+type Load struct {
+	// RelayEndpoint is the relay endpoint handed to peers, host:port.
+	RelayEndpoint string
+	// Allocations counts the allocations held, bridged or not.
+	Allocations int
+	// Bridges counts the bridged allocations.
+	Bridges int
+	// MaxAllocations caps Allocations. 0 means unlimited.
+	MaxAllocations int
+}
+
+// Full reports whether the relay refuses new allocations.
+func (l *Load) Full() bool {
+	return l.MaxAllocations > 0 && l.Allocations >= l.MaxAllocations
+}
+
+// Presence announces this node on the mesh topic and tracks the other members.
+type Presence struct {
+	Host   host.Host
+	MeshID string
+	// Role is announced to other members, e.g. "relay-server" or "tunnel".
+	Role string
+	// Services are the service names this node offers.
+	Services []string
+	// Load, if set, returns the load announced along the presence of a
+	// relay-server. Optional.
+	Load func() *Load
+	// Discovery finds other subscribers of the mesh topic, e.g. a routing
+	// discovery on the DHT. Optional: without it only already connected peers
+	// are reached.
+	Discovery discovery.Discovery
+	// Interval is the time between two announcements.
+	Interval time.Duration
+	// MemberTTL is how long a member stays in the table without announcing.
+	MemberTTL time.Duration
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	mu      sync.Mutex
+	members map[peer.ID]*Member
+	topic   *pubsub.Topic
+	sub     *pubsub.Subscription
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func New(h host.Host, meshID string) *Presence {
+	return &Presence{
+		Host:      h,
+		MeshID:    meshID,
+		Interval:  30 * time.Second,
+		MemberTTL: 2 * time.Minute,
+		members:   make(map[peer.ID]*Member),
+	}
+}
+
+func (p *Presence) logger() *slog.Logger {
+	return logging.Component(p.Logger, "mesh")
+}
+
+// Start joins the mesh topic and starts announcing.
+func (p *Presence) Start(ctx context.Context) error {
+	if p.MeshID == "" {
+		return errors.New("empty mesh ID")
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+
+	var opts []pubsub.Option
+	if p.Discovery != nil {
+		opts = append(opts, pubsub.WithDiscovery(p.Discovery))
+	}
+	ps, err := pubsub.NewGossipSub(p.ctx, p.Host, opts...)
+	if err != nil {
+		p.cancel()
+		return fmt.Errorf("start gossipsub: %w", err)
+	}
+	topic := Topic(p.MeshID)
+	if err := ps.RegisterTopicValidator(topic, p.validate); err != nil {
+		p.cancel()
+		return err
+	}
+	p.topic, err = ps.Join(topic)
+	if err != nil {
+		p.cancel()
+		return fmt.Errorf("join mesh topic: %w", err)
+	}
+	p.sub, err = p.topic.Subscribe()
+	if err != nil {
+		p.cancel()
+		return fmt.Errorf("subscribe mesh topic: %w", err)
+	}
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		p.receiveLoop()
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.announceLoop()
+	}()
+	p.logger().Info("joined mesh", "topic", topic, "role", p.Role, "services", p.Services)
+	return nil
+}
+
+// Stop leaves the mesh.
+func (p *Presence) Stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.sub.Cancel()
+	p.wg.Wait()
+	_ = p.topic.Close()
+}
+
+// Members returns the members heard from within MemberTTL, sorted by peer ID.
+// The node itself is not included.
+func (p *Presence) Members() []Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	out := make([]Member, 0, len(p.members))
+	for id, m := range p.members {
+		if now.Sub(m.LastSeen) > p.MemberTTL {
+			delete(p.members, id)
+			continue
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PeerID < out[j].PeerID })
+	return out
+}
+
+// FindService returns the members offering service.
+func (p *Presence) FindService(service string) []Member {
+	var out []Member
+	for _, m := range p.Members() {
+		if slices.Contains(m.Services, service) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// FillStatus implements status.Source.
+func (p *Presence) FillStatus(s *status.Status) {
+	info := &status.MeshInfo{
+		Topic:   Topic(p.MeshID),
+		Members: []status.MeshMember{},
+	}
+	for _, m := range p.Members() {
+		mm := status.MeshMember{
+			PeerID:   m.PeerID.String(),
+			Role:     m.Role,
+			Services: m.Services,
+			LastSeen: m.LastSeen.UTC(),
+		}
+		if m.Load != nil {
+			mm.Load = &status.MeshLoad{
+				RelayEndpoint:  m.Load.RelayEndpoint,
+				Allocations:    m.Load.Allocations,
+				Bridges:        m.Load.Bridges,
+				MaxAllocations: m.Load.MaxAllocations,
+			}
+		}
+		for _, a := range m.Addrs {
+			mm.Addresses = append(mm.Addresses, a.String())
+		}
+		info.Members = append(info.Members, mm)
+	}
+	s.Mesh = info
+}
+
+func (p *Presence) announceLoop() {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	for {
+		if err := p.announce(); err != nil && p.ctx.Err() == nil {
+			p.logger().Warn("announce failed", "err", err)
+		}
+		select {
+		case <-t.C:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Presence) announce() error {
+	a := meshpb.Announcement{
+		PeerId:          []byte(p.Host.ID()),
+		Services:        p.Services,
+		Role:            p.Role,
+		TimestampUnixMs: time.Now().UnixMilli(),
+	}
+	if p.Load != nil {
+		if l := p.Load(); l != nil {
+			a.RelayLoad = &meshpb.RelayLoad{
+				RelayEndpoint:  l.RelayEndpoint,
+				Allocations:    uint32(l.Allocations),
+				Bridges:        uint32(l.Bridges),
+				MaxAllocations: uint32(l.MaxAllocations),
+			}
+		}
+	}
+	for _, addr := range p.Host.Addrs() {
+		a.Addrs = append(a.Addrs, addr.Bytes())
+	}
+	data, err := a.MarshalVT()
+	if err != nil {
+		return err
+	}
+	return p.topic.Publish(p.ctx, data)
+}
+
+func (p *Presence) receiveLoop() {
+	for {
+		msg, err := p.sub.Next(p.ctx)
+		if err != nil {
+			return
+		}
+		if msg.ReceivedFrom == p.Host.ID() {
+			continue
+		}
+		m, ok := msg.ValidatorData.(*Member)
+		if !ok {
+			continue
+		}
+		p.Host.Peerstore().AddAddrs(m.PeerID, m.Addrs, p.MemberTTL)
+
+		p.mu.Lock()
+		_, known := p.members[m.PeerID]
+		p.members[m.PeerID] = m
+		p.mu.Unlock()
+		if !known {
+			p.logger().Info("member joined", logging.KeyPeer, m.PeerID.String(), "role", m.Role, "services", m.Services)
+		}
+	}
+}
+
+// validate accepts announcements signed by the peer they describe and stores
+// the decoded Member as validator data.
+func (p *Presence) validate(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if msg.GetFrom() == p.Host.ID() {
+		return pubsub.ValidationAccept
+	}
+	m, err := decode(msg)
+	if err != nil {
+		p.logger().Debug("rejected announcement", logging.KeyPeer, from.String(), "err", err)
+		return pubsub.ValidationReject
+	}
+	msg.ValidatorData = m
+	return pubsub.ValidationAccept
+}
+
+func decode(msg *pubsub.Message) (*Member, error) {
+	var a meshpb.Announcement
+	if err := a.UnmarshalVT(msg.GetData()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadAnnouncement, err)
+	}
+	id, err := peer.IDFromBytes(a.GetPeerId())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadAnnouncement, err)
+	}
+	// Messages are signed by their author, so this binds the announcement to
+	// the announced peer.
+	if id != msg.GetFrom() {
+		return nil, fmt.Errorf("%w: announces %s but published by %s", ErrBadAnnouncement, id, msg.GetFrom())
+	}
+	ts := time.UnixMilli(a.GetTimestampUnixMs())
+	if ts.After(time.Now().Add(maxClockSkew)) {
+		return nil, fmt.Errorf("%w: timestamp in the future", ErrBadAnnouncement)
+	}
+	m := &Member{
+		PeerID:   id,
+		Role:     a.GetRole(),
+		Services: a.GetServices(),
+		LastSeen: time.Now(),
+	}
+	if l := a.GetRelayLoad(); l != nil {
+		m.Load = &Load{
+			RelayEndpoint:  l.GetRelayEndpoint(),
+			Allocations:    int(l.GetAllocations()),
+			Bridges:        int(l.GetBridges()),
+			MaxAllocations: int(l.GetMaxAllocations()),
+		}
+	}
+	for _, b := range a.GetAddrs() {
+		addr, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadAnnouncement, err)
+		}
+		m.Addrs = append(m.Addrs, addr)
+	}
+	return m, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package metrics defines the metrics flymesh components report, and the
+// Metrics interface they report them to. An application embedding flymesh
+// routes them into its own telemetry system by implementing Metrics; package
+// prom implements it with Prometheus.
+package metrics
+
+// Desc describes a metric.
+type Desc struct {
+	// Name is the full name of the metric, e.g.
+	// "flymesh_forward_connections_total".
+	Name string
+	Help string
+	// Labels names the labels of the metric, whose values are passed in this
+	// order.
+	Labels []string
+	// Buckets are the upper bounds of the buckets of a histogram. Empty
+	// means the defaults of the implementation.
+	Buckets []float64
+}
+
+// Metrics receives the metrics of flymesh components. It returns the series
+// of a metric for the values of its labels, creating it on first use. It must
+// be safe for concurrent use.
+type Metrics interface {
+	Counter(d *Desc, labelValues ...string) Counter
+	Gauge(d *Desc, labelValues ...string) Gauge
+	Histogram(d *Desc, labelValues ...string) Histogram
+}
+
+// Counter is a value that only goes up.
+type Counter interface {
+	Add(delta float64)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge interface {
+	Set(v float64)
+	Add(delta float64)
+}
+
+// Histogram counts observations in buckets.
+type Histogram interface {
+	Observe(v float64)
+}
+
+// Deleter is implemented by the Metrics that drop series, e.g. those of a link
+// no longer monitored.
+type Deleter interface {
+	Delete(d *Desc, labelValues ...string)
+}
+
+// Delete drops the series of d for labelValues from m, if m is a Deleter.
+func Delete(m Metrics, d *Desc, labelValues ...string) {
+	if del, ok := m.(Deleter); ok {
+		del.Delete(d, labelValues...)
+	}
+}
+
+// Nop drops every metric.
+var Nop Metrics = nop{}
+
+// Or returns m, or Nop if m is nil.
+func Or(m Metrics) Metrics {
+	if m == nil {
+		return Nop
+	}
+	return m
+}
+
+type nop struct{}
+
+func (nop) Counter(*Desc, ...string) Counter     { return nop{} }
+func (nop) Gauge(*Desc, ...string) Gauge         { return nop{} }
+func (nop) Histogram(*Desc, ...string) Histogram { return nop{} }
+func (nop) Add(float64)                          {}
+func (nop) Set(float64)                          {}
+func (nop) Observe(float64)                      {}
+
+var (
+	ForwardConnections = &Desc{
+		Name:   "flymesh_forward_connections_total",
+		Help:   "Connections accepted by a forward.",
+		Labels: []string{"forward"},
+	}
+	ForwardActiveConnections = &Desc{
+		Name:   "flymesh_forward_active_connections",
+		Help:   "Connections currently carried by a forward.",
+		Labels: []string{"forward"},
+	}
+	ForwardBytes = &Desc{
+		Name:   "flymesh_forward_bytes_total",
+		Help:   "Bytes carried by a forward, by direction (tx = towards the remote peer).",
+		Labels: []string{"forward", "direction"},
+	}
+	ForwardErrors = &Desc{
+		Name:   "flymesh_forward_errors_total",
+		Help:   "Connections of a forward that failed to be established.",
+		Labels: []string{"forward"},
+	}
+
+	LinkRTT = &Desc{
+		Name:   "flymesh_link_rtt_seconds",
+		Help:   "Average ping round-trip time of a monitored link, to a peer or a relay.",
+		Labels: []string{"peer", "kind"},
+	}
+	LinkLoss = &Desc{
+		Name:   "flymesh_link_loss_ratio",
+		Help:   "Fraction of lost pings of a monitored link, to a peer or a relay.",
+		Labels: []string{"peer", "kind"},
+	}
+	LinkDegradations = &Desc{
+		Name:   "flymesh_link_degradations_total",
+		Help:   "Monitored links that crossed the round-trip time or loss threshold.",
+		Labels: []string{"kind"},
+	}
+	HolePunches = &Desc{
+		Name:   "flymesh_p2p_hole_punches_total",
+		Help:   "Hole punches of the node, by result (success or failure).",
+		Labels: []string{"result"},
+	}
+
+	RelayAllocations = &Desc{
+		Name: "flymesh_relay_allocations_total",
+		Help: "Allocations created by the relay.",
+	}
+	RelayActiveBridges = &Desc{
+		Name: "flymesh_relay_active_bridges",
+		Help: "Bridges currently carried by the relay.",
+	}
+	RelayBridges = &Desc{
+		Name:   "flymesh_relay_bridges_total",
+		Help:   "Bridges of the relay that ended, by the reason they ended.",
+		Labels: []string{"reason"},
+	}
+	RelayBridgeBytes = &Desc{
+		Name:   "flymesh_relay_bridge_bytes_total",
+		Help:   "Bytes carried by the bridges of the relay, by direction (client_to_server or server_to_client).",
+		Labels: []string{"direction"},
+	}
+	RelayBridgeDuration = &Desc{
+		Name:    "flymesh_relay_bridge_duration_seconds",
+		Help:    "Lifetime of the bridges of the relay.",
+		Buckets: []float64{1, 10, 60, 300, 1800, 3600, 4 * 3600, 24 * 3600},
+	}
+
+	ClientStreams = &Desc{
+		Name:   "flymesh_client_streams_total",
+		Help:   "Streams opened by a client, by the path they took (relay, direct or bond).",
+		Labels: []string{"path"},
+	}
+	ClientStreamErrors = &Desc{
+		Name: "flymesh_client_stream_errors_total",
+		Help: "Streams a client failed to open.",
+	}
+	ClientStreamSetup = &Desc{
+		Name: "flymesh_client_stream_setup_seconds",
+		Help: "Time a client took to open a stream.",
+	}
+
+	ServerSessions = &Desc{
+		Name:   "flymesh_server_sessions_total",
+		Help:   "Sessions served by a server, by path (relay or direct).",
+		Labels: []string{"path"},
+	}
+	ServerActiveSessions = &Desc{
+		Name: "flymesh_server_active_sessions",
+		Help: "Sessions a server currently serves.",
+	}
+)
@@ -0,0 +1,92 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package prom implements metrics.Metrics with Prometheus.
+package prom
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics registers the metrics reported to it on its Registry, the first
+// time each is reported.
+type Metrics struct {
+	// Registry holds the metrics. It is separate from the default Prometheus
+	// registry so embedding applications are not polluted.
+	Registry *prometheus.Registry
+
+	mu         sync.Mutex
+	counters   map[*metrics.Desc]*prometheus.CounterVec
+	gauges     map[*metrics.Desc]*prometheus.GaugeVec
+	histograms map[*metrics.Desc]*prometheus.HistogramVec
+}
+
+// New returns Metrics on a new Registry.
+func New() *Metrics {
+	return &Metrics{
+		Registry:   prometheus.NewRegistry(),
+		counters:   make(map[*metrics.Desc]*prometheus.CounterVec),
+		gauges:     make(map[*metrics.Desc]*prometheus.GaugeVec),
+		histograms: make(map[*metrics.Desc]*prometheus.HistogramVec),
+	}
+}
+
+// Handler serves the metrics of m in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) Counter(d *metrics.Desc, labelValues ...string) metrics.Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vec, ok := m.counters[d]
+	if !ok {
+		vec = prometheus.NewCounterVec(prometheus.CounterOpts{Name: d.Name, Help: d.Help}, d.Labels)
+		m.Registry.MustRegister(vec)
+		m.counters[d] = vec
+	}
+	return vec.WithLabelValues(labelValues...)
+}
+
+func (m *Metrics) Gauge(d *metrics.Desc, labelValues ...string) metrics.Gauge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vec, ok := m.gauges[d]
+	if !ok {
+		vec = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: d.Name, Help: d.Help}, d.Labels)
+		m.Registry.MustRegister(vec)
+		m.gauges[d] = vec
+	}
+	return vec.WithLabelValues(labelValues...)
+}
+
+func (m *Metrics) Histogram(d *metrics.Desc, labelValues ...string) metrics.Histogram {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vec, ok := m.histograms[d]
+	if !ok {
+		vec = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: d.Name, Help: d.Help, Buckets: d.Buckets}, d.Labels)
+		m.Registry.MustRegister(vec)
+		m.histograms[d] = vec
+	}
+	return vec.WithLabelValues(labelValues...)
+}
+
+// Delete drops the series of d for labelValues.
+func (m *Metrics) Delete(d *metrics.Desc, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.counters[d] != nil:
+		m.counters[d].DeleteLabelValues(labelValues...)
+	case m.gauges[d] != nil:
+		m.gauges[d].DeleteLabelValues(labelValues...)
+	case m.histograms[d] != nil:
+		m.histograms[d].DeleteLabelValues(labelValues...)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package prom
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flymesh/core/pkg/metrics"
+)
+
+// scrape returns the text exposition of m.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Result().Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.Counter(metrics.ForwardBytes, "ssh", "tx").Add(3)
+	m.Counter(metrics.ForwardBytes, "ssh", "tx").Add(4)
+	m.Gauge(metrics.LinkRTT, "peer1", "direct").Set(0.25)
+	m.Gauge(metrics.LinkRTT, "peer2", "relay").Set(0.5)
+	m.Histogram(metrics.RelayBridgeDuration).Observe(30)
+	metrics.Delete(m, metrics.LinkRTT, "peer2", "relay")
+
+	got := scrape(t, m)
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`flymesh_forward_bytes_total{direction="tx",forward="ssh"} 7`, true},
+		{`flymesh_link_rtt_seconds{kind="direct",peer="peer1"} 0.25`, true},
+		{`flymesh_link_rtt_seconds{kind="relay",peer="peer2"}`, false},
+		{`flymesh_relay_bridge_duration_seconds_bucket{le="60"} 1`, true},
+		{`flymesh_relay_bridge_duration_seconds_bucket{le="10"} 0`, true},
+	}
+	for _, tt := range tests {
+		if strings.Contains(got, tt.line) != tt.want {
+			t.Errorf("exposition contains %q = %v, want %v:\n%s", tt.line, !tt.want, tt.want, got)
+		}
+	}
+}
+
+func TestNop(t *testing.T) {
+	m := metrics.Or(nil)
+	m.Counter(metrics.ForwardErrors, "ssh").Add(1)
+	m.Gauge(metrics.ServerActiveSessions).Add(-1)
+	m.Histogram(metrics.ClientStreamSetup).Observe(1)
+	metrics.Delete(m, metrics.LinkRTT, "peer1", "direct")
+	if m != metrics.Nop {
+		t.Fatalf("Or(nil) = %v, want Nop", m)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package nattest runs flymesh end to end across simulated NATs. A scenario
+// builds network namespaces for an "internet" hosting the relays and for two
+// peers, each optionally behind a NAT router, starts the real relay-server and
+// tunnel binaries in them and checks which path the client ends up using.
+//
+// Running a scenario requires root, iproute2 and iptables.
+package nattest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/util"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// NATType is the kind of NAT a peer sits behind.
+type NATType string
+
+const (
+	// NATNone gives the peer a public address.
+	NATNone NATType = "none"
+	// NATCone is a port preserving MASQUERADE: the mapping does not depend on the
+	// destination, so hole punching works.
+	NATCone NATType = "cone"
+	// NATSymmetric is MASQUERADE --random-fully: every destination gets a new
+	// port, so hole punching between two NATed peers fails.
+	NATSymmetric NATType = "symmetric"
+)
+
+// Path is the kind of libp2p connection the client ends up with.
+type Path string
+
+const (
+	// PathDirect is a connection dialed directly to the server.
+	PathDirect Path = "direct"
+	// PathHolePunched is a relayed connection upgraded to a direct one.
+	PathHolePunched Path = "holepunched"
+	// PathRelayed is a connection that stayed on the circuit relay.
+	PathRelayed Path = "relayed"
+)
+
+// Scenario is one cell of the test matrix.
+type Scenario struct {
+	Name   string
+	Server NATType
+	Client NATType
+	Want   Path
+}
+
+// Matrix returns every combination of NAT types for server and client.
+func Matrix() []Scenario {
+	types := []NATType{NATNone, NATCone, NATSymmetric}
+	var out []Scenario
+	for _, s := range types {
+		for _, c := range types {
+			out = append(out, Scenario{
+				Name:   fmt.Sprintf("server-%s_client-%s", s, c),
+				Server: s,
+				Client: c,
+				Want:   expectedPath(s, c),
+			})
+		}
+	}
+	return out
+}
+
+func expectedPath(server, client NATType) Path {
+	switch {
+	case server == NATNone:
+		return PathDirect
+	case client == NATNone:
+		// The server dials back to the public client (connection reversal).
+		return PathHolePunched
+	case server == NATSymmetric || client == NATSymmetric:
+		return PathRelayed
+	default:
+		return PathHolePunched
+	}
+}
+
+// Result is the outcome of a scenario.
+type Result struct {
+	Scenario Scenario
+	Got      Path
+	// Err is set if the scenario could not be set up or no data made it through
+	// the tunnel.
+	Err      error
+	Duration time.Duration
+	// LogDir holds the logs of every process of the scenario.
+	LogDir string
+}
+
+// OK reports whether data went through and the expected path was used.
+func (r Result) OK() bool {
+	return r.Err == nil && r.Got == r.Scenario.Want
+}
+
+// Harness runs scenarios one at a time.
+type Harness struct {
+	// RelayServerBin and TunnelBin are the binaries under test.
+	RelayServerBin string
+	TunnelBin      string
+	// Self is the executable serving the helper roles (see RunRole). If empty,
+	// the running executable is used.
+	Self string
+	// WorkDir receives one directory of keys and logs per scenario.
+	WorkDir string
+	// HolePunchWait is how long a relayed connection may take to become direct.
+	HolePunchWait time.Duration
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+func New() *Harness {
+	return &Harness{
+		RelayServerBin: "relay-server",
+		TunnelBin:      "tunnel",
+		WorkDir:        filepath.Join(os.TempDir(), "flymesh-nattest"),
+		HolePunchWait:  30 * time.Second,
+	}
+}
+
+func (h *Harness) logger() *slog.Logger {
+	return logging.Component(h.Logger, "nattest")
+}
+
+// Run runs sc and tears its namespaces and processes down again.
+func (h *Harness) Run(ctx context.Context, sc Scenario) Result {
+	start := time.Now()
+	res := Result{Scenario: sc, LogDir: filepath.Join(h.WorkDir, sc.Name)}
+	res.Got, res.Err = h.run(ctx, sc, res.LogDir)
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (h *Harness) run(ctx context.Context, sc Scenario, dir string) (Path, error) {
+	self := h.Self
+	if self == "" {
+		var err error
+		if self, err = os.Executable(); err != nil {
+			return "", err
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	t := &topology{ctx: ctx, logger: h.logger()}
+	defer t.close()
+	if err := t.setup(sc); err != nil {
+		return "", fmt.Errorf("setup: %w", err)
+	}
+
+	circuitID, err := keyID(filepath.Join(dir, "circuit-relay.key"))
+	if err != nil {
+		return "", err
+	}
+	relayID, err := keyID(filepath.Join(dir, "relay.key"))
+	if err != nil {
+		return "", err
+	}
+	serverID, err := keyID(filepath.Join(dir, "server.key"))
+	if err != nil {
+		return "", err
+	}
+	if _, err := keyID(filepath.Join(dir, "client.key")); err != nil {
+		return "", err
+	}
+
+	relayHost := t.hosts["relay"]
+	circuitAddr := fmt.Sprintf("/ip4/%s/tcp/%d/p2p/%s", relayHost.ip, circuitRelayPort, circuitID)
+
+	if err := t.start(dir, "circuit-relay", "relay", self, RoleArg, "circuit-relay",
+		"-key", filepath.Join(dir, "circuit-relay.key"),
+		"-listen", fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", circuitRelayPort)); err != nil {
+		return "", err
+	}
+	if err := t.start(dir, "relay-server", "relay", h.RelayServerBin,
+		"--private-key", filepath.Join(dir, "relay.key"),
+		"--listen-port", fmt.Sprint(relayP2PPort),
+		"--relay-server-listen", fmt.Sprintf("0.0.0.0:%d", relayStreamPort),
+		"--relay-server-public-address", fmt.Sprintf("%s:%d", relayHost.ip, relayStreamPort),
+		"--no-bootstrap", "--reachability", "public", "--autorelay=false"); err != nil {
+		return "", err
+	}
+	if err := t.start(dir, "echo", "server", self, RoleArg, "echo", "-listen", echoAddr); err != nil {
+		return "", err
+	}
+
+	serverArgs := []string{
+		"--mode", "server",
+		"--private-key", filepath.Join(dir, "server.key"),
+		"--listen-port", fmt.Sprint(peerP2PPort),
+		"--no-bootstrap",
+		"--relay-server-addr", fmt.Sprintf("/ip4/%s/tcp/%d/p2p/%s", relayHost.ip, relayP2PPort, relayID),
+		"--forward-target", "echo=" + echoAddr,
+	}
+	remote := fmt.Sprintf("/ip4/%s/tcp/%d/p2p/%s", t.hosts["server"].ip, peerP2PPort, serverID)
+	if sc.Server == NATNone {
+		serverArgs = append(serverArgs, "--reachability", "public")
+	} else {
+		serverArgs = append(serverArgs, "--static-relay", circuitAddr)
+		remote = fmt.Sprintf("%s/p2p-circuit/p2p/%s", circuitAddr, serverID)
+	}
+	if err := t.start(dir, "server", "server", h.TunnelBin, serverArgs...); err != nil {
+		return "", err
+	}
+	if err := waitLog(ctx, filepath.Join(dir, "server.log"), 30*time.Second, "server ready"); err != nil {
+		return "", fmt.Errorf("server: %w", err)
+	}
+
+	clientArgs := []string{
+		"--mode", "client",
+		"--private-key", filepath.Join(dir, "client.key"),
+		"--listen-port", fmt.Sprint(peerP2PPort),
+		"--no-bootstrap",
+		"--remote", remote,
+		"--forward", "echo=" + forwardAddr,
+	}
+	if sc.Client == NATNone {
+		clientArgs = append(clientArgs, "--reachability", "public")
+	} else {
+		clientArgs = append(clientArgs, "--static-relay", circuitAddr)
+	}
+	if err := t.start(dir, "client", "client", h.TunnelBin, clientArgs...); err != nil {
+		return "", err
+	}
+
+	if err := t.exec("client", self, RoleArg, "probe", "-addr", forwardAddr, "-timeout", "90s"); err != nil {
+		return "", fmt.Errorf("no data through the tunnel: %w", err)
+	}
+
+	if err := waitLog(ctx, filepath.Join(dir, "client.log"), h.HolePunchWait, `msg="connection opened"`, "path=direct"); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return PathRelayed, nil
+	}
+	if sc.Server == NATNone {
+		return PathDirect, nil
+	}
+	return PathHolePunched, nil
+}
+
+// keyID creates the identity key at path and returns its peer ID.
+func keyID(path string) (peer.ID, error) {
+	priv, err := util.LoadOrCreatePrivateKey(path, nil)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPrivateKey(priv)
+}
+
+var errLogTimeout = errors.New("timed out waiting for log line")
+
+// waitLog polls the log file at path until a line contains all of substrs.
+func waitLog(ctx context.Context, path string, timeout time.Duration, substrs ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if logContains(path, substrs) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s in %s", errLogTimeout, strings.Join(substrs, " "), filepath.Base(path))
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func logContains(path string, substrs []string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+lines:
+	for sc.Scan() {
+		for _, s := range substrs {
+			if !strings.Contains(sc.Text(), s) {
+				continue lines
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package nattest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	nsPrefix = "fmnat-"
+	inetNS   = nsPrefix + "inet"
+
+	// internetNet is the simulated internet. It must be a range libp2p treats
+	// as public, since hole punching ignores private and documentation ranges.
+	// The namespaces are isolated, so it is never routed anywhere.
+	internetNet = "11.0.0"
+
+	circuitRelayPort = 4001
+	relayP2PPort     = 4101
+	relayStreamPort  = 24002
+	peerP2PPort      = 4102
+	echoAddr         = "127.0.0.1:7000"
+	forwardAddr      = "127.0.0.1:7001"
+)
+
+// host is where a role of the scenario runs and its address on the internet.
+type host struct {
+	ns string
+	ip string
+}
+
+// topology owns the namespaces and processes of one scenario.
+//
+//	relay  (inetNS, bridge br0, internetNet.1): circuit relay and relay-server
+//	server (internetNet.11, or 10.0.1.2 behind a router at internetNet.11)
+//	client (internetNet.12, or 10.0.2.2 behind a router at internetNet.12)
+type topology struct {
+	ctx    context.Context
+	logger *slog.Logger
+
+	namespaces []string
+	hosts      map[string]host
+	procs      []*exec.Cmd
+}
+
+func (t *topology) setup(sc Scenario) error {
+	t.hosts = make(map[string]host)
+	if err := t.addNS(inetNS); err != nil {
+		return err
+	}
+	if err := t.runAll([][]string{
+		{"ip", "-n", inetNS, "link", "add", "br0", "type", "bridge"},
+		{"ip", "-n", inetNS, "addr", "add", internetNet + ".1/24", "dev", "br0"},
+		{"ip", "-n", inetNS, "link", "set", "br0", "up"},
+	}); err != nil {
+		return err
+	}
+	t.hosts["relay"] = host{ns: inetNS, ip: internetNet + ".1"}
+
+	if err := t.addPeer("server", 1, sc.Server); err != nil {
+		return err
+	}
+	return t.addPeer("client", 2, sc.Client)
+}
+
+// addPeer creates the namespace of a peer, attached to the internet either
+// directly or through a NAT router namespace.
+func (t *topology) addPeer(name string, idx int, nat NATType) error {
+	ns := nsPrefix + name
+	wan := fmt.Sprintf("%s.%d", internetNet, 10+idx)
+	if err := t.addNS(ns); err != nil {
+		return err
+	}
+	t.hosts[name] = host{ns: ns, ip: wan}
+
+	if nat == NATNone {
+		return t.runAll([][]string{
+			{"ip", "-n", inetNS, "link", "add", "v-" + name, "type", "veth", "peer", "name", "eth0", "netns", ns},
+			{"ip", "-n", inetNS, "link", "set", "v-" + name, "master", "br0", "up"},
+			{"ip", "-n", ns, "addr", "add", wan + "/24", "dev", "eth0"},
+			{"ip", "-n", ns, "link", "set", "eth0", "up"},
+		})
+	}
+
+	router := ns + "-nat"
+	if err := t.addNS(router); err != nil {
+		return err
+	}
+	lan := fmt.Sprintf("10.0.%d", idx)
+	masquerade := []string{"ip", "netns", "exec", router, "iptables", "-t", "nat", "-A", "POSTROUTING", "-o", "wan", "-j", "MASQUERADE"}
+	if nat == NATSymmetric {
+		masquerade = append(masquerade, "--random-fully")
+	}
+	return t.runAll([][]string{
+		{"ip", "-n", inetNS, "link", "add", "v-" + name, "type", "veth", "peer", "name", "wan", "netns", router},
+		{"ip", "-n", inetNS, "link", "set", "v-" + name, "master", "br0", "up"},
+		{"ip", "-n", router, "addr", "add", wan + "/24", "dev", "wan"},
+		{"ip", "-n", router, "link", "set", "wan", "up"},
+		{"ip", "-n", router, "link", "add", "lan", "type", "veth", "peer", "name", "eth0", "netns", ns},
+		{"ip", "-n", router, "addr", "add", lan + ".1/24", "dev", "lan"},
+		{"ip", "-n", router, "link", "set", "lan", "up"},
+		{"ip", "-n", ns, "addr", "add", lan + ".2/24", "dev", "eth0"},
+		{"ip", "-n", ns, "link", "set", "eth0", "up"},
+		{"ip", "-n", ns, "route", "add", "default", "via", lan + ".1"},
+		{"ip", "netns", "exec", router, "sysctl", "-qw", "net.ipv4.ip_forward=1"},
+		masquerade,
+	})
+}
+
+func (t *topology) addNS(name string) error {
+	// Left over by an interrupted run.
+	_ = exec.Command("ip", "netns", "del", name).Run()
+	if err := t.run("ip", "netns", "add", name); err != nil {
+		return err
+	}
+	t.namespaces = append(t.namespaces, name)
+	return t.run("ip", "-n", name, "link", "set", "lo", "up")
+}
+
+// start runs bin in the namespace of host in the background, logging to
+// dir/name.log.
+func (t *topology) start(dir string, name string, host string, bin string, args ...string) error {
+	f, err := os.Create(filepath.Join(dir, name+".log"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd := exec.Command("ip", append([]string{"netns", "exec", t.hosts[host].ns, bin}, args...)...)
+	cmd.Stdout = f
+	cmd.Stderr = f
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", name, err)
+	}
+	t.logger.Debug("started", "name", name, "host", host, "pid", cmd.Process.Pid)
+	t.procs = append(t.procs, cmd)
+	return nil
+}
+
+// exec runs bin in the namespace of host to completion.
+func (t *topology) exec(host string, bin string, args ...string) error {
+	return t.run(append([]string{"ip", "netns", "exec", t.hosts[host].ns, bin}, args...)...)
+}
+
+func (t *topology) run(args ...string) error {
+	out, err := exec.CommandContext(t.ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (t *topology) runAll(cmds [][]string) error {
+	for _, args := range cmds {
+		if err := t.run(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close stops the processes, most recently started first, then removes the
+// namespaces.
+func (t *topology) close() {
+	for i := len(t.procs) - 1; i >= 0; i-- {
+		cmd := t.procs[i]
+		_ = cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			_ = cmd.Process.Kill()
+			<-done
+		}
+	}
+	for i := len(t.namespaces) - 1; i >= 0; i-- {
+		if err := exec.Command("ip", "netns", "del", t.namespaces[i]).Run(); err != nil {
+			t.logger.Warn("delete namespace failed", "ns", t.namespaces[i], "err", err)
+		}
+	}
+}
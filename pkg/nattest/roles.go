@@ -0,0 +1,143 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package nattest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/flymesh/core/pkg/util"
+	"github.com/libp2p/go-libp2p"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+// RoleArg is the first argument with which the harness re-executes itself to
+// run a helper role inside a namespace. Programs embedding the harness must
+// hand the remaining arguments to RunRole.
+const RoleArg = "nattest-role"
+
+// probeAttemptTimeout bounds one probe round trip, so that a forward that
+// accepted but cannot reach the server is retried.
+const probeAttemptTimeout = 15 * time.Second
+
+// RunRole runs one of the helper roles:
+//
+//	circuit-relay -key FILE -listen MULTIADDR   libp2p circuit relay v2 service
+//	echo -listen ADDR                           TCP echo server
+//	probe -addr ADDR -timeout D                 sends data to ADDR and checks the echo
+func RunRole(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing role")
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	key := fs.String("key", "", "identity key file")
+	listen := fs.String("listen", "", "listen address")
+	addr := fs.String("addr", "", "address to probe")
+	timeout := fs.Duration("timeout", time.Minute, "probe timeout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "circuit-relay":
+		return runCircuitRelay(ctx, *key, *listen)
+	case "echo":
+		return runEcho(ctx, *listen)
+	case "probe":
+		return runProbe(ctx, *addr, *timeout)
+	default:
+		return fmt.Errorf("unknown role %q", args[0])
+	}
+}
+
+func runCircuitRelay(ctx context.Context, keyFile string, listen string) error {
+	priv, err := util.LoadOrCreatePrivateKey(keyFile, nil)
+	if err != nil {
+		return err
+	}
+	h, err := libp2p.New(
+		libp2p.Identity(priv),
+		libp2p.ListenAddrStrings(listen),
+		libp2p.ForceReachabilityPublic(),
+		libp2p.EnableRelayService(relayv2.WithInfiniteLimits()),
+	)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	<-ctx.Done()
+	return nil
+}
+
+func runEcho(ctx context.Context, listen string) error {
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { _ = ln.Close() })
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer c.Close()
+			_, _ = io.Copy(c, c)
+		}()
+	}
+}
+
+// runProbe retries until the forward at addr accepts a connection and echoes
+// a payload back unchanged.
+func runProbe(ctx context.Context, addr string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	payload := bytes.Repeat([]byte("flymesh nattest "), 4096)
+	var lastErr error
+	for {
+		if lastErr = probe(ctx, addr, payload); lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("probe %s: %w", addr, lastErr)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func probe(ctx context.Context, addr string, payload []byte) error {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(probeAttemptTimeout))
+	go func() {
+		_, _ = c.Write(payload)
+	}()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(c, got); err != nil {
+		return err
+	}
+	if !bytes.Equal(got, payload) {
+		return errors.New("echo mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package obfs disguises the TCP connections between peers and a relay-server,
+// so that middleboxes blocking unknown protocols do not fingerprint them by
+// the FLYR magic and the sizes of relay frames.
+//
+// Obfuscation is not encryption: the relayed streams are already secured
+// end-to-end with Noise, and the relay frames are authenticated by their HMAC.
+package obfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+// ErrNoSecret is returned by NewPadded for an empty secret.
+var ErrNoSecret = errors.New("obfuscation secret is empty")
+
+// Obfuscator wraps both ends of a connection to a relay-server.
+type Obfuscator interface {
+	// Client wraps a connection dialed to the relay-server.
+	Client(conn net.Conn) (net.Conn, error)
+	// Server wraps a connection the relay-server accepted. What Client sends
+	// first must not start with relay_protocol.RelayMagic, so that the
+	// relay-server can tell it from a plain connection.
+	Server(conn net.Conn) (net.Conn, error)
+}
+
+// Padded is an Obfuscator keyed by a secret shared by the relay-server and
+// its peers. Each direction starts with a random nonce and is then encrypted
+// with AES-CTR, so that no byte is predictable. Data is carried in records
+// with random padding, so that their sizes do not reveal the frames either.
+type Padded struct {
+	// MaxPadding bounds the random padding added to every record.
+	MaxPadding int
+
+	clientKey []byte
+	serverKey []byte
+}
+
+// DefaultMaxPadding is the MaxPadding of NewPadded.
+const DefaultMaxPadding = 255
+
+const (
+	nonceSize     = aes.BlockSize
+	headerSize    = 4
+	maxRecordData = 1<<14 - 1
+	maxPadding    = 1<<16 - 1
+)
+
+// NewPadded returns a Padded obfuscator keyed by secret.
+func NewPadded(secret string) (*Padded, error) {
+	if secret == "" {
+		return nil, ErrNoSecret
+	}
+	key := sha256.Sum256([]byte(secret))
+	return &Padded{
+		MaxPadding: DefaultMaxPadding,
+		clientKey:  deriveKey(key[:], "flymesh obfs client"),
+		serverKey:  deriveKey(key[:], "flymesh obfs server"),
+	}, nil
+}
+
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func (p *Padded) Client(conn net.Conn) (net.Conn, error) {
+	return p.wrap(conn, true), nil
+}
+
+func (p *Padded) Server(conn net.Conn) (net.Conn, error) {
+	return p.wrap(conn, false), nil
+}
+
+func (p *Padded) wrap(conn net.Conn, client bool) net.Conn {
+	c := &paddedConn{
+		Conn:       conn,
+		maxPadding: min(max(p.MaxPadding, 0), maxPadding),
+		client:     client,
+		writeKey:   p.serverKey,
+		readKey:    p.clientKey,
+	}
+	if client {
+		c.writeKey, c.readKey = p.clientKey, p.serverKey
+	}
+	return c
+}
+
+// paddedConn carries the records of Padded. Each record is a header holding
+// the data and padding lengths as LE16, the data and the padding, all
+// encrypted.
+type paddedConn struct {
+	net.Conn
+	maxPadding int
+	client     bool
+
+	writeMu  sync.Mutex
+	writeKey []byte
+	enc      cipher.Stream
+	werr     error
+
+	readMu  sync.Mutex
+	readKey []byte
+	dec     cipher.Stream
+	data    []byte // decrypted data of the current record not returned yet
+	buf     []byte
+	rerr    error
+}
+
+// Write sends b in records. Any failure leaves the stream undecodable, so it
+// is returned by all later writes too.
+func (c *paddedConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.werr != nil {
+		return 0, c.werr
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var out []byte
+	if c.enc == nil {
+		nonce, err := c.newNonce()
+		if err != nil {
+			c.werr = err
+			return 0, err
+		}
+		c.enc = newStream(c.writeKey, nonce)
+		out = append(out, nonce...)
+	}
+	n := 0
+	for n < len(b) {
+		chunk := b[n:min(len(b), n+maxRecordData)]
+		pad, err := c.padding()
+		if err != nil {
+			c.werr = err
+			return n, err
+		}
+		start := len(out)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(chunk)))
+		out = binary.LittleEndian.AppendUint16(out, uint16(pad))
+		out = append(out, chunk...)
+		out = append(out, make([]byte, pad)...)
+		c.enc.XORKeyStream(out[start:], out[start:])
+		n += len(chunk)
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		c.werr = err
+		return 0, err
+	}
+	return n, nil
+}
+
+// newNonce returns the random nonce starting a direction. A client nonce is
+// drawn again should it start like a relay frame.
+func (c *paddedConn) newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	for {
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		if !c.client || string(nonce[:len(relay_protocol.RelayMagic)]) != relay_protocol.RelayMagic {
+			return nonce, nil
+		}
+	}
+}
+
+func (c *paddedConn) padding() (int, error) {
+	if c.maxPadding == 0 {
+		return 0, nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(c.maxPadding)+1))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+// Read returns the data of the records read. A failure, a timeout included,
+// may leave a record partly read, so it is returned by all later reads too.
+func (c *paddedConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.data) == 0 {
+		if c.rerr != nil {
+			return 0, c.rerr
+		}
+		if err := c.readRecord(); err != nil {
+			c.rerr = err
+		}
+	}
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *paddedConn) readRecord() error {
+	if c.dec == nil {
+		nonce := make([]byte, nonceSize)
+		if _, err := io.ReadFull(c.Conn, nonce); err != nil {
+			return err
+		}
+		c.dec = newStream(c.readKey, nonce)
+	}
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return err
+	}
+	c.dec.XORKeyStream(hdr[:], hdr[:])
+	dataLen := int(binary.LittleEndian.Uint16(hdr[0:2]))
+	padLen := int(binary.LittleEndian.Uint16(hdr[2:4]))
+	if dataLen > maxRecordData {
+		return errors.New("obfs: oversized record")
+	}
+	if cap(c.buf) < dataLen+padLen {
+		c.buf = make([]byte, dataLen+padLen)
+	}
+	rec := c.buf[:dataLen+padLen]
+	if _, err := io.ReadFull(c.Conn, rec); err != nil {
+		return unexpectedEOF(err)
+	}
+	c.dec.XORKeyStream(rec, rec)
+	c.data = rec[:dataLen]
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func newStream(key, nonce []byte) cipher.Stream {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// The key is always the 32 bytes of deriveKey.
+		panic(err)
+	}
+	return cipher.NewCTR(block, nonce)
+}
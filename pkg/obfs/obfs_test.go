@@ -0,0 +1,184 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package obfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+// bufConn is a net.Conn writing to and reading from one buffer.
+type bufConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *bufConn) Read(b []byte) (int, error)  { return c.buf.Read(b) }
+func (c *bufConn) Write(b []byte) (int, error) { return c.buf.Write(b) }
+
+func newPadded(t *testing.T, secret string, maxPadding int) *Padded {
+	t.Helper()
+	p, err := NewPadded(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxPadding = maxPadding
+	return p
+}
+
+// relayFrame returns a relay frame with data of n bytes.
+func relayFrame(t *testing.T, n int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := relay_protocol.WriteRelayFrame(&b, relay_protocol.RelayTypeHandshakeRequest, []byte("token"), bytes.Repeat([]byte{'x'}, n)); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestPaddedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPadding int
+		writes     [][]byte
+	}{
+		{name: "one frame", maxPadding: DefaultMaxPadding, writes: [][]byte{relayFrame(t, 100)}},
+		{name: "several writes", maxPadding: DefaultMaxPadding, writes: [][]byte{[]byte("a"), relayFrame(t, 0), bytes.Repeat([]byte{7}, 5000)}},
+		{name: "over one record", maxPadding: DefaultMaxPadding, writes: [][]byte{bytes.Repeat([]byte{1}, 3*maxRecordData+5)}},
+		{name: "no padding", maxPadding: 0, writes: [][]byte{relayFrame(t, 10), []byte("tail")}},
+		{name: "largest padding", maxPadding: maxPadding, writes: [][]byte{relayFrame(t, 10)}},
+		{name: "padding over the bound", maxPadding: 1 << 20, writes: [][]byte{relayFrame(t, 10)}},
+		{name: "negative padding", maxPadding: -1, writes: [][]byte{relayFrame(t, 10)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPadded(t, "shared secret", tt.maxPadding)
+			for _, dir := range []string{"client to server", "server to client"} {
+				wire := &bufConn{}
+				writer, _ := p.Client(wire)
+				reader, _ := p.Server(wire)
+				if dir == "server to client" {
+					writer, _ = p.Server(wire)
+					reader, _ = p.Client(wire)
+				}
+				var want []byte
+				for _, w := range tt.writes {
+					n, err := writer.Write(w)
+					if err != nil || n != len(w) {
+						t.Fatalf("%s: Write() = %d, %v, want %d", dir, n, err, len(w))
+					}
+					want = append(want, w...)
+				}
+				if bytes.Contains(wire.buf.Bytes(), want[:min(len(want), 32)]) {
+					t.Errorf("%s: the data shows on the wire", dir)
+				}
+				got, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatalf("%s: read err = %v", dir, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%s: read %d bytes, want %d", dir, len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestPaddedSizes(t *testing.T) {
+	frame := relayFrame(t, 100)
+	p := newPadded(t, "shared secret", 0)
+	wire := &bufConn{}
+	c, _ := p.Client(wire)
+	if _, err := c.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := wire.buf.Len(), nonceSize+headerSize+len(frame); got != want {
+		t.Fatalf("unpadded record of %d bytes, want %d", got, want)
+	}
+
+	// Padded records of the same frame vary in size.
+	p = newPadded(t, "shared secret", DefaultMaxPadding)
+	sizes := map[int]bool{}
+	for range 20 {
+		wire := &bufConn{}
+		c, _ := p.Client(wire)
+		if _, err := c.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		n := wire.buf.Len() - nonceSize - headerSize - len(frame)
+		if n < 0 || n > DefaultMaxPadding {
+			t.Fatalf("padding of %d bytes", n)
+		}
+		sizes[n] = true
+	}
+	if len(sizes) < 2 {
+		t.Fatal("the padding does not vary")
+	}
+}
+
+func TestPaddedClientNeverStartsLikeARelayFrame(t *testing.T) {
+	p := newPadded(t, "shared secret", DefaultMaxPadding)
+	for range 1000 {
+		wire := &bufConn{}
+		c, _ := p.Client(wire)
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(wire.buf.Bytes(), []byte(relay_protocol.RelayMagic)) {
+			t.Fatal("client stream starts with the relay magic")
+		}
+	}
+}
+
+func TestPaddedRejects(t *testing.T) {
+	if _, err := NewPadded(""); !errors.Is(err, ErrNoSecret) {
+		t.Fatalf("NewPadded(\"\") err = %v, want ErrNoSecret", err)
+	}
+
+	frame := relayFrame(t, 100)
+	record := func(p *Padded) []byte {
+		wire := &bufConn{}
+		c, _ := p.Client(wire)
+		if _, err := c.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		return wire.buf.Bytes()
+	}
+	p := newPadded(t, "shared secret", DefaultMaxPadding)
+	valid := record(p)
+
+	t.Run("other secret", func(t *testing.T) {
+		wire := &bufConn{}
+		wire.buf.Write(record(newPadded(t, "another secret", DefaultMaxPadding)))
+		s, _ := p.Server(wire)
+		got, _ := io.ReadAll(s)
+		if bytes.Equal(got, frame) {
+			t.Fatal("a server with another secret read the frame")
+		}
+	})
+	for name, wireBytes := range map[string][]byte{
+		"truncated nonce":  valid[:nonceSize-1],
+		"truncated header": valid[:nonceSize+2],
+		"truncated record": valid[:len(valid)-1],
+	} {
+		t.Run(name, func(t *testing.T) {
+			wire := &bufConn{}
+			wire.buf.Write(wireBytes)
+			s, _ := p.Server(wire)
+			got, err := io.ReadAll(s)
+			if err == nil {
+				t.Fatalf("read %d bytes without an error", len(got))
+			}
+			// A failed read fails for good.
+			if _, again := s.Read(make([]byte, 1)); again == nil {
+				t.Fatal("the read after a failure succeeded")
+			}
+		})
+	}
+}
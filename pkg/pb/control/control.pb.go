@@ -24,27 +24,1227 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// ErrorCode classifies the failure of a response.
+type ErrorCode int32
+
+const (
+	ErrorCode_ERROR_CODE_UNSPECIFIED ErrorCode = 0
+	// The server does not serve the requested destination.
+	ErrorCode_ERROR_CODE_UNKNOWN_TARGET ErrorCode = 1
+	// The server does not accept streams from the requesting peer, or the
+	// relay-server could not verify it.
+	ErrorCode_ERROR_CODE_UNAUTHORIZED ErrorCode = 2
+	// The relay-server has no allocation for the stream: it expired, was
+	// superseded or never existed. Relay-servers no longer send it in a
+	// HandshakeAck, see ERROR_CODE_HMAC_MISMATCH.
+	ErrorCode_ERROR_CODE_NO_SUCH_STREAM ErrorCode = 3
+	// The handshake was not authenticated by the token of the allocation.
+	// Relay-servers no longer send it in a HandshakeAck: they close the
+	// connection without an answer, as they do for an unknown stream, so that
+	// peers cannot tell which streams exist.
+	ErrorCode_ERROR_CODE_HMAC_MISMATCH ErrorCode = 4
+	// An allocation or session limit is reached.
+	ErrorCode_ERROR_CODE_QUOTA_EXCEEDED ErrorCode = 5
+	// The peer is shutting down and takes no new streams.
+	ErrorCode_ERROR_CODE_SHUTTING_DOWN ErrorCode = 6
+	// The request is malformed or out of bounds.
+	ErrorCode_ERROR_CODE_BAD_REQUEST ErrorCode = 7
+)
+
+// Enum value maps for ErrorCode.
+var (
+	ErrorCode_name = map[int32]string{
+		0: "ERROR_CODE_UNSPECIFIED",
+		1: "ERROR_CODE_UNKNOWN_TARGET",
+		2: "ERROR_CODE_UNAUTHORIZED",
+		3: "ERROR_CODE_NO_SUCH_STREAM",
+		4: "ERROR_CODE_HMAC_MISMATCH",
+		5: "ERROR_CODE_QUOTA_EXCEEDED",
+		6: "ERROR_CODE_SHUTTING_DOWN",
+		7: "ERROR_CODE_BAD_REQUEST",
+	}
+	ErrorCode_value = map[string]int32{
+		"ERROR_CODE_UNSPECIFIED":    0,
+		"ERROR_CODE_UNKNOWN_TARGET": 1,
+		"ERROR_CODE_UNAUTHORIZED":   2,
+		"ERROR_CODE_NO_SUCH_STREAM": 3,
+		"ERROR_CODE_HMAC_MISMATCH":  4,
+		"ERROR_CODE_QUOTA_EXCEEDED": 5,
+		"ERROR_CODE_SHUTTING_DOWN":  6,
+		"ERROR_CODE_BAD_REQUEST":    7,
+	}
+)
+
+func (x ErrorCode) Enum() *ErrorCode {
+	p := new(ErrorCode)
+	*p = x
+	return p
+}
+
+func (x ErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_control_proto_enumTypes[0].Descriptor()
+}
+
+func (ErrorCode) Type() protoreflect.EnumType {
+	return &file_control_proto_enumTypes[0]
+}
+
+func (x ErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ErrorCode.Descriptor instead.
+func (ErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{0}
+}
+
+// StreamPriority is the class of traffic a stream carries, which a relay-server
+// schedules the bandwidth of its bridges by.
+type StreamPriority int32
+
+const (
+	// Scheduled as the relay-server defaults to.
+	StreamPriority_STREAM_PRIORITY_UNSPECIFIED StreamPriority = 0
+	// Latency-sensitive traffic, e.g. an SSH session.
+	StreamPriority_STREAM_PRIORITY_INTERACTIVE StreamPriority = 1
+	// Bulk transfers, e.g. a backup, that yield to interactive streams.
+	StreamPriority_STREAM_PRIORITY_BULK StreamPriority = 2
+)
+
+// Enum value maps for StreamPriority.
+var (
+	StreamPriority_name = map[int32]string{
+		0: "STREAM_PRIORITY_UNSPECIFIED",
+		1: "STREAM_PRIORITY_INTERACTIVE",
+		2: "STREAM_PRIORITY_BULK",
+	}
+	StreamPriority_value = map[string]int32{
+		"STREAM_PRIORITY_UNSPECIFIED": 0,
+		"STREAM_PRIORITY_INTERACTIVE": 1,
+		"STREAM_PRIORITY_BULK":        2,
+	}
+)
+
+func (x StreamPriority) Enum() *StreamPriority {
+	p := new(StreamPriority)
+	*p = x
+	return p
+}
+
+func (x StreamPriority) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (StreamPriority) Descriptor() protoreflect.EnumDescriptor {
+	return file_control_proto_enumTypes[1].Descriptor()
+}
+
+func (StreamPriority) Type() protoreflect.EnumType {
+	return &file_control_proto_enumTypes[1]
+}
+
+func (x StreamPriority) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use StreamPriority.Descriptor instead.
+func (StreamPriority) EnumDescriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{1}
+}
+
+// Hello announces the wire protocol version, features and limits of a peer. It
+// precedes the first frame of a control exchange with a peer that supports it,
+// and is carried in the relay handshake.
+type Hello struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Peers that send no Hello speak version 1.
+	ProtocolVersion uint32   `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features        []string `protobuf:"bytes,2,rep,name=features,proto3" json:"features,omitempty"`
+	Limits          *Limits  `protobuf:"bytes,3,opt,name=limits,proto3" json:"limits,omitempty"`
+	// MAC algorithms of relay frames the peer accepts, most preferred first,
+	// see relay_protocol.MAC. Empty means HMAC-SHA256 only.
+	RelayMacs     []uint32 `protobuf:"varint,4,rep,packed,name=relay_macs,json=relayMacs,proto3" json:"relay_macs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Hello) Reset() {
+	*x = Hello{}
+	mi := &file_control_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Hello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hello) ProtoMessage() {}
+
+func (x *Hello) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hello.ProtoReflect.Descriptor instead.
+func (*Hello) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Hello) GetProtocolVersion() uint32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+func (x *Hello) GetLimits() *Limits {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+func (x *Hello) GetRelayMacs() []uint32 {
+	if x != nil {
+		return x.RelayMacs
+	}
+	return nil
+}
+
+// Limits of a peer. 0 means unknown or unlimited.
+type Limits struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	MaxControlPayload    uint32                 `protobuf:"varint,1,opt,name=max_control_payload,json=maxControlPayload,proto3" json:"max_control_payload,omitempty"`
+	MaxRelayPayload      uint32                 `protobuf:"varint,2,opt,name=max_relay_payload,json=maxRelayPayload,proto3" json:"max_relay_payload,omitempty"`
+	MaxBatchAllocations  uint32                 `protobuf:"varint,3,opt,name=max_batch_allocations,json=maxBatchAllocations,proto3" json:"max_batch_allocations,omitempty"`
+	MaxSessionsPerClient uint32                 `protobuf:"varint,4,opt,name=max_sessions_per_client,json=maxSessionsPerClient,proto3" json:"max_sessions_per_client,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *Limits) Reset() {
+	*x = Limits{}
+	mi := &file_control_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Limits) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Limits) ProtoMessage() {}
+
+func (x *Limits) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Limits.ProtoReflect.Descriptor instead.
+func (*Limits) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Limits) GetMaxControlPayload() uint32 {
+	if x != nil {
+		return x.MaxControlPayload
+	}
+	return 0
+}
+
+func (x *Limits) GetMaxRelayPayload() uint32 {
+	if x != nil {
+		return x.MaxRelayPayload
+	}
+	return 0
+}
+
+func (x *Limits) GetMaxBatchAllocations() uint32 {
+	if x != nil {
+		return x.MaxBatchAllocations
+	}
+	return 0
+}
+
+func (x *Limits) GetMaxSessionsPerClient() uint32 {
+	if x != nil {
+		return x.MaxSessionsPerClient
+	}
+	return 0
+}
+
 type StartRelayStreamRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// W3C trace context (traceparent/tracestate) of the requesting span
+	TraceContext map[string]string `protobuf:"bytes,1,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// retry_cookie of the previous attempt, if its data-plane dial failed
+	RetryCookie []byte `protobuf:"bytes,2,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"`
+	// Destination the client wants bridged. All optional: the server applies its
+	// default target when they are empty.
+	Service       string `protobuf:"bytes,3,opt,name=service,proto3" json:"service,omitempty"`                                  // named target of the server, e.g. "ssh"
+	TargetAddress string `protobuf:"bytes,4,opt,name=target_address,json=targetAddress,proto3" json:"target_address,omitempty"` // host:port
+	Alpn          string `protobuf:"bytes,5,opt,name=alpn,proto3" json:"alpn,omitempty"`                                        // application protocol carried, e.g. "ssh"
+	// Asks to bind the Noise handshake of the relayed stream to its allocation
+	BindSession bool `protobuf:"varint,6,opt,name=bind_session,json=bindSession,proto3" json:"bind_session,omitempty"`
+	// Compression algorithms the client accepts on the relayed stream, most
+	// preferred first, e.g. "zstd", "snappy"
+	Compression []string `protobuf:"bytes,7,rep,name=compression,proto3" json:"compression,omitempty"`
+	// Random ID of the bonded stream this one is a path of, empty for a stream
+	// of its own. Paths with the same ID are striped into one stream.
+	Bond []byte `protobuf:"bytes,8,opt,name=bond,proto3" json:"bond,omitempty"`
+	// Class of traffic the client asks the relayed stream to be scheduled as
+	Priority      StreamPriority `protobuf:"varint,9,opt,name=priority,proto3,enum=flymesh.control.StreamPriority" json:"priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StartRelayStreamRequest) Reset() {
+	*x = StartRelayStreamRequest{}
+	mi := &file_control_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartRelayStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartRelayStreamRequest) ProtoMessage() {}
+
+func (x *StartRelayStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartRelayStreamRequest.ProtoReflect.Descriptor instead.
+func (*StartRelayStreamRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StartRelayStreamRequest) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
+}
+
+func (x *StartRelayStreamRequest) GetRetryCookie() []byte {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return nil
+}
+
+func (x *StartRelayStreamRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *StartRelayStreamRequest) GetTargetAddress() string {
+	if x != nil {
+		return x.TargetAddress
+	}
+	return ""
+}
+
+func (x *StartRelayStreamRequest) GetAlpn() string {
+	if x != nil {
+		return x.Alpn
+	}
+	return ""
+}
+
+func (x *StartRelayStreamRequest) GetBindSession() bool {
+	if x != nil {
+		return x.BindSession
+	}
+	return false
+}
+
+func (x *StartRelayStreamRequest) GetCompression() []string {
+	if x != nil {
+		return x.Compression
+	}
+	return nil
+}
+
+func (x *StartRelayStreamRequest) GetBond() []byte {
+	if x != nil {
+		return x.Bond
+	}
+	return nil
+}
+
+func (x *StartRelayStreamRequest) GetPriority() StreamPriority {
+	if x != nil {
+		return x.Priority
+	}
+	return StreamPriority_STREAM_PRIORITY_UNSPECIFIED
+}
+
+type StartRelayStreamResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	RelayEndpoint string                 `protobuf:"bytes,3,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
+	StreamId      uint64                 `protobuf:"varint,4,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Token         []byte                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"` // 32 bytes (256-bit)
+	// Opaque cookie identifying the allocation to the relay, sent back on retry
+	RetryCookie []byte    `protobuf:"bytes,6,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"`
+	ErrorCode   ErrorCode `protobuf:"varint,7,opt,name=error_code,json=errorCode,proto3,enum=flymesh.control.ErrorCode" json:"error_code,omitempty"`
+	// Set if the server binds the Noise handshake to the allocation, as asked
+	BindSession bool `protobuf:"varint,8,opt,name=bind_session,json=bindSession,proto3" json:"bind_session,omitempty"`
+	// Compression the server picked from the request, empty for none
+	Compression string `protobuf:"bytes,9,opt,name=compression,proto3" json:"compression,omitempty"`
+	// Every endpoint of the relay-server, relay_endpoint first, e.g. ws:// or
+	// wss:// URLs for networks that only let HTTP(S) out
+	RelayEndpoints []string `protobuf:"bytes,10,rep,name=relay_endpoints,json=relayEndpoints,proto3" json:"relay_endpoints,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *StartRelayStreamResponse) Reset() {
+	*x = StartRelayStreamResponse{}
+	mi := &file_control_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartRelayStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartRelayStreamResponse) ProtoMessage() {}
+
+func (x *StartRelayStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartRelayStreamResponse.ProtoReflect.Descriptor instead.
+func (*StartRelayStreamResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StartRelayStreamResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *StartRelayStreamResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *StartRelayStreamResponse) GetRelayEndpoint() string {
+	if x != nil {
+		return x.RelayEndpoint
+	}
+	return ""
+}
+
+func (x *StartRelayStreamResponse) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StartRelayStreamResponse) GetToken() []byte {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+func (x *StartRelayStreamResponse) GetRetryCookie() []byte {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return nil
+}
+
+func (x *StartRelayStreamResponse) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+func (x *StartRelayStreamResponse) GetBindSession() bool {
+	if x != nil {
+		return x.BindSession
+	}
+	return false
+}
+
+func (x *StartRelayStreamResponse) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
+func (x *StartRelayStreamResponse) GetRelayEndpoints() []string {
+	if x != nil {
+		return x.RelayEndpoints
+	}
+	return nil
+}
+
+type CreateStreamRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ClientPeerId []byte                 `protobuf:"bytes,1,opt,name=client_peer_id,json=clientPeerId,proto3" json:"client_peer_id,omitempty"`
+	// W3C trace context (traceparent/tracestate) of the requesting span
+	TraceContext map[string]string `protobuf:"bytes,2,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// retry_cookie of an earlier allocation this one replaces
+	RetryCookie []byte `protobuf:"bytes,3,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"`
+	// Bytes the bridge may carry, both directions counted. 0 means unlimited.
+	MaxBytes uint64 `protobuf:"varint,4,opt,name=max_bytes,json=maxBytes,proto3" json:"max_bytes,omitempty"`
+	// Time the bridge is closed at, in unix milliseconds. 0 means none.
+	DeadlineUnixMs int64 `protobuf:"varint,5,opt,name=deadline_unix_ms,json=deadlineUnixMs,proto3" json:"deadline_unix_ms,omitempty"`
+	// Have the relay-server offer the allocation to client_peer_id over the
+	// stream-offer protocol, for a client that is not a protocol server.
+	Offer bool `protobuf:"varint,6,opt,name=offer,proto3" json:"offer,omitempty"`
+	// Have the relay-server notify client_peer_id, a server registered over
+	// the relay-server notify protocol, of this stream request of the
+	// requesting client. The server joins the allocation as its server peer.
+	Notify *StartRelayStreamRequest `protobuf:"bytes,7,opt,name=notify,proto3" json:"notify,omitempty"`
+	// Class of traffic the bridge is scheduled as, among the bridges sharing
+	// the bandwidth of the relay-server
+	Priority      StreamPriority `protobuf:"varint,8,opt,name=priority,proto3,enum=flymesh.control.StreamPriority" json:"priority,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateStreamRequest) Reset() {
+	*x = CreateStreamRequest{}
+	mi := &file_control_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateStreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateStreamRequest) ProtoMessage() {}
+
+func (x *CreateStreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateStreamRequest.ProtoReflect.Descriptor instead.
+func (*CreateStreamRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreateStreamRequest) GetClientPeerId() []byte {
+	if x != nil {
+		return x.ClientPeerId
+	}
+	return nil
+}
+
+func (x *CreateStreamRequest) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
+}
+
+func (x *CreateStreamRequest) GetRetryCookie() []byte {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return nil
+}
+
+func (x *CreateStreamRequest) GetMaxBytes() uint64 {
+	if x != nil {
+		return x.MaxBytes
+	}
+	return 0
+}
+
+func (x *CreateStreamRequest) GetDeadlineUnixMs() int64 {
+	if x != nil {
+		return x.DeadlineUnixMs
+	}
+	return 0
+}
+
+func (x *CreateStreamRequest) GetOffer() bool {
+	if x != nil {
+		return x.Offer
+	}
+	return false
+}
+
+func (x *CreateStreamRequest) GetNotify() *StartRelayStreamRequest {
+	if x != nil {
+		return x.Notify
+	}
+	return nil
+}
+
+func (x *CreateStreamRequest) GetPriority() StreamPriority {
+	if x != nil {
+		return x.Priority
+	}
+	return StreamPriority_STREAM_PRIORITY_UNSPECIFIED
+}
+
+type CreateStreamResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	RelayEndpoint string                 `protobuf:"bytes,3,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
+	StreamId      uint64                 `protobuf:"varint,4,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Token         []byte                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"` // 32 bytes (256-bit)
+	// Opaque cookie identifying the allocation, sent back on retry
+	RetryCookie []byte    `protobuf:"bytes,6,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"`
+	ErrorCode   ErrorCode `protobuf:"varint,7,opt,name=error_code,json=errorCode,proto3,enum=flymesh.control.ErrorCode" json:"error_code,omitempty"`
+	// Every endpoint of the relay-server, relay_endpoint first
+	RelayEndpoints []string `protobuf:"bytes,8,rep,name=relay_endpoints,json=relayEndpoints,proto3" json:"relay_endpoints,omitempty"`
+	// Address of the libp2p connection of the request as the relay-server saw
+	// it, ip:port. Empty for relayed connections.
+	ObservedAddress string `protobuf:"bytes,9,opt,name=observed_address,json=observedAddress,proto3" json:"observed_address,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CreateStreamResponse) Reset() {
+	*x = CreateStreamResponse{}
+	mi := &file_control_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateStreamResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateStreamResponse) ProtoMessage() {}
+
+func (x *CreateStreamResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateStreamResponse.ProtoReflect.Descriptor instead.
+func (*CreateStreamResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CreateStreamResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *CreateStreamResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CreateStreamResponse) GetRelayEndpoint() string {
+	if x != nil {
+		return x.RelayEndpoint
+	}
+	return ""
+}
+
+func (x *CreateStreamResponse) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *CreateStreamResponse) GetToken() []byte {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+func (x *CreateStreamResponse) GetRetryCookie() []byte {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return nil
+}
+
+func (x *CreateStreamResponse) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+func (x *CreateStreamResponse) GetRelayEndpoints() []string {
+	if x != nil {
+		return x.RelayEndpoints
+	}
+	return nil
+}
+
+func (x *CreateStreamResponse) GetObservedAddress() string {
+	if x != nil {
+		return x.ObservedAddress
+	}
+	return ""
+}
+
+// CreateStreamsRequest allocates count streams for the same client peer in one
+// round trip, for servers expecting a burst of connections.
+type CreateStreamsRequest struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	ClientPeerId []byte                 `protobuf:"bytes,1,opt,name=client_peer_id,json=clientPeerId,proto3" json:"client_peer_id,omitempty"`
+	Count        uint32                 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	// W3C trace context (traceparent/tracestate) of the requesting span
+	TraceContext  map[string]string `protobuf:"bytes,3,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateStreamsRequest) Reset() {
+	*x = CreateStreamsRequest{}
+	mi := &file_control_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateStreamsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateStreamsRequest) ProtoMessage() {}
+
+func (x *CreateStreamsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateStreamsRequest.ProtoReflect.Descriptor instead.
+func (*CreateStreamsRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CreateStreamsRequest) GetClientPeerId() []byte {
+	if x != nil {
+		return x.ClientPeerId
+	}
+	return nil
+}
+
+func (x *CreateStreamsRequest) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *CreateStreamsRequest) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
+}
+
+type StreamAllocation struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	StreamId uint64                 `protobuf:"varint,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Token    []byte                 `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"` // 32 bytes (256-bit)
+	// Opaque cookie identifying the allocation, sent back on retry
+	RetryCookie   []byte `protobuf:"bytes,3,opt,name=retry_cookie,json=retryCookie,proto3" json:"retry_cookie,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamAllocation) Reset() {
+	*x = StreamAllocation{}
+	mi := &file_control_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamAllocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamAllocation) ProtoMessage() {}
+
+func (x *StreamAllocation) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamAllocation.ProtoReflect.Descriptor instead.
+func (*StreamAllocation) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StreamAllocation) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamAllocation) GetToken() []byte {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+func (x *StreamAllocation) GetRetryCookie() []byte {
+	if x != nil {
+		return x.RetryCookie
+	}
+	return nil
+}
+
+type CreateStreamsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	RelayEndpoint string                 `protobuf:"bytes,3,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
+	Streams       []*StreamAllocation    `protobuf:"bytes,4,rep,name=streams,proto3" json:"streams,omitempty"`
+	// Shared expiry of the unused allocations
+	ExpiresUnixMs int64     `protobuf:"varint,5,opt,name=expires_unix_ms,json=expiresUnixMs,proto3" json:"expires_unix_ms,omitempty"`
+	ErrorCode     ErrorCode `protobuf:"varint,6,opt,name=error_code,json=errorCode,proto3,enum=flymesh.control.ErrorCode" json:"error_code,omitempty"`
+	// Every endpoint of the relay-server, relay_endpoint first
+	RelayEndpoints []string `protobuf:"bytes,7,rep,name=relay_endpoints,json=relayEndpoints,proto3" json:"relay_endpoints,omitempty"`
+	// Address of the libp2p connection of the request as the relay-server saw
+	// it, ip:port. Empty for relayed connections.
+	ObservedAddress string `protobuf:"bytes,8,opt,name=observed_address,json=observedAddress,proto3" json:"observed_address,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CreateStreamsResponse) Reset() {
+	*x = CreateStreamsResponse{}
+	mi := &file_control_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateStreamsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateStreamsResponse) ProtoMessage() {}
+
+func (x *CreateStreamsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateStreamsResponse.ProtoReflect.Descriptor instead.
+func (*CreateStreamsResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CreateStreamsResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *CreateStreamsResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CreateStreamsResponse) GetRelayEndpoint() string {
+	if x != nil {
+		return x.RelayEndpoint
+	}
+	return ""
+}
+
+func (x *CreateStreamsResponse) GetStreams() []*StreamAllocation {
+	if x != nil {
+		return x.Streams
+	}
+	return nil
+}
+
+func (x *CreateStreamsResponse) GetExpiresUnixMs() int64 {
+	if x != nil {
+		return x.ExpiresUnixMs
+	}
+	return 0
+}
+
+func (x *CreateStreamsResponse) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+func (x *CreateStreamsResponse) GetRelayEndpoints() []string {
+	if x != nil {
+		return x.RelayEndpoints
+	}
+	return nil
+}
+
+func (x *CreateStreamsResponse) GetObservedAddress() string {
+	if x != nil {
+		return x.ObservedAddress
+	}
+	return ""
+}
+
+// StreamExpired is sent by a relay-server on a stream it opens to the server
+// peer of an allocation that its TTL dropped before both peers connected.
+type StreamExpired struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	StreamId     uint64                 `protobuf:"varint,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	ClientPeerId []byte                 `protobuf:"bytes,2,opt,name=client_peer_id,json=clientPeerId,proto3" json:"client_peer_id,omitempty"`
+	// Which peers had connected to the allocation
+	ServerConnected bool `protobuf:"varint,3,opt,name=server_connected,json=serverConnected,proto3" json:"server_connected,omitempty"`
+	ClientConnected bool `protobuf:"varint,4,opt,name=client_connected,json=clientConnected,proto3" json:"client_connected,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *StreamExpired) Reset() {
+	*x = StreamExpired{}
+	mi := &file_control_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamExpired) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamExpired) ProtoMessage() {}
+
+func (x *StreamExpired) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamExpired.ProtoReflect.Descriptor instead.
+func (*StreamExpired) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StreamExpired) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamExpired) GetClientPeerId() []byte {
+	if x != nil {
+		return x.ClientPeerId
+	}
+	return nil
+}
+
+func (x *StreamExpired) GetServerConnected() bool {
+	if x != nil {
+		return x.ServerConnected
+	}
+	return false
+}
+
+func (x *StreamExpired) GetClientConnected() bool {
+	if x != nil {
+		return x.ClientConnected
+	}
+	return false
+}
+
+// StreamOffer is sent by a relay-server on a stream it opens to the peer named
+// by a CreateStreamRequest with offer set, which joins the allocation as its
+// client.
+type StreamOffer struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Peer that requested the allocation
+	PeerId         []byte   `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	StreamId       uint64   `protobuf:"varint,2,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	Token          []byte   `protobuf:"bytes,3,opt,name=token,proto3" json:"token,omitempty"`
+	RelayEndpoint  string   `protobuf:"bytes,4,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
+	RelayEndpoints []string `protobuf:"bytes,5,rep,name=relay_endpoints,json=relayEndpoints,proto3" json:"relay_endpoints,omitempty"`
+	// Time the allocation expires at unless both peers connect, in unix
+	// milliseconds.
+	ExpiresUnixMs int64 `protobuf:"varint,6,opt,name=expires_unix_ms,json=expiresUnixMs,proto3" json:"expires_unix_ms,omitempty"`
+	// Stream request of peer_id, for an offer notified to a registered server
+	Request       *StartRelayStreamRequest `protobuf:"bytes,7,opt,name=request,proto3" json:"request,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamOffer) Reset() {
+	*x = StreamOffer{}
+	mi := &file_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamOffer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamOffer) ProtoMessage() {}
+
+func (x *StreamOffer) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamOffer.ProtoReflect.Descriptor instead.
+func (*StreamOffer) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *StreamOffer) GetPeerId() []byte {
+	if x != nil {
+		return x.PeerId
+	}
+	return nil
+}
+
+func (x *StreamOffer) GetStreamId() uint64 {
+	if x != nil {
+		return x.StreamId
+	}
+	return 0
+}
+
+func (x *StreamOffer) GetToken() []byte {
+	if x != nil {
+		return x.Token
+	}
+	return nil
+}
+
+func (x *StreamOffer) GetRelayEndpoint() string {
+	if x != nil {
+		return x.RelayEndpoint
+	}
+	return ""
+}
+
+func (x *StreamOffer) GetRelayEndpoints() []string {
+	if x != nil {
+		return x.RelayEndpoints
+	}
+	return nil
+}
+
+func (x *StreamOffer) GetExpiresUnixMs() int64 {
+	if x != nil {
+		return x.ExpiresUnixMs
+	}
+	return 0
+}
+
+func (x *StreamOffer) GetRequest() *StartRelayStreamRequest {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+type StreamOfferResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the peer accepted the offer and will connect
+	Ok            bool      `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error         string    `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	ErrorCode     ErrorCode `protobuf:"varint,3,opt,name=error_code,json=errorCode,proto3,enum=flymesh.control.ErrorCode" json:"error_code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamOfferResponse) Reset() {
+	*x = StreamOfferResponse{}
+	mi := &file_control_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamOfferResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamOfferResponse) ProtoMessage() {}
+
+func (x *StreamOfferResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamOfferResponse.ProtoReflect.Descriptor instead.
+func (*StreamOfferResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *StreamOfferResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *StreamOfferResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *StreamOfferResponse) GetErrorCode() ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+// DialBackChallenge is sent by a relay-server on a stream it opens to a peer, to
+// verify that the peer controls the identity it requests allocations for.
+type DialBackChallenge struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nonce         []byte                 `protobuf:"bytes,1,opt,name=nonce,proto3" json:"nonce,omitempty"` // 32 bytes
+	RelayPeerId   []byte                 `protobuf:"bytes,2,opt,name=relay_peer_id,json=relayPeerId,proto3" json:"relay_peer_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StartRelayStreamRequest) Reset() {
-	*x = StartRelayStreamRequest{}
-	mi := &file_control_proto_msgTypes[0]
+func (x *DialBackChallenge) Reset() {
+	*x = DialBackChallenge{}
+	mi := &file_control_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StartRelayStreamRequest) String() string {
+func (x *DialBackChallenge) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StartRelayStreamRequest) ProtoMessage() {}
+func (*DialBackChallenge) ProtoMessage() {}
 
-func (x *StartRelayStreamRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[0]
+func (x *DialBackChallenge) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -55,37 +1255,49 @@ func (x *StartRelayStreamRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StartRelayStreamRequest.ProtoReflect.Descriptor instead.
-func (*StartRelayStreamRequest) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use DialBackChallenge.ProtoReflect.Descriptor instead.
+func (*DialBackChallenge) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{12}
 }
 
-type StartRelayStreamResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
-	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
-	RelayEndpoint string                 `protobuf:"bytes,3,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
-	StreamId      uint64                 `protobuf:"varint,4,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
-	Token         []byte                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"` // 32 bytes (256-bit)
+func (x *DialBackChallenge) GetNonce() []byte {
+	if x != nil {
+		return x.Nonce
+	}
+	return nil
+}
+
+func (x *DialBackChallenge) GetRelayPeerId() []byte {
+	if x != nil {
+		return x.RelayPeerId
+	}
+	return nil
+}
+
+type DialBackResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Signature by the peer's identity key over the dial-back payload
+	// ("flymesh-dialback/1" || relay_peer_id || nonce).
+	Signature     []byte `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StartRelayStreamResponse) Reset() {
-	*x = StartRelayStreamResponse{}
-	mi := &file_control_proto_msgTypes[1]
+func (x *DialBackResponse) Reset() {
+	*x = DialBackResponse{}
+	mi := &file_control_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StartRelayStreamResponse) String() string {
+func (x *DialBackResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StartRelayStreamResponse) ProtoMessage() {}
+func (*DialBackResponse) ProtoMessage() {}
 
-func (x *StartRelayStreamResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[1]
+func (x *DialBackResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -96,68 +1308,219 @@ func (x *StartRelayStreamResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StartRelayStreamResponse.ProtoReflect.Descriptor instead.
-func (*StartRelayStreamResponse) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use DialBackResponse.ProtoReflect.Descriptor instead.
+func (*DialBackResponse) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *StartRelayStreamResponse) GetOk() bool {
+func (x *DialBackResponse) GetSignature() []byte {
 	if x != nil {
-		return x.Ok
+		return x.Signature
 	}
-	return false
+	return nil
 }
 
-func (x *StartRelayStreamResponse) GetError() string {
+// WireGuardHello is exchanged by both ends at the start of a stream carrying
+// WireGuard, before its packets.
+type WireGuardHello struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Curve25519 public key of the WireGuard device of the sender
+	PublicKey []byte `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	// Prefixes routed to the sender, as CIDR
+	Routes        []string `protobuf:"bytes,2,rep,name=routes,proto3" json:"routes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WireGuardHello) Reset() {
+	*x = WireGuardHello{}
+	mi := &file_control_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WireGuardHello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WireGuardHello) ProtoMessage() {}
+
+func (x *WireGuardHello) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[14]
 	if x != nil {
-		return x.Error
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return ""
+	return mi.MessageOf(x)
 }
 
-func (x *StartRelayStreamResponse) GetRelayEndpoint() string {
+// Deprecated: Use WireGuardHello.ProtoReflect.Descriptor instead.
+func (*WireGuardHello) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *WireGuardHello) GetPublicKey() []byte {
 	if x != nil {
-		return x.RelayEndpoint
+		return x.PublicKey
+	}
+	return nil
+}
+
+func (x *WireGuardHello) GetRoutes() []string {
+	if x != nil {
+		return x.Routes
+	}
+	return nil
+}
+
+// FileRequest opens a file transfer on a stream with the flymesh-file ALPN.
+// The sender of the file then sends a FileHeader, the receiver answers a
+// FileResume, and FileChunk frames carry the rest of the file. The receiver
+// ends the transfer with a FileResult. Either end may send a FileResult
+// instead of its next message to refuse the transfer.
+type FileRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Path of the file on the server, relative to the directory it serves
+	Path string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	// Whether the client sends the file rather than fetches it
+	Put           bool `protobuf:"varint,2,opt,name=put,proto3" json:"put,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileRequest) Reset() {
+	*x = FileRequest{}
+	mi := &file_control_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileRequest) ProtoMessage() {}
+
+func (x *FileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileRequest.ProtoReflect.Descriptor instead.
+func (*FileRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *FileRequest) GetPath() string {
+	if x != nil {
+		return x.Path
 	}
 	return ""
 }
 
-func (x *StartRelayStreamResponse) GetStreamId() uint64 {
+func (x *FileRequest) GetPut() bool {
 	if x != nil {
-		return x.StreamId
+		return x.Put
+	}
+	return false
+}
+
+type FileHeader struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Size  uint64                 `protobuf:"varint,1,opt,name=size,proto3" json:"size,omitempty"`
+	// SHA-256 of the whole file, verified by the receiver
+	Sha256 []byte `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	// Permission bits of the file
+	Mode          uint32 `protobuf:"varint,3,opt,name=mode,proto3" json:"mode,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileHeader) Reset() {
+	*x = FileHeader{}
+	mi := &file_control_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileHeader) ProtoMessage() {}
+
+func (x *FileHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileHeader.ProtoReflect.Descriptor instead.
+func (*FileHeader) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *FileHeader) GetSize() uint64 {
+	if x != nil {
+		return x.Size
 	}
 	return 0
 }
 
-func (x *StartRelayStreamResponse) GetToken() []byte {
+func (x *FileHeader) GetSha256() []byte {
 	if x != nil {
-		return x.Token
+		return x.Sha256
 	}
 	return nil
 }
 
-type CreateStreamRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ClientPeerId  []byte                 `protobuf:"bytes,1,opt,name=client_peer_id,json=clientPeerId,proto3" json:"client_peer_id,omitempty"`
+func (x *FileHeader) GetMode() uint32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+type FileResume struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Bytes of the file the receiver holds from an earlier transfer, which the
+	// sender skips
+	Offset        uint64 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CreateStreamRequest) Reset() {
-	*x = CreateStreamRequest{}
-	mi := &file_control_proto_msgTypes[2]
+func (x *FileResume) Reset() {
+	*x = FileResume{}
+	mi := &file_control_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateStreamRequest) String() string {
+func (x *FileResume) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CreateStreamRequest) ProtoMessage() {}
+func (*FileResume) ProtoMessage() {}
 
-func (x *CreateStreamRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[2]
+func (x *FileResume) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -168,44 +1531,41 @@ func (x *CreateStreamRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CreateStreamRequest.ProtoReflect.Descriptor instead.
-func (*CreateStreamRequest) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use FileResume.ProtoReflect.Descriptor instead.
+func (*FileResume) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{17}
 }
 
-func (x *CreateStreamRequest) GetClientPeerId() []byte {
+func (x *FileResume) GetOffset() uint64 {
 	if x != nil {
-		return x.ClientPeerId
+		return x.Offset
 	}
-	return nil
+	return 0
 }
 
-type CreateStreamResponse struct {
+type FileResult struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
 	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
-	RelayEndpoint string                 `protobuf:"bytes,3,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
-	StreamId      uint64                 `protobuf:"varint,4,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
-	Token         []byte                 `protobuf:"bytes,5,opt,name=token,proto3" json:"token,omitempty"` // 32 bytes (256-bit)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CreateStreamResponse) Reset() {
-	*x = CreateStreamResponse{}
-	mi := &file_control_proto_msgTypes[3]
+func (x *FileResult) Reset() {
+	*x = FileResult{}
+	mi := &file_control_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateStreamResponse) String() string {
+func (x *FileResult) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CreateStreamResponse) ProtoMessage() {}
+func (*FileResult) ProtoMessage() {}
 
-func (x *CreateStreamResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_control_proto_msgTypes[3]
+func (x *FileResult) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -216,66 +1576,294 @@ func (x *CreateStreamResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CreateStreamResponse.ProtoReflect.Descriptor instead.
-func (*CreateStreamResponse) Descriptor() ([]byte, []int) {
-	return file_control_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use FileResult.ProtoReflect.Descriptor instead.
+func (*FileResult) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{18}
 }
 
-func (x *CreateStreamResponse) GetOk() bool {
+func (x *FileResult) GetOk() bool {
 	if x != nil {
 		return x.Ok
 	}
 	return false
 }
 
-func (x *CreateStreamResponse) GetError() string {
+func (x *FileResult) GetError() string {
 	if x != nil {
 		return x.Error
 	}
 	return ""
 }
 
-func (x *CreateStreamResponse) GetRelayEndpoint() string {
+// ExecRequest runs a command on a stream with the flymesh-exec ALPN. The client
+// then sends the stdin of the command in ExecStdin frames, an empty one closing
+// it, and the server its output in ExecStdout and ExecStderr frames. The server
+// ends the stream with an ExecExit.
+type ExecRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Program and arguments of the command
+	Argv []string `protobuf:"bytes,1,rep,name=argv,proto3" json:"argv,omitempty"`
+	// Environment variables, KEY=VALUE, added to those of the server
+	Env []string `protobuf:"bytes,2,rep,name=env,proto3" json:"env,omitempty"`
+	// Working directory of the command, empty for that of the server
+	Dir           string `protobuf:"bytes,3,opt,name=dir,proto3" json:"dir,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecRequest) Reset() {
+	*x = ExecRequest{}
+	mi := &file_control_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecRequest) ProtoMessage() {}
+
+func (x *ExecRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[19]
 	if x != nil {
-		return x.RelayEndpoint
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecRequest.ProtoReflect.Descriptor instead.
+func (*ExecRequest) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ExecRequest) GetArgv() []string {
+	if x != nil {
+		return x.Argv
+	}
+	return nil
+}
+
+func (x *ExecRequest) GetEnv() []string {
+	if x != nil {
+		return x.Env
+	}
+	return nil
+}
+
+func (x *ExecRequest) GetDir() string {
+	if x != nil {
+		return x.Dir
 	}
 	return ""
 }
 
-func (x *CreateStreamResponse) GetStreamId() uint64 {
+type ExecExit struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Exit code of the command, -1 if it was killed by a signal
+	Code int32 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	// Why the command did not run, or was refused
+	Error         string `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExecExit) Reset() {
+	*x = ExecExit{}
+	mi := &file_control_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExecExit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExecExit) ProtoMessage() {}
+
+func (x *ExecExit) ProtoReflect() protoreflect.Message {
+	mi := &file_control_proto_msgTypes[20]
 	if x != nil {
-		return x.StreamId
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExecExit.ProtoReflect.Descriptor instead.
+func (*ExecExit) Descriptor() ([]byte, []int) {
+	return file_control_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ExecExit) GetCode() int32 {
+	if x != nil {
+		return x.Code
 	}
 	return 0
 }
 
-func (x *CreateStreamResponse) GetToken() []byte {
+func (x *ExecExit) GetError() string {
 	if x != nil {
-		return x.Token
+		return x.Error
 	}
-	return nil
+	return ""
 }
 
 var File_control_proto protoreflect.FileDescriptor
 
 const file_control_proto_rawDesc = "" +
 	"\n" +
-	"\rcontrol.proto\x12\x0fflymesh.control\"\x19\n" +
-	"\x17StartRelayStreamRequest\"\x9a\x01\n" +
+	"\rcontrol.proto\x12\x0fflymesh.control\"\x9e\x01\n" +
+	"\x05Hello\x12)\n" +
+	"\x10protocol_version\x18\x01 \x01(\rR\x0fprotocolVersion\x12\x1a\n" +
+	"\bfeatures\x18\x02 \x03(\tR\bfeatures\x12/\n" +
+	"\x06limits\x18\x03 \x01(\v2\x17.flymesh.control.LimitsR\x06limits\x12\x1d\n" +
+	"\n" +
+	"relay_macs\x18\x04 \x03(\rR\trelayMacs\"\xcf\x01\n" +
+	"\x06Limits\x12.\n" +
+	"\x13max_control_payload\x18\x01 \x01(\rR\x11maxControlPayload\x12*\n" +
+	"\x11max_relay_payload\x18\x02 \x01(\rR\x0fmaxRelayPayload\x122\n" +
+	"\x15max_batch_allocations\x18\x03 \x01(\rR\x13maxBatchAllocations\x125\n" +
+	"\x17max_sessions_per_client\x18\x04 \x01(\rR\x14maxSessionsPerClient\"\xc9\x03\n" +
+	"\x17StartRelayStreamRequest\x12_\n" +
+	"\rtrace_context\x18\x01 \x03(\v2:.flymesh.control.StartRelayStreamRequest.TraceContextEntryR\ftraceContext\x12!\n" +
+	"\fretry_cookie\x18\x02 \x01(\fR\vretryCookie\x12\x18\n" +
+	"\aservice\x18\x03 \x01(\tR\aservice\x12%\n" +
+	"\x0etarget_address\x18\x04 \x01(\tR\rtargetAddress\x12\x12\n" +
+	"\x04alpn\x18\x05 \x01(\tR\x04alpn\x12!\n" +
+	"\fbind_session\x18\x06 \x01(\bR\vbindSession\x12 \n" +
+	"\vcompression\x18\a \x03(\tR\vcompression\x12\x12\n" +
+	"\x04bond\x18\b \x01(\fR\x04bond\x12;\n" +
+	"\bpriority\x18\t \x01(\x0e2\x1f.flymesh.control.StreamPriorityR\bpriority\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xe6\x02\n" +
 	"\x18StartRelayStreamResponse\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
 	"\x05error\x18\x02 \x01(\tR\x05error\x12%\n" +
 	"\x0erelay_endpoint\x18\x03 \x01(\tR\rrelayEndpoint\x12\x1b\n" +
 	"\tstream_id\x18\x04 \x01(\x04R\bstreamId\x12\x14\n" +
-	"\x05token\x18\x05 \x01(\fR\x05token\";\n" +
+	"\x05token\x18\x05 \x01(\fR\x05token\x12!\n" +
+	"\fretry_cookie\x18\x06 \x01(\fR\vretryCookie\x129\n" +
+	"\n" +
+	"error_code\x18\a \x01(\x0e2\x1a.flymesh.control.ErrorCodeR\terrorCode\x12!\n" +
+	"\fbind_session\x18\b \x01(\bR\vbindSession\x12 \n" +
+	"\vcompression\x18\t \x01(\tR\vcompression\x12'\n" +
+	"\x0frelay_endpoints\x18\n" +
+	" \x03(\tR\x0erelayEndpoints\"\xd8\x03\n" +
 	"\x13CreateStreamRequest\x12$\n" +
-	"\x0eclient_peer_id\x18\x01 \x01(\fR\fclientPeerId\"\x96\x01\n" +
+	"\x0eclient_peer_id\x18\x01 \x01(\fR\fclientPeerId\x12[\n" +
+	"\rtrace_context\x18\x02 \x03(\v26.flymesh.control.CreateStreamRequest.TraceContextEntryR\ftraceContext\x12!\n" +
+	"\fretry_cookie\x18\x03 \x01(\fR\vretryCookie\x12\x1b\n" +
+	"\tmax_bytes\x18\x04 \x01(\x04R\bmaxBytes\x12(\n" +
+	"\x10deadline_unix_ms\x18\x05 \x01(\x03R\x0edeadlineUnixMs\x12\x14\n" +
+	"\x05offer\x18\x06 \x01(\bR\x05offer\x12@\n" +
+	"\x06notify\x18\a \x01(\v2(.flymesh.control.StartRelayStreamRequestR\x06notify\x12;\n" +
+	"\bpriority\x18\b \x01(\x0e2\x1f.flymesh.control.StreamPriorityR\bpriority\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc8\x02\n" +
 	"\x14CreateStreamResponse\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
 	"\x05error\x18\x02 \x01(\tR\x05error\x12%\n" +
 	"\x0erelay_endpoint\x18\x03 \x01(\tR\rrelayEndpoint\x12\x1b\n" +
 	"\tstream_id\x18\x04 \x01(\x04R\bstreamId\x12\x14\n" +
-	"\x05token\x18\x05 \x01(\fR\x05tokenB2Z0github.com/flymesh/core/pkg/pb/control;controlpbb\x06proto3"
+	"\x05token\x18\x05 \x01(\fR\x05token\x12!\n" +
+	"\fretry_cookie\x18\x06 \x01(\fR\vretryCookie\x129\n" +
+	"\n" +
+	"error_code\x18\a \x01(\x0e2\x1a.flymesh.control.ErrorCodeR\terrorCode\x12'\n" +
+	"\x0frelay_endpoints\x18\b \x03(\tR\x0erelayEndpoints\x12)\n" +
+	"\x10observed_address\x18\t \x01(\tR\x0fobservedAddress\"\xf1\x01\n" +
+	"\x14CreateStreamsRequest\x12$\n" +
+	"\x0eclient_peer_id\x18\x01 \x01(\fR\fclientPeerId\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\rR\x05count\x12\\\n" +
+	"\rtrace_context\x18\x03 \x03(\v27.flymesh.control.CreateStreamsRequest.TraceContextEntryR\ftraceContext\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"h\n" +
+	"\x10StreamAllocation\x12\x1b\n" +
+	"\tstream_id\x18\x01 \x01(\x04R\bstreamId\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\fR\x05token\x12!\n" +
+	"\fretry_cookie\x18\x03 \x01(\fR\vretryCookie\"\xd8\x02\n" +
+	"\x15CreateStreamsResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12%\n" +
+	"\x0erelay_endpoint\x18\x03 \x01(\tR\rrelayEndpoint\x12;\n" +
+	"\astreams\x18\x04 \x03(\v2!.flymesh.control.StreamAllocationR\astreams\x12&\n" +
+	"\x0fexpires_unix_ms\x18\x05 \x01(\x03R\rexpiresUnixMs\x129\n" +
+	"\n" +
+	"error_code\x18\x06 \x01(\x0e2\x1a.flymesh.control.ErrorCodeR\terrorCode\x12'\n" +
+	"\x0frelay_endpoints\x18\a \x03(\tR\x0erelayEndpoints\x12)\n" +
+	"\x10observed_address\x18\b \x01(\tR\x0fobservedAddress\"\xa8\x01\n" +
+	"\rStreamExpired\x12\x1b\n" +
+	"\tstream_id\x18\x01 \x01(\x04R\bstreamId\x12$\n" +
+	"\x0eclient_peer_id\x18\x02 \x01(\fR\fclientPeerId\x12)\n" +
+	"\x10server_connected\x18\x03 \x01(\bR\x0fserverConnected\x12)\n" +
+	"\x10client_connected\x18\x04 \x01(\bR\x0fclientConnected\"\x95\x02\n" +
+	"\vStreamOffer\x12\x17\n" +
+	"\apeer_id\x18\x01 \x01(\fR\x06peerId\x12\x1b\n" +
+	"\tstream_id\x18\x02 \x01(\x04R\bstreamId\x12\x14\n" +
+	"\x05token\x18\x03 \x01(\fR\x05token\x12%\n" +
+	"\x0erelay_endpoint\x18\x04 \x01(\tR\rrelayEndpoint\x12'\n" +
+	"\x0frelay_endpoints\x18\x05 \x03(\tR\x0erelayEndpoints\x12&\n" +
+	"\x0fexpires_unix_ms\x18\x06 \x01(\x03R\rexpiresUnixMs\x12B\n" +
+	"\arequest\x18\a \x01(\v2(.flymesh.control.StartRelayStreamRequestR\arequest\"v\n" +
+	"\x13StreamOfferResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x129\n" +
+	"\n" +
+	"error_code\x18\x03 \x01(\x0e2\x1a.flymesh.control.ErrorCodeR\terrorCode\"M\n" +
+	"\x11DialBackChallenge\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\fR\x05nonce\x12\"\n" +
+	"\rrelay_peer_id\x18\x02 \x01(\fR\vrelayPeerId\"0\n" +
+	"\x10DialBackResponse\x12\x1c\n" +
+	"\tsignature\x18\x01 \x01(\fR\tsignature\"G\n" +
+	"\x0eWireGuardHello\x12\x1d\n" +
+	"\n" +
+	"public_key\x18\x01 \x01(\fR\tpublicKey\x12\x16\n" +
+	"\x06routes\x18\x02 \x03(\tR\x06routes\"3\n" +
+	"\vFileRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x10\n" +
+	"\x03put\x18\x02 \x01(\bR\x03put\"L\n" +
+	"\n" +
+	"FileHeader\x12\x12\n" +
+	"\x04size\x18\x01 \x01(\x04R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x02 \x01(\fR\x06sha256\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\rR\x04mode\"$\n" +
+	"\n" +
+	"FileResume\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x04R\x06offset\"2\n" +
+	"\n" +
+	"FileResult\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"E\n" +
+	"\vExecRequest\x12\x12\n" +
+	"\x04argv\x18\x01 \x03(\tR\x04argv\x12\x10\n" +
+	"\x03env\x18\x02 \x03(\tR\x03env\x12\x10\n" +
+	"\x03dir\x18\x03 \x01(\tR\x03dir\"4\n" +
+	"\bExecExit\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\x05R\x04code\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error*\xf9\x01\n" +
+	"\tErrorCode\x12\x1a\n" +
+	"\x16ERROR_CODE_UNSPECIFIED\x10\x00\x12\x1d\n" +
+	"\x19ERROR_CODE_UNKNOWN_TARGET\x10\x01\x12\x1b\n" +
+	"\x17ERROR_CODE_UNAUTHORIZED\x10\x02\x12\x1d\n" +
+	"\x19ERROR_CODE_NO_SUCH_STREAM\x10\x03\x12\x1c\n" +
+	"\x18ERROR_CODE_HMAC_MISMATCH\x10\x04\x12\x1d\n" +
+	"\x19ERROR_CODE_QUOTA_EXCEEDED\x10\x05\x12\x1c\n" +
+	"\x18ERROR_CODE_SHUTTING_DOWN\x10\x06\x12\x1a\n" +
+	"\x16ERROR_CODE_BAD_REQUEST\x10\a*l\n" +
+	"\x0eStreamPriority\x12\x1f\n" +
+	"\x1bSTREAM_PRIORITY_UNSPECIFIED\x10\x00\x12\x1f\n" +
+	"\x1bSTREAM_PRIORITY_INTERACTIVE\x10\x01\x12\x18\n" +
+	"\x14STREAM_PRIORITY_BULK\x10\x02B2Z0github.com/flymesh/core/pkg/pb/control;controlpbb\x06proto3"
 
 var (
 	file_control_proto_rawDescOnce sync.Once
@@ -289,19 +1877,55 @@ func file_control_proto_rawDescGZIP() []byte {
 	return file_control_proto_rawDescData
 }
 
-var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_control_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_control_proto_msgTypes = make([]protoimpl.MessageInfo, 24)
 var file_control_proto_goTypes = []any{
-	(*StartRelayStreamRequest)(nil),  // 0: flymesh.control.StartRelayStreamRequest
-	(*StartRelayStreamResponse)(nil), // 1: flymesh.control.StartRelayStreamResponse
-	(*CreateStreamRequest)(nil),      // 2: flymesh.control.CreateStreamRequest
-	(*CreateStreamResponse)(nil),     // 3: flymesh.control.CreateStreamResponse
+	(ErrorCode)(0),                   // 0: flymesh.control.ErrorCode
+	(StreamPriority)(0),              // 1: flymesh.control.StreamPriority
+	(*Hello)(nil),                    // 2: flymesh.control.Hello
+	(*Limits)(nil),                   // 3: flymesh.control.Limits
+	(*StartRelayStreamRequest)(nil),  // 4: flymesh.control.StartRelayStreamRequest
+	(*StartRelayStreamResponse)(nil), // 5: flymesh.control.StartRelayStreamResponse
+	(*CreateStreamRequest)(nil),      // 6: flymesh.control.CreateStreamRequest
+	(*CreateStreamResponse)(nil),     // 7: flymesh.control.CreateStreamResponse
+	(*CreateStreamsRequest)(nil),     // 8: flymesh.control.CreateStreamsRequest
+	(*StreamAllocation)(nil),         // 9: flymesh.control.StreamAllocation
+	(*CreateStreamsResponse)(nil),    // 10: flymesh.control.CreateStreamsResponse
+	(*StreamExpired)(nil),            // 11: flymesh.control.StreamExpired
+	(*StreamOffer)(nil),              // 12: flymesh.control.StreamOffer
+	(*StreamOfferResponse)(nil),      // 13: flymesh.control.StreamOfferResponse
+	(*DialBackChallenge)(nil),        // 14: flymesh.control.DialBackChallenge
+	(*DialBackResponse)(nil),         // 15: flymesh.control.DialBackResponse
+	(*WireGuardHello)(nil),           // 16: flymesh.control.WireGuardHello
+	(*FileRequest)(nil),              // 17: flymesh.control.FileRequest
+	(*FileHeader)(nil),               // 18: flymesh.control.FileHeader
+	(*FileResume)(nil),               // 19: flymesh.control.FileResume
+	(*FileResult)(nil),               // 20: flymesh.control.FileResult
+	(*ExecRequest)(nil),              // 21: flymesh.control.ExecRequest
+	(*ExecExit)(nil),                 // 22: flymesh.control.ExecExit
+	nil,                              // 23: flymesh.control.StartRelayStreamRequest.TraceContextEntry
+	nil,                              // 24: flymesh.control.CreateStreamRequest.TraceContextEntry
+	nil,                              // 25: flymesh.control.CreateStreamsRequest.TraceContextEntry
 }
 var file_control_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3,  // 0: flymesh.control.Hello.limits:type_name -> flymesh.control.Limits
+	23, // 1: flymesh.control.StartRelayStreamRequest.trace_context:type_name -> flymesh.control.StartRelayStreamRequest.TraceContextEntry
+	1,  // 2: flymesh.control.StartRelayStreamRequest.priority:type_name -> flymesh.control.StreamPriority
+	0,  // 3: flymesh.control.StartRelayStreamResponse.error_code:type_name -> flymesh.control.ErrorCode
+	24, // 4: flymesh.control.CreateStreamRequest.trace_context:type_name -> flymesh.control.CreateStreamRequest.TraceContextEntry
+	4,  // 5: flymesh.control.CreateStreamRequest.notify:type_name -> flymesh.control.StartRelayStreamRequest
+	1,  // 6: flymesh.control.CreateStreamRequest.priority:type_name -> flymesh.control.StreamPriority
+	0,  // 7: flymesh.control.CreateStreamResponse.error_code:type_name -> flymesh.control.ErrorCode
+	25, // 8: flymesh.control.CreateStreamsRequest.trace_context:type_name -> flymesh.control.CreateStreamsRequest.TraceContextEntry
+	9,  // 9: flymesh.control.CreateStreamsResponse.streams:type_name -> flymesh.control.StreamAllocation
+	0,  // 10: flymesh.control.CreateStreamsResponse.error_code:type_name -> flymesh.control.ErrorCode
+	4,  // 11: flymesh.control.StreamOffer.request:type_name -> flymesh.control.StartRelayStreamRequest
+	0,  // 12: flymesh.control.StreamOfferResponse.error_code:type_name -> flymesh.control.ErrorCode
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_control_proto_init() }
@@ -314,13 +1938,14 @@ func file_control_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_control_proto_rawDesc), len(file_control_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   4,
+			NumEnums:      2,
+			NumMessages:   24,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_control_proto_goTypes,
 		DependencyIndexes: file_control_proto_depIdxs,
+		EnumInfos:         file_control_proto_enumTypes,
 		MessageInfos:      file_control_proto_msgTypes,
 	}.Build()
 	File_control_proto = out.File
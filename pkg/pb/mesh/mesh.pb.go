@@ -0,0 +1,256 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v3.21.12
+// source: mesh.proto
+
+package meshpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Announcement is published periodically by every member of a mesh on the
+// GossipSub topic of the mesh.
+type Announcement struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	PeerId          []byte                 `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	Addrs           [][]byte               `protobuf:"bytes,2,rep,name=addrs,proto3" json:"addrs,omitempty"` // binary multiaddrs
+	Services        []string               `protobuf:"bytes,3,rep,name=services,proto3" json:"services,omitempty"`
+	Role            string                 `protobuf:"bytes,4,opt,name=role,proto3" json:"role,omitempty"` // "relay-server" or "tunnel"
+	TimestampUnixMs int64                  `protobuf:"varint,5,opt,name=timestamp_unix_ms,json=timestampUnixMs,proto3" json:"timestamp_unix_ms,omitempty"`
+	// Load of a relay-server member, unset for other roles
+	RelayLoad     *RelayLoad `protobuf:"bytes,6,opt,name=relay_load,json=relayLoad,proto3" json:"relay_load,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Announcement) Reset() {
+	*x = Announcement{}
+	mi := &file_mesh_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Announcement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Announcement) ProtoMessage() {}
+
+func (x *Announcement) ProtoReflect() protoreflect.Message {
+	mi := &file_mesh_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Announcement.ProtoReflect.Descriptor instead.
+func (*Announcement) Descriptor() ([]byte, []int) {
+	return file_mesh_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Announcement) GetPeerId() []byte {
+	if x != nil {
+		return x.PeerId
+	}
+	return nil
+}
+
+func (x *Announcement) GetAddrs() [][]byte {
+	if x != nil {
+		return x.Addrs
+	}
+	return nil
+}
+
+func (x *Announcement) GetServices() []string {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+func (x *Announcement) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+func (x *Announcement) GetTimestampUnixMs() int64 {
+	if x != nil {
+		return x.TimestampUnixMs
+	}
+	return 0
+}
+
+func (x *Announcement) GetRelayLoad() *RelayLoad {
+	if x != nil {
+		return x.RelayLoad
+	}
+	return nil
+}
+
+// RelayLoad is how busy a relay-server is, for peers picking the least loaded
+// relay of a fleet.
+type RelayLoad struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Relay endpoint handed to peers, host:port
+	RelayEndpoint string `protobuf:"bytes,1,opt,name=relay_endpoint,json=relayEndpoint,proto3" json:"relay_endpoint,omitempty"`
+	// Allocations currently held, bridged or not
+	Allocations uint32 `protobuf:"varint,2,opt,name=allocations,proto3" json:"allocations,omitempty"`
+	// Allocations currently bridged
+	Bridges uint32 `protobuf:"varint,3,opt,name=bridges,proto3" json:"bridges,omitempty"`
+	// Maximum concurrent allocations, 0 for unlimited
+	MaxAllocations uint32 `protobuf:"varint,4,opt,name=max_allocations,json=maxAllocations,proto3" json:"max_allocations,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *RelayLoad) Reset() {
+	*x = RelayLoad{}
+	mi := &file_mesh_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RelayLoad) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RelayLoad) ProtoMessage() {}
+
+func (x *RelayLoad) ProtoReflect() protoreflect.Message {
+	mi := &file_mesh_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RelayLoad.ProtoReflect.Descriptor instead.
+func (*RelayLoad) Descriptor() ([]byte, []int) {
+	return file_mesh_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RelayLoad) GetRelayEndpoint() string {
+	if x != nil {
+		return x.RelayEndpoint
+	}
+	return ""
+}
+
+func (x *RelayLoad) GetAllocations() uint32 {
+	if x != nil {
+		return x.Allocations
+	}
+	return 0
+}
+
+func (x *RelayLoad) GetBridges() uint32 {
+	if x != nil {
+		return x.Bridges
+	}
+	return 0
+}
+
+func (x *RelayLoad) GetMaxAllocations() uint32 {
+	if x != nil {
+		return x.MaxAllocations
+	}
+	return 0
+}
+
+var File_mesh_proto protoreflect.FileDescriptor
+
+const file_mesh_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"mesh.proto\x12\fflymesh.mesh\"\xd1\x01\n" +
+	"\fAnnouncement\x12\x17\n" +
+	"\apeer_id\x18\x01 \x01(\fR\x06peerId\x12\x14\n" +
+	"\x05addrs\x18\x02 \x03(\fR\x05addrs\x12\x1a\n" +
+	"\bservices\x18\x03 \x03(\tR\bservices\x12\x12\n" +
+	"\x04role\x18\x04 \x01(\tR\x04role\x12*\n" +
+	"\x11timestamp_unix_ms\x18\x05 \x01(\x03R\x0ftimestampUnixMs\x126\n" +
+	"\n" +
+	"relay_load\x18\x06 \x01(\v2\x17.flymesh.mesh.RelayLoadR\trelayLoad\"\x97\x01\n" +
+	"\tRelayLoad\x12%\n" +
+	"\x0erelay_endpoint\x18\x01 \x01(\tR\rrelayEndpoint\x12 \n" +
+	"\vallocations\x18\x02 \x01(\rR\vallocations\x12\x18\n" +
+	"\abridges\x18\x03 \x01(\rR\abridges\x12'\n" +
+	"\x0fmax_allocations\x18\x04 \x01(\rR\x0emaxAllocationsB,Z*github.com/flymesh/core/pkg/pb/mesh;meshpbb\x06proto3"
+
+var (
+	file_mesh_proto_rawDescOnce sync.Once
+	file_mesh_proto_rawDescData []byte
+)
+
+func file_mesh_proto_rawDescGZIP() []byte {
+	file_mesh_proto_rawDescOnce.Do(func() {
+		file_mesh_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_mesh_proto_rawDesc), len(file_mesh_proto_rawDesc)))
+	})
+	return file_mesh_proto_rawDescData
+}
+
+var file_mesh_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_mesh_proto_goTypes = []any{
+	(*Announcement)(nil), // 0: flymesh.mesh.Announcement
+	(*RelayLoad)(nil),    // 1: flymesh.mesh.RelayLoad
+}
+var file_mesh_proto_depIdxs = []int32{
+	1, // 0: flymesh.mesh.Announcement.relay_load:type_name -> flymesh.mesh.RelayLoad
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_mesh_proto_init() }
+func file_mesh_proto_init() {
+	if File_mesh_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_mesh_proto_rawDesc), len(file_mesh_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_mesh_proto_goTypes,
+		DependencyIndexes: file_mesh_proto_depIdxs,
+		MessageInfos:      file_mesh_proto_msgTypes,
+	}.Build()
+	File_mesh_proto = out.File
+	file_mesh_proto_goTypes = nil
+	file_mesh_proto_depIdxs = nil
+}
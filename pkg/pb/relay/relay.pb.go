@@ -10,6 +10,7 @@
 package relaypb
 
 import (
+	control "github.com/flymesh/core/pkg/pb/control"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -25,9 +26,13 @@ const (
 )
 
 type HandshakeRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	StreamId      uint64                 `protobuf:"varint,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
-	SenderPeerId  []byte                 `protobuf:"bytes,2,opt,name=sender_peer_id,json=senderPeerId,proto3" json:"sender_peer_id,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	StreamId     uint64                 `protobuf:"varint,1,opt,name=stream_id,json=streamId,proto3" json:"stream_id,omitempty"`
+	SenderPeerId []byte                 `protobuf:"bytes,2,opt,name=sender_peer_id,json=senderPeerId,proto3" json:"sender_peer_id,omitempty"`
+	// W3C trace context (traceparent/tracestate) of the dialing span
+	TraceContext map[string]string `protobuf:"bytes,3,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Hello of the dialing peer, unset for version 1 peers
+	Hello         *control.Hello `protobuf:"bytes,4,opt,name=hello,proto3" json:"hello,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -76,12 +81,32 @@ func (x *HandshakeRequest) GetSenderPeerId() []byte {
 	return nil
 }
 
+func (x *HandshakeRequest) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
+}
+
+func (x *HandshakeRequest) GetHello() *control.Hello {
+	if x != nil {
+		return x.Hello
+	}
+	return nil
+}
+
 type HandshakeAck struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
-	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Ok    bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	// Hello of the relay-server, unset for version 1 relays
+	Hello     *control.Hello    `protobuf:"bytes,3,opt,name=hello,proto3" json:"hello,omitempty"`
+	ErrorCode control.ErrorCode `protobuf:"varint,4,opt,name=error_code,json=errorCode,proto3,enum=flymesh.control.ErrorCode" json:"error_code,omitempty"`
+	// Address the connection came from as the relay-server saw it, ip:port;
+	// the NAT mapping of the peer. Empty for non-TCP connections.
+	ObservedAddress string `protobuf:"bytes,5,opt,name=observed_address,json=observedAddress,proto3" json:"observed_address,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *HandshakeAck) Reset() {
@@ -128,17 +153,192 @@ func (x *HandshakeAck) GetError() string {
 	return ""
 }
 
+func (x *HandshakeAck) GetHello() *control.Hello {
+	if x != nil {
+		return x.Hello
+	}
+	return nil
+}
+
+func (x *HandshakeAck) GetErrorCode() control.ErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return control.ErrorCode(0)
+}
+
+func (x *HandshakeAck) GetObservedAddress() string {
+	if x != nil {
+		return x.ObservedAddress
+	}
+	return ""
+}
+
+// Probe asks the relay-server for an immediate ProbeReply, so that peers
+// measure their round-trip time to it before asking for any allocation.
+type Probe struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Echoed in the reply
+	Nonce uint64 `protobuf:"varint,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Hello of the probing peer
+	Hello         *control.Hello `protobuf:"bytes,2,opt,name=hello,proto3" json:"hello,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Probe) Reset() {
+	*x = Probe{}
+	mi := &file_relay_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Probe) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Probe) ProtoMessage() {}
+
+func (x *Probe) ProtoReflect() protoreflect.Message {
+	mi := &file_relay_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Probe.ProtoReflect.Descriptor instead.
+func (*Probe) Descriptor() ([]byte, []int) {
+	return file_relay_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Probe) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
+func (x *Probe) GetHello() *control.Hello {
+	if x != nil {
+		return x.Hello
+	}
+	return nil
+}
+
+type ProbeReply struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Nonce uint64                 `protobuf:"varint,1,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	// Hello of the relay-server
+	Hello *control.Hello `protobuf:"bytes,2,opt,name=hello,proto3" json:"hello,omitempty"`
+	// Allocations the relay-server holds, and their cap, 0 for unlimited
+	Allocations    uint32 `protobuf:"varint,3,opt,name=allocations,proto3" json:"allocations,omitempty"`
+	MaxAllocations uint32 `protobuf:"varint,4,opt,name=max_allocations,json=maxAllocations,proto3" json:"max_allocations,omitempty"`
+	// Address the probe came from, as in HandshakeAck
+	ObservedAddress string `protobuf:"bytes,5,opt,name=observed_address,json=observedAddress,proto3" json:"observed_address,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ProbeReply) Reset() {
+	*x = ProbeReply{}
+	mi := &file_relay_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProbeReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProbeReply) ProtoMessage() {}
+
+func (x *ProbeReply) ProtoReflect() protoreflect.Message {
+	mi := &file_relay_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProbeReply.ProtoReflect.Descriptor instead.
+func (*ProbeReply) Descriptor() ([]byte, []int) {
+	return file_relay_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ProbeReply) GetNonce() uint64 {
+	if x != nil {
+		return x.Nonce
+	}
+	return 0
+}
+
+func (x *ProbeReply) GetHello() *control.Hello {
+	if x != nil {
+		return x.Hello
+	}
+	return nil
+}
+
+func (x *ProbeReply) GetAllocations() uint32 {
+	if x != nil {
+		return x.Allocations
+	}
+	return 0
+}
+
+func (x *ProbeReply) GetMaxAllocations() uint32 {
+	if x != nil {
+		return x.MaxAllocations
+	}
+	return 0
+}
+
+func (x *ProbeReply) GetObservedAddress() string {
+	if x != nil {
+		return x.ObservedAddress
+	}
+	return ""
+}
+
 var File_relay_proto protoreflect.FileDescriptor
 
 const file_relay_proto_rawDesc = "" +
 	"\n" +
-	"\vrelay.proto\x12\rflymesh.relay\"U\n" +
+	"\vrelay.proto\x12\rflymesh.relay\x1a\rcontrol.proto\"\x9c\x02\n" +
 	"\x10HandshakeRequest\x12\x1b\n" +
 	"\tstream_id\x18\x01 \x01(\x04R\bstreamId\x12$\n" +
-	"\x0esender_peer_id\x18\x02 \x01(\fR\fsenderPeerId\"4\n" +
+	"\x0esender_peer_id\x18\x02 \x01(\fR\fsenderPeerId\x12V\n" +
+	"\rtrace_context\x18\x03 \x03(\v21.flymesh.relay.HandshakeRequest.TraceContextEntryR\ftraceContext\x12,\n" +
+	"\x05hello\x18\x04 \x01(\v2\x16.flymesh.control.HelloR\x05hello\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xc8\x01\n" +
 	"\fHandshakeAck\x12\x0e\n" +
 	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05errorB5Z3github.com/flymesh/core/pkg/pb/relay-server;relaypbb\x06proto3"
+	"\x05error\x18\x02 \x01(\tR\x05error\x12,\n" +
+	"\x05hello\x18\x03 \x01(\v2\x16.flymesh.control.HelloR\x05hello\x129\n" +
+	"\n" +
+	"error_code\x18\x04 \x01(\x0e2\x1a.flymesh.control.ErrorCodeR\terrorCode\x12)\n" +
+	"\x10observed_address\x18\x05 \x01(\tR\x0fobservedAddress\"K\n" +
+	"\x05Probe\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\x04R\x05nonce\x12,\n" +
+	"\x05hello\x18\x02 \x01(\v2\x16.flymesh.control.HelloR\x05hello\"\xc6\x01\n" +
+	"\n" +
+	"ProbeReply\x12\x14\n" +
+	"\x05nonce\x18\x01 \x01(\x04R\x05nonce\x12,\n" +
+	"\x05hello\x18\x02 \x01(\v2\x16.flymesh.control.HelloR\x05hello\x12 \n" +
+	"\vallocations\x18\x03 \x01(\rR\vallocations\x12'\n" +
+	"\x0fmax_allocations\x18\x04 \x01(\rR\x0emaxAllocations\x12)\n" +
+	"\x10observed_address\x18\x05 \x01(\tR\x0fobservedAddressB5Z3github.com/flymesh/core/pkg/pb/relay-server;relaypbb\x06proto3"
 
 var (
 	file_relay_proto_rawDescOnce sync.Once
@@ -152,17 +352,28 @@ func file_relay_proto_rawDescGZIP() []byte {
 	return file_relay_proto_rawDescData
 }
 
-var file_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
 var file_relay_proto_goTypes = []any{
 	(*HandshakeRequest)(nil), // 0: flymesh.relay.HandshakeRequest
 	(*HandshakeAck)(nil),     // 1: flymesh.relay.HandshakeAck
+	(*Probe)(nil),            // 2: flymesh.relay.Probe
+	(*ProbeReply)(nil),       // 3: flymesh.relay.ProbeReply
+	nil,                      // 4: flymesh.relay.HandshakeRequest.TraceContextEntry
+	(*control.Hello)(nil),    // 5: flymesh.control.Hello
+	(control.ErrorCode)(0),   // 6: flymesh.control.ErrorCode
 }
 var file_relay_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4, // 0: flymesh.relay.HandshakeRequest.trace_context:type_name -> flymesh.relay.HandshakeRequest.TraceContextEntry
+	5, // 1: flymesh.relay.HandshakeRequest.hello:type_name -> flymesh.control.Hello
+	5, // 2: flymesh.relay.HandshakeAck.hello:type_name -> flymesh.control.Hello
+	6, // 3: flymesh.relay.HandshakeAck.error_code:type_name -> flymesh.control.ErrorCode
+	5, // 4: flymesh.relay.Probe.hello:type_name -> flymesh.control.Hello
+	5, // 5: flymesh.relay.ProbeReply.hello:type_name -> flymesh.control.Hello
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
 }
 
 func init() { file_relay_proto_init() }
@@ -176,7 +387,7 @@ func file_relay_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_relay_proto_rawDesc), len(file_relay_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   5,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
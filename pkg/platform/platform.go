@@ -0,0 +1,75 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Feature is an OS-specific subsystem that is only available on some platforms.
+type Feature string
+
+const (
+	// FeatureTUN is a layer-3 TUN device for VPN modes.
+	FeatureTUN Feature = "tun"
+	// FeatureSplice is zero-copy socket-to-socket forwarding.
+	FeatureSplice Feature = "splice"
+	// FeatureTPROXY is transparent proxying of redirected connections.
+	FeatureTPROXY Feature = "tproxy"
+	// FeatureSystemd is sd_notify readiness and watchdog integration.
+	FeatureSystemd Feature = "systemd"
+)
+
+// ErrUnsupported is returned (wrapped) by Require when a feature is not available.
+var ErrUnsupported = errors.New("feature not supported on this platform")
+
+// Capability reports whether a feature is available, and why not if it is not.
+type Capability struct {
+	Feature   Feature
+	Available bool
+	Reason    string
+}
+
+var (
+	detectOnce   sync.Once
+	capabilities map[Feature]Capability
+)
+
+// Capabilities returns the detected availability of every known feature.
+// Detection runs once per process.
+func Capabilities() []Capability {
+	detectOnce.Do(func() {
+		capabilities = make(map[Feature]Capability)
+		for _, c := range detect() {
+			capabilities[c.Feature] = c
+		}
+	})
+	out := make([]Capability, 0, len(capabilities))
+	for _, f := range []Feature{FeatureTUN, FeatureSplice, FeatureTPROXY, FeatureSystemd} {
+		out = append(out, lookup(f))
+	}
+	return out
+}
+
+// Require returns nil if f is available, or an error wrapping ErrUnsupported that
+// names the platform and the reason.
+func Require(f Feature) error {
+	Capabilities()
+	c := lookup(f)
+	if c.Available {
+		return nil
+	}
+	return fmt.Errorf("%s on %s/%s: %s: %w", f, runtime.GOOS, runtime.GOARCH, c.Reason, ErrUnsupported)
+}
+
+func lookup(f Feature) Capability {
+	c, ok := capabilities[f]
+	if !ok {
+		return Capability{Feature: f, Reason: "not implemented for this platform"}
+	}
+	return c
+}
@@ -0,0 +1,29 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build linux
+
+package platform
+
+import (
+	"os"
+)
+
+func detect() []Capability {
+	tun := Capability{Feature: FeatureTUN, Available: true}
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		tun = Capability{Feature: FeatureTUN, Reason: "/dev/net/tun is not accessible: " + err.Error()}
+	}
+
+	systemd := Capability{Feature: FeatureSystemd, Available: true}
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		systemd = Capability{Feature: FeatureSystemd, Reason: "not started by systemd (NOTIFY_SOCKET unset)"}
+	}
+
+	return []Capability{
+		tun,
+		{Feature: FeatureSplice, Available: true},
+		{Feature: FeatureTPROXY, Available: true},
+		systemd,
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build !linux
+
+package platform
+
+import (
+	"os"
+	"runtime"
+)
+
+func detect() []Capability {
+	tun := Capability{Feature: FeatureTUN, Reason: "no TUN driver support"}
+	switch runtime.GOOS {
+	case "darwin":
+		// utun devices are created on demand by the kernel.
+		tun = Capability{Feature: FeatureTUN, Available: true}
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		if _, err := os.Stat("/dev/tun"); err == nil {
+			tun = Capability{Feature: FeatureTUN, Available: true}
+		} else {
+			tun.Reason = "/dev/tun is not accessible: " + err.Error()
+		}
+	case "windows":
+		tun.Reason = "requires the wintun driver, which is not bundled"
+	}
+
+	return []Capability{
+		tun,
+		{Feature: FeatureSplice, Reason: "splice(2) is Linux-only"},
+		{Feature: FeatureTPROXY, Reason: "IP_TRANSPARENT is Linux-only"},
+		{Feature: FeatureSystemd, Reason: "systemd is Linux-only"},
+	}
+}
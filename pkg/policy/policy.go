@@ -0,0 +1,146 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package policy evaluates admission policies written as Starlark expressions
+// over the attributes of a request, so that operators express complex rules
+// without recompiling. For example:
+//
+//	peer in ["12D3KooW..."] or (service == "http" and 8 <= hour < 18)
+//
+// Every expression also sees the time of the request as unix, hour (0-23) and
+// weekday (0 for Sunday), in UTC.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// ErrDenied is returned by Check when the expression evaluates to false.
+var ErrDenied = errors.New("denied by policy")
+
+// maxSteps bounds the evaluation of an expression.
+const maxSteps = 100000
+
+// Attributes of the time of the request, defined for every policy.
+const (
+	AttrUnix    = "unix"
+	AttrHour    = "hour"
+	AttrWeekday = "weekday"
+)
+
+var fileOptions = &syntax.FileOptions{Set: true}
+
+// Attrs are the attributes of a request. Values are strings, integers, bools
+// or string slices.
+type Attrs map[string]any
+
+// Policy is a compiled admission expression.
+type Policy struct {
+	src   string
+	names []string
+}
+
+// Compile parses src, an expression using the attributes in names besides the
+// time attributes.
+func Compile(src string, names ...string) (*Policy, error) {
+	names = append(slices.Clone(names), AttrUnix, AttrHour, AttrWeekday)
+	expr, err := fileOptions.ParseExpr("policy", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	isPredeclared := func(name string) bool { return slices.Contains(names, name) }
+	if _, err := resolve.ExprOptions(fileOptions, expr, isPredeclared, starlark.Universe.Has); err != nil {
+		return nil, err
+	}
+	return &Policy{src: src, names: names}, nil
+}
+
+// String returns the source of p.
+func (p *Policy) String() string {
+	return p.src
+}
+
+// Allow evaluates p over attrs at now. Attributes p was compiled with but
+// missing from attrs are None.
+func (p *Policy) Allow(attrs Attrs, now time.Time) (bool, error) {
+	env := make(starlark.StringDict, len(p.names))
+	for _, name := range p.names {
+		env[name] = starlark.None
+	}
+	for name, v := range attrs {
+		sv, err := toValue(v)
+		if err != nil {
+			return false, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		env[name] = sv
+	}
+	now = now.UTC()
+	env[AttrUnix] = starlark.MakeInt64(now.Unix())
+	env[AttrHour] = starlark.MakeInt(now.Hour())
+	env[AttrWeekday] = starlark.MakeInt(int(now.Weekday()))
+
+	// Evaluation resolves the expression in place, so it is parsed anew.
+	expr, err := fileOptions.ParseExpr("policy", p.src, 0)
+	if err != nil {
+		return false, err
+	}
+	thread := &starlark.Thread{Name: "policy"}
+	thread.SetMaxExecutionSteps(maxSteps)
+	v, err := starlark.EvalExprOptions(fileOptions, thread, expr, env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(starlark.Bool)
+	if !ok {
+		return false, fmt.Errorf("policy evaluated to %s, not bool", v.Type())
+	}
+	return bool(b), nil
+}
+
+// Check is Allow returning ErrDenied, or the evaluation error, unless p
+// allows the request. A nil Policy allows everything.
+func (p *Policy) Check(attrs Attrs, now time.Time) error {
+	if p == nil {
+		return nil
+	}
+	ok, err := p.Allow(attrs, now)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDenied, err)
+	}
+	if !ok {
+		return ErrDenied
+	}
+	return nil
+}
+
+func toValue(v any) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case string:
+		return starlark.String(v), nil
+	case bool:
+		return starlark.Bool(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case []string:
+		l := make([]starlark.Value, len(v))
+		for i, s := range v {
+			l[i] = starlark.String(s)
+		}
+		return starlark.NewList(l), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
@@ -8,4 +8,18 @@ const (
 	ProtoRelayCreate = "/flymesh/1.0/relay-server/create-stream"
 	// For client to ask server to start a relay-server stream
 	ProtoServerStartRelay = "/flymesh/1.0/server/start-relay-server-stream"
+	// For client to carry a stream to server over a direct libp2p connection
+	ProtoServerDirect = "/flymesh/1.0/server/direct-stream"
+	// For server to register with relay-server for the stream requests clients
+	// send through it
+	ProtoRelayNotify = "/flymesh/1.0/relay-server/notify"
+	// For relay-server to notify server of the fate of its allocations
+	ProtoServerNotify = "/flymesh/1.0/server/notify"
+	// For relay-server to offer a peer an allocation another peer requested
+	ProtoPeerOffer = "/flymesh/1.0/peer/stream-offer"
+	// For relay-server to verify that a peer controls its identity
+	ProtoDialBack = "/flymesh/1.0/dial-back"
+	// For peers to exchange Hello messages. Supporting it tells other peers that
+	// control exchanges may start with a Hello.
+	ProtoInfo = "/flymesh/1.0/info"
 )
@@ -0,0 +1,240 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package proxyproto reads HAProxy PROXY protocol v1 and v2 headers so that a
+// listener behind an L4 load balancer sees the original client address.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("malformed PROXY header")
+	ErrNoHeader  = errors.New("missing PROXY header")
+	ErrUntrusted = errors.New("PROXY header from untrusted address")
+)
+
+var (
+	v1Prefix    = []byte("PROXY")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	v1MaxLength   = 107
+	v2HeaderBytes = 16
+)
+
+// Conn is a connection whose RemoteAddr and LocalAddr are the addresses carried
+// in its PROXY header. ProxyAddr is the address of the load balancer itself.
+type Conn struct {
+	net.Conn
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// ProxyAddr returns the address of the proxy that sent the header.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// Trusted reports whether addr is in one of prefixes. An empty prefix list trusts
+// every address.
+func Trusted(addr net.Addr, prefixes []netip.Prefix) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrefixes parses a list of CIDR prefixes or bare IP addresses.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			a, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// ReadHeader reads a v1 or v2 PROXY header from c within timeout and returns a
+// connection reporting the addresses it carries. Headers announcing a LOCAL
+// connection (health checks) or an unknown protocol keep the original addresses.
+// No bytes following the header are consumed.
+func ReadHeader(c net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(timeout))
+		defer c.SetReadDeadline(time.Time{})
+	}
+
+	head := make([]byte, len(v1Prefix))
+	if _, err := io.ReadFull(c, head); err != nil {
+		return nil, err
+	}
+	var (
+		remote, local net.Addr
+		err           error
+	)
+	switch {
+	case bytes.Equal(head, v1Prefix):
+		remote, local, err = readV1(c)
+	case bytes.Equal(head, v2Signature[:len(head)]):
+		remote, local, err = readV2(c, head)
+	default:
+		return nil, ErrNoHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return c, nil
+	}
+	return &Conn{Conn: c, remote: remote, local: local}, nil
+}
+
+// readV1 parses the rest of "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(c net.Conn) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, v1MaxLength)
+	line = append(line, v1Prefix...)
+	var b [1]byte
+	for {
+		if len(line) >= v1MaxLength {
+			return nil, nil, fmt.Errorf("%w: v1 header too long", ErrMalformed)
+		}
+		if _, err := io.ReadFull(c, b[:]); err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b[0])
+		if b[0] == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: v1 header not terminated by CRLF", ErrMalformed)
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) < 2 {
+		return nil, nil, fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown v1 protocol %q", ErrMalformed, fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	remote, err := v1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := v1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return remote, local, nil
+}
+
+func v1Addr(ip string, port string) (net.Addr, error) {
+	a, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(a, uint16(p))), nil
+}
+
+// readV2 parses the rest of a binary v2 header whose first bytes are head.
+func readV2(c net.Conn, head []byte) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, v2HeaderBytes)
+	copy(hdr, head)
+	if _, err := io.ReadFull(c, hdr[len(head):]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:len(v2Signature)], v2Signature) {
+		return nil, nil, ErrNoHeader
+	}
+	verCmd, family := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrMalformed, verCmd>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(c, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown v2 command %d", ErrMalformed, verCmd&0x0f)
+	}
+
+	var ipLen int
+	switch family {
+	case 0x11, 0x12: // TCP or UDP over IPv4
+		ipLen = 4
+	case 0x21, 0x22: // TCP or UDP over IPv6
+		ipLen = 16
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: v2 address block too short", ErrMalformed)
+	}
+	src, _ := netip.AddrFromSlice(payload[:ipLen])
+	dst, _ := netip.AddrFromSlice(payload[ipLen : 2*ipLen])
+	sport := binary.BigEndian.Uint16(payload[2*ipLen:])
+	dport := binary.BigEndian.Uint16(payload[2*ipLen+2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, sport)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dport)), nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bufConn is a net.Conn reading from a buffer, from the address of a proxy.
+type bufConn struct {
+	net.Conn
+	r *bytes.Reader
+}
+
+var (
+	proxyAddr = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 40000}
+	localAddr = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 443}
+)
+
+func (c *bufConn) Read(b []byte) (int, error)      { return c.r.Read(b) }
+func (c *bufConn) RemoteAddr() net.Addr            { return proxyAddr }
+func (c *bufConn) LocalAddr() net.Addr             { return localAddr }
+func (c *bufConn) SetReadDeadline(time.Time) error { return nil }
+
+// v2 returns a v2 header with the command and family bytes, and payload.
+func v2(verCmd byte, family byte, payload []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, verCmd, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// v2Addrs returns the address block of src and dst, of the same family.
+func v2Addrs(src, dst string) []byte {
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	b := append(s.Addr().AsSlice(), d.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, s.Port())
+	return binary.BigEndian.AppendUint16(b, d.Port())
+}
+
+func TestReadHeader(t *testing.T) {
+	tlv := []byte{0x04, 0x00, 0x03, 'a', 'b', 'c'} // PP2_TYPE_NOOP
+	tests := []struct {
+		name   string
+		header []byte
+		remote string // empty when the addresses of the proxy are kept
+		local  string
+		err    error
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), remote: "192.0.2.1:56324", local: "198.51.100.1:443"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 UNKNOWN with addresses", header: []byte("PROXY UNKNOWN ffff:f::1 ffff:f::2 1 2\r\n")},
+		{name: "v1 longest", header: []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"),
+			remote: "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535", local: "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535"},
+		{name: "v1 too long", header: []byte("PROXY TCP6 " + strings.Repeat("f", 100) + "\r\n"), err: ErrMalformed},
+		{name: "v1 without CRLF", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 2\n"), err: ErrMalformed},
+		{name: "v1 unknown protocol", header: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n"), err: ErrMalformed},
+		{name: "v1 missing port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1\r\n"), err: ErrMalformed},
+		{name: "v1 bad address", header: []byte("PROXY TCP4 192.0.2.300 198.51.100.1 1 2\r\n"), err: ErrMalformed},
+		{name: "v1 bad port", header: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 2\r\n"), err: ErrMalformed},
+		{name: "v1 no protocol", header: []byte("PROXY\r\n"), err: ErrMalformed},
+		{name: "v1 truncated", header: []byte("PROXY TCP4 192.0.2.1"), err: io.EOF},
+
+		{name: "v2 TCP4", header: v2(0x21, 0x11, v2Addrs("192.0.2.1:56324", "198.51.100.1:443")), remote: "192.0.2.1:56324", local: "198.51.100.1:443"},
+		{name: "v2 TCP6", header: v2(0x21, 0x21, v2Addrs("[2001:db8::1]:56324", "[2001:db8::2]:443")), remote: "[2001:db8::1]:56324", local: "[2001:db8::2]:443"},
+		{name: "v2 UDP4", header: v2(0x21, 0x12, v2Addrs("192.0.2.1:53", "198.51.100.1:53")), remote: "192.0.2.1:53", local: "198.51.100.1:53"},
+		{name: "v2 TLV", header: v2(0x21, 0x11, append(v2Addrs("192.0.2.1:56324", "198.51.100.1:443"), tlv...)), remote: "192.0.2.1:56324", local: "198.51.100.1:443"},
+		{name: "v2 LOCAL", header: v2(0x20, 0x00, nil)},
+		{name: "v2 LOCAL with addresses and TLV", header: v2(0x20, 0x11, append(v2Addrs("192.0.2.1:1", "198.51.100.1:2"), tlv...))},
+		{name: "v2 AF_UNSPEC", header: v2(0x21, 0x00, nil)},
+		{name: "v2 AF_UNIX", header: v2(0x21, 0x31, make([]byte, 216))},
+		{name: "v2 bad version", header: v2(0x11, 0x11, v2Addrs("192.0.2.1:1", "198.51.100.1:2")), err: ErrMalformed},
+		{name: "v2 bad command", header: v2(0x22, 0x11, v2Addrs("192.0.2.1:1", "198.51.100.1:2")), err: ErrMalformed},
+		{name: "v2 short address block", header: v2(0x21, 0x21, v2Addrs("192.0.2.1:1", "198.51.100.1:2")), err: ErrMalformed},
+		{name: "v2 bad signature", header: append([]byte("\r\n\r\n\x00\r\nQUIT!"), v2(0x21, 0x11, nil)[12:]...), err: ErrNoHeader},
+		{name: "v2 truncated header", header: v2(0x21, 0x11, nil)[:14], err: io.ErrUnexpectedEOF},
+		{name: "v2 truncated payload", header: v2(0x21, 0x11, v2Addrs("192.0.2.1:1", "198.51.100.1:2"))[:20], err: io.ErrUnexpectedEOF},
+		{name: "v2 length past the data", header: func() []byte {
+			b := v2(0x21, 0x11, v2Addrs("192.0.2.1:1", "198.51.100.1:2"))
+			binary.BigEndian.PutUint16(b[14:16], 0xFFFF)
+			return b
+		}(), err: io.ErrUnexpectedEOF},
+
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), err: ErrNoHeader},
+		{name: "relay frame", header: []byte("FLYR\x00\x00\x01\x01"), err: ErrNoHeader},
+		{name: "empty", header: nil, err: io.EOF},
+	}
+	trailer := []byte("FLYR after the header")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &bufConn{r: bytes.NewReader(append(bytes.Clone(tt.header), trailer...))}
+			if tt.err != nil {
+				// Errors that depend on missing bytes need the stream to end.
+				in.r = bytes.NewReader(tt.header)
+			}
+			c, err := ReadHeader(in, time.Second)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ReadHeader() err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadHeader() err = %v", err)
+			}
+			wantRemote, wantLocal := proxyAddr.String(), localAddr.String()
+			if tt.remote != "" {
+				wantRemote, wantLocal = tt.remote, tt.local
+			}
+			if got := c.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, wantRemote)
+			}
+			if got := c.LocalAddr().String(); got != wantLocal {
+				t.Errorf("LocalAddr() = %s, want %s", got, wantLocal)
+			}
+			if pc, ok := c.(*Conn); ok && pc.ProxyAddr() != proxyAddr {
+				t.Errorf("ProxyAddr() = %s, want %s", pc.ProxyAddr(), proxyAddr)
+			}
+			rest, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rest, trailer) {
+				t.Errorf("bytes after the header = %q, want %q", rest, trailer)
+			}
+		})
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	prefixes, err := ParsePrefixes([]string{"10.0.0.0/8", " 2001:db8::1 ", "", "::ffff:192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3:1", true},
+		{"[::ffff:10.1.2.3]:1", true},
+		{"11.0.0.1:1", false},
+		{"[2001:db8::1]:1", true},
+		{"[2001:db8::2]:1", false},
+		{"192.0.2.7:1", true},
+	}
+	for _, tt := range tests {
+		addr := net.TCPAddrFromAddrPort(netip.MustParseAddrPort(tt.addr))
+		if got := Trusted(addr, prefixes); got != tt.want {
+			t.Errorf("Trusted(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+	if !Trusted(proxyAddr, nil) {
+		t.Error("Trusted() with no prefixes = false")
+	}
+	for _, bad := range []string{"10.0.0.0/33", "not an address"} {
+		if _, err := ParsePrefixes([]string{bad}); err == nil {
+			t.Errorf("ParsePrefixes(%q) err = nil", bad)
+		}
+	}
+}
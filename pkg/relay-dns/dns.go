@@ -0,0 +1,186 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package relay_dns answers DNS queries for the hostname of a relay fleet with
+// the address of its least loaded relay-server, as announced on the mesh, so
+// that peers spread over the fleet without a load balancer in front of it.
+package relay_dns
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/mesh"
+	"github.com/miekg/dns"
+)
+
+// DefaultTTL is the TTL of the answers when Responder.TTL is zero. It is short
+// so that resolvers follow the load.
+const DefaultTTL = 5 * time.Second
+
+// Responder is an authoritative DNS server for Name. A and AAAA queries are
+// answered with the address of the relay-server, among the node itself and
+// the relay-server members of Presence, that holds the fewest allocations
+// without being full. Relays whose endpoint is not an IP address are skipped.
+//
+// DNS carries no port, so the relays of a fleet must share the relay port.
+type Responder struct {
+	// Name is the hostname answered, e.g. "relay.example.com".
+	Name string
+	// Presence is the mesh the load of the other relays is gossiped on.
+	Presence *mesh.Presence
+	// TTL of the answers. Defaults to DefaultTTL.
+	TTL time.Duration
+	// IPv6Only listens on IPv6 only.
+	IPv6Only bool
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	servers []*dns.Server
+	wg      sync.WaitGroup
+}
+
+func (r *Responder) logger() *slog.Logger {
+	return logging.Component(r.Logger, "relay-dns")
+}
+
+// Start serves DNS over UDP and TCP on address.
+func (r *Responder) Start(address string) error {
+	if r.Name == "" {
+		return errors.New("empty relay DNS name")
+	}
+	udp, tcp := "udp", "tcp"
+	if r.IPv6Only {
+		udp, tcp = "udp6", "tcp6"
+	}
+	pc, err := net.ListenPacket(udp, address)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen(tcp, address)
+	if err != nil {
+		_ = pc.Close()
+		return err
+	}
+	mux := dns.NewServeMux()
+	mux.Handle(dns.Fqdn(r.Name), r)
+	mux.HandleFunc(".", refuse)
+	r.servers = []*dns.Server{
+		{PacketConn: pc, Handler: mux},
+		{Listener: ln, Handler: mux},
+	}
+	for _, s := range r.servers {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			if err := s.ActivateAndServe(); err != nil {
+				r.logger().Warn("dns server stopped", "err", err)
+			}
+		}()
+	}
+	r.logger().Info("listening", "addr", address, "name", r.Name)
+	return nil
+}
+
+// Stop stops serving.
+func (r *Responder) Stop() {
+	for _, s := range r.servers {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = s.ShutdownContext(ctx)
+		cancel()
+	}
+	r.wg.Wait()
+}
+
+func (r *Responder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	if len(req.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+	} else if !strings.EqualFold(req.Question[0].Name, dns.Fqdn(r.Name)) {
+		// A subdomain of Name.
+		resp.Rcode = dns.RcodeNameError
+	} else if rr := r.answer(req.Question[0]); rr != nil {
+		resp.Answer = append(resp.Answer, rr)
+	}
+	_ = w.WriteMsg(resp)
+}
+
+func (r *Responder) answer(q dns.Question) dns.RR {
+	if q.Qclass != dns.ClassINET || (q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA) {
+		return nil
+	}
+	addr, ok := r.pick(q.Qtype == dns.TypeAAAA)
+	if !ok {
+		return nil
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: r.ttl()}
+	if q.Qtype == dns.TypeAAAA {
+		return &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()}
+	}
+	return &dns.A{Hdr: hdr, A: addr.AsSlice()}
+}
+
+func (r *Responder) ttl() uint32 {
+	if r.TTL <= 0 {
+		return uint32(DefaultTTL / time.Second)
+	}
+	return uint32(r.TTL / time.Second)
+}
+
+// pick returns the address of the least loaded relay of the family asked.
+func (r *Responder) pick(ipv6 bool) (netip.Addr, bool) {
+	type candidate struct {
+		addr netip.Addr
+		load *mesh.Load
+	}
+	var cands []candidate
+	add := func(l *mesh.Load) {
+		if l == nil || l.Full() {
+			return
+		}
+		host, _, err := net.SplitHostPort(l.RelayEndpoint)
+		if err != nil {
+			return
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || addr.Unmap().Is4() == ipv6 {
+			return
+		}
+		cands = append(cands, candidate{addr: addr.Unmap(), load: l})
+	}
+	if r.Presence.Load != nil {
+		add(r.Presence.Load())
+	}
+	for _, m := range r.Presence.Members() {
+		add(m.Load)
+	}
+	if len(cands) == 0 {
+		return netip.Addr{}, false
+	}
+	// Loads are only as fresh as the last announcements: ties are broken at
+	// random so that the relays equally loaded then share the queries.
+	least := slices.MinFunc(cands, func(a, b candidate) int {
+		return a.load.Allocations - b.load.Allocations
+	}).load.Allocations
+	cands = slices.DeleteFunc(cands, func(c candidate) bool {
+		return c.load.Allocations > least
+	})
+	return cands[rand.IntN(len(cands))].addr, true
+}
+
+func refuse(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetRcode(req, dns.RcodeRefused)
+	_ = w.WriteMsg(resp)
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// Reasons an allocation ended, as written to AccessLog.
+const (
+	CloseClient     = "client closed"
+	CloseServer     = "server closed"
+	CloseError      = "bridge error"
+	CloseQuota      = "quota spent"
+	CloseDeadline   = "deadline reached"
+	CloseReady      = "ready failed"
+	CloseChaos      = "chaos"
+	CloseExpired    = "expired"
+	CloseSuperseded = "superseded"
+	CloseShutdown   = "shutdown"
+)
+
+// accessLogEntry is the AccessLog line of an allocation, written when it ends.
+type accessLogEntry struct {
+	// Time is when the bridge started, or when the allocation was dropped if
+	// it never got bridged.
+	Time                time.Time `json:"time"`
+	Created             time.Time `json:"created"`
+	StreamID            uint64    `json:"stream_id"`
+	ServerPeerID        string    `json:"server_peer"`
+	ClientPeerID        string    `json:"client_peer"`
+	ServerAddr          string    `json:"server_addr,omitempty"`
+	ClientAddr          string    `json:"client_addr,omitempty"`
+	Bridged             bool      `json:"bridged"`
+	BytesClientToServer int64     `json:"bytes_c2s"`
+	BytesServerToClient int64     `json:"bytes_s2c"`
+	DurationMillis      int64     `json:"duration_ms"`
+	CloseReason         string    `json:"close_reason"`
+}
+
+// closeWith closes a, recording reason unless one is already recorded.
+func (a *allocation) closeWith(reason string) {
+	a.mu.Lock()
+	if a.closeReason == "" {
+		a.closeReason = reason
+	}
+	a.mu.Unlock()
+	_ = a.Close()
+}
+
+// droppedEntry returns the AccessLog line of a, dropped at now without a
+// bridge.
+func (m *RelayManager) droppedEntry(a *allocation, now time.Time, reason string) *accessLogEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &accessLogEntry{
+		Time:           now.UTC(),
+		Created:        a.created.UTC(),
+		StreamID:       a.streamID,
+		ServerPeerID:   m.Redaction.Peer(a.serverPeerID.String()),
+		ClientPeerID:   m.Redaction.Peer(a.clientPeerID.String()),
+		ServerAddr:     m.remoteAddr(a.sideS),
+		ClientAddr:     m.remoteAddr(a.sideC),
+		DurationMillis: now.Sub(a.created).Milliseconds(),
+		CloseReason:    reason,
+	}
+}
+
+// remoteAddr returns the redacted remote address of c, empty if c is nil.
+func (m *RelayManager) remoteAddr(c net.Conn) string {
+	if c == nil {
+		return ""
+	}
+	return m.Redaction.Addr(c.RemoteAddr().String())
+}
+
+func (m *RelayManager) writeAccessLog(entries ...*accessLogEntry) {
+	if m.AccessLog == nil {
+		return
+	}
+	m.accessMu.Lock()
+	defer m.accessMu.Unlock()
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		line = append(line, '\n')
+		if _, err := m.AccessLog.Write(line); err != nil {
+			m.logger().Warn("write access log failed", "err", err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAccessLogDropped(t *testing.T) {
+	server := mustDecodePeer(t, "12D3KooWLRPJAA5o6zfDNcEnJUWHA8FYhG9GLExsqDhVdxPA5ZUE")
+	client := mustDecodePeer(t, "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo")
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		drop   func(m *RelayManager, a StreamAllocation)
+		reason string
+	}{
+		{
+			name:   "expired",
+			ttl:    time.Nanosecond,
+			drop:   func(m *RelayManager, a StreamAllocation) { m.gc() },
+			reason: CloseExpired,
+		},
+		{
+			name: "superseded",
+			ttl:  time.Minute,
+			drop: func(m *RelayManager, a StreamAllocation) {
+				if _, ok := m.Supersede(server, client, a.RetryCookie); !ok {
+					t.Fatal("Supersede() = false")
+				}
+			},
+			reason: CloseSuperseded,
+		},
+		{
+			name: "shutdown",
+			ttl:  time.Minute,
+			drop: func(m *RelayManager, a StreamAllocation) {
+				_ = m.Shutdown(context.Background())
+			},
+			reason: CloseShutdown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log bytes.Buffer
+			m := New()
+			m.AccessLog = &log
+			a, _, err := m.CreateStream(server, client, tt.ttl, Quota{})
+			if err != nil {
+				t.Fatalf("CreateStream() err = %v", err)
+			}
+			time.Sleep(time.Millisecond)
+			tt.drop(m, a)
+
+			var e accessLogEntry
+			if err := json.Unmarshal(log.Bytes(), &e); err != nil {
+				t.Fatalf("access log %q: %v", log.String(), err)
+			}
+			if e.StreamID != a.StreamID || e.ServerPeerID != server.String() || e.ClientPeerID != client.String() {
+				t.Fatalf("entry %+v", e)
+			}
+			if e.Bridged || e.CloseReason != tt.reason {
+				t.Fatalf("bridged = %v, close reason %q, want %q", e.Bridged, e.CloseReason, tt.reason)
+			}
+			if e.Created.IsZero() || e.Time.Before(e.Created) {
+				t.Fatalf("created %s, time %s", e.Created, e.Time)
+			}
+		})
+	}
+}
+
+func TestCloseWithKeepsFirstReason(t *testing.T) {
+	a := &allocation{}
+	a.closeWith(CloseQuota)
+	a.closeWith(CloseClient)
+	if a.closeReason != CloseQuota {
+		t.Fatalf("close reason %q, want %q", a.closeReason, CloseQuota)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errChaosHandshakeDropped = errors.New("chaos: handshake dropped")
+	errChaosBridgeKilled     = errors.New("chaos: bridge killed")
+)
+
+// Chaos injects deterministic faults into the relay so that client resilience
+// paths can be tested. It is meant for development relays only. The zero value
+// injects nothing.
+type Chaos struct {
+	// KillBridgeAfter closes a bridge once this many bytes crossed it, counting
+	// both directions. 0 disables.
+	KillBridgeAfter int64
+	// AckDelay delays every successful HandshakeAck.
+	AckDelay time.Duration
+	// DropHandshakeEvery closes every k-th relay connection right after its
+	// HandshakeRequest without acking it. 0 disables.
+	DropHandshakeEvery int
+
+	handshakes atomic.Uint64
+}
+
+// Enabled reports whether c injects any fault.
+func (c *Chaos) Enabled() bool {
+	return c != nil && (c.KillBridgeAfter > 0 || c.AckDelay > 0 || c.DropHandshakeEvery > 0)
+}
+
+// dropHandshake counts a handshake and reports whether it must be dropped.
+func (c *Chaos) dropHandshake() bool {
+	if c == nil || c.DropHandshakeEvery <= 0 {
+		return false
+	}
+	return c.handshakes.Add(1)%uint64(c.DropHandshakeEvery) == 0
+}
+
+// delayAck waits AckDelay or until ctx is done.
+func (c *Chaos) delayAck(ctx context.Context) {
+	if c == nil || c.AckDelay <= 0 {
+		return
+	}
+	t := time.NewTimer(c.AckDelay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// bridgeWriters returns the writers both directions of a bridge copy into. With
+// KillBridgeAfter set they share a byte budget and call kill when it runs out.
+func (c *Chaos) bridgeWriters(toServer io.Writer, toClient io.Writer, kill func()) (io.Writer, io.Writer) {
+	if c == nil || c.KillBridgeAfter <= 0 {
+		return toServer, toClient
+	}
+	return newBudget(c.KillBridgeAfter, errChaosBridgeKilled, kill).writers(toServer, toClient)
+}
+
+// budget is a byte budget shared by the writers of a bridge. When it runs out,
+// kill is called and the writes fail with err.
+type budget struct {
+	left atomic.Int64
+	once sync.Once
+	kill func()
+	err  error
+}
+
+func newBudget(n int64, err error, kill func()) *budget {
+	b := &budget{kill: kill, err: err}
+	b.left.Store(n)
+	return b
+}
+
+func (b *budget) writers(toServer io.Writer, toClient io.Writer) (io.Writer, io.Writer) {
+	return &budgetWriter{w: toServer, b: b}, &budgetWriter{w: toClient, b: b}
+}
+
+// budgetWriter passes writes through until the shared budget is spent.
+type budgetWriter struct {
+	w io.Writer
+	b *budget
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	left := w.b.left.Add(-n) + n
+	if left >= n {
+		return w.w.Write(p)
+	}
+	written := 0
+	if left > 0 {
+		written, _ = w.w.Write(p[:left])
+	}
+	w.b.once.Do(w.b.kill)
+	return written, w.b.err
+}
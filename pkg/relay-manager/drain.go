@@ -0,0 +1,114 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrDraining is reported by CheckDraining while the relay drains.
+var ErrDraining = errors.New("relay is draining")
+
+// drainPollInterval is how often WaitDrained looks at the allocations left.
+var drainPollInterval = time.Second
+
+// listen listens on the TCP address, with SO_REUSEPORT if ReusePort is set.
+func (m *RelayManager) listen(address string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if m.ReusePort {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), m.network(), address)
+}
+
+// Drain refuses new allocations, as Shutdown does, but lets those in place run
+// to their end: the allocations waiting for their peers keep accepting their
+// relay connections until bridged or expired, and bridges are left alone.
+//
+// With ReusePort, Drain hands the listeners over instead, for the next process
+// of an upgrade listening on the same addresses: it closes them, so that the
+// next process accepts every relay connection that follows, and drops the
+// allocations not yet bridged, whose peers then retry with it. StateFile is
+// left to the next process too.
+//
+// It returns the number of allocations left and how many of them are bridged.
+// Draining again only reports them.
+func (m *RelayManager) Drain() (allocations int, bridges int) {
+	if m.draining.Swap(true) {
+		return m.Load()
+	}
+	if m.ReusePort {
+		m.handOff()
+	}
+	allocations, bridges = m.Load()
+	m.logger().Info("draining", "allocations", allocations, "bridges", bridges, "handed_off", m.ReusePort)
+	return allocations, bridges
+}
+
+// Draining reports whether m refuses new allocations, after Drain or Shutdown.
+func (m *RelayManager) Draining() bool {
+	return m.draining.Load()
+}
+
+// CheckDraining reports ErrDraining once m refuses new allocations, so that a
+// readiness check takes the relay-server out of rotation.
+func (m *RelayManager) CheckDraining(ctx context.Context) error {
+	if m.draining.Load() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// WaitDrained waits until m drains and no allocation is left, or ctx is done.
+func (m *RelayManager) WaitDrained(ctx context.Context) error {
+	t := time.NewTicker(drainPollInterval)
+	defer t.Stop()
+	for {
+		if m.draining.Load() {
+			if allocations, _ := m.Load(); allocations == 0 {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// handOff closes the listeners and drops the allocations not yet bridged.
+func (m *RelayManager) handOff() {
+	m.handedOff.Store(true)
+	for _, ln := range m.listeners {
+		// The next process replaced the socket file: leave it in place.
+		if uln, ok := ln.(*net.UnixListener); ok {
+			uln.SetUnlinkOnClose(false)
+		}
+	}
+	m.closeListeners()
+	m.writeAccessLog(m.dropUnbridged(time.Now())...)
+}
+
+// dropUnbridged closes and removes the allocations not bridged, and returns
+// their AccessLog entries.
+func (m *RelayManager) dropUnbridged(now time.Time) []*accessLogEntry {
+	var dropped []*accessLogEntry
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, a := range m.allocations {
+		a.mu.Lock()
+		bridged := a.sideS != nil && a.sideC != nil
+		a.mu.Unlock()
+		if !bridged {
+			dropped = append(dropped, m.droppedEntry(a, now, CloseShutdown))
+			_ = a.Close()
+			delete(m.allocations, id)
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	relaypb "github.com/flymesh/core/pkg/pb/relay"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// probe sends a probe to the relay listening on addr and reads its reply.
+func probe(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	data, _ := proto.Marshal(&relaypb.Probe{Nonce: 7})
+	if err := relay_protocol.WriteRelayFrame(conn, relay_protocol.RelayTypeProbe, relay_protocol.ProbeToken, data); err != nil {
+		return err
+	}
+	hdr, _, _, err := relay_protocol.ReadRelayFrameRaw(conn, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if hdr.Type != relay_protocol.RelayTypeProbeReply {
+		return fmt.Errorf("reply of type %d", hdr.Type)
+	}
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	defer func(d time.Duration) { drainPollInterval = d }(drainPollInterval)
+	drainPollInterval = 10 * time.Millisecond
+
+	m := New()
+	m.PublicAddress = "127.0.0.1:24002"
+	if err := m.Start(context.Background(), "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if _, _, _, err := m.allocate(peer.ID("server"), peer.ID("client"), 1, 50*time.Millisecond, Quota{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if allocations, bridges := m.Drain(); allocations != 1 || bridges != 0 {
+		t.Fatalf("Drain() = %d, %d, want 1, 0", allocations, bridges)
+	}
+	if _, _, err := m.CreateStream(peer.ID("server"), peer.ID("client"), 0, Quota{}); !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("CreateStream() while draining err = %v, want ErrShuttingDown", err)
+	}
+	if err := m.CheckDraining(context.Background()); !errors.Is(err, ErrDraining) {
+		t.Fatalf("CheckDraining() = %v, want ErrDraining", err)
+	}
+	if err := probe(m.Addr().String()); err != nil {
+		t.Fatalf("probe while draining: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.WaitDrained(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitDrained() with an allocation left = %v, want DeadlineExceeded", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	m.gc()
+	if err := m.WaitDrained(context.Background()); err != nil {
+		t.Fatalf("WaitDrained() = %v", err)
+	}
+}
+
+func TestDrainHandOff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is unix only")
+	}
+	old := New()
+	old.PublicAddress = "127.0.0.1:24002"
+	old.ReusePort = true
+	if err := old.Start(context.Background(), "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer old.Stop()
+	addr := old.Addr().String()
+	next := New()
+	next.PublicAddress = addr
+	next.ReusePort = true
+	if err := next.Start(context.Background(), addr); err != nil {
+		t.Fatalf("next process listening on %s: %v", addr, err)
+	}
+	defer next.Stop()
+	if _, _, _, err := old.allocate(peer.ID("server"), peer.ID("client"), 1, time.Minute, Quota{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if allocations, _ := old.Drain(); allocations != 0 {
+		t.Fatalf("Drain() left %d allocations, want the unbridged one dropped", allocations)
+	}
+	if err := old.CheckListener(context.Background()); err != nil {
+		t.Fatalf("CheckListener() after handing off = %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := probe(addr); err != nil {
+			t.Fatalf("probe after handing off: %v", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// relayEndpoint returns PublicAddress as handed to peers, with the tls://
+// scheme when the TCP listener requires TLS. The first address PortMapping
+// mapped replaces a PublicAddress peers cannot reach.
+func (m *RelayManager) relayEndpoint() string {
+	address := m.PublicAddress
+	if mapped := m.mappedAddresses(); len(mapped) > 0 && !isPublicAddress(address) {
+		address = mapped[0]
+	}
+	return m.tcpEndpoint(address)
+}
+
+// tcpEndpoint returns the host:port address of a TCP listener as handed to
+// peers, with the tls:// scheme when the TCP listeners require TLS.
+func (m *RelayManager) tcpEndpoint(address string) string {
+	if address == "" || m.TLSConfig == nil {
+		return address
+	}
+	return "tls://" + address
+}
+
+// Endpoints returns every endpoint peers may reach the relay on: PublicAddress
+// first, then the extra public addresses and those PortMapping mapped, then
+// the WebSocket URL.
+func (m *RelayManager) Endpoints() []string {
+	var eps []string
+	if ep := m.relayEndpoint(); ep != "" {
+		eps = append(eps, ep)
+	}
+	for _, address := range append(m.extraPublicAddresses(), m.mappedAddresses()...) {
+		if ep := m.tcpEndpoint(address); !slices.Contains(eps, ep) {
+			eps = append(eps, ep)
+		}
+	}
+	if u := m.webSocketURL(); u != "" {
+		eps = append(eps, u)
+	}
+	return eps
+}
+
+// extraPublicAddresses returns ExtraPublicAddresses, or the addresses of the
+// ExtraListen listeners, from their listen addresses until they are started.
+// Those listening on an unspecified IP take the host of PublicAddress; they
+// are left out if it has none.
+func (m *RelayManager) extraPublicAddresses() []string {
+	if len(m.ExtraPublicAddresses) > 0 {
+		return m.ExtraPublicAddresses
+	}
+	publicHost, _, _ := net.SplitHostPort(m.PublicAddress)
+	var addrs []string
+	for i, listen := range m.ExtraListen {
+		if len(m.listeners) > 1+i {
+			listen = m.listeners[1+i].Addr().String()
+		}
+		host, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			continue
+		}
+		if ip, err := netip.ParseAddr(host); host == "" || err == nil && ip.IsUnspecified() {
+			if publicHost == "" {
+				continue
+			}
+			host = publicHost
+		}
+		addrs = append(addrs, net.JoinHostPort(host, port))
+	}
+	return addrs
+}
+
+// observedAddress returns addr as ip:port, the NAT mapping of the peer a
+// connection comes from, or "" if it is not a TCP address, e.g. on the Unix
+// socket.
+func observedAddress(addr net.Addr) string {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	ap := a.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+}
+
+// checkPublicAddress reports a PublicAddress or an extra public address peers
+// could not dial: one that is not host:port, e.g. an IPv6 address without
+// brackets, or an IPv4 address of an IPv6-only relay.
+func (m *RelayManager) checkPublicAddress() error {
+	for _, address := range append([]string{m.PublicAddress}, m.ExtraPublicAddresses...) {
+		if err := m.checkAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *RelayManager) checkAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return fmt.Errorf("public address %q: IPv6 addresses must be bracketed, e.g. [2001:db8::1]:24002", address)
+		}
+		return fmt.Errorf("public address %q: %w", address, err)
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && m.IPv6Only && ip.Unmap().Is4() {
+		return fmt.Errorf("public address %q is IPv4 but the relay is IPv6-only", address)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name        string
+		public      string
+		extraListen []string
+		extraPublic []string
+		tls         bool
+		want        []string
+	}{
+		{name: "public only", public: "relay.example.com:24002", want: []string{"relay.example.com:24002"}},
+		{
+			name: "unspecified extra listeners", public: "relay.example.com:24002",
+			extraListen: []string{"0.0.0.0:443", "[::]:80", ":8443"},
+			want:        []string{"relay.example.com:24002", "relay.example.com:443", "relay.example.com:80", "relay.example.com:8443"},
+		},
+		{
+			name: "specified extra listener", public: "198.51.100.7:24002",
+			extraListen: []string{"203.0.113.9:443"},
+			want:        []string{"198.51.100.7:24002", "203.0.113.9:443"},
+		},
+		{
+			name: "duplicate dropped", public: "198.51.100.7:24002",
+			extraListen: []string{"[::]:24002"},
+			want:        []string{"198.51.100.7:24002"},
+		},
+		{
+			name: "extra public addresses", public: "relay.example.com:24002",
+			extraListen: []string{"0.0.0.0:8443"}, extraPublic: []string{"lb.example.com:443"},
+			want: []string{"relay.example.com:24002", "lb.example.com:443"},
+		},
+		{
+			name: "tls", public: "relay.example.com:24002", extraListen: []string{"0.0.0.0:443"}, tls: true,
+			want: []string{"tls://relay.example.com:24002", "tls://relay.example.com:443"},
+		},
+		{name: "no public host", extraListen: []string{"0.0.0.0:443"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New()
+			m.PublicAddress = tt.public
+			m.ExtraListen = tt.extraListen
+			m.ExtraPublicAddresses = tt.extraPublic
+			if tt.tls {
+				m.TLSConfig = &tls.Config{}
+			}
+			if got := m.Endpoints(); !slices.Equal(got, tt.want) {
+				t.Fatalf("Endpoints() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtraListen(t *testing.T) {
+	m := New()
+	m.PublicAddress = "127.0.0.1:24002"
+	m.ExtraListen = []string{"127.0.0.1:0"}
+	if err := m.Start(context.Background(), "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	addrs := m.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("Addrs() = %v, want 2 addresses", addrs)
+	}
+	_, port, _ := net.SplitHostPort(addrs[1].String())
+	if eps := m.Endpoints(); !slices.Contains(eps, "127.0.0.1:"+port) {
+		t.Fatalf("Endpoints() = %q, want the extra listener on port %s", eps, port)
+	}
+
+	for _, addr := range addrs {
+		if err := probe(addr.String()); err != nil {
+			t.Fatalf("probe on %s: %v", addr, err)
+		}
+	}
+}
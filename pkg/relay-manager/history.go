@@ -0,0 +1,43 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/flymesh/core/pkg/history"
+)
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n.Add(uint64(n))
+	return n, err
+}
+
+// trackBridge records the traffic of the bridge of a in m.History, for both its
+// peers, bytes in being those received from the peer. It returns the writers to
+// copy into and the func ending the record.
+func (m *RelayManager) trackBridge(a *allocation, toServer io.Writer, toClient io.Writer) (io.Writer, io.Writer, func()) {
+	if m.History == nil {
+		return toServer, toClient, func() {}
+	}
+	var c2s, s2c atomic.Uint64
+	untrackServer := m.History.Track([]history.Series{{Kind: history.KindPeer, Key: a.serverPeerID.String()}}, func() (uint64, uint64) {
+		return s2c.Load(), c2s.Load()
+	})
+	untrackClient := m.History.Track([]history.Series{{Kind: history.KindPeer, Key: a.clientPeerID.String()}}, func() (uint64, uint64) {
+		return c2s.Load(), s2c.Load()
+	})
+	return countingWriter{toServer, &c2s}, countingWriter{toClient, &s2c}, func() {
+		untrackServer()
+		untrackClient()
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/flymesh/core/pkg/keyfile"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// stateVersion is the version of the StateFile format.
+const stateVersion = 1
+
+// savedState is the content of StateFile.
+type savedState struct {
+	Version     int               `json:"version"`
+	Allocations []savedAllocation `json:"allocations"`
+}
+
+// savedAllocation is an allocation not yet bridged, as kept in StateFile.
+type savedAllocation struct {
+	StreamID      uint64        `json:"stream_id"`
+	Token         []byte        `json:"token"`
+	RetryCookie   []byte        `json:"retry_cookie"`
+	ServerPeer    peer.ID       `json:"server_peer"`
+	ClientPeer    peer.ID       `json:"client_peer"`
+	Created       time.Time     `json:"created"`
+	TTL           time.Duration `json:"ttl"`
+	QuotaBytes    int64         `json:"quota_bytes,omitempty"`
+	QuotaDeadline time.Time     `json:"quota_deadline,omitzero"`
+	Priority      Priority      `json:"priority,omitempty"`
+	ServerSources []netip.Addr  `json:"server_sources,omitempty"`
+	ClientSources []netip.Addr  `json:"client_sources,omitempty"`
+}
+
+// stateChanged marks the allocations to be saved to StateFile on the next GC
+// tick.
+func (m *RelayManager) stateChanged() {
+	if m.StateFile != "" {
+		m.stateDirty.Store(true)
+	}
+}
+
+// saveState writes the allocations not yet bridged to StateFile. Bridged
+// allocations are left out: their connections do not survive a restart.
+func (m *RelayManager) saveState() error {
+	m.stateDirty.Store(false)
+	st := savedState{Version: stateVersion, Allocations: []savedAllocation{}}
+	m.mu.Lock()
+	for _, a := range m.allocations {
+		a.mu.Lock()
+		bridged := a.sideS != nil && a.sideC != nil
+		serverSources, clientSources := a.sourcesS, a.sourcesC
+		a.mu.Unlock()
+		if bridged {
+			continue
+		}
+		st.Allocations = append(st.Allocations, savedAllocation{
+			StreamID:      a.streamID,
+			Token:         a.token,
+			RetryCookie:   a.retryCookie,
+			ServerPeer:    a.serverPeerID,
+			ClientPeer:    a.clientPeerID,
+			Created:       a.created,
+			TTL:           a.ttl,
+			QuotaBytes:    a.quota.MaxBytes,
+			QuotaDeadline: a.quota.Deadline,
+			Priority:      a.quota.Priority,
+			ServerSources: serverSources,
+			ClientSources: clientSources,
+		})
+	}
+	m.mu.Unlock()
+	data, err := json.Marshal(&st)
+	if err != nil {
+		return err
+	}
+	if err := keyfile.Replace(m.StateFile, data); err != nil {
+		m.stateDirty.Store(true)
+		return err
+	}
+	return nil
+}
+
+// flushState saves the allocations to StateFile if they changed since the
+// last save.
+func (m *RelayManager) flushState() {
+	if m.StateFile == "" || !m.stateDirty.Load() || m.handedOff.Load() {
+		return
+	}
+	if err := m.saveState(); err != nil {
+		m.logger().Warn("save allocations failed", "path", m.StateFile, "err", err)
+	}
+}
+
+// restoreState loads the allocations of StateFile whose TTL has not passed. A
+// missing file restores nothing.
+func (m *RelayManager) restoreState() error {
+	data, err := os.ReadFile(m.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st savedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("%s: %w", m.StateFile, err)
+	}
+	if st.Version != stateVersion {
+		return fmt.Errorf("%s: unsupported version %d", m.StateFile, st.Version)
+	}
+	now := time.Now()
+	restored := 0
+	m.mu.Lock()
+	for _, s := range st.Allocations {
+		if now.Sub(s.Created) > s.TTL || len(s.Token) != 32 || m.allocations[s.StreamID] != nil {
+			continue
+		}
+		m.allocations[s.StreamID] = &allocation{
+			streamID:     s.StreamID,
+			token:        s.Token,
+			retryCookie:  s.RetryCookie,
+			serverPeerID: s.ServerPeer,
+			clientPeerID: s.ClientPeer,
+			created:      s.Created,
+			ttl:          s.TTL,
+			quota:        Quota{MaxBytes: s.QuotaBytes, Deadline: s.QuotaDeadline, Priority: s.Priority},
+			sourcesS:     s.ServerSources,
+			sourcesC:     s.ClientSources,
+		}
+		restored++
+	}
+	m.mu.Unlock()
+	m.stateChanged()
+	if restored > 0 {
+		m.logger().Info("allocations restored", "path", m.StateFile, "allocations", restored)
+	}
+	return nil
+}
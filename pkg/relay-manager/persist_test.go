@@ -0,0 +1,82 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func mustDecodePeer(t *testing.T, s string) peer.ID {
+	t.Helper()
+	id, err := peer.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	server := mustDecodePeer(t, "12D3KooWLRPJAA5o6zfDNcEnJUWHA8FYhG9GLExsqDhVdxPA5ZUE")
+	client := mustDecodePeer(t, "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo")
+	path := filepath.Join(t.TempDir(), "allocations.json")
+	m := New()
+	m.StateFile = path
+	quota := Quota{MaxBytes: 1 << 20, Deadline: time.Now().Add(time.Hour).Truncate(time.Second), Priority: PriorityBulk}
+	live, _, err := m.CreateStream(server, client, time.Minute, quota)
+	if err != nil {
+		t.Fatalf("CreateStream() err = %v", err)
+	}
+	expired, _, err := m.CreateStream(server, client, time.Nanosecond, Quota{})
+	if err != nil {
+		t.Fatalf("CreateStream() err = %v", err)
+	}
+	if !m.stateDirty.Load() {
+		t.Fatal("allocations not marked for saving")
+	}
+	if err := m.saveState(); err != nil {
+		t.Fatalf("saveState() err = %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("state file %v, err = %v, want mode 0600", fi, err)
+	}
+
+	restored := New()
+	restored.StateFile = path
+	if err := restored.restoreState(); err != nil {
+		t.Fatalf("restoreState() err = %v", err)
+	}
+	if a := restored.allocations[expired.StreamID]; a != nil {
+		t.Fatal("expired allocation restored")
+	}
+	a := restored.allocations[live.StreamID]
+	if a == nil {
+		t.Fatal("live allocation not restored")
+	}
+	if !bytes.Equal(a.token, live.Token) || !bytes.Equal(a.retryCookie, live.RetryCookie) {
+		t.Fatal("restored token or retry cookie differs")
+	}
+	if a.serverPeerID != server || a.clientPeerID != client || a.ttl != time.Minute {
+		t.Fatalf("restored allocation %+v", a)
+	}
+	if a.quota.MaxBytes != quota.MaxBytes || !a.quota.Deadline.Equal(quota.Deadline) || a.quota.Priority != quota.Priority {
+		t.Fatalf("restored quota %+v, want %+v", a.quota, quota)
+	}
+}
+
+func TestRestoreStateMissingFile(t *testing.T) {
+	m := New()
+	m.StateFile = filepath.Join(t.TempDir(), "missing.json")
+	if err := m.restoreState(); err != nil {
+		t.Fatalf("restoreState() err = %v", err)
+	}
+	if len(m.allocations) != 0 {
+		t.Fatalf("%d allocations restored", len(m.allocations))
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/net/nat"
+)
+
+// portMapTimeout bounds the discovery of the NAT gateway.
+var portMapTimeout = 30 * time.Second
+
+// portMapper maps ports on the NAT gateway of the network and renews them
+// until closed, as nat.NAT does.
+type portMapper interface {
+	AddMapping(ctx context.Context, protocol string, port int) error
+	GetMapping(protocol string, port int) (netip.AddrPort, bool)
+	Close() error
+}
+
+// discoverNAT finds the UPnP or NAT-PMP gateway of the network.
+var discoverNAT = func(ctx context.Context) (portMapper, error) {
+	n, err := nat.DiscoverNAT(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// portMap is the mapping of the ports of the TCP listeners on the gateway.
+type portMap struct {
+	mapper portMapper
+	ports  []int
+}
+
+// checkPortMapping reports why the ports of m cannot be mapped.
+func (m *RelayManager) checkPortMapping() error {
+	if m.PortMapping && m.IPv6Only {
+		return errors.New("port mapping is IPv4 only but the relay is IPv6-only")
+	}
+	return nil
+}
+
+// mapPorts discovers the NAT gateway and maps the ports of the TCP listeners
+// on it, then renews the mappings until ctx is done. They are removed then,
+// unless Drain handed the listeners over to the next process, which maps
+// the same ports.
+func (m *RelayManager) mapPorts(ctx context.Context) {
+	var ports []int
+	for _, ln := range m.listeners[:1+len(m.ExtraListen)] {
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	}
+	discoverCtx, cancel := context.WithTimeout(ctx, portMapTimeout)
+	mapper, err := discoverNAT(discoverCtx)
+	cancel()
+	if err != nil {
+		m.logger().Warn("no NAT gateway to map the relay ports on", "err", err)
+		return
+	}
+	for _, port := range ports {
+		if err := mapper.AddMapping(ctx, "tcp", port); err != nil {
+			m.logger().Warn("port mapping failed", "port", port, "err", err)
+		}
+	}
+	m.portMap.Store(&portMap{mapper: mapper, ports: ports})
+	m.logger().Info("relay ports mapped", "endpoints", m.mappedAddresses())
+
+	<-ctx.Done()
+	m.portMap.Store(nil)
+	if !m.handedOff.Load() {
+		_ = mapper.Close()
+	}
+}
+
+// mappedAddresses returns the external addresses, ip:port, the gateway
+// currently maps the TCP listeners to.
+func (m *RelayManager) mappedAddresses() []string {
+	pm := m.portMap.Load()
+	if pm == nil {
+		return nil
+	}
+	var addrs []string
+	for _, port := range pm.ports {
+		if addr, ok := pm.mapper.GetMapping("tcp", port); ok {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return addrs
+}
+
+// isPublicAddress reports whether peers on the Internet may reach the
+// host:port address: its host is a name, or a global unicast IP outside the
+// private ranges.
+func isPublicAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return true
+	}
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
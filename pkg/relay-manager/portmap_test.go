@@ -0,0 +1,108 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMapper maps every port to itself plus 10000 on 203.0.113.5.
+type fakeMapper struct {
+	mu     sync.Mutex
+	ports  map[int]bool
+	closed bool
+}
+
+func (f *fakeMapper) AddMapping(ctx context.Context, protocol string, port int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ports[port] = true
+	return nil
+}
+
+func (f *fakeMapper) GetMapping(protocol string, port int) (netip.AddrPort, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.ports[port] || protocol != "tcp" {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(netip.MustParseAddr("203.0.113.5"), uint16(port+10000)), true
+}
+
+func (f *fakeMapper) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func TestPortMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		public string
+		// want lists the endpoints, "mapped" standing for the mapped address.
+		want []string
+	}{
+		{name: "no public address", want: []string{"mapped"}},
+		{name: "unspecified public address", public: "0.0.0.0:24002", want: []string{"mapped"}},
+		{name: "private public address", public: "192.168.1.2:24002", want: []string{"mapped"}},
+		{name: "public name", public: "relay.example.com:24002", want: []string{"relay.example.com:24002", "mapped"}},
+		{name: "public IP", public: "198.51.100.7:24002", want: []string{"198.51.100.7:24002", "mapped"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := &fakeMapper{ports: make(map[int]bool)}
+			old := discoverNAT
+			discoverNAT = func(ctx context.Context) (portMapper, error) { return mapper, nil }
+			defer func() { discoverNAT = old }()
+
+			m := New()
+			m.PublicAddress = tt.public
+			m.PortMapping = true
+			if err := m.Start(context.Background(), "127.0.0.1:0"); err != nil {
+				t.Fatal(err)
+			}
+			port := m.Addrs()[0].(*net.TCPAddr).Port
+			mapped := "203.0.113.5:" + strconv.Itoa(port+10000)
+			want := slices.Clone(tt.want)
+			want[slices.Index(want, "mapped")] = mapped
+
+			deadline := time.Now().Add(5 * time.Second)
+			for !slices.Contains(m.Endpoints(), mapped) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := m.Endpoints(); !slices.Equal(got, want) {
+				t.Fatalf("Endpoints() = %q, want %q", got, want)
+			}
+			if _, ep, err := m.CreateStream("server", "client", time.Minute, Quota{}); err != nil || ep != want[0] {
+				t.Fatalf("CreateStream() endpoint = %q, %v, want %q", ep, err, want[0])
+			}
+
+			m.Stop()
+			if !mapper.closed {
+				t.Fatal("mappings not removed on Stop")
+			}
+			if eps := m.Endpoints(); slices.Contains(eps, mapped) {
+				t.Fatalf("Endpoints() = %q after Stop", eps)
+			}
+		})
+	}
+}
+
+func TestPortMappingIPv6Only(t *testing.T) {
+	m := New()
+	m.PortMapping = true
+	m.IPv6Only = true
+	if err := m.Start(context.Background(), "[::1]:0"); err == nil {
+		m.Stop()
+		t.Fatal("Start() err = nil, want an error")
+	}
+}
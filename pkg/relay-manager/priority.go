@@ -0,0 +1,220 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var errBridgeClosed = errors.New("bridge closed")
+
+// Priority is the class of traffic of a bridge, which schedules its share of
+// Bandwidth. Its values are those of controlpb.StreamPriority.
+type Priority int
+
+const (
+	// PriorityDefault is the priority of bridges whose peers asked for none.
+	PriorityDefault Priority = iota
+	// PriorityInteractive is latency-sensitive traffic, e.g. an SSH session.
+	PriorityInteractive
+	// PriorityBulk is traffic yielding to the others, e.g. a backup.
+	PriorityBulk
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBulk:
+		return "bulk"
+	default:
+		return "default"
+	}
+}
+
+// weight is the share of Bandwidth a bridge of priority p gets, relative to
+// the other bridges waiting for it.
+func (p Priority) weight() float64 {
+	switch p {
+	case PriorityInteractive:
+		return 16
+	case PriorityBulk:
+		return 1
+	default:
+		return 4
+	}
+}
+
+const (
+	// schedQuantum is the most bytes a bridge writes per grant of the
+	// scheduler, so that a large write of a bulk bridge does not hold back
+	// those of interactive ones for long.
+	schedQuantum = 16 << 10
+	// schedBurst is how much of Bandwidth the scheduler grants at once when
+	// the relay was idle.
+	schedBurst = 20 * time.Millisecond
+)
+
+// scheduler shares a bandwidth among bridges by weighted fair queuing: every
+// write is tagged with the virtual time it would finish at if its bridge got
+// the share its weight gives, from the later of the end of the previous write
+// of the bridge and the start of the last write granted, and the writes
+// waiting for the bandwidth are granted in tag order. A bridge writing little,
+// as an interactive session does, thus gets its writes through ahead of the
+// queued bulk ones.
+type scheduler struct {
+	rate  float64 // bytes per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// vtime is the start tag of the last write granted.
+	vtime float64
+	seq   uint64
+	queue writeQueue
+	timer *time.Timer
+}
+
+func newScheduler(bandwidth int64) *scheduler {
+	rate := float64(bandwidth)
+	burst := max(rate*schedBurst.Seconds(), schedQuantum)
+	return &scheduler{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// bridgeWriters returns the writers both directions of a bridge of priority
+// p copy into, which wait for their share of the bandwidth of s, and the func
+// releasing the writers still waiting once the bridge closes. A nil s
+// schedules nothing.
+func (s *scheduler) bridgeWriters(p Priority, toServer io.Writer, toClient io.Writer) (io.Writer, io.Writer, func()) {
+	if s == nil {
+		return toServer, toClient, func() {}
+	}
+	done := make(chan struct{})
+	var once sync.Once
+	return &scheduledWriter{w: toServer, s: s, weight: p.weight(), done: done},
+		&scheduledWriter{w: toClient, s: s, weight: p.weight(), done: done},
+		func() { once.Do(func() { close(done) }) }
+}
+
+// wait queues a write of n bytes of w, and returns once it is granted, or
+// w.done is closed.
+func (s *scheduler) wait(w *scheduledWriter, n int) error {
+	s.mu.Lock()
+	start := max(s.vtime, w.finish)
+	w.finish = start + float64(n)/w.weight
+	pw := &pendingWrite{start: start, finish: w.finish, seq: s.seq, n: float64(n), ready: make(chan struct{})}
+	s.seq++
+	heap.Push(&s.queue, pw)
+	s.dispatch()
+	s.mu.Unlock()
+
+	select {
+	case <-pw.ready:
+		return nil
+	case <-w.done:
+		s.mu.Lock()
+		pw.abandoned = true
+		s.mu.Unlock()
+		return errBridgeClosed
+	}
+}
+
+// dispatch grants the queued writes the bandwidth allows, and arms the timer
+// granting the next one. Caller must hold s.mu.
+func (s *scheduler) dispatch() {
+	now := time.Now()
+	s.tokens = min(s.burst, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	s.last = now
+	for s.queue.Len() > 0 {
+		pw := s.queue[0]
+		if pw.abandoned {
+			heap.Pop(&s.queue)
+			continue
+		}
+		if s.tokens < pw.n {
+			break
+		}
+		heap.Pop(&s.queue)
+		s.tokens -= pw.n
+		s.vtime = pw.start
+		close(pw.ready)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.queue.Len() > 0 {
+		wait := time.Duration((s.queue[0].n - s.tokens) / s.rate * float64(time.Second))
+		s.timer = time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.dispatch()
+		})
+	}
+}
+
+// scheduledWriter passes writes through as the scheduler grants them.
+type scheduledWriter struct {
+	w      io.Writer
+	s      *scheduler
+	weight float64
+	// finish is the tag of the last write queued, guarded by s.mu.
+	finish float64
+	done   <-chan struct{}
+}
+
+func (w *scheduledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := min(len(p), written+schedQuantum)
+		if err := w.s.wait(w, end-written); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// pendingWrite is a write waiting in the queue of a scheduler.
+type pendingWrite struct {
+	start     float64
+	finish    float64
+	seq       uint64
+	n         float64
+	ready     chan struct{}
+	abandoned bool
+}
+
+// writeQueue is a heap of pending writes, by tag then arrival.
+type writeQueue []*pendingWrite
+
+func (q writeQueue) Len() int { return len(q) }
+
+func (q writeQueue) Less(i, j int) bool {
+	if q[i].finish != q[j].finish {
+		return q[i].finish < q[j].finish
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q writeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *writeQueue) Push(x any) { *q = append(*q, x.(*pendingWrite)) }
+
+func (q *writeQueue) Pop() any {
+	old := *q
+	pw := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return pw
+}
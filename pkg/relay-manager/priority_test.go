@@ -0,0 +1,118 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// saturate has w write chunks until stop is closed, and counts the bytes in n.
+func saturate(w io.Writer, n *atomic.Int64, stop chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	chunk := make([]byte, 32<<10)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		written, err := w.Write(chunk)
+		n.Add(int64(written))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestSchedulerShares(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Priority
+	}{
+		{name: "interactive and bulk", a: PriorityInteractive, b: PriorityBulk},
+		{name: "default and bulk", a: PriorityDefault, b: PriorityBulk},
+		{name: "same priority", a: PriorityBulk, b: PriorityBulk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(2 << 20)
+			wa, _, releaseA := s.bridgeWriters(tt.a, io.Discard, io.Discard)
+			wb, _, releaseB := s.bridgeWriters(tt.b, io.Discard, io.Discard)
+			var (
+				na, nb atomic.Int64
+				wg     sync.WaitGroup
+			)
+			stop := make(chan struct{})
+			wg.Add(2)
+			go saturate(wa, &na, stop, &wg)
+			go saturate(wb, &nb, stop, &wg)
+			time.Sleep(500 * time.Millisecond)
+			close(stop)
+			releaseA()
+			releaseB()
+			wg.Wait()
+
+			want := tt.a.weight() / tt.b.weight()
+			got := float64(na.Load()) / float64(nb.Load())
+			if got < want/2 || got > want*2 {
+				t.Fatalf("share ratio = %.2f (%d/%d bytes), want about %.2f", got, na.Load(), nb.Load(), want)
+			}
+		})
+	}
+}
+
+func TestSchedulerInteractiveLatency(t *testing.T) {
+	// Half a second of bulk writes queue up before the interactive one.
+	s := newScheduler(1 << 20)
+	var (
+		n  atomic.Int64
+		wg sync.WaitGroup
+	)
+	stop := make(chan struct{})
+	for range 16 {
+		w, _, release := s.bridgeWriters(PriorityBulk, io.Discard, io.Discard)
+		defer release()
+		wg.Add(1)
+		go saturate(w, &n, stop, &wg)
+	}
+	defer wg.Wait()
+	defer close(stop)
+	time.Sleep(100 * time.Millisecond)
+
+	w, _, release := s.bridgeWriters(PriorityInteractive, io.Discard, io.Discard)
+	defer release()
+	started := time.Now()
+	if _, err := w.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(started); d > 100*time.Millisecond {
+		t.Fatalf("interactive write took %v behind bulk ones", d)
+	}
+}
+
+func TestSchedulerRelease(t *testing.T) {
+	s := newScheduler(schedQuantum)
+	w, _, release := s.bridgeWriters(PriorityDefault, io.Discard, io.Discard)
+	// The first quantum is granted at once, the next ones a second apart.
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 4*schedQuantum))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	release()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errBridgeClosed) {
+			t.Fatalf("Write() err = %v, want errBridgeClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write() still waiting after release")
+	}
+}
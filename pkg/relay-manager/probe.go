@@ -0,0 +1,63 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	relaypb "github.com/flymesh/core/pkg/pb/relay"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxProbes bounds the probes answered on one connection.
+	maxProbes = 16
+	// probeIdleTimeout bounds the wait for the next probe of a connection.
+	probeIdleTimeout = 10 * time.Second
+)
+
+// handleProbes answers the Probe read first on c and those following it, up
+// to maxProbes, then closes c.
+func (m *RelayManager) handleProbes(c net.Conn, hdr *relay_protocol.RelayHeader, data []byte, sum []byte) error {
+	for n := 1; ; n++ {
+		if err := hdr.VerifyRelayHMAC(relay_protocol.ProbeToken, data, sum); err != nil {
+			return err
+		}
+		var probe relaypb.Probe
+		if err := proto.Unmarshal(data, &probe); err != nil {
+			return fmt.Errorf("bad probe payload: %w", err)
+		}
+		allocations, _ := m.Load()
+		reply, _ := proto.Marshal(&relaypb.ProbeReply{
+			Nonce:           probe.GetNonce(),
+			Hello:           m.Hello(),
+			Allocations:     uint32(allocations),
+			MaxAllocations:  uint32(m.Settings().MaxAllocations),
+			ObservedAddress: observedAddress(c.RemoteAddr()),
+		})
+		if err := relay_protocol.WriteRelayFrame(c, relay_protocol.RelayTypeProbeReply, relay_protocol.ProbeToken, reply); err != nil {
+			return err
+		}
+		if n == maxProbes {
+			break
+		}
+		var err error
+		hdr, data, sum, err = relay_protocol.ReadRelayFrameRaw(c, probeIdleTimeout)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read relay-server frame: %w", err)
+		}
+		if hdr.Type != relay_protocol.RelayTypeProbe {
+			return fmt.Errorf("unexpected relay-server frame type after probe: %d", hdr.Type)
+		}
+	}
+	return c.Close()
+}
@@ -0,0 +1,42 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var errQuotaSpent = errors.New("bridge byte quota spent")
+
+// Quota bounds the bridge of an allocation, e.g. to the guest access the server
+// requests it for, and sets its priority. The zero value bounds nothing.
+type Quota struct {
+	// MaxBytes closes the bridge once this many bytes crossed it, counting both
+	// directions. 0 means unlimited.
+	MaxBytes int64
+	// Deadline closes the bridge when reached. The zero value means none.
+	Deadline time.Time
+	// Priority schedules the share of Bandwidth the bridge gets.
+	Priority Priority
+}
+
+// bridgeWriters returns the writers both directions of a bridge copy into. With
+// MaxBytes set they share a byte budget and call kill when it runs out.
+func (q Quota) bridgeWriters(toServer io.Writer, toClient io.Writer, kill func()) (io.Writer, io.Writer) {
+	if q.MaxBytes <= 0 {
+		return toServer, toClient
+	}
+	return newBudget(q.MaxBytes, errQuotaSpent, kill).writers(toServer, toClient)
+}
+
+// deadlineTimer calls kill at q.Deadline, if set. The returned func stops the
+// timer.
+func (q Quota) deadlineTimer(kill func()) func() bool {
+	if q.Deadline.IsZero() {
+		return func() bool { return false }
+	}
+	return time.AfterFunc(time.Until(q.Deadline), kill).Stop
+}
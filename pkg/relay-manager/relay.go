@@ -4,20 +4,37 @@
 package relay_manager
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
+	"net/netip"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/flymesh/core/pkg/history"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/obfs"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
 	"github.com/flymesh/core/pkg/pb/relay"
+	"github.com/flymesh/core/pkg/policy"
+	"github.com/flymesh/core/pkg/proxyproto"
 	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/status"
+	"github.com/flymesh/core/pkg/tracing"
+	"github.com/flymesh/core/pkg/wsconn"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/trace"
 
 	"google.golang.org/protobuf/proto"
 )
@@ -25,11 +42,23 @@ import (
 var (
 	ErrAllocationNotFound = errors.New("allocation not found")
 	ErrBadPeer            = errors.New("bad peer")
+	ErrTooManyAllocations = errors.New("too many allocations")
+	ErrShuttingDown       = errors.New("relay is shutting down")
+	ErrBadCount           = errors.New("bad allocation count")
+	// ErrHandshakeRejected is returned for a handshake naming an unknown
+	// stream or failing its HMAC check, which are told apart in logs only.
+	ErrHandshakeRejected = errors.New("handshake rejected")
+	// ErrSourceMismatch is returned for a handshake from a source IP its
+	// allocation is not bound to, see BindSources.
+	ErrSourceMismatch = errors.New("handshake from unexpected source address")
+
+	errNotObfuscated = errors.New("plain connection refused, obfuscation required")
 )
 
 type allocation struct {
 	streamID     uint64
 	token        []byte // 32 bytes
+	retryCookie  []byte
 	serverPeerID peer.ID
 	clientPeerID peer.ID
 
@@ -39,6 +68,30 @@ type allocation struct {
 	sideC   net.Conn
 	created time.Time
 	ttl     time.Duration
+	// quota bounds the bridge.
+	quota Quota
+	// readyS and readyC tell whether each side waits for a Ready frame.
+	readyS, readyC bool
+	// helloS and helloC are the Hellos of the HandshakeRequests of each side,
+	// which choose the MAC algorithm of their Ready frame.
+	helloS, helloC *controlpb.Hello
+	// closeReason is why the bridge ended, see closeWith.
+	closeReason string
+	// sourcesS and sourcesC are the source IPs each side must connect from,
+	// see BindSources. Empty allows any.
+	sourcesS, sourcesC []netip.Addr
+}
+
+// state returns the allocation lifecycle state. Caller must hold a.mu.
+func (a *allocation) state() string {
+	switch {
+	case a.sideS != nil && a.sideC != nil:
+		return "bridged"
+	case a.sideS != nil || a.sideC != nil:
+		return "half-connected"
+	default:
+		return "allocated"
+	}
 }
 
 func (a *allocation) Close() error {
@@ -56,41 +109,256 @@ func (a *allocation) Close() error {
 	return nil
 }
 
+// PeerVerifier proves that a peer requesting an allocation controls its identity
+// beyond the authentication of the control stream, e.g. dialback.Verifier.
+type PeerVerifier interface {
+	VerifyPeer(ctx context.Context, p peer.ID) error
+}
+
 type RelayManager struct {
 	PublicAddress string
+	// ExtraListen lists further TCP addresses accepting relay connections
+	// like the listen address of Start, e.g. "[::]:24002" or "0.0.0.0:443",
+	// for peers behind egress policies allowing few ports. Optional.
+	ExtraListen []string
+	// ExtraPublicAddresses lists the endpoints, host:port, handed to peers
+	// after PublicAddress. Empty means the ExtraListen addresses, those of an
+	// unspecified IP taking the host of PublicAddress. Optional.
+	ExtraPublicAddresses []string
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// AccessLog receives one JSON line per allocation when it ends, bridged
+	// or not, with its peers, their addresses, the bytes bridged and the
+	// reason it ended. Optional.
+	AccessLog io.Writer
+	// Redaction is applied to peer IDs and addresses written to AccessLog.
+	Redaction logging.Redaction
+	// StreamTTL is how long an allocation waits for both sides to connect.
+	StreamTTL time.Duration
+	// MaxAllocations caps concurrent allocations. 0 means unlimited.
+	MaxAllocations int
+	// ProxyProtocol requires a PROXY protocol v1/v2 header on every relay
+	// connection, so that logs see the client address behind an L4 load balancer.
+	ProxyProtocol bool
+	// TrustedProxies restricts which peers may send a PROXY header. Empty trusts all.
+	// Connections on UnixSocket are always trusted.
+	TrustedProxies []netip.Prefix
+	// PeerVerifier, if set, must accept a server peer before an allocation is
+	// created for it. Optional.
+	PeerVerifier PeerVerifier
+	// Policy, if set, must allow a create-stream request before allocations
+	// are created for it, see relay_server.PolicyAttrs. Optional.
+	Policy *policy.Policy
+	// UnixSocket additionally accepts relay connections on this unix socket path,
+	// for a co-located gateway terminating TLS in front of the relay. Optional.
+	UnixSocket string
+	// UnixSocketMode is the file mode applied to UnixSocket.
+	UnixSocketMode os.FileMode
+	// Chaos injects faults for testing clients. nil disables. Development only.
+	Chaos *Chaos
+	// Obfuscator, if set, unwraps the relay connections that do not start like
+	// a relay frame, from peers disguising them. Optional.
+	Obfuscator obfs.Obfuscator
+	// RequireObfuscation refuses plain relay connections when Obfuscator is
+	// set, so that the relay-server does not answer probes in the clear.
+	RequireObfuscation bool
+	// History, if set, records the traffic of every peer. Optional.
+	History *history.Store
+	// WebSocket, if set, additionally accepts relay connections over
+	// WebSocket. Optional.
+	WebSocket *WebSocketOptions
+	// IPv6Only listens on IPv6 only, on both the TCP and WebSocket listeners.
+	IPv6Only bool
+	// TLSConfig, if set, requires TLS on the TCP listener, after the PROXY
+	// header if any. PublicAddress is then handed to peers as a tls://
+	// endpoint. Optional.
+	TLSConfig *tls.Config
+	// OnExpire, if set, is called on a goroutine of its own for every
+	// allocation its TTL drops before both peers connected. Optional.
+	OnExpire func(ExpiredAllocation)
+	// BindObservedSources has the relay-server bind every allocation, with
+	// BindSources, to the IPs of the direct libp2p connections of its peers
+	// when it is created. A peer must then open its relay connection from
+	// the address its libp2p connection to the relay-server comes from; a
+	// side without such a connection is left unbound. Behind a gateway, the
+	// source is only known with ProxyProtocol.
+	BindObservedSources bool
+	// StateFile, if set, keeps the allocations not yet bridged across
+	// restarts: they are saved there every few seconds and on Shutdown, and
+	// restored by Start while their TTL lasts, so that the CreateStream
+	// responses handed out before a restart stay valid. The file holds their
+	// tokens and is readable by its owner only. Optional.
+	StateFile string
+	// ReusePort sets SO_REUSEPORT on the TCP and WebSocket listeners, so that
+	// the next process of an upgrade can listen on the same addresses before
+	// this one drains, see Drain. Unix only.
+	ReusePort bool
+	// Bandwidth caps the bytes per second all bridges carry together, both
+	// directions counted. The bridges waiting for it share it by the weight
+	// of their Quota.Priority, interactive ones getting their writes through
+	// ahead of bulk ones. 0 means unlimited.
+	Bandwidth int64
+	// PortMapping maps the ports of the TCP listeners on the UPnP or NAT-PMP
+	// gateway of the network, for a relay hosted behind a consumer NAT. The
+	// external address of the mapping is handed to peers in place of a
+	// PublicAddress they cannot reach, e.g. a private IP, or else after the
+	// extra public addresses. IPv4 only.
+	PortMapping bool
+	// Metrics receives the metrics of the allocations and bridges. If nil,
+	// they are dropped.
+	Metrics metrics.Metrics
 
 	mu          sync.Mutex
+	accessMu    sync.Mutex
 	allocations map[uint64]*allocation
 	wg          sync.WaitGroup
-	lis         net.Listener
+	bridges     sync.WaitGroup
+	listeners   []net.Listener
+	accepting   atomic.Int32
+	draining    atomic.Bool
 	ctx         context.Context
 	cancel      context.CancelFunc
+	// rejectKey checks the HMAC of handshakes for unknown streams, so that
+	// they cost what those of known streams do.
+	rejectKey []byte
+	// stateDirty tells that the allocations changed since StateFile was saved.
+	stateDirty atomic.Bool
+	// handedOff tells that Drain closed the listeners for the next process.
+	handedOff atomic.Bool
+	// scheduler shares Bandwidth among the bridges, nil if unlimited.
+	scheduler *scheduler
+	// portMap is the mapping of PortMapping, once the gateway made it.
+	portMap atomic.Pointer[portMap]
 }
 
+// handshakeRejectDelay is how long after reading it a rejected handshake is
+// answered by closing the connection. Rejections of unknown streams and of bad
+// HMACs take the same time, so that peers cannot probe which streams exist.
+var handshakeRejectDelay = time.Second
+
 func New() *RelayManager {
+	rejectKey := make([]byte, 32)
+	_, _ = rand.Read(rejectKey)
 	return &RelayManager{
-		allocations: make(map[uint64]*allocation),
+		StreamTTL:      time.Minute,
+		UnixSocketMode: 0660,
+		allocations:    make(map[uint64]*allocation),
+		rejectKey:      rejectKey,
+	}
+}
+
+func (m *RelayManager) logger() *slog.Logger {
+	return logging.Component(m.Logger, "relay-manager")
+}
+
+func (m *RelayManager) metrics() metrics.Metrics {
+	return metrics.Or(m.Metrics)
+}
+
+// Settings are the fields of a RelayManager that Reconfigure changes.
+type Settings struct {
+	StreamTTL      time.Duration
+	MaxAllocations int
+	Policy         *policy.Policy
+}
+
+// Reconfigure changes the settings of a running m. They apply to the requests
+// that follow: allocations and bridges in place are kept, even beyond a lower
+// MaxAllocations.
+func (m *RelayManager) Reconfigure(s Settings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.StreamTTL = s.StreamTTL
+	m.MaxAllocations = s.MaxAllocations
+	m.Policy = s.Policy
+}
+
+// Settings returns the settings Reconfigure changes.
+func (m *RelayManager) Settings() Settings {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Settings{
+		StreamTTL:      m.StreamTTL,
+		MaxAllocations: m.MaxAllocations,
+		Policy:         m.Policy,
+	}
+}
+
+// network returns the network of the TCP and WebSocket listeners.
+func (m *RelayManager) network() string {
+	if m.IPv6Only {
+		return "tcp6"
 	}
+	return "tcp"
 }
 
-// Start begins accepting TCP connections and handling handshakes.
+// Start begins accepting TCP (and UnixSocket and WebSocket) connections and handling handshakes.
 func (m *RelayManager) Start(ctx context.Context, listenAddress string) error {
 	if m.cancel != nil {
 		return errors.New("already started")
 	}
-	m.ctx, m.cancel = context.WithCancel(ctx)
-	ln, err := net.Listen("tcp", listenAddress)
-	if err != nil {
+	if err := m.checkPublicAddress(); err != nil {
 		return err
 	}
-	m.lis = ln
-	log.Printf("[relay-server] listening on %s", ln.Addr().String())
+	if err := m.checkPortMapping(); err != nil {
+		return err
+	}
+	if m.StateFile != "" {
+		if err := m.restoreState(); err != nil {
+			return fmt.Errorf("restore allocations: %w", err)
+		}
+	}
+	m.listeners = nil
+	for _, addr := range append([]string{listenAddress}, m.ExtraListen...) {
+		ln, err := m.listen(addr)
+		if err != nil {
+			m.closeListeners()
+			return err
+		}
+		m.listeners = append(m.listeners, ln)
+	}
+	if m.UnixSocket != "" {
+		uln, err := listenUnix(m.UnixSocket, m.UnixSocketMode)
+		if err != nil {
+			m.closeListeners()
+			return err
+		}
+		m.listeners = append(m.listeners, uln)
+	}
+	if m.WebSocket != nil {
+		wln, err := m.listenWebSocket()
+		if err != nil {
+			m.closeListeners()
+			return err
+		}
+		m.listeners = append(m.listeners, wln)
+	}
+	if m.Bandwidth > 0 {
+		m.scheduler = newScheduler(m.Bandwidth)
+	}
+	m.ctx, m.cancel = context.WithCancel(ctx)
 
-	m.wg.Add(1)
-	go func() {
-		defer m.wg.Done()
-		m.acceptLoop()
-	}()
+	for i, ln := range m.listeners {
+		m.logger().Info("listening", "network", ln.Addr().Network(), "addr", ln.Addr().String())
+		// Only the TCP listeners, the first ones, serve TLS themselves.
+		var tlsConfig *tls.Config
+		if i <= len(m.ExtraListen) {
+			tlsConfig = m.TLSConfig
+		}
+		m.accepting.Add(1)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.acceptLoop(ln, tlsConfig)
+		}()
+	}
+	if m.PortMapping {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.mapPorts(m.ctx)
+		}()
+	}
 	// GC loop for TTL
 	m.wg.Add(1)
 	go func() {
@@ -103,83 +371,376 @@ func (m *RelayManager) Start(ctx context.Context, listenAddress string) error {
 				return
 			case <-t.C:
 				m.gc()
+				m.flushState()
 			}
 		}
 	}()
 	return nil
 }
 
-// Stop gracefully stops the server.
+// Stop stops the server and closes every allocation, including bridged ones.
 func (m *RelayManager) Stop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = m.Shutdown(ctx)
+}
+
+// Shutdown stops accepting connections and new allocations, drops allocations
+// that were never bridged, after saving them to StateFile if set, then waits
+// for in-flight bridges to finish until ctx is done. Bridges still running at
+// that point are closed and ctx.Err() is returned.
+func (m *RelayManager) Shutdown(ctx context.Context) error {
+	m.draining.Store(true)
 	if m.cancel != nil {
 		m.cancel()
 	}
-	if m.lis != nil {
-		_ = m.lis.Close()
-	}
+	m.closeListeners()
 	m.wg.Wait()
+	if m.StateFile != "" && !m.handedOff.Load() {
+		if err := m.saveState(); err != nil {
+			m.logger().Warn("save allocations failed", "path", m.StateFile, "err", err)
+		}
+	}
+
+	m.writeAccessLog(m.dropUnbridged(time.Now())...)
+	m.mu.Lock()
+	inflight := len(m.allocations)
+	m.mu.Unlock()
+	if inflight > 0 {
+		m.logger().Info("draining bridges", "bridges", inflight)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.bridges.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		m.mu.Lock()
+		for _, a := range m.allocations {
+			a.closeWith(CloseShutdown)
+		}
+		m.mu.Unlock()
+		<-done
+	}
+
+	m.mu.Lock()
+	m.allocations = make(map[uint64]*allocation)
+	m.mu.Unlock()
+	return err
+}
+
+// Addr returns the address of the TCP listener, or nil if not started.
+func (m *RelayManager) Addr() net.Addr {
+	if len(m.listeners) == 0 {
+		return nil
+	}
+	return m.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of the TCP listeners, that of the listen address
+// of Start first, then those of ExtraListen. Nil if not started.
+func (m *RelayManager) Addrs() []net.Addr {
+	if len(m.listeners) == 0 {
+		return nil
+	}
+	addrs := make([]net.Addr, 0, 1+len(m.ExtraListen))
+	for _, ln := range m.listeners[:1+len(m.ExtraListen)] {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+func (m *RelayManager) closeListeners() {
+	for _, ln := range m.listeners {
+		_ = ln.Close()
+	}
+}
+
+// CheckListener reports an error unless every listener is accepting connections,
+// or Drain handed them over.
+func (m *RelayManager) CheckListener(ctx context.Context) error {
+	// Drain handed the listeners over to the next process.
+	if m.handedOff.Load() {
+		return nil
+	}
+	if len(m.listeners) == 0 || int(m.accepting.Load()) != len(m.listeners) {
+		return errors.New("relay listener is not accepting")
+	}
+	return nil
+}
+
+// FillStatus implements status.Source.
+func (m *RelayManager) FillStatus(s *status.Status) {
+	ri := status.RelayInfo{Kind: "flymesh-relay", Draining: m.draining.Load()}
+	ri.Endpoints = m.Endpoints()
+	s.Relays = append(s.Relays, ri)
+
+	m.mu.Lock()
+	allocs := make([]*allocation, 0, len(m.allocations))
+	for _, a := range m.allocations {
+		allocs = append(allocs, a)
+	}
+	m.mu.Unlock()
+
+	for _, a := range allocs {
+		a.mu.Lock()
+		s.Sessions = append(s.Sessions, status.SessionInfo{
+			StreamID:     a.streamID,
+			ServerPeerID: a.serverPeerID.String(),
+			ClientPeerID: a.clientPeerID.String(),
+			State:        a.state(),
+			CreatedAt:    a.created,
+		})
+		a.mu.Unlock()
+	}
+}
+
+// Load returns the number of allocations held and how many of them are
+// bridged.
+func (m *RelayManager) Load() (allocations int, bridges int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, a := range m.allocations {
-		_ = a.Close()
+		a.mu.Lock()
+		if a.sideS != nil && a.sideC != nil {
+			bridges++
+		}
+		a.mu.Unlock()
 	}
-	m.allocations = make(map[uint64]*allocation)
+	return len(m.allocations), bridges
+}
+
+// MaxBatchAllocations caps the streams allocated by one CreateStreams call.
+const MaxBatchAllocations = 64
+
+// Hello returns the Hello the relay-server announces, on control streams and
+// in handshake acks.
+func (m *RelayManager) Hello() *controlpb.Hello {
+	return relay_protocol.NewHello(&controlpb.Limits{
+		MaxBatchAllocations: MaxBatchAllocations,
+	})
 }
 
-// CreateStream allocates a new stream with TTL and returns (streamID, token, tcpEndpoint)
-func (m *RelayManager) CreateStream(serverPeerID peer.ID, clientPeerID peer.ID, ttl time.Duration) (uint64, []byte, string, error) {
-	streamID := randomUint64()
-	token := make([]byte, 32)
-	if _, err := io.ReadFull(rand.Reader, token); err != nil {
-		return 0, nil, "", err
+// retryCookieSize is the size of the cookie identifying an allocation on retry.
+const retryCookieSize = 16
+
+// StreamAllocation is one stream allocated by CreateStreams.
+type StreamAllocation struct {
+	StreamID uint64
+	Token    []byte
+	// RetryCookie identifies the allocation to Supersede when the client retries
+	// after a failed dial.
+	RetryCookie []byte
+}
+
+// CreateStream allocates a new stream with TTL, whose bridge is bounded by
+// quota, and returns it with the tcpEndpoint.
+func (m *RelayManager) CreateStream(serverPeerID peer.ID, clientPeerID peer.ID, ttl time.Duration, quota Quota) (StreamAllocation, string, error) {
+	allocs, _, endpoint, err := m.allocate(serverPeerID, clientPeerID, 1, ttl, quota)
+	if err != nil {
+		return StreamAllocation{}, "", err
 	}
+	return allocs[0], endpoint, nil
+}
+
+// CreateStreams allocates n streams between the same peers. They share their
+// creation time and so expire together. Either all n are allocated or none.
+// It returns the allocations, their expiry and the tcpEndpoint.
+func (m *RelayManager) CreateStreams(serverPeerID peer.ID, clientPeerID peer.ID, n int, ttl time.Duration) ([]StreamAllocation, time.Time, string, error) {
+	return m.allocate(serverPeerID, clientPeerID, n, ttl, Quota{})
+}
 
-	a := &allocation{
-		streamID:     streamID,
-		token:        token,
-		serverPeerID: serverPeerID,
-		clientPeerID: clientPeerID,
-		created:      time.Now(),
-		ttl:          ttl,
+func (m *RelayManager) allocate(serverPeerID peer.ID, clientPeerID peer.ID, n int, ttl time.Duration, quota Quota) ([]StreamAllocation, time.Time, string, error) {
+	if n < 1 || n > MaxBatchAllocations {
+		return nil, time.Time{}, "", fmt.Errorf("%w %d: must be 1 to %d", ErrBadCount, n, MaxBatchAllocations)
+	}
+	created := time.Now()
+	allocs := make([]StreamAllocation, n)
+	entries := make([]*allocation, n)
+	for i := range allocs {
+		token := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, token); err != nil {
+			return nil, time.Time{}, "", err
+		}
+		cookie := make([]byte, retryCookieSize)
+		if _, err := io.ReadFull(rand.Reader, cookie); err != nil {
+			return nil, time.Time{}, "", err
+		}
+		allocs[i] = StreamAllocation{StreamID: randomUint64(), Token: token, RetryCookie: cookie}
+		entries[i] = &allocation{
+			streamID:     allocs[i].StreamID,
+			token:        token,
+			retryCookie:  cookie,
+			serverPeerID: serverPeerID,
+			clientPeerID: clientPeerID,
+			created:      created,
+			ttl:          ttl,
+			quota:        quota,
+		}
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.allocations[streamID] = a
+	if m.draining.Load() {
+		return nil, time.Time{}, "", ErrShuttingDown
+	}
+	if m.MaxAllocations > 0 && len(m.allocations)+n > m.MaxAllocations {
+		return nil, time.Time{}, "", ErrTooManyAllocations
+	}
+	for _, a := range entries {
+		m.allocations[a.streamID] = a
+	}
+	m.stateChanged()
+	m.metrics().Counter(metrics.RelayAllocations).Add(float64(n))
+
+	return allocs, created.Add(ttl), m.relayEndpoint(), nil
+}
 
-	return streamID, token, m.PublicAddress, nil
+// Supersede drops the allocation identified by cookie, which a client is
+// retrying after its dial failed, unless it got bridged meanwhile. Only the
+// peers of the allocation may supersede it. It returns the stream ID of the
+// dropped allocation.
+func (m *RelayManager) Supersede(serverPeerID peer.ID, clientPeerID peer.ID, cookie []byte) (uint64, bool) {
+	if len(cookie) != retryCookieSize {
+		return 0, false
+	}
+	m.mu.Lock()
+	for id, a := range m.allocations {
+		if a.serverPeerID != serverPeerID || a.clientPeerID != clientPeerID || subtle.ConstantTimeCompare(a.retryCookie, cookie) != 1 {
+			continue
+		}
+		a.mu.Lock()
+		bridged := a.sideS != nil && a.sideC != nil
+		a.mu.Unlock()
+		if bridged {
+			m.mu.Unlock()
+			return 0, false
+		}
+		entry := m.droppedEntry(a, time.Now(), CloseSuperseded)
+		a.Close()
+		delete(m.allocations, id)
+		m.stateChanged()
+		m.mu.Unlock()
+		m.writeAccessLog(entry)
+		return id, true
+	}
+	m.mu.Unlock()
+	return 0, false
 }
 
-// acceptLoop handles incoming TCP connections and handshake frames.
-func (m *RelayManager) acceptLoop() {
+// acceptLoop handles incoming connections on ln and their handshake frames.
+// Connections are secured with tlsConfig if it is not nil.
+func (m *RelayManager) acceptLoop(ln net.Listener, tlsConfig *tls.Config) {
+	defer m.accepting.Add(-1)
 	for {
-		conn, err := m.lis.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			select {
 			case <-m.ctx.Done():
 				return
 			default:
 			}
-			log.Printf("[relay-server] accept error: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			m.logger().Warn("accept error", "err", err)
 			continue
 		}
 		m.wg.Add(1)
 		go func(c net.Conn) {
 			defer m.wg.Done()
+			// A WebSocket carries no PROXY header: its HTTP server is the
+			// one a load balancer would talk to.
+			if _, ws := c.(*wsconn.Conn); m.ProxyProtocol && !ws {
+				pc, err := m.readProxyHeader(c)
+				if err != nil {
+					m.logger().Warn("proxy protocol error", logging.KeyRemoteAddr, c.RemoteAddr().String(), "err", err)
+					_ = c.Close()
+					return
+				}
+				c = pc
+			}
+			if tlsConfig != nil {
+				tc, err := m.handshakeTLS(c, tlsConfig)
+				if err != nil {
+					if !errors.Is(err, errACMEChallenge) {
+						m.logger().Warn("tls error", logging.KeyRemoteAddr, c.RemoteAddr().String(), "err", err)
+					}
+					_ = c.Close()
+					return
+				}
+				c = tc
+			}
+			if m.Obfuscator != nil {
+				oc, err := m.unwrapObfuscated(c)
+				if err != nil {
+					m.logger().Warn("obfuscation error", logging.KeyRemoteAddr, c.RemoteAddr().String(), "err", err)
+					_ = c.Close()
+					return
+				}
+				c = oc
+			}
 			if err := m.handleConn(c); err != nil {
-				log.Printf("[relay-server] conn error: %v", err)
+				m.logger().Warn("conn error", logging.KeyRemoteAddr, c.RemoteAddr().String(), "err", err)
 				_ = c.Close()
 			}
 		}(conn)
 	}
 }
 
+// readProxyHeader checks that c comes from a trusted proxy and returns it with the
+// client addresses from its PROXY header.
+func (m *RelayManager) readProxyHeader(c net.Conn) (net.Conn, error) {
+	if c.LocalAddr().Network() != "unix" && !proxyproto.Trusted(c.RemoteAddr(), m.TrustedProxies) {
+		return nil, proxyproto.ErrUntrusted
+	}
+	return proxyproto.ReadHeader(c, time.Second*10)
+}
+
+// unwrapObfuscated peeks at the start of c, and unwraps it with m.Obfuscator
+// unless it is a plain relay connection.
+func (m *RelayManager) unwrapObfuscated(c net.Conn) (net.Conn, error) {
+	head := make([]byte, len(relay_protocol.RelayMagic))
+	_ = c.SetReadDeadline(time.Now().Add(time.Second * 10))
+	_, err := io.ReadFull(c, head)
+	_ = c.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	pc := &peekedConn{Conn: c, r: io.MultiReader(bytes.NewReader(head), c)}
+	if string(head) == relay_protocol.RelayMagic {
+		if m.RequireObfuscation {
+			return nil, errNotObfuscated
+		}
+		return pc, nil
+	}
+	return m.Obfuscator.Server(pc)
+}
+
+// peekedConn is a net.Conn whose first bytes were read ahead.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
 func (m *RelayManager) handleConn(c net.Conn) error {
-	// Read one relay-server frame (HandshakeRequest) + verify HMAC
+	// Read one relay-server frame (HandshakeRequest or Probe) + verify HMAC
 	hdr, data, sum, err := relay_protocol.ReadRelayFrameRaw(c, time.Second*10)
 	if err != nil {
 		return fmt.Errorf("read relay-server frame: %w", err)
 	}
+	if hdr.Type == relay_protocol.RelayTypeProbe {
+		return m.handleProbes(c, hdr, data, sum)
+	}
 	if hdr.Type != relay_protocol.RelayTypeHandshakeRequest {
 		return fmt.Errorf("unexpected relay-server frame type: %d", hdr.Type)
 	}
@@ -187,24 +748,44 @@ func (m *RelayManager) handleConn(c net.Conn) error {
 	if err := proto.Unmarshal(data, &req); err != nil {
 		return fmt.Errorf("bad handshake payload: %w", err)
 	}
+	if m.Chaos.dropHandshake() {
+		return errChaosHandshakeDropped
+	}
+
+	_, span := tracing.Tracer().Start(tracing.Extract(m.ctx, req.GetTraceContext()), tracing.SpanHandleRelayHandshake,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrStreamID.Int64(int64(req.StreamId))))
+	err = m.handleHandshake(c, hdr, data, sum, &req)
+	tracing.End(span, err)
+	return err
+}
 
+// handleHandshake validates a HandshakeRequest against its allocation, acks it and
+// attaches the connection to the allocation.
+func (m *RelayManager) handleHandshake(c net.Conn, hdr *relay_protocol.RelayHeader, data []byte, sum []byte, req *relaypb.HandshakeRequest) error {
+	received := time.Now()
 	m.mu.Lock()
 	a := m.allocations[req.StreamId]
 	m.mu.Unlock()
-	if a == nil {
-		// Ack false
-		ack := &relaypb.HandshakeAck{Ok: false, Error: "no such stream"}
-		ackBytes, _ := proto.Marshal(ack)
-		_ = relay_protocol.WriteRelayFrame(c, relay_protocol.RelayTypeHandshakeAck, make([]byte, 32), ackBytes) // bogus token; conn will close
-		return ErrAllocationNotFound
-	}
-
-	// Verify HMAC with token
-	if err := hdr.VerifyRelayHMAC(a.token, data, sum); err != nil {
-		ack := &relaypb.HandshakeAck{Ok: false, Error: "hmac mismatch"}
-		ackBytes, _ := proto.Marshal(ack)
-		_ = relay_protocol.WriteRelayFrame(c, relay_protocol.RelayTypeHandshakeAck, a.token, ackBytes)
-		return err
+
+	// Check the HMAC before anything is written back. An unknown stream is
+	// checked against rejectKey and rejected exactly like a bad HMAC: no
+	// frame, the same delay, then the connection is dropped.
+	token := m.rejectKey
+	if a != nil {
+		token = a.token
+	}
+	if err := hdr.VerifyRelayHMAC(token, data, sum); err != nil || a == nil {
+		reason := "hmac mismatch"
+		if a == nil {
+			reason = "no such stream"
+		}
+		m.logger().Debug("handshake rejected",
+			logging.KeyStreamID, req.StreamId,
+			logging.KeyRemoteAddr, c.RemoteAddr().String(),
+			"reason", reason)
+		m.rejectHandshake(received)
+		return ErrHandshakeRejected
 	}
 
 	senderPeerId, err := peer.IDFromBytes(req.SenderPeerId)
@@ -215,14 +796,37 @@ func (m *RelayManager) handleConn(c net.Conn) error {
 	isClientPeer := a.clientPeerID == senderPeerId
 
 	if !isServerPeer && !isClientPeer {
-		log.Printf("[relay-server] warning: sender_peer_id mismatch alloc=(%s, %s) got=%s", a.serverPeerID.String(), a.clientPeerID.String(), senderPeerId.String())
+		m.logger().Warn("sender peer id mismatch",
+			logging.KeyStreamID, req.StreamId,
+			logging.KeyServerPeer, a.serverPeerID.String(),
+			logging.KeyClientPeer, a.clientPeerID.String(),
+			logging.KeyPeer, senderPeerId.String(),
+			logging.KeyRemoteAddr, c.RemoteAddr().String())
 		return ErrBadPeer
 	}
 
+	a.mu.Lock()
+	sources := a.sourcesC
+	if isServerPeer {
+		sources = a.sourcesS
+	}
+	a.mu.Unlock()
+	if !sourceAllowed(sources, c.RemoteAddr()) {
+		m.logger().Warn("handshake from unexpected source",
+			logging.KeyStreamID, req.StreamId,
+			logging.KeyPeer, senderPeerId.String(),
+			logging.KeyRemoteAddr, c.RemoteAddr().String())
+		m.rejectHandshake(received)
+		return ErrSourceMismatch
+	}
+
+	wantsReady := relay_protocol.HasFeature(req.GetHello(), relay_protocol.FeatureBridgeReady)
+
 	// Ack OK
-	ack := &relaypb.HandshakeAck{Ok: true}
+	m.Chaos.delayAck(m.ctx)
+	ack := &relaypb.HandshakeAck{Ok: true, Hello: m.Hello(), ObservedAddress: observedAddress(c.RemoteAddr())}
 	ackBytes, _ := proto.Marshal(ack)
-	if err := relay_protocol.WriteRelayFrame(c, relay_protocol.RelayTypeHandshakeAck, a.token, ackBytes); err != nil {
+	if err := relay_protocol.WriteRelayFrameTo(c, req.GetHello(), relay_protocol.RelayTypeHandshakeAck, a.token, ackBytes); err != nil {
 		return fmt.Errorf("write ack: %w", err)
 	}
 
@@ -234,34 +838,118 @@ func (m *RelayManager) handleConn(c net.Conn) error {
 			return errors.New("server already bridged")
 		}
 		a.sideS = c
+		a.readyS = wantsReady
+		a.helloS = req.GetHello()
 	} else {
 		if a.sideC != nil {
 			return errors.New("client already bridged")
 		}
 		a.sideC = c
+		a.readyC = wantsReady
+		a.helloC = req.GetHello()
 	}
 
+	m.logger().Debug("handshake accepted",
+		logging.KeyStreamID, req.StreamId,
+		logging.KeyPeer, senderPeerId.String(),
+		logging.KeyRemoteAddr, c.RemoteAddr().String(),
+		"is_server", isServerPeer)
+
 	if a.sideS != nil && a.sideC != nil {
 		// Bridge and remove allocation when both sides finish.
-		go m.startBridge(req.StreamId, a)
+		m.bridges.Add(1)
+		go func() {
+			defer m.bridges.Done()
+			m.startBridge(req.StreamId, a)
+		}()
+	}
+
+	return nil
+}
+
+// rejectHandshake waits until handshakeRejectDelay after received, or until m
+// stops. The caller then closes the connection without writing to it.
+func (m *RelayManager) rejectHandshake(received time.Time) {
+	t := time.NewTimer(time.Until(received.Add(handshakeRejectDelay)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-m.ctx.Done():
 	}
+}
 
+// signalReady writes a Ready frame to the sides waiting for one, before any
+// data of the other side.
+func (a *allocation) signalReady() error {
+	for _, side := range []struct {
+		conn  net.Conn
+		ready bool
+		hello *controlpb.Hello
+	}{{a.sideS, a.readyS, a.helloS}, {a.sideC, a.readyC, a.helloC}} {
+		if !side.ready {
+			continue
+		}
+		_ = side.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		err := relay_protocol.WriteRelayFrameTo(side.conn, side.hello, relay_protocol.RelayTypeReady, a.token, nil)
+		_ = side.conn.SetWriteDeadline(time.Time{})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // startBridge runs bidirectional piping and removes the allocation after both directions finish.
 func (m *RelayManager) startBridge(id uint64, a *allocation) {
-	var wg sync.WaitGroup
+	logger := m.logger().With(
+		logging.KeyStreamID, id,
+		logging.KeyServerPeer, a.serverPeerID.String(),
+		logging.KeyClientPeer, a.clientPeerID.String())
+	logger.Info("bridge started", "priority", a.quota.Priority.String())
+	started := time.Now()
+	m.stateChanged()
+	active := m.metrics().Gauge(metrics.RelayActiveBridges)
+	active.Add(1)
+	defer active.Add(-1)
+
+	if err := a.signalReady(); err != nil {
+		logger.Warn("signal ready failed", "err", err)
+		a.closeWith(CloseReady)
+	}
+
+	var (
+		wg                 sync.WaitGroup
+		bytesC2S, bytesS2C int64
+	)
+	toServer, toClient := m.Chaos.bridgeWriters(a.sideS, a.sideC, func() {
+		logger.Warn("chaos: killing bridge", "after_bytes", m.Chaos.KillBridgeAfter)
+		a.closeWith(CloseChaos)
+	})
+	toServer, toClient = a.quota.bridgeWriters(toServer, toClient, func() {
+		logger.Info("bridge byte quota spent, closing", "max_bytes", a.quota.MaxBytes)
+		a.closeWith(CloseQuota)
+	})
+	toServer, toClient, unschedule := m.scheduler.bridgeWriters(a.quota.Priority, toServer, toClient)
+	toServer, toClient, untrack := m.trackBridge(a, toServer, toClient)
+	defer untrack()
+	defer a.quota.deadlineTimer(func() {
+		logger.Info("bridge deadline reached, closing")
+		a.closeWith(CloseDeadline)
+	})()
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		defer a.Close()
-		_, _ = io.Copy(a.sideS, a.sideC)
+		var err error
+		bytesC2S, err = io.Copy(toServer, a.sideC)
+		a.closeWith(copyCloseReason(err, CloseClient))
+		unschedule()
 	}()
 	go func() {
 		defer wg.Done()
-		defer a.Close()
-		_, _ = io.Copy(a.sideC, a.sideS)
+		var err error
+		bytesS2C, err = io.Copy(toClient, a.sideS)
+		a.closeWith(copyCloseReason(err, CloseServer))
+		unschedule()
 	}()
 	wg.Wait()
 
@@ -269,6 +957,49 @@ func (m *RelayManager) startBridge(id uint64, a *allocation) {
 	m.mu.Lock()
 	delete(m.allocations, id)
 	m.mu.Unlock()
+
+	logger.Info("bridge finished", "duration", time.Since(started))
+
+	a.mu.Lock()
+	reason := a.closeReason
+	a.mu.Unlock()
+	m.metrics().Counter(metrics.RelayBridges, reason).Add(1)
+	m.metrics().Counter(metrics.RelayBridgeBytes, "client_to_server").Add(float64(bytesC2S))
+	m.metrics().Counter(metrics.RelayBridgeBytes, "server_to_client").Add(float64(bytesS2C))
+	m.metrics().Histogram(metrics.RelayBridgeDuration).Observe(time.Since(started).Seconds())
+	m.writeAccessLog(&accessLogEntry{
+		Time:                started.UTC(),
+		Created:             a.created.UTC(),
+		StreamID:            id,
+		ServerPeerID:        m.Redaction.Peer(a.serverPeerID.String()),
+		ClientPeerID:        m.Redaction.Peer(a.clientPeerID.String()),
+		ServerAddr:          m.remoteAddr(a.sideS),
+		ClientAddr:          m.remoteAddr(a.sideC),
+		Bridged:             true,
+		BytesClientToServer: bytesC2S,
+		BytesServerToClient: bytesS2C,
+		DurationMillis:      time.Since(started).Milliseconds(),
+		CloseReason:         reason,
+	})
+}
+
+// copyCloseReason returns why a bridge direction copying from the side ended
+// with eof ended, given the error of io.Copy.
+func copyCloseReason(err error, eof string) string {
+	if err != nil {
+		return CloseError
+	}
+	return eof
+}
+
+// ExpiredAllocation is an allocation dropped by its TTL before both peers
+// connected, see RelayManager.OnExpire.
+type ExpiredAllocation struct {
+	StreamID        uint64
+	ServerPeer      peer.ID
+	ClientPeer      peer.ID
+	ServerConnected bool
+	ClientConnected bool
 }
 
 // gc removes expired allocations (TTL since creation).
@@ -276,14 +1007,30 @@ func (m *RelayManager) startBridge(id uint64, a *allocation) {
 // Only clean up unbridged (Allocated/HalfConnected) entries when TTL expires.
 func (m *RelayManager) gc() {
 	now := time.Now()
+	var dropped []*accessLogEntry
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	defer func() {
+		m.mu.Unlock()
+		m.writeAccessLog(dropped...)
+	}()
 	for id, a := range m.allocations {
 		if now.Sub(a.created) > a.ttl {
 			// If not fully bridged, close any half-connected sides and delete.
 			if a.sideS == nil || a.sideC == nil {
+				if m.OnExpire != nil {
+					go m.OnExpire(ExpiredAllocation{
+						StreamID:        id,
+						ServerPeer:      a.serverPeerID,
+						ClientPeer:      a.clientPeerID,
+						ServerConnected: a.sideS != nil,
+						ClientConnected: a.sideC != nil,
+					})
+				}
+				dropped = append(dropped, m.droppedEntry(a, now, CloseExpired))
 				a.Close()
 				delete(m.allocations, id)
+				m.stateChanged()
+				m.logger().Debug("allocation expired", logging.KeyStreamID, id)
 			}
 			// If fully bridged (both sides present), keep the allocation as-is.
 			// The bridge will close itself when either side ends, or on Stop().
@@ -291,6 +1038,28 @@ func (m *RelayManager) gc() {
 	}
 }
 
+// listenUnix listens on a unix socket at path, replacing a stale socket file left
+// behind by a previous run.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
 // randomUint64 returns a random uint64 using crypto/rand.
 func randomUint64() uint64 {
 	var b [8]byte
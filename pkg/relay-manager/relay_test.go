@@ -0,0 +1,136 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	relaypb "github.com/flymesh/core/pkg/pb/relay"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHandshakeRejection(t *testing.T) {
+	defer func(d time.Duration) { handshakeRejectDelay = d }(handshakeRejectDelay)
+	handshakeRejectDelay = 50 * time.Millisecond
+
+	m := New()
+	m.ctx = context.Background()
+	serverPeer := peer.ID("server")
+	allocs, _, _, err := m.allocate(serverPeer, peer.ID("client"), 1, time.Minute, Quota{})
+	if err != nil {
+		t.Fatalf("allocate() err = %v", err)
+	}
+	alloc := allocs[0]
+
+	tests := []struct {
+		name     string
+		streamID uint64
+		token    []byte
+	}{
+		{name: "no such stream", streamID: alloc.StreamID + 1, token: alloc.Token},
+		{name: "hmac mismatch", streamID: alloc.StreamID, token: make([]byte, 32)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := proto.Marshal(&relaypb.HandshakeRequest{StreamId: tt.streamID, SenderPeerId: []byte(serverPeer)})
+			client, server := net.Pipe()
+			defer client.Close()
+			answer := make(chan int, 1)
+			go func() {
+				_ = relay_protocol.WriteRelayFrameTo(client, nil, relay_protocol.RelayTypeHandshakeRequest, tt.token, data)
+				b, _ := io.ReadAll(client)
+				answer <- len(b)
+			}()
+
+			start := time.Now()
+			err := m.handleConn(server)
+			elapsed := time.Since(start)
+			_ = server.Close()
+			if !errors.Is(err, ErrHandshakeRejected) {
+				t.Fatalf("handleConn() err = %v, want ErrHandshakeRejected", err)
+			}
+			if elapsed < handshakeRejectDelay {
+				t.Fatalf("rejected after %s, want at least %s", elapsed, handshakeRejectDelay)
+			}
+			if n := <-answer; n != 0 {
+				t.Fatalf("client got %d bytes, want none", n)
+			}
+		})
+	}
+}
+
+// addrConn is a net.Conn with the remote address addr.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestHandshakeSourceMismatch(t *testing.T) {
+	defer func(d time.Duration) { handshakeRejectDelay = d }(handshakeRejectDelay)
+	handshakeRejectDelay = 10 * time.Millisecond
+
+	m := New()
+	m.ctx = context.Background()
+	server := mustDecodePeer(t, "12D3KooWLRPJAA5o6zfDNcEnJUWHA8FYhG9GLExsqDhVdxPA5ZUE")
+	client := mustDecodePeer(t, "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo")
+	alloc, _, err := m.CreateStream(server, client, time.Minute, Quota{})
+	if err != nil {
+		t.Fatalf("CreateStream() err = %v", err)
+	}
+	if !m.BindSources(alloc.StreamID, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, nil) {
+		t.Fatal("BindSources() = false")
+	}
+
+	data, _ := proto.Marshal(&relaypb.HandshakeRequest{StreamId: alloc.StreamID, SenderPeerId: []byte(server)})
+	c, s := net.Pipe()
+	defer c.Close()
+	answer := make(chan int, 1)
+	go func() {
+		_ = relay_protocol.WriteRelayFrameTo(c, nil, relay_protocol.RelayTypeHandshakeRequest, alloc.Token, data)
+		b, _ := io.ReadAll(c)
+		answer <- len(b)
+	}()
+	err = m.handleConn(addrConn{s, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 4000}})
+	_ = s.Close()
+	if !errors.Is(err, ErrSourceMismatch) {
+		t.Fatalf("handleConn() err = %v, want ErrSourceMismatch", err)
+	}
+	if n := <-answer; n != 0 {
+		t.Fatalf("client got %d bytes, want none", n)
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	sources := []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}
+	tests := []struct {
+		name    string
+		sources []netip.Addr
+		addr    net.Addr
+		want    bool
+	}{
+		{name: "unbound", addr: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1}, want: true},
+		{name: "ipv4", sources: sources, addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}, want: true},
+		{name: "ipv4-mapped", sources: sources, addr: &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 1}, want: true},
+		{name: "ipv6", sources: sources, addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, want: true},
+		{name: "other", sources: sources, addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}, want: false},
+		{name: "unix", sources: sources, addr: &net.UnixAddr{Name: "/run/relay.sock", Net: "unix"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceAllowed(tt.sources, tt.addr); got != tt.want {
+				t.Fatalf("sourceAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build !unix
+
+package relay_manager
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort fails: SO_REUSEPORT is a unix socket option.
+func reusePort(network string, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
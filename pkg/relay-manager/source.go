@@ -0,0 +1,52 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// BindSources restricts the handshakes of the allocation streamID to the source
+// IPs given for each side, as a second factor beyond its token. An empty list
+// leaves that side unbound. It reports false if there is no such allocation.
+func (m *RelayManager) BindSources(streamID uint64, server []netip.Addr, client []netip.Addr) bool {
+	m.mu.Lock()
+	a := m.allocations[streamID]
+	m.mu.Unlock()
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	a.sourcesS = unmapAll(server)
+	a.sourcesC = unmapAll(client)
+	a.mu.Unlock()
+	m.stateChanged()
+	return true
+}
+
+// sourceAllowed reports whether addr, the remote address of a relay
+// connection, comes from one of sources. Empty sources allow any address.
+func sourceAllowed(sources []netip.Addr, addr net.Addr) bool {
+	if len(sources) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	return slices.Contains(sources, ap.Addr().Unmap())
+}
+
+func unmapAll(addrs []netip.Addr) []netip.Addr {
+	if len(addrs) == 0 {
+		return nil
+	}
+	unmapped := make([]netip.Addr, len(addrs))
+	for i, a := range addrs {
+		unmapped[i] = a.Unmap()
+	}
+	return unmapped
+}
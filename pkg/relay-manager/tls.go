@@ -0,0 +1,34 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake of a relay connection.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// errACMEChallenge is returned by handshakeTLS for the connections of an ACME
+// CA validating a tls-alpn-01 challenge, which carry no relay traffic.
+var errACMEChallenge = errors.New("acme tls-alpn-01 challenge")
+
+func (m *RelayManager) handshakeTLS(c net.Conn, cfg *tls.Config) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, tlsHandshakeTimeout)
+	defer cancel()
+	tc := tls.Server(c, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	if tc.ConnectionState().NegotiatedProtocol == acme.ALPNProto {
+		return nil, errACMEChallenge
+	}
+	return tc, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_manager
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/flymesh/core/pkg/wsconn"
+)
+
+// DefaultWebSocketPath is the path WebSocketOptions serves when Path is empty.
+const DefaultWebSocketPath = "/flymesh-relay"
+
+// WebSocketOptions additionally accepts relay connections carried over
+// WebSocket, for peers on networks that only let HTTP(S) out.
+type WebSocketOptions struct {
+	// Listen is the TCP address of the HTTP(S) server.
+	Listen string
+	// Path is the path upgraded to WebSocket. Defaults to DefaultWebSocketPath.
+	Path string
+	// TLSConfig, if set, serves wss:// rather than ws://.
+	TLSConfig *tls.Config
+	// PublicURL is the URL announced to peers. Defaults to a URL built from
+	// Listen, or from the host of PublicAddress when Listen has none.
+	PublicURL string
+}
+
+func (o *WebSocketOptions) path() string {
+	if o.Path == "" {
+		return DefaultWebSocketPath
+	}
+	return o.Path
+}
+
+func (m *RelayManager) listenWebSocket() (net.Listener, error) {
+	o := m.WebSocket
+	ln, err := m.listen(o.Listen)
+	if err != nil {
+		return nil, err
+	}
+	return wsconn.Serve(ln, o.path(), o.TLSConfig), nil
+}
+
+// webSocketURL returns the URL peers dial the WebSocket listener on, or "" if
+// there is none.
+func (m *RelayManager) webSocketURL() string {
+	o := m.WebSocket
+	if o == nil {
+		return ""
+	}
+	if o.PublicURL != "" {
+		return o.PublicURL
+	}
+	host, port, err := net.SplitHostPort(o.Listen)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, _, err = net.SplitHostPort(m.PublicAddress); err != nil {
+			return ""
+		}
+	}
+	scheme := "ws"
+	if o.TLSConfig != nil {
+		scheme = "wss"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + o.path()
+}
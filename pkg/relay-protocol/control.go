@@ -5,15 +5,21 @@ package relay_protocol
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"time"
 
-	"github.com/libp2p/go-libp2p/core/network"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
 	"github.com/pkg/errors"
 )
 
 // Control framing: Length (LE16) + Type (LE16) + Data(Protobuf)
 // We will carry Data as raw protobuf-encoded bytes prepared by caller.
+//
+// A payload longer than 0xFFFF bytes is split over several frames for peers
+// announcing FeatureControlFragments. Every frame but the last has
+// ControlFlagFragment set in its type, and the receiver joins their data.
+// Payloads that fit one frame are unchanged on the wire.
 
 var (
 	ErrTooLarge          = errors.New("payload too large")
@@ -22,19 +28,91 @@ var (
 	ErrUnknownType       = errors.New("unsupported type")
 )
 
+const (
+	// ControlFlagFragment marks a frame whose payload continues in the next
+	// frame.
+	ControlFlagFragment uint16 = 0x8000
+	// MaxControlPayload caps the payload of a control message, fragments joined.
+	MaxControlPayload = 1 << 20
+
+	maxControlFrameData = 0xFFFF
+
+	// ControlChunkSize is the most data a frame streaming a payload carries,
+	// e.g. a FileChunk or an ExecStdout frame.
+	ControlChunkSize = 32 << 10
+)
+
 const (
 	ControlTypeStartRelayStreamRequest  uint16 = 0x0101
 	ControlTypeStartRelayStreamResponse uint16 = 0x0102
 	ControlTypeCreateStreamRequest      uint16 = 0x0201
 	ControlTypeCreateStreamResponse     uint16 = 0x0202
+	ControlTypeCreateStreamsRequest     uint16 = 0x0203
+	ControlTypeCreateStreamsResponse    uint16 = 0x0204
+	ControlTypeStreamExpired            uint16 = 0x0205
+	ControlTypeStreamOffer              uint16 = 0x0206
+	ControlTypeStreamOfferResponse      uint16 = 0x0207
+	ControlTypeNotifyRegister           uint16 = 0x0208
+	ControlTypeDialBackChallenge        uint16 = 0x0301
+	ControlTypeDialBackResponse         uint16 = 0x0302
+	ControlTypeWireGuardHello           uint16 = 0x0401
+	ControlTypeFileRequest              uint16 = 0x0501
+	ControlTypeFileHeader               uint16 = 0x0502
+	ControlTypeFileResume               uint16 = 0x0503
+	ControlTypeFileChunk                uint16 = 0x0504
+	ControlTypeFileResult               uint16 = 0x0505
+	ControlTypeExecRequest              uint16 = 0x0601
+	ControlTypeExecStdin                uint16 = 0x0602
+	ControlTypeExecStdout               uint16 = 0x0603
+	ControlTypeExecStderr               uint16 = 0x0604
+	ControlTypeExecExit                 uint16 = 0x0605
 )
 
-// WriteControlFrame writes LE16 length + LE16 type + data to w.
-// data must be the protobuf-encoded payload for the control message.
+// WriteControlFrame writes LE16 length + LE16 type + data to w in one frame.
+// data must be the protobuf-encoded payload for the control message. A payload
+// that does not fit one frame fails with ErrTooLarge, see WriteControlMessage.
 func WriteControlFrame(w io.Writer, typ uint16, data []byte) error {
-	if len(data) > 0xFFFF {
+	return WriteControlMessage(w, nil, typ, data)
+}
+
+// Message is a protobuf control message.
+type Message interface {
+	MarshalVT() ([]byte, error)
+}
+
+// WriteMessage writes msg, encoded, in a control frame of type typ.
+func WriteMessage(w io.Writer, typ uint16, msg Message) error {
+	payload, err := msg.MarshalVT()
+	if err != nil {
+		return err
+	}
+	return WriteControlFrame(w, typ, payload)
+}
+
+// WriteControlMessage writes a control message to the peer whose Hello is peer,
+// nil for a peer that sent none. data is fragmented over several frames if it
+// does not fit one and peer announces FeatureControlFragments; otherwise it
+// fails with ErrTooLarge.
+func WriteControlMessage(w io.Writer, peer *controlpb.Hello, typ uint16, data []byte) error {
+	if len(data) > MaxControlPayload {
 		return ErrTooLarge
 	}
+	if typ&ControlFlagFragment != 0 {
+		return ErrUnknownType
+	}
+	if len(data) > maxControlFrameData && !HasFeature(peer, FeatureControlFragments) {
+		return fmt.Errorf("%w: %d bytes, peer does not announce %s", ErrTooLarge, len(data), FeatureControlFragments)
+	}
+	for len(data) > maxControlFrameData {
+		if err := writeControlFrame(w, typ|ControlFlagFragment, data[:maxControlFrameData]); err != nil {
+			return err
+		}
+		data = data[maxControlFrameData:]
+	}
+	return writeControlFrame(w, typ, data)
+}
+
+func writeControlFrame(w io.Writer, typ uint16, data []byte) error {
 	var hdr [4]byte
 	binary.LittleEndian.PutUint16(hdr[0:2], uint16(len(data)))
 	binary.LittleEndian.PutUint16(hdr[2:4], typ)
@@ -48,15 +126,50 @@ func WriteControlFrame(w io.Writer, typ uint16, data []byte) error {
 	return nil
 }
 
-// ReadControlFrame reads one control frame and returns type and data bytes.
-func ReadControlFrame(r network.Stream, timeout time.Duration) (typ uint16, data []byte, err error) {
-	var hdr [4]byte
+// DeadlineReader is a reader with a read deadline, a network.Stream or a
+// net.Conn.
+type DeadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
 
+// ReadControlFrame reads one control message, joining its fragments, and
+// returns type and data bytes. timeout bounds the whole message.
+func ReadControlFrame(r DeadlineReader, timeout time.Duration) (typ uint16, data []byte, err error) {
 	_ = r.SetReadDeadline(time.Now().Add(timeout))
 	defer func() {
 		_ = r.SetReadDeadline(time.Time{})
 	}()
 
+	for {
+		frameTyp, frameData, err := readControlFrame(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		more := frameTyp&ControlFlagFragment != 0
+		frameTyp &^= ControlFlagFragment
+		if data != nil && frameTyp != typ {
+			return 0, nil, fmt.Errorf("%w: fragment of type %#04x continues type %#04x", ErrUnknownType, frameTyp, typ)
+		}
+		typ = frameTyp
+		if len(data)+len(frameData) > MaxControlPayload {
+			return 0, nil, ErrTooLarge
+		}
+		if !more {
+			if data == nil {
+				return typ, frameData, nil
+			}
+			return typ, append(data, frameData...), nil
+		}
+		if data == nil {
+			data = make([]byte, 0, 2*maxControlFrameData)
+		}
+		data = append(data, frameData...)
+	}
+}
+
+func readControlFrame(r io.Reader) (typ uint16, data []byte, err error) {
+	var hdr [4]byte
 	if _, err = io.ReadFull(r, hdr[:]); err != nil {
 		return 0, nil, err
 	}
@@ -0,0 +1,165 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// bufStream is a network.Stream reading from a buffer. Only Read and
+// SetReadDeadline are implemented.
+type bufStream struct {
+	network.Stream
+	r *bytes.Reader
+}
+
+func (s *bufStream) Read(b []byte) (int, error)      { return s.r.Read(b) }
+func (s *bufStream) SetReadDeadline(time.Time) error { return nil }
+
+func newBufStream(b []byte) *bufStream {
+	return &bufStream{r: bytes.NewReader(b)}
+}
+
+func helloWith(features ...string) *controlpb.Hello {
+	return &controlpb.Hello{Features: features}
+}
+
+func payload(n int) []byte {
+	return bytes.Repeat([]byte{0x5a}, n)
+}
+
+// frameTypes returns the types of the control frames in wire.
+func frameTypes(t *testing.T, wire []byte) (types []uint16) {
+	t.Helper()
+	for len(wire) > 0 {
+		if len(wire) < 4 {
+			t.Fatalf("truncated frame header: %d bytes", len(wire))
+		}
+		n := int(binary.LittleEndian.Uint16(wire[0:2]))
+		types = append(types, binary.LittleEndian.Uint16(wire[2:4]))
+		wire = wire[4+n:]
+	}
+	return types
+}
+
+func TestControlMessageRoundTrip(t *testing.T) {
+	fragments := helloWith(FeatureControlFragments)
+	tests := []struct {
+		name   string
+		peer   *controlpb.Hello
+		size   int
+		frames int
+		err    error
+	}{
+		{name: "empty", size: 0, frames: 1},
+		{name: "one frame", size: 100, frames: 1},
+		{name: "largest frame", size: maxControlFrameData, frames: 1},
+		{name: "largest frame, fragments announced", peer: fragments, size: maxControlFrameData, frames: 1},
+		{name: "two fragments", peer: fragments, size: maxControlFrameData + 1, frames: 2},
+		{name: "exact fragments", peer: fragments, size: 3 * maxControlFrameData, frames: 3},
+		{name: "largest payload", peer: fragments, size: MaxControlPayload, frames: MaxControlPayload/maxControlFrameData + 1},
+		{name: "over a frame, no Hello", size: maxControlFrameData + 1, err: ErrTooLarge},
+		{name: "over a frame, fragments not announced", peer: helloWith(FeatureRelayFrameV2), size: maxControlFrameData + 1, err: ErrTooLarge},
+		{name: "over the payload limit", peer: fragments, size: MaxControlPayload + 1, err: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			data := payload(tt.size)
+			err := WriteControlMessage(&wire, tt.peer, ControlTypeCreateStreamRequest, data)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("WriteControlMessage() err = %v, want %v", err, tt.err)
+				}
+				if wire.Len() != 0 {
+					t.Fatalf("WriteControlMessage() wrote %d bytes on failure", wire.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteControlMessage() err = %v", err)
+			}
+			types := frameTypes(t, wire.Bytes())
+			if len(types) != tt.frames {
+				t.Fatalf("wrote %d frames, want %d", len(types), tt.frames)
+			}
+			for i, typ := range types {
+				want := ControlTypeCreateStreamRequest
+				if i < len(types)-1 {
+					want |= ControlFlagFragment
+				}
+				if typ != want {
+					t.Fatalf("frame %d type = %#04x, want %#04x", i, typ, want)
+				}
+			}
+			typ, got, err := ReadControlFrame(newBufStream(wire.Bytes()), time.Second)
+			if err != nil {
+				t.Fatalf("ReadControlFrame() err = %v", err)
+			}
+			if typ != ControlTypeCreateStreamRequest {
+				t.Fatalf("ReadControlFrame() type = %#04x", typ)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("ReadControlFrame() data of %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestWriteControlFrameRejectsFragmentFlag(t *testing.T) {
+	var wire bytes.Buffer
+	err := WriteControlFrame(&wire, ControlTypeCreateStreamRequest|ControlFlagFragment, nil)
+	if !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("WriteControlFrame() err = %v, want ErrUnknownType", err)
+	}
+}
+
+func TestReadControlFrameRejects(t *testing.T) {
+	frame := func(typ uint16, data []byte) []byte {
+		var b bytes.Buffer
+		if err := writeControlFrame(&b, typ, data); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	var oversized []byte
+	for n := 0; n <= MaxControlPayload; n += maxControlFrameData {
+		oversized = append(oversized, frame(ControlTypeCreateStreamRequest|ControlFlagFragment, payload(maxControlFrameData))...)
+	}
+	oversized = append(oversized, frame(ControlTypeCreateStreamRequest, nil)...)
+
+	tests := []struct {
+		name string
+		wire []byte
+		err  error
+	}{
+		{
+			name: "fragment type changes",
+			wire: append(frame(ControlTypeCreateStreamRequest|ControlFlagFragment, payload(10)), frame(ControlTypeDialBackResponse, payload(10))...),
+			err:  ErrUnknownType,
+		},
+		{name: "joined payload over the limit", wire: oversized, err: ErrTooLarge},
+		{name: "truncated header", wire: []byte{1, 0, 1}},
+		{name: "truncated data", wire: frame(ControlTypeCreateStreamRequest, payload(10))[:8]},
+		{name: "last fragment missing", wire: frame(ControlTypeCreateStreamRequest|ControlFlagFragment, payload(10))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ReadControlFrame(newBufStream(tt.wire), time.Second)
+			if err == nil {
+				t.Fatal("ReadControlFrame() err = nil")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ReadControlFrame() err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_protocol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/protocol"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Hello exchange:
+//
+// A peer that registers protocol.ProtoInfo understands Hello frames. The peer
+// opening a control exchange with it sends a Hello frame right before its
+// request, and the other peer answers with its own Hello right before its
+// response. Exchanges with peers that do not register protocol.ProtoInfo are
+// unchanged, so peers that predate Hello keep working.
+
+// ProtocolVersion is the wire protocol version of this build. Peers that send
+// no Hello speak version 1.
+const ProtocolVersion = 2
+
+// ControlTypeHello is the control frame carrying a Hello.
+const ControlTypeHello uint16 = 0x0001
+
+// Features a peer announces in its Hello.
+const (
+	// FeatureControlFragments is the fragmentation of control payloads over
+	// 0xFFFF bytes.
+	FeatureControlFragments = "control-fragments"
+	// FeatureRelayFrameV2 is the relay frame with a 32-bit length.
+	FeatureRelayFrameV2 = "relay-frame-v2"
+	// FeatureBatchAllocation is CreateStreamsRequest.
+	FeatureBatchAllocation = "batch-allocation"
+	// FeatureRetryCookie is the retry of failed relay dials with a retry cookie.
+	FeatureRetryCookie = "retry-cookie"
+	// FeatureDestination is the destination carried by StartRelayStreamRequest.
+	FeatureDestination = "destination"
+	// FeatureDirectStream is protocol.ProtoServerDirect.
+	FeatureDirectStream = "direct-stream"
+	// FeatureBridgeQuota is the byte and time bound of a bridge carried by
+	// CreateStreamRequest.
+	FeatureBridgeQuota = "bridge-quota"
+	// FeatureBridgeReady is the Ready relay frame signaling that both peers of
+	// an allocation connected.
+	FeatureBridgeReady = "bridge-ready"
+	// FeatureSessionBinding is the binding of the Noise handshake of a relayed
+	// stream to its allocation, asked for in StartRelayStreamRequest.
+	FeatureSessionBinding = "session-binding"
+	// FeatureCompression is the compression of relayed streams, negotiated in
+	// StartRelayStreamRequest.
+	FeatureCompression = "compression"
+	// FeatureProbe is the Probe relay frame, answered by relay-servers before
+	// any allocation.
+	FeatureProbe = "probe"
+	// FeatureBond is the striping of a stream over paths requested with the
+	// same StartRelayStreamRequest.bond.
+	FeatureBond = "bond"
+	// FeatureStreamExpired is the StreamExpired notification, sent by
+	// relay-servers to server peers announcing it.
+	FeatureStreamExpired = "stream-expired"
+	// FeatureStreamOffer is the allocation of a stream offered to the peer it
+	// names, asked for with CreateStreamRequest.offer.
+	FeatureStreamOffer = "stream-offer"
+	// FeatureRelayNotify is protocol.ProtoRelayNotify, and the stream requests
+	// of clients notified to the servers registered over it.
+	FeatureRelayNotify = "relay-notify"
+)
+
+// helloKey is the peerstore metadata key of the Hello of a peer.
+const helloKey = "flymesh/hello"
+
+// NewHello returns the Hello of this build with limits, completed by the
+// framing limits. A Hello describes the whole peer, so all the roles of a host
+// announce the same one.
+func NewHello(limits *controlpb.Limits) *controlpb.Hello {
+	if limits == nil {
+		limits = &controlpb.Limits{}
+	}
+	limits.MaxControlPayload = MaxControlPayload
+	limits.MaxRelayPayload = MaxRelayPayload
+	return &controlpb.Hello{
+		ProtocolVersion: ProtocolVersion,
+		Features: []string{
+			FeatureControlFragments,
+			FeatureRelayFrameV2,
+			FeatureBatchAllocation,
+			FeatureRetryCookie,
+			FeatureDestination,
+			FeatureDirectStream,
+			FeatureBridgeQuota,
+			FeatureBridgeReady,
+			FeatureSessionBinding,
+			FeatureCompression,
+			FeatureProbe,
+			FeatureBond,
+			FeatureStreamExpired,
+			FeatureStreamOffer,
+			FeatureRelayNotify,
+		},
+		Limits:    limits,
+		RelayMacs: macIDs(defaultMACs),
+	}
+}
+
+// HasFeature reports whether hello announces feature. A nil hello, from a peer
+// that sent none, announces nothing.
+func HasFeature(hello *controlpb.Hello, feature string) bool {
+	return slices.Contains(hello.GetFeatures(), feature)
+}
+
+// PeerHello returns the last Hello received from p, or nil if none was.
+func PeerHello(h host.Host, p peer.ID) *controlpb.Hello {
+	v, err := h.Peerstore().Get(p, helloKey)
+	if err != nil {
+		return nil
+	}
+	hello, _ := v.(*controlpb.Hello)
+	return hello
+}
+
+// SupportsHello reports whether p announced protocol.ProtoInfo, and so
+// understands Hello frames.
+func SupportsHello(h host.Host, p peer.ID) bool {
+	protos, err := h.Peerstore().SupportsProtocols(p, protocol.ProtoInfo)
+	return err == nil && len(protos) > 0
+}
+
+// WriteHello writes hello as a control frame.
+func WriteHello(w io.Writer, hello *controlpb.Hello) error {
+	data, err := hello.MarshalVT()
+	if err != nil {
+		return fmt.Errorf("marshal Hello: %w", err)
+	}
+	return WriteControlFrame(w, ControlTypeHello, data)
+}
+
+// WriteControlRequest starts a control exchange on s, which h opened: it writes
+// hello if the remote peer understands it, then the request, fragmented if the
+// last Hello of the remote peer allows.
+func WriteControlRequest(h host.Host, s network.Stream, hello *controlpb.Hello, typ uint16, data []byte) error {
+	p := s.Conn().RemotePeer()
+	if SupportsHello(h, p) {
+		if err := WriteHello(s, hello); err != nil {
+			return err
+		}
+	}
+	return WriteControlMessage(s, PeerHello(h, p), typ, data)
+}
+
+// WriteControlResponse writes the response of a control exchange the remote
+// peer opened on s, fragmented if the Hello of the remote peer allows.
+func WriteControlResponse(h host.Host, s network.Stream, typ uint16, data []byte) error {
+	return WriteControlMessage(s, PeerHello(h, s.Conn().RemotePeer()), typ, data)
+}
+
+// ReadControlRequest reads the request of a control exchange the remote peer
+// opened on s. If the request is preceded by a Hello, it is remembered for the
+// peer and answered with hello.
+func ReadControlRequest(h host.Host, s network.Stream, hello *controlpb.Hello, timeout time.Duration) (typ uint16, data []byte, err error) {
+	peerHello, typ, data, err := readControlMessage(h, s, timeout)
+	if err != nil {
+		return 0, nil, err
+	}
+	if peerHello != nil {
+		if err := WriteHello(s, hello); err != nil {
+			return 0, nil, err
+		}
+	}
+	return typ, data, nil
+}
+
+// ReadControlResponse reads the response of a control exchange h opened on s,
+// remembering the Hello of the remote peer if one precedes it.
+func ReadControlResponse(h host.Host, s network.Stream, timeout time.Duration) (typ uint16, data []byte, err error) {
+	_, typ, data, err = readControlMessage(h, s, timeout)
+	return typ, data, err
+}
+
+func readControlMessage(h host.Host, s network.Stream, timeout time.Duration) (hello *controlpb.Hello, typ uint16, data []byte, err error) {
+	deadline := time.Now().Add(timeout)
+	typ, data, err = ReadControlFrame(s, timeout)
+	if err != nil || typ != ControlTypeHello {
+		return nil, typ, data, err
+	}
+	if hello, err = rememberHello(h, s.Conn().RemotePeer(), data); err != nil {
+		return nil, 0, nil, err
+	}
+	typ, data, err = ReadControlFrame(s, time.Until(deadline))
+	return hello, typ, data, err
+}
+
+// readHello reads a Hello frame alone on s and remembers it.
+func readHello(h host.Host, s network.Stream, timeout time.Duration) (*controlpb.Hello, error) {
+	typ, data, err := ReadControlFrame(s, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if typ != ControlTypeHello {
+		return nil, fmt.Errorf("%w: 0x%04x, want Hello", ErrUnknownType, typ)
+	}
+	return rememberHello(h, s.Conn().RemotePeer(), data)
+}
+
+func rememberHello(h host.Host, p peer.ID, data []byte) (*controlpb.Hello, error) {
+	hello := &controlpb.Hello{}
+	if err := hello.UnmarshalVT(data); err != nil {
+		return nil, fmt.Errorf("decode Hello: %w", err)
+	}
+	_ = h.Peerstore().Put(p, helloKey, hello)
+	return hello, nil
+}
+
+// RegisterInfo answers Hello exchanges on protocol.ProtoInfo with hello.
+func RegisterInfo(h host.Host, hello *controlpb.Hello) {
+	h.SetStreamHandler(protocol.ProtoInfo, func(s network.Stream) {
+		defer s.Close()
+		if _, err := readHello(h, s, 10*time.Second); err != nil {
+			_ = s.Reset()
+			return
+		}
+		_ = WriteHello(s, hello)
+	})
+}
+
+// GetInfo exchanges Hello messages with p and returns the Hello of p.
+func GetInfo(ctx context.Context, h host.Host, p peer.ID, hello *controlpb.Hello) (*controlpb.Hello, error) {
+	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "info"), p, protocol.ProtoInfo)
+	if err != nil {
+		return nil, fmt.Errorf("open info stream: %w", err)
+	}
+	defer s.Close()
+	if err := WriteHello(s, hello); err != nil {
+		return nil, err
+	}
+	peerHello, err := readHello(h, s, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("read Hello: %w", err)
+	}
+	return peerHello, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"lukechampine.com/blake3"
+)
+
+// ErrUnknownMAC is returned for a version 0x03 relay frame naming a MAC
+// algorithm this build does not implement.
+var ErrUnknownMAC = errors.New("unknown mac algorithm")
+
+// MAC is the algorithm authenticating a relay frame. Frames of versions 0x01
+// and 0x02 always use MACHMACSHA256; version 0x03 frames name theirs.
+type MAC byte
+
+const (
+	// MACHMACSHA256 is HMAC-SHA256 keyed with the token.
+	MACHMACSHA256 MAC = 0x01
+	// MACBLAKE3 is keyed BLAKE3, cheaper than HMAC-SHA256 without SHA
+	// instructions. Its key is derived from the token, of any length.
+	MACBLAKE3 MAC = 0x02
+)
+
+// macSize is the size of the MAC of every algorithm.
+const macSize = 32
+
+// blake3KeyContext is the BLAKE3 key derivation context of MACBLAKE3 keys.
+const blake3KeyContext = "flymesh 2025 relay frame mac"
+
+// macNames names the algorithms this build implements.
+var macNames = map[MAC]string{
+	MACHMACSHA256: "hmac-sha256",
+	MACBLAKE3:     "blake3",
+}
+
+// defaultMACs is the order of preference NewHello announces. HMAC-SHA256
+// comes first, so that the frames written to peers that configure nothing
+// stay readable by the peers that predate version 0x03.
+var defaultMACs = []MAC{MACHMACSHA256, MACBLAKE3}
+
+func (m MAC) String() string {
+	if name, ok := macNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("mac(0x%02x)", byte(m))
+}
+
+// ParseMACs returns the algorithms named, hmac-sha256 or blake3, in order.
+func ParseMACs(names []string) ([]MAC, error) {
+	macs := make([]MAC, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(defaultMACs, func(m MAC) bool { return macNames[m] == name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownMAC, name)
+		}
+		macs = append(macs, defaultMACs[i])
+	}
+	return macs, nil
+}
+
+// WithMACs sets the MAC algorithms hello announces, most preferred first.
+// Empty macs keeps the default of NewHello. It returns hello.
+func WithMACs(hello *controlpb.Hello, macs []MAC) *controlpb.Hello {
+	if len(macs) == 0 {
+		return hello
+	}
+	hello.RelayMacs = macIDs(macs)
+	return hello
+}
+
+// macIDs returns macs as Hello carries them.
+func macIDs(macs []MAC) []uint32 {
+	ids := make([]uint32, len(macs))
+	for i, m := range macs {
+		ids[i] = uint32(m)
+	}
+	return ids
+}
+
+// peerMAC returns the algorithm to authenticate the frames written to the peer
+// whose Hello is peer with: the first one it announces that this build
+// implements, MACHMACSHA256 for a peer announcing none.
+func peerMAC(peer *controlpb.Hello) MAC {
+	for _, m := range peer.GetRelayMacs() {
+		if _, ok := macNames[MAC(m)]; ok && m <= 0xFF {
+			return MAC(m)
+		}
+	}
+	return MACHMACSHA256
+}
+
+// newMAC returns the keyed hash of m.
+func (m MAC) newMAC(token []byte) (hash.Hash, error) {
+	switch m {
+	case MACHMACSHA256:
+		return hmac.New(sha256.New, token), nil
+	case MACBLAKE3:
+		key := make([]byte, 32)
+		blake3.DeriveKey(key, blake3KeyContext, token)
+		return blake3.New(macSize, key), nil
+	}
+	return nil, fmt.Errorf("%w: 0x%02x", ErrUnknownMAC, byte(m))
+}
@@ -4,15 +4,15 @@
 package relay_protocol
 
 import (
-	"bytes"
 	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
 )
 
 var (
@@ -25,56 +25,118 @@ var (
 //
 // Magic "FLYR" (4B)
 // Length (LE16) -- length of Data only (does NOT include header/HMAC)
-// Version (1B) -- fixed 0x01
+// Version (1B) -- 0x01, 0x02 for frames with a 32-bit length, or 0x03 for frames also naming their MAC algorithm
 // Type (1B)
+// LengthHigh (LE16) -- versions 0x02 and 0x03: high 16 bits of the length of Data
+// MAC (1B) -- version 0x03 only: the MAC algorithm, see MAC
 // Data (NB) -- protobuf-encoded payload
-// HMAC (32B) -- HMAC-SHA256(key=token, msg = Magic||Length||Version||Type||Data)
+// HMAC (32B) -- MAC(key=token, msg = every field before HMAC), HMAC-SHA256 before version 0x03
+//
+// Writers use version 0x02 only for data longer than 0xFFFF bytes, and only to
+// peers announcing FeatureRelayFrameV2, so frames stay readable by peers that
+// predate version 0x02. They use version 0x03 only to peers whose Hello
+// prefers another MAC algorithm than HMAC-SHA256, see Hello.relay_macs.
+//
+// The MAC algorithm is negotiated by the relay handshake: a peer writes its
+// HandshakeRequest with HMAC-SHA256, since it does not know the algorithms of
+// the relay-server yet, and the relay-server writes HandshakeAck and Ready
+// with the first algorithm of the Hello of the request it implements.
 //
 // Types:
 //
 //	0x01 HandshakeRequest
 //	0x02 HandshakeAck
+//	0x03 Ready -- no data; sent when the bridge starts to the peers whose
+//	     HandshakeRequest announced FeatureBridgeReady
+//	0x04 Probe -- may open a connection instead of a HandshakeRequest
+//	0x05 ProbeReply -- sent at once for every Probe
+//
+// Probe frames belong to no allocation: their HMAC is keyed with ProbeToken
+// and only detects corruption.
 const (
-	relayMagic   = "FLYR"
-	relayVersion = byte(0x01)
+	// RelayMagic starts every relay frame, and so every plain connection to a
+	// relay-server.
+	RelayMagic    = "FLYR"
+	relayVersion  = byte(0x01)
+	relayVersion2 = byte(0x02)
+	relayVersion3 = byte(0x03)
+
+	// MaxRelayPayload caps the data of a relay frame.
+	MaxRelayPayload = 1 << 20
 
 	RelayTypeHandshakeRequest = byte(0x01)
 	RelayTypeHandshakeAck     = byte(0x02)
+	RelayTypeReady            = byte(0x03)
+	RelayTypeProbe            = byte(0x04)
+	RelayTypeProbeReply       = byte(0x05)
 )
 
+// ProbeToken keys the HMAC of Probe and ProbeReply frames.
+var ProbeToken = []byte{}
+
 type RelayHeader struct {
-	Length  uint16
+	Length  uint32
 	Version byte
 	Type    byte
+	// MAC is the algorithm of the HMAC field, MACHMACSHA256 before version
+	// 0x03.
+	MAC MAC
+}
+
+// encode appends the header fields covered by the HMAC to buf, in wire order.
+func (h *RelayHeader) encode(buf []byte) []byte {
+	buf = append(buf, RelayMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(h.Length))
+	buf = append(buf, h.Version, h.Type)
+	if h.Version >= relayVersion2 {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(h.Length>>16))
+	}
+	if h.Version == relayVersion3 {
+		buf = append(buf, byte(h.MAC))
+	}
+	return buf
 }
 
 // WriteRelayFrame writes one relay-server frame with computed HMAC.
-// token is required to compute HMAC.
+// token is required to compute HMAC. data longer than 0xFFFF bytes fails, see
+// WriteRelayFrameTo.
 func WriteRelayFrame(w io.Writer, typ byte, token []byte, data []byte) error {
-	if len(data) > 0xFFFF {
+	return WriteRelayFrameTo(w, nil, typ, token, data)
+}
+
+// WriteRelayFrameTo is WriteRelayFrame to the peer whose Hello is peer, nil for
+// a peer that sent none. data longer than 0xFFFF bytes goes in a version 0x02
+// frame if peer announces FeatureRelayFrameV2, and fails otherwise. The frame
+// is a version 0x03 one if peer prefers another MAC algorithm than
+// HMAC-SHA256.
+func WriteRelayFrameTo(w io.Writer, peer *controlpb.Hello, typ byte, token []byte, data []byte) error {
+	if len(data) > MaxRelayPayload {
 		return fmt.Errorf("relay-server frame too large: %d", len(data))
 	}
 	hdr := &RelayHeader{
-		Length:  uint16(len(data)),
+		Length:  uint32(len(data)),
 		Version: relayVersion,
 		Type:    typ,
+		MAC:     peerMAC(peer),
 	}
-	// RelayHeader
-	buf := bytes.NewBuffer(nil)
-	buf.Grow(4 + 2 + 1 + 1 + len(data) + 32)
-	buf.WriteString(relayMagic)
-	var le [2]byte
-	binary.LittleEndian.PutUint16(le[:], hdr.Length)
-	buf.Write(le[:])
-	buf.WriteByte(hdr.Version)
-	buf.WriteByte(hdr.Type)
-	if len(data) > 0 {
-		buf.Write(data)
+	if hdr.MAC != MACHMACSHA256 {
+		hdr.Version = relayVersion3
+	} else if len(data) > 0xFFFF {
+		if !HasFeature(peer, FeatureRelayFrameV2) {
+			return fmt.Errorf("relay-server frame too large: %d, peer does not announce %s", len(data), FeatureRelayFrameV2)
+		}
+		hdr.Version = relayVersion2
+	}
+	sum, err := buildRelayHMAC(token, hdr, data)
+	if err != nil {
+		return err
 	}
-	h := buildRelayHMAC(token, hdr, data)
-	buf.Write(h)
+	buf := make([]byte, 0, 4+2+1+1+2+1+len(data)+macSize)
+	buf = hdr.encode(buf)
+	buf = append(buf, data...)
+	buf = append(buf, sum...)
 
-	_, err := w.Write(buf.Bytes())
+	_, err = w.Write(buf)
 	return err
 }
 
@@ -91,7 +153,7 @@ func ReadRelayFrameRaw(r net.Conn, timeout time.Duration) (hdr *RelayHeader, dat
 	if _, err = io.ReadFull(r, magic[:]); err != nil {
 		return
 	}
-	if string(magic[:]) != relayMagic {
+	if string(magic[:]) != RelayMagic {
 		err = ErrBadMagic
 		return
 	}
@@ -102,14 +164,14 @@ func ReadRelayFrameRaw(r net.Conn, timeout time.Duration) (hdr *RelayHeader, dat
 	if _, err = io.ReadFull(r, le[:]); err != nil {
 		return
 	}
-	hdr.Length = binary.LittleEndian.Uint16(le[:])
+	hdr.Length = uint32(binary.LittleEndian.Uint16(le[:]))
 
 	var ver [1]byte
 	if _, err = io.ReadFull(r, ver[:]); err != nil {
 		return
 	}
 	hdr.Version = ver[0]
-	if hdr.Version != relayVersion {
+	if hdr.Version != relayVersion && hdr.Version != relayVersion2 && hdr.Version != relayVersion3 {
 		err = ErrBadVersion
 		return
 	}
@@ -118,6 +180,28 @@ func ReadRelayFrameRaw(r net.Conn, timeout time.Duration) (hdr *RelayHeader, dat
 		return
 	}
 	hdr.Type = typ[0]
+	hdr.MAC = MACHMACSHA256
+	if hdr.Version >= relayVersion2 {
+		if _, err = io.ReadFull(r, le[:]); err != nil {
+			return
+		}
+		hdr.Length |= uint32(binary.LittleEndian.Uint16(le[:])) << 16
+		if hdr.Length > MaxRelayPayload {
+			err = fmt.Errorf("relay-server frame too large: %d", hdr.Length)
+			return
+		}
+	}
+	if hdr.Version == relayVersion3 {
+		var mac [1]byte
+		if _, err = io.ReadFull(r, mac[:]); err != nil {
+			return
+		}
+		hdr.MAC = MAC(mac[0])
+		if _, ok := macNames[hdr.MAC]; !ok {
+			err = fmt.Errorf("%w: 0x%02x", ErrUnknownMAC, mac[0])
+			return
+		}
+	}
 
 	if hdr.Length > 0 {
 		data = make([]byte, int(hdr.Length))
@@ -125,34 +209,40 @@ func ReadRelayFrameRaw(r net.Conn, timeout time.Duration) (hdr *RelayHeader, dat
 			return
 		}
 	}
-	hmacSum = make([]byte, 32)
+	hmacSum = make([]byte, macSize)
 	if _, err = io.ReadFull(r, hmacSum); err != nil {
 		return
 	}
 	return
 }
 
-// VerifyRelayHMAC verifies the relay-server HMAC using token. Returns ErrHMACMismatch if invalid.
+// VerifyRelayHMAC verifies the relay-server HMAC using token, with the MAC
+// algorithm of h. Returns ErrHMACMismatch if invalid.
 func (h *RelayHeader) VerifyRelayHMAC(token []byte, data []byte, got []byte) error {
-	want := buildRelayHMAC(token, h, data)
+	want, err := buildRelayHMAC(token, h, data)
+	if err != nil {
+		return err
+	}
 	if !hmac.Equal(want, got) {
 		return ErrHMACMismatch
 	}
 	return nil
 }
 
-// buildRelayHMAC computes HMAC per spec using token as key.
-func buildRelayHMAC(token []byte, hdr *RelayHeader, data []byte) []byte {
-	mac := hmac.New(sha256.New, token)
-	// Magic
-	mac.Write([]byte(relayMagic))
-	// Length LE16
-	var le [2]byte
-	binary.LittleEndian.PutUint16(le[:], hdr.Length)
-	mac.Write(le[:])
-	// Version, Type
-	mac.Write([]byte{hdr.Version, hdr.Type})
+// buildRelayHMAC computes the MAC of hdr per spec using token as key.
+func buildRelayHMAC(token []byte, hdr *RelayHeader, data []byte) ([]byte, error) {
+	algorithm := hdr.MAC
+	if hdr.Version != relayVersion3 {
+		algorithm = MACHMACSHA256
+	}
+	mac, err := algorithm.newMAC(token)
+	if err != nil {
+		return nil, err
+	}
+	// Magic, Length, Version, Type and, from version 0x02, LengthHigh, and
+	// for version 0x03, MAC
+	mac.Write(hdr.encode(make([]byte, 0, 11)))
 	// Data
 	mac.Write(data)
-	return mac.Sum(nil)
+	return mac.Sum(nil), nil
 }
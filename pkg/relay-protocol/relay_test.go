@@ -0,0 +1,204 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+)
+
+// bufConn is a net.Conn reading from a buffer. Only Read and SetReadDeadline
+// are implemented.
+type bufConn struct {
+	net.Conn
+	r *bytes.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error)      { return c.r.Read(b) }
+func (c *bufConn) SetReadDeadline(time.Time) error { return nil }
+
+func newBufConn(b []byte) *bufConn {
+	return &bufConn{r: bytes.NewReader(b)}
+}
+
+func TestRelayFrameRoundTrip(t *testing.T) {
+	token := []byte("0123456789abcdef0123456789abcdef")
+	v2 := helloWith(FeatureRelayFrameV2)
+	b3 := WithMACs(helloWith(), []MAC{MACBLAKE3, MACHMACSHA256})
+	tests := []struct {
+		name    string
+		peer    *controlpb.Hello
+		size    int
+		version byte
+		header  int
+		mac     MAC
+		tooBig  bool
+	}{
+		{name: "empty", size: 0, version: relayVersion, header: 8},
+		{name: "v1", size: 100, version: relayVersion, header: 8},
+		{name: "largest v1", size: 0xFFFF, version: relayVersion, header: 8},
+		{name: "largest v1, v2 announced", peer: v2, size: 0xFFFF, version: relayVersion, header: 8},
+		{name: "v2", peer: v2, size: 0x10000, version: relayVersion2, header: 10},
+		{name: "largest v2", peer: v2, size: MaxRelayPayload, version: relayVersion2, header: 10},
+		{name: "hmac-sha256 preferred", peer: NewHello(nil), size: 100, version: relayVersion, header: 8},
+		{name: "blake3", peer: b3, size: 100, version: relayVersion3, header: 11, mac: MACBLAKE3},
+		{name: "largest blake3", peer: b3, size: MaxRelayPayload, version: relayVersion3, header: 11, mac: MACBLAKE3},
+		{name: "unknown mac skipped", peer: &controlpb.Hello{RelayMacs: []uint32{0x7f, 0x102, uint32(MACBLAKE3)}}, size: 1, version: relayVersion3, header: 11, mac: MACBLAKE3},
+		{name: "over v1, no Hello", size: 0x10000, tooBig: true},
+		{name: "over v1, v2 not announced", peer: helloWith(FeatureControlFragments), size: 0x10000, tooBig: true},
+		{name: "over the payload limit", peer: v2, size: MaxRelayPayload + 1, tooBig: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			data := payload(tt.size)
+			err := WriteRelayFrameTo(&wire, tt.peer, RelayTypeHandshakeRequest, token, data)
+			if tt.tooBig {
+				if err == nil {
+					t.Fatal("WriteRelayFrameTo() err = nil")
+				}
+				if wire.Len() != 0 {
+					t.Fatalf("WriteRelayFrameTo() wrote %d bytes on failure", wire.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteRelayFrameTo() err = %v", err)
+			}
+			if n := wire.Len(); n != tt.header+tt.size+32 {
+				t.Fatalf("frame of %d bytes, want %d", n, tt.header+tt.size+32)
+			}
+			if v := wire.Bytes()[6]; v != tt.version {
+				t.Fatalf("version %#02x, want %#02x", v, tt.version)
+			}
+			hdr, got, sum, err := ReadRelayFrameRaw(newBufConn(wire.Bytes()), time.Second)
+			if err != nil {
+				t.Fatalf("ReadRelayFrameRaw() err = %v", err)
+			}
+			if tt.mac == 0 {
+				tt.mac = MACHMACSHA256
+			}
+			if hdr.Version != tt.version || hdr.Type != RelayTypeHandshakeRequest || int(hdr.Length) != tt.size || hdr.MAC != tt.mac {
+				t.Fatalf("header %+v", hdr)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("data of %d bytes, want %d", len(got), len(data))
+			}
+			if err := hdr.VerifyRelayHMAC(token, got, sum); err != nil {
+				t.Fatalf("VerifyRelayHMAC() err = %v", err)
+			}
+			if err := hdr.VerifyRelayHMAC([]byte("another token"), got, sum); !errors.Is(err, ErrHMACMismatch) {
+				t.Fatalf("VerifyRelayHMAC() with another token err = %v", err)
+			}
+		})
+	}
+}
+
+func TestReadRelayFrameRejects(t *testing.T) {
+	frame := func(size int) []byte {
+		var b bytes.Buffer
+		if err := WriteRelayFrameTo(&b, helloWith(FeatureRelayFrameV2), RelayTypeProbe, ProbeToken, payload(size)); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	with := func(b []byte, f func([]byte)) []byte {
+		b = bytes.Clone(b)
+		f(b)
+		return b
+	}
+	tests := []struct {
+		name string
+		wire []byte
+		err  error
+	}{
+		{name: "bad magic", wire: with(frame(1), func(b []byte) { b[0] = 'X' }), err: ErrBadMagic},
+		{name: "bad version", wire: with(frame(1), func(b []byte) { b[6] = 4 }), err: ErrBadVersion},
+		{name: "unknown mac", wire: with(blake3Frame(t), func(b []byte) { b[10] = 0x7f }), err: ErrUnknownMAC},
+		{name: "v2 length over the limit", wire: with(frame(0x10000), func(b []byte) {
+			binary.LittleEndian.PutUint16(b[8:10], uint16((MaxRelayPayload+1)>>16))
+		})},
+		{name: "truncated header", wire: frame(1)[:7]},
+		{name: "truncated v2 header", wire: frame(0x10000)[:9]},
+		{name: "truncated v3 header", wire: blake3Frame(t)[:10]},
+		{name: "truncated data", wire: frame(100)[:50]},
+		{name: "truncated HMAC", wire: frame(100)[:8+100+31]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := ReadRelayFrameRaw(newBufConn(tt.wire), time.Second)
+			if err == nil {
+				t.Fatal("ReadRelayFrameRaw() err = nil")
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("ReadRelayFrameRaw() err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRelayHMACCoversLengthHigh(t *testing.T) {
+	var wire bytes.Buffer
+	if err := WriteRelayFrameTo(&wire, helloWith(FeatureRelayFrameV2), RelayTypeHandshakeRequest, []byte("token"), payload(0x10000)); err != nil {
+		t.Fatal(err)
+	}
+	hdr, data, sum, err := ReadRelayFrameRaw(newBufConn(wire.Bytes()), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr.Version = relayVersion
+	if err := hdr.VerifyRelayHMAC([]byte("token"), data, sum); !errors.Is(err, ErrHMACMismatch) {
+		t.Fatalf("VerifyRelayHMAC() of a v1 header err = %v, want ErrHMACMismatch", err)
+	}
+}
+
+// blake3Frame returns a version 0x03 Probe frame authenticated with BLAKE3.
+func blake3Frame(t *testing.T) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := WriteRelayFrameTo(&b, WithMACs(helloWith(), []MAC{MACBLAKE3}), RelayTypeProbe, ProbeToken, payload(10)); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestRelayMACCoversAlgorithm(t *testing.T) {
+	wire := blake3Frame(t)
+	hdr, data, sum, err := ReadRelayFrameRaw(newBufConn(wire), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hdr.VerifyRelayHMAC(ProbeToken, data, sum); err != nil {
+		t.Fatalf("VerifyRelayHMAC() err = %v", err)
+	}
+	hdr.MAC = MACHMACSHA256
+	if err := hdr.VerifyRelayHMAC(ProbeToken, data, sum); !errors.Is(err, ErrHMACMismatch) {
+		t.Fatalf("VerifyRelayHMAC() as HMAC-SHA256 err = %v, want ErrHMACMismatch", err)
+	}
+}
+
+func TestParseMACs(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []MAC
+		wantErr bool
+	}{
+		{names: nil, want: []MAC{}},
+		{names: []string{"blake3", "hmac-sha256"}, want: []MAC{MACBLAKE3, MACHMACSHA256}},
+		{names: []string{"hmac-md5"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMACs(tt.names)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !slices.Equal(got, tt.want)) {
+			t.Errorf("ParseMACs(%q) = %v, %v, want %v", tt.names, got, err, tt.want)
+		}
+	}
+}
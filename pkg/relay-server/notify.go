@@ -0,0 +1,166 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// errNotRegistered is returned for a stream request notified to a server that
+// is not registered for notifications.
+var errNotRegistered = errors.New("server not registered for notifications")
+
+// notifyKeepalive is the interval of the keepalives sent to registered
+// servers, which they expect within twice as long.
+const notifyKeepalive = 30 * time.Second
+
+// registration is the notify stream of a registered server. Its exchanges,
+// offers and keepalives, take turns under mu.
+type registration struct {
+	mu       sync.Mutex
+	s        network.Stream
+	done     chan struct{}
+	dropOnce sync.Once
+}
+
+// notifyRegistry holds the servers registered for notifications over
+// protocol.ProtoRelayNotify.
+type notifyRegistry struct {
+	mu   sync.Mutex
+	regs map[peer.ID]*registration
+}
+
+func newNotifyRegistry() *notifyRegistry {
+	return &notifyRegistry{regs: make(map[peer.ID]*registration)}
+}
+
+// add registers s for p, replacing an earlier registration of p.
+func (r *notifyRegistry) add(p peer.ID, s network.Stream) *registration {
+	reg := &registration{s: s, done: make(chan struct{})}
+	r.mu.Lock()
+	old := r.regs[p]
+	r.regs[p] = reg
+	r.mu.Unlock()
+	if old != nil {
+		r.drop(p, old)
+	}
+	return reg
+}
+
+func (r *notifyRegistry) get(p peer.ID) *registration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.regs[p]
+}
+
+// drop unregisters reg of p, unless a later registration replaced it, and
+// resets its stream.
+func (r *notifyRegistry) drop(p peer.ID, reg *registration) {
+	r.mu.Lock()
+	if r.regs[p] == reg {
+		delete(r.regs, p)
+	}
+	r.mu.Unlock()
+	reg.dropOnce.Do(func() {
+		close(reg.done)
+		_ = reg.s.Reset()
+	})
+}
+
+// handleRegister registers the server of s for notifications and keeps the
+// registration alive until the stream fails, the server registers again or ctx
+// ends.
+func (r *notifyRegistry) handleRegister(ctx context.Context, logger *slog.Logger, h host.Host, rm *relay_manager.RelayManager, s network.Stream) {
+	serverPeer := s.Conn().RemotePeer()
+	logger = logger.With(logging.KeyPeer, serverPeer.String())
+	typ, _, err := relay_protocol.ReadControlRequest(h, s, rm.Hello(), 10*time.Second)
+	if err == nil && typ != relay_protocol.ControlTypeNotifyRegister {
+		err = fmt.Errorf("unexpected control frame type 0x%04x", typ)
+	}
+	if err == nil && rm.PeerVerifier != nil {
+		if verr := rm.PeerVerifier.VerifyPeer(ctx, serverPeer); verr != nil {
+			err = fmt.Errorf("%w: %v", errNotVerified, verr)
+		}
+	}
+	if err != nil {
+		logger.Warn("notify registration refused", "err", err)
+		_ = s.Reset()
+		return
+	}
+
+	reg := r.add(serverPeer, s)
+	defer r.drop(serverPeer, reg)
+	if err := r.keepalive(h, reg); err != nil {
+		logger.Warn("notify registration failed", "err", err)
+		return
+	}
+	logger.Info("server registered for notifications")
+	ticker := time.NewTicker(notifyKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.keepalive(h, reg); err != nil {
+				logger.Info("server notifications ended", "err", err)
+				return
+			}
+		case <-reg.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// keepalive sends a keepalive, which also acknowledges the registration, on
+// reg.
+func (r *notifyRegistry) keepalive(h host.Host, reg *registration) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_ = reg.s.SetWriteDeadline(time.Now().Add(offerTimeout))
+	defer func() { _ = reg.s.SetWriteDeadline(time.Time{}) }()
+	return relay_protocol.WriteControlResponse(h, reg.s, relay_protocol.ControlTypeNotifyRegister, nil)
+}
+
+// notify offers the allocation of offer to serverPeer on its registration, and
+// returns once serverPeer accepted it.
+func (r *notifyRegistry) notify(h host.Host, serverPeer peer.ID, offer *controlpb.StreamOffer) error {
+	reg := r.get(serverPeer)
+	if reg == nil {
+		return errNotRegistered
+	}
+	payload, err := offer.MarshalVT()
+	if err != nil {
+		return fmt.Errorf("marshal StreamOffer: %w", err)
+	}
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_ = reg.s.SetWriteDeadline(time.Now().Add(offerTimeout))
+	err = relay_protocol.WriteControlResponse(h, reg.s, relay_protocol.ControlTypeStreamOffer, payload)
+	_ = reg.s.SetWriteDeadline(time.Time{})
+	if err != nil {
+		r.drop(serverPeer, reg)
+		return fmt.Errorf("write StreamOffer: %w", err)
+	}
+	typ, data, err := relay_protocol.ReadControlFrame(reg.s, offerTimeout)
+	if err != nil {
+		// The answer may still come and be taken for the next one.
+		r.drop(serverPeer, reg)
+		return fmt.Errorf("read StreamOfferResponse: %w", err)
+	}
+	return offerResult(typ, data)
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/protocol"
+	relay_manager "github.com/flymesh/core/pkg/relay-manager"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestNotifyRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	relay, server := mn.Hosts()[0], mn.Hosts()[1]
+	rm := relay_manager.New()
+	registry := newNotifyRegistry()
+	answered := make(chan struct{})
+	relay.SetStreamHandler(protocol.ProtoRelayNotify, func(s network.Stream) {
+		registry.handleRegister(ctx, slog.Default(), relay, rm, s)
+	})
+
+	offer := &controlpb.StreamOffer{StreamId: 7}
+	if err := registry.notify(relay, server.ID(), offer); !errors.Is(err, errNotRegistered) {
+		t.Fatalf("notify() before registration err = %v, want errNotRegistered", err)
+	}
+
+	s, err := server.NewStream(ctx, relay.ID(), protocol.ProtoRelayNotify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := relay_protocol.WriteControlRequest(server, s, relay_protocol.NewHello(nil), relay_protocol.ControlTypeNotifyRegister, nil); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, err := relay_protocol.ReadControlResponse(server, s, time.Second); err != nil || typ != relay_protocol.ControlTypeNotifyRegister {
+		t.Fatalf("registration ack type 0x%04x, err = %v", typ, err)
+	}
+	go func() {
+		defer close(answered)
+		for _, resp := range []*controlpb.StreamOfferResponse{
+			{Ok: true},
+			{Error: "no such service", ErrorCode: controlpb.ErrorCode_ERROR_CODE_UNKNOWN_TARGET},
+		} {
+			typ, data, err := relay_protocol.ReadControlFrame(s, time.Second)
+			if err != nil || typ != relay_protocol.ControlTypeStreamOffer {
+				t.Errorf("offer type 0x%04x, err = %v", typ, err)
+				return
+			}
+			var got controlpb.StreamOffer
+			if err := got.UnmarshalVT(data); err != nil || got.GetStreamId() != offer.GetStreamId() {
+				t.Errorf("offer %v, err = %v", &got, err)
+			}
+			payload, _ := resp.MarshalVT()
+			if err := relay_protocol.WriteControlResponse(server, s, relay_protocol.ControlTypeStreamOfferResponse, payload); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	if err := registry.notify(relay, server.ID(), offer); err != nil {
+		t.Fatalf("accepted notify() err = %v", err)
+	}
+	err = registry.notify(relay, server.ID(), offer)
+	if !errors.Is(err, errOfferDeclined) || errorCode(err) != controlpb.ErrorCode_ERROR_CODE_UNKNOWN_TARGET {
+		t.Fatalf("declined notify() err = %v, code %v", err, errorCode(err))
+	}
+	<-answered
+}
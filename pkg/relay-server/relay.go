@@ -5,79 +5,503 @@ package relay_server
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/flymesh/core/p2p"
+	"github.com/flymesh/core/pkg/logging"
 	"github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/policy"
 	"github.com/flymesh/core/pkg/protocol"
 	relay_manager "github.com/flymesh/core/pkg/relay-manager"
 	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	"github.com/libp2p/go-libp2p/core/network"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Run starts the relay-server mode handlers on the given node.
-func Run(ctx context.Context, node *p2p.Node, listenAddress string, publicAddress string) {
+// Run starts rm on listenAddress and registers the relay-server mode handlers on the given node.
+func Run(ctx context.Context, node *p2p.Node, rm *relay_manager.RelayManager, listenAddress string) {
+	logger := logging.Component(nil, "relay-server")
+
+	onExpire := rm.OnExpire
+	rm.OnExpire = func(e relay_manager.ExpiredAllocation) {
+		if onExpire != nil {
+			onExpire(e)
+		}
+		notifyExpired(ctx, logger, node, rm, e)
+	}
+
 	// Start TCP RelayManager
-	rm := relay_manager.New()
-	rm.PublicAddress = publicAddress
 	if err := rm.Start(ctx, listenAddress); err != nil {
-		log.Fatalf("relay-server manager start failed: %+v", err)
+		logging.Fatal("relay-server manager start failed", "err", err)
 	}
-	log.Printf("RelayManager started on %s", listenAddress)
+	logger.Info("RelayManager started", "addr", listenAddress)
+
+	relay_protocol.RegisterInfo(node.Host, rm.Hello())
+
+	// Handle /flymesh/1.0/relay-server/notify
+	registry := newNotifyRegistry()
+	node.Host.SetStreamHandler(protocol.ProtoRelayNotify, func(s network.Stream) {
+		registry.handleRegister(ctx, logger, node.Host, rm, s)
+	})
 
 	// Handle /flymesh/1.0/relay-server/create-stream
 	node.Host.SetStreamHandler(protocol.ProtoRelayCreate, func(s network.Stream) {
 		defer s.Close()
 
 		remotePeer := s.Conn().RemotePeer()
-		log.Printf("[relay-server] create-stream from %s", s.Conn().RemotePeer())
+		logger := logger.With(logging.KeyPeer, remotePeer.String())
+		logger.Info("create-stream request")
 
-		// Read one control frame (CreateStreamRequest)
-		typ, data, err := relay_protocol.ReadControlFrame(s, time.Second*10)
+		// Read one control frame (CreateStreamRequest or CreateStreamsRequest)
+		typ, data, err := relay_protocol.ReadControlRequest(node.Host, s, rm.Hello(), time.Second*10)
 		if err != nil {
-			log.Printf("[relay-server] read control frame failed: %v", err)
+			logger.Warn("read control frame failed", "err", err)
 			return
 		}
-		if typ != relay_protocol.ControlTypeCreateStreamRequest {
-			log.Printf("[relay-server] unexpected type: 0x%04x", typ)
-			return
+		switch typ {
+		case relay_protocol.ControlTypeCreateStreamRequest:
+			handleCreateStream(ctx, logger, node.Host, rm, registry, s, data)
+		case relay_protocol.ControlTypeCreateStreamsRequest:
+			handleCreateStreams(ctx, logger, node.Host, rm, s, data)
+		default:
+			logger.Warn("unexpected control frame type", "type", typ)
 		}
-		var req controlpb.CreateStreamRequest
-		if data != nil {
-			if err := req.UnmarshalVT(data); err != nil {
-				log.Printf("[relay-server] bad CreateStreamRequest: %v", err)
-				return
-			}
+	})
+}
+
+// notifyExpired sends StreamExpired for e to its server peer, on its current
+// connection, if it announced FeatureStreamExpired.
+func notifyExpired(ctx context.Context, logger *slog.Logger, node *p2p.Node, rm *relay_manager.RelayManager, e relay_manager.ExpiredAllocation) {
+	h := node.Host
+	if !relay_protocol.HasFeature(relay_protocol.PeerHello(h, e.ServerPeer), relay_protocol.FeatureStreamExpired) {
+		return
+	}
+	logger = logger.With(logging.KeyPeer, e.ServerPeer.String(), logging.KeyStreamID, e.StreamID)
+	msg := controlpb.StreamExpired{
+		StreamId:        e.StreamID,
+		ServerConnected: e.ServerConnected,
+		ClientConnected: e.ClientConnected,
+	}
+	msg.ClientPeerId, _ = e.ClientPeer.Marshal()
+	payload, err := msg.MarshalVT()
+	if err != nil {
+		logger.Error("marshal StreamExpired failed", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	ctx = network.WithNoDial(network.WithAllowLimitedConn(ctx, "notify"), "notify")
+	s, err := h.NewStream(ctx, e.ServerPeer, protocol.ProtoServerNotify)
+	if err != nil {
+		logger.Debug("notify expired allocation failed", "err", err)
+		return
+	}
+	defer s.Close()
+	if err := relay_protocol.WriteControlRequest(h, s, rm.Hello(), relay_protocol.ControlTypeStreamExpired, payload); err != nil {
+		logger.Debug("notify expired allocation failed", "err", err)
+		return
+	}
+	// Let the server answer the Hello and read the notification before the
+	// stream goes away.
+	_ = s.CloseWrite()
+	_ = s.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, _ = io.Copy(io.Discard, io.LimitReader(s, relay_protocol.MaxControlPayload))
+	logger.Debug("server notified of expired allocation")
+}
+
+// errNotVerified is returned when the PeerVerifier rejects the requesting peer.
+var errNotVerified = errors.New("peer not verified")
+
+// errOfferDeclined is returned when the peer offered an allocation declines it.
+var errOfferDeclined = errors.New("offer declined")
+
+// declinedError is an offer declined by the peer, with its error code.
+type declinedError struct {
+	code    controlpb.ErrorCode
+	message string
+}
+
+func (e *declinedError) Error() string {
+	return errOfferDeclined.Error() + ": " + e.message
+}
+
+func (e *declinedError) Is(target error) bool {
+	return target == errOfferDeclined
+}
+
+// offerTimeout bounds the StreamOffer exchange, well within the time the
+// requesting peer waits for its CreateStreamResponse.
+const offerTimeout = 5 * time.Second
+
+// newStreamOffer returns the StreamOffer of alloc, requested by requester.
+// expires is when alloc is dropped unless both peers connect.
+func newStreamOffer(rm *relay_manager.RelayManager, requester peer.ID, alloc relay_manager.StreamAllocation, endpoint string, expires time.Time) *controlpb.StreamOffer {
+	msg := &controlpb.StreamOffer{
+		StreamId:       alloc.StreamID,
+		Token:          alloc.Token,
+		RelayEndpoint:  endpoint,
+		RelayEndpoints: rm.Endpoints(),
+		ExpiresUnixMs:  expires.UnixMilli(),
+	}
+	msg.PeerId, _ = requester.Marshal()
+	return msg
+}
+
+// offerStream sends offer to peerID over its current connection, and returns
+// once peerID accepted it.
+func offerStream(ctx context.Context, h host.Host, rm *relay_manager.RelayManager, peerID peer.ID, offer *controlpb.StreamOffer) error {
+	payload, err := offer.MarshalVT()
+	if err != nil {
+		return fmt.Errorf("marshal StreamOffer: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, offerTimeout)
+	defer cancel()
+	ctx = network.WithNoDial(network.WithAllowLimitedConn(ctx, "offer"), "offer")
+	s, err := h.NewStream(ctx, peerID, protocol.ProtoPeerOffer)
+	if err != nil {
+		return fmt.Errorf("open stream-offer: %w", err)
+	}
+	defer s.Close()
+	if err := relay_protocol.WriteControlRequest(h, s, rm.Hello(), relay_protocol.ControlTypeStreamOffer, payload); err != nil {
+		return fmt.Errorf("write StreamOffer: %w", err)
+	}
+	typ, data, err := relay_protocol.ReadControlResponse(h, s, offerTimeout)
+	if err != nil {
+		return fmt.Errorf("read StreamOfferResponse: %w", err)
+	}
+	return offerResult(typ, data)
+}
+
+// offerResult decodes the StreamOfferResponse of an offer, returning a
+// *declinedError if the peer declined it.
+func offerResult(typ uint16, data []byte) error {
+	if typ != relay_protocol.ControlTypeStreamOfferResponse {
+		return fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var resp controlpb.StreamOfferResponse
+	if err := resp.UnmarshalVT(data); err != nil {
+		return fmt.Errorf("decode StreamOfferResponse: %w", err)
+	}
+	if !resp.GetOk() {
+		return &declinedError{code: resp.GetErrorCode(), message: resp.GetError()}
+	}
+	return nil
+}
+
+// PolicyAttrs are the attributes of the create-stream requests that
+// RelayManager.Policy is evaluated over:
+//
+//   - action: "create-stream", "create-streams", "offer-stream", a
+//     create-stream request offered to the client peer, or "notify-stream", a
+//     request of the client peer notified to the server peer
+//   - peer: the server peer of the allocation, which asks for it unless
+//     notified
+//   - client_peer: the client peer the allocation is for
+//   - count: the number of allocations asked for
+//   - max_bytes: the byte quota asked for the bridge, 0 for none
+//   - allocations: the allocations the relay holds
+var PolicyAttrs = []string{"action", "peer", "client_peer", "count", "max_bytes", "allocations"}
+
+// checkPolicy evaluates rm.Policy over a create-stream request.
+func checkPolicy(rm *relay_manager.RelayManager, action string, serverPeer, clientPeer peer.ID, count int, maxBytes int64) error {
+	p := rm.Settings().Policy
+	if p == nil {
+		return nil
+	}
+	allocations, _ := rm.Load()
+	return p.Check(policy.Attrs{
+		"action":      action,
+		"peer":        serverPeer.String(),
+		"client_peer": clientPeer.String(),
+		"count":       count,
+		"max_bytes":   maxBytes,
+		"allocations": allocations,
+	}, time.Now())
+}
+
+// errorCode classifies the failure of a create-stream request.
+func errorCode(err error) controlpb.ErrorCode {
+	var declined *declinedError
+	if errors.As(err, &declined) && declined.code != controlpb.ErrorCode_ERROR_CODE_UNSPECIFIED {
+		return declined.code
+	}
+	switch {
+	case errors.Is(err, errNotVerified), errors.Is(err, policy.ErrDenied), errors.Is(err, errOfferDeclined):
+		return controlpb.ErrorCode_ERROR_CODE_UNAUTHORIZED
+	case errors.Is(err, relay_manager.ErrTooManyAllocations):
+		return controlpb.ErrorCode_ERROR_CODE_QUOTA_EXCEEDED
+	case errors.Is(err, relay_manager.ErrShuttingDown):
+		return controlpb.ErrorCode_ERROR_CODE_SHUTTING_DOWN
+	case errors.Is(err, errNotRegistered):
+		return controlpb.ErrorCode_ERROR_CODE_UNKNOWN_TARGET
+	case errors.Is(err, relay_manager.ErrBadCount):
+		return controlpb.ErrorCode_ERROR_CODE_BAD_REQUEST
+	default:
+		return controlpb.ErrorCode_ERROR_CODE_UNSPECIFIED
+	}
+}
+
+// quotaOf returns the bound and priority of the bridge req asks for.
+func quotaOf(req *controlpb.CreateStreamRequest) relay_manager.Quota {
+	var q relay_manager.Quota
+	q.MaxBytes = int64(min(req.GetMaxBytes(), math.MaxInt64))
+	if ms := req.GetDeadlineUnixMs(); ms > 0 {
+		q.Deadline = time.UnixMilli(ms)
+	}
+	switch req.GetPriority() {
+	case controlpb.StreamPriority_STREAM_PRIORITY_INTERACTIVE:
+		q.Priority = relay_manager.PriorityInteractive
+	case controlpb.StreamPriority_STREAM_PRIORITY_BULK:
+		q.Priority = relay_manager.PriorityBulk
+	}
+	return q
+}
+
+func handleCreateStream(ctx context.Context, logger *slog.Logger, h host.Host, rm *relay_manager.RelayManager, registry *notifyRegistry, s network.Stream, data []byte) {
+	remotePeer := s.Conn().RemotePeer()
+	var req controlpb.CreateStreamRequest
+	if data != nil {
+		if err := req.UnmarshalVT(data); err != nil {
+			logger.Warn("bad CreateStreamRequest", "err", err)
+			return
 		}
+	}
 
-		clientPeerId, err := peer.IDFromBytes(req.GetClientPeerId())
+	spanCtx, span := tracing.Tracer().Start(tracing.Extract(ctx, req.GetTraceContext()), tracing.SpanHandleCreateStream,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrPeer.String(remotePeer.String())))
+	defer span.End()
+
+	clientPeerId, err := peer.IDFromBytes(req.GetClientPeerId())
+	if err != nil {
+		logger.Warn("invalid client peer id", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
+
+	var (
+		alloc       relay_manager.StreamAllocation
+		tcpEndpoint string
+	)
+	if rm.PeerVerifier != nil {
+		if verr := rm.PeerVerifier.VerifyPeer(spanCtx, remotePeer); verr != nil {
+			err = fmt.Errorf("%w: %v", errNotVerified, verr)
+		}
+	}
+	// A notified request comes from the client peer of the allocation.
+	action, serverPeer, clientPeer := "create-stream", remotePeer, clientPeerId
+	switch {
+	case req.GetNotify() != nil:
+		action, serverPeer, clientPeer = "notify-stream", clientPeerId, remotePeer
+		if err == nil && registry.get(serverPeer) == nil {
+			err = errNotRegistered
+		}
+	case req.GetOffer():
+		action = "offer-stream"
+	}
+	if err == nil {
+		err = checkPolicy(rm, action, serverPeer, clientPeer, 1, quotaOf(&req).MaxBytes)
+	}
+	if err == nil && len(req.GetRetryCookie()) > 0 {
+		// The client failed to dial the earlier allocation: drop it now rather
+		// than at TTL expiry.
+		if old, ok := rm.Supersede(serverPeer, clientPeer, req.GetRetryCookie()); ok {
+			logger.Info("allocation superseded by retry", logging.KeyStreamID, old)
+		}
+	}
+	ttl := rm.Settings().StreamTTL
+	if err == nil {
+		alloc, tcpEndpoint, err = rm.CreateStream(serverPeer, clientPeer, ttl, quotaOf(&req))
+	}
+	if err == nil {
+		bindObservedSources(h, rm, serverPeer, clientPeer, alloc)
+	}
+	if err == nil && action != "create-stream" {
+		offer := newStreamOffer(rm, remotePeer, alloc, tcpEndpoint, time.Now().Add(ttl))
+		if req.GetNotify() != nil {
+			offer.Request = req.GetNotify()
+			err = registry.notify(h, serverPeer, offer)
+		} else {
+			err = offerStream(spanCtx, h, rm, clientPeer, offer)
+		}
 		if err != nil {
-			log.Printf("[relay-server] invalid peer id: %+v", err)
+			rm.Supersede(serverPeer, clientPeer, alloc.RetryCookie)
+			alloc, tcpEndpoint = relay_manager.StreamAllocation{}, ""
+		}
+	}
+	resp := controlpb.CreateStreamResponse{
+		Ok:            err == nil,
+		Error:         "",
+		StreamId:      alloc.StreamID,
+		Token:         alloc.Token,
+		RelayEndpoint: tcpEndpoint,
+		RetryCookie:   alloc.RetryCookie,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorCode = errorCode(err)
+		tracing.Fail(span, err)
+	} else {
+		resp.RelayEndpoints = rm.Endpoints()
+		resp.ObservedAddress = observedAddress(s.Conn().RemoteMultiaddr())
+		span.SetAttributes(tracing.AttrStreamID.Int64(int64(alloc.StreamID)))
+	}
+	payload, err := resp.MarshalVT()
+	if err != nil {
+		logger.Error("marshal CreateStreamResponse failed", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
+	if err := relay_protocol.WriteControlResponse(h, s, relay_protocol.ControlTypeCreateStreamResponse, payload); err != nil {
+		logger.Warn("write CreateStreamResponse failed", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
+	if resp.Ok {
+		logger.Info("stream created", logging.KeyStreamID, alloc.StreamID, logging.KeyClientPeer, clientPeer.String(), "action", action)
+	}
+}
+
+func handleCreateStreams(ctx context.Context, logger *slog.Logger, h host.Host, rm *relay_manager.RelayManager, s network.Stream, data []byte) {
+	remotePeer := s.Conn().RemotePeer()
+	var req controlpb.CreateStreamsRequest
+	if data != nil {
+		if err := req.UnmarshalVT(data); err != nil {
+			logger.Warn("bad CreateStreamsRequest", "err", err)
 			return
 		}
+	}
+
+	spanCtx, span := tracing.Tracer().Start(tracing.Extract(ctx, req.GetTraceContext()), tracing.SpanHandleCreateStreams,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrPeer.String(remotePeer.String())))
+	defer span.End()
+
+	clientPeerId, err := peer.IDFromBytes(req.GetClientPeerId())
+	if err != nil {
+		logger.Warn("invalid client peer id", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
 
-		streamID, token, tcpEndpoint, err := rm.CreateStream(remotePeer, clientPeerId, time.Minute)
-		resp := controlpb.CreateStreamResponse{
-			Ok:            err == nil,
-			Error:         "",
-			StreamId:      streamID,
-			Token:         token,
-			RelayEndpoint: tcpEndpoint,
+	var (
+		allocs      []relay_manager.StreamAllocation
+		expires     time.Time
+		tcpEndpoint string
+	)
+	if rm.PeerVerifier != nil {
+		if verr := rm.PeerVerifier.VerifyPeer(spanCtx, remotePeer); verr != nil {
+			err = fmt.Errorf("%w: %v", errNotVerified, verr)
 		}
-		if err != nil {
-			resp.Error = err.Error()
+	}
+	if err == nil {
+		err = checkPolicy(rm, "create-streams", remotePeer, clientPeerId, int(req.GetCount()), 0)
+	}
+	if err == nil {
+		allocs, expires, tcpEndpoint, err = rm.CreateStreams(remotePeer, clientPeerId, int(req.GetCount()), rm.Settings().StreamTTL)
+	}
+	if err == nil {
+		bindObservedSources(h, rm, remotePeer, clientPeerId, allocs...)
+	}
+	resp := controlpb.CreateStreamsResponse{
+		Ok:            err == nil,
+		RelayEndpoint: tcpEndpoint,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorCode = errorCode(err)
+		tracing.Fail(span, err)
+	} else {
+		resp.ExpiresUnixMs = expires.UnixMilli()
+		resp.RelayEndpoints = rm.Endpoints()
+		resp.ObservedAddress = observedAddress(s.Conn().RemoteMultiaddr())
+		for _, a := range allocs {
+			resp.Streams = append(resp.Streams, &controlpb.StreamAllocation{
+				StreamId:    a.StreamID,
+				Token:       a.Token,
+				RetryCookie: a.RetryCookie,
+			})
 		}
-		payload, err := resp.MarshalVT()
+	}
+	payload, err := resp.MarshalVT()
+	if err != nil {
+		logger.Error("marshal CreateStreamsResponse failed", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
+	if err := relay_protocol.WriteControlResponse(h, s, relay_protocol.ControlTypeCreateStreamsResponse, payload); err != nil {
+		logger.Warn("write CreateStreamsResponse failed", "err", err)
+		tracing.Fail(span, err)
+		return
+	}
+	if resp.Ok {
+		logger.Info("streams created", "count", len(allocs), logging.KeyClientPeer, clientPeerId.String())
+	}
+}
+
+// bindObservedSources binds allocs to the IPs of the direct connections of h
+// to their peers, if rm.BindObservedSources is set.
+func bindObservedSources(h host.Host, rm *relay_manager.RelayManager, serverPeer peer.ID, clientPeer peer.ID, allocs ...relay_manager.StreamAllocation) {
+	if !rm.BindObservedSources {
+		return
+	}
+	server := observedIPs(h, serverPeer)
+	client := observedIPs(h, clientPeer)
+	for _, a := range allocs {
+		rm.BindSources(a.StreamID, server, client)
+	}
+}
+
+// observedIPs returns the remote IPs of the direct connections of h to p.
+func observedIPs(h host.Host, p peer.ID) []netip.Addr {
+	var ips []netip.Addr
+	for _, c := range h.Network().ConnsToPeer(p) {
+		maddr := c.RemoteMultiaddr()
+		if _, err := maddr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+			continue
+		}
+		ip, err := manet.ToIP(maddr)
 		if err != nil {
-			log.Printf("[relay-server] marshal CreateStreamResponse failed: %v", err)
-			return
+			continue
 		}
-		if err := relay_protocol.WriteControlFrame(s, relay_protocol.ControlTypeCreateStreamResponse, payload); err != nil {
-			log.Printf("[relay-server] write CreateStreamResponse failed: %v", err)
-			return
+		if addr, ok := netip.AddrFromSlice(ip); ok && !slices.Contains(ips, addr.Unmap()) {
+			ips = append(ips, addr.Unmap())
 		}
-	})
+	}
+	return ips
+}
+
+// observedAddress returns the ip:port of the TCP or UDP connection maddr is
+// the remote address of, or "" for relayed connections.
+func observedAddress(maddr ma.Multiaddr) string {
+	if _, err := maddr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return ""
+	}
+	ip, err := manet.ToIP(maddr)
+	if err != nil {
+		return ""
+	}
+	port, err := maddr.ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		if port, err = maddr.ValueForProtocol(ma.P_UDP); err != nil {
+			return ""
+		}
+	}
+	return net.JoinHostPort(ip.String(), port)
 }
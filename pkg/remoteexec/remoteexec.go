@@ -0,0 +1,123 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package remoteexec runs commands on a remote peer over flymesh streams: a
+// client sends a command to a Server, streams its stdin there, and gets its
+// stdout, stderr and exit code back.
+//
+// The stdin of the command travels in ExecStdin frames, an empty one closing
+// it, and its output in ExecStdout and ExecStderr frames. The Server ends the
+// stream with an ExecExit once the command exits.
+package remoteexec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+// ALPN marks the streams carrying a command.
+const ALPN = "flymesh-exec"
+
+// ErrRefused is returned for a command the Server refused or could not start.
+var ErrRefused = errors.New("command refused")
+
+// Command is a command to run on a Server.
+type Command struct {
+	// Argv is the program, looked up in the PATH of the Server, and its
+	// arguments.
+	Argv []string
+	// Env lists environment variables, KEY=VALUE, added to those of the
+	// Server.
+	Env []string
+	// Dir is the working directory of the command, empty for that of the
+	// Server.
+	Dir string
+	// Stdin, if set, is streamed to the command, which reads EOF after it.
+	Stdin io.Reader
+	// Stdout and Stderr receive the output of the command. Nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run runs cmd on the Server at the other end of conn and returns its exit
+// code, -1 if a signal killed it. A command the Server refused or could not
+// start fails with an error wrapping ErrRefused.
+//
+// Run returns once the command exits, leaving the goroutine streaming
+// cmd.Stdin until its pending read returns.
+func Run(conn net.Conn, cmd *Command) (int, error) {
+	req := &controlpb.ExecRequest{Argv: cmd.Argv, Env: cmd.Env, Dir: cmd.Dir}
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeExecRequest, req); err != nil {
+		return -1, err
+	}
+	go func() { _ = sendStdin(conn, cmd.Stdin) }()
+
+	stdout, stderr := orDiscard(cmd.Stdout), orDiscard(cmd.Stderr)
+	for {
+		typ, data, err := relay_protocol.ReadControlFrame(noDeadline{conn}, 0)
+		if err != nil {
+			return -1, err
+		}
+		switch typ {
+		case relay_protocol.ControlTypeExecStdout:
+			_, err = stdout.Write(data)
+		case relay_protocol.ControlTypeExecStderr:
+			_, err = stderr.Write(data)
+		case relay_protocol.ControlTypeExecExit:
+			var exit controlpb.ExecExit
+			if err := exit.UnmarshalVT(data); err != nil {
+				return -1, fmt.Errorf("decode ExecExit: %w", err)
+			}
+			if exit.GetError() != "" {
+				return -1, fmt.Errorf("%w: %s", ErrRefused, exit.GetError())
+			}
+			return int(exit.GetCode()), nil
+		default:
+			return -1, fmt.Errorf("unexpected type 0x%04x", typ)
+		}
+		if err != nil {
+			return -1, err
+		}
+	}
+}
+
+// sendStdin streams r to w in ExecStdin frames, then closes the stdin of the
+// command with an empty one. A nil r closes it right away.
+func sendStdin(w io.Writer, r io.Reader) error {
+	if r != nil {
+		buf := make([]byte, relay_protocol.ControlChunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				if err := relay_protocol.WriteControlFrame(w, relay_protocol.ControlTypeExecStdin, buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	return relay_protocol.WriteControlFrame(w, relay_protocol.ControlTypeExecStdin, nil)
+}
+
+func orDiscard(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// noDeadline reads a stream without the deadline of ReadControlFrame: a
+// command may stay silent for long.
+type noDeadline struct {
+	net.Conn
+}
+
+func (noDeadline) SetReadDeadline(time.Time) error { return nil }
@@ -0,0 +1,162 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package remoteexec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flymesh/core/pkg/flymeshtest"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// helperEnv makes the test binary act as the command it names.
+const helperEnv = "REMOTEEXEC_HELPER"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	case "fail":
+		fmt.Fprint(os.Stderr, "boom")
+		os.Exit(3)
+	case "hang":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+// serve serves one command of s in the background and returns its error
+// channel.
+func serve(t *testing.T, s *Server) (net.Conn, <-chan error) {
+	t.Helper()
+	return flymeshtest.ServeConn(t, func(conn net.Conn) error {
+		return s.Serve(context.Background(), conn, peer.ID("client"))
+	})
+}
+
+func helper(t *testing.T, mode string) []string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []string{exe, "-test.run=^$", mode}
+}
+
+func TestRun(t *testing.T) {
+	input := strings.Repeat("flymesh ", 3*relay_protocol.ControlChunkSize/8)
+	notCat := func(_ peer.ID, req *controlpb.ExecRequest) error {
+		if argv := req.GetArgv(); argv[len(argv)-1] == "cat" {
+			return errors.New("cat not allowed")
+		}
+		return nil
+	}
+	tests := []struct {
+		name       string
+		mode       string
+		stdin      io.Reader
+		allow      func(peer.ID, *controlpb.ExecRequest) error
+		wantStdout string
+		wantStderr string
+		wantCode   int
+		wantErr    error
+	}{
+		{name: "stdin to stdout", mode: "cat", stdin: strings.NewReader(input), wantStdout: input},
+		{name: "no stdin", mode: "cat"},
+		{name: "stderr and exit code", mode: "fail", wantStderr: "boom", wantCode: 3},
+		{name: "allowed", mode: "fail", allow: notCat, wantStderr: "boom", wantCode: 3},
+		{name: "not allowed", mode: "cat", allow: notCat, wantCode: -1, wantErr: ErrRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, done := serve(t, &Server{Allow: tt.allow})
+			var stdout, stderr bytes.Buffer
+			code, err := Run(conn, &Command{
+				Argv:   helper(t, tt.mode),
+				Env:    []string{helperEnv + "=" + tt.mode},
+				Stdin:  tt.stdin,
+				Stdout: &stdout,
+				Stderr: &stderr,
+			})
+			if !errors.Is(err, tt.wantErr) || code != tt.wantCode {
+				t.Fatalf("Run() = %d, %v, want %d, %v", code, err, tt.wantCode, tt.wantErr)
+			}
+			if stdout.String() != tt.wantStdout || stderr.String() != tt.wantStderr {
+				t.Fatalf("Run() stdout %d bytes, stderr %q, want %d bytes, %q", stdout.Len(), stderr.String(), len(tt.wantStdout), tt.wantStderr)
+			}
+			if err := <-done; (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("Serve() err = %v", err)
+			}
+		})
+	}
+}
+
+func TestAllowSeesEnvAndDir(t *testing.T) {
+	// Allow permits the program, but not the environment it runs in.
+	noOverride := func(_ peer.ID, req *controlpb.ExecRequest) error {
+		if len(req.GetEnv()) > 1 || req.GetDir() != "" {
+			return errors.New("env and dir overrides not allowed")
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		env     []string
+		dir     string
+		wantErr error
+	}{
+		{name: "no override"},
+		{name: "env override", env: []string{"LD_PRELOAD=/tmp/evil.so"}, wantErr: ErrRefused},
+		{name: "dir override", dir: os.TempDir(), wantErr: ErrRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, done := serve(t, &Server{Allow: noOverride})
+			code, err := Run(conn, &Command{
+				Argv: helper(t, "fail"),
+				Env:  append([]string{helperEnv + "=fail"}, tt.env...),
+				Dir:  tt.dir,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() = %d, %v, want %v", code, err, tt.wantErr)
+			}
+			<-done
+		})
+	}
+}
+
+func TestRunUnknownProgram(t *testing.T) {
+	conn, done := serve(t, &Server{})
+	_, err := Run(conn, &Command{Argv: []string{"flymesh-no-such-program"}})
+	if !errors.Is(err, ErrRefused) {
+		t.Fatalf("Run() err = %v, want ErrRefused", err)
+	}
+	<-done
+}
+
+func TestServeKillsOnBrokenStream(t *testing.T) {
+	conn, done := serve(t, &Server{})
+	cmd := &Command{Argv: helper(t, "hang"), Env: []string{helperEnv + "=hang"}}
+	go func() { _, _ = Run(conn, cmd) }()
+	time.Sleep(200 * time.Millisecond)
+	_ = conn.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Serve() still running after the stream broke")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package remoteexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// requestTimeout bounds the wait for the ExecRequest of a client.
+	requestTimeout = 10 * time.Second
+	// waitDelay bounds the wait for the output of a command once it exited,
+	// which the processes it left running may hold open.
+	waitDelay = 5 * time.Second
+)
+
+// Server runs the commands of clients, as the user of the process.
+type Server struct {
+	// Allow, if set, must accept the command req of the peer p before it
+	// runs: its argv, and the environment variables and working directory
+	// it sets, which change what the program does, e.g. LD_PRELOAD or PATH.
+	// The error it returns is sent to the client. Optional.
+	Allow func(p peer.ID, req *controlpb.ExecRequest) error
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Serve runs the command the peer p requests on conn, and closes conn. The
+// command is killed if conn breaks or ctx is done before it exits.
+func (s *Server) Serve(ctx context.Context, conn net.Conn, p peer.ID) error {
+	defer conn.Close()
+	typ, data, err := relay_protocol.ReadControlFrame(conn, requestTimeout)
+	if err != nil {
+		return err
+	}
+	if typ != relay_protocol.ControlTypeExecRequest {
+		return fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var req controlpb.ExecRequest
+	if err := req.UnmarshalVT(data); err != nil {
+		return fmt.Errorf("decode ExecRequest: %w", err)
+	}
+	argv := req.GetArgv()
+	if len(argv) == 0 {
+		return refuse(conn, errors.New("no command"))
+	}
+	if s.Allow != nil {
+		if err := s.Allow(p, &req); err != nil {
+			return refuse(conn, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), req.GetEnv()...)
+	cmd.Dir = req.GetDir()
+	cmd.Stdout = &output{mu: &mu, conn: conn, typ: relay_protocol.ControlTypeExecStdout}
+	cmd.Stderr = &output{mu: &mu, conn: conn, typ: relay_protocol.ControlTypeExecStderr}
+	cmd.WaitDelay = waitDelay
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return refuse(conn, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return refuse(conn, err)
+	}
+	logger := logging.Component(s.Logger, "exec").With(logging.KeyPeer, p.String(), "program", argv[0])
+	logger.Info("command started")
+	started := time.Now()
+	go func() {
+		_ = receiveStdin(conn, stdin)
+		// The stream broke, or Serve closed it.
+		cancel()
+	}()
+
+	_ = cmd.Wait()
+	code := cmd.ProcessState.ExitCode()
+	mu.Lock()
+	err = relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeExecExit, &controlpb.ExecExit{Code: int32(code)})
+	mu.Unlock()
+	logger.Info("command exited", "code", code, "seconds", time.Since(started).Seconds())
+	return err
+}
+
+// receiveStdin writes the ExecStdin frames read from conn to stdin, which
+// it closes on the empty one. It then reads on until conn breaks, which it
+// returns.
+func receiveStdin(conn net.Conn, stdin io.WriteCloser) error {
+	defer stdin.Close()
+	for {
+		typ, data, err := relay_protocol.ReadControlFrame(noDeadline{conn}, 0)
+		if err != nil {
+			return err
+		}
+		if typ != relay_protocol.ControlTypeExecStdin {
+			return fmt.Errorf("unexpected type 0x%04x", typ)
+		}
+		if len(data) == 0 {
+			_ = stdin.Close()
+			continue
+		}
+		// A command that closed its stdin drops what follows.
+		_, _ = stdin.Write(data)
+	}
+}
+
+// output writes the output of a command to the client in frames of typ,
+// sharing conn under mu.
+type output struct {
+	mu   *sync.Mutex
+	conn net.Conn
+	typ  uint16
+}
+
+func (o *output) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for n := 0; n < len(b); {
+		end := min(len(b), n+relay_protocol.ControlChunkSize)
+		if err := relay_protocol.WriteControlFrame(o.conn, o.typ, b[n:end]); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return len(b), nil
+}
+
+// refuse sends err to the client in an ExecExit, and returns it.
+func refuse(conn net.Conn, err error) error {
+	_ = relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeExecExit, &controlpb.ExecExit{Code: -1, Error: err.Error()})
+	return err
+}
@@ -0,0 +1,215 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package service runs the daemons under a service manager: systemd, told of
+// readiness, reloads and shutdown over sd_notify and pinged by the watchdog,
+// or the Windows service manager, which starts and stops them and receives
+// their logs in the event log.
+package service
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrUnsupported is returned by Command for the commands of a service manager
+// other than the one of this platform.
+var ErrUnsupported = errors.New("not supported on this platform")
+
+// Ready tells the service manager that the process serves.
+func Ready() {
+	markReady()
+	notify("READY=1")
+}
+
+// Reloading tells the service manager that the process reloads its
+// configuration. Call Ready once done.
+func Reloading() {
+	notify("RELOADING=1")
+}
+
+// Stopping tells the service manager that the process shuts down.
+func Stopping() {
+	notify("STOPPING=1")
+}
+
+// Notify sends state, newline separated VAR=VALUE assignments, to systemd
+// when it started the process with a notify socket, see sd_notify(3). It does
+// nothing otherwise.
+func Notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// A leading @ names an abstract socket, which package net handles.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+func notify(state string) {
+	if err := Notify(state); err != nil {
+		slog.Warn("notify systemd failed", "state", state, "err", err)
+	}
+}
+
+// WaitReload tells the service manager that the process serves, and pings its
+// watchdog while checks pass. It then waits for ctx to be done, calling reload
+// on every SIGHUP or reload request of the service manager. A nil reload
+// ignores them, e.g. when the process runs without a config file.
+func WaitReload(ctx context.Context, reload func(), checks ...func(context.Context) error) {
+	hup := make(chan os.Signal, 1)
+	var requests <-chan struct{}
+	if reload != nil {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		requests = ReloadRequests()
+	}
+	Ready()
+	Watchdog(ctx, checks...)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-requests:
+		}
+		Reloading()
+		reload()
+		Ready()
+	}
+}
+
+// Watchdog pings the systemd watchdog at half its interval while every check
+// passes, until ctx is done. Without a watchdog for this process it does
+// nothing.
+func Watchdog(ctx context.Context, checks ...func(context.Context) error) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			healthy := true
+			for _, check := range checks {
+				checkCtx, cancel := context.WithTimeout(ctx, interval/2)
+				err := check(checkCtx)
+				cancel()
+				if err != nil {
+					slog.Warn("watchdog check failed", "err", err)
+					healthy = false
+					break
+				}
+			}
+			if healthy {
+				notify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// watchdogInterval returns the watchdog interval systemd set for this process,
+// or 0.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Command runs a service management command of the daemon named name, args
+// following the "service" argument of its command line:
+//
+//	service [-name NAME] unit [ARGS...]       print a systemd unit running the daemon with ARGS
+//	service [-name NAME] install [ARGS...]    register a Windows service running the daemon with ARGS
+//	service [-name NAME] uninstall            remove the Windows service
+//	service [-name NAME] start | stop         start or stop the Windows service
+//
+// description describes the daemon to the service manager.
+func Command(name string, description string, args []string) error {
+	fs := flag.NewFlagSet("service", flag.ContinueOnError)
+	fs.StringVar(&name, "name", name, "name of the service")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("missing service command: unit, install, uninstall, start or stop")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	switch cmd, rest := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "unit":
+		fmt.Print(unit(description, exe, rest))
+		return nil
+	case "install":
+		return install(name, description, exe, rest)
+	case "uninstall", "start", "stop":
+		if len(rest) > 0 {
+			return fmt.Errorf("service %s takes no arguments", cmd)
+		}
+		return control(name, cmd)
+	default:
+		return fmt.Errorf("unknown service command %q", cmd)
+	}
+}
+
+// unit returns a systemd unit running exe with args.
+func unit(description string, exe string, args []string) string {
+	cmdline := []string{unitQuote(exe)}
+	for _, a := range args {
+		cmdline = append(cmdline, unitQuote(a))
+	}
+	return fmt.Sprintf(`[Unit]
+Description=%s
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s
+ExecReload=/bin/kill -HUP $MAINPID
+WatchdogSec=30s
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`, description, strings.Join(cmdline, " "))
+}
+
+// unitQuote quotes s as a word of an ExecStart command line, escaping the
+// specifiers and variables systemd would expand.
+func unitQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,34 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Run runs main, the daemon named name. Outside Windows the service manager,
+// if any, talks to the process itself, see Ready and Watchdog.
+func Run(name string, main func(ctx context.Context) int) int {
+	return main(context.Background())
+}
+
+// ReloadRequests returns the reloads the service manager asks for. Outside
+// Windows they come as SIGHUP.
+func ReloadRequests() <-chan struct{} {
+	return nil
+}
+
+func markReady() {}
+
+func install(name string, description string, exe string, args []string) error {
+	return fmt.Errorf("Windows service on %s: %w, see service unit", runtime.GOOS, ErrUnsupported)
+}
+
+func control(name string, cmd string) error {
+	return fmt.Errorf("Windows service on %s: %w", runtime.GOOS, ErrUnsupported)
+}
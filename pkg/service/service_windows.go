@@ -0,0 +1,205 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// waitHint is how long the service manager is told a pending start or stop
+// may take before the next checkpoint.
+const waitHint = 10 * time.Second
+
+var (
+	ready     = make(chan struct{})
+	readyOnce sync.Once
+	reloads   = make(chan struct{}, 1)
+)
+
+// Run runs main, the daemon named name, as that Windows service when the
+// service manager started the process, and directly otherwise. The service
+// runs from Ready on, and main's context is cancelled when the service manager
+// stops it. Its logs then go to the event log of the service, which install
+// registers.
+func Run(name string, main func(ctx context.Context) int) int {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return main(context.Background())
+	}
+	if elog, err := eventlog.Open(name); err == nil {
+		defer elog.Close()
+		logging.UseSystemLog(func(level slog.Level, line []byte) error {
+			switch {
+			case level >= slog.LevelError:
+				return elog.Error(1, string(line))
+			case level >= slog.LevelWarn:
+				return elog.Warning(1, string(line))
+			default:
+				return elog.Info(1, string(line))
+			}
+		})
+	}
+	h := &handler{main: main}
+	if err := svc.Run(name, h); err != nil {
+		return 1
+	}
+	return h.code
+}
+
+// ReloadRequests returns the reloads the service manager asks for with a
+// parameter change, sc control NAME paramchange.
+func ReloadRequests() <-chan struct{} {
+	return reloads
+}
+
+func markReady() {
+	readyOnce.Do(func() { close(ready) })
+}
+
+// handler runs main under the service manager.
+type handler struct {
+	main func(ctx context.Context) int
+	code int
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	status := svc.Status{State: svc.StartPending, WaitHint: uint32(waitHint / time.Millisecond)}
+	changes <- status
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan int, 1)
+	go func() {
+		done <- h.main(ctx)
+	}()
+
+	started := ready
+	ticker := time.NewTicker(waitHint / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case h.code = <-done:
+			changes <- svc.Status{State: svc.StopPending}
+			// A code of our own is a service specific exit code.
+			return h.code != 0, uint32(h.code)
+		case <-started:
+			started = nil
+			if status.State == svc.StartPending {
+				status = svc.Status{State: svc.Running, Accepts: accepts}
+				changes <- status
+			}
+		case <-ticker.C:
+			if status.State == svc.StartPending || status.State == svc.StopPending {
+				status.CheckPoint++
+				changes <- status
+			}
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status = svc.Status{State: svc.StopPending, WaitHint: uint32(waitHint / time.Millisecond)}
+				changes <- status
+				cancel()
+			case svc.ParamChange:
+				select {
+				case reloads <- struct{}{}:
+				default:
+				}
+				changes <- status
+			}
+		}
+	}
+}
+
+// install registers the service name running exe with args, started at boot
+// and restarted when it fails, and its event log source.
+func install(name string, description string, exe string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s exists", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: description,
+		Description: description,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 5 * time.Second}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("set recovery actions: %w", err)
+	}
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("install event log source: %w", err)
+	}
+	fmt.Printf("installed service %s: %s\n", name, exe)
+	return nil
+}
+
+// control runs cmd, uninstall, start or stop, on the service name.
+func control(name string, cmd string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("open service %s: %w", name, err)
+	}
+	defer s.Close()
+	switch cmd {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		if err := eventlog.Remove(name); err != nil {
+			return fmt.Errorf("remove event log source: %w", err)
+		}
+	case "start":
+		if err := s.Start(); err != nil {
+			return err
+		}
+	case "stop":
+		st, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(time.Minute)
+		for st.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return errors.New("timed out waiting for the service to stop")
+			}
+			time.Sleep(300 * time.Millisecond)
+			if st, err = s.Query(); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("%s service %s\n", map[string]string{"uninstall": "removed", "start": "started", "stop": "stopped"}[cmd], name)
+	return nil
+}
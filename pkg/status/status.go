@@ -0,0 +1,241 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/flymesh/core/pkg/platform"
+)
+
+// SchemaVersion is bumped whenever a field is removed or changes meaning.
+// Adding new optional fields does not change the version.
+const SchemaVersion = 1
+
+// Status is the status document served by the `status` endpoint of every flymesh component.
+type Status struct {
+	SchemaVersion int           `json:"schema_version"`
+	Component     string        `json:"component"`
+	GeneratedAt   time.Time     `json:"generated_at"`
+	Versions      Versions      `json:"versions"`
+	Platform      Platform      `json:"platform"`
+	Node          *NodeInfo     `json:"node,omitempty"`
+	Relays        []RelayInfo   `json:"relays"`
+	Sessions      []SessionInfo `json:"sessions"`
+	Forwards      []ForwardInfo `json:"forwards"`
+	Streams       []StreamInfo  `json:"streams,omitempty"`
+	Links         []LinkInfo    `json:"links,omitempty"`
+	Mesh          *MeshInfo     `json:"mesh,omitempty"`
+	Grants        []GrantInfo   `json:"grants,omitempty"`
+}
+
+type Versions struct {
+	Flymesh string `json:"flymesh"`
+	Go      string `json:"go"`
+	// ProtocolVersion is the wire protocol version announced in Hello messages.
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Protocols       []string `json:"protocols,omitempty"`
+}
+
+type Platform struct {
+	OS       string            `json:"os"`
+	Arch     string            `json:"arch"`
+	Features []PlatformFeature `json:"features"`
+}
+
+type PlatformFeature struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type NodeInfo struct {
+	PeerID         string   `json:"peer_id"`
+	Addresses      []string `json:"addresses"`
+	Reachability   string   `json:"reachability"`
+	ConnectedPeers int      `json:"connected_peers"`
+	NAT            *NATInfo `json:"nat,omitempty"`
+}
+
+// NATInfo explains how a node is reachable: the AutoNAT v2 verdict per public
+// address, the addresses peers observed, relay reservations and hole punching.
+type NATInfo struct {
+	ReachableAddrs   []string      `json:"reachable_addresses,omitempty"`
+	UnreachableAddrs []string      `json:"unreachable_addresses,omitempty"`
+	UnknownAddrs     []string      `json:"unknown_addresses,omitempty"`
+	ObservedAddrs    []string      `json:"observed_addresses,omitempty"`
+	Relays           []string      `json:"relays,omitempty"`
+	HolePunch        HolePunchInfo `json:"hole_punch"`
+}
+
+type HolePunchInfo struct {
+	Attempts          uint64     `json:"attempts"`
+	Successes         uint64     `json:"successes"`
+	LastAttempt       *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess       *time.Time `json:"last_success,omitempty"`
+	LastError         string     `json:"last_error,omitempty"`
+	SucceededRecently bool       `json:"succeeded_recently"`
+}
+
+// RelayInfo describes a relay known to this component: a circuit relay reservation
+// on a node, or the relay endpoint(s) served by a relay-server.
+type RelayInfo struct {
+	PeerID    string   `json:"peer_id,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	Kind      string   `json:"kind"`
+	// Draining tells that the relay refuses new allocations.
+	Draining bool `json:"draining,omitempty"`
+}
+
+// SessionInfo describes one relayed stream (an allocation on a relay-server, or a
+// bridged session on a peer).
+type SessionInfo struct {
+	StreamID     uint64    `json:"stream_id"`
+	ServerPeerID string    `json:"server_peer_id,omitempty"`
+	ClientPeerID string    `json:"client_peer_id,omitempty"`
+	State        string    `json:"state"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// StreamInfo describes one open stream of a peer, to or from a remote peer.
+type StreamInfo struct {
+	StreamID      uint64    `json:"stream_id"`
+	RemotePeerID  string    `json:"remote_peer_id"`
+	Path          string    `json:"path"`
+	RelayEndpoint string    `json:"relay_endpoint,omitempty"`
+	Destination   string    `json:"destination"`
+	OpenedAt      time.Time `json:"opened_at"`
+	LastActivity  time.Time `json:"last_activity"`
+	BytesRead     uint64    `json:"bytes_read"`
+	BytesWritten  uint64    `json:"bytes_written"`
+}
+
+// LinkInfo describes the quality of a monitored link, to a peer or to a relay
+// carrying a connection to it, measured over a window of pings.
+type LinkInfo struct {
+	PeerID   string  `json:"peer_id"`
+	Kind     string  `json:"kind"`
+	RTTMs    float64 `json:"rtt_ms"`
+	AvgRTTMs float64 `json:"avg_rtt_ms"`
+	Loss     float64 `json:"loss"`
+	Samples  int     `json:"samples"`
+	Degraded bool    `json:"degraded"`
+}
+
+// GrantInfo describes a guest grant a server issued.
+type GrantInfo struct {
+	PeerID    string    `json:"peer_id"`
+	Service   string    `json:"service,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxBytes  int64     `json:"max_bytes,omitempty"`
+	UsedBytes int64     `json:"used_bytes"`
+}
+
+type ForwardInfo struct {
+	Name              string `json:"name"`
+	Listen            string `json:"listen,omitempty"`
+	Target            string `json:"target,omitempty"`
+	Network           string `json:"network,omitempty"`
+	Connections       uint64 `json:"connections"`
+	ActiveConnections int64  `json:"active_connections"`
+	BytesTx           uint64 `json:"bytes_tx"`
+	BytesRx           uint64 `json:"bytes_rx"`
+	Errors            uint64 `json:"errors"`
+}
+
+// MeshInfo describes the mesh a component joined and the members it knows.
+type MeshInfo struct {
+	Topic   string       `json:"topic"`
+	Members []MeshMember `json:"members"`
+}
+
+type MeshMember struct {
+	PeerID    string    `json:"peer_id"`
+	Role      string    `json:"role,omitempty"`
+	Addresses []string  `json:"addresses,omitempty"`
+	Services  []string  `json:"services,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+	Load      *MeshLoad `json:"load,omitempty"`
+}
+
+// MeshLoad is the load a relay-server member announced.
+type MeshLoad struct {
+	RelayEndpoint  string `json:"relay_endpoint,omitempty"`
+	Allocations    int    `json:"allocations"`
+	Bridges        int    `json:"bridges"`
+	MaxAllocations int    `json:"max_allocations,omitempty"`
+}
+
+// Source fills its part of a status document.
+type Source interface {
+	FillStatus(s *Status)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(s *Status)
+
+func (f SourceFunc) FillStatus(s *Status) {
+	f(s)
+}
+
+// Build collects a status document for component from sources.
+func Build(component string, sources ...Source) *Status {
+	s := &Status{
+		SchemaVersion: SchemaVersion,
+		Component:     component,
+		GeneratedAt:   time.Now().UTC(),
+		Versions: Versions{
+			Flymesh: moduleVersion(),
+			Go:      runtime.Version(),
+		},
+		Platform: Platform{
+			OS:   runtime.GOOS,
+			Arch: runtime.GOARCH,
+		},
+		Relays:   []RelayInfo{},
+		Sessions: []SessionInfo{},
+		Forwards: []ForwardInfo{},
+	}
+	for _, c := range platform.Capabilities() {
+		s.Platform.Features = append(s.Platform.Features, PlatformFeature{
+			Name:      string(c.Feature),
+			Available: c.Available,
+			Reason:    c.Reason,
+		})
+	}
+	for _, src := range sources {
+		src.FillStatus(s)
+	}
+	return s
+}
+
+// Handler serves the status document as JSON.
+func Handler(component string, sources ...Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(Build(component, sources...))
+	})
+}
+
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Path == "github.com/flymesh/core" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/flymesh/core" {
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
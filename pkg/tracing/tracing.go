@@ -0,0 +1,111 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/flymesh/core"
+
+// Span names of the stream setup exchanges.
+const (
+	SpanOpenStream             = "flymesh.OpenStream"
+	SpanStartRelayStream       = "flymesh.StartRelayStream"
+	SpanHandleStartRelayStream = "flymesh.HandleStartRelayStream"
+	SpanCreateStream           = "flymesh.CreateStream"
+	SpanHandleCreateStream     = "flymesh.HandleCreateStream"
+	SpanCreateStreams          = "flymesh.CreateStreams"
+	SpanHandleCreateStreams    = "flymesh.HandleCreateStreams"
+	SpanDialRelayStream        = "flymesh.DialRelayStream"
+	SpanRelayHandshake         = "flymesh.RelayHandshake"
+	SpanRelayReady             = "flymesh.RelayReady"
+	SpanHandleRelayHandshake   = "flymesh.HandleRelayHandshake"
+	SpanNoiseUpgrade           = "flymesh.NoiseUpgrade"
+)
+
+// Span attribute keys.
+const (
+	AttrStreamID      = attribute.Key("flymesh.stream_id")
+	AttrPeer          = attribute.Key("flymesh.peer")
+	AttrRelayEndpoint = attribute.Key("flymesh.relay_endpoint")
+	AttrPath          = attribute.Key("flymesh.path")
+)
+
+// The trace context travels inside protobuf messages as a W3C traceparent/tracestate map,
+// independently of the globally configured propagator.
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the flymesh tracer from the global TracerProvider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Inject returns the trace context of ctx for embedding in a protobuf message.
+// It returns nil if ctx carries no sampled span.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the remote span context found in m, if any.
+func Extract(ctx context.Context, m map[string]string) context.Context {
+	if len(m) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(m))
+}
+
+// Fail records err on span and marks it as failed.
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End records err on span (if non-nil) and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}
+
+// SetupFile installs a global TracerProvider writing spans as JSON to path.
+// The returned function flushes and closes the exporter.
+func SetupFile(path string, serviceName string) (func(context.Context) error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(sdkresource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		_ = f.Close()
+		return err
+	}, nil
+}
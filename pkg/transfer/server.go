@@ -0,0 +1,99 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+// requestTimeout bounds the wait for the FileRequest of a client.
+const requestTimeout = 10 * time.Second
+
+// ErrReadOnly is returned by Server.Serve for a put to a read-only server.
+var ErrReadOnly = errors.New("read-only")
+
+// Put sends the local file src to path on the Server at the other end of
+// conn.
+func Put(conn net.Conn, src string, path string, progress Progress) (Result, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileRequest, &controlpb.FileRequest{Path: path, Put: true}); err != nil {
+		return Result{}, err
+	}
+	return send(conn, f, progress)
+}
+
+// Get fetches path from the Server at the other end of conn into the local
+// file dst.
+func Get(conn net.Conn, path string, dst string, progress Progress) (Result, error) {
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileRequest, &controlpb.FileRequest{Path: path}); err != nil {
+		return Result{}, err
+	}
+	return receive(conn, localDir{}, dst, progress)
+}
+
+// Server serves the transfers of clients within a directory.
+type Server struct {
+	// Root is the directory served. Paths leaving it, symbolic links
+	// included, are refused.
+	Root string
+	// ReadOnly refuses puts.
+	ReadOnly bool
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Serve serves the transfer requested on conn, and closes it.
+func (s *Server) Serve(conn net.Conn) error {
+	defer conn.Close()
+	var req controlpb.FileRequest
+	if err := readMessage(conn, requestTimeout, relay_protocol.ControlTypeFileRequest, &req); err != nil {
+		return err
+	}
+	name := filepath.FromSlash(req.GetPath())
+	if !filepath.IsLocal(name) {
+		return refuse(conn, fmt.Errorf("path %q leaves the served directory", req.GetPath()))
+	}
+	if req.GetPut() && s.ReadOnly {
+		return refuse(conn, ErrReadOnly)
+	}
+	root, err := os.OpenRoot(s.Root)
+	if err != nil {
+		return refuse(conn, errors.New("served directory unavailable"))
+	}
+	defer root.Close()
+
+	op := "get"
+	var result Result
+	if req.GetPut() {
+		op = "put"
+		result, err = receive(conn, root, name, nil)
+	} else {
+		var f *os.File
+		if f, err = root.Open(name); err != nil {
+			return refuse(conn, err)
+		}
+		defer f.Close()
+		result, err = send(conn, f, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", op, req.GetPath(), err)
+	}
+	logging.Component(s.Logger, "transfer").Info("file transferred", "op", op, "path", req.GetPath(),
+		"size", result.Size, "resumed", result.Resumed, "seconds", result.Seconds)
+	return nil
+}
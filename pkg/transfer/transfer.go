@@ -0,0 +1,267 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package transfer copies files over flymesh streams: a client puts a file to
+// a Server, or gets one from it.
+//
+// The file is sent in FileChunk frames after a FileHeader carrying its size
+// and SHA-256, which the receiver verifies before moving the file in place.
+// Until then the file is kept under a partial name derived from its SHA-256,
+// and a later transfer of the same file resumes from the bytes it holds.
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+// ALPN marks the streams carrying a file transfer.
+const ALPN = "flymesh-file"
+
+const (
+	// chunkTimeout bounds the wait for a chunk, a stalled transfer failing
+	// after it.
+	chunkTimeout = 30 * time.Second
+	// answerTimeout bounds the wait for the answers of the other end, which
+	// may hash a large file first.
+	answerTimeout = 5 * time.Minute
+)
+
+// partSuffix ends the names of partially received files.
+const partSuffix = ".flymesh-part"
+
+var (
+	// ErrChecksum is returned for a received file not matching the SHA-256 of
+	// its FileHeader. Its partial file is removed.
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrRefused is returned for a transfer the other end refused.
+	ErrRefused = errors.New("transfer refused")
+)
+
+// Progress is called as a transfer goes, with the bytes of the file done,
+// resumed ones included, and its size.
+type Progress func(done, size int64)
+
+// Result is the outcome of a transfer.
+type Result struct {
+	Size int64 `json:"size"`
+	// Resumed is how many bytes an earlier transfer had already carried.
+	Resumed int64   `json:"resumed"`
+	Seconds float64 `json:"seconds"`
+	MBps    float64 `json:"mbps"`
+}
+
+func newResult(size, resumed int64, started time.Time) Result {
+	r := Result{Size: size, Resumed: resumed, Seconds: time.Since(started).Seconds()}
+	if r.Seconds > 0 {
+		r.MBps = float64(size-resumed) / r.Seconds / 1e6
+	}
+	return r
+}
+
+// dir is where files are received: a Root on the server, the working
+// directory on the client.
+type dir interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// localDir is the file system of the process.
+type localDir struct{}
+
+func (localDir) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+func (localDir) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+func (localDir) Remove(name string) error             { return os.Remove(name) }
+
+// partName returns the name a file with SHA-256 sum is received under until
+// verified.
+func partName(name string, sum []byte) string {
+	d, base := filepath.Split(name)
+	return filepath.Join(d, "."+base+"."+hex.EncodeToString(sum[:8])+partSuffix)
+}
+
+// send sends f to the receiver at the other end of conn.
+func send(conn net.Conn, f *os.File, progress Progress) (Result, error) {
+	started := time.Now()
+	fi, err := f.Stat()
+	if err != nil {
+		return Result{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return Result{}, fmt.Errorf("%s is not a regular file", f.Name())
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Result{}, err
+	}
+	size := fi.Size()
+	hdr := &controlpb.FileHeader{Size: uint64(size), Sha256: h.Sum(nil), Mode: uint32(fi.Mode().Perm())}
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileHeader, hdr); err != nil {
+		return Result{}, err
+	}
+	var resume controlpb.FileResume
+	if err := readMessage(conn, answerTimeout, relay_protocol.ControlTypeFileResume, &resume); err != nil {
+		return Result{}, err
+	}
+	offset := int64(resume.GetOffset())
+	if offset > size {
+		return Result{}, fmt.Errorf("resume at %d past the end of %d bytes", offset, size)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return Result{}, err
+	}
+
+	buf := make([]byte, relay_protocol.ControlChunkSize)
+	for done := offset; done < size; {
+		n, err := io.ReadFull(f, buf[:min(int64(len(buf)), size-done)])
+		if err != nil {
+			return Result{}, fmt.Errorf("read %s: %w", f.Name(), err)
+		}
+		if err := relay_protocol.WriteControlFrame(conn, relay_protocol.ControlTypeFileChunk, buf[:n]); err != nil {
+			return Result{}, fmt.Errorf("write chunk: %w", err)
+		}
+		done += int64(n)
+		if progress != nil {
+			progress(done, size)
+		}
+	}
+	if err := readMessage(conn, answerTimeout, relay_protocol.ControlTypeFileResult, nil); err != nil {
+		return Result{}, err
+	}
+	return newResult(size, offset, started), nil
+}
+
+// receive receives the file sent from the other end of conn as name in d.
+func receive(conn net.Conn, d dir, name string, progress Progress) (Result, error) {
+	started := time.Now()
+	var hdr controlpb.FileHeader
+	if err := readMessage(conn, answerTimeout, relay_protocol.ControlTypeFileHeader, &hdr); err != nil {
+		return Result{}, err
+	}
+	if len(hdr.GetSha256()) != sha256.Size {
+		return Result{}, refuse(conn, errors.New("bad FileHeader checksum"))
+	}
+	size := int64(hdr.GetSize())
+	part := partName(name, hdr.GetSha256())
+	f, err := d.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return Result{}, refuse(conn, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	offset, err := resumeFrom(f, h, size)
+	if err != nil {
+		return Result{}, refuse(conn, err)
+	}
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileResume, &controlpb.FileResume{Offset: uint64(offset)}); err != nil {
+		return Result{}, err
+	}
+
+	for done := offset; done < size; {
+		typ, data, err := relay_protocol.ReadControlFrame(conn, chunkTimeout)
+		if err != nil {
+			return Result{}, fmt.Errorf("read chunk: %w", err)
+		}
+		if typ != relay_protocol.ControlTypeFileChunk || int64(len(data)) > size-done {
+			return Result{}, refuse(conn, fmt.Errorf("unexpected frame 0x%04x of %d bytes", typ, len(data)))
+		}
+		if _, err := f.Write(data); err != nil {
+			return Result{}, refuse(conn, err)
+		}
+		h.Write(data)
+		done += int64(len(data))
+		if progress != nil {
+			progress(done, size)
+		}
+	}
+	if !bytes.Equal(h.Sum(nil), hdr.GetSha256()) {
+		_ = f.Close()
+		_ = d.Remove(part)
+		return Result{}, refuse(conn, ErrChecksum)
+	}
+	if err := f.Chmod(fs.FileMode(hdr.GetMode()).Perm()); err != nil {
+		return Result{}, refuse(conn, err)
+	}
+	if err := f.Close(); err != nil {
+		return Result{}, refuse(conn, err)
+	}
+	if err := d.Rename(part, name); err != nil {
+		return Result{}, refuse(conn, err)
+	}
+	if err := relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileResult, &controlpb.FileResult{Ok: true}); err != nil {
+		return Result{}, err
+	}
+	return newResult(size, offset, started), nil
+}
+
+// resumeFrom hashes the bytes an earlier transfer left in the partial file f
+// into h, and returns how many there are, f positioned after them.
+func resumeFrom(f *os.File, h hash.Hash, size int64) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset := min(fi.Size(), size)
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// refuse sends err to the other end of conn in a FileResult, and returns it.
+func refuse(conn net.Conn, err error) error {
+	_ = relay_protocol.WriteMessage(conn, relay_protocol.ControlTypeFileResult, &controlpb.FileResult{Error: err.Error()})
+	return err
+}
+
+type message interface {
+	UnmarshalVT([]byte) error
+}
+
+// readMessage reads the message of type typ into msg, which may be nil for a
+// FileResult. A FileResult in its place, or one that is not ok, is returned as
+// an error wrapping ErrRefused.
+func readMessage(conn net.Conn, timeout time.Duration, typ uint16, msg message) error {
+	got, data, err := relay_protocol.ReadControlFrame(conn, timeout)
+	if err != nil {
+		return err
+	}
+	if got == relay_protocol.ControlTypeFileResult {
+		var res controlpb.FileResult
+		if err := res.UnmarshalVT(data); err != nil {
+			return fmt.Errorf("decode FileResult: %w", err)
+		}
+		if !res.GetOk() {
+			return fmt.Errorf("%w: %s", ErrRefused, res.GetError())
+		}
+		if typ == relay_protocol.ControlTypeFileResult {
+			return nil
+		}
+	}
+	if got != typ {
+		return fmt.Errorf("unexpected type 0x%04x", got)
+	}
+	if msg == nil {
+		return nil
+	}
+	return msg.UnmarshalVT(data)
+}
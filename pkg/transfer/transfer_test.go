@@ -0,0 +1,156 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package transfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flymesh/core/pkg/flymeshtest"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+)
+
+func writeRandom(t *testing.T, path string, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	_, _ = rand.Read(data)
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPutGet(t *testing.T) {
+	root, local := t.TempDir(), t.TempDir()
+	s := &Server{Root: root}
+	src := filepath.Join(local, "src.bin")
+	data := writeRandom(t, src, 3*relay_protocol.ControlChunkSize+123)
+
+	var last int64
+	conn, done := flymeshtest.ServeConn(t, s.Serve)
+	result, err := Put(conn, src, "dst.bin", func(done, size int64) { last = done })
+	if err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() err = %v", err)
+	}
+	if result.Size != int64(len(data)) || last != result.Size {
+		t.Fatalf("Put() size %d, progress %d, want %d", result.Size, last, len(data))
+	}
+	got, err := os.ReadFile(filepath.Join(root, "dst.bin"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("put file differs, err = %v", err)
+	}
+	if fi, _ := os.Stat(filepath.Join(root, "dst.bin")); fi.Mode().Perm() != 0o640 {
+		t.Fatalf("put file mode %v, want 0640", fi.Mode().Perm())
+	}
+
+	conn, done = flymeshtest.ServeConn(t, s.Serve)
+	dst := filepath.Join(local, "back.bin")
+	if _, err := Get(conn, "dst.bin", dst, nil); err != nil {
+		t.Fatalf("Get() err = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Serve() err = %v", err)
+	}
+	if got, err := os.ReadFile(dst); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("got file differs, err = %v", err)
+	}
+}
+
+func TestPutResume(t *testing.T) {
+	root, local := t.TempDir(), t.TempDir()
+	src := filepath.Join(local, "src.bin")
+	data := writeRandom(t, src, 2*relay_protocol.ControlChunkSize)
+	sum := sha256.Sum256(data)
+	part := filepath.Join(root, partName("dst.bin", sum[:]))
+
+	tests := []struct {
+		name        string
+		partial     []byte
+		wantResumed int64
+		wantErr     error
+	}{
+		{name: "fresh", wantResumed: 0},
+		{name: "resumed", partial: data[:relay_protocol.ControlChunkSize+7], wantResumed: relay_protocol.ControlChunkSize + 7},
+		{name: "complete", partial: data, wantResumed: int64(len(data))},
+		{name: "corrupt", partial: make([]byte, 100), wantErr: ErrChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = os.Remove(filepath.Join(root, "dst.bin"))
+			if tt.partial != nil {
+				if err := os.WriteFile(part, tt.partial, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn, done := flymeshtest.ServeConn(t, (&Server{Root: root}).Serve)
+			result, err := Put(conn, src, "dst.bin", nil)
+			serr := <-done
+			if tt.wantErr != nil {
+				if !errors.Is(err, ErrRefused) || !errors.Is(serr, tt.wantErr) {
+					t.Fatalf("Put() err = %v, Serve() err = %v, want %v", err, serr, tt.wantErr)
+				}
+				if _, err := os.Stat(part); !os.IsNotExist(err) {
+					t.Fatalf("partial file kept, stat err = %v", err)
+				}
+				return
+			}
+			if err != nil || serr != nil {
+				t.Fatalf("Put() err = %v, Serve() err = %v", err, serr)
+			}
+			if result.Resumed != tt.wantResumed {
+				t.Fatalf("resumed %d bytes, want %d", result.Resumed, tt.wantResumed)
+			}
+			if got, err := os.ReadFile(filepath.Join(root, "dst.bin")); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("put file differs, err = %v", err)
+			}
+		})
+	}
+}
+
+func TestServeRefused(t *testing.T) {
+	root, local := t.TempDir(), t.TempDir()
+	src := filepath.Join(local, "src.bin")
+	writeRandom(t, src, 10)
+	if err := os.Symlink(local, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		server Server
+		put    bool
+		path   string
+	}{
+		{name: "parent", server: Server{Root: root}, put: true, path: "../escape.bin"},
+		{name: "absolute", server: Server{Root: root}, path: src},
+		{name: "symlink", server: Server{Root: root}, path: "link/src.bin"},
+		{name: "read-only", server: Server{Root: root, ReadOnly: true}, put: true, path: "dst.bin"},
+		{name: "missing", server: Server{Root: root}, path: "missing.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, done := flymeshtest.ServeConn(t, tt.server.Serve)
+			var err error
+			if tt.put {
+				_, err = Put(conn, src, tt.path, nil)
+			} else {
+				_, err = Get(conn, tt.path, filepath.Join(local, "got.bin"), nil)
+			}
+			if !errors.Is(err, ErrRefused) {
+				t.Fatalf("err = %v, want ErrRefused", err)
+			}
+			if serr := <-done; serr == nil {
+				t.Fatal("Serve() err = nil")
+			}
+		})
+	}
+}
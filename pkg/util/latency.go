@@ -0,0 +1,140 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencyALPN is the ALPN of the destination of a stream carrying the latency
+// test, which the server answers with EchoLatency.
+const LatencyALPN = "flymesh-latency"
+
+// latencyFrameSize is the size of a latency test frame: a sequence number, the
+// send time, then padding.
+const latencyFrameSize = 64
+
+// LatencyStats summarizes a latency test.
+type LatencyStats struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Min      time.Duration `json:"rtt_min_ns"`
+	Avg      time.Duration `json:"rtt_avg_ns"`
+	P95      time.Duration `json:"rtt_p95_ns"`
+	P99      time.Duration `json:"rtt_p99_ns"`
+	Max      time.Duration `json:"rtt_max_ns"`
+	// Jitter is the mean difference between consecutive round trips.
+	Jitter time.Duration `json:"jitter_ns"`
+}
+
+// EchoLatency answers the latency test on conn, sending every frame back as
+// it comes.
+func EchoLatency(conn net.Conn) {
+	buf := make([]byte, latencyFrameSize)
+	for {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			if err != io.EOF {
+				slog.Warn("latency test read error", "err", err)
+			}
+			return
+		}
+		if _, err := conn.Write(buf); err != nil {
+			slog.Warn("latency test write error", "err", err)
+			return
+		}
+	}
+}
+
+// MeasureLatency sends a timestamped frame over conn every interval for
+// duration seconds, the server echoing them back, and writes the round trip
+// times and their jitter to w.
+func MeasureLatency(conn net.Conn, duration int, interval time.Duration, w io.Writer) LatencyStats {
+	slog.Info("starting latency test", "duration_sec", duration, "interval", interval)
+	start := time.Now()
+	var (
+		mu   sync.Mutex
+		rtts []time.Duration
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, latencyFrameSize)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				if !IsTimeout(err) && err != io.EOF {
+					slog.Warn("latency test read error", "err", err)
+				}
+				return
+			}
+			sent := time.Duration(binary.BigEndian.Uint64(buf[8:16]))
+			mu.Lock()
+			rtts = append(rtts, time.Since(start)-sent)
+			mu.Unlock()
+		}
+	}()
+
+	var stats LatencyStats
+	buf := make([]byte, latencyFrameSize)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for deadline := start.Add(time.Duration(duration) * time.Second); time.Now().Before(deadline); <-ticker.C {
+		binary.BigEndian.PutUint64(buf[0:8], uint64(stats.Sent))
+		binary.BigEndian.PutUint64(buf[8:16], uint64(time.Since(start)))
+		if _, err := conn.Write(buf); err != nil {
+			slog.Warn("latency test write error", "err", err)
+			break
+		}
+		stats.Sent++
+	}
+	// Give the last echoes a second to come back.
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	<-done
+	_ = conn.SetReadDeadline(time.Time{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	stats.Received = len(rtts)
+	if len(rtts) > 0 {
+		var sum, diffs time.Duration
+		for i, rtt := range rtts {
+			sum += rtt
+			if i > 0 {
+				diffs += (rtt - rtts[i-1]).Abs()
+			}
+		}
+		stats.Avg = sum / time.Duration(len(rtts))
+		if len(rtts) > 1 {
+			stats.Jitter = diffs / time.Duration(len(rtts)-1)
+		}
+		slices.Sort(rtts)
+		stats.Min, stats.Max = rtts[0], rtts[len(rtts)-1]
+		stats.P95 = percentile(rtts, 95)
+		stats.P99 = percentile(rtts, 99)
+	}
+	lost := 0.0
+	if stats.Sent > 0 {
+		lost = 100 * float64(stats.Sent-stats.Received) / float64(stats.Sent)
+	}
+	fmt.Fprintf(w, "✅ %d frames sent, %d received (%.1f%% lost)\n", stats.Sent, stats.Received, lost)
+	fmt.Fprintf(w, "   rtt min/avg/p95/p99/max = %s/%s/%s/%s/%s, jitter %s\n",
+		ms(stats.Min), ms(stats.Avg), ms(stats.P95), ms(stats.P99), ms(stats.Max), ms(stats.Jitter))
+	return stats
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)]
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
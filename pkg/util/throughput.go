@@ -0,0 +1,319 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package util
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ThroughputALPN is the ALPN of the destination of a stream carrying the
+// throughput test, which starts with the request of RequestThroughput and is
+// answered with ServeThroughput. Streams without it are only received from.
+const ThroughputALPN = "flymesh-throughput"
+
+// MaxThroughputDuration bounds the seconds a client may have a server run the
+// throughput test for.
+const MaxThroughputDuration = 600
+
+const (
+	throughputVersion = 1
+	// throughputRequestSize is the size of the request: the version, the
+	// direction, then the duration in seconds.
+	throughputRequestSize = 6
+	// throughputLinger is how long ServeThroughput waits for the client to
+	// close the stream after the test, so that the client sees it end on its
+	// own deadline rather than on a reset.
+	throughputLinger = 5 * time.Second
+	// throughputSlack is how early before the deadline of the test its other
+	// end may stop, its clock starting a little apart.
+	throughputSlack = time.Second
+)
+
+// Direction is the way the throughput test carries data, seen from the
+// client.
+type Direction byte
+
+const (
+	DirectionSend Direction = iota + 1
+	DirectionReceive
+	DirectionBidir
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionSend:
+		return "send"
+	case DirectionReceive:
+		return "receive"
+	case DirectionBidir:
+		return "bidir"
+	default:
+		return fmt.Sprintf("Direction(%d)", byte(d))
+	}
+}
+
+// Reverse returns the direction seen from the other end.
+func (d Direction) Reverse() Direction {
+	switch d {
+	case DirectionSend:
+		return DirectionReceive
+	case DirectionReceive:
+		return DirectionSend
+	default:
+		return d
+	}
+}
+
+func (d Direction) sends() bool {
+	return d == DirectionSend || d == DirectionBidir
+}
+
+func (d Direction) receives() bool {
+	return d == DirectionReceive || d == DirectionBidir
+}
+
+// ThroughputResult is the outcome of a throughput test.
+type ThroughputResult struct {
+	Direction     string               `json:"direction"`
+	Seconds       float64              `json:"seconds"`
+	SentBytes     int64                `json:"sent_bytes"`
+	ReceivedBytes int64                `json:"received_bytes"`
+	SendMBps      float64              `json:"send_mbps"`
+	ReceiveMBps   float64              `json:"receive_mbps"`
+	Streams       []ThroughputStream   `json:"streams"`
+	Intervals     []ThroughputInterval `json:"intervals"`
+}
+
+// ThroughputStream is the transfer of one stream of a throughput test.
+type ThroughputStream struct {
+	SentBytes     int64 `json:"sent_bytes"`
+	ReceivedBytes int64 `json:"received_bytes"`
+}
+
+// ThroughputInterval is the transfer of all streams of a throughput test
+// between Start and End, in seconds since it started.
+type ThroughputInterval struct {
+	Start         float64 `json:"start"`
+	End           float64 `json:"end"`
+	SentBytes     int64   `json:"sent_bytes"`
+	ReceivedBytes int64   `json:"received_bytes"`
+}
+
+// RequestThroughput asks the server at the other end of conn, a stream with
+// ThroughputALPN, to run its side of the throughput test in dir for duration
+// seconds.
+func RequestThroughput(conn net.Conn, dir Direction, duration int) error {
+	var req [throughputRequestSize]byte
+	req[0] = throughputVersion
+	req[1] = byte(dir)
+	binary.BigEndian.PutUint32(req[2:], uint32(duration))
+	_, err := conn.Write(req[:])
+	return err
+}
+
+// ServeThroughput reads the request of RequestThroughput from conn and runs the
+// other side of the test it asks for.
+func ServeThroughput(conn net.Conn) (ThroughputResult, error) {
+	var req [throughputRequestSize]byte
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return ThroughputResult{}, fmt.Errorf("read throughput request: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if req[0] != throughputVersion {
+		return ThroughputResult{}, fmt.Errorf("unsupported throughput request version %d", req[0])
+	}
+	dir := Direction(req[1])
+	if !dir.sends() && !dir.receives() {
+		return ThroughputResult{}, fmt.Errorf("bad throughput direction %d", req[1])
+	}
+	duration := int(min(binary.BigEndian.Uint32(req[2:]), MaxThroughputDuration))
+	if duration == 0 {
+		return ThroughputResult{}, errors.New("bad throughput duration 0")
+	}
+
+	result := ParallelTCP([]net.Conn{conn}, duration, dir.Reverse(), io.Discard)
+	_ = conn.SetReadDeadline(time.Now().Add(throughputLinger))
+	_, _ = io.Copy(io.Discard, conn)
+	return result, nil
+}
+
+// ParallelTCP runs the throughput test in dir over every conn at once for
+// duration seconds, like iperf -P. It writes the transfer of each stream and
+// of all of them every second to w, then a summary, and returns the result. A
+// single stream through the relay often cannot fill the path, their sum can.
+func ParallelTCP(conns []net.Conn, duration int, dir Direction, w io.Writer) ThroughputResult {
+	slog.Info("starting throughput test", "direction", dir, "duration_sec", duration, "streams", len(conns))
+
+	sent := make([]atomic.Int64, len(conns))
+	received := make([]atomic.Int64, len(conns))
+	start := time.Now()
+	deadline := start.Add(time.Duration(duration) * time.Second)
+	var wg sync.WaitGroup
+	run := func(i int, direction string, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil && time.Until(deadline) > throughputSlack {
+				slog.Warn("throughput test stream failed", "stream", i+1, "direction", direction, "err", err)
+			}
+		}()
+	}
+	for i, conn := range conns {
+		if dir.sends() {
+			run(i, "send", func() error { return sendUntil(conn, deadline, &sent[i]) })
+		}
+		if dir.receives() {
+			run(i, "receive", func() error { return receiveUntil(conn, deadline, &received[i]) })
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Each way data goes: its label, counters, what was last printed of them
+	// and the field of interval adding them up.
+	type way struct {
+		label    string
+		counters []atomic.Int64
+		last     []int64
+		sums     *int64
+	}
+	var (
+		ways     []*way
+		interval ThroughputInterval
+	)
+	if dir.sends() {
+		ways = append(ways, &way{counters: sent, last: make([]int64, len(conns)), sums: &interval.SentBytes})
+	}
+	if dir.receives() {
+		ways = append(ways, &way{counters: received, last: make([]int64, len(conns)), sums: &interval.ReceivedBytes})
+	}
+	if dir == DirectionBidir {
+		ways[0].label, ways[1].label = "TX ", "RX "
+	}
+
+	result := ThroughputResult{
+		Direction: dir.String(),
+		Streams:   make([]ThroughputStream, len(conns)),
+	}
+	fmt.Fprintf(w, "[ ID] Interval        Transfer     Throughput\n")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	from := time.Duration(0)
+	for finished := false; !finished; {
+		select {
+		case <-ticker.C:
+		case <-done:
+			finished = true
+		}
+		to := time.Since(start)
+		if finished && to-from < time.Second/2 {
+			// The tail past the last full second is in the summary.
+			break
+		}
+		interval = ThroughputInterval{Start: from.Seconds(), End: to.Seconds()}
+		for _, way := range ways {
+			for i := range way.counters {
+				n := way.counters[i].Load()
+				if len(conns) > 1 {
+					printInterval(w, fmt.Sprintf("%s%3d", way.label, i+1), from, to, n-way.last[i])
+				}
+				*way.sums += n - way.last[i]
+				way.last[i] = n
+			}
+			printInterval(w, way.label+"SUM", from, to, *way.sums)
+		}
+		result.Intervals = append(result.Intervals, interval)
+		from = to
+	}
+
+	elapsed := time.Since(start)
+	result.Seconds = elapsed.Seconds()
+	for i := range conns {
+		result.Streams[i] = ThroughputStream{SentBytes: sent[i].Load(), ReceivedBytes: received[i].Load()}
+		result.SentBytes += result.Streams[i].SentBytes
+		result.ReceivedBytes += result.Streams[i].ReceivedBytes
+	}
+	result.SendMBps = mbps(result.SentBytes, elapsed)
+	result.ReceiveMBps = mbps(result.ReceivedBytes, elapsed)
+
+	fmt.Fprintf(w, "- - - - - - - - - - - - - - - - - - - - - - - -\n")
+	for _, way := range ways {
+		var total int64
+		for i := range way.counters {
+			n := way.counters[i].Load()
+			total += n
+			if len(conns) > 1 {
+				printInterval(w, fmt.Sprintf("%s%3d", way.label, i+1), 0, elapsed, n)
+			}
+		}
+		printInterval(w, way.label+"SUM", 0, elapsed, total)
+	}
+	if dir.sends() {
+		fmt.Fprintf(w, "✅ Sent %d bytes over %d streams in %.1fs (%.2f MB/s)\n",
+			result.SentBytes, len(conns), result.Seconds, result.SendMBps)
+	}
+	if dir.receives() {
+		fmt.Fprintf(w, "✅ Received %d bytes over %d streams in %.1fs (%.2f MB/s)\n",
+			result.ReceivedBytes, len(conns), result.Seconds, result.ReceiveMBps)
+	}
+	return result
+}
+
+func printInterval(w io.Writer, id string, from, to time.Duration, n int64) {
+	fmt.Fprintf(w, "[%s] %5.1f-%-5.1f sec %8.2f MB  %8.2f MB/s\n",
+		id, from.Seconds(), to.Seconds(), float64(n)/(1024*1024), mbps(n, to-from))
+}
+
+// mbps returns n bytes over d in MB/s.
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds() / (1024 * 1024)
+}
+
+// sendUntil writes to conn until deadline. It does not set a write deadline,
+// which could cut a message of the secured stream short.
+func sendUntil(conn net.Conn, deadline time.Time, total *atomic.Int64) error {
+	buf := make([]byte, 64*1024)
+	_, _ = rand.Read(buf)
+	for time.Now().Before(deadline) {
+		n, err := conn.Write(buf)
+		total.Add(int64(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func receiveUntil(conn net.Conn, deadline time.Time, total *atomic.Int64) error {
+	buf := make([]byte, 64*1024)
+	_ = conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		n, err := conn.Read(buf)
+		total.Add(int64(n))
+		if errors.Is(err, os.ErrDeadlineExceeded) || err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
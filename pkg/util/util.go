@@ -10,32 +10,46 @@ import (
 
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"time"
 
+	"github.com/flymesh/core/pkg/keyfile"
 	"github.com/libp2p/go-libp2p/core/crypto"
 )
 
-// LoadOrCreatePrivateKey loads a private key from a file, or creates a new one if it doesn't exist.
-func LoadOrCreatePrivateKey(path string) (crypto.PrivKey, error) {
-	data, err := os.ReadFile(path)
+// LoadOrCreatePrivateKey loads a private key from a file, raw, PEM or
+// encrypted, or creates a new Ed25519 one if it doesn't exist. With a
+// passphrase new keys are encrypted with it, and a plaintext key file is
+// encrypted in place.
+func LoadOrCreatePrivateKey(path string, passphrase []byte) (crypto.PrivKey, error) {
+	format := keyfile.FormatRaw
+	if len(passphrase) > 0 {
+		format = keyfile.FormatEncrypted
+	}
+	priv, loaded, err := keyfile.Load(path, passphrase)
 	if errors.Is(err, os.ErrNotExist) {
-		privKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		privKey, err := keyfile.Generate("ed25519")
 		if err != nil {
 			return nil, err
 		}
-		data, err = crypto.MarshalPrivateKey(privKey)
+		data, err := keyfile.Marshal(privKey, format, passphrase)
 		if err != nil {
 			return nil, err
 		}
-		return privKey, os.WriteFile(path, data, 0600)
+		return privKey, keyfile.Write(path, data, false)
 	} else if err != nil {
 		return nil, err
 	}
-	priv, err := crypto.UnmarshalPrivateKey(data)
-	if err != nil {
-		return nil, err
+	if format == keyfile.FormatEncrypted && loaded != keyfile.FormatEncrypted {
+		data, err := keyfile.Marshal(priv, format, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if err := keyfile.Replace(path, data); err != nil {
+			return nil, fmt.Errorf("encrypt %s: %w", path, err)
+		}
+		slog.Info("private key file encrypted", "path", path, "was", loaded)
 	}
 	return priv, nil
 }
@@ -44,13 +58,13 @@ func LoadOrCreatePrivateKey(path string) (crypto.PrivKey, error) {
 func SendAndMeasureTCP(conn net.Conn, duration int) {
 	buf := make([]byte, 64*1024)
 	_, _ = rand.Read(buf)
-	log.Printf("Starting to send data for %d seconds over TCP relay-server...", duration)
+	slog.Info("starting throughput test", "direction", "send", "duration_sec", duration)
 	deadline := time.Now().Add(time.Duration(duration) * time.Second)
 	total := 0
 	for time.Now().Before(deadline) {
 		n, err := conn.Write(buf)
 		if err != nil {
-			log.Printf("Write error: %v", err)
+			slog.Warn("throughput test write error", "err", err)
 			break
 		}
 		total += n
@@ -64,7 +78,7 @@ func ReceiveAndMeasureTCP(conn net.Conn, duration int) {
 	buf := make([]byte, 64*1024)
 	deadline := time.Now().Add(time.Duration(duration) * time.Second)
 	total := 0
-	log.Printf("Starting to receive data for %d seconds over TCP relay-server...", duration)
+	slog.Info("starting throughput test", "direction", "receive", "duration_sec", duration)
 	for {
 		if time.Now().After(deadline) {
 			break
@@ -78,7 +92,7 @@ func ReceiveAndMeasureTCP(conn net.Conn, duration int) {
 			if err == io.EOF {
 				break
 			}
-			log.Printf("Read error: %v", err)
+			slog.Warn("throughput test read error", "err", err)
 			break
 		}
 		total += n
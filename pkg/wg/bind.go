@@ -0,0 +1,170 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package wg
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/flymesh/core/pkg/forward"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// bindQueue is how many received packets wait for the device before the
+// streams stop being read.
+const bindQueue = 64
+
+// endpoint names a stream of a streamBind.
+type endpoint string
+
+func (e endpoint) ClearSrc()           {}
+func (e endpoint) SrcToString() string { return "" }
+func (e endpoint) DstToString() string { return string(e) }
+func (e endpoint) DstToBytes() []byte  { return []byte(e) }
+func (e endpoint) DstIP() netip.Addr   { return netip.Addr{} }
+func (e endpoint) SrcIP() netip.Addr   { return netip.Addr{} }
+
+// received is a packet read from the stream of ep.
+type received struct {
+	ep endpoint
+	p  []byte
+}
+
+// link is a stream of a streamBind. Its writes take turns under mu.
+type link struct {
+	mu   sync.Mutex
+	conn net.Conn
+	done chan struct{}
+}
+
+// streamBind is a conn.Bind whose endpoints are streams, each packet framed
+// with forward.WriteDatagram, instead of UDP addresses.
+type streamBind struct {
+	mu     sync.Mutex
+	links  map[endpoint]*link
+	next   uint64
+	closed chan struct{}
+	recv   chan received
+}
+
+func newStreamBind() *streamBind {
+	return &streamBind{
+		links: make(map[endpoint]*link),
+		recv:  make(chan received, bindQueue),
+	}
+}
+
+// add makes conn an endpoint, whose packets are read by read.
+func (b *streamBind) add(c net.Conn) endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.next++
+	ep := endpoint("stream/" + strconv.FormatUint(b.next, 10))
+	b.links[ep] = &link{conn: c, done: make(chan struct{})}
+	return ep
+}
+
+// remove forgets ep, whose stream is left to its owner.
+func (b *streamBind) remove(ep endpoint) {
+	b.mu.Lock()
+	l := b.links[ep]
+	delete(b.links, ep)
+	b.mu.Unlock()
+	if l != nil {
+		close(l.done)
+	}
+}
+
+func (b *streamBind) link(ep endpoint) *link {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.links[ep]
+}
+
+// read passes the packets of the stream of ep to the device until the stream
+// fails or ep is removed.
+func (b *streamBind) read(ep endpoint) error {
+	l := b.link(ep)
+	if l == nil {
+		return net.ErrClosed
+	}
+	buf := make([]byte, forward.MaxDatagram)
+	for {
+		n, err := forward.ReadDatagram(l.conn, buf)
+		if err != nil {
+			return err
+		}
+		select {
+		case b.recv <- received{ep: ep, p: bytes.Clone(buf[:n])}:
+		case <-l.done:
+			return net.ErrClosed
+		}
+	}
+}
+
+func (b *streamBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	closed := make(chan struct{})
+	b.closed = closed
+	receive := func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		select {
+		case r := <-b.recv:
+			sizes[0] = copy(packets[0], r.p)
+			eps[0] = r.ep
+			return 1, nil
+		case <-closed:
+			return 0, net.ErrClosed
+		}
+	}
+	return []conn.ReceiveFunc{receive}, port, nil
+}
+
+func (b *streamBind) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed != nil {
+		close(b.closed)
+		b.closed = nil
+	}
+	return nil
+}
+
+func (b *streamBind) SetMark(uint32) error { return nil }
+
+func (b *streamBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	e, ok := ep.(endpoint)
+	if !ok {
+		return fmt.Errorf("not a stream endpoint: %s", ep.DstToString())
+	}
+	l := b.link(e)
+	if l == nil {
+		return net.ErrClosed
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range bufs {
+		if err := forward.WriteDatagram(l.conn, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *streamBind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	ep := endpoint(s)
+	if b.link(ep) == nil {
+		return nil, fmt.Errorf("no stream %q", s)
+	}
+	return ep, nil
+}
+
+func (b *streamBind) BatchSize() int { return 1 }
@@ -0,0 +1,70 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package wg
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// Key is a Curve25519 key of a WireGuard device.
+type Key [32]byte
+
+// GenerateKey returns a new private key.
+func GenerateKey() (Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return Key{}, err
+	}
+	// Clamped as wg genkey does.
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	return k, nil
+}
+
+// ParseKey parses a key in base64, as wg genkey and wg pubkey print it.
+func ParseKey(s string) (Key, error) {
+	var k Key
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return Key{}, fmt.Errorf("bad key: %w", err)
+	}
+	if len(b) != len(k) {
+		return Key{}, fmt.Errorf("bad key: %d bytes, want %d", len(b), len(k))
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// LoadKey reads a private key file written by wg genkey.
+func LoadKey(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	return ParseKey(string(data))
+}
+
+// PublicKey returns the public key of the private key k.
+func (k Key) PublicKey() Key {
+	var pub Key
+	curve25519.ScalarBaseMult((*[32]byte)(&pub), (*[32]byte)(&k))
+	return pub
+}
+
+// String returns k in base64.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// hex returns k in hex, as the configuration protocol of wireguard-go takes it.
+func (k Key) hex() string {
+	return hex.EncodeToString(k[:])
+}
@@ -0,0 +1,284 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package wg runs a userspace WireGuard device whose peers are reached over
+// flymesh streams, relayed or direct, instead of UDP: WireGuard encrypts the
+// IP layer while flymesh traverses the NATs.
+//
+// Both ends of a stream send a WireGuardHello, with their public key and the
+// prefixes routed to them, before the WireGuard packets. A peer is added to
+// the device for the lifetime of its stream.
+package wg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// ALPN marks the streams carrying WireGuard.
+const ALPN = "flymesh-wireguard"
+
+// helloTimeout bounds the WireGuardHello exchange at the start of a stream.
+const helloTimeout = 10 * time.Second
+
+var (
+	// ErrRouteNotAllowed is returned by Serve for a peer announcing a route
+	// outside Config.AllowedIPs.
+	ErrRouteNotAllowed = errors.New("route not allowed")
+	// ErrRouteConflict is returned by Serve for a peer announcing a route
+	// overlapping one of another connected peer.
+	ErrRouteConflict = errors.New("route of another peer")
+)
+
+// Config configures a Device.
+type Config struct {
+	// PrivateKey is the key of the device. Zero generates one, which peers
+	// learn anew on every stream.
+	PrivateKey Key
+	// Routes are the prefixes routed to this device, announced to its peers.
+	Routes []netip.Prefix
+	// AllowedIPs bound the routes a peer may announce: the packets to them
+	// go to that peer. A peer announcing any other route is refused.
+	AllowedIPs []netip.Prefix
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// wgPeer is a peer of a Device, reached over the stream of ep.
+type wgPeer struct {
+	ep     endpoint
+	routes []netip.Prefix
+}
+
+// Device is a WireGuard device over a TUN interface, see the package comment.
+type Device struct {
+	cfg    Config
+	logger *slog.Logger
+	bind   *streamBind
+	dev    *device.Device
+
+	mu    sync.Mutex
+	peers map[Key]*wgPeer
+}
+
+// New brings up a device over tunDev, which it closes with the device.
+func New(tunDev tun.Device, cfg Config) (*Device, error) {
+	if cfg.PrivateKey == (Key{}) {
+		k, err := GenerateKey()
+		if err != nil {
+			_ = tunDev.Close()
+			return nil, err
+		}
+		cfg.PrivateKey = k
+	}
+	logger := logging.Component(cfg.Logger, "wireguard")
+	d := &Device{
+		cfg:    cfg,
+		logger: logger,
+		bind:   newStreamBind(),
+		peers:  make(map[Key]*wgPeer),
+	}
+	d.dev = device.NewDevice(tunDev, d.bind, &device.Logger{
+		Verbosef: func(format string, args ...any) { logger.Debug(fmt.Sprintf(format, args...)) },
+		Errorf:   func(format string, args ...any) { logger.Warn(fmt.Sprintf(format, args...)) },
+	})
+	if err := d.dev.IpcSet("private_key=" + cfg.PrivateKey.hex() + "\n"); err != nil {
+		d.dev.Close()
+		return nil, fmt.Errorf("configure device: %w", err)
+	}
+	if err := d.dev.Up(); err != nil {
+		d.dev.Close()
+		return nil, fmt.Errorf("bring up device: %w", err)
+	}
+	return d, nil
+}
+
+// PublicKey returns the public key of the device.
+func (d *Device) PublicKey() Key {
+	return d.cfg.PrivateKey.PublicKey()
+}
+
+// Close takes the device down and closes its TUN interface. The streams of its
+// peers are left to their Serve calls.
+func (d *Device) Close() {
+	d.mu.Lock()
+	clear(d.peers)
+	d.mu.Unlock()
+	d.dev.Close()
+}
+
+// Serve exchanges WireGuardHello messages on conn and carries the packets of
+// the peer at its other end until conn fails or ctx ends. It closes conn.
+func (d *Device) Serve(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	key, routes, err := d.hello(conn)
+	if err != nil {
+		return err
+	}
+	logger := d.logger.With("public_key", key.String())
+	ep := d.bind.add(conn)
+	defer d.bind.remove(ep)
+	if err := d.addPeer(key, ep, routes); err != nil {
+		return err
+	}
+	defer d.removePeer(key, ep)
+	logger.Info("wireguard peer connected", "routes", routes)
+
+	err = d.bind.read(ep)
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+		err = nil
+	}
+	logger.Info("wireguard peer disconnected", "err", err)
+	return err
+}
+
+// hello sends the WireGuardHello of the device on conn and returns the public
+// key and routes of the one it receives.
+func (d *Device) hello(conn net.Conn) (Key, []netip.Prefix, error) {
+	pub := d.PublicKey()
+	msg := controlpb.WireGuardHello{PublicKey: pub[:]}
+	for _, p := range d.cfg.Routes {
+		msg.Routes = append(msg.Routes, p.String())
+	}
+	payload, err := msg.MarshalVT()
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("marshal WireGuardHello: %w", err)
+	}
+	if err := relay_protocol.WriteControlFrame(conn, relay_protocol.ControlTypeWireGuardHello, payload); err != nil {
+		return Key{}, nil, fmt.Errorf("write WireGuardHello: %w", err)
+	}
+	typ, data, err := relay_protocol.ReadControlFrame(conn, helloTimeout)
+	if err != nil {
+		return Key{}, nil, fmt.Errorf("read WireGuardHello: %w", err)
+	}
+	if typ != relay_protocol.ControlTypeWireGuardHello {
+		return Key{}, nil, fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var peer controlpb.WireGuardHello
+	if err := peer.UnmarshalVT(data); err != nil {
+		return Key{}, nil, fmt.Errorf("decode WireGuardHello: %w", err)
+	}
+	var key Key
+	if len(peer.GetPublicKey()) != len(key) {
+		return Key{}, nil, fmt.Errorf("bad public key of %d bytes", len(peer.GetPublicKey()))
+	}
+	copy(key[:], peer.GetPublicKey())
+	if key == pub {
+		return Key{}, nil, errors.New("peer has the key of this device")
+	}
+	routes, err := ParsePrefixes(peer.GetRoutes())
+	if err != nil {
+		return Key{}, nil, err
+	}
+	for _, r := range routes {
+		if !contains(d.cfg.AllowedIPs, r) {
+			return Key{}, nil, fmt.Errorf("%w: %s", ErrRouteNotAllowed, r)
+		}
+	}
+	return key, routes, nil
+}
+
+// addPeer adds the peer key reached over ep with routes, replacing an earlier
+// stream of key.
+func (d *Device) addPeer(key Key, ep endpoint, routes []netip.Prefix) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, p := range d.peers {
+		if k == key {
+			continue
+		}
+		for _, r := range routes {
+			if overlaps(p.routes, r) {
+				return fmt.Errorf("%w: %s", ErrRouteConflict, r)
+			}
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "public_key=%s\nreplace_allowed_ips=true\n", key.hex())
+	for _, r := range routes {
+		fmt.Fprintf(&b, "allowed_ip=%s\n", r)
+	}
+	fmt.Fprintf(&b, "endpoint=%s\n", ep)
+	if err := d.dev.IpcSet(b.String()); err != nil {
+		return fmt.Errorf("add peer: %w", err)
+	}
+	if old := d.peers[key]; old != nil {
+		// The peer is now reached over ep, its earlier stream ends.
+		if l := d.bind.link(old.ep); l != nil {
+			_ = l.conn.Close()
+		}
+	}
+	d.peers[key] = &wgPeer{ep: ep, routes: routes}
+	return nil
+}
+
+// removePeer removes the peer key, unless a later stream than ep replaced it.
+func (d *Device) removePeer(key Key, ep endpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p := d.peers[key]; p == nil || p.ep != ep {
+		return
+	}
+	delete(d.peers, key)
+	if err := d.dev.IpcSet("public_key=" + key.hex() + "\nremove=true\n"); err != nil {
+		d.logger.Warn("remove wireguard peer failed", "err", err)
+	}
+}
+
+// ParsePrefixes parses CIDR prefixes, a bare address being a prefix of its
+// own.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			a, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				return nil, fmt.Errorf("bad prefix %q: %w", s, err)
+			}
+			p = netip.PrefixFrom(a, a.BitLen())
+		}
+		if p != p.Masked() {
+			return nil, fmt.Errorf("bad prefix %q: host bits set, want %s", s, p.Masked())
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// contains reports whether one of prefixes contains all of p.
+func contains(prefixes []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q.Bits() <= p.Bits() && q.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether p overlaps one of prefixes.
+func overlaps(prefixes []netip.Prefix, p netip.Prefix) bool {
+	for _, q := range prefixes {
+		if q.Overlaps(p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package wg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/flymesh/core/pkg/flymeshtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func newDevice(t *testing.T, routes ...string) (*Device, *tuntest.ChannelTUN) {
+	t.Helper()
+	tunDev := tuntest.NewChannelTUN()
+	d, err := New(tunDev.TUN(), Config{
+		Routes:     mustPrefixes(t, routes...),
+		AllowedIPs: mustPrefixes(t, "10.9.0.0/24"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d, tunDev
+}
+
+func mustPrefixes(t *testing.T, list ...string) []netip.Prefix {
+	t.Helper()
+	prefixes, err := ParsePrefixes(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefixes
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, tunA := newDevice(t, "10.9.0.1/32")
+	b, tunB := newDevice(t, "10.9.0.2")
+	ca, cb := flymeshtest.TCPPair(t)
+	done := make(chan error, 2)
+	go func() { done <- a.Serve(ctx, ca) }()
+	go func() { done <- b.Serve(ctx, cb) }()
+	waitPeers(t, a, 1)
+	waitPeers(t, b, 1)
+
+	ping := tuntest.Ping(netip.MustParseAddr("10.9.0.2"), netip.MustParseAddr("10.9.0.1"))
+	tunA.Outbound <- ping
+	select {
+	case got := <-tunB.Inbound:
+		if !bytes.Equal(got, ping) {
+			t.Fatalf("received %x, want %x", got, ping)
+		}
+	case err := <-done:
+		t.Fatalf("Serve() err = %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping not received")
+	}
+
+	cancel()
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatalf("Serve() err = %v after ctx ended", err)
+		}
+	}
+}
+
+func TestServeRefusedRoute(t *testing.T) {
+	tests := []struct {
+		name    string
+		routes  []string
+		wantErr error
+	}{
+		{name: "outside AllowedIPs", routes: []string{"10.8.0.0/24"}, wantErr: ErrRouteNotAllowed},
+		{name: "wider than AllowedIPs", routes: []string{"10.0.0.0/8"}, wantErr: ErrRouteNotAllowed},
+		{name: "route of another peer", routes: []string{"10.9.0.0/30"}, wantErr: ErrRouteConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			server, _ := newDevice(t, "10.9.0.1/32")
+			first, _ := newDevice(t, "10.9.0.2/32")
+			c1, s1 := flymeshtest.TCPPair(t)
+			go func() { _ = first.Serve(ctx, c1) }()
+			go func() { _ = server.Serve(ctx, s1) }()
+			waitPeers(t, server, 1)
+
+			other, _ := newDevice(t, tt.routes...)
+			c2, s2 := flymeshtest.TCPPair(t)
+			go func() { _ = other.Serve(ctx, c2) }()
+			if err := server.Serve(ctx, s2); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Serve() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// waitPeers waits for d to have n peers.
+func waitPeers(t *testing.T, d *Device, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		got := len(d.peers)
+		d.mu.Unlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d peers, want %d", got, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+// Package wsconn carries byte streams over WebSocket, for networks that only
+// let HTTP(S) out. Each write is sent as one binary message.
+package wsconn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// IsURL reports whether endpoint is a ws:// or wss:// URL rather than a TCP
+// address.
+func IsURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "ws://") || strings.HasPrefix(endpoint, "wss://")
+}
+
+// Conn is a net.Conn over a WebSocket connection.
+type Conn struct {
+	ws *websocket.Conn
+
+	readMu sync.Mutex
+	r      io.Reader // current message
+
+	writeMu sync.Mutex
+}
+
+// New returns a net.Conn carried by ws.
+func New(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+// Dial connects to the ws:// or wss:// URL u over network, "tcp", "tcp4" or
+// "tcp6". A nil tlsConfig uses the system roots.
+func Dial(ctx context.Context, network string, u string, tlsConfig *tls.Config) (*Conn, error) {
+	var d net.Dialer
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  tlsConfig,
+		NetDialContext: func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	ws, resp, err := dialer.DialContext(ctx, u, nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(ws), nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		if c.r == nil {
+			typ, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, readError(err)
+			}
+			if typ != websocket.BinaryMessage {
+				continue
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(b)
+		if errors.Is(err, io.EOF) {
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// readError maps a normal closure to io.EOF.
+func readError(err error) error {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return io.EOF
+	}
+	return err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends a close message, without waiting for the peer's, and closes the
+// connection.
+func (c *Conn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	_ = c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return c.ws.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline. As with gorilla/websocket, a read
+// that timed out leaves the connection unusable.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
+
+// Listener accepts WebSocket connections on an HTTP(S) server, as a
+// net.Listener.
+type Listener struct {
+	ln       net.Listener
+	srv      *http.Server
+	upgrader websocket.Upgrader
+	conns    chan *Conn
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Listen serves WebSocket upgrades on path at address of network, over TLS if
+// tlsConfig is set.
+func Listen(network string, address string, path string, tlsConfig *tls.Config) (*Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(ln, path, tlsConfig), nil
+}
+
+// Serve serves WebSocket upgrades on path over ln, like Listen. Closing the
+// Listener closes ln.
+func Serve(ln net.Listener, path string, tlsConfig *tls.Config) *Listener {
+	l := &Listener{
+		ln: ln,
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout: 10 * time.Second,
+			// Peers are not browsers: there is no origin to check.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		conns: make(chan *Conn),
+		done:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, l)
+	l.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	go func() {
+		if tlsConfig != nil {
+			_ = l.srv.ServeTLS(ln, "", "")
+		} else {
+			_ = l.srv.Serve(ln)
+		}
+	}()
+	return l
+}
+
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	select {
+	case l.conns <- New(ws):
+	case <-l.done:
+		_ = ws.Close()
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the HTTP server. Connections already accepted stay open.
+func (l *Listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.srv.Close()
+	})
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
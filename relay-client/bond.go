@@ -0,0 +1,706 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Frames a bonded stream carries on each of its paths:
+//
+//	data: type(1) | seq(8) | length(4) | payload
+//	ack:  type(1) | seq(8), the frames before seq were consumed
+//	fin:  type(1) | seq(8), seq data frames were sent in all
+const (
+	bondFrameData byte = iota
+	bondFrameAck
+	bondFrameFin
+)
+
+const (
+	// bondIDLen is the length of the random ID of a bonded stream.
+	bondIDLen = 16
+	// bondMaxPaths caps the paths of a bonded stream.
+	bondMaxPaths = 4
+	// bondChunk is the largest payload of a data frame.
+	bondChunk = 16 << 10
+	// bondWindow caps the bytes written and not acknowledged yet, which the
+	// writer keeps to resend and the reader may have to buffer out of order.
+	bondWindow = 4 << 20
+	// bondAckEvery is how many bytes the reader consumes before it
+	// acknowledges them, unless it caught up with the writer first.
+	bondAckEvery = 256 << 10
+	// bondLinger bounds how long Close waits for the written data to be sent.
+	bondLinger = 5 * time.Second
+)
+
+// ErrBondLost is returned by a bonded stream once its last path failed.
+var ErrBondLost = errors.New("bonded stream lost all its paths")
+
+// bondConn is a stream striped over up to bondMaxPaths paths to the same peer,
+// each a Conn of its own. Writes are cut into numbered frames that the paths
+// pull from a shared queue as fast as they send, so that faster paths carry
+// more. The reader puts them back in order and acknowledges what it consumed.
+// The frames a failing path may have lost are resent on the others, so the
+// stream goes on as long as one path does.
+type bondConn struct {
+	id        []byte
+	local     peer.ID
+	remote    peer.ID
+	remoteKey crypto.PubKey
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	paths   []*bondPath
+	closing bool
+	closed  bool
+	err     error // set once the last path failed
+
+	// Writing side.
+	nextSeq       uint64
+	queue         []*bondFrame // frames to send, by seq
+	unacked       []*bondFrame // frames written and not acknowledged, by seq
+	inflight      int          // payload bytes of unacked
+	writeDeadline time.Time
+	deadlineTimer *time.Timer
+	finDue        bool
+	finPath       *bondPath // path sending the fin frame
+
+	// Reading side.
+	recvNext uint64
+	pending  map[uint64][]byte // frames received out of order
+	readBuf  []byte
+	finSeq   uint64
+	finRecv  bool
+	consumed int // bytes consumed since the last ack
+	ackDue   bool
+}
+
+type bondFrame struct {
+	seq    uint64
+	data   []byte
+	path   *bondPath // path the frame was last handed to
+	queued bool
+}
+
+type bondPath struct {
+	conn *Conn
+	done chan struct{}
+	dead bool
+}
+
+func newBondConn(id []byte, local, remote peer.ID, logger *slog.Logger) *bondConn {
+	b := &bondConn{
+		id:      id,
+		local:   local,
+		remote:  remote,
+		logger:  logger.With("bond", fmt.Sprintf("%x", id)),
+		pending: make(map[uint64][]byte),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// join adds conn as a path of b. It returns nil if b cannot take it: b is
+// closed, lost all its paths or has bondMaxPaths already.
+func (b *bondConn) join(conn *Conn) *bondPath {
+	b.mu.Lock()
+	if b.closing || b.err != nil || len(b.paths) >= bondMaxPaths {
+		b.mu.Unlock()
+		return nil
+	}
+	p := &bondPath{conn: conn, done: make(chan struct{})}
+	b.paths = append(b.paths, p)
+	if b.remoteKey == nil {
+		b.remoteKey = conn.RemotePublicKey()
+	}
+	n := len(b.paths)
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	b.logger.Info("bond path joined", "path", conn.Path(), "paths", n)
+	go b.send(p)
+	go b.receive(p)
+	return p
+}
+
+// send writes the frames of b to p until p fails or b is closed.
+func (b *bondConn) send(p *bondPath) {
+	w := bufio.NewWriterSize(p.conn, 2*bondChunk)
+	for {
+		var (
+			typ   byte
+			seq   uint64
+			frame *bondFrame
+			idle  bool
+		)
+		b.mu.Lock()
+		for {
+			if p.dead || b.closed {
+				b.mu.Unlock()
+				return
+			}
+			if b.ackDue {
+				b.ackDue = false
+				typ, seq = bondFrameAck, b.recvNext
+				break
+			}
+			if len(b.queue) > 0 {
+				frame = b.queue[0]
+				b.queue = b.queue[1:]
+				frame.path, frame.queued = p, false
+				typ, seq = bondFrameData, frame.seq
+				break
+			}
+			if b.finDue && b.finPath == nil {
+				b.finPath = p
+				typ, seq = bondFrameFin, b.nextSeq
+				break
+			}
+			if w.Buffered() > 0 {
+				// Nothing left to send for now.
+				idle = true
+				break
+			}
+			b.cond.Wait()
+		}
+		b.mu.Unlock()
+
+		var err error
+		if !idle {
+			err = writeBondFrame(w, typ, seq, frame)
+		}
+		if err == nil && (idle || typ == bondFrameFin) {
+			err = w.Flush()
+		}
+		if err != nil {
+			b.fail(p, err)
+			return
+		}
+		if typ == bondFrameFin {
+			b.mu.Lock()
+			b.finDue = false
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		}
+	}
+}
+
+// writeBondFrame writes a frame of typ, carrying frame for a data frame.
+func writeBondFrame(w *bufio.Writer, typ byte, seq uint64, frame *bondFrame) error {
+	var hdr [13]byte
+	hdr[0] = typ
+	binary.BigEndian.PutUint64(hdr[1:9], seq)
+	if frame == nil {
+		_, err := w.Write(hdr[:9])
+		return err
+	}
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(len(frame.data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame.data)
+	return err
+}
+
+// receive reads the frames p carries until it fails.
+func (b *bondConn) receive(p *bondPath) {
+	r := bufio.NewReaderSize(p.conn, 2*bondChunk)
+	var hdr [9]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			b.fail(p, err)
+			return
+		}
+		seq := binary.BigEndian.Uint64(hdr[1:])
+		switch hdr[0] {
+		case bondFrameData:
+			var l [4]byte
+			if _, err := io.ReadFull(r, l[:]); err != nil {
+				b.fail(p, err)
+				return
+			}
+			n := binary.BigEndian.Uint32(l[:])
+			if n > bondChunk {
+				b.fail(p, fmt.Errorf("bond frame of %d bytes", n))
+				return
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				b.fail(p, err)
+				return
+			}
+			b.received(seq, data)
+		case bondFrameAck:
+			b.acked(seq)
+		case bondFrameFin:
+			b.finished(seq)
+		default:
+			b.fail(p, fmt.Errorf("unknown bond frame type %d", hdr[0]))
+			return
+		}
+	}
+}
+
+func (b *bondConn) received(seq uint64, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if seq < b.recvNext {
+		// Resent after the path it was first sent on failed.
+		return
+	}
+	if _, ok := b.pending[seq]; ok {
+		return
+	}
+	b.pending[seq] = data
+	if seq == b.recvNext {
+		b.cond.Broadcast()
+	}
+}
+
+func (b *bondConn) acked(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := 0
+	for ; i < len(b.unacked) && b.unacked[i].seq < seq; i++ {
+		b.inflight -= len(b.unacked[i].data)
+	}
+	if i == 0 {
+		return
+	}
+	b.unacked = slices.Delete(b.unacked, 0, i)
+	// Frames queued for resending may have got through already.
+	b.queue = slices.DeleteFunc(b.queue, func(f *bondFrame) bool {
+		return f.seq < seq
+	})
+	b.cond.Broadcast()
+}
+
+func (b *bondConn) finished(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.finSeq, b.finRecv = seq, true
+	b.cond.Broadcast()
+}
+
+// fail drops p after err, handing the frames it may have lost to the other
+// paths.
+func (b *bondConn) fail(p *bondPath, err error) {
+	b.mu.Lock()
+	if p.dead {
+		b.mu.Unlock()
+		return
+	}
+	p.dead = true
+	close(p.done)
+	b.paths = slices.DeleteFunc(b.paths, func(q *bondPath) bool {
+		return q == p
+	})
+	resent := 0
+	for _, f := range b.unacked {
+		if f.path == p && !f.queued {
+			f.path, f.queued = nil, true
+			b.queue = append(b.queue, f)
+			resent++
+		}
+	}
+	slices.SortFunc(b.queue, func(x, y *bondFrame) int {
+		return cmp.Compare(x.seq, y.seq)
+	})
+	if b.finPath == p {
+		b.finPath = nil
+	}
+	left := len(b.paths)
+	// The peer closes its paths once done.
+	quiet := b.closing || b.finRecv
+	if left == 0 && b.err == nil {
+		b.err = fmt.Errorf("%w: %v", ErrBondLost, err)
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	_ = p.conn.Close()
+	if !quiet {
+		b.logger.Warn("bond path lost",
+			"path", p.conn.Path(),
+			"paths", left,
+			"resent_frames", resent,
+			"err", err)
+	}
+}
+
+func (b *bondConn) Read(buf []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if len(b.readBuf) > 0 {
+			n := copy(buf, b.readBuf)
+			b.readBuf = b.readBuf[n:]
+			return n, nil
+		}
+		if b.closed {
+			return 0, net.ErrClosed
+		}
+		if data, ok := b.pending[b.recvNext]; ok {
+			delete(b.pending, b.recvNext)
+			b.recvNext++
+			b.readBuf = data
+			b.consumed += len(data)
+			if b.consumed >= bondAckEvery || len(b.pending) == 0 {
+				b.consumed = 0
+				b.ackDue = true
+				b.cond.Broadcast()
+			}
+			continue
+		}
+		if b.finRecv && b.recvNext >= b.finSeq {
+			return 0, io.EOF
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		b.cond.Wait()
+	}
+}
+
+func (b *bondConn) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	written := 0
+	for len(data) > 0 {
+		for {
+			switch {
+			case b.closing:
+				return written, net.ErrClosed
+			case b.err != nil:
+				return written, b.err
+			case !b.writeDeadline.IsZero() && !time.Now().Before(b.writeDeadline):
+				return written, os.ErrDeadlineExceeded
+			}
+			if b.inflight < bondWindow {
+				break
+			}
+			b.cond.Wait()
+		}
+		n := min(len(data), bondChunk)
+		f := &bondFrame{seq: b.nextSeq, data: slices.Clone(data[:n]), queued: true}
+		b.nextSeq++
+		b.queue = append(b.queue, f)
+		b.unacked = append(b.unacked, f)
+		b.inflight += n
+		data = data[n:]
+		written += n
+		b.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// Close sends what was written, within bondLinger, then the fin frame and
+// closes every path.
+func (b *bondConn) Close() error {
+	b.mu.Lock()
+	if b.closing {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closing = true
+	expired := false
+	timer := time.AfterFunc(bondLinger, func() {
+		b.mu.Lock()
+		expired = true
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	for len(b.queue) > 0 && len(b.paths) > 0 && !expired {
+		b.cond.Wait()
+	}
+	b.finDue = true
+	b.cond.Broadcast()
+	for b.finDue && len(b.paths) > 0 && !expired {
+		b.cond.Wait()
+	}
+	timer.Stop()
+	b.closed = true
+	if b.deadlineTimer != nil {
+		b.deadlineTimer.Stop()
+	}
+	paths := b.paths
+	b.paths = nil
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	for _, p := range paths {
+		_ = p.conn.Close()
+	}
+	return nil
+}
+
+// done reports whether b is closed or lost all its paths.
+func (b *bondConn) done() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closing || b.err != nil
+}
+
+func (b *bondConn) LocalAddr() net.Addr {
+	return PeerAddr{ID: b.local}
+}
+
+func (b *bondConn) RemoteAddr() net.Addr {
+	return PeerAddr{ID: b.remote}
+}
+
+func (b *bondConn) SetDeadline(t time.Time) error {
+	return b.SetWriteDeadline(t)
+}
+
+// SetReadDeadline is a no-op: Conn applies the read deadline, see
+// deadlineReader.
+func (b *bondConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (b *bondConn) SetWriteDeadline(t time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writeDeadline = t
+	if b.deadlineTimer != nil {
+		b.deadlineTimer.Stop()
+		b.deadlineTimer = nil
+	}
+	if !t.IsZero() {
+		b.deadlineTimer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+func (b *bondConn) LocalPeer() peer.ID {
+	return b.local
+}
+
+func (b *bondConn) RemotePeer() peer.ID {
+	return b.remote
+}
+
+func (b *bondConn) RemotePublicKey() crypto.PubKey {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remoteKey
+}
+
+func (b *bondConn) ConnState() network.ConnectionState {
+	return network.ConnectionState{}
+}
+
+// bondPathTimeout bounds the dial of the paths of a bonded stream, which may
+// outlive OpenStream.
+const bondPathTimeout = time.Minute
+
+// bond opens a direct and a relayed path to serverPeerId for one bonded stream,
+// returned as soon as one of them is set up. The other joins it in the
+// background, if it can be set up at all.
+func (r *ClientRole) bond(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination) (*Conn, error) {
+	hello := relay_protocol.PeerHello(h, serverPeerId)
+	if hello == nil && relay_protocol.SupportsHello(h, serverPeerId) {
+		hello, _ = relay_protocol.GetInfo(ctx, h, serverPeerId, relay_protocol.NewHello(nil))
+	}
+	if !relay_protocol.HasFeature(hello, relay_protocol.FeatureBond) {
+		r.logger().Debug("server does not bond streams, racing the paths instead",
+			logging.KeyPeer, serverPeerId.String())
+		return r.race(ctx, h, serverPeerId, dst)
+	}
+	id := make([]byte, bondIDLen)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	b := newBondConn(id, h.ID(), serverPeerId, logging.Component(r.Logger, "bond"))
+
+	// Once a path is up, the other is left to join after OpenStream returned,
+	// so the dials are only bound by ctx until then.
+	pathCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bondPathTimeout)
+	stop := context.AfterFunc(ctx, cancel)
+	results := make(chan dialResult, 2)
+	for _, open := range []func(context.Context, host.Host, peer.ID, Destination, []byte) (*Conn, error){r.openDirect, r.openRelayed} {
+		go func() {
+			conn, err := open(pathCtx, h, serverPeerId, dst, id)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+	// rest joins the n paths still dialing to b, or closes them if joined is
+	// false.
+	rest := func(n int, joined bool) {
+		go func() {
+			defer cancel()
+			for ; n > 0; n-- {
+				res := <-results
+				switch {
+				case res.err != nil:
+					if joined {
+						r.logger().Warn("bond path failed, going on with one path",
+							logging.KeyPeer, serverPeerId.String(),
+							"err", res.err)
+					}
+				case !joined || b.join(res.conn) == nil:
+					_ = res.conn.Close()
+				}
+			}
+		}()
+	}
+
+	var errs []error
+	for pending := 2; pending > 0; {
+		res := <-results
+		pending--
+		if permanent(res.err) {
+			// The other path would be refused the same way.
+			stop()
+			cancel()
+			rest(pending, false)
+			return nil, res.err
+		}
+		if res.err != nil {
+			errs = append(errs, res.err)
+			continue
+		}
+		stop()
+		b.join(res.conn)
+		rest(pending, true)
+		return newConn(b, &StreamInfo{
+			IsServer:     false,
+			LocalPeerID:  h.ID(),
+			RemotePeerID: serverPeerId,
+			Destination:  dst,
+			Bond:         id,
+		}), nil
+	}
+	stop()
+	cancel()
+	return nil, errors.Join(errs...)
+}
+
+// checkBond checks the bond ID of a stream request, if any.
+func checkBond(req *controlpb.StartRelayStreamRequest) error {
+	if n := len(req.GetBond()); n != 0 && n != bondIDLen {
+		return fmt.Errorf("%w: bond ID of %d bytes", ErrBadRequest, n)
+	}
+	return nil
+}
+
+type bondKey struct {
+	client peer.ID
+	id     string
+}
+
+// bondRegistry tracks the bonded streams of a ServerRole by client and ID, for
+// their later paths to join. Its zero value is ready to use.
+type bondRegistry struct {
+	mu    sync.Mutex
+	bonds map[bondKey]*bondEntry
+}
+
+type bondEntry struct {
+	conn *bondConn
+	dst  Destination
+}
+
+// join adds conn, the path info describes, to its bonded stream. It returns
+// the stream and the path, with created telling whether conn is its first
+// path, or a nil path if conn was refused.
+func (g *bondRegistry) join(info *StreamInfo, conn *Conn, logger *slog.Logger) (b *bondConn, p *bondPath, created bool) {
+	key := bondKey{info.RemotePeerID, string(info.Bond)}
+	g.mu.Lock()
+	e, ok := g.bonds[key]
+	if ok && (e.dst != info.Destination || e.conn.done()) {
+		g.mu.Unlock()
+		return nil, nil, false
+	}
+	if !ok {
+		e = &bondEntry{
+			conn: newBondConn(info.Bond, info.LocalPeerID, info.RemotePeerID, logger),
+			dst:  info.Destination,
+		}
+		if g.bonds == nil {
+			g.bonds = make(map[bondKey]*bondEntry)
+		}
+		g.bonds[key] = e
+	}
+	g.mu.Unlock()
+	if p = e.conn.join(conn); p == nil {
+		if !ok {
+			g.forget(key, e.conn)
+		}
+		return nil, nil, false
+	}
+	return e.conn, p, !ok
+}
+
+// forget drops b, which is done. Paths of b still dialing keep being refused
+// until the client gave up on them.
+func (g *bondRegistry) forget(key bondKey, b *bondConn) {
+	time.AfterFunc(bondPathTimeout, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if e, ok := g.bonds[key]; ok && e.conn == b {
+			delete(g.bonds, key)
+		}
+	})
+}
+
+// serveBondPath joins conn to its bonded stream. The first path passes the
+// stream to r.Handler, the others last as long as they carry it.
+func (r *ServerRole) serveBondPath(info *StreamInfo, conn *Conn, logger *slog.Logger) {
+	b, p, created := r.bonds.join(info, conn, logging.Component(r.Logger, "bond"))
+	if p == nil {
+		logger.Info("bond path refused", logging.KeyStreamID, info.StreamID, "path", conn.Path())
+		_ = conn.Close()
+		return
+	}
+	if !created {
+		<-p.done
+		return
+	}
+	defer r.bonds.forget(bondKey{info.RemotePeerID, string(info.Bond)}, b)
+	bondInfo := &StreamInfo{
+		IsServer:     true,
+		LocalPeerID:  info.LocalPeerID,
+		RemotePeerID: info.RemotePeerID,
+		Destination:  info.Destination,
+		Bond:         info.Bond,
+	}
+	bonded := newConn(b, bondInfo)
+	// Count the bytes of a guest grant once, on the bonded stream.
+	bonded.grant, conn.grant = conn.grant, nil
+	bonded.features = conn.features
+	logger.Info("bonded stream opened", "destination", info.Destination.String())
+	r.Handler(bondInfo, bonded)
+	_ = bonded.Close()
+	<-p.done
+}
@@ -5,61 +5,418 @@ package relay_client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 	"time"
 
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/obfs"
 	controlpb "github.com/flymesh/core/pkg/pb/control"
 	"github.com/flymesh/core/pkg/protocol"
 	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/sec"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// DialStrategy selects the paths OpenStream tries.
+type DialStrategy string
+
+const (
+	// DialRelay always goes through the relay-server.
+	DialRelay DialStrategy = "relay"
+	// DialRace races a direct libp2p stream against the relay path and keeps
+	// whichever is set up first.
+	DialRace DialStrategy = "race"
+	// DialBond opens both a direct libp2p stream and a relayed one and stripes
+	// the data over them, going on over the other when one fails. Servers
+	// without relay_protocol.FeatureBond get DialRace instead. Experimental.
+	DialBond DialStrategy = "bond"
+	// DialNotify goes through the relay-server NotifyRelay, which notifies
+	// the server of the request over its registration, see
+	// ServerRole.ServeNotify. It needs no libp2p connection to the server.
+	DialNotify DialStrategy = "notify"
+)
+
+// ParseDialStrategy parses relay, race, bond or notify.
+func ParseDialStrategy(s string) (DialStrategy, error) {
+	switch d := DialStrategy(s); d {
+	case DialRelay, DialRace, DialBond, DialNotify:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown dial strategy %q (want relay, race, bond or notify)", s)
+	}
+}
+
 type ClientRole struct {
 	PrivKey crypto.PrivKey
+	// Strategy selects the paths OpenStream tries. Empty means DialRelay.
+	Strategy DialStrategy
+	// DirectHeadStart is how long DialRace lets the direct attempt run alone
+	// before it also starts the relay path.
+	DirectHeadStart time.Duration
+	// RelayRetries is how many times a failed relay dial is retried with a new
+	// allocation requested from the server. Retries are paced by a backoff
+	// doubling from relayRetryBackoff.
+	RelayRetries int
+	// RequireSessionBinding refuses relayed streams from servers that do not
+	// bind the Noise handshake to the allocation, see StreamInfo.BindSession.
+	RequireSessionBinding bool
+	// Compression lists the algorithms offered to compress relayed streams
+	// with, most preferred first, see ParseCompression. Empty disables
+	// compression.
+	Compression []string
+	// Obfuscator, if set, disguises the connections to relay-servers, which
+	// must be configured with the same one.
+	Obfuscator obfs.Obfuscator
+	// RelayTLSConfig, if set, verifies the tls:// and wss:// endpoints of
+	// relay-servers instead of the system roots.
+	RelayTLSConfig *tls.Config
+	// RelayMACs lists the MAC algorithms relay-servers may authenticate
+	// their relay frames with, most preferred first, see
+	// relay_protocol.ParseMACs. Empty announces the defaults.
+	RelayMACs []relay_protocol.MAC
+	// IPv6Only dials relay-servers over IPv6 only.
+	IPv6Only bool
+	// NotifyRelay is the relay-server DialNotify requests streams from.
+	NotifyRelay peer.ID
+	// Priority is the priority of the streams opened to a Destination that
+	// has none, see ParsePriority.
+	Priority controlpb.StreamPriority
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Metrics receives the metrics of the streams opened. If nil, they are
+	// dropped.
+	Metrics metrics.Metrics
 }
 
-func (r *ClientRole) OpenStream(ctx context.Context, h host.Host, serverPeerId peer.ID) (sec.SecureConn, error) {
-	streamInfo, err := r.RequestStream(ctx, h, serverPeerId)
+func (r *ClientRole) logger() *slog.Logger {
+	return logging.Component(r.Logger, "client")
+}
+
+func (r *ClientRole) metrics() metrics.Metrics {
+	return metrics.Or(r.Metrics)
+}
+
+// OpenStream opens a stream to serverPeerId, bridged to dst, along the paths of
+// r.Strategy.
+func (r *ClientRole) OpenStream(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination) (*Conn, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanOpenStream,
+		trace.WithAttributes(tracing.AttrPeer.String(serverPeerId.String())))
+	if dst.Priority == controlpb.StreamPriority_STREAM_PRIORITY_UNSPECIFIED {
+		dst.Priority = r.Priority
+	}
+	var (
+		conn    *Conn
+		err     error
+		started = time.Now()
+	)
+	switch r.Strategy {
+	case "", DialRelay, DialNotify:
+		conn, err = r.openRelayed(ctx, h, serverPeerId, dst, nil)
+	case DialRace:
+		conn, err = r.race(ctx, h, serverPeerId, dst)
+	case DialBond:
+		conn, err = r.bond(ctx, h, serverPeerId, dst)
+	default:
+		err = fmt.Errorf("unknown dial strategy %q", r.Strategy)
+	}
+	if conn != nil {
+		conn.features = relay_protocol.PeerHello(h, serverPeerId).GetFeatures()
+		span.SetAttributes(tracing.AttrPath.String(conn.Path()))
+	}
+	tracing.End(span, err)
 	if err != nil {
+		r.metrics().Counter(metrics.ClientStreamErrors).Add(1)
 		return nil, err
 	}
-	return DialRelayStream(ctx, r.PrivKey, streamInfo)
+	r.metrics().Counter(metrics.ClientStreams, conn.Path()).Add(1)
+	r.metrics().Histogram(metrics.ClientStreamSetup).Observe(time.Since(started).Seconds())
+	return conn, nil
+}
+
+// Pause before the first relay retry, doubled for each further one up to
+// maxRelayRetryBackoff, so that failing dials do not churn relay allocations.
+const (
+	relayRetryBackoff    = 250 * time.Millisecond
+	maxRelayRetryBackoff = 4 * time.Second
+)
+
+// openRelayed requests an allocation from the server and dials it, as a path of
+// the bonded stream bond if not nil. When the dial fails, the retry carries the
+// cookie of the failed allocation so that the relay drops it right away.
+func (r *ClientRole) openRelayed(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, bond []byte) (*Conn, error) {
+	request := r.requestStream
+	if r.Strategy == DialNotify {
+		request = r.requestNotified
+	}
+	var retryCookie []byte
+	backoff := relayRetryBackoff
+	for attempt := 0; ; attempt++ {
+		streamInfo, err := request(ctx, h, serverPeerId, dst, retryCookie, bond)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := DialRelayStream(ctx, r.PrivKey, streamInfo)
+		if err == nil {
+			return conn, nil
+		}
+		if attempt >= r.RelayRetries || ctx.Err() != nil {
+			return nil, err
+		}
+		r.logger().Warn("relay dial failed, retrying",
+			logging.KeyPeer, serverPeerId.String(),
+			logging.KeyStreamID, streamInfo.StreamID,
+			"attempt", attempt+1,
+			"backoff", backoff,
+			"err", err)
+		retryCookie = streamInfo.RetryCookie
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff = min(2*backoff, maxRelayRetryBackoff)
+	}
+}
+
+// openDirect opens a stream to serverPeerId on a direct connection, as a path of
+// the bonded stream bond if not nil. libp2p waits for hole punching if the peer
+// is only reachable through a circuit relay.
+func (r *ClientRole) openDirect(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, bond []byte) (*Conn, error) {
+	s, err := h.NewStream(ctx, serverPeerId, protocol.ProtoServerDirect)
+	if err != nil {
+		return nil, fmt.Errorf("open direct stream: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.Reset()
+	})
+	defer stop()
+	if _, err := exchangeStartRelay(ctx, h, s, dst, nil, nil, bond); err != nil {
+		_ = s.Reset()
+		return nil, fmt.Errorf("direct stream: %w", err)
+	}
+	return newConn(streamConn{s}, &StreamInfo{
+		IsServer:     false,
+		LocalPeerID:  h.ID(),
+		RemotePeerID: serverPeerId,
+		Destination:  dst,
+		Direct:       true,
+		Bond:         bond,
+	}), nil
 }
 
-func (r *ClientRole) RequestStream(ctx context.Context, h host.Host, serverPeerId peer.ID) (*StreamInfo, error) {
+type dialResult struct {
+	conn *Conn
+	err  error
+}
+
+// race starts the direct path, then the relay path once the direct one failed
+// or had DirectHeadStart alone. It returns the first conn set up and cancels or
+// closes the other. Servers whose Hello lacks FeatureDirectStream only get the
+// relay path.
+func (r *ClientRole) race(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination) (*Conn, error) {
+	if hello := relay_protocol.PeerHello(h, serverPeerId); hello != nil && !relay_protocol.HasFeature(hello, relay_protocol.FeatureDirectStream) {
+		return r.openRelayed(ctx, h, serverPeerId, dst, nil)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, 2)
+	dial := func(open func(context.Context, host.Host, peer.ID, Destination, []byte) (*Conn, error)) {
+		go func() {
+			conn, err := open(ctx, h, serverPeerId, dst, nil)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+	dial(r.openDirect)
+	pending := 1
+	headStart := time.NewTimer(r.DirectHeadStart)
+	defer headStart.Stop()
+	relayStarted := false
+	startRelay := func() {
+		if !relayStarted {
+			relayStarted = true
+			pending++
+			dial(r.openRelayed)
+		}
+	}
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-headStart.C:
+			startRelay()
+		case res := <-results:
+			pending--
+			if permanent(res.err) {
+				// The other path would be refused the same way.
+				return nil, res.err
+			}
+			if res.err != nil {
+				errs = append(errs, res.err)
+				startRelay()
+				continue
+			}
+			// Close the loser should it complete despite the cancellation.
+			go func(n int) {
+				for ; n > 0; n-- {
+					if l := <-results; l.err == nil {
+						_ = l.conn.Close()
+					}
+				}
+			}(pending)
+			r.logger().Debug("dial race won",
+				logging.KeyPeer, serverPeerId.String(),
+				"path", res.conn.Path())
+			return res.conn, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// RequestStream asks the server for a relay allocation bridged to dst.
+// retryCookie is the RetryCookie of an allocation that could not be dialed, or
+// nil.
+func (r *ClientRole) RequestStream(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, retryCookie []byte) (*StreamInfo, error) {
+	return r.requestStream(ctx, h, serverPeerId, dst, retryCookie, nil)
+}
+
+// requestStream is RequestStream for a path of the bonded stream bond, if not
+// nil.
+func (r *ClientRole) requestStream(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, retryCookie, bond []byte) (*StreamInfo, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanStartRelayStream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrPeer.String(serverPeerId.String())))
+	info, err := r.startRelayStream(ctx, h, serverPeerId, dst, retryCookie, bond)
+	if info != nil {
+		span.SetAttributes(tracing.AttrStreamID.Int64(int64(info.StreamID)))
+	}
+	tracing.End(span, err)
+	return info, err
+}
+
+func (r *ClientRole) startRelayStream(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, retryCookie, bond []byte) (*StreamInfo, error) {
 	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), serverPeerId, protocol.ProtoServerStartRelay)
 	if err != nil {
 		return nil, err
 	}
 	defer stream.Close()
 
-	// Send empty StartRelayStreamRequest
-	if err := relay_protocol.WriteControlFrame(stream, relay_protocol.ControlTypeStartRelayStreamRequest, nil); err != nil {
+	resp, err := exchangeStartRelay(ctx, h, stream, dst, retryCookie, r.Compression, bond)
+	if err != nil {
 		return nil, err
 	}
+	if r.RequireSessionBinding && !resp.GetBindSession() {
+		return nil, ErrSessionNotBound
+	}
+	if c := resp.GetCompression(); c != "" && !slices.Contains(r.Compression, c) {
+		return nil, fmt.Errorf("server picked compression %q, not offered", c)
+	}
+
+	r.logger().Info("relay stream assigned",
+		logging.KeyPeer, serverPeerId.String(),
+		logging.KeyStreamID, resp.GetStreamId(),
+		"relay_endpoint", resp.GetRelayEndpoint(),
+		"compression", resp.GetCompression())
+
+	return &StreamInfo{
+		RelayEndpoint: resp.GetRelayEndpoint(),
+		StreamID:      resp.GetStreamId(),
+		Token:         resp.GetToken(),
+		IsServer:      false,
+		LocalPeerID:   h.ID(),
+		RemotePeerID:  serverPeerId,
+		Destination:   dst,
+		RetryCookie:   resp.GetRetryCookie(),
+		BindSession:   resp.GetBindSession(),
+		Compression:   resp.GetCompression(),
+		Obfuscator:    r.Obfuscator,
+		TLSConfig:     r.RelayTLSConfig,
+		RelayMACs:     r.RelayMACs,
+		IPv6Only:      r.IPv6Only,
+		Bond:          bond,
+
+		RelayEndpoints: resp.GetRelayEndpoints(),
+	}, nil
+}
+
+// requestNotified has r.NotifyRelay allocate a stream to serverPeerId, bridged
+// to dst, and notify the server of it, as a path of the bonded stream bond if
+// not nil.
+func (r *ClientRole) requestNotified(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, retryCookie, bond []byte) (*StreamInfo, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanStartRelayStream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrPeer.String(r.NotifyRelay.String())))
+	info, err := r.notifyRelay(ctx, h, serverPeerId, dst, retryCookie, bond)
+	if info != nil {
+		span.SetAttributes(tracing.AttrStreamID.Int64(int64(info.StreamID)))
+	}
+	tracing.End(span, err)
+	return info, err
+}
 
-	// Read StartRelayStreamResponse
-	typ, data, err := relay_protocol.ReadControlFrame(stream, time.Second*10)
+func (r *ClientRole) notifyRelay(ctx context.Context, h host.Host, serverPeerId peer.ID, dst Destination, retryCookie, bond []byte) (*StreamInfo, error) {
+	if r.NotifyRelay == "" {
+		return nil, errors.New("no relay-server to notify the server through")
+	}
+	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), r.NotifyRelay, protocol.ProtoRelayCreate)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("open relay-server create-stream: %w", err)
 	}
-	if typ != relay_protocol.ControlTypeStartRelayStreamResponse {
-		return nil, fmt.Errorf("unknown type: 0x%x", typ)
+	defer stream.Close()
+
+	req := controlpb.CreateStreamRequest{
+		TraceContext: tracing.Inject(ctx),
+		RetryCookie:  retryCookie,
+		Notify: &controlpb.StartRelayStreamRequest{
+			Service:       dst.Service,
+			TargetAddress: dst.Address,
+			Alpn:          dst.ALPN,
+			BindSession:   true,
+			Bond:          bond,
+			Priority:      dst.Priority,
+		},
+		Priority: dst.Priority,
 	}
-	var resp controlpb.StartRelayStreamResponse
+	req.ClientPeerId, err = serverPeerId.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal server peer id: %w", err)
+	}
+	payload, err := req.MarshalVT()
+	if err != nil {
+		return nil, fmt.Errorf("marshal CreateStreamRequest: %w", err)
+	}
+	if err := relay_protocol.WriteControlRequest(h, stream, relay_protocol.NewHello(nil), relay_protocol.ControlTypeCreateStreamRequest, payload); err != nil {
+		return nil, fmt.Errorf("write CreateStreamRequest: %w", err)
+	}
+
+	typ, data, err := relay_protocol.ReadControlResponse(h, stream, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("read CreateStreamResponse: %w", err)
+	}
+	if typ != relay_protocol.ControlTypeCreateStreamResponse {
+		return nil, fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var resp controlpb.CreateStreamResponse
 	if err := resp.UnmarshalVT(data); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("decode CreateStreamResponse: %w", err)
 	}
 	if !resp.GetOk() {
-		return nil, fmt.Errorf("server error: %s", resp.GetError())
+		return nil, &RelayError{Code: resp.GetErrorCode(), Message: resp.GetError()}
 	}
 
-	log.Printf("[client] relay-server endpoint: %s, streamID=%d", resp.GetRelayEndpoint(), resp.GetStreamId())
+	r.logger().Info("relay stream notified",
+		logging.KeyPeer, serverPeerId.String(),
+		logging.KeyStreamID, resp.GetStreamId(),
+		"relay_endpoint", resp.GetRelayEndpoint())
 
 	return &StreamInfo{
 		RelayEndpoint: resp.GetRelayEndpoint(),
@@ -68,5 +425,56 @@ func (r *ClientRole) RequestStream(ctx context.Context, h host.Host, serverPeerI
 		IsServer:      false,
 		LocalPeerID:   h.ID(),
 		RemotePeerID:  serverPeerId,
+		Destination:   dst,
+		RetryCookie:   resp.GetRetryCookie(),
+		BindSession:   true,
+		Obfuscator:    r.Obfuscator,
+		TLSConfig:     r.RelayTLSConfig,
+		RelayMACs:     r.RelayMACs,
+		IPv6Only:      r.IPv6Only,
+		Bond:          bond,
+
+		RelayEndpoints:  resp.GetRelayEndpoints(),
+		ObservedAddress: resp.GetObservedAddress(),
 	}, nil
 }
+
+// exchangeStartRelay sends a StartRelayStreamRequest for dst on s, which h
+// opened, and reads the successful response. compression is offered for a
+// relayed stream; bond, if not nil, is the bonded stream it is a path of.
+func exchangeStartRelay(ctx context.Context, h host.Host, s network.Stream, dst Destination, retryCookie []byte, compression []string, bond []byte) (*controlpb.StartRelayStreamResponse, error) {
+	req := controlpb.StartRelayStreamRequest{
+		TraceContext:  tracing.Inject(ctx),
+		RetryCookie:   retryCookie,
+		Service:       dst.Service,
+		TargetAddress: dst.Address,
+		Alpn:          dst.ALPN,
+		BindSession:   true,
+		Compression:   compression,
+		Bond:          bond,
+		Priority:      dst.Priority,
+	}
+	payload, err := req.MarshalVT()
+	if err != nil {
+		return nil, err
+	}
+	if err := relay_protocol.WriteControlRequest(h, s, relay_protocol.NewHello(nil), relay_protocol.ControlTypeStartRelayStreamRequest, payload); err != nil {
+		return nil, err
+	}
+
+	typ, data, err := relay_protocol.ReadControlResponse(h, s, time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+	if typ != relay_protocol.ControlTypeStartRelayStreamResponse {
+		return nil, fmt.Errorf("unknown type: 0x%x", typ)
+	}
+	var resp controlpb.StartRelayStreamResponse
+	if err := resp.UnmarshalVT(data); err != nil {
+		return nil, err
+	}
+	if !resp.GetOk() {
+		return nil, &ServerError{Code: resp.GetErrorCode(), Message: resp.GetError()}
+	}
+	return &resp, nil
+}
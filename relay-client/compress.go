@@ -0,0 +1,110 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/sec"
+)
+
+// Compression algorithms of relayed streams.
+const (
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// ParseCompression checks that names are compression algorithms.
+func ParseCompression(names []string) error {
+	for _, name := range names {
+		switch name {
+		case CompressionZstd, CompressionSnappy:
+		default:
+			return fmt.Errorf("unknown compression %q (want zstd or snappy)", name)
+		}
+	}
+	return nil
+}
+
+// negotiateCompression returns the first algorithm of the client's offer the
+// server accepts, or "" for none.
+func negotiateCompression(offer, accept []string) string {
+	for _, name := range offer {
+		if ParseCompression([]string{name}) == nil && slices.Contains(accept, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// flushWriter is a compressing writer.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// compressedConn compresses both directions of a sec.SecureConn. Every Write
+// is flushed, so interactive protocols are not delayed for a fuller block.
+// Both codecs run synchronously, so there is nothing to release on close.
+type compressedConn struct {
+	sec.SecureConn
+	r io.Reader
+
+	writeMu sync.Mutex
+	w       flushWriter
+}
+
+// compress layers algorithm over sconn, or returns sconn for "".
+func compress(sconn sec.SecureConn, algorithm string) (sec.SecureConn, error) {
+	c := &compressedConn{SecureConn: sconn}
+	switch algorithm {
+	case "":
+		return sconn, nil
+	case CompressionZstd:
+		w, err := zstd.NewWriter(sconn,
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithWindowSize(1<<20))
+		if err != nil {
+			return nil, err
+		}
+		r, err := zstd.NewReader(sconn,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxWindow(1<<20))
+		if err != nil {
+			return nil, err
+		}
+		c.w, c.r = w, r
+	case CompressionSnappy:
+		c.w = s2.NewWriter(sconn, s2.WriterSnappyCompat(), s2.WriterConcurrency(1))
+		c.r = s2.NewReader(sconn)
+	default:
+		return nil, fmt.Errorf("unknown compression %q", algorithm)
+	}
+	return c, nil
+}
+
+func (c *compressedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Write compresses and sends b. On failure no part of b counts as written,
+// since the peer cannot decode a partly sent block anyway.
+func (c *compressedConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.w.Write(b); err != nil {
+		return 0, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Paths a Conn can take to the remote peer.
+const (
+	PathRelay  = "relay"
+	PathDirect = "direct"
+	// PathBond is a stream striped over several paths, see DialBond.
+	PathBond = "bond"
+)
+
+// PeerAddr is the net.Addr of one end of a Conn: the peer ID.
+type PeerAddr struct {
+	ID peer.ID
+}
+
+func (a PeerAddr) Network() string {
+	return "flymesh"
+}
+
+func (a PeerAddr) String() string {
+	return a.ID.String()
+}
+
+// ConnStats is a snapshot of the activity and path of a Conn.
+type ConnStats struct {
+	StreamID     uint64
+	BytesRead    uint64
+	BytesWritten uint64
+	Opened       time.Time
+	LastActivity time.Time
+	// Path is PathRelay, PathDirect or PathBond.
+	Path string
+	// RelayEndpoint is the relay-server address the stream was dialed to.
+	RelayEndpoint string
+	// TransportLocalAddr and TransportRemoteAddr are the addresses of the
+	// connection carrying the stream: the TCP connection to the relay-server,
+	// the direct libp2p connection, or the peer IDs for a bonded stream.
+	TransportLocalAddr  net.Addr
+	TransportRemoteAddr net.Addr
+}
+
+// Conn is a stream secured end-to-end with the remote peer, carried by the
+// relay-server or by a direct libp2p connection. It counts the bytes it carries,
+// and its LocalAddr and RemoteAddr are the peer IDs of both ends rather than the
+// addresses of the underlying connection.
+//
+// Deadlines follow net.Conn: a Read past the read deadline fails with
+// os.ErrDeadlineExceeded and the stream can still be read once the deadline is
+// extended. As with crypto/tls, a Write that timed out may have sent part of
+// an encrypted message, so all later writes fail with the same error.
+type Conn struct {
+	sec.SecureConn
+
+	info         *StreamInfo
+	opened       time.Time
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	lastActivity atomic.Int64
+	// grant is the guest grant the conn was admitted under, or nil.
+	grant *grant
+	// features are those of the Hello of the remote peer.
+	features []string
+
+	reader   deadlineReader
+	writeErr atomic.Pointer[error]
+
+	closeMu  sync.Mutex
+	closed   bool
+	onClose  []func(ConnStats)
+	closeErr error
+}
+
+func newConn(sconn sec.SecureConn, info *StreamInfo) *Conn {
+	c := &Conn{
+		SecureConn: sconn,
+		info:       info,
+		opened:     time.Now(),
+		reader:     deadlineReader{r: sconn},
+	}
+	c.lastActivity.Store(c.opened.UnixNano())
+	return c
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	b, err := c.limit(b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.reader.read(b)
+	if n > 0 && c.grant != nil {
+		c.grant.used.Add(int64(n))
+	}
+	if n > 0 {
+		c.bytesRead.Add(uint64(n))
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.writeErr.Load(); err != nil {
+		return 0, *err
+	}
+	p, err := c.take(b)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.SecureConn.Write(p)
+	c.giveBack(len(p) - n)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.writeErr.CompareAndSwap(nil, &err)
+	}
+	if n > 0 {
+		c.bytesWritten.Add(uint64(n))
+		c.lastActivity.Store(time.Now().UnixNano())
+	}
+	if err == nil && len(p) < len(b) {
+		_ = c.Close()
+		err = ErrGrantExhausted
+	}
+	return n, err
+}
+
+// limit limits b to the bytes left under the grant of c, closing c once none
+// are. A read may block for long, so it does not reserve them.
+func (c *Conn) limit(b []byte) ([]byte, error) {
+	if c.grant == nil || len(b) == 0 {
+		return b, nil
+	}
+	switch left := c.grant.remaining(); {
+	case left == 0:
+		_ = c.Close()
+		return nil, ErrGrantExhausted
+	case left > 0 && int64(len(b)) > left:
+		return b[:left], nil
+	}
+	return b, nil
+}
+
+// take reserves the bytes of b left under the grant of c, closing c once none
+// are.
+func (c *Conn) take(b []byte) ([]byte, error) {
+	if c.grant == nil || len(b) == 0 {
+		return b, nil
+	}
+	n := c.grant.take(len(b))
+	if n == 0 {
+		_ = c.Close()
+		return nil, ErrGrantExhausted
+	}
+	return b[:n], nil
+}
+
+// giveBack returns to the grant of c the n bytes taken but not transferred.
+func (c *Conn) giveBack(n int) {
+	if c.grant != nil {
+		c.grant.giveBack(n)
+	}
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.reader.setDeadline(t)
+	return c.SecureConn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of c. The underlying conn is always
+// read without one, see deadlineReader.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.reader.setDeadline(t)
+	return nil
+}
+
+// Close closes c and calls the OnClose callbacks. Later calls return the error
+// of the first.
+func (c *Conn) Close() error {
+	c.closeMu.Lock()
+	if c.closed {
+		c.closeMu.Unlock()
+		return c.closeErr
+	}
+	c.closed = true
+	c.closeErr = c.SecureConn.Close()
+	callbacks := c.onClose
+	c.onClose = nil
+	c.closeMu.Unlock()
+
+	stats := c.Stats()
+	for _, f := range callbacks {
+		f(stats)
+	}
+	return c.closeErr
+}
+
+// OnClose registers f to be called with the final stats of c once it is
+// closed, or right away if it already is.
+func (c *Conn) OnClose(f func(ConnStats)) {
+	c.closeMu.Lock()
+	if !c.closed {
+		c.onClose = append(c.onClose, f)
+		c.closeMu.Unlock()
+		return
+	}
+	c.closeMu.Unlock()
+	f(c.Stats())
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return PeerAddr{ID: c.LocalPeer()}
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return PeerAddr{ID: c.RemotePeer()}
+}
+
+// Info returns the stream the conn was dialed for.
+func (c *Conn) Info() *StreamInfo {
+	return c.info
+}
+
+// Meta describes the conn and what was negotiated for it.
+func (c *Conn) Meta() ConnMeta {
+	return ConnMeta{
+		RemotePeer:    c.info.RemotePeerID,
+		Path:          c.Path(),
+		RelayEndpoint: c.info.RelayEndpoint,
+		StreamID:      c.info.StreamID,
+		Destination:   c.info.Destination,
+		Features:      c.features,
+		BindSession:   c.info.BindSession,
+		Compression:   c.info.Compression,
+		Guest:         c.grant != nil,
+
+		ObservedAddress: c.info.ObservedAddress,
+	}
+}
+
+// Path returns PathRelay, PathDirect or PathBond.
+func (c *Conn) Path() string {
+	if _, ok := c.SecureConn.(*bondConn); ok {
+		return PathBond
+	}
+	if c.info.Direct {
+		return PathDirect
+	}
+	return PathRelay
+}
+
+// Stats returns the current counters of the conn.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		StreamID:            c.info.StreamID,
+		BytesRead:           c.bytesRead.Load(),
+		BytesWritten:        c.bytesWritten.Load(),
+		Opened:              c.opened,
+		LastActivity:        time.Unix(0, c.lastActivity.Load()),
+		Path:                c.Path(),
+		RelayEndpoint:       c.info.RelayEndpoint,
+		TransportLocalAddr:  c.SecureConn.LocalAddr(),
+		TransportRemoteAddr: c.SecureConn.RemoteAddr(),
+	}
+}
+
+// streamConn adapts a libp2p stream to sec.SecureConn. The libp2p connection
+// already secures it end-to-end.
+type streamConn struct {
+	network.Stream
+}
+
+func (s streamConn) LocalAddr() net.Addr {
+	return netAddr(s.Conn().LocalMultiaddr(), s.Conn().LocalPeer())
+}
+
+func (s streamConn) RemoteAddr() net.Addr {
+	return netAddr(s.Conn().RemoteMultiaddr(), s.Conn().RemotePeer())
+}
+
+func (s streamConn) LocalPeer() peer.ID {
+	return s.Conn().LocalPeer()
+}
+
+func (s streamConn) RemotePeer() peer.ID {
+	return s.Conn().RemotePeer()
+}
+
+func (s streamConn) RemotePublicKey() crypto.PubKey {
+	return s.Conn().RemotePublicKey()
+}
+
+func (s streamConn) ConnState() network.ConnectionState {
+	return s.Conn().ConnState()
+}
+
+// netAddr converts a to a net.Addr, falling back to the peer ID for addresses
+// without one, e.g. over a transport net does not know.
+func netAddr(a ma.Multiaddr, id peer.ID) net.Addr {
+	if addr, err := manet.ToNetAddr(a); err == nil {
+		return addr
+	}
+	return PeerAddr{ID: id}
+}
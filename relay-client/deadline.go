@@ -0,0 +1,121 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadlineReader reads from r honouring a read deadline without losing data.
+// The Noise session of a relayed stream cannot resume a read its own deadline
+// interrupted mid-message, so r is read without one: a read of r outliving the
+// deadline is left pending, and its data is returned by the next read.
+type deadlineReader struct {
+	r io.Reader
+
+	mu      sync.Mutex // serializes reads
+	pending chan readResult
+	data    []byte // data of the last read of r not returned yet
+	err     error  // error of the last read of r, returned once data is
+	spare   []byte // buffer for the next read of r
+
+	deadlineMu sync.Mutex
+	deadline   time.Time
+	changed    chan struct{} // closed when deadline changes
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+func (d *deadlineReader) setDeadline(t time.Time) {
+	d.deadlineMu.Lock()
+	defer d.deadlineMu.Unlock()
+	d.deadline = t
+	if d.changed != nil {
+		close(d.changed)
+	}
+	d.changed = make(chan struct{})
+}
+
+func (d *deadlineReader) currentDeadline() (time.Time, <-chan struct{}) {
+	d.deadlineMu.Lock()
+	defer d.deadlineMu.Unlock()
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.deadline, d.changed
+}
+
+func (d *deadlineReader) read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.data) > 0 {
+		n := copy(b, d.data)
+		d.data = d.data[n:]
+		return n, nil
+	}
+	if d.err != nil {
+		err := d.err
+		d.err = nil
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	deadline, changed := d.currentDeadline()
+	if d.pending == nil {
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		buf := d.spare
+		if cap(buf) < len(b) {
+			buf = make([]byte, len(b))
+		}
+		buf = buf[:len(b)]
+		d.spare = nil
+		pending := make(chan readResult, 1)
+		d.pending = pending
+		go func() {
+			n, err := d.r.Read(buf)
+			pending <- readResult{data: buf[:n], err: err}
+		}()
+	}
+	for {
+		var (
+			timer   *time.Timer
+			expired <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			timer = time.NewTimer(time.Until(deadline))
+			expired = timer.C
+		}
+		select {
+		case res := <-d.pending:
+			if timer != nil {
+				timer.Stop()
+			}
+			d.pending = nil
+			n := copy(b, res.data)
+			if n < len(res.data) {
+				d.data, d.err = res.data[n:], res.err
+				return n, nil
+			}
+			d.spare = res.data[:0]
+			return n, res.err
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+			deadline, changed = d.currentDeadline()
+		}
+	}
+}
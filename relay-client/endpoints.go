@@ -0,0 +1,182 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/wsconn"
+)
+
+// tlsScheme prefixes the relay endpoints served over TLS.
+const tlsScheme = "tls://"
+
+// endpointPenalty is how long an endpoint that failed to connect is tried
+// after the others.
+const endpointPenalty = 5 * time.Minute
+
+// failedEndpoints maps the relay endpoints that recently failed to connect to
+// the time they did.
+var failedEndpoints sync.Map
+
+// relayEndpoints returns the endpoints of info in the order to try them:
+// RelayEndpoint then the others, those that failed recently last.
+func relayEndpoints(info *StreamInfo) []string {
+	eps := []string{info.RelayEndpoint}
+	for _, ep := range info.RelayEndpoints {
+		if ep != "" && !slices.Contains(eps, ep) {
+			eps = append(eps, ep)
+		}
+	}
+	now := time.Now()
+	slices.SortStableFunc(eps, func(a, b string) int {
+		return boolCmp(endpointFailed(a, now), endpointFailed(b, now))
+	})
+	return eps
+}
+
+func endpointFailed(ep string, now time.Time) bool {
+	v, ok := failedEndpoints.Load(ep)
+	if !ok {
+		return false
+	}
+	if now.Sub(v.(time.Time)) >= endpointPenalty {
+		failedEndpoints.CompareAndDelete(ep, v)
+		return false
+	}
+	return true
+}
+
+func boolCmp(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// endpointStagger is how long the dial of an endpoint runs alone before the
+// next endpoint is dialed too, as in Happy Eyeballs (RFC 8305).
+const endpointStagger = 250 * time.Millisecond
+
+// dialRelay connects to the relay-server of info over the first of its
+// endpoints to connect. ws:// and wss:// endpoints are dialed as WebSocket,
+// tls:// ones as TLS over TCP.
+func dialRelay(ctx context.Context, info *StreamInfo) (net.Conn, error) {
+	return dialStaggered(ctx, relayEndpoints(info), func(ctx context.Context, ep string) (net.Conn, error) {
+		return dialEndpoint(ctx, ep, info)
+	})
+}
+
+// dialStaggered dials eps in order, each endpointStagger after the previous
+// one or as soon as it failed, and returns the first connection set up. The
+// dials still running then are canceled, and the connections they set up all
+// the same are closed. Endpoints that fail are remembered in failedEndpoints,
+// those that connect forgotten there.
+func dialStaggered(ctx context.Context, eps []string, dial func(ctx context.Context, ep string) (net.Conn, error)) (net.Conn, error) {
+	type result struct {
+		ep   string
+		conn net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(eps))
+	next, running := 0, 0
+	defer func() {
+		go func(n int) {
+			for range n {
+				if r := <-results; r.conn != nil {
+					_ = r.conn.Close()
+				}
+			}
+		}(running)
+	}()
+	stagger := time.NewTimer(endpointStagger)
+	defer stagger.Stop()
+	start := func() {
+		ep := eps[next]
+		next++
+		running++
+		go func() {
+			connectCtx, cancel := context.WithTimeout(ctx, relayConnectTimeout)
+			defer cancel()
+			conn, err := dial(connectCtx, ep)
+			results <- result{ep: ep, conn: conn, err: err}
+		}()
+		stagger.Reset(endpointStagger)
+	}
+
+	var errs []error
+	start()
+	for running > 0 {
+		select {
+		case <-stagger.C:
+			if next < len(eps) {
+				start()
+			}
+		case r := <-results:
+			running--
+			if r.err == nil {
+				failedEndpoints.Delete(r.ep)
+				return r.conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, r.err
+			}
+			failedEndpoints.Store(r.ep, time.Now())
+			errs = append(errs, fmt.Errorf("%s: %w", r.ep, r.err))
+			if next < len(eps) {
+				start()
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func dialEndpoint(ctx context.Context, ep string, info *StreamInfo) (net.Conn, error) {
+	network := "tcp"
+	if info.IPv6Only {
+		network = "tcp6"
+	}
+	if wsconn.IsURL(ep) {
+		return wsconn.Dial(ctx, network, ep, info.TLSConfig)
+	}
+	address, secure := strings.CutPrefix(ep, tlsScheme)
+	conn, err := dialTCP(ctx, network, address)
+	if err != nil || !secure {
+		return conn, err
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	var cfg *tls.Config
+	if info.TLSConfig != nil {
+		cfg = info.TLSConfig.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
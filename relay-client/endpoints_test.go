@@ -0,0 +1,132 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeDial answers the dials of an endpoint after delay, with a connection
+// unless it fails, or never if it hangs.
+type fakeDial struct {
+	delay time.Duration
+	fail  bool
+	hang  bool
+	// ignoreCancel sets up the connection even once its dial is canceled.
+	ignoreCancel bool
+}
+
+// closeConn is a net.Conn reporting its Close.
+type closeConn struct {
+	net.Conn
+	ep     string
+	closed chan struct{}
+}
+
+func (c *closeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestDialStaggered(t *testing.T) {
+	tests := []struct {
+		name       string
+		dials      []fakeDial
+		want       int // index of the endpoint connected over, -1 for none
+		maxElapsed time.Duration
+		minElapsed time.Duration
+		failed     []bool
+	}{
+		{name: "first connects", dials: []fakeDial{{}, {}}, want: 0, maxElapsed: endpointStagger / 2, failed: []bool{false, false}},
+		{
+			name: "first hangs", dials: []fakeDial{{hang: true}, {}}, want: 1,
+			minElapsed: endpointStagger, maxElapsed: 2 * endpointStagger, failed: []bool{false, false},
+		},
+		{
+			name: "first fails at once", dials: []fakeDial{{fail: true}, {}}, want: 1,
+			maxElapsed: endpointStagger / 2, failed: []bool{true, false},
+		},
+		{
+			name: "slow first wins", dials: []fakeDial{{delay: endpointStagger * 3 / 2}, {hang: true}}, want: 0,
+			minElapsed: endpointStagger, failed: []bool{false, false},
+		},
+		{
+			name: "late connection closed", dials: []fakeDial{{delay: 3 * endpointStagger, ignoreCancel: true}, {}}, want: 1,
+			failed: []bool{false, false},
+		},
+		{name: "all fail", dials: []fakeDial{{fail: true}, {fail: true}, {fail: true}}, want: -1, failed: []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eps := make([]string, len(tt.dials))
+			dials := make(map[string]fakeDial)
+			conns := make(map[string]*closeConn)
+			for i, d := range tt.dials {
+				eps[i] = fmt.Sprintf("%s/%d", tt.name, i)
+				dials[eps[i]] = d
+				conns[eps[i]] = &closeConn{ep: eps[i], closed: make(chan struct{})}
+				failedEndpoints.Delete(eps[i])
+			}
+			dial := func(ctx context.Context, ep string) (net.Conn, error) {
+				d := dials[ep]
+				switch {
+				case d.hang:
+					<-ctx.Done()
+					return nil, ctx.Err()
+				case d.ignoreCancel:
+					time.Sleep(d.delay)
+				default:
+					select {
+					case <-time.After(d.delay):
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					}
+				}
+				if d.fail {
+					return nil, errors.New("refused")
+				}
+				return conns[ep], nil
+			}
+
+			started := time.Now()
+			conn, err := dialStaggered(context.Background(), eps, dial)
+			elapsed := time.Since(started)
+			if tt.want < 0 {
+				if err == nil {
+					t.Fatalf("dialStaggered() connected to %s", conn.(*closeConn).ep)
+				}
+			} else if err != nil || conn != conns[eps[tt.want]] {
+				t.Fatalf("dialStaggered() = %v, %v, want endpoint %d", conn, err, tt.want)
+			}
+			if tt.maxElapsed > 0 && elapsed > tt.maxElapsed {
+				t.Fatalf("took %s, want at most %s", elapsed, tt.maxElapsed)
+			}
+			if elapsed < tt.minElapsed {
+				t.Fatalf("took %s, want at least %s", elapsed, tt.minElapsed)
+			}
+			now := time.Now()
+			for i, ep := range eps {
+				if got := endpointFailed(ep, now); got != tt.failed[i] {
+					t.Errorf("endpoint %d failed = %v, want %v", i, got, tt.failed[i])
+				}
+				failedEndpoints.Delete(ep)
+			}
+			for i, d := range tt.dials {
+				if !d.ignoreCancel || i == tt.want {
+					continue
+				}
+				select {
+				case <-conns[eps[i]].closed:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("late connection of endpoint %d not closed", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrGrantExhausted is returned when a client transferred the volume of its
+// guest grant.
+var ErrGrantExhausted = fmt.Errorf("guest grant exhausted: %w", ErrQuotaExceeded)
+
+// Grant gives a peer guest access to a server for a limited time and volume,
+// for ad-hoc sharing with peers the server does not otherwise accept.
+type Grant struct {
+	Peer peer.ID
+	// Service is the Destination.Service the grant covers. Empty covers every
+	// destination.
+	Service string
+	// Expires ends the grant and the sessions it admitted.
+	Expires time.Time
+	// MaxBytes caps the bytes the peer transfers over all the sessions the grant
+	// admitted, both directions counted. 0 means unlimited.
+	MaxBytes int64
+}
+
+// GrantStatus is a Grant with the bytes transferred under it so far.
+type GrantStatus struct {
+	Grant
+	Used int64
+}
+
+type grantKey struct {
+	peer    peer.ID
+	service string
+}
+
+type grant struct {
+	Grant
+	used atomic.Int64
+	// ctx is done when the grant expires or is revoked.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// remaining returns the bytes left to transfer, or -1 if unlimited.
+func (g *grant) remaining() int64 {
+	if g.MaxBytes <= 0 {
+		return -1
+	}
+	return max(g.MaxBytes-g.used.Load(), 0)
+}
+
+// take counts up to n bytes against g and returns how many it may transfer.
+func (g *grant) take(n int) int {
+	if g.MaxBytes <= 0 {
+		g.used.Add(int64(n))
+		return n
+	}
+	for {
+		used := g.used.Load()
+		k := min(int64(n), g.MaxBytes-used)
+		if k <= 0 {
+			return 0
+		}
+		if g.used.CompareAndSwap(used, used+k) {
+			return int(k)
+		}
+	}
+}
+
+// giveBack returns n bytes taken but not transferred.
+func (g *grant) giveBack(n int) {
+	if n > 0 {
+		g.used.Add(-int64(n))
+	}
+}
+
+// Grants is a set of guest grants, at most one per peer and service. Its zero
+// value is ready to use.
+type Grants struct {
+	mu     sync.Mutex
+	grants map[grantKey]*grant
+}
+
+// Add issues g, replacing the grant of the same peer and service.
+func (gs *Grants) Add(g Grant) {
+	ctx, cancel := context.WithDeadline(context.Background(), g.Expires)
+	entry := &grant{Grant: g, ctx: ctx, cancel: cancel}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.grants == nil {
+		gs.grants = make(map[grantKey]*grant)
+	}
+	key := grantKey{g.Peer, g.Service}
+	if old, ok := gs.grants[key]; ok {
+		old.cancel()
+	}
+	gs.grants[key] = entry
+}
+
+// Revoke withdraws the grant of p for service and ends the sessions it
+// admitted. It reports whether the grant was found.
+func (gs *Grants) Revoke(p peer.ID, service string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	key := grantKey{p, service}
+	g, ok := gs.grants[key]
+	if ok {
+		g.cancel()
+		delete(gs.grants, key)
+	}
+	return ok
+}
+
+// List returns the grants in effect, soonest to expire first.
+func (gs *Grants) List() []GrantStatus {
+	gs.mu.Lock()
+	gs.pruneLocked()
+	out := make([]GrantStatus, 0, len(gs.grants))
+	for _, g := range gs.grants {
+		out = append(out, GrantStatus{Grant: g.Grant, Used: g.used.Load()})
+	}
+	gs.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Expires.Before(out[j].Expires)
+	})
+	return out
+}
+
+// lookup returns the grant admitting p to dst: one for dst.Service, else one
+// for every destination. It returns ErrGrantExhausted if the grants of p that
+// cover dst are used up, and nil if there are none.
+func (gs *Grants) lookup(p peer.ID, dst Destination) (*grant, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.pruneLocked()
+	keys := []grantKey{{p, ""}}
+	if dst.Service != "" {
+		keys = []grantKey{{p, dst.Service}, {p, ""}}
+	}
+	var err error
+	for _, key := range keys {
+		g, ok := gs.grants[key]
+		if !ok {
+			continue
+		}
+		if g.remaining() == 0 {
+			err = ErrGrantExhausted
+			continue
+		}
+		return g, nil
+	}
+	return nil, err
+}
+
+// pruneLocked drops expired grants. Caller must hold gs.mu.
+func (gs *Grants) pruneLocked() {
+	for key, g := range gs.grants {
+		if g.ctx.Err() != nil {
+			g.cancel()
+			delete(gs.grants, key)
+		}
+	}
+}
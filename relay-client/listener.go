@@ -0,0 +1,141 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ConnMeta describes a Conn for authorization and routing decisions, e.g. at
+// accept time.
+type ConnMeta struct {
+	RemotePeer peer.ID
+	// Path is PathRelay or PathDirect.
+	Path string
+	// RelayEndpoint is the relay-server carrying the stream, empty on the
+	// direct path.
+	RelayEndpoint string
+	StreamID      uint64
+	// Destination is what the client asked the stream to be bridged to.
+	Destination Destination
+	// Features are the capabilities the remote peer announced in its Hello,
+	// empty for peers predating it.
+	Features []string
+	// BindSession and Compression are the options negotiated for the stream.
+	BindSession bool
+	Compression string
+	// Guest is set for streams admitted under a guest grant.
+	Guest bool
+	// ObservedAddress is this peer's NAT mapping as the relay-server saw it,
+	// see StreamInfo.ObservedAddress.
+	ObservedAddress string
+}
+
+// Listener hands out the streams a ServerRole accepts, as a net.Listener.
+type Listener struct {
+	local peer.ID
+	conns chan *Conn
+	done  chan struct{}
+
+	mu     sync.Mutex // orders queueing against Close
+	closed bool
+}
+
+// Listen makes r pass its streams to the returned Listener instead of to
+// Handler. Up to backlog streams wait to be accepted, further ones are closed.
+// local is the peer ID the Listener reports as its address.
+func (r *ServerRole) Listen(local peer.ID, backlog int) *Listener {
+	l := &Listener{
+		local: local,
+		conns: make(chan *Conn, max(backlog, 1)),
+		done:  make(chan struct{}),
+	}
+	r.Handler = func(streamInfo *StreamInfo, conn net.Conn) {
+		l.handle(r, conn.(*Conn))
+	}
+	return l
+}
+
+// handle queues c and returns once it is closed: the session of c ends with
+// the Handler call.
+func (l *Listener) handle(r *ServerRole, c *Conn) {
+	closed := make(chan struct{})
+	c.OnClose(func(ConnStats) {
+		close(closed)
+	})
+	if !l.queue(c) {
+		r.logger().Warn("stream not accepted, closing",
+			logging.KeyClientPeer, c.RemotePeer().String(),
+			logging.KeyStreamID, c.info.StreamID)
+		_ = c.Close()
+		return
+	}
+	<-closed
+}
+
+// queue queues c for Accept, unless l is closed or its backlog full.
+func (l *Listener) queue(c *Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	select {
+	case l.conns <- c:
+		return true
+	default:
+		return false
+	}
+}
+
+// AcceptContext waits for the next stream until ctx is done. The Meta of the
+// conn tells where it comes from.
+func (l *Listener) AcceptContext(ctx context.Context) (*Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for the next stream.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.AcceptContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close stops accepting streams and closes those waiting to be accepted.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	for {
+		select {
+		case c := <-l.conns:
+			_ = c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Addr returns the peer ID of the server.
+func (l *Listener) Addr() net.Addr {
+	return PeerAddr{ID: l.local}
+}
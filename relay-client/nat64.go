@@ -0,0 +1,137 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// nat64DiscoveryName is resolved to discover the NAT64 prefix, see RFC 7050.
+const nat64DiscoveryName = "ipv4only.arpa"
+
+// nat64PrefixTTL is how long a discovered NAT64 prefix, or its absence, is
+// remembered.
+const nat64PrefixTTL = 10 * time.Minute
+
+// Well-known IPv4 addresses of ipv4only.arpa.
+var nat64WellKnown = []netip.Addr{
+	netip.AddrFrom4([4]byte{192, 0, 0, 170}),
+	netip.AddrFrom4([4]byte{192, 0, 0, 171}),
+}
+
+// Prefix lengths of RFC 6052, longest first.
+var nat64PrefixLengths = []int{96, 64, 56, 48, 40, 32}
+
+var nat64 struct {
+	sync.Mutex
+	prefix netip.Prefix
+	err    error
+	at     time.Time
+}
+
+// dialTCP dials address, through the NAT64 of the network when address is an
+// IPv4 literal that cannot be reached directly, as on IPv6-only mobile
+// carriers.
+func dialTCP(ctx context.Context, network string, address string) (net.Conn, error) {
+	ap, perr := netip.ParseAddrPort(address)
+	if perr != nil || !ap.Addr().Unmap().Is4() {
+		return dialer.DialContext(ctx, network, address)
+	}
+	var err error
+	if network != "tcp6" {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, address)
+		if err == nil || !(errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH)) {
+			return conn, err
+		}
+	}
+	prefix, perr := nat64Prefix(ctx)
+	if perr != nil {
+		if err == nil {
+			err = fmt.Errorf("IPv4 relay endpoint %s unreachable over IPv6: %w", address, perr)
+		}
+		return nil, err
+	}
+	synth := netip.AddrPortFrom(synthesizeNAT64(prefix, ap.Addr().Unmap()), ap.Port())
+	return dialer.DialContext(ctx, "tcp6", synth.String())
+}
+
+// nat64Prefix returns the NAT64 prefix of the network, discovered through
+// DNS64 as in RFC 7050.
+func nat64Prefix(ctx context.Context) (netip.Prefix, error) {
+	nat64.Lock()
+	defer nat64.Unlock()
+	if !nat64.at.IsZero() && time.Since(nat64.at) < nat64PrefixTTL {
+		return nat64.prefix, nat64.err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip6", nat64DiscoveryName)
+	if ctx.Err() != nil {
+		// Not an answer about the network: do not remember it.
+		return netip.Prefix{}, ctx.Err()
+	}
+	nat64.prefix, nat64.err, nat64.at = netip.Prefix{}, nil, time.Now()
+	if err != nil {
+		nat64.err = fmt.Errorf("no DNS64: %w", err)
+		return nat64.prefix, nat64.err
+	}
+	for _, a := range addrs {
+		if prefix, ok := nat64PrefixOf(a); ok {
+			nat64.prefix = prefix
+			return prefix, nil
+		}
+	}
+	nat64.err = fmt.Errorf("no NAT64 prefix in the addresses of %s", nat64DiscoveryName)
+	return nat64.prefix, nat64.err
+}
+
+// nat64PrefixOf returns the prefix a was synthesized with from a well-known
+// address of ipv4only.arpa.
+func nat64PrefixOf(a netip.Addr) (netip.Prefix, bool) {
+	if !a.Is6() || a.Is4In6() {
+		return netip.Prefix{}, false
+	}
+	b := a.As16()
+	for _, bits := range nat64PrefixLengths {
+		var v4 [4]byte
+		for i, pos := range nat64Positions(bits) {
+			v4[i] = b[pos]
+		}
+		for _, wk := range nat64WellKnown {
+			if netip.AddrFrom4(v4) == wk {
+				prefix, err := a.Prefix(bits)
+				return prefix, err == nil
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// synthesizeNAT64 embeds v4 in prefix as in RFC 6052.
+func synthesizeNAT64(prefix netip.Prefix, v4 netip.Addr) netip.Addr {
+	b := prefix.Masked().Addr().As16()
+	v := v4.As4()
+	for i, pos := range nat64Positions(prefix.Bits()) {
+		b[pos] = v[i]
+	}
+	return netip.AddrFrom16(b)
+}
+
+// nat64Positions returns the bytes of an IPv6 address holding the IPv4
+// address embedded after a prefix of bits, skipping the reserved bits 64-71.
+func nat64Positions(bits int) []int {
+	pos := make([]int, 0, 4)
+	for i := bits / 8; len(pos) < 4; i++ {
+		if i != 8 {
+			pos = append(pos, i)
+		}
+	}
+	return pos
+}
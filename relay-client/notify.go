@@ -0,0 +1,198 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/flymesh/core/pkg/logging"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/protocol"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StreamExpiry is an allocation of the server that a relay-server dropped
+// because both peers did not connect to it within its TTL.
+type StreamExpiry struct {
+	// RelayPeer is the relay-server that reported it.
+	RelayPeer  peer.ID
+	StreamID   uint64
+	ClientPeer peer.ID
+	// ServerConnected and ClientConnected tell which peers had connected to
+	// the allocation.
+	ServerConnected bool
+	ClientConnected bool
+}
+
+// HandleNotify reads a notification a relay-server sent about an allocation of
+// r, and passes a StreamExpired one to r.OnStreamExpired.
+func (r *ServerRole) HandleNotify(h host.Host, s network.Stream) {
+	defer s.Close()
+	relayPeer := s.Conn().RemotePeer()
+	logger := r.logger().With(logging.KeyPeer, relayPeer.String())
+
+	typ, data, err := relay_protocol.ReadControlRequest(h, s, r.hello(), time.Second*10)
+	if err != nil {
+		logger.Warn("read notification failed", "err", err)
+		return
+	}
+	if typ != relay_protocol.ControlTypeStreamExpired {
+		logger.Warn("unexpected control frame type", "type", typ)
+		return
+	}
+	var msg controlpb.StreamExpired
+	if err := msg.UnmarshalVT(data); err != nil {
+		logger.Warn("bad StreamExpired", "err", err)
+		return
+	}
+	clientPeer, err := peer.IDFromBytes(msg.GetClientPeerId())
+	if err != nil {
+		logger.Warn("bad StreamExpired", "err", err)
+		return
+	}
+	e := StreamExpiry{
+		RelayPeer:       relayPeer,
+		StreamID:        msg.GetStreamId(),
+		ClientPeer:      clientPeer,
+		ServerConnected: msg.GetServerConnected(),
+		ClientConnected: msg.GetClientConnected(),
+	}
+	logger.Warn("relay allocation expired unused",
+		logging.KeyStreamID, e.StreamID,
+		logging.KeyClientPeer, clientPeer.String(),
+		"server_connected", e.ServerConnected,
+		"client_connected", e.ClientConnected)
+	if r.OnStreamExpired != nil {
+		r.OnStreamExpired(e)
+	}
+}
+
+// notifyTimeout is how long ServeNotify waits for a frame of the relay-server,
+// twice the interval of its keepalives.
+const notifyTimeout = time.Minute
+
+// ServeNotify registers r with relayPeerId for the stream requests clients send
+// through it, see ClientRole.NotifyRelay, and serves them until ctx ends or the
+// registration fails. The clients need no libp2p connection to r. Notified
+// requests are admitted like start-relay requests, then dialed to the
+// allocation the relay-server made for them. Their sessions end with ctx.
+func (r *ServerRole) ServeNotify(ctx context.Context, h host.Host, relayPeerId peer.ID) error {
+	if hello := relay_protocol.PeerHello(h, relayPeerId); hello != nil && !relay_protocol.HasFeature(hello, relay_protocol.FeatureRelayNotify) {
+		return fmt.Errorf("relay-server %s does not notify stream requests", relayPeerId)
+	}
+	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), relayPeerId, protocol.ProtoRelayNotify)
+	if err != nil {
+		return fmt.Errorf("open relay-server notify: %w", err)
+	}
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = s.Reset()
+	})
+	defer stop()
+	logger := r.logger().With(logging.KeyPeer, relayPeerId.String())
+
+	if err := relay_protocol.WriteControlRequest(h, s, r.hello(), relay_protocol.ControlTypeNotifyRegister, nil); err != nil {
+		return fmt.Errorf("write registration: %w", err)
+	}
+	typ, _, err := relay_protocol.ReadControlResponse(h, s, 10*time.Second)
+	if err == nil && typ != relay_protocol.ControlTypeNotifyRegister {
+		err = fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	if err != nil {
+		return fmt.Errorf("registration refused: %w", err)
+	}
+	logger.Info("registered for notifications")
+
+	for {
+		typ, data, err := relay_protocol.ReadControlFrame(s, notifyTimeout)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("read notification: %w", err)
+		}
+		switch typ {
+		case relay_protocol.ControlTypeNotifyRegister:
+			// Keepalive
+		case relay_protocol.ControlTypeStreamOffer:
+			err := r.acceptNotified(ctx, h, data, logger)
+			if err != nil {
+				logger.Warn("notified stream request refused", "err", err)
+			}
+			if err := writeOfferResponse(h, s, err); err != nil {
+				return fmt.Errorf("write StreamOfferResponse: %w", err)
+			}
+		default:
+			return fmt.Errorf("unexpected type 0x%04x", typ)
+		}
+	}
+}
+
+// acceptNotified admits the stream request of a StreamOffer and starts serving
+// its allocation.
+func (r *ServerRole) acceptNotified(ctx context.Context, h host.Host, data []byte, logger *slog.Logger) error {
+	var msg controlpb.StreamOffer
+	if err := msg.UnmarshalVT(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	clientPeerID, err := peer.IDFromBytes(msg.GetPeerId())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+	req := msg.GetRequest()
+	if req == nil {
+		req = &controlpb.StartRelayStreamRequest{}
+	}
+	logger = logger.With(logging.KeyClientPeer, clientPeerID.String(), logging.KeyStreamID, msg.GetStreamId())
+
+	g, err := r.admit(clientPeerID, req, logger)
+	if err != nil {
+		return err
+	}
+	dst := destinationOf(req)
+	switch {
+	case req.GetAlpn() == TransportALPN:
+		err = fmt.Errorf("%w: libp2p transport", ErrUnknownTarget)
+	case r.RequireSessionBinding && !req.GetBindSession():
+		err = ErrSessionNotBound
+	default:
+		err = errors.Join(r.checkDestination(dst), checkBond(req))
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.sessions.reserve(clientPeerID, r.Settings().MaxSessionsPerClient); err != nil {
+		return err
+	}
+	streamInfo := &StreamInfo{
+		RelayEndpoint: msg.GetRelayEndpoint(),
+		StreamID:      msg.GetStreamId(),
+		Token:         msg.GetToken(),
+		IsServer:      true,
+		LocalPeerID:   h.ID(),
+		RemotePeerID:  clientPeerID,
+		Destination:   dst,
+		BindSession:   req.GetBindSession(),
+		Obfuscator:    r.Obfuscator,
+		TLSConfig:     r.RelayTLSConfig,
+		RelayMACs:     r.RelayMACs,
+		IPv6Only:      r.IPv6Only,
+		Bond:          req.GetBond(),
+
+		RelayEndpoints: msg.GetRelayEndpoints(),
+	}
+	if ms := msg.GetExpiresUnixMs(); ms > 0 {
+		streamInfo.Expires = time.UnixMilli(ms)
+	}
+	logger.Info("notified stream request accepted", "destination", dst.String())
+	r.serveRelayed(ctx, h, streamInfo, g, logger)
+	return nil
+}
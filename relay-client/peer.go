@@ -0,0 +1,250 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/flymesh/core/pkg/dialback"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/obfs"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/protocol"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PeerRole bridges two peers through a relay-server without either of them
+// serving the start-relay protocol, as two clients behind NATs would: the peer
+// that dials asks the relay-server for an allocation naming the other one, and
+// the relay-server offers it to that peer, which joins it from HandleOffer.
+// Both peers must keep a connection to the relay-server.
+type PeerRole struct {
+	PrivKey crypto.PrivKey
+	// Accept is consulted for every allocation a relay-server offers on behalf
+	// of another peer. Returning an error declines the offer; the dialing peer
+	// sees ErrUnauthorized. Nil declines every offer.
+	Accept func(relayPeer peer.ID, fromPeer peer.ID) error
+	// Handler is called with every offered stream set up, conn being a *Conn,
+	// and the session lasts until it returns. Required if Accept is set.
+	Handler func(streamInfo *StreamInfo, conn net.Conn)
+	// Obfuscator, if set, disguises the connections to relay-servers, which
+	// must be configured with the same one.
+	Obfuscator obfs.Obfuscator
+	// RelayTLSConfig, if set, verifies the tls:// and wss:// endpoints of
+	// relay-servers instead of the system roots.
+	RelayTLSConfig *tls.Config
+	// RelayMACs lists the MAC algorithms relay-servers may authenticate
+	// their relay frames with, most preferred first, see
+	// relay_protocol.ParseMACs. Empty announces the defaults.
+	RelayMACs []relay_protocol.MAC
+	// IPv6Only dials relay-servers over IPv6 only.
+	IPv6Only bool
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+func (r *PeerRole) logger() *slog.Logger {
+	return logging.Component(r.Logger, "peer")
+}
+
+// Dial asks relayPeerId for an allocation offered to remotePeerId and dials it
+// once remotePeerId accepted the offer.
+func (r *PeerRole) Dial(ctx context.Context, h host.Host, relayPeerId peer.ID, remotePeerId peer.ID) (*Conn, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanCreateStream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrPeer.String(relayPeerId.String())))
+	info, err := r.requestOffer(ctx, h, relayPeerId, remotePeerId)
+	if info != nil {
+		span.SetAttributes(tracing.AttrStreamID.Int64(int64(info.StreamID)))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return DialRelayStream(ctx, r.PrivKey, info)
+}
+
+func (r *PeerRole) requestOffer(ctx context.Context, h host.Host, relayPeerId peer.ID, remotePeerId peer.ID) (*StreamInfo, error) {
+	if hello := relay_protocol.PeerHello(h, relayPeerId); hello != nil && !relay_protocol.HasFeature(hello, relay_protocol.FeatureStreamOffer) {
+		return nil, fmt.Errorf("relay-server %s does not offer streams", relayPeerId)
+	}
+	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), relayPeerId, protocol.ProtoRelayCreate)
+	if err != nil {
+		return nil, fmt.Errorf("open relay-server create-stream: %w", err)
+	}
+	defer stream.Close()
+
+	req := controlpb.CreateStreamRequest{
+		TraceContext: tracing.Inject(ctx),
+		Offer:        true,
+	}
+	req.ClientPeerId, err = remotePeerId.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal remote peer id: %w", err)
+	}
+	payload, err := req.MarshalVT()
+	if err != nil {
+		return nil, fmt.Errorf("marshal CreateStreamRequest: %w", err)
+	}
+	if err := relay_protocol.WriteControlRequest(h, stream, relay_protocol.NewHello(nil), relay_protocol.ControlTypeCreateStreamRequest, payload); err != nil {
+		return nil, fmt.Errorf("write CreateStreamRequest: %w", err)
+	}
+
+	typ, data, err := relay_protocol.ReadControlResponse(h, stream, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("read CreateStreamResponse: %w", err)
+	}
+	if typ != relay_protocol.ControlTypeCreateStreamResponse {
+		return nil, fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var resp controlpb.CreateStreamResponse
+	if err := resp.UnmarshalVT(data); err != nil {
+		return nil, fmt.Errorf("decode CreateStreamResponse: %w", err)
+	}
+	if !resp.GetOk() {
+		return nil, &RelayError{Code: resp.GetErrorCode(), Message: resp.GetError()}
+	}
+
+	r.logger().Info("relay stream offered",
+		logging.KeyPeer, remotePeerId.String(),
+		logging.KeyStreamID, resp.GetStreamId(),
+		"relay_endpoint", resp.GetRelayEndpoint(),
+		"observed_address", resp.GetObservedAddress())
+
+	return &StreamInfo{
+		RelayEndpoint: resp.GetRelayEndpoint(),
+		StreamID:      resp.GetStreamId(),
+		Token:         resp.GetToken(),
+		IsServer:      true,
+		LocalPeerID:   h.ID(),
+		RemotePeerID:  remotePeerId,
+		RetryCookie:   resp.GetRetryCookie(),
+		Obfuscator:    r.Obfuscator,
+		TLSConfig:     r.RelayTLSConfig,
+		RelayMACs:     r.RelayMACs,
+		IPv6Only:      r.IPv6Only,
+
+		RelayEndpoints:  resp.GetRelayEndpoints(),
+		ObservedAddress: resp.GetObservedAddress(),
+	}, nil
+}
+
+// RegisterProtocol registers the stream-offer handler, and answers dial-back
+// challenges from relay-servers verifying this peer and Hello exchanges.
+func (r *PeerRole) RegisterProtocol(h host.Host) {
+	h.SetStreamHandler(protocol.ProtoPeerOffer, func(stream network.Stream) {
+		r.HandleOffer(h, stream)
+	})
+	dialback.RegisterResponder(h, r.PrivKey, relay_protocol.NewHello(nil), r.Logger)
+	relay_protocol.RegisterInfo(h, relay_protocol.NewHello(nil))
+}
+
+// HandleOffer answers a StreamOffer of a relay-server and, if r.Accept accepts
+// it, dials the allocation and passes it to r.Handler.
+func (r *PeerRole) HandleOffer(h host.Host, s network.Stream) {
+	defer s.Close()
+	relayPeer := s.Conn().RemotePeer()
+	logger := r.logger().With(logging.KeyPeer, relayPeer.String())
+
+	info, err := r.readOffer(h, s)
+	if err != nil {
+		logger.Warn("read StreamOffer failed", "err", err)
+		return
+	}
+	logger = logger.With(logging.KeyStreamID, info.StreamID, "from_peer", info.RemotePeerID.String())
+	err = r.accept(relayPeer, info.RemotePeerID)
+	if werr := writeOfferResponse(h, s, err); werr != nil {
+		logger.Warn("write StreamOfferResponse failed", "err", werr)
+		return
+	}
+	if err != nil {
+		logger.Warn("stream offer declined", "err", err)
+		return
+	}
+	logger.Info("stream offer accepted")
+
+	go func() {
+		conn, err := DialRelayStream(context.Background(), r.PrivKey, info)
+		if err != nil {
+			logger.Warn("dial relay failed", "err", err)
+			return
+		}
+		r.Handler(info, conn)
+	}()
+}
+
+// accept consults r.Accept, making sure a rejection wraps ErrUnauthorized.
+func (r *PeerRole) accept(relayPeer, fromPeer peer.ID) error {
+	if r.Accept == nil || r.Handler == nil {
+		return fmt.Errorf("%w: offers are not accepted", ErrUnauthorized)
+	}
+	err := r.Accept(relayPeer, fromPeer)
+	if err == nil || errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+}
+
+// readOffer reads the StreamOffer on s and returns the allocation it offers.
+func (r *PeerRole) readOffer(h host.Host, s network.Stream) (*StreamInfo, error) {
+	typ, data, err := relay_protocol.ReadControlRequest(h, s, relay_protocol.NewHello(nil), time.Second*10)
+	if err != nil {
+		return nil, err
+	}
+	if typ != relay_protocol.ControlTypeStreamOffer {
+		return nil, fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var msg controlpb.StreamOffer
+	if err := msg.UnmarshalVT(data); err != nil {
+		return nil, err
+	}
+	fromPeer, err := peer.IDFromBytes(msg.GetPeerId())
+	if err != nil {
+		return nil, fmt.Errorf("bad peer id: %w", err)
+	}
+	info := &StreamInfo{
+		RelayEndpoint: msg.GetRelayEndpoint(),
+		StreamID:      msg.GetStreamId(),
+		Token:         msg.GetToken(),
+		IsServer:      false,
+		LocalPeerID:   h.ID(),
+		RemotePeerID:  fromPeer,
+		Obfuscator:    r.Obfuscator,
+		TLSConfig:     r.RelayTLSConfig,
+		RelayMACs:     r.RelayMACs,
+		IPv6Only:      r.IPv6Only,
+
+		RelayEndpoints: msg.GetRelayEndpoints(),
+	}
+	if ms := msg.GetExpiresUnixMs(); ms > 0 {
+		info.Expires = time.UnixMilli(ms)
+	}
+	return info, nil
+}
+
+// writeOfferResponse accepts the offer, or declines it with err.
+func writeOfferResponse(h host.Host, s network.Stream, err error) error {
+	resp := controlpb.StreamOfferResponse{Ok: err == nil}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorCode = errorCode(err)
+	}
+	payload, err := resp.MarshalVT()
+	if err != nil {
+		return err
+	}
+	return relay_protocol.WriteControlResponse(h, s, relay_protocol.ControlTypeStreamOfferResponse, payload)
+}
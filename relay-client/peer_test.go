@@ -0,0 +1,47 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPeerRoleAccept(t *testing.T) {
+	handler := func(*StreamInfo, net.Conn) {}
+	accept := func(relayPeer, fromPeer peer.ID) error {
+		if fromPeer != "friend" {
+			return errors.New("stranger")
+		}
+		return nil
+	}
+	tests := []struct {
+		name   string
+		role   PeerRole
+		from   peer.ID
+		wantOK bool
+	}{
+		{name: "no Accept", role: PeerRole{Handler: handler}, from: "friend"},
+		{name: "no Handler", role: PeerRole{Accept: accept}, from: "friend"},
+		{name: "accepted", role: PeerRole{Accept: accept, Handler: handler}, from: "friend", wantOK: true},
+		{name: "declined", role: PeerRole{Accept: accept, Handler: handler}, from: "stranger"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.role.accept("relay", tt.from)
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("accept() err = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("accept() err = %v, want ErrUnauthorized", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/obfs"
+	controlpb "github.com/flymesh/core/pkg/pb/control"
+	relaypb "github.com/flymesh/core/pkg/pb/relay"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultProbeCount is the number of probes Prober sends to each endpoint
+// when Count is zero.
+const DefaultProbeCount = 3
+
+// Prober measures the round-trip time to relay-servers with Probe frames,
+// which relays answer without allocating anything, so that peers pick the
+// closest of several relays.
+type Prober struct {
+	// Obfuscator, if set, disguises the connections to relay-servers, which
+	// must be configured with the same one.
+	Obfuscator obfs.Obfuscator
+	// TLSConfig, if set, verifies the tls:// and wss:// endpoints of
+	// relay-servers instead of the system roots.
+	TLSConfig *tls.Config
+	// IPv6Only dials relay-servers over IPv6 only.
+	IPv6Only bool
+	// Count is the number of probes sent to each endpoint, the RTT being the
+	// least measured. Defaults to DefaultProbeCount.
+	Count int
+	// Timeout bounds the probing of one endpoint. Defaults to 10s.
+	Timeout time.Duration
+}
+
+// ProbeResult is the outcome of probing one relay endpoint.
+type ProbeResult struct {
+	Endpoint string
+	// RTT is the least round-trip time of the probes, connection setup
+	// excluded.
+	RTT time.Duration
+	// Hello of the relay-server.
+	Hello *controlpb.Hello
+	// Allocations held by the relay-server, and the most it accepts, 0 for
+	// unlimited.
+	Allocations    int
+	MaxAllocations int
+	// ObservedAddress is the address, ip:port, the relay-server saw the
+	// probes come from.
+	ObservedAddress string
+	// Err is set if the endpoint could not be probed; the other fields are
+	// then zero.
+	Err error
+}
+
+// Full reports whether the relay-server accepts no more allocations.
+func (r *ProbeResult) Full() bool {
+	return r.MaxAllocations > 0 && r.Allocations >= r.MaxAllocations
+}
+
+func (p *Prober) count() int {
+	if p.Count <= 0 {
+		return DefaultProbeCount
+	}
+	return p.Count
+}
+
+func (p *Prober) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return relayConnectTimeout
+	}
+	return p.Timeout
+}
+
+// Probe measures the round-trip time to the relay-server at endpoint.
+func (p *Prober) Probe(ctx context.Context, endpoint string) ProbeResult {
+	res := ProbeResult{Endpoint: endpoint}
+	if err := p.probe(ctx, &res); err != nil {
+		res = ProbeResult{Endpoint: endpoint, Err: err}
+	}
+	return res
+}
+
+func (p *Prober) probe(ctx context.Context, res *ProbeResult) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+	info := &StreamInfo{TLSConfig: p.TLSConfig, IPv6Only: p.IPv6Only}
+	conn, err := dialEndpoint(ctx, res.Endpoint, info)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if p.Obfuscator != nil {
+		if conn, err = p.Obfuscator.Client(conn); err != nil {
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	hello := relay_protocol.NewHello(nil)
+	for i := range p.count() {
+		nonce := rand.Uint64()
+		data, _ := proto.Marshal(&relaypb.Probe{Nonce: nonce, Hello: hello})
+		start := time.Now()
+		if err := relay_protocol.WriteRelayFrame(conn, relay_protocol.RelayTypeProbe, relay_protocol.ProbeToken, data); err != nil {
+			return err
+		}
+		reply, err := readProbeReply(ctx, conn, nonce)
+		if err != nil {
+			return err
+		}
+		if rtt := time.Since(start); i == 0 || rtt < res.RTT {
+			res.RTT = rtt
+		}
+		res.Hello = reply.GetHello()
+		res.Allocations = int(reply.GetAllocations())
+		res.MaxAllocations = int(reply.GetMaxAllocations())
+		res.ObservedAddress = reply.GetObservedAddress()
+	}
+	return nil
+}
+
+func readProbeReply(ctx context.Context, conn net.Conn, nonce uint64) (*relaypb.ProbeReply, error) {
+	deadline, _ := ctx.Deadline()
+	hdr, data, sum, err := relay_protocol.ReadRelayFrameRaw(conn, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Type != relay_protocol.RelayTypeProbeReply {
+		return nil, fmt.Errorf("unexpected relay-server frame type: %d", hdr.Type)
+	}
+	if err := hdr.VerifyRelayHMAC(relay_protocol.ProbeToken, data, sum); err != nil {
+		return nil, err
+	}
+	var reply relaypb.ProbeReply
+	if err := proto.Unmarshal(data, &reply); err != nil {
+		return nil, fmt.Errorf("bad probe reply: %w", err)
+	}
+	if reply.GetNonce() != nonce {
+		return nil, fmt.Errorf("probe reply nonce mismatch")
+	}
+	return &reply, nil
+}
+
+// Rank probes endpoints concurrently and returns the results from the closest
+// relay-server to the farthest, full relay-servers after the others and those
+// that could not be probed last.
+func (p *Prober) Rank(ctx context.Context, endpoints []string) []ProbeResult {
+	results := make([]ProbeResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.Probe(ctx, ep)
+		}()
+	}
+	wg.Wait()
+	slices.SortStableFunc(results, func(a, b ProbeResult) int {
+		if c := boolCmp(a.Err != nil, b.Err != nil); c != 0 {
+			return c
+		}
+		if c := boolCmp(a.Full(), b.Full()); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.RTT, b.RTT)
+	})
+	return results
+}
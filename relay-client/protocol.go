@@ -4,18 +4,24 @@
 package relay_client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"time"
 
+	controlpb "github.com/flymesh/core/pkg/pb/control"
 	"github.com/flymesh/core/pkg/pb/relay"
 	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-func sendHandshake(conn net.Conn, streamID uint64, token []byte, peerID peer.ID) error {
+func sendHandshake(ctx context.Context, conn net.Conn, streamID uint64, token []byte, peerID peer.ID, macs []relay_protocol.MAC) error {
 	req := relaypb.HandshakeRequest{
-		StreamId: streamID,
+		StreamId:     streamID,
+		TraceContext: tracing.Inject(ctx),
+		Hello:        relay_protocol.WithMACs(relay_protocol.NewHello(nil), macs),
 	}
 	req.SenderPeerId, _ = peerID.MarshalBinary()
 	payload, err := req.MarshalVT()
@@ -25,23 +31,195 @@ func sendHandshake(conn net.Conn, streamID uint64, token []byte, peerID peer.ID)
 	return relay_protocol.WriteRelayFrame(conn, relay_protocol.RelayTypeHandshakeRequest, token, payload)
 }
 
-func readHandshakeAck(conn net.Conn, token []byte) error {
-	hdr, data, sum, err := relay_protocol.ReadRelayFrameRaw(conn, time.Second*10)
+// readHandshakeAck reads the ack of the relay-server. Its Hello is nil for
+// relays that predate Hello.
+func readHandshakeAck(conn net.Conn, token []byte, timeout time.Duration) (*relaypb.HandshakeAck, error) {
+	hdr, data, sum, err := relay_protocol.ReadRelayFrameRaw(conn, timeout)
 	if err != nil {
-		return fmt.Errorf("read relay-server ack: %w", err)
+		return nil, fmt.Errorf("read relay-server ack: %w", err)
 	}
 	if err := hdr.VerifyRelayHMAC(token, data, sum); err != nil {
-		return fmt.Errorf("ack hmac: %w", err)
+		return nil, fmt.Errorf("ack hmac: %w", err)
 	}
 	if hdr.Type != relay_protocol.RelayTypeHandshakeAck {
-		return fmt.Errorf("unexpected relay-server type: %d", hdr.Type)
+		return nil, fmt.Errorf("unexpected relay-server type: %d", hdr.Type)
 	}
 	var ack relaypb.HandshakeAck
 	if err := ack.UnmarshalVT(data); err != nil {
-		return fmt.Errorf("decode ack: %w", err)
+		return nil, fmt.Errorf("decode ack: %w", err)
 	}
 	if !ack.GetOk() {
-		return fmt.Errorf("relay-server nack: %s", ack.GetError())
+		return nil, &RelayError{Code: ack.GetErrorCode(), Message: ack.GetError()}
+	}
+	return &ack, nil
+}
+
+// readReady reads the Ready frame the relay-server sends once the remote peer
+// connected too.
+func readReady(conn net.Conn, token []byte, timeout time.Duration) error {
+	hdr, data, sum, err := relay_protocol.ReadRelayFrameRaw(conn, timeout)
+	if err != nil {
+		return fmt.Errorf("read relay-server ready: %w", err)
+	}
+	if err := hdr.VerifyRelayHMAC(token, data, sum); err != nil {
+		return fmt.Errorf("ready hmac: %w", err)
+	}
+	if hdr.Type != relay_protocol.RelayTypeReady {
+		return fmt.Errorf("unexpected relay-server type: %d", hdr.Type)
 	}
 	return nil
 }
+
+// Errors matched by the failures peers report, by their error code.
+var (
+	// ErrUnknownTarget is returned when the server does not serve the requested
+	// Destination.
+	ErrUnknownTarget = errors.New("unknown target")
+	// ErrUnauthorized is returned when the server does not accept streams from
+	// the client, or the relay-server could not verify the server.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNoSuchStream is returned when the relay-server has no allocation for
+	// the stream, e.g. because it expired.
+	ErrNoSuchStream = errors.New("no such stream")
+	// ErrQuotaExceeded is returned when an allocation or session limit is
+	// reached.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrShuttingDown is returned when the peer takes no new streams.
+	ErrShuttingDown = errors.New("shutting down")
+	// ErrBadRequest is returned for malformed or out of bounds requests.
+	ErrBadRequest = errors.New("bad request")
+	// ErrSessionNotBound is returned when a peer requiring session binding
+	// talks to one that does not bind its relayed streams.
+	ErrSessionNotBound = fmt.Errorf("relay session binding required: %w", ErrBadRequest)
+)
+
+// codeErrors pairs error codes with the errors they match.
+var codeErrors = []struct {
+	code controlpb.ErrorCode
+	err  error
+}{
+	{controlpb.ErrorCode_ERROR_CODE_UNKNOWN_TARGET, ErrUnknownTarget},
+	{controlpb.ErrorCode_ERROR_CODE_UNAUTHORIZED, ErrUnauthorized},
+	{controlpb.ErrorCode_ERROR_CODE_NO_SUCH_STREAM, ErrNoSuchStream},
+	{controlpb.ErrorCode_ERROR_CODE_HMAC_MISMATCH, relay_protocol.ErrHMACMismatch},
+	{controlpb.ErrorCode_ERROR_CODE_QUOTA_EXCEEDED, ErrQuotaExceeded},
+	{controlpb.ErrorCode_ERROR_CODE_SHUTTING_DOWN, ErrShuttingDown},
+	{controlpb.ErrorCode_ERROR_CODE_BAD_REQUEST, ErrBadRequest},
+}
+
+// Destination is what a client asks the server to bridge its stream to. The
+// zero value requests the default target of the server.
+type Destination struct {
+	// Service is a named target of the server, e.g. "ssh".
+	Service string
+	// Address is a host:port target.
+	Address string
+	// ALPN names the application protocol carried, e.g. "ssh".
+	ALPN string
+	// Priority is the class of traffic of the stream, which relay-servers
+	// schedule its bridge by.
+	Priority controlpb.StreamPriority
+}
+
+func (d Destination) IsZero() bool {
+	return d == Destination{}
+}
+
+func (d Destination) String() string {
+	s := "default"
+	switch {
+	case d.Service != "":
+		s = d.Service
+	case d.Address != "":
+		s = d.Address
+	}
+	if d.ALPN != "" {
+		s += " (" + d.ALPN + ")"
+	}
+	return s
+}
+
+// ParsePriority returns the stream priority named s: interactive, bulk, or ""
+// for none.
+func ParsePriority(s string) (controlpb.StreamPriority, error) {
+	switch s {
+	case "":
+		return controlpb.StreamPriority_STREAM_PRIORITY_UNSPECIFIED, nil
+	case "interactive":
+		return controlpb.StreamPriority_STREAM_PRIORITY_INTERACTIVE, nil
+	case "bulk":
+		return controlpb.StreamPriority_STREAM_PRIORITY_BULK, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q (want interactive or bulk)", s)
+}
+
+func destinationOf(req *controlpb.StartRelayStreamRequest) Destination {
+	return Destination{
+		Service:  req.GetService(),
+		Address:  req.GetTargetAddress(),
+		ALPN:     req.GetAlpn(),
+		Priority: req.GetPriority(),
+	}
+}
+
+// errorCode classifies err for a response. Failures reported by a relay-server
+// keep their code.
+func errorCode(err error) controlpb.ErrorCode {
+	var relayErr *RelayError
+	if errors.As(err, &relayErr) {
+		return relayErr.Code
+	}
+	for _, c := range codeErrors {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return controlpb.ErrorCode_ERROR_CODE_UNSPECIFIED
+}
+
+// codeIs reports whether code matches target.
+func codeIs(code controlpb.ErrorCode, target error) bool {
+	for _, c := range codeErrors {
+		if c.code == code {
+			return c.err == target
+		}
+	}
+	return false
+}
+
+// permanent reports whether err would fail the same way on any path to the
+// server.
+func permanent(err error) bool {
+	return errors.Is(err, ErrUnknownTarget) || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrBadRequest)
+}
+
+// ServerError is a failure reported by the server in a response. It matches the
+// error of its code, e.g. ErrUnknownTarget or ErrUnauthorized, with errors.Is.
+type ServerError struct {
+	Code    controlpb.ErrorCode
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "server error: " + e.Message
+}
+
+func (e *ServerError) Is(target error) bool {
+	return codeIs(e.Code, target)
+}
+
+// RelayError is a failure reported by a relay-server in a response or
+// handshake ack. It matches the error of its code, e.g. ErrQuotaExceeded or
+// ErrNoSuchStream, with errors.Is.
+type RelayError struct {
+	Code    controlpb.ErrorCode
+	Message string
+}
+
+func (e *RelayError) Error() string {
+	return "relay-server error: " + e.Message
+}
+
+func (e *RelayError) Is(target error) bool {
+	return codeIs(e.Code, target)
+}
@@ -5,45 +5,196 @@ package relay_client
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/flymesh/core/pkg/dialback"
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/metrics"
+	"github.com/flymesh/core/pkg/obfs"
 	controlpb "github.com/flymesh/core/pkg/pb/control"
+	"github.com/flymesh/core/pkg/policy"
 	"github.com/flymesh/core/pkg/protocol"
 	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ServerRole struct {
 	PrivKey     crypto.PrivKey
 	RelayPeerId peer.ID
-	Handler     func(streamInfo *StreamInfo, conn net.Conn)
+	// Handler is called with every stream set up, conn being a *Conn, and the
+	// session lasts until it returns. See also Listen.
+	Handler func(streamInfo *StreamInfo, conn net.Conn)
+	// Authorize, if set, is consulted for every stream request before anything
+	// is allocated for it, on the relay path and on direct connections alike.
+	// Returning an error rejects the request; the client sees ErrUnauthorized.
+	Authorize func(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest) error
+	// CheckDestination, if set, is consulted before a stream is set up for the
+	// destination a client requested. Returning an error wrapping
+	// ErrUnknownTarget tells the client the server does not serve it.
+	CheckDestination func(dst Destination) error
+	// MaxSessionsPerClient caps the concurrent sessions of one client. 0 means
+	// unlimited.
+	MaxSessionsPerClient int
+	// Grants, if set, admits the requests Authorize rejects from peers holding
+	// a guest grant for the destination. The sessions a grant admitted end when
+	// it expires, is revoked or its volume is transferred, and their relay
+	// allocations carry the same bounds.
+	Grants *Grants
+	// RequireSessionBinding rejects relay stream requests from clients that do
+	// not bind the Noise handshake to the allocation, see
+	// StreamInfo.BindSession.
+	RequireSessionBinding bool
+	// Compression lists the algorithms the server accepts to compress relayed
+	// streams with, see ParseCompression. The first one the client offers is
+	// used. Empty disables compression.
+	Compression []string
+	// Obfuscator, if set, disguises the connections to the relay-server, which
+	// must be configured with the same one.
+	Obfuscator obfs.Obfuscator
+	// Policy, if set, must allow every stream request, including those
+	// admitted under a guest grant, see PolicyAttrs. Optional.
+	Policy *policy.Policy
+	// RelayTLSConfig, if set, verifies the tls:// and wss:// endpoints of the
+	// relay-server instead of the system roots.
+	RelayTLSConfig *tls.Config
+	// RelayMACs lists the MAC algorithms relay-servers may authenticate
+	// their relay frames with, most preferred first, see
+	// relay_protocol.ParseMACs. Empty announces the defaults.
+	RelayMACs []relay_protocol.MAC
+	// IPv6Only dials the relay-server over IPv6 only.
+	IPv6Only bool
+	// OnStreamExpired, if set, is called when a relay-server reports that an
+	// allocation of the server expired before both peers connected to it, so
+	// that the caller can retry or report the failure. Optional.
+	OnStreamExpired func(StreamExpiry)
+	// Logger is used for all log output. If nil, slog.Default() is used.
+	Logger *slog.Logger
+	// Metrics receives the metrics of the sessions served. If nil, they are
+	// dropped.
+	Metrics metrics.Metrics
+
+	// mu guards the fields Reconfigure changes once r serves, and announced.
+	mu sync.RWMutex
+	// announced is the Hello of r from RegisterProtocol on.
+	announced *controlpb.Hello
+	sessions  sessionRegistry
+	bonds     bondRegistry
+	// transport receives the streams requested for TransportALPN, see
+	// AddTransport.
+	transport *Transport
+}
+
+func (r *ServerRole) logger() *slog.Logger {
+	return logging.Component(r.Logger, "server")
 }
 
-func (r *ServerRole) CreateStream(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID) (*StreamInfo, error) {
+// countSession counts a session served on path, and returns the func counting
+// it ended.
+func (r *ServerRole) countSession(path string) func() {
+	m := metrics.Or(r.Metrics)
+	m.Counter(metrics.ServerSessions, path).Add(1)
+	active := m.Gauge(metrics.ServerActiveSessions)
+	active.Add(1)
+	return func() { active.Add(-1) }
+}
+
+// ServerSettings are the fields of a ServerRole that Reconfigure changes.
+type ServerSettings struct {
+	RelayPeerId          peer.ID
+	Authorize            func(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest) error
+	Policy               *policy.Policy
+	MaxSessionsPerClient int
+}
+
+// Reconfigure changes the settings of a serving r. They apply to the stream
+// requests that follow: the sessions open keep running, even those the new
+// settings would refuse. The limits announced in the Hello of r stay as
+// registered.
+func (r *ServerRole) Reconfigure(s ServerSettings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.RelayPeerId = s.RelayPeerId
+	r.Authorize = s.Authorize
+	r.Policy = s.Policy
+	r.MaxSessionsPerClient = s.MaxSessionsPerClient
+}
+
+// Settings returns the settings Reconfigure changes.
+func (r *ServerRole) Settings() ServerSettings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return ServerSettings{
+		RelayPeerId:          r.RelayPeerId,
+		Authorize:            r.Authorize,
+		Policy:               r.Policy,
+		MaxSessionsPerClient: r.MaxSessionsPerClient,
+	}
+}
+
+// CreateStream allocates a stream to clientPeerId on the relay. retryCookie is
+// the RetryCookie of an earlier allocation the client failed to dial, which the
+// relay then drops, or nil.
+func (r *ServerRole) CreateStream(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID, retryCookie []byte) (*StreamInfo, error) {
+	return r.allocateStream(ctx, h, relayPeerId, clientPeerId, retryCookie, nil, controlpb.StreamPriority_STREAM_PRIORITY_UNSPECIFIED)
+}
+
+// allocateStream is CreateStream for a session admitted under g, or nil, whose
+// bridge the relay schedules as priority.
+func (r *ServerRole) allocateStream(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID, retryCookie []byte, g *grant, priority controlpb.StreamPriority) (*StreamInfo, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanCreateStream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrPeer.String(relayPeerId.String())))
+	info, err := r.createStream(ctx, h, relayPeerId, clientPeerId, retryCookie, g, priority)
+	if info != nil {
+		span.SetAttributes(tracing.AttrStreamID.Int64(int64(info.StreamID)))
+	}
+	tracing.End(span, err)
+	return info, err
+}
+
+func (r *ServerRole) createStream(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID, retryCookie []byte, g *grant, priority controlpb.StreamPriority) (*StreamInfo, error) {
 	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), relayPeerId, protocol.ProtoRelayCreate)
 	if err != nil {
 		return nil, fmt.Errorf("open relay-server create-stream: %w", err)
 	}
 	defer stream.Close()
 
-	req := controlpb.CreateStreamRequest{}
+	req := controlpb.CreateStreamRequest{
+		TraceContext: tracing.Inject(ctx),
+		RetryCookie:  retryCookie,
+		Priority:     priority,
+	}
 	req.ClientPeerId, err = clientPeerId.Marshal()
+	if g != nil {
+		// Bound the bridge by the grant too. The relay-server counts the
+		// encrypted stream, so leave room for the framing of small writes.
+		req.DeadlineUnixMs = g.Expires.UnixMilli()
+		if left := g.remaining(); left >= 0 {
+			req.MaxBytes = max(2*uint64(left), 1)
+		}
+	}
 
 	payload, err := req.MarshalVT()
 	if err != nil {
 		return nil, fmt.Errorf("marshal CreateStreamRequest: %w", err)
 	}
-	if err := relay_protocol.WriteControlFrame(stream, relay_protocol.ControlTypeCreateStreamRequest, payload); err != nil {
+	if err := relay_protocol.WriteControlRequest(h, stream, r.hello(), relay_protocol.ControlTypeCreateStreamRequest, payload); err != nil {
 		return nil, fmt.Errorf("write CreateStreamRequest: %w", err)
 	}
 
-	typ, data, err := relay_protocol.ReadControlFrame(stream, time.Second*10)
+	typ, data, err := relay_protocol.ReadControlResponse(h, stream, time.Second*10)
 	if err != nil {
 		return nil, fmt.Errorf("read CreateStreamResponse: %w", err)
 	}
@@ -55,10 +206,14 @@ func (r *ServerRole) CreateStream(ctx context.Context, h host.Host, relayPeerId
 		return nil, fmt.Errorf("decode CreateStreamResponse: %w", err)
 	}
 	if !resp.GetOk() {
-		return nil, fmt.Errorf("relay-server error: %s", resp.GetError())
+		return nil, &RelayError{Code: resp.GetErrorCode(), Message: resp.GetError()}
 	}
 
-	log.Printf("[server] relay-server endpoint: %s, streamID=%d", resp.GetRelayEndpoint(), resp.GetStreamId())
+	r.logger().Info("relay stream created",
+		logging.KeyClientPeer, clientPeerId.String(),
+		logging.KeyStreamID, resp.GetStreamId(),
+		"relay_endpoint", resp.GetRelayEndpoint(),
+		"observed_address", resp.GetObservedAddress())
 
 	return &StreamInfo{
 		RelayEndpoint: resp.GetRelayEndpoint(),
@@ -67,68 +222,501 @@ func (r *ServerRole) CreateStream(ctx context.Context, h host.Host, relayPeerId
 		IsServer:      true,
 		LocalPeerID:   h.ID(),
 		RemotePeerID:  clientPeerId,
+		RetryCookie:   resp.GetRetryCookie(),
+
+		RelayEndpoints:  resp.GetRelayEndpoints(),
+		ObservedAddress: resp.GetObservedAddress(),
 	}, nil
 }
 
+// CreateStreams allocates count streams to clientPeerId on the relay in one
+// round trip, for servers expecting a burst of connections from that client.
+// The allocations share their expiry.
+func (r *ServerRole) CreateStreams(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID, count int) ([]*StreamInfo, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanCreateStreams,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.AttrPeer.String(relayPeerId.String())))
+	infos, err := r.createStreams(ctx, h, relayPeerId, clientPeerId, count)
+	tracing.End(span, err)
+	return infos, err
+}
+
+func (r *ServerRole) createStreams(ctx context.Context, h host.Host, relayPeerId peer.ID, clientPeerId peer.ID, count int) ([]*StreamInfo, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("bad stream count %d", count)
+	}
+	if hello := relay_protocol.PeerHello(h, relayPeerId); hello != nil {
+		if !relay_protocol.HasFeature(hello, relay_protocol.FeatureBatchAllocation) {
+			return nil, fmt.Errorf("relay-server %s does not support batch allocation", relayPeerId)
+		}
+		if limit := hello.GetLimits().GetMaxBatchAllocations(); limit > 0 && uint32(count) > limit {
+			return nil, fmt.Errorf("relay-server allocates at most %d streams per batch, requested %d", limit, count)
+		}
+	}
+	stream, err := h.NewStream(network.WithAllowLimitedConn(ctx, ""), relayPeerId, protocol.ProtoRelayCreate)
+	if err != nil {
+		return nil, fmt.Errorf("open relay-server create-stream: %w", err)
+	}
+	defer stream.Close()
+
+	req := controlpb.CreateStreamsRequest{
+		Count:        uint32(count),
+		TraceContext: tracing.Inject(ctx),
+	}
+	req.ClientPeerId, err = clientPeerId.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("marshal client peer id: %w", err)
+	}
+
+	payload, err := req.MarshalVT()
+	if err != nil {
+		return nil, fmt.Errorf("marshal CreateStreamsRequest: %w", err)
+	}
+	if err := relay_protocol.WriteControlRequest(h, stream, r.hello(), relay_protocol.ControlTypeCreateStreamsRequest, payload); err != nil {
+		return nil, fmt.Errorf("write CreateStreamsRequest: %w", err)
+	}
+
+	typ, data, err := relay_protocol.ReadControlResponse(h, stream, time.Second*10)
+	if err != nil {
+		return nil, fmt.Errorf("read CreateStreamsResponse: %w", err)
+	}
+	if typ != relay_protocol.ControlTypeCreateStreamsResponse {
+		return nil, fmt.Errorf("unexpected type 0x%04x", typ)
+	}
+	var resp controlpb.CreateStreamsResponse
+	if err := resp.UnmarshalVT(data); err != nil {
+		return nil, fmt.Errorf("decode CreateStreamsResponse: %w", err)
+	}
+	if !resp.GetOk() {
+		return nil, &RelayError{Code: resp.GetErrorCode(), Message: resp.GetError()}
+	}
+	if len(resp.GetStreams()) != count {
+		return nil, fmt.Errorf("relay-server allocated %d streams, requested %d", len(resp.GetStreams()), count)
+	}
+
+	r.logger().Info("relay streams created",
+		logging.KeyClientPeer, clientPeerId.String(),
+		"count", count,
+		"relay_endpoint", resp.GetRelayEndpoint(),
+		"observed_address", resp.GetObservedAddress())
+
+	expires := time.UnixMilli(resp.GetExpiresUnixMs())
+	infos := make([]*StreamInfo, 0, count)
+	for _, a := range resp.GetStreams() {
+		infos = append(infos, &StreamInfo{
+			RelayEndpoint: resp.GetRelayEndpoint(),
+			StreamID:      a.GetStreamId(),
+			Token:         a.GetToken(),
+			IsServer:      true,
+			LocalPeerID:   h.ID(),
+			RemotePeerID:  clientPeerId,
+			RetryCookie:   a.GetRetryCookie(),
+			Expires:       expires,
+
+			RelayEndpoints:  resp.GetRelayEndpoints(),
+			ObservedAddress: resp.GetObservedAddress(),
+		})
+	}
+	return infos, nil
+}
+
+// RegisterProtocol registers the start-relay and direct stream handlers,
+// answers dial-back challenges from relay-servers verifying this peer and
+// Hello exchanges, and receives the notifications of relay-servers.
 func (r *ServerRole) RegisterProtocol(h host.Host) {
 	h.SetStreamHandler(protocol.ProtoServerStartRelay, func(stream network.Stream) {
 		r.HandleStartRelay(h, stream)
 	})
+	h.SetStreamHandler(protocol.ProtoServerDirect, func(stream network.Stream) {
+		r.HandleDirect(h, stream)
+	})
+	h.SetStreamHandler(protocol.ProtoServerNotify, func(stream network.Stream) {
+		r.HandleNotify(h, stream)
+	})
+	r.mu.Lock()
+	r.announced = relay_protocol.NewHello(&controlpb.Limits{
+		MaxSessionsPerClient: uint32(r.MaxSessionsPerClient),
+	})
+	r.mu.Unlock()
+	dialback.RegisterResponder(h, r.PrivKey, r.hello(), r.Logger)
+	relay_protocol.RegisterInfo(h, r.hello())
+}
+
+// hello returns the Hello r announces to clients and relay-servers: the one
+// of RegisterProtocol once it ran, which Reconfigure leaves alone.
+func (r *ServerRole) hello() *controlpb.Hello {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.announced != nil {
+		return r.announced
+	}
+	return relay_protocol.NewHello(&controlpb.Limits{
+		MaxSessionsPerClient: uint32(r.MaxSessionsPerClient),
+	})
 }
 
 func (r *ServerRole) HandleStartRelay(h host.Host, s network.Stream) {
 	defer s.Close()
 	clientPeerID := s.Conn().RemotePeer()
 
-	ctx := context.Background()
+	logger := r.logger().With(logging.KeyClientPeer, clientPeerID.String())
+	logger.Info("start-relay-server-stream request")
+
+	req, ok := r.readStartRelayRequest(h, s, logger)
+	if !ok {
+		return
+	}
 
-	log.Printf("[server] start-relay-server-stream from %s", clientPeerID)
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), req.GetTraceContext()), tracing.SpanHandleStartRelayStream,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.AttrPeer.String(clientPeerID.String())))
 
-	typ, _, err := relay_protocol.ReadControlFrame(s, time.Second*10)
+	g, err := r.admit(clientPeerID, req, logger)
 	if err != nil {
-		log.Printf("[server] read StartRelayStreamRequest failed: %v", err)
+		logger.Warn("stream request not authorized", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
 		return
 	}
-	if typ != relay_protocol.ControlTypeStartRelayStreamRequest {
-		log.Printf("[server] unexpected type: 0x%04x", typ)
+	if req.GetAlpn() == TransportALPN {
+		err := r.startTransportStream(ctx, h, s, clientPeerID, req, g)
+		if err != nil {
+			logger.Warn("transport stream refused", "err", err)
+		}
+		tracing.End(span, err)
 		return
 	}
-
-	streamInfo, err := r.CreateStream(ctx, h, r.RelayPeerId, clientPeerID)
+	dst := destinationOf(req)
+	if err := r.checkDestination(dst); err != nil {
+		logger.Warn("destination rejected", "destination", dst.String(), "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
+		return
+	}
+	if err := checkBond(req); err != nil {
+		logger.Warn("stream request refused", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
+		return
+	}
+	if r.RequireSessionBinding && !req.GetBindSession() {
+		err := ErrSessionNotBound
+		logger.Warn("stream request refused", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
+		return
+	}
+	if err := r.sessions.reserve(clientPeerID, r.Settings().MaxSessionsPerClient); err != nil {
+		logger.Warn("stream request refused", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
+		return
+	}
+	if len(req.GetRetryCookie()) > 0 {
+		logger.Info("client retries relay stream")
+	}
+	streamInfo, err := r.allocateStream(ctx, h, r.Settings().RelayPeerId, clientPeerID, req.GetRetryCookie(), g, dst.Priority)
 	if err != nil {
-		log.Printf("[server] create stream failed: %v", err)
+		r.sessions.release(clientPeerID)
+		logger.Warn("create stream failed", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		tracing.End(span, err)
 		return
 	}
+	streamInfo.Destination = dst
+	streamInfo.Bond = req.GetBond()
+	streamInfo.BindSession = req.GetBindSession()
+	streamInfo.Compression = negotiateCompression(req.GetCompression(), r.Compression)
+	streamInfo.Obfuscator = r.Obfuscator
+	streamInfo.TLSConfig = r.RelayTLSConfig
+	streamInfo.RelayMACs = r.RelayMACs
+	streamInfo.IPv6Only = r.IPv6Only
+	span.SetAttributes(tracing.AttrStreamID.Int64(int64(streamInfo.StreamID)))
+	r.serveRelayed(ctx, h, streamInfo, g, logger)
+
+	// Return StartRelayStreamResponse to the client
+	err = writeStartRelayResponse(h, s, streamInfo, nil)
+	tracing.End(span, err)
+}
 
+// serveRelayed dials the relay allocation of streamInfo, for a session of its
+// remote peer reserved in r.sessions and admitted under g, or nil, and passes
+// it to r.Handler.
+func (r *ServerRole) serveRelayed(ctx context.Context, h host.Host, streamInfo *StreamInfo, g *grant, logger *slog.Logger) {
+	clientPeerID := streamInfo.RemotePeerID
+	features := relay_protocol.PeerHello(h, clientPeerID).GetFeatures()
+	sessCtx, cancel := context.WithCancel(ctx)
+	sess := r.sessions.add(clientPeerID, streamInfo.StreamID, streamInfo, SessionDialing, cancel)
 	go func() {
-		conn, err := DialRelayStream(ctx, r.PrivKey, streamInfo)
+		defer r.sessions.remove(sess)
+		defer r.watchGrant(sess, g)()
+		conn, err := DialRelayStream(sessCtx, r.PrivKey, streamInfo)
 		if err != nil {
-			log.Printf("[server] Stream[%d] dial relay failed: %+v", streamInfo.StreamID, err)
+			logger.Warn("dial relay failed", logging.KeyStreamID, streamInfo.StreamID, "err", err)
+			return
+		}
+		conn.grant = g
+		conn.features = features
+		if !r.sessions.activate(sess, conn) {
+			_ = conn.Close()
+			return
+		}
+		defer r.countSession(PathRelay)()
+		if len(streamInfo.Bond) > 0 {
+			r.serveBondPath(streamInfo, conn, logger)
 			return
 		}
-		
+
 		r.Handler(streamInfo, conn)
 	}()
-
-	// Return StartRelayStreamResponse to the client
-	_ = writeStartRelayResponse(s, true, "", streamInfo)
 }
 
-func writeStartRelayResponse(s network.Stream, ok bool, errStr string, streamInfo *StreamInfo) error {
-	resp := controlpb.StartRelayStreamResponse{
-		Ok:            ok,
-		Error:         errStr,
-		RelayEndpoint: streamInfo.RelayEndpoint,
-		StreamId:      streamInfo.StreamID,
-		Token:         streamInfo.Token,
+// startTransportStream allocates a relay stream carrying a libp2p connection
+// of the client, answers s with it and passes it to the Transport once the
+// relay connection is set up. Such streams are libp2p connections rather than
+// sessions: they are bounded by the resource manager of h, and guest grants do
+// not cover them.
+func (r *ServerRole) startTransportStream(ctx context.Context, h host.Host, s network.Stream, clientPeerID peer.ID, req *controlpb.StartRelayStreamRequest, g *grant) error {
+	var err error
+	switch {
+	case r.transport == nil:
+		err = fmt.Errorf("%w: libp2p transport", ErrUnknownTarget)
+	case g != nil:
+		err = fmt.Errorf("%w: guest grants do not cover libp2p connections", ErrUnauthorized)
 	}
-	payload, err := resp.MarshalVT()
 	if err != nil {
+		_ = writeStartRelayResponse(h, s, nil, err)
 		return err
 	}
-	if err := relay_protocol.WriteControlFrame(s, relay_protocol.ControlTypeStartRelayStreamResponse, payload); err != nil {
+	streamInfo, err := r.allocateStream(ctx, h, r.Settings().RelayPeerId, clientPeerID, req.GetRetryCookie(), nil, req.GetPriority())
+	if err != nil {
+		_ = writeStartRelayResponse(h, s, nil, err)
 		return err
 	}
+	streamInfo.Destination = destinationOf(req)
+	streamInfo.Obfuscator = r.Obfuscator
+	streamInfo.TLSConfig = r.RelayTLSConfig
+	streamInfo.RelayMACs = r.RelayMACs
+	streamInfo.IPv6Only = r.IPv6Only
+	go r.transport.accept(streamInfo)
+	return writeStartRelayResponse(h, s, streamInfo, nil)
+}
+
+// HandleDirect passes a stream a client opened on a direct connection to
+// r.Handler. Like a start-relay request, the stream begins with a
+// StartRelayStreamRequest naming the destination, answered before any data.
+func (r *ServerRole) HandleDirect(h host.Host, s network.Stream) {
+	clientPeerID := s.Conn().RemotePeer()
+	logger := r.logger().With(logging.KeyClientPeer, clientPeerID.String())
+	// Circuit relays cap the data of limited connections, so keep those for
+	// control streams only.
+	if s.Conn().Stat().Limited {
+		logger.Warn("direct stream on a limited connection, resetting")
+		_ = s.Reset()
+		return
+	}
+
+	req, ok := r.readStartRelayRequest(h, s, logger)
+	if !ok {
+		_ = s.Reset()
+		return
+	}
+	g, err := r.admit(clientPeerID, req, logger)
+	if err != nil {
+		logger.Warn("stream request not authorized", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		_ = s.Close()
+		return
+	}
+	dst := destinationOf(req)
+	if err := r.checkDestination(dst); err != nil {
+		logger.Warn("destination rejected", "destination", dst.String(), "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		_ = s.Close()
+		return
+	}
+	if err := checkBond(req); err != nil {
+		logger.Warn("stream request refused", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		_ = s.Close()
+		return
+	}
+	if err := r.sessions.reserve(clientPeerID, r.Settings().MaxSessionsPerClient); err != nil {
+		logger.Warn("stream request refused", "err", err)
+		_ = writeStartRelayResponse(h, s, nil, err)
+		_ = s.Close()
+		return
+	}
+	streamInfo := &StreamInfo{
+		IsServer:     true,
+		LocalPeerID:  h.ID(),
+		RemotePeerID: clientPeerID,
+		Destination:  dst,
+		Direct:       true,
+		Bond:         req.GetBond(),
+	}
+	sess := r.sessions.add(clientPeerID, rand.Uint64(), streamInfo, SessionDialing, nil)
+	defer r.sessions.remove(sess)
+	defer r.watchGrant(sess, g)()
+	if err := writeStartRelayResponse(h, s, streamInfo, nil); err != nil {
+		logger.Warn("write StartRelayStreamResponse failed", "err", err)
+		_ = s.Reset()
+		return
+	}
+	conn := newConn(streamConn{s}, streamInfo)
+	conn.grant = g
+	conn.features = relay_protocol.PeerHello(h, clientPeerID).GetFeatures()
+	if !r.sessions.activate(sess, conn) {
+		_ = s.Reset()
+		return
+	}
+	defer r.countSession(PathDirect)()
+	logger.Info("direct stream opened", "addr", s.Conn().RemoteMultiaddr().String(), "destination", dst.String())
+	if len(streamInfo.Bond) > 0 {
+		r.serveBondPath(streamInfo, conn, logger)
+		return
+	}
+
+	r.Handler(streamInfo, conn)
+}
+
+// PolicyAttrs are the attributes of the stream requests that
+// ServerRole.Policy is evaluated over:
+//
+//   - peer: the client peer
+//   - service, address, alpn: the destination asked for, see Destination
+//   - guest: whether the request is admitted under a guest grant
+//   - sessions: the sessions the client holds
+var PolicyAttrs = []string{"peer", "service", "address", "alpn", "guest", "sessions"}
+
+// admit authorizes a stream request, admitting a request r.Authorize rejects
+// under a guest grant of the client for its destination, then checks it
+// against r.Policy. It returns the grant, or nil if the request is admitted
+// without one.
+func (r *ServerRole) admit(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest, logger *slog.Logger) (*grant, error) {
+	g, err := r.admitGrant(clientPeer, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkPolicy(clientPeer, req, g != nil); err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, nil
+	}
+	logger.Info("stream request admitted under guest grant",
+		"service", g.Service,
+		"expires", g.Expires,
+		"remaining_bytes", g.remaining())
+	return g, nil
+}
 
+func (r *ServerRole) admitGrant(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest) (*grant, error) {
+	err := r.authorize(clientPeer, req)
+	if err == nil || r.Grants == nil {
+		return nil, err
+	}
+	g, grantErr := r.Grants.lookup(clientPeer, destinationOf(req))
+	if grantErr != nil {
+		return nil, grantErr
+	}
+	if g == nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// checkPolicy evaluates r.Policy over a stream request. A rejection wraps
+// ErrUnauthorized.
+func (r *ServerRole) checkPolicy(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest, guest bool) error {
+	p := r.Settings().Policy
+	if p == nil {
+		return nil
+	}
+	dst := destinationOf(req)
+	err := p.Check(policy.Attrs{
+		"peer":     clientPeer.String(),
+		"service":  dst.Service,
+		"address":  dst.Address,
+		"alpn":     dst.ALPN,
+		"guest":    guest,
+		"sessions": r.sessions.count(clientPeer),
+	}, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	}
 	return nil
 }
+
+// watchGrant ends sess when g, if not nil, expires or is revoked. The returned
+// func stops watching.
+func (r *ServerRole) watchGrant(sess *session, g *grant) func() bool {
+	if g == nil {
+		return func() bool { return false }
+	}
+	return context.AfterFunc(g.ctx, func() {
+		r.sessions.close(sessionKey{sess.ClientPeer, sess.ID})
+	})
+}
+
+// authorize consults r.Authorize, making sure a rejection wraps ErrUnauthorized.
+func (r *ServerRole) authorize(clientPeer peer.ID, req *controlpb.StartRelayStreamRequest) error {
+	authorize := r.Settings().Authorize
+	if authorize == nil {
+		return nil
+	}
+	err := authorize(clientPeer, req)
+	if err == nil || errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+}
+
+func (r *ServerRole) checkDestination(dst Destination) error {
+	if r.CheckDestination == nil {
+		return nil
+	}
+	return r.CheckDestination(dst)
+}
+
+func (r *ServerRole) readStartRelayRequest(h host.Host, s network.Stream, logger *slog.Logger) (*controlpb.StartRelayStreamRequest, bool) {
+	typ, data, err := relay_protocol.ReadControlRequest(h, s, r.hello(), time.Second*10)
+	if err != nil {
+		logger.Warn("read StartRelayStreamRequest failed", "err", err)
+		return nil, false
+	}
+	if typ != relay_protocol.ControlTypeStartRelayStreamRequest {
+		logger.Warn("unexpected control frame type", "type", typ)
+		return nil, false
+	}
+	var req controlpb.StartRelayStreamRequest
+	if err := req.UnmarshalVT(data); err != nil {
+		logger.Warn("bad StartRelayStreamRequest", "err", err)
+		return nil, false
+	}
+	return &req, true
+}
+
+// writeStartRelayResponse answers with streamInfo, or with the failure err.
+func writeStartRelayResponse(h host.Host, s network.Stream, streamInfo *StreamInfo, err error) error {
+	resp := controlpb.StartRelayStreamResponse{
+		Ok: err == nil,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorCode = errorCode(err)
+	} else {
+		resp.RelayEndpoint = streamInfo.RelayEndpoint
+		resp.StreamId = streamInfo.StreamID
+		resp.Token = streamInfo.Token
+		resp.RetryCookie = streamInfo.RetryCookie
+		resp.BindSession = streamInfo.BindSession
+		resp.Compression = streamInfo.Compression
+		resp.RelayEndpoints = streamInfo.RelayEndpoints
+	}
+	payload, err := resp.MarshalVT()
+	if err != nil {
+		return err
+	}
+	return relay_protocol.WriteControlResponse(h, s, relay_protocol.ControlTypeStartRelayStreamResponse, payload)
+}
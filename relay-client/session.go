@@ -0,0 +1,315 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/flymesh/core/pkg/status"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// ErrTooManySessions is returned when a client already has
+	// ServerRole.MaxSessionsPerClient sessions.
+	ErrTooManySessions = fmt.Errorf("too many sessions: %w", ErrQuotaExceeded)
+	// ErrServerClosed is returned for requests arriving after ServerRole.Shutdown.
+	ErrServerClosed = fmt.Errorf("server %w", ErrShuttingDown)
+)
+
+// SessionState is the lifecycle state of a Session.
+type SessionState int
+
+const (
+	// SessionDialing is a relay allocation the server is still dialing.
+	SessionDialing SessionState = iota
+	// SessionActive is a stream passed to ServerRole.Handler.
+	SessionActive
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionDialing:
+		return "dialing"
+	case SessionActive:
+		return "active"
+	default:
+		return "unknown"
+	}
+}
+
+// Session is a stream ServerRole serves for a client.
+type Session struct {
+	ClientPeer peer.ID
+	// ID is the relay stream ID, or a random ID for a direct stream.
+	ID      uint64
+	Info    *StreamInfo
+	State   SessionState
+	Created time.Time
+}
+
+type sessionKey struct {
+	client peer.ID
+	id     uint64
+}
+
+type session struct {
+	Session
+	cancel  context.CancelFunc
+	conn    net.Conn
+	stopped bool
+}
+
+// sessionRegistry tracks the sessions of a ServerRole. Its zero value is ready
+// to use.
+type sessionRegistry struct {
+	mu        sync.Mutex
+	sessions  map[sessionKey]*session
+	perClient map[peer.ID]int
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// reserve counts a new session of client against limit (0 means unlimited).
+// The caller either adds it or releases it.
+func (g *sessionRegistry) reserve(client peer.ID, limit int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrServerClosed
+	}
+	if limit > 0 && g.perClient[client] >= limit {
+		return ErrTooManySessions
+	}
+	if g.perClient == nil {
+		g.perClient = make(map[peer.ID]int)
+	}
+	g.perClient[client]++
+	g.wg.Add(1)
+	return nil
+}
+
+// release gives back a reservation that did not become a session.
+func (g *sessionRegistry) release(client peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.uncount(client)
+}
+
+func (g *sessionRegistry) uncount(client peer.ID) {
+	if g.perClient[client]--; g.perClient[client] <= 0 {
+		delete(g.perClient, client)
+	}
+	g.wg.Done()
+}
+
+// count returns the sessions of client, reservations included.
+func (g *sessionRegistry) count(client peer.ID) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.perClient[client]
+}
+
+// add registers a reserved session. cancel aborts it while it is dialing.
+func (g *sessionRegistry) add(client peer.ID, id uint64, info *StreamInfo, state SessionState, cancel context.CancelFunc) *session {
+	s := &session{
+		Session: Session{
+			ClientPeer: client,
+			ID:         id,
+			Info:       info,
+			State:      state,
+			Created:    time.Now(),
+		},
+		cancel: cancel,
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sessions == nil {
+		g.sessions = make(map[sessionKey]*session)
+	}
+	g.sessions[sessionKey{client, id}] = s
+	return s
+}
+
+// activate marks s as passed to the handler with conn. It returns false if s
+// was stopped meanwhile.
+func (g *sessionRegistry) activate(s *session, conn net.Conn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.State = SessionActive
+	s.conn = conn
+	return true
+}
+
+// remove unregisters s once it ended.
+func (g *sessionRegistry) remove(s *session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.sessions, sessionKey{s.ClientPeer, s.ID})
+	g.uncount(s.ClientPeer)
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+func (g *sessionRegistry) list() []Session {
+	g.mu.Lock()
+	out := make([]Session, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		out = append(out, s.Session)
+	}
+	g.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+	return out
+}
+
+func (g *sessionRegistry) close(key sessionKey) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.sessions[key]
+	if ok {
+		s.stop()
+	}
+	return ok
+}
+
+// stop cancels s while dialing and closes its conn once active.
+func (s *session) stop() {
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
+
+// shutdown refuses new sessions and waits for the current ones to end. When ctx
+// is done first, it cancels the remaining ones and returns ctx.Err().
+func (g *sessionRegistry) shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	g.mu.Lock()
+	for _, s := range g.sessions {
+		s.stop()
+	}
+	g.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// Sessions returns the sessions of r, oldest first.
+func (r *ServerRole) Sessions() []Session {
+	return r.sessions.list()
+}
+
+// CloseSession ends the session id of client. It reports whether the session
+// was found.
+func (r *ServerRole) CloseSession(client peer.ID, id uint64) bool {
+	return r.sessions.close(sessionKey{client, id})
+}
+
+// Shutdown refuses new stream requests and waits for the sessions to end. When
+// ctx is done first, it cancels the remaining sessions and returns ctx.Err().
+// The protocol handlers should be removed from the host beforehand.
+func (r *ServerRole) Shutdown(ctx context.Context) error {
+	return r.sessions.shutdown(ctx)
+}
+
+// FillStatus implements status.Source.
+func (r *ServerRole) FillStatus(s *status.Status) {
+	for _, sess := range r.Sessions() {
+		s.Sessions = append(s.Sessions, status.SessionInfo{
+			StreamID:     sess.ID,
+			ServerPeerID: sess.Info.LocalPeerID.String(),
+			ClientPeerID: sess.ClientPeer.String(),
+			State:        sess.State.String(),
+			CreatedAt:    sess.Created,
+		})
+	}
+	if r.Grants == nil {
+		return
+	}
+	for _, g := range r.Grants.List() {
+		s.Grants = append(s.Grants, status.GrantInfo{
+			PeerID:    g.Peer.String(),
+			Service:   g.Service,
+			ExpiresAt: g.Expires,
+			MaxBytes:  g.MaxBytes,
+			UsedBytes: g.Used,
+		})
+	}
+}
+
+// StreamSet keeps the open streams of a peer for its status document. Its zero
+// value is empty.
+type StreamSet struct {
+	mu    sync.Mutex
+	conns map[*Conn]struct{}
+}
+
+// Add keeps c until it closes.
+func (s *StreamSet) Add(c *Conn) {
+	s.mu.Lock()
+	if s.conns == nil {
+		s.conns = make(map[*Conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	c.OnClose(func(ConnStats) {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	})
+}
+
+// FillStatus implements status.Source, oldest stream first.
+func (s *StreamSet) FillStatus(st *status.Status) {
+	s.mu.Lock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	infos := make([]status.StreamInfo, 0, len(conns))
+	for _, c := range conns {
+		meta, stats := c.Meta(), c.Stats()
+		infos = append(infos, status.StreamInfo{
+			StreamID:      meta.StreamID,
+			RemotePeerID:  meta.RemotePeer.String(),
+			Path:          meta.Path,
+			RelayEndpoint: meta.RelayEndpoint,
+			Destination:   meta.Destination.String(),
+			OpenedAt:      stats.Opened,
+			LastActivity:  stats.LastActivity,
+			BytesRead:     stats.BytesRead,
+			BytesWritten:  stats.BytesWritten,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].OpenedAt.Before(infos[j].OpenedAt)
+	})
+	st.Streams = append(st.Streams, infos...)
+}
@@ -5,12 +5,21 @@ package relay_client
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
 	"net"
+	"time"
 
+	"github.com/flymesh/core/pkg/obfs"
+	relaypb "github.com/flymesh/core/pkg/pb/relay"
+	relay_protocol "github.com/flymesh/core/pkg/relay-protocol"
+	"github.com/flymesh/core/pkg/tracing"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type StreamInfo struct {
@@ -20,6 +29,49 @@ type StreamInfo struct {
 	IsServer      bool
 	LocalPeerID   peer.ID
 	RemotePeerID  peer.ID
+	// Destination is what the client asked the stream to be bridged to.
+	Destination Destination
+	// Direct marks a stream carried over a direct libp2p connection instead of
+	// the relay-server. RelayEndpoint, StreamID and Token are then unset.
+	Direct bool
+	// RetryCookie identifies the allocation to the relay when the client
+	// retries after a failed dial.
+	RetryCookie []byte
+	// Expires is when the relay drops the allocation if it is still unused.
+	// Zero if the relay did not report it.
+	Expires time.Time
+	// BindSession binds the Noise handshake to the allocation, so that it
+	// fails unless both peers dialed the same one. Both peers must agree on it,
+	// as negotiated by the start-relay exchange.
+	BindSession bool
+	// Compression is the algorithm compressing the relayed stream inside
+	// Noise, as negotiated by the start-relay exchange. Empty for none.
+	Compression string
+	// Obfuscator, if set, disguises the connection to the relay-server, which
+	// must be configured with the same one.
+	Obfuscator obfs.Obfuscator
+	// TLSConfig, if set, verifies the tls:// and wss:// endpoints of the
+	// relay-server instead of the system roots.
+	TLSConfig *tls.Config
+	// RelayMACs lists the MAC algorithms the relay-server may authenticate
+	// its frames with, most preferred first. Empty announces the defaults of
+	// relay_protocol.NewHello.
+	RelayMACs []relay_protocol.MAC
+	// IPv6Only dials the relay-server over IPv6 only, through the NAT64 of
+	// the network for IPv4 literal endpoints.
+	IPv6Only bool
+	// RelayEndpoints lists every endpoint of the relay-server, dialed after
+	// RelayEndpoint in staggered parallel attempts, the first to connect
+	// being used. Optional.
+	RelayEndpoints []string
+	// ObservedAddress is the address, ip:port, the relay-server saw this peer
+	// come from: over the libp2p connection the stream was allocated on, then
+	// over the relay connection once it is set up. It is the NAT mapping of
+	// the peer towards the relay, empty if the relay did not report it.
+	ObservedAddress string
+	// Bond is the ID of the bonded stream the stream is a path of, see
+	// DialBond. Nil for a stream of its own.
+	Bond []byte
 }
 
 type commonRole struct {
@@ -28,40 +80,226 @@ type commonRole struct {
 
 var dialer net.Dialer
 
-func DialRelayStream(ctx context.Context, privateKey crypto.PrivKey, info *StreamInfo) (sec.SecureConn, error) {
-	var success bool
+// Bounds of the phases of DialRelayStream, on top of the deadline of its
+// context. Without a Ready frame, the Noise handshake completes only once the
+// remote peer dialed the relay-server too, so it then gets the time the Ready
+// wait would have.
+const (
+	relayConnectTimeout   = 10 * time.Second
+	relayHandshakeTimeout = 10 * time.Second
+	bridgeReadyTimeout    = 30 * time.Second
+	noiseReadyTimeout     = 10 * time.Second
+	noiseHandshakeTimeout = bridgeReadyTimeout + noiseReadyTimeout
+)
+
+// Phases of DialRelayStream, reported by DialError.
+const (
+	// DialPhaseConnect is the connection to the relay-server, over TCP or
+	// WebSocket.
+	DialPhaseConnect = "connect"
+	// DialPhaseHandshake is the write of the HandshakeRequest.
+	DialPhaseHandshake = "handshake"
+	// DialPhaseAck is the read of the HandshakeAck.
+	DialPhaseAck = "ack"
+	// DialPhaseReady is the wait for the remote peer to connect to the
+	// relay-server.
+	DialPhaseReady = "ready"
+	// DialPhaseNoise is the Noise handshake with the remote peer.
+	DialPhaseNoise = "noise"
+)
+
+// DialError is a failure of DialRelayStream, with the phase it failed in.
+type DialError struct {
+	Phase string
+	Err   error
+}
+
+func (e *DialError) Error() string {
+	return "relay dial " + e.Phase + ": " + e.Err.Error()
+}
 
-	conn, err := dialer.DialContext(ctx, "tcp", info.RelayEndpoint)
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// DialRelayStream connects to the relay-server of info and secures the stream
+// end-to-end with the remote peer. Every phase is bounded by ctx and by its own
+// timeout. Failures are *DialError.
+func DialRelayStream(ctx context.Context, privateKey crypto.PrivKey, info *StreamInfo) (*Conn, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanDialRelayStream,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			tracing.AttrStreamID.Int64(int64(info.StreamID)),
+			tracing.AttrPeer.String(info.RemotePeerID.String()),
+			tracing.AttrRelayEndpoint.String(info.RelayEndpoint)))
+	sconn, err := dialRelayStream(ctx, privateKey, info)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(sconn, info), nil
+}
+
+func dialRelayStream(ctx context.Context, privateKey crypto.PrivKey, info *StreamInfo) (sec.SecureConn, error) {
+	conn, noiseTimeout, err := connectRelay(ctx, info)
 	if err != nil {
 		return nil, err
 	}
+	var success bool
 	defer func() {
 		if !success {
 			_ = conn.Close()
 		}
 	}()
 
-	// send handshake for this data conn as well
-	if err := sendHandshake(conn, info.StreamID, info.Token, info.LocalPeerID); err != nil {
+	sconn, err := noiseUpgrade(ctx, conn, privateKey, info, noiseTimeout)
+	if err != nil {
+		return nil, &DialError{Phase: DialPhaseNoise, Err: err}
+	}
+	sconn, err = compress(sconn, info.Compression)
+	if err != nil {
 		return nil, err
 	}
+	success = true
+	return sconn, nil
+}
 
-	// read ack
-	if err := readHandshakeAck(conn, info.Token); err != nil {
-		return nil, err
+// connectRelay connects to the relay-server of info and attaches the
+// connection to its allocation. It returns the connection, not yet secured
+// with the remote peer, and the time the Noise handshake may take over it.
+func connectRelay(ctx context.Context, info *StreamInfo) (_ net.Conn, noiseTimeout time.Duration, err error) {
+	conn, err := dialRelay(ctx, info)
+	if err != nil {
+		return nil, 0, &DialError{Phase: DialPhaseConnect, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+	if info.Obfuscator != nil {
+		if conn, err = info.Obfuscator.Client(conn); err != nil {
+			return nil, 0, &DialError{Phase: DialPhaseConnect, Err: err}
+		}
 	}
 
-	tpt, err := noise.New(noise.ID, privateKey, nil)
+	ack, err := relayHandshake(ctx, conn, info)
+	if err != nil {
+		return nil, 0, err
+	}
+	if a := ack.GetObservedAddress(); a != "" {
+		info.ObservedAddress = a
+	}
+
+	// Relays that signal Ready let the Noise handshake start once the remote
+	// peer is there, rather than block on it.
+	if relay_protocol.HasFeature(ack.GetHello(), relay_protocol.FeatureBridgeReady) {
+		if err := waitReady(ctx, conn, info); err != nil {
+			return nil, 0, err
+		}
+		return conn, noiseReadyTimeout, nil
+	}
+	return conn, noiseHandshakeTimeout, nil
+}
+
+// relayHandshake sends the handshake for this data conn and reads the relay's
+// ack.
+func relayHandshake(ctx context.Context, conn net.Conn, info *StreamInfo) (*relaypb.HandshakeAck, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanRelayHandshake)
+	ack, err := exchangeHandshake(ctx, conn, info)
+	tracing.End(span, err)
+	return ack, err
+}
+
+func exchangeHandshake(ctx context.Context, conn net.Conn, info *StreamInfo) (*relaypb.HandshakeAck, error) {
+	ctx, cancel := context.WithTimeout(ctx, relayHandshakeTimeout)
+	defer cancel()
+	// Closing conn unblocks the write or read in progress when ctx is done.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+	deadline, _ := ctx.Deadline()
+
+	_ = conn.SetWriteDeadline(deadline)
+	err := sendHandshake(ctx, conn, info.StreamID, info.Token, info.LocalPeerID, info.RelayMACs)
+	_ = conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return nil, &DialError{Phase: DialPhaseHandshake, Err: contextError(ctx, err)}
+	}
+	ack, err := readHandshakeAck(conn, info.Token, time.Until(deadline))
+	if err != nil {
+		return nil, &DialError{Phase: DialPhaseAck, Err: contextError(ctx, err)}
+	}
+	return ack, nil
+}
+
+// waitReady waits for the relay-server to signal that the remote peer connected
+// too.
+func waitReady(ctx context.Context, conn net.Conn, info *StreamInfo) error {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanRelayReady)
+	ctx, cancel := context.WithTimeout(ctx, bridgeReadyTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+	deadline, _ := ctx.Deadline()
+
+	err := readReady(conn, info.Token, time.Until(deadline))
+	if err != nil {
+		err = &DialError{Phase: DialPhaseReady, Err: contextError(ctx, err)}
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// contextError returns the error of ctx instead of err if ctx is done, since
+// err then results from closing the conn.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// noiseUpgrade secures conn end-to-end with the remote peer.
+func noiseUpgrade(ctx context.Context, conn net.Conn, privateKey crypto.PrivKey, info *StreamInfo, timeout time.Duration) (sec.SecureConn, error) {
+	ctx, span := tracing.Tracer().Start(ctx, tracing.SpanNoiseUpgrade)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	sconn, err := secureConn(ctx, conn, privateKey, info)
+	tracing.End(span, err)
+	return sconn, err
+}
+
+func secureConn(ctx context.Context, conn net.Conn, privateKey crypto.PrivKey, info *StreamInfo) (sec.SecureConn, error) {
+	noiseTpt, err := noise.New(noise.ID, privateKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	var opts []noise.SessionOption
+	if info.BindSession {
+		opts = append(opts, noise.Prologue(sessionPrologue(info)))
+	}
+	tpt, err := noiseTpt.WithSessionOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	var sconn sec.SecureConn
 	if info.IsServer {
-		sconn, err = tpt.SecureInbound(ctx, conn, info.RemotePeerID)
-	} else {
-		sconn, err = tpt.SecureOutbound(ctx, conn, info.RemotePeerID)
+		return tpt.SecureInbound(ctx, conn, info.RemotePeerID)
 	}
-	success = err == nil
-	return sconn, err
+	return tpt.SecureOutbound(ctx, conn, info.RemotePeerID)
+}
+
+// sessionPrologue returns the Noise prologue binding a handshake to the
+// allocation of info. A relay-server could otherwise splice together two
+// streams it relays between the same peers, even without knowing their tokens.
+func sessionPrologue(info *StreamInfo) []byte {
+	mac := sha256.New()
+	mac.Write([]byte("flymesh relay session\x00"))
+	mac.Write(binary.LittleEndian.AppendUint64(nil, info.StreamID))
+	mac.Write(info.Token)
+	return mac.Sum(nil)
 }
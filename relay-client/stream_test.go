@@ -0,0 +1,124 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestSessionPrologue(t *testing.T) {
+	base := &StreamInfo{StreamID: 7, Token: []byte("token of stream 7")}
+	same := &StreamInfo{StreamID: 7, Token: []byte("token of stream 7"), IsServer: true, RelayEndpoint: "elsewhere:1"}
+	if !bytes.Equal(sessionPrologue(base), sessionPrologue(same)) {
+		t.Fatal("the peers of one allocation disagree on the prologue")
+	}
+	for name, other := range map[string]*StreamInfo{
+		"other stream ID": {StreamID: 8, Token: base.Token},
+		"other token":     {StreamID: 7, Token: []byte("token of stream 8")},
+		"no token":        {StreamID: 7},
+		// The ID is fixed size, so bytes cannot move between ID and token.
+		"shifted bytes": {StreamID: 7 << 8, Token: append([]byte{0}, base.Token...)},
+	} {
+		if bytes.Equal(sessionPrologue(base), sessionPrologue(other)) {
+			t.Errorf("%s: same prologue", name)
+		}
+	}
+}
+
+func TestSecureConnBinding(t *testing.T) {
+	serverKey, serverID := newTestIdentity(t)
+	clientKey, clientID := newTestIdentity(t)
+	tokenA, tokenB := []byte("allocation A"), []byte("allocation B")
+	tests := []struct {
+		name         string
+		server       StreamInfo
+		client       StreamInfo
+		wantSecuring bool
+	}{
+		{
+			name:         "bound to the same allocation",
+			server:       StreamInfo{StreamID: 1, Token: tokenA, BindSession: true},
+			client:       StreamInfo{StreamID: 1, Token: tokenA, BindSession: true},
+			wantSecuring: true,
+		},
+		{
+			name:         "unbound",
+			server:       StreamInfo{StreamID: 1, Token: tokenA},
+			client:       StreamInfo{StreamID: 2, Token: tokenB},
+			wantSecuring: true,
+		},
+		{
+			name:   "spliced allocations",
+			server: StreamInfo{StreamID: 1, Token: tokenA, BindSession: true},
+			client: StreamInfo{StreamID: 2, Token: tokenB, BindSession: true},
+		},
+		{
+			name:   "same ID, other token",
+			server: StreamInfo{StreamID: 1, Token: tokenA, BindSession: true},
+			client: StreamInfo{StreamID: 1, Token: tokenB, BindSession: true},
+		},
+		{
+			name:   "bound on one side only",
+			server: StreamInfo{StreamID: 1, Token: tokenA, BindSession: true},
+			client: StreamInfo{StreamID: 1, Token: tokenA},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+
+			server, client := tt.server, tt.client
+			server.IsServer, server.RemotePeerID = true, clientID
+			client.RemotePeerID = serverID
+			errc := make(chan error, 1)
+			go func() {
+				sc, err := secureConn(ctx, serverConn, serverKey, &server)
+				if err == nil {
+					sc.Close()
+				} else {
+					// Unblock the client, which may wait for a message.
+					serverConn.Close()
+				}
+				errc <- err
+			}()
+			sc, clientErr := secureConn(ctx, clientConn, clientKey, &client)
+			if clientErr == nil {
+				sc.Close()
+			} else {
+				clientConn.Close()
+			}
+			serverErr := <-errc
+			if tt.wantSecuring && (clientErr != nil || serverErr != nil) {
+				t.Fatalf("handshake failed: client %v, server %v", clientErr, serverErr)
+			}
+			if !tt.wantSecuring && clientErr == nil && serverErr == nil {
+				t.Fatal("handshake succeeded")
+			}
+		})
+	}
+}
+
+func newTestIdentity(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
@@ -0,0 +1,293 @@
+// Copyright 2025 JC-Lab
+// SPDX-License-Identifier: AGPL-3.0-or-later OR LicenseRef-FEL
+
+package relay_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/flymesh/core/pkg/logging"
+	"github.com/flymesh/core/pkg/protocol"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// TransportALPN is the Destination.ALPN of the relay streams that carry the
+// libp2p connections of a Transport.
+const TransportALPN = "libp2p"
+
+// TransportCode is the multiaddr protocol code of TransportAddr, in the
+// private range of the multicodec table.
+const TransportCode = 0x3f6d72
+
+// TransportAddr is the address hosts listening with a Transport announce, and
+// peers dial them on.
+var TransportAddr ma.Multiaddr
+
+func init() {
+	err := ma.AddProtocol(ma.Protocol{
+		Name:  "flymesh-relay",
+		Code:  TransportCode,
+		VCode: ma.CodeToVarint(TransportCode),
+	})
+	if err != nil {
+		panic(err)
+	}
+	TransportAddr = ma.StringCast("/flymesh-relay")
+}
+
+// Transport is a libp2p transport carrying connections over relay streams. A
+// host dialing the TransportAddr of a peer, as announced through identify,
+// gets a connection through the relay-server of the ServerRole of the peer on
+// which every protocol runs as on a direct one.
+//
+// The peer is signalled over the connection at hand, e.g. a limited circuit
+// relay one, which the Transport thus upgrades rather than replaces. libp2p
+// secures and multiplexes the relay stream itself: the session binding and
+// compression options of the roles do not apply to it.
+type Transport struct {
+	host     host.Host
+	client   *ClientRole
+	upgrader transport.Upgrader
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	listener *transportListener
+}
+
+var (
+	_ transport.Transport = (*Transport)(nil)
+	_ manet.Listener      = (*transportListener)(nil)
+)
+
+// AddTransport adds a Transport dialing with client, for its Obfuscator,
+// RelayTLSConfig and IPv6Only, to the network of h. If server is not nil, h
+// also listens on TransportAddr for the streams server accepts.
+func AddTransport(h host.Host, client *ClientRole, server *ServerRole) (*Transport, error) {
+	n, ok := h.Network().(transport.TransportNetwork)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a transport network", h.Network())
+	}
+	security, err := noise.New(noise.ID, h.Peerstore().PrivKey(h.ID()), nil)
+	if err != nil {
+		return nil, err
+	}
+	muxers := []tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}
+	upgrader, err := tptu.New([]sec.SecureTransport{security}, muxers, nil, h.Network().ResourceManager(), nil)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transport{host: h, client: client, upgrader: upgrader}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	if err := n.AddTransport(t); err != nil {
+		return nil, err
+	}
+	if server != nil {
+		server.transport = t
+		if err := n.Listen(TransportAddr); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *Transport) logger() *slog.Logger {
+	return logging.Component(t.client.Logger, "transport")
+}
+
+func (t *Transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	scope, err := t.host.Network().ResourceManager().OpenConnection(network.DirOutbound, false, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := t.dial(ctx, raddr, p, scope)
+	if err != nil {
+		scope.Done()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *Transport) dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID, scope network.ConnManagementScope) (transport.CapableConn, error) {
+	if err := scope.SetPeer(p); err != nil {
+		return nil, err
+	}
+	info, err := t.requestStream(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := connectRelay(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	c, err := t.upgrader.Upgrade(ctx, t, &transportConn{Conn: conn, remote: raddr}, network.DirOutbound, p, scope)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// requestStream asks p for a relay stream carrying a libp2p connection.
+func (t *Transport) requestStream(ctx context.Context, p peer.ID) (*StreamInfo, error) {
+	// Dialing p would come back to this transport.
+	ctx = network.WithNoDial(ctx, "flymesh-relay signalling")
+	s, err := t.host.NewStream(network.WithAllowLimitedConn(ctx, "flymesh-relay signalling"), p, protocol.ProtoServerStartRelay)
+	if err != nil {
+		return nil, fmt.Errorf("open signalling stream: %w", err)
+	}
+	defer s.Close()
+	dst := Destination{ALPN: TransportALPN}
+	resp, err := exchangeStartRelay(ctx, t.host, s, dst, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamInfo{
+		RelayEndpoint: resp.GetRelayEndpoint(),
+		StreamID:      resp.GetStreamId(),
+		Token:         resp.GetToken(),
+		LocalPeerID:   t.host.ID(),
+		RemotePeerID:  p,
+		Destination:   dst,
+		RetryCookie:   resp.GetRetryCookie(),
+		Obfuscator:    t.client.Obfuscator,
+		TLSConfig:     t.client.RelayTLSConfig,
+		RelayMACs:     t.client.RelayMACs,
+		IPv6Only:      t.client.IPv6Only,
+
+		RelayEndpoints: resp.GetRelayEndpoints(),
+	}, nil
+}
+
+func (t *Transport) CanDial(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(TransportCode)
+	return err == nil
+}
+
+func (t *Transport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	if !laddr.Equal(TransportAddr) {
+		return nil, fmt.Errorf("cannot listen on %s", laddr)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listener != nil {
+		return nil, errors.New("already listening")
+	}
+	t.listener = &transportListener{
+		t:     t,
+		conns: make(chan manet.Conn),
+		done:  make(chan struct{}),
+	}
+	return t.upgrader.UpgradeGatedMaListener(t, t.upgrader.GateMaListener(t.listener)), nil
+}
+
+func (t *Transport) Protocols() []int {
+	return []int{TransportCode}
+}
+
+// Proxy reports false although the connections go through a relay-server:
+// they are full connections, not limited ones, and dials forced direct, as
+// when libp2p upgrades a limited connection, must be able to use them.
+func (t *Transport) Proxy() bool {
+	return false
+}
+
+// Close aborts the relay streams being set up.
+func (t *Transport) Close() error {
+	t.cancel()
+	return nil
+}
+
+// accept connects to the allocation of info, which the ServerRole made for a
+// client dialing the Transport, and hands the connection to the listener.
+func (t *Transport) accept(info *StreamInfo) {
+	logger := t.logger().With(logging.KeyClientPeer, info.RemotePeerID.String(), logging.KeyStreamID, info.StreamID)
+	conn, _, err := connectRelay(t.ctx, info)
+	if err != nil {
+		logger.Warn("dial relay failed", "err", err)
+		return
+	}
+	t.mu.Lock()
+	l := t.listener
+	t.mu.Unlock()
+	if l == nil || !l.queue(&transportConn{Conn: conn, remote: TransportAddr}) {
+		logger.Warn("transport not listening, closing relay stream")
+		_ = conn.Close()
+	}
+}
+
+// transportConn is a relay stream carrying a libp2p connection.
+type transportConn struct {
+	net.Conn
+	remote ma.Multiaddr
+}
+
+func (c *transportConn) LocalMultiaddr() ma.Multiaddr {
+	return TransportAddr
+}
+
+func (c *transportConn) RemoteMultiaddr() ma.Multiaddr {
+	return c.remote
+}
+
+// transportListener hands the relay streams of a Transport to the upgrader.
+type transportListener struct {
+	t         *Transport
+	conns     chan manet.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// queue waits for c to be accepted. It returns false if the listener closed
+// first.
+func (l *transportListener) queue(c manet.Conn) bool {
+	select {
+	case l.conns <- c:
+		return true
+	case <-l.done:
+		return false
+	case <-l.t.ctx.Done():
+		return false
+	}
+}
+
+func (l *transportListener) Accept() (manet.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *transportListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.t.mu.Lock()
+		l.t.listener = nil
+		l.t.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *transportListener) Addr() net.Addr {
+	return PeerAddr{ID: l.t.host.ID()}
+}
+
+func (l *transportListener) Multiaddr() ma.Multiaddr {
+	return TransportAddr
+}